@@ -0,0 +1,171 @@
+// Copyright 2016-2022, Pulumi Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package allocation adds, modifies and removes the resource settings of virtual machines
+// with the AddResourceSettings, ModifyResourceSettings and RemoveResourceSettings methods of
+// Msvm_VirtualSystemManagementService. The WMI calls are behind the Session interface, so
+// the lookup of default settings and the tracking of jobs can be tested against a fake.
+package allocation
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/pulumi/pulumi-hyperv/provider/pkg/provider/job"
+)
+
+// Values of Msvm_SettingsDefineCapabilities that mark the default settings of a pool.
+const (
+	ValueRoleDefault = 0
+	ValueRangePoint  = 0
+)
+
+// Settings is a resource allocation setting data instance and the properties to change on
+// it before it is passed to the service.
+type Settings struct {
+	// Path is the instance path the settings are based on: the default settings of a pool
+	// for a new resource, or the settings of the resource to modify.
+	Path string
+	// Properties are the values to set on the instance.
+	Properties map[string]interface{}
+}
+
+// Set sets a property and returns the settings, so calls can be chained.
+func (s *Settings) Set(name string, value interface{}) *Settings {
+	if s.Properties == nil {
+		s.Properties = map[string]interface{}{}
+	}
+	s.Properties[name] = value
+	return s
+}
+
+// CapabilitySetting is a Msvm_SettingsDefineCapabilities association of an
+// Msvm_AllocationCapabilities instance.
+type CapabilitySetting struct {
+	// Path is the instance path of the settings, the PartComponent of the association.
+	Path       string
+	ValueRole  uint16
+	ValueRange uint16
+}
+
+// Result is the outcome of a Msvm_VirtualSystemManagementService method.
+type Result struct {
+	ReturnValue uint32
+	// Job is the instance path of the Msvm_ConcreteJob when ReturnValue is job.ReturnJobStarted.
+	Job string
+	// Resulting are the instance paths of the resulting resource settings.
+	Resulting []string
+}
+
+// Session is the part of WMI that allocating resources needs.
+type Session interface {
+	// PrimordialPool returns the instance path of the primordial resource pool of the given
+	// ResourceType and ResourceSubType.
+	PrimordialPool(resourceType uint16, subType string) (string, error)
+	// AllocationCapabilities returns the instance path of the Msvm_AllocationCapabilities of
+	// a pool.
+	AllocationCapabilities(pool string) (string, error)
+	// CapabilitySettings returns the settings that the capabilities define.
+	CapabilitySettings(capabilities string) ([]CapabilitySetting, error)
+	// AddResourceSettings adds resources to the virtual system with the given settings.
+	AddResourceSettings(systemSettings string, settings []*Settings) (Result, error)
+	// ModifyResourceSettings changes the settings of existing resources.
+	ModifyResourceSettings(settings []*Settings) (Result, error)
+	// RemoveResourceSettings removes the resources with the given settings.
+	RemoveResourceSettings(paths []string) (Result, error)
+	job.Source
+}
+
+// Options control how long Add, Modify and Remove wait for the jobs they start.
+type Options = job.Options
+
+// DefaultSettings returns new settings based on the default settings of the primordial pool
+// of the given ResourceType and ResourceSubType. They are found through the
+// Msvm_AllocationCapabilities of the pool, like Hyper-V Manager does.
+func DefaultSettings(s Session, resourceType uint16, subType string) (*Settings, error) {
+	pool, err := s.PrimordialPool(resourceType, subType)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find the resource pool for %s: %w", subType, err)
+	}
+	capabilities, err := s.AllocationCapabilities(pool)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get the allocation capabilities for %s: %w", subType, err)
+	}
+	settings, err := s.CapabilitySettings(capabilities)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get the settings for %s: %w", subType, err)
+	}
+	for _, setting := range settings {
+		if setting.ValueRole == ValueRoleDefault && setting.ValueRange == ValueRangePoint {
+			return &Settings{Path: setting.Path}, nil
+		}
+	}
+	return nil, fmt.Errorf("no default settings for %s", subType)
+}
+
+// Add adds resources with the given settings to the virtual system whose
+// Msvm_VirtualSystemSettingData is at systemSettings, waits for the job and returns the
+// instance paths of the resulting settings.
+func Add(ctx context.Context, s Session, systemSettings string, settings []*Settings, opts Options) ([]string, error) {
+	if len(settings) == 0 {
+		return nil, nil
+	}
+	result, err := s.AddResourceSettings(systemSettings, settings)
+	if err != nil {
+		return nil, fmt.Errorf("AddResourceSettings failed: %w", err)
+	}
+	if err := Wait(ctx, s, "AddResourceSettings", result, opts); err != nil {
+		return nil, err
+	}
+	return result.Resulting, nil
+}
+
+// Modify changes the settings of existing resources, waits for the job and returns the
+// instance paths of the resulting settings.
+func Modify(ctx context.Context, s Session, settings []*Settings, opts Options) ([]string, error) {
+	if len(settings) == 0 {
+		return nil, nil
+	}
+	for _, setting := range settings {
+		if setting.Path == "" {
+			return nil, fmt.Errorf("cannot modify settings without an instance path")
+		}
+	}
+	result, err := s.ModifyResourceSettings(settings)
+	if err != nil {
+		return nil, fmt.Errorf("ModifyResourceSettings failed: %w", err)
+	}
+	if err := Wait(ctx, s, "ModifyResourceSettings", result, opts); err != nil {
+		return nil, err
+	}
+	return result.Resulting, nil
+}
+
+// Remove removes the resources whose settings are at paths and waits for the job.
+func Remove(ctx context.Context, s Session, paths []string, opts Options) error {
+	if len(paths) == 0 {
+		return nil
+	}
+	result, err := s.RemoveResourceSettings(paths)
+	if err != nil {
+		return fmt.Errorf("RemoveResourceSettings failed: %w", err)
+	}
+	return Wait(ctx, s, "RemoveResourceSettings", result, opts)
+}
+
+// Wait returns once the method that returned result is done. A method that started a job
+// is done when the job is, and it failed if the job did not complete.
+func Wait(ctx context.Context, s Session, method string, result Result, opts Options) error {
+	return job.Wait(ctx, s, method, result.ReturnValue, result.Job, opts)
+}
@@ -0,0 +1,188 @@
+// Copyright 2016-2022, Pulumi Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package allocation
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pulumi/pulumi-hyperv/provider/pkg/provider/errs"
+	"github.com/pulumi/pulumi-hyperv/provider/pkg/provider/job"
+)
+
+const (
+	scsiPool      = `Msvm_ResourcePool.InstanceID="Microsoft:Definition\SCSI"`
+	scsiCaps      = `Msvm_AllocationCapabilities.InstanceID="Microsoft:SCSI"`
+	scsiDefault   = `Msvm_ResourceAllocationSettingData.InstanceID="Microsoft:Definition\Default"`
+	scsiMinimum   = `Msvm_ResourceAllocationSettingData.InstanceID="Microsoft:Definition\Minimum"`
+	vmSettings    = `Msvm_VirtualSystemSettingData.InstanceID="Microsoft:VM"`
+	jobPath       = `Msvm_ConcreteJob.InstanceID="job"`
+	resultingPath = `Msvm_ResourceAllocationSettingData.InstanceID="Microsoft:VM\SCSI1"`
+)
+
+// fakeSession has one pool and runs every method as a job whose states are in jobs.
+type fakeSession struct {
+	returnValue uint32
+	jobs        []job.State
+	polls       int
+	added       []*Settings
+	modified    []*Settings
+	removed     []string
+}
+
+func (f *fakeSession) PrimordialPool(resourceType uint16, subType string) (string, error) {
+	if resourceType == 6 && subType == "Microsoft:Hyper-V:Synthetic SCSI Controller" {
+		return scsiPool, nil
+	}
+	return "", fmt.Errorf("no pool for %d %s", resourceType, subType)
+}
+
+func (f *fakeSession) AllocationCapabilities(pool string) (string, error) {
+	if pool == scsiPool {
+		return scsiCaps, nil
+	}
+	return "", fmt.Errorf("no capabilities for %s", pool)
+}
+
+func (f *fakeSession) CapabilitySettings(capabilities string) ([]CapabilitySetting, error) {
+	return []CapabilitySetting{
+		{Path: scsiMinimum, ValueRole: 3, ValueRange: 1},
+		{Path: scsiDefault, ValueRole: ValueRoleDefault, ValueRange: ValueRangePoint},
+	}, nil
+}
+
+func (f *fakeSession) result() Result {
+	if f.returnValue != job.ReturnJobStarted {
+		return Result{ReturnValue: f.returnValue, Resulting: []string{resultingPath}}
+	}
+	return Result{ReturnValue: job.ReturnJobStarted, Job: jobPath, Resulting: []string{resultingPath}}
+}
+
+func (f *fakeSession) AddResourceSettings(systemSettings string, settings []*Settings) (Result, error) {
+	if systemSettings != vmSettings {
+		return Result{}, fmt.Errorf("unknown system %s", systemSettings)
+	}
+	f.added = append(f.added, settings...)
+	return f.result(), nil
+}
+
+func (f *fakeSession) ModifyResourceSettings(settings []*Settings) (Result, error) {
+	f.modified = append(f.modified, settings...)
+	return f.result(), nil
+}
+
+func (f *fakeSession) RemoveResourceSettings(paths []string) (Result, error) {
+	f.removed = append(f.removed, paths...)
+	return f.result(), nil
+}
+
+func (f *fakeSession) Job(path string) (job.State, error) {
+	if path != jobPath {
+		return job.State{}, fmt.Errorf("unknown job %s", path)
+	}
+	state := f.jobs[f.polls]
+	if f.polls < len(f.jobs)-1 {
+		f.polls++
+	}
+	return state, nil
+}
+
+var fast = Options{PollInterval: time.Millisecond}
+
+func TestDefaultSettings(t *testing.T) {
+	s := &fakeSession{}
+	settings, err := DefaultSettings(s, 6, "Microsoft:Hyper-V:Synthetic SCSI Controller")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if settings.Path != scsiDefault {
+		t.Errorf("DefaultSettings returned %s, want %s", settings.Path, scsiDefault)
+	}
+
+	if _, err := DefaultSettings(s, 17, "Microsoft:Hyper-V:Synthetic Disk Drive"); err == nil || !strings.Contains(err.Error(), "resource pool") {
+		t.Errorf("DefaultSettings of an unknown pool = %v", err)
+	}
+}
+
+func TestAdd(t *testing.T) {
+	s := &fakeSession{
+		returnValue: job.ReturnJobStarted,
+		jobs:        []job.State{{JobState: job.StateRunning, PercentComplete: 50}, {JobState: job.StateCompleted, PercentComplete: 100}},
+	}
+	settings := (&Settings{Path: scsiDefault}).Set("ElementName", "SCSI Controller")
+	resulting, err := Add(context.Background(), s, vmSettings, []*Settings{settings}, fast)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(resulting, []string{resultingPath}) {
+		t.Errorf("Add returned %v", resulting)
+	}
+	if len(s.added) != 1 || s.added[0].Properties["ElementName"] != "SCSI Controller" {
+		t.Errorf("added %+v", s.added)
+	}
+	if s.polls != 1 {
+		t.Errorf("polled the job %d times, want it polled until it completed", s.polls+1)
+	}
+}
+
+func TestModifyAndRemove(t *testing.T) {
+	s := &fakeSession{returnValue: job.ReturnCompleted}
+	if _, err := Modify(context.Background(), s, []*Settings{(&Settings{Path: resultingPath}).Set("Limit", uint64(10))}, fast); err != nil {
+		t.Fatal(err)
+	}
+	if len(s.modified) != 1 {
+		t.Errorf("modified %+v", s.modified)
+	}
+	if _, err := Modify(context.Background(), s, []*Settings{{}}, fast); err == nil {
+		t.Error("Modify of settings without a path succeeded")
+	}
+	if err := Remove(context.Background(), s, []string{resultingPath}, fast); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(s.removed, []string{resultingPath}) {
+		t.Errorf("removed %v", s.removed)
+	}
+}
+
+func TestWaitErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		session *fakeSession
+		opts    Options
+		want    string
+		is      error
+	}{
+		{"return value", &fakeSession{returnValue: 32775}, fast, "return value 32775", errs.ErrInvalidState},
+		{"job failed", &fakeSession{returnValue: job.ReturnJobStarted, jobs: []job.State{{JobState: job.StateException, ErrorCode: 32775, ErrorDescription: "The device is in use. "}}}, fast, "failed with error code 32775: The device is in use.", errs.ErrInvalidState},
+		{"job killed", &fakeSession{returnValue: job.ReturnJobStarted, jobs: []job.State{{JobState: job.StateKilled}}}, fast, "was killed", errs.ErrTerminated},
+		{"timeout", &fakeSession{returnValue: job.ReturnJobStarted, jobs: []job.State{{JobState: job.StateRunning, PercentComplete: 30}}}, Options{Timeout: 5 * time.Millisecond, PollInterval: time.Millisecond}, "30% done", context.DeadlineExceeded},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Remove(context.Background(), tt.session, []string{resultingPath}, tt.opts)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("Remove error = %v, want it to contain %q", err, tt.want)
+			}
+			if !errors.Is(err, tt.is) {
+				t.Errorf("Remove error = %v, want it to be %v", err, tt.is)
+			}
+		})
+	}
+}
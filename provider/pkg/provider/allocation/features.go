@@ -0,0 +1,142 @@
+// Copyright 2016-2022, Pulumi Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package allocation
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// Feature is a switch port feature, such as VLAN or security settings, identified by the
+// FeatureId of its Msvm_EthernetSwitchFeatureCapabilities and the class of its settings.
+type Feature struct {
+	ID    string
+	Class string
+}
+
+// PortFeature is a feature setting that is applied to a switch port.
+type PortFeature struct {
+	Path  string
+	Class string
+}
+
+// FeatureSession is the part of WMI that switch port features need.
+type FeatureSession interface {
+	Session
+	// FeatureCapabilities returns the instance path of the
+	// Msvm_EthernetSwitchFeatureCapabilities with the given FeatureId.
+	FeatureCapabilities(featureID string) (string, error)
+	// PortFeatures returns the feature settings of the Msvm_EthernetPortAllocationSettingData
+	// at portSettings.
+	PortFeatures(portSettings string) ([]PortFeature, error)
+	// AddFeatureSettings adds feature settings to a switch port.
+	AddFeatureSettings(portSettings string, settings []*Settings) (Result, error)
+	// ModifyFeatureSettings changes existing feature settings.
+	ModifyFeatureSettings(settings []*Settings) (Result, error)
+	// RemoveFeatureSettings removes the feature settings at paths.
+	RemoveFeatureSettings(paths []string) (Result, error)
+}
+
+// DefaultFeatureSettings returns new settings for a feature, based on the default settings
+// its Msvm_EthernetSwitchFeatureCapabilities define.
+func DefaultFeatureSettings(s FeatureSession, feature Feature) (*Settings, error) {
+	capabilities, err := s.FeatureCapabilities(feature.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find the capabilities of %s: %w", feature.Class, err)
+	}
+	settings, err := s.CapabilitySettings(capabilities)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get the settings of %s: %w", feature.Class, err)
+	}
+	for _, setting := range settings {
+		if setting.ValueRole == ValueRoleDefault && setting.ValueRange == ValueRangePoint {
+			return &Settings{Path: setting.Path}, nil
+		}
+	}
+	return nil, fmt.Errorf("no default settings for %s", feature.Class)
+}
+
+// FindPortFeature returns the setting of feature on the switch port, or nil if the port
+// does not have it.
+func FindPortFeature(s FeatureSession, portSettings string, feature Feature) (*PortFeature, error) {
+	features, err := s.PortFeatures(portSettings)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get the features of the switch port: %w", err)
+	}
+	for i := range features {
+		if strings.EqualFold(features[i].Class, feature.Class) {
+			return &features[i], nil
+		}
+	}
+	return nil, nil
+}
+
+// SetPortFeature applies properties to feature on the switch port. A feature the port
+// already has is modified, otherwise it is added from its default settings. It returns the
+// instance path of the feature settings.
+func SetPortFeature(ctx context.Context, s FeatureSession, portSettings string, feature Feature, properties map[string]interface{}, opts Options) (string, error) {
+	existing, err := FindPortFeature(s, portSettings, feature)
+	if err != nil {
+		return "", err
+	}
+
+	if existing != nil {
+		settings := &Settings{Path: existing.Path, Properties: properties}
+		result, err := s.ModifyFeatureSettings([]*Settings{settings})
+		if err != nil {
+			return "", fmt.Errorf("ModifyFeatureSettings failed: %w", err)
+		}
+		if err := Wait(ctx, s, "ModifyFeatureSettings", result, opts); err != nil {
+			return "", err
+		}
+		return firstOr(result.Resulting, existing.Path), nil
+	}
+
+	settings, err := DefaultFeatureSettings(s, feature)
+	if err != nil {
+		return "", err
+	}
+	settings.Properties = properties
+	result, err := s.AddFeatureSettings(portSettings, []*Settings{settings})
+	if err != nil {
+		return "", fmt.Errorf("AddFeatureSettings failed: %w", err)
+	}
+	if err := Wait(ctx, s, "AddFeatureSettings", result, opts); err != nil {
+		return "", err
+	}
+	return firstOr(result.Resulting, ""), nil
+}
+
+// RemovePortFeature removes feature from the switch port. A port without the feature is
+// left as it is.
+func RemovePortFeature(ctx context.Context, s FeatureSession, portSettings string, feature Feature, opts Options) error {
+	existing, err := FindPortFeature(s, portSettings, feature)
+	if err != nil || existing == nil {
+		return err
+	}
+	result, err := s.RemoveFeatureSettings([]string{existing.Path})
+	if err != nil {
+		return fmt.Errorf("RemoveFeatureSettings failed: %w", err)
+	}
+	return Wait(ctx, s, "RemoveFeatureSettings", result, opts)
+}
+
+func firstOr(paths []string, fallback string) string {
+	if len(paths) > 0 {
+		return paths[0]
+	}
+	return fallback
+}
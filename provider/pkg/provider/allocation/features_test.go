@@ -0,0 +1,117 @@
+// Copyright 2016-2022, Pulumi Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package allocation
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/pulumi/pulumi-hyperv/provider/pkg/provider/job"
+)
+
+const (
+	portSettings = `Msvm_EthernetPortAllocationSettingData.InstanceID="Microsoft:VM\Port"`
+	vlanCaps     = `Msvm_EthernetSwitchFeatureCapabilities.InstanceID="Microsoft:Vlan"`
+	vlanExisting = `Msvm_EthernetSwitchPortVlanSettingData.InstanceID="Microsoft:VM\Port\Vlan"`
+)
+
+// fakeFeatureSession is a switch port that may have a VLAN feature. Only the VLAN feature
+// has capabilities.
+type fakeFeatureSession struct {
+	*fakeSession
+	features []PortFeature
+	addedTo  string
+}
+
+func (f *fakeFeatureSession) FeatureCapabilities(featureID string) (string, error) {
+	if featureID == FeatureVlan.ID {
+		return vlanCaps, nil
+	}
+	return "", fmt.Errorf("no capabilities for feature %s", featureID)
+}
+
+func (f *fakeFeatureSession) PortFeatures(port string) ([]PortFeature, error) {
+	return f.features, nil
+}
+
+func (f *fakeFeatureSession) AddFeatureSettings(port string, settings []*Settings) (Result, error) {
+	f.addedTo = port
+	return f.AddResourceSettings(vmSettings, settings)
+}
+
+func (f *fakeFeatureSession) ModifyFeatureSettings(settings []*Settings) (Result, error) {
+	return f.ModifyResourceSettings(settings)
+}
+
+func (f *fakeFeatureSession) RemoveFeatureSettings(paths []string) (Result, error) {
+	return f.RemoveResourceSettings(paths)
+}
+
+func TestSetPortFeatureAdds(t *testing.T) {
+	s := &fakeFeatureSession{fakeSession: &fakeSession{returnValue: job.ReturnCompleted}}
+	properties := map[string]interface{}{"OperationMode": uint32(1), "AccessVlanId": uint16(42)}
+	if _, err := SetPortFeature(context.Background(), s, portSettings, FeatureVlan, properties, fast); err != nil {
+		t.Fatal(err)
+	}
+	if s.addedTo != portSettings {
+		t.Errorf("added the feature to %s", s.addedTo)
+	}
+	if len(s.added) != 1 || s.added[0].Path != scsiDefault || !reflect.DeepEqual(s.added[0].Properties, properties) {
+		t.Errorf("added %+v, want the default settings with %v", s.added, properties)
+	}
+	if len(s.modified) != 0 {
+		t.Errorf("modified %+v", s.modified)
+	}
+}
+
+func TestSetPortFeatureModifies(t *testing.T) {
+	s := &fakeFeatureSession{
+		fakeSession: &fakeSession{returnValue: job.ReturnCompleted},
+		features:    []PortFeature{{Path: vlanExisting, Class: "msvm_ethernetswitchportvlansettingdata"}},
+	}
+	properties := map[string]interface{}{"AccessVlanId": uint16(7)}
+	if _, err := SetPortFeature(context.Background(), s, portSettings, FeatureVlan, properties, fast); err != nil {
+		t.Fatal(err)
+	}
+	if len(s.modified) != 1 || s.modified[0].Path != vlanExisting {
+		t.Errorf("modified %+v, want %s", s.modified, vlanExisting)
+	}
+	if len(s.added) != 0 {
+		t.Errorf("added %+v", s.added)
+	}
+}
+
+func TestSetPortFeatureWithoutCapabilities(t *testing.T) {
+	s := &fakeFeatureSession{fakeSession: &fakeSession{returnValue: job.ReturnCompleted}}
+	if _, err := SetPortFeature(context.Background(), s, portSettings, FeatureSecurity, map[string]interface{}{"EnableDhcpGuard": true}, fast); err == nil {
+		t.Error("SetPortFeature succeeded without feature capabilities")
+	}
+}
+
+func TestRemovePortFeature(t *testing.T) {
+	s := &fakeFeatureSession{fakeSession: &fakeSession{returnValue: job.ReturnCompleted}}
+	if err := RemovePortFeature(context.Background(), s, portSettings, FeatureVlan, fast); err != nil || len(s.removed) != 0 {
+		t.Errorf("RemovePortFeature of a missing feature = %v, removed %v", err, s.removed)
+	}
+	s.features = []PortFeature{{Path: vlanExisting, Class: FeatureVlan.Class}}
+	if err := RemovePortFeature(context.Background(), s, portSettings, FeatureVlan, fast); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(s.removed, []string{vlanExisting}) {
+		t.Errorf("removed %v, want [%s]", s.removed, vlanExisting)
+	}
+}
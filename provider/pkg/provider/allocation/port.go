@@ -0,0 +1,136 @@
+// Copyright 2016-2022, Pulumi Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package allocation
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/pulumi/pulumi-hyperv/provider/pkg/provider/backend"
+)
+
+// The switch port features the provider configures.
+var (
+	FeatureBandwidth = Feature{ID: "24AD3CE1-69BD-4978-B2AC-DAAD389D699C", Class: "Msvm_EthernetSwitchPortBandwidthSettingData"}
+	FeatureOffload   = Feature{ID: "C885BFD1-ABB7-418F-8163-9F379C9F7166", Class: "Msvm_EthernetSwitchPortOffloadSettingData"}
+	FeatureSecurity  = Feature{ID: "776E0BA7-94A1-41C8-8F28-951F524251B5", Class: "Msvm_EthernetSwitchPortSecuritySettingData"}
+	FeatureVlan      = Feature{ID: "952C5004-4465-451C-8CB8-FA9AB382B773", Class: "Msvm_EthernetSwitchPortVlanSettingData"}
+)
+
+// ApplyPortSettings applies the switch port features of settings to the
+// Msvm_EthernetPortAllocationSettingData at portSettings. An untagged port has no VLAN
+// feature. The MAC address of settings belongs to the adapter and is not applied.
+func ApplyPortSettings(ctx context.Context, s FeatureSession, portSettings string, settings backend.NetworkAdapterSettings, opts Options) error {
+	if vlan := settings.Vlan; vlan != nil {
+		if vlan.Mode == backend.VlanUntagged {
+			if err := RemovePortFeature(ctx, s, portSettings, FeatureVlan, opts); err != nil {
+				return fmt.Errorf("failed to remove the VLAN: %w", err)
+			}
+		} else if _, err := SetPortFeature(ctx, s, portSettings, FeatureVlan, VlanProperties(*vlan), opts); err != nil {
+			return fmt.Errorf("failed to set the VLAN: %w", err)
+		}
+	}
+
+	security := map[string]interface{}{}
+	if settings.DHCPGuard != nil {
+		security["EnableDhcpGuard"] = *settings.DHCPGuard
+	}
+	if settings.RouterGuard != nil {
+		security["EnableRouterGuard"] = *settings.RouterGuard
+	}
+	if settings.PortMirroring != nil {
+		mode, err := MonitorMode(*settings.PortMirroring)
+		if err != nil {
+			return err
+		}
+		security["MonitorMode"] = mode
+	}
+	if settings.IeeePriorityTag != nil {
+		security["AllowIeeePriorityTags"] = *settings.IeeePriorityTag
+	}
+	if len(security) > 0 {
+		if _, err := SetPortFeature(ctx, s, portSettings, FeatureSecurity, security, opts); err != nil {
+			return fmt.Errorf("failed to set the security settings: %w", err)
+		}
+	}
+
+	if settings.VMQWeight != nil {
+		offload := map[string]interface{}{"VMQOffloadWeight": uint32(*settings.VMQWeight)}
+		if _, err := SetPortFeature(ctx, s, portSettings, FeatureOffload, offload, opts); err != nil {
+			return fmt.Errorf("failed to set the VMQ weight: %w", err)
+		}
+	}
+	return nil
+}
+
+// MonitorMode returns the MonitorMode of Msvm_EthernetSwitchPortSecuritySettingData for a
+// port mirroring mode.
+func MonitorMode(portMirroring string) (uint8, error) {
+	switch strings.ToLower(portMirroring) {
+	case "none":
+		return 0, nil
+	case "destination":
+		return 1, nil
+	case "source":
+		return 2, nil
+	}
+	return 0, fmt.Errorf("unsupported portMirroring [%s], must be None, Destination or Source", portMirroring)
+}
+
+// VlanProperties returns the properties of Msvm_EthernetSwitchPortVlanSettingData for a VLAN.
+// An untagged port has no VLAN feature.
+func VlanProperties(vlan backend.VlanSettings) map[string]interface{} {
+	switch vlan.Mode {
+	case backend.VlanAccess:
+		return map[string]interface{}{
+			"OperationMode": uint32(1),
+			"AccessVlanId":  uint16(vlan.AccessVlanID),
+		}
+	case backend.VlanTrunk:
+		return map[string]interface{}{
+			"OperationMode":    uint32(2),
+			"NativeVlanId":     uint16(vlan.NativeVlanID),
+			"TrunkVlanIdArray": vlanIDArray(vlan.AllowedVlanIDs),
+		}
+	case backend.VlanIsolated, backend.VlanCommunity:
+		privateMode := uint32(1)
+		if vlan.Mode == backend.VlanCommunity {
+			privateMode = 2
+		}
+		return map[string]interface{}{
+			"OperationMode":   uint32(3),
+			"PrivateVlanMode": privateMode,
+			"PrimaryVlanId":   uint16(vlan.PrimaryVlanID),
+			"SecondaryVlanId": uint16(vlan.SecondaryVlanID),
+		}
+	case backend.VlanPromiscuous:
+		return map[string]interface{}{
+			"OperationMode":        uint32(3),
+			"PrivateVlanMode":      uint32(3),
+			"PrimaryVlanId":        uint16(vlan.PrimaryVlanID),
+			"SecondaryVlanIdArray": vlanIDArray(vlan.SecondaryVlanIDs),
+		}
+	}
+	return nil
+}
+
+func vlanIDArray(ids []int) []uint16 {
+	array := make([]uint16, len(ids))
+	for i, id := range ids {
+		array[i] = uint16(id)
+	}
+	return array
+}
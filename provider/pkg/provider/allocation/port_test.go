@@ -0,0 +1,64 @@
+// Copyright 2016-2022, Pulumi Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package allocation
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/pulumi/pulumi-hyperv/provider/pkg/provider/backend"
+	"github.com/pulumi/pulumi-hyperv/provider/pkg/provider/job"
+)
+
+func TestApplyPortSettings(t *testing.T) {
+	s := &fakeFeatureSession{fakeSession: &fakeSession{returnValue: job.ReturnCompleted}}
+	access := backend.NetworkAdapterSettings{Vlan: &backend.VlanSettings{Mode: backend.VlanAccess, AccessVlanID: 42}}
+	if err := ApplyPortSettings(context.Background(), s, portSettings, access, fast); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]interface{}{"OperationMode": uint32(1), "AccessVlanId": uint16(42)}
+	if len(s.added) != 1 || !reflect.DeepEqual(s.added[0].Properties, want) {
+		t.Errorf("added %+v, want the VLAN %v", s.added, want)
+	}
+
+	s.features = []PortFeature{{Path: vlanExisting, Class: FeatureVlan.Class}}
+	untagged := backend.NetworkAdapterSettings{Vlan: &backend.VlanSettings{Mode: backend.VlanUntagged}}
+	if err := ApplyPortSettings(context.Background(), s, portSettings, untagged, fast); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(s.removed, []string{vlanExisting}) {
+		t.Errorf("removed %v, want the VLAN of an untagged port", s.removed)
+	}
+
+	mirroring := "Sideways"
+	if err := ApplyPortSettings(context.Background(), s, portSettings, backend.NetworkAdapterSettings{PortMirroring: &mirroring}, fast); err == nil {
+		t.Error("ApplyPortSettings accepted an unsupported port mirroring mode")
+	}
+}
+
+func TestVlanProperties(t *testing.T) {
+	isolated := VlanProperties(backend.VlanSettings{Mode: backend.VlanIsolated, PrimaryVlanID: 100, SecondaryVlanID: 200})
+	if isolated["OperationMode"] != uint32(3) || isolated["PrivateVlanMode"] != uint32(1) || isolated["SecondaryVlanId"] != uint16(200) {
+		t.Errorf("VlanProperties of an isolated port = %v", isolated)
+	}
+	trunk := VlanProperties(backend.VlanSettings{Mode: backend.VlanTrunk, AllowedVlanIDs: []int{10, 11}})
+	if !reflect.DeepEqual(trunk["TrunkVlanIdArray"], []uint16{10, 11}) {
+		t.Errorf("VlanProperties of a trunk = %v", trunk)
+	}
+	if properties := VlanProperties(backend.VlanSettings{Mode: backend.VlanUntagged}); properties != nil {
+		t.Errorf("VlanProperties of an untagged port = %v", properties)
+	}
+}
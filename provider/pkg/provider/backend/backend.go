@@ -0,0 +1,568 @@
+// Copyright 2016-2022, Pulumi Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package backend defines HypervBackend, the operations the resources perform on a Hyper-V
+// host, independent of whether they run through WMI, PowerShell or the in-memory Simulator.
+package backend
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pulumi/pulumi-hyperv/provider/pkg/provider/errs"
+	"github.com/pulumi/pulumi-hyperv/provider/pkg/provider/passthrough"
+	"github.com/pulumi/pulumi-hyperv/provider/pkg/provider/vhd"
+)
+
+// PowerState is the state of a virtual machine, spelled the way Get-VM reports it.
+type PowerState string
+
+// Power states of a virtual machine.
+const (
+	PowerStateOff     PowerState = "Off"
+	PowerStateRunning PowerState = "Running"
+	PowerStatePaused  PowerState = "Paused"
+	PowerStateSaved   PowerState = "Saved"
+)
+
+// Switch types.
+const (
+	SwitchTypeExternal = "External"
+	SwitchTypeInternal = "Internal"
+	SwitchTypePrivate  = "Private"
+)
+
+// VLAN modes of a switch port. Isolated, Community and Promiscuous are the modes of a private
+// VLAN.
+const (
+	VlanUntagged    = "Untagged"
+	VlanAccess      = "Access"
+	VlanTrunk       = "Trunk"
+	VlanIsolated    = "Isolated"
+	VlanCommunity   = "Community"
+	VlanPromiscuous = "Promiscuous"
+)
+
+// Controller types.
+const (
+	ControllerSCSI = "SCSI"
+	ControllerIDE  = "IDE"
+)
+
+// MaxSCSIControllers is the number of SCSI controllers a virtual machine can have.
+const MaxSCSIControllers = 4
+
+// Cache modes of a virtual hard disk drive. They match the values of the
+// -OverrideCacheAttributes parameter of Set-VMHardDiskDrive and are stored in the
+// WriteHardeningMethod property of Msvm_StorageAllocationSettingData.
+const (
+	CacheModeDefault                 = "Default"
+	CacheModeWriteCacheEnabled       = "WriteCacheEnabled"
+	CacheModeWriteCacheAndFUAEnabled = "WriteCacheAndFUAEnabled"
+	CacheModeWriteCacheDisabled      = "WriteCacheDisabled"
+)
+
+var cacheModes = []string{CacheModeDefault, CacheModeWriteCacheEnabled, CacheModeWriteCacheAndFUAEnabled, CacheModeWriteCacheDisabled}
+
+// VM is a virtual machine.
+type VM struct {
+	ID             string
+	Name           string
+	Generation     int
+	State          PowerState
+	MemoryMB       uint64
+	ProcessorCount int
+	// DynamicMemory reports whether Hyper-V balances the memory of the VM between
+	// MinimumMemoryMB and MaximumMemoryMB.
+	DynamicMemory   bool
+	MinimumMemoryMB uint64
+	MaximumMemoryMB uint64
+	// AutomaticStartAction is Nothing, StartIfRunning or Start, as Get-VM reports it.
+	AutomaticStartAction string
+	// AutomaticStopAction is TurnOff, Save or ShutDown, as Get-VM reports it.
+	AutomaticStopAction string
+}
+
+// VMSpec describes a virtual machine to create.
+type VMSpec struct {
+	Name string
+	// Generation is 1 or 2. Defaults to 2.
+	Generation int
+	// MemoryMB is the startup memory. Defaults to 1024.
+	MemoryMB uint64
+	// ProcessorCount defaults to 1.
+	ProcessorCount int
+}
+
+// VMSettings are changes to a virtual machine. Settings that are nil are left as they are.
+// The processor count, and the memory unless it is dynamic, can only change while the VM is
+// off.
+type VMSettings struct {
+	ProcessorCount *int
+	// MemoryMB is the startup memory.
+	MemoryMB        *uint64
+	DynamicMemory   *bool
+	MinimumMemoryMB *uint64
+	MaximumMemoryMB *uint64
+	// AutomaticStartAction is Nothing, StartIfRunning or Start.
+	AutomaticStartAction *string
+	// AutomaticStopAction is TurnOff, Save or ShutDown.
+	AutomaticStopAction *string
+}
+
+// IsEmpty reports whether no setting is to be changed.
+func (s VMSettings) IsEmpty() bool {
+	return s.ProcessorCount == nil && s.MemoryMB == nil && s.DynamicMemory == nil && s.MinimumMemoryMB == nil &&
+		s.MaximumMemoryMB == nil && s.AutomaticStartAction == nil && s.AutomaticStopAction == nil
+}
+
+// Validate checks the automatic actions, the only settings that Hyper-V does not check
+// itself before changing anything.
+func (s VMSettings) Validate() error {
+	if s.AutomaticStartAction != nil {
+		if _, err := AutomaticStartAction(*s.AutomaticStartAction); err != nil {
+			return err
+		}
+	}
+	if s.AutomaticStopAction != nil {
+		if _, err := AutomaticStopAction(*s.AutomaticStopAction); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+var (
+	startActions = []string{"Nothing", "StartIfRunning", "Start"}
+	stopActions  = []string{"TurnOff", "Save", "ShutDown"}
+)
+
+// AutomaticStartAction returns the position of an automatic start action in Nothing,
+// StartIfRunning and Start, which is also the order of the AutomaticStartupAction values of
+// Msvm_VirtualSystemSettingData.
+func AutomaticStartAction(action string) (int, error) {
+	for i, a := range startActions {
+		if strings.EqualFold(action, a) {
+			return i, nil
+		}
+	}
+	return 0, fmt.Errorf("unsupported automatic start action [%s], must be one of %s", action, strings.Join(startActions, ", "))
+}
+
+// AutomaticStopAction returns the position of an automatic stop action in TurnOff, Save and
+// ShutDown, which is also the order of the AutomaticShutdownAction values of
+// Msvm_VirtualSystemSettingData.
+func AutomaticStopAction(action string) (int, error) {
+	for i, a := range stopActions {
+		if strings.EqualFold(action, a) {
+			return i, nil
+		}
+	}
+	return 0, fmt.Errorf("unsupported automatic stop action [%s], must be one of %s", action, strings.Join(stopActions, ", "))
+}
+
+// Disk is a virtual hard disk file.
+type Disk struct {
+	Path string
+	vhd.Info
+	// MinimumSize is the smallest virtual size the disk can be shrunk to, which is the end of
+	// its last partition, or 0 if it is unknown.
+	MinimumSize uint64
+}
+
+// DiskUser is a virtual machine, or a checkpoint of one, whose drive uses a virtual hard disk.
+type DiskUser struct {
+	VMName     string
+	State      PowerState
+	Generation int
+	// ControllerType is the controller the disk is attached to, or empty for a checkpoint.
+	ControllerType string
+}
+
+// DiskDrive is a virtual hard disk attached to a controller slot of a virtual machine.
+type DiskDrive struct {
+	Path string
+	// ControllerType is ControllerSCSI or ControllerIDE.
+	ControllerType   string
+	ControllerNumber int
+	// ControllerLocation is the location on the controller. When attaching, a negative
+	// location selects the first free one.
+	ControllerLocation int
+	// Settings are applied when the disk is attached. They are not reported.
+	Settings *DiskDriveSettings `json:"-"`
+}
+
+// DiskDriveSettings are the storage QoS and attachment settings of an attached virtual hard
+// disk. Nil fields are left at their Hyper-V defaults, or as they are when changing them.
+type DiskDriveSettings struct {
+	// MinimumIops is the reserved throughput in normalized 8 KB IOPS.
+	MinimumIops *uint64
+	// MaximumIops is the throughput limit in normalized 8 KB IOPS.
+	MaximumIops *uint64
+	// QosPolicyID is the ID of a Storage QoS policy of a Scale-Out File Server.
+	QosPolicyID *string
+	// SupportPersistentReservations shares the disk between the nodes of a guest cluster.
+	SupportPersistentReservations *bool
+	// ReadOnly attaches the disk without write access.
+	ReadOnly *bool
+	// CacheMode is one of the CacheMode constants.
+	CacheMode *string
+}
+
+// IsEmpty reports whether no setting is specified.
+func (s *DiskDriveSettings) IsEmpty() bool {
+	return s == nil || (s.MinimumIops == nil && s.MaximumIops == nil && s.QosPolicyID == nil &&
+		s.SupportPersistentReservations == nil && s.ReadOnly == nil && s.CacheMode == nil)
+}
+
+func (s *DiskDriveSettings) readOnly() bool {
+	return s != nil && s.ReadOnly != nil && *s.ReadOnly
+}
+
+// CheckDiskDriveSettings returns an error when b cannot apply settings, so they can be
+// refused before anything is changed. Only WMI can attach a disk read-only.
+func CheckDiskDriveSettings(b HypervBackend, path string, settings *DiskDriveSettings) error {
+	if settings.readOnly() && b.Name() == (&PowerShell{}).Name() {
+		return ReadOnlyUnsupported("Add-VMHardDiskDrive", path)
+	}
+	return nil
+}
+
+// ReadOnlyUnsupported is the error of attaching the disk at path read-only with the Hyper-V
+// PowerShell module, whose cmdlets have no parameter for it.
+func ReadOnlyUnsupported(op string, path string) error {
+	return errs.New(errs.ErrNotSupported, op, fmt.Sprintf(
+		"vhd [%s] cannot be attached read-only with the Hyper-V PowerShell module, use the wmi backend", path))
+}
+
+// Validate checks that the settings are consistent.
+func (s *DiskDriveSettings) Validate() error {
+	if s == nil {
+		return nil
+	}
+	if s.MinimumIops != nil && s.MaximumIops != nil && *s.MaximumIops != 0 && *s.MinimumIops > *s.MaximumIops {
+		return fmt.Errorf("minimumIops (%d) cannot be greater than maximumIops (%d)", *s.MinimumIops, *s.MaximumIops)
+	}
+	// Zero IOPS removes the reservation or limit, which is how a drive moves to a policy.
+	if s.QosPolicyID != nil && *s.QosPolicyID != "" && (nonZero(s.MinimumIops) || nonZero(s.MaximumIops)) {
+		return fmt.Errorf("qosPolicyId cannot be combined with minimumIops or maximumIops")
+	}
+	if s.CacheMode != nil {
+		if _, err := WriteHardeningMethod(*s.CacheMode); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func nonZero(v *uint64) bool {
+	return v != nil && *v != 0
+}
+
+// WriteHardeningMethod returns the WriteHardeningMethod value of a cache mode.
+func WriteHardeningMethod(mode string) (uint16, error) {
+	for i, m := range cacheModes {
+		if strings.EqualFold(mode, m) {
+			return uint16(i), nil
+		}
+	}
+	return 0, fmt.Errorf("unsupported cache mode [%s], must be one of %s", mode, strings.Join(cacheModes, ", "))
+}
+
+// PowerShellArgs returns the settings as parameters of Add-VMHardDiskDrive and
+// Set-VMHardDiskDrive. Read-only attachment has no cmdlet parameter and is left out.
+func (s *DiskDriveSettings) PowerShellArgs() string {
+	if s == nil {
+		return ""
+	}
+	var args []string
+	if s.MinimumIops != nil {
+		args = append(args, fmt.Sprintf("-MinimumIOPS %d", *s.MinimumIops))
+	}
+	if s.MaximumIops != nil {
+		args = append(args, fmt.Sprintf("-MaximumIOPS %d", *s.MaximumIops))
+	}
+	if s.QosPolicyID != nil {
+		args = append(args, "-QoSPolicyID "+quote(*s.QosPolicyID))
+	}
+	if s.SupportPersistentReservations != nil {
+		args = append(args, fmt.Sprintf("-SupportPersistentReservations:$%t", *s.SupportPersistentReservations))
+	}
+	if s.CacheMode != nil {
+		if method, err := WriteHardeningMethod(*s.CacheMode); err == nil {
+			args = append(args, "-OverrideCacheAttributes "+cacheModes[method])
+		}
+	}
+	return strings.Join(args, " ")
+}
+
+// Switch is a virtual switch.
+type Switch struct {
+	ID   string
+	Name string
+	// SwitchType is SwitchTypeExternal, SwitchTypeInternal or SwitchTypePrivate.
+	SwitchType string
+	// NetAdapterName is the physical network adapter an external switch is bound to.
+	NetAdapterName    string
+	AllowManagementOS bool
+	Notes             string
+	// BandwidthReservationMode is how minimum bandwidth is reserved on the switch: Absolute,
+	// Weight, Default or None. It is set when the switch is created and only reported.
+	BandwidthReservationMode string
+	// IovEnabled reports whether the switch was created with SR-IOV. It is only reported.
+	IovEnabled bool
+}
+
+// NetworkAdapter is a network adapter of a virtual machine.
+type NetworkAdapter struct {
+	Name   string
+	VMName string
+	// SwitchName is the switch the adapter is connected to, or empty if it is not connected.
+	SwitchName string
+	// MacAddress is the address as 12 hexadecimal digits. When adding an adapter, an empty
+	// address selects a dynamic one.
+	MacAddress string
+	// DynamicMacAddress reports whether Hyper-V assigns the address.
+	DynamicMacAddress bool
+}
+
+// NetworkAdapterStatus is the switch port configuration and the runtime state of a network
+// adapter.
+type NetworkAdapterStatus struct {
+	Vlan        VlanSettings
+	DHCPGuard   bool
+	RouterGuard bool
+	// PortMirroring is None, Destination or Source.
+	PortMirroring   string
+	IeeePriorityTag bool
+	VMQWeight       int
+	// IPAddresses are the addresses the guest reports through the integration services.
+	IPAddresses []string
+	// Status is the operational status of the switch port, such as Ok or Degraded.
+	Status string
+}
+
+// VlanSettings is the VLAN configuration of a switch port. Only the IDs of its mode are set.
+type VlanSettings struct {
+	// Mode is one of the VLAN modes, such as VlanAccess.
+	Mode         string
+	AccessVlanID int
+	// NativeVlanID is the VLAN of the untagged traffic of a trunk port.
+	NativeVlanID   int
+	AllowedVlanIDs []int
+	// PrimaryVlanID and SecondaryVlanID are the private VLAN of an isolated or community
+	// port. A promiscuous port has SecondaryVlanIDs instead.
+	PrimaryVlanID    int
+	SecondaryVlanID  int
+	SecondaryVlanIDs []int
+}
+
+// NetworkAdapterSettings are changes to a network adapter and to the features of its switch
+// port. Settings that are nil are left as they are.
+type NetworkAdapterSettings struct {
+	// MacAddress is a static address as 12 hexadecimal digits.
+	MacAddress *string
+	// Vlan in the VlanUntagged mode removes the VLAN of the port.
+	Vlan        *VlanSettings
+	DHCPGuard   *bool
+	RouterGuard *bool
+	// PortMirroring is None, Destination or Source.
+	PortMirroring   *string
+	IeeePriorityTag *bool
+	VMQWeight       *int
+}
+
+// PortFeatures reports whether any switch port feature is to be changed.
+func (s NetworkAdapterSettings) PortFeatures() bool {
+	return s.Vlan != nil || s.DHCPGuard != nil || s.RouterGuard != nil || s.PortMirroring != nil ||
+		s.IeeePriorityTag != nil || s.VMQWeight != nil
+}
+
+// FormatVlanList returns VLAN IDs as the list Set-VMNetworkAdapterVlan takes, sorted and
+// with consecutive IDs joined into ranges, such as 1-3,5,200.
+func FormatVlanList(ids []int) string {
+	sorted := append([]int(nil), ids...)
+	sort.Ints(sorted)
+	var fields []string
+	for i := 0; i < len(sorted); {
+		j := i
+		for j+1 < len(sorted) && sorted[j+1] <= sorted[j]+1 {
+			j++
+		}
+		if sorted[j] == sorted[i] {
+			fields = append(fields, strconv.Itoa(sorted[i]))
+		} else {
+			fields = append(fields, fmt.Sprintf("%d-%d", sorted[i], sorted[j]))
+		}
+		i = j + 1
+	}
+	return strings.Join(fields, ",")
+}
+
+// HostInfo describes the resources and default paths of a Hyper-V host.
+type HostInfo struct {
+	ComputerName          string
+	LogicalProcessorCount int
+	MemoryCapacityBytes   uint64
+	// VirtualHardDiskPath is the default folder of new virtual hard disks.
+	VirtualHardDiskPath string
+	// VirtualMachinePath is the default folder of the configuration files of new VMs.
+	VirtualMachinePath string
+}
+
+// PhysicalNetAdapter is a physical network adapter of the host.
+type PhysicalNetAdapter struct {
+	Name                 string
+	InterfaceDescription string
+	MacAddress           string
+	// Status is the operational status, such as "Up" or "Disconnected".
+	Status       string
+	LinkSpeedBps uint64
+	// SwitchName is the external switch bound to the adapter, or empty if there is none.
+	SwitchName string
+}
+
+// HypervBackend performs operations on a Hyper-V host. Operations on objects that do not
+// exist fail with errs.ErrNotFound, and operations that the state of an object does not
+// allow fail with errs.ErrInvalidState.
+type HypervBackend interface {
+	// Name identifies the backend in logs, such as "wmi" or "powershell".
+	Name() string
+	// HostCapabilities probes what the host supports.
+	HostCapabilities(ctx context.Context) (*HostCapabilities, error)
+	// GetHostInfo returns the resources and default paths of the host.
+	GetHostInfo(ctx context.Context) (*HostInfo, error)
+	// ListPhysicalNetAdapters returns the physical network adapters of the host.
+	ListPhysicalNetAdapters(ctx context.Context) ([]PhysicalNetAdapter, error)
+
+	// ListVMs returns the virtual machines of the host.
+	ListVMs(ctx context.Context) ([]VM, error)
+	// GetVM returns the virtual machine with the given name or ID.
+	GetVM(ctx context.Context, nameOrID string) (*VM, error)
+	// CreateVM creates a virtual machine that is off and has no drives or adapters.
+	CreateVM(ctx context.Context, spec VMSpec) (*VM, error)
+	// SetVMState starts, stops, pauses or saves a virtual machine.
+	SetVMState(ctx context.Context, name string, state PowerState) error
+	// SetVM changes the settings of a virtual machine.
+	SetVM(ctx context.Context, name string, settings VMSettings) error
+	// DeleteVM deletes a virtual machine that is off. Its disk files are left in place.
+	DeleteVM(ctx context.Context, name string) error
+
+	// GetDisk returns the virtual hard disk at path.
+	GetDisk(ctx context.Context, path string) (*Disk, error)
+	// CreateDisk creates a virtual hard disk. An existing file is never overwritten.
+	CreateDisk(ctx context.Context, path string, opts vhd.CreateOptions) (*Disk, error)
+	// DeleteDisk deletes a virtual hard disk that is not attached to a virtual machine.
+	DeleteDisk(ctx context.Context, path string) error
+	// ListDiskUsers returns the virtual machines and checkpoints that use the disk at path.
+	ListDiskUsers(ctx context.Context, path string) ([]DiskUser, error)
+	// ResizeDisk changes the virtual size of a disk.
+	ResizeDisk(ctx context.Context, path string, size uint64) error
+	// ConvertDisk converts a disk between the fixed and dynamic types in place. The
+	// converted copy only replaces the disk once the conversion has succeeded.
+	ConvertDisk(ctx context.Context, path string, diskType string) error
+	// CompactDisk reclaims the unused space of a dynamic or differencing disk.
+	CompactDisk(ctx context.Context, path string) error
+	// MergeDisk merges a differencing disk into destination, its parent or another
+	// ancestor, and removes it.
+	MergeDisk(ctx context.Context, path string, destination string) error
+	// MoveDisk moves a disk file to destination and returns its new path. A destination that
+	// is a directory, or ends with a separator, receives the file under its name. An
+	// existing file is never overwritten.
+	MoveDisk(ctx context.Context, path string, destination string) (string, error)
+
+	// ListDiskDrives returns the virtual hard disks attached to a virtual machine.
+	ListDiskDrives(ctx context.Context, vmName string) ([]DiskDrive, error)
+	// AttachDisk attaches a virtual hard disk to a virtual machine and returns the slot it
+	// was attached to.
+	AttachDisk(ctx context.Context, vmName string, drive DiskDrive) (*DiskDrive, error)
+	// DetachDisk detaches the virtual hard disk at path. A disk that is not attached is
+	// ignored.
+	DetachDisk(ctx context.Context, vmName string, path string) error
+	// SetDiskDrive changes the settings of the attached virtual hard disk at path. The VM can
+	// be running. The PowerShell backend cannot make a drive read-only and refuses to.
+	SetDiskDrive(ctx context.Context, vmName string, path string, settings DiskDriveSettings) error
+	// SetSCSIControllerCount adds or removes SCSI controllers of a virtual machine that is off
+	// until it has count of them. Controllers are removed from the end, and a controller that
+	// still has drives attached is not removed.
+	SetSCSIControllerCount(ctx context.Context, vmName string, count int) error
+	// AttachPassThroughDisk attaches the offline physical disk of the host that spec
+	// identifies and returns it. A disk that is already attached to the VM is left as it is.
+	AttachPassThroughDisk(ctx context.Context, vmName string, spec passthrough.Spec) (*passthrough.Disk, error)
+	// DetachPassThroughDisk detaches the physical disk that spec identifies. A disk that is
+	// not attached is ignored.
+	DetachPassThroughDisk(ctx context.Context, vmName string, spec passthrough.Spec) error
+
+	// ListSwitches returns the virtual switches of the host.
+	ListSwitches(ctx context.Context) ([]Switch, error)
+	// GetSwitch returns the virtual switch with the given name.
+	GetSwitch(ctx context.Context, name string) (*Switch, error)
+	// CreateSwitch creates a virtual switch.
+	CreateSwitch(ctx context.Context, spec Switch) (*Switch, error)
+	// UpdateSwitch changes the type, adapter, management OS access and notes of a switch.
+	UpdateSwitch(ctx context.Context, name string, spec Switch) (*Switch, error)
+	// DeleteSwitch deletes a virtual switch. Adapters connected to it are disconnected.
+	DeleteSwitch(ctx context.Context, name string) error
+
+	// ListNetworkAdapters returns the network adapters of a virtual machine.
+	ListNetworkAdapters(ctx context.Context, vmName string) ([]NetworkAdapter, error)
+	// GetNetworkAdapterStatus returns the switch port configuration and the runtime state of
+	// a network adapter.
+	GetNetworkAdapterStatus(ctx context.Context, vmName string, adapterName string) (*NetworkAdapterStatus, error)
+	// ListConnectedAdapters returns the network adapters of virtual machines that are
+	// connected to the switch.
+	ListConnectedAdapters(ctx context.Context, switchName string) ([]NetworkAdapter, error)
+	// AddNetworkAdapter adds a network adapter to a virtual machine and connects it to
+	// SwitchName, if set.
+	AddNetworkAdapter(ctx context.Context, adapter NetworkAdapter) (*NetworkAdapter, error)
+	// ConnectNetworkAdapter connects an adapter to a switch, or disconnects it when
+	// switchName is empty.
+	ConnectNetworkAdapter(ctx context.Context, vmName string, adapterName string, switchName string) error
+	// SetNetworkAdapter changes the settings of a network adapter and of its switch port.
+	SetNetworkAdapter(ctx context.Context, vmName string, adapterName string, settings NetworkAdapterSettings) error
+	// RemoveNetworkAdapter removes a network adapter from a virtual machine.
+	RemoveNetworkAdapter(ctx context.Context, vmName string, adapterName string) error
+}
+
+// Connector returns the backend for the provider configuration in ctx.
+type Connector func(ctx context.Context) (HypervBackend, error)
+
+var connector Connector
+
+// SetConnector sets the function that Connect uses. The provider sets it when it starts.
+func SetConnector(c Connector) {
+	connector = c
+}
+
+type contextKey struct{}
+
+// WithBackend returns a context in which Connect returns b. Tests use it to run resources
+// against a Simulator.
+func WithBackend(ctx context.Context, b HypervBackend) context.Context {
+	return context.WithValue(ctx, contextKey{}, b)
+}
+
+// Connect returns the backend that resource operations in ctx use.
+func Connect(ctx context.Context) (HypervBackend, error) {
+	if b, ok := ctx.Value(contextKey{}).(HypervBackend); ok {
+		return b, nil
+	}
+	if connector == nil {
+		return nil, fmt.Errorf("no Hyper-V backend is configured")
+	}
+	return connector(ctx)
+}
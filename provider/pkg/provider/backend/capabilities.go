@@ -0,0 +1,175 @@
+// Copyright 2016-2022, Pulumi Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Windows builds that introduced the features the provider depends on.
+const (
+	// BuildGeneration2 is Windows 8.1 and Windows Server 2012 R2, the first builds with
+	// generation 2 virtual machines and their UEFI secure boot.
+	BuildGeneration2 = 9600
+)
+
+// HostCapabilities describes the operating system of a Hyper-V host and the parts of Hyper-V
+// it provides.
+type HostCapabilities struct {
+	// OSCaption is the name of the edition, such as "Microsoft Windows Server 2022 Datacenter".
+	OSCaption string
+	// OSVersion is the version of the operating system, such as "10.0.20348".
+	OSVersion string
+	// OSBuild is the build number of OSVersion.
+	OSBuild int
+	// Server reports whether the edition is Windows Server.
+	Server bool
+	// AzureEdition reports whether the edition is a Windows Server Azure Edition.
+	AzureEdition bool
+
+	// HyperVRole reports whether the Hyper-V role or optional feature is enabled.
+	HyperVRole bool
+	// PowerShellModule reports whether the Hyper-V PowerShell module is installed.
+	PowerShellModule bool
+	// VMMS reports whether the Virtual Machine Management service is running.
+	VMMS bool
+	// VSMS reports whether the Virtual System Management Service of the WMI provider answers.
+	VSMS bool
+	// HGS reports whether the Host Guardian Service WMI namespace is available.
+	HGS bool
+
+	// ConfigVersions are the virtual machine configuration versions the host supports.
+	ConfigVersions []string
+	// MaxConfigVersion is the highest of ConfigVersions.
+	MaxConfigVersion string
+	// Generation2 reports whether the host runs generation 2 virtual machines.
+	Generation2 bool
+	// SecureBoot reports whether generation 2 virtual machines can use secure boot.
+	SecureBoot bool
+}
+
+// Complete derives the fields that follow from the probed ones: the build, the kind of
+// edition, the highest configuration version and the support for generation 2.
+func (c *HostCapabilities) Complete() {
+	if c.OSBuild == 0 {
+		c.OSBuild = ParseBuild(c.OSVersion)
+	}
+	caption := strings.ToLower(c.OSCaption)
+	c.Server = strings.Contains(caption, "server")
+	c.AzureEdition = strings.Contains(caption, "azure")
+	c.MaxConfigVersion = MaxConfigVersion(c.ConfigVersions)
+	c.Generation2 = c.HyperVRole && c.OSBuild >= BuildGeneration2
+	c.SecureBoot = c.Generation2
+}
+
+// CheckGeneration returns an error when the host cannot run virtual machines of generation.
+func (c *HostCapabilities) CheckGeneration(generation int) error {
+	if generation == 2 && !c.Generation2 {
+		return fmt.Errorf("the host does not support generation 2 virtual machines: %s build %d is older than Windows Server 2012 R2", c.OSCaption, c.OSBuild)
+	}
+	return nil
+}
+
+// ParseBuild returns the build number of a Windows version such as "10.0.20348", or 0.
+func ParseBuild(version string) int {
+	parts := strings.Split(strings.TrimSpace(version), ".")
+	if len(parts) < 3 {
+		return 0
+	}
+	build, err := strconv.Atoi(parts[2])
+	if err != nil {
+		return 0
+	}
+	return build
+}
+
+// MaxConfigVersion returns the highest of the configuration versions, which have the form
+// major.minor, or an empty string when there are none.
+func MaxConfigVersion(versions []string) string {
+	max := ""
+	var maxMajor, maxMinor int
+	for _, version := range versions {
+		major, minor, ok := parseConfigVersion(version)
+		if !ok {
+			continue
+		}
+		if max == "" || major > maxMajor || (major == maxMajor && minor > maxMinor) {
+			max, maxMajor, maxMinor = strings.TrimSpace(version), major, minor
+		}
+	}
+	return max
+}
+
+func parseConfigVersion(version string) (int, int, bool) {
+	majorText, minorText, _ := strings.Cut(strings.TrimSpace(version), ".")
+	major, err := strconv.Atoi(majorText)
+	if err != nil {
+		return 0, 0, false
+	}
+	minor := 0
+	if minorText != "" {
+		if minor, err = strconv.Atoi(minorText); err != nil {
+			return 0, 0, false
+		}
+	}
+	return major, minor, true
+}
+
+// CapabilityCache keeps the capabilities of each host, so that they are probed once for all
+// the connections of the provider to it.
+type CapabilityCache struct {
+	mu    sync.Mutex
+	hosts map[string]*HostCapabilities
+}
+
+// Wrap returns b with HostCapabilities answered from the cache entry of host.
+func (c *CapabilityCache) Wrap(b HypervBackend, host string) HypervBackend {
+	return &cachedBackend{HypervBackend: b, cache: c, host: host}
+}
+
+// Capabilities returns the cached capabilities of host, probing them with b the first time.
+// Failed probes are not cached.
+func (c *CapabilityCache) Capabilities(ctx context.Context, b HypervBackend, host string) (*HostCapabilities, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	caps, ok := c.hosts[host]
+	if !ok {
+		var err error
+		if caps, err = b.HostCapabilities(ctx); err != nil {
+			return nil, err
+		}
+		if c.hosts == nil {
+			c.hosts = map[string]*HostCapabilities{}
+		}
+		c.hosts[host] = caps
+	}
+	result := *caps
+	return &result, nil
+}
+
+// cachedBackend is a backend whose host capabilities come from a CapabilityCache.
+type cachedBackend struct {
+	HypervBackend
+	cache *CapabilityCache
+	host  string
+}
+
+func (b *cachedBackend) HostCapabilities(ctx context.Context) (*HostCapabilities, error) {
+	return b.cache.Capabilities(ctx, b.HypervBackend, b.host)
+}
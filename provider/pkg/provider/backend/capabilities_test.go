@@ -0,0 +1,113 @@
+// Copyright 2016-2022, Pulumi Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestHostCapabilitiesComplete(t *testing.T) {
+	caps := HostCapabilities{
+		OSCaption:      "Microsoft Windows Server 2022 Datacenter: Azure Edition",
+		OSVersion:      "10.0.20348",
+		HyperVRole:     true,
+		ConfigVersions: []string{"9.0", "10.0", "8.3", "bogus"},
+	}
+	caps.Complete()
+	if caps.OSBuild != 20348 || !caps.Server || !caps.AzureEdition || caps.MaxConfigVersion != "10.0" || !caps.Generation2 || !caps.SecureBoot {
+		t.Errorf("Complete = %+v", caps)
+	}
+	if err := caps.CheckGeneration(2); err != nil {
+		t.Errorf("CheckGeneration(2) = %v", err)
+	}
+
+	old := HostCapabilities{OSCaption: "Microsoft Windows Server 2012 Standard", OSVersion: "6.2.9200", HyperVRole: true}
+	old.Complete()
+	if old.Generation2 || old.MaxConfigVersion != "" {
+		t.Errorf("Complete of Windows Server 2012 = %+v", old)
+	}
+	if err := old.CheckGeneration(2); err == nil || !strings.Contains(err.Error(), "build 9200") {
+		t.Errorf("CheckGeneration(2) on Windows Server 2012 = %v", err)
+	}
+	if err := old.CheckGeneration(1); err != nil {
+		t.Errorf("CheckGeneration(1) on Windows Server 2012 = %v", err)
+	}
+}
+
+func TestParseBuild(t *testing.T) {
+	for version, want := range map[string]int{"10.0.26100": 26100, "6.3.9600": 9600, "10.0": 0, "": 0, "a.b.c": 0} {
+		if got := ParseBuild(version); got != want {
+			t.Errorf("ParseBuild(%q) = %d, want %d", version, got, want)
+		}
+	}
+}
+
+// countingBackend counts the probes of the host.
+type countingBackend struct {
+	*Simulator
+	probes int
+	err    error
+}
+
+func (b *countingBackend) HostCapabilities(ctx context.Context) (*HostCapabilities, error) {
+	b.probes++
+	if b.err != nil {
+		return nil, b.err
+	}
+	return b.Simulator.HostCapabilities(ctx)
+}
+
+func TestCapabilityCache(t *testing.T) {
+	ctx := context.Background()
+	var cache CapabilityCache
+	probe := &countingBackend{Simulator: NewSimulator(), err: errors.New("access denied")}
+
+	if _, err := cache.Wrap(probe, "hv1").HostCapabilities(ctx); err == nil {
+		t.Fatal("HostCapabilities of a failing probe succeeded")
+	}
+	probe.err = nil
+	for i := 0; i < 3; i++ {
+		caps, err := cache.Wrap(probe, "hv1").HostCapabilities(ctx)
+		if err != nil || caps.OSBuild != 20348 {
+			t.Fatalf("HostCapabilities = %+v, %v", caps, err)
+		}
+		caps.OSBuild = 0
+	}
+	if probe.probes != 2 {
+		t.Errorf("the host was probed %d times, want a failed probe and one that is cached", probe.probes)
+	}
+	if _, err := cache.Wrap(probe, "hv2").HostCapabilities(ctx); err != nil || probe.probes != 3 {
+		t.Errorf("another host was probed %d times in all, want it probed separately", probe.probes)
+	}
+}
+
+func TestPowerShellHostCapabilities(t *testing.T) {
+	f := &fakeRunner{responses: map[string]string{
+		"Win32_OperatingSystem": `[{"Caption":"Microsoft Windows 11 Pro","Version":"10.0.22631","HyperVRole":true,"Module":true,"VMMS":true,"VSMS":true,"HGS":false,"ConfigVersions":["9.0","12.0","10.0"]}]`,
+	}}
+	caps, err := NewPowerShell(f.run).HostCapabilities(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if caps.Server || caps.OSBuild != 22631 || caps.MaxConfigVersion != "12.0" || !caps.PowerShellModule || caps.HGS || !caps.Generation2 {
+		t.Errorf("HostCapabilities = %+v", caps)
+	}
+	if !strings.Contains(f.scripts[0], "Get-VMHostSupportedVersion") {
+		t.Errorf("HostCapabilities ran %q, want the supported configuration versions probed", f.scripts[0])
+	}
+}
@@ -0,0 +1,1050 @@
+// Copyright 2016-2022, Pulumi Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/pulumi/pulumi-hyperv/provider/pkg/provider/errs"
+	"github.com/pulumi/pulumi-hyperv/provider/pkg/provider/passthrough"
+	"github.com/pulumi/pulumi-hyperv/provider/pkg/provider/vhd"
+)
+
+// PowerShell is a backend that runs the cmdlets of the Hyper-V PowerShell module. It is used
+// where the WMI services are unavailable, such as on client editions of Windows.
+type PowerShell struct {
+	// Run runs a script and returns its output. Failures should be classified with
+	// errs.ClassifyPowerShell.
+	Run func(ctx context.Context, script string) (string, error)
+	// RunIdempotent runs the scripts that only read or that can be repeated, and may retry
+	// their transient failures. Scripts that create or add run with Run, once. Run is used
+	// when it is nil.
+	RunIdempotent func(ctx context.Context, script string) (string, error)
+}
+
+var _ HypervBackend = (*PowerShell)(nil)
+
+// NewPowerShell returns a backend that runs its scripts with run.
+func NewPowerShell(run func(ctx context.Context, script string) (string, error)) *PowerShell {
+	return &PowerShell{Run: run}
+}
+
+func (p *PowerShell) Name() string {
+	return "powershell"
+}
+
+// hostCapabilitiesScript probes the operating system and the parts of Hyper-V the host has.
+// Probes that fail report the part as missing.
+const hostCapabilitiesScript = `$os = Get-CimInstance -ClassName Win32_OperatingSystem
+$module = [bool](Get-Module -ListAvailable -Name Hyper-V)
+[pscustomobject]@{
+  Caption = $os.Caption
+  Version = $os.Version
+  HyperVRole = [bool](Get-CimInstance -ClassName Win32_OptionalFeature -Filter "Name='Microsoft-Hyper-V' AND InstallState=1" -ErrorAction SilentlyContinue)
+  Module = $module
+  VMMS = [bool](Get-Service -Name vmms -ErrorAction SilentlyContinue | Where-Object Status -eq 'Running')
+  VSMS = [bool](Get-CimInstance -Namespace root/virtualization/v2 -ClassName Msvm_VirtualSystemManagementService -ErrorAction SilentlyContinue)
+  HGS = [bool](Get-CimClass -Namespace root/Microsoft/Windows/Hgs -ErrorAction SilentlyContinue | Select-Object -First 1)
+  ConfigVersions = @(if ($module) { Get-VMHostSupportedVersion -ErrorAction SilentlyContinue | ForEach-Object { $_.Version.ToString() } })
+}`
+
+type psHostCapabilities struct {
+	Caption        string
+	Version        string
+	HyperVRole     bool
+	Module         bool
+	VMMS           bool
+	VSMS           bool
+	HGS            bool
+	ConfigVersions []string
+}
+
+func (p *PowerShell) HostCapabilities(ctx context.Context) (*HostCapabilities, error) {
+	var found []psHostCapabilities
+	if err := p.query(ctx, "Get-CimInstance", hostCapabilitiesScript, "*", &found); err != nil {
+		return nil, err
+	}
+	if len(found) == 0 {
+		return nil, fmt.Errorf("the host capabilities probe returned nothing")
+	}
+	caps := &HostCapabilities{
+		OSCaption:        found[0].Caption,
+		OSVersion:        found[0].Version,
+		HyperVRole:       found[0].HyperVRole,
+		PowerShellModule: found[0].Module,
+		VMMS:             found[0].VMMS,
+		VSMS:             found[0].VSMS,
+		HGS:              found[0].HGS,
+		ConfigVersions:   found[0].ConfigVersions,
+	}
+	caps.Complete()
+	return caps, nil
+}
+
+// singleQuotes doubles the characters PowerShell takes for a single quote, which are the
+// ASCII one and the typographic ones, so that none of them can end a single-quoted string.
+var singleQuotes = strings.NewReplacer("'", "''", "\u2018", "\u2018\u2018", "\u2019", "\u2019\u2019", "\u201A", "\u201A\u201A", "\u201B", "\u201B\u201B")
+
+// quote returns s as a single-quoted PowerShell string.
+func quote(s string) string {
+	return "'" + singleQuotes.Replace(s) + "'"
+}
+
+// Quote returns s as a single-quoted PowerShell string, for the scripts that run outside
+// this backend.
+func Quote(s string) string {
+	return quote(s)
+}
+
+// boolean returns b as a PowerShell boolean.
+func boolean(b bool) string {
+	if b {
+		return "$true"
+	}
+	return "$false"
+}
+
+// run runs a script for the cmdlet op.
+func (p *PowerShell) run(ctx context.Context, op string, script string) (string, error) {
+	output, err := p.Run(ctx, script)
+	if err != nil {
+		return output, fmt.Errorf("%s failed: %w", op, err)
+	}
+	return output, nil
+}
+
+// runIdempotent runs a script that reads or can be repeated for the cmdlet op.
+func (p *PowerShell) runIdempotent(ctx context.Context, op string, script string) (string, error) {
+	if p.RunIdempotent == nil {
+		return p.run(ctx, op, script)
+	}
+	output, err := p.RunIdempotent(ctx, script)
+	if err != nil {
+		return output, fmt.Errorf("%s failed: %w", op, err)
+	}
+	return output, nil
+}
+
+// query runs pipeline, which only reads, for the cmdlet op, selects properties from the
+// objects it returns and decodes them into out, a pointer to a slice.
+func (p *PowerShell) query(ctx context.Context, op string, pipeline string, properties string, out interface{}) error {
+	return p.decode(ctx, p.runIdempotent, op, pipeline, properties, out)
+}
+
+// create runs pipeline, which creates or adds, for the cmdlet op like query, but only once.
+func (p *PowerShell) create(ctx context.Context, op string, pipeline string, properties string, out interface{}) error {
+	return p.decode(ctx, p.run, op, pipeline, properties, out)
+}
+
+func (p *PowerShell) decode(ctx context.Context, run func(context.Context, string, string) (string, error), op string, pipeline string, properties string, out interface{}) error {
+	script := fmt.Sprintf("ConvertTo-Json -Compress -Depth 3 -InputObject @(%s | Select-Object %s)", pipeline, properties)
+	output, err := run(ctx, op, script)
+	if err != nil {
+		return err
+	}
+	// Warnings may precede the JSON document, which is written on one line.
+	lines := strings.Split(strings.TrimSpace(output), "\n")
+	document := strings.TrimSpace(lines[len(lines)-1])
+	if document == "" {
+		document = "[]"
+	}
+	if err := json.Unmarshal([]byte(document), out); err != nil {
+		return fmt.Errorf("failed to parse the output of %s: %w, output: %s", op, err, output)
+	}
+	return nil
+}
+
+// asString returns a calculated property that converts an enumeration or a GUID to a string,
+// which ConvertTo-Json would otherwise write as a number or an object.
+func asString(name string) string {
+	return fmt.Sprintf("@{n='%s';e={\"$($_.%s)\"}}", name, name)
+}
+
+var vmProperties = strings.Join([]string{
+	"Name", asString("Id"), "Generation", asString("State"),
+	"@{n='MemoryMB';e={[uint64]($_.MemoryStartup / 1MB)}}", "ProcessorCount",
+	"DynamicMemoryEnabled",
+	"@{n='MinimumMemoryMB';e={[uint64]($_.MemoryMinimum / 1MB)}}",
+	"@{n='MaximumMemoryMB';e={[uint64]($_.MemoryMaximum / 1MB)}}",
+	asString("AutomaticStartAction"), asString("AutomaticStopAction"),
+}, ",")
+
+type psVM struct {
+	Name                 string
+	ID                   string `json:"Id"`
+	Generation           int
+	State                string
+	MemoryMB             uint64
+	ProcessorCount       int
+	DynamicMemoryEnabled bool
+	MinimumMemoryMB      uint64
+	MaximumMemoryMB      uint64
+	AutomaticStartAction string
+	AutomaticStopAction  string
+}
+
+func (v psVM) vm() VM {
+	return VM{
+		ID:             v.ID,
+		Name:           v.Name,
+		Generation:     v.Generation,
+		State:          PowerState(v.State),
+		MemoryMB:       v.MemoryMB,
+		ProcessorCount: v.ProcessorCount,
+
+		DynamicMemory:        v.DynamicMemoryEnabled,
+		MinimumMemoryMB:      v.MinimumMemoryMB,
+		MaximumMemoryMB:      v.MaximumMemoryMB,
+		AutomaticStartAction: v.AutomaticStartAction,
+		AutomaticStopAction:  v.AutomaticStopAction,
+	}
+}
+
+func (p *PowerShell) ListVMs(ctx context.Context) ([]VM, error) {
+	var found []psVM
+	if err := p.query(ctx, "Get-VM", "Get-VM", vmProperties, &found); err != nil {
+		return nil, err
+	}
+	vms := make([]VM, 0, len(found))
+	for _, v := range found {
+		vms = append(vms, v.vm())
+	}
+	return vms, nil
+}
+
+func (p *PowerShell) GetVM(ctx context.Context, nameOrID string) (*VM, error) {
+	id := strings.Trim(nameOrID, "{}")
+	pipeline := fmt.Sprintf("Get-VM | Where-Object { $_.Name -eq %s -or \"$($_.Id)\" -eq %s }", quote(nameOrID), quote(id))
+	var found []psVM
+	if err := p.query(ctx, "Get-VM", pipeline, vmProperties, &found); err != nil {
+		return nil, err
+	}
+	if len(found) == 0 {
+		return nil, errs.New(errs.ErrNotFound, "Get-VM", fmt.Sprintf("virtual machine %s not found", nameOrID))
+	}
+	vm := found[0].vm()
+	return &vm, nil
+}
+
+func (p *PowerShell) CreateVM(ctx context.Context, spec VMSpec) (*VM, error) {
+	if spec.Name == "" {
+		return nil, fmt.Errorf("a virtual machine name is required")
+	}
+	generation, memoryMB, processorCount := spec.Generation, spec.MemoryMB, spec.ProcessorCount
+	if generation == 0 {
+		generation = 2
+	}
+	if memoryMB == 0 {
+		memoryMB = defaultMemoryMB
+	}
+	if processorCount == 0 {
+		processorCount = 1
+	}
+	pipeline := fmt.Sprintf("$vm = New-VM -Name %s -Generation %d -MemoryStartupBytes %dMB -NoVHD -ErrorAction Stop; "+
+		"Set-VMProcessor -VM $vm -Count %d -ErrorAction Stop; Get-VM -Id $vm.Id",
+		quote(spec.Name), generation, memoryMB, processorCount)
+	var found []psVM
+	if err := p.create(ctx, "New-VM", pipeline, vmProperties, &found); err != nil {
+		return nil, err
+	}
+	if len(found) == 0 {
+		return nil, fmt.Errorf("New-VM did not return virtual machine %s", spec.Name)
+	}
+	vm := found[0].vm()
+	return &vm, nil
+}
+
+func (p *PowerShell) SetVMState(ctx context.Context, name string, state PowerState) error {
+	var script string
+	switch state {
+	case PowerStateRunning:
+		script = fmt.Sprintf("$vm = Get-VM -Name %s -ErrorAction Stop; "+
+			"if ($vm.State -eq 'Paused') { Resume-VM -VM $vm -ErrorAction Stop } elseif ($vm.State -ne 'Running') { Start-VM -VM $vm -ErrorAction Stop }",
+			quote(name))
+	case PowerStateOff:
+		script = fmt.Sprintf("Stop-VM -Name %s -Force -ErrorAction Stop", quote(name))
+	case PowerStatePaused:
+		script = fmt.Sprintf("Suspend-VM -Name %s -ErrorAction Stop", quote(name))
+	case PowerStateSaved:
+		script = fmt.Sprintf("Save-VM -Name %s -ErrorAction Stop", quote(name))
+	default:
+		return fmt.Errorf("unsupported power state %s", state)
+	}
+	_, err := p.run(ctx, "SetVMState", script)
+	return err
+}
+
+func (p *PowerShell) SetVM(ctx context.Context, name string, settings VMSettings) error {
+	if err := settings.Validate(); err != nil {
+		return err
+	}
+	var commands []string
+	if settings.ProcessorCount != nil {
+		commands = append(commands, fmt.Sprintf("Set-VMProcessor -VMName %s -Count %d -ErrorAction Stop", quote(name), *settings.ProcessorCount))
+	}
+	var memoryArgs []string
+	if settings.DynamicMemory != nil {
+		memoryArgs = append(memoryArgs, "-DynamicMemoryEnabled "+boolean(*settings.DynamicMemory))
+	}
+	if settings.MemoryMB != nil {
+		memoryArgs = append(memoryArgs, fmt.Sprintf("-StartupBytes %dMB", *settings.MemoryMB))
+	}
+	if settings.MinimumMemoryMB != nil {
+		memoryArgs = append(memoryArgs, fmt.Sprintf("-MinimumBytes %dMB", *settings.MinimumMemoryMB))
+	}
+	if settings.MaximumMemoryMB != nil {
+		memoryArgs = append(memoryArgs, fmt.Sprintf("-MaximumBytes %dMB", *settings.MaximumMemoryMB))
+	}
+	if len(memoryArgs) > 0 {
+		commands = append(commands, fmt.Sprintf("Set-VMMemory -VMName %s %s -ErrorAction Stop", quote(name), strings.Join(memoryArgs, " ")))
+	}
+	var vmArgs []string
+	if settings.AutomaticStartAction != nil {
+		action, _ := AutomaticStartAction(*settings.AutomaticStartAction)
+		vmArgs = append(vmArgs, "-AutomaticStartAction "+startActions[action])
+	}
+	if settings.AutomaticStopAction != nil {
+		action, _ := AutomaticStopAction(*settings.AutomaticStopAction)
+		vmArgs = append(vmArgs, "-AutomaticStopAction "+stopActions[action])
+	}
+	if len(vmArgs) > 0 {
+		commands = append(commands, fmt.Sprintf("Set-VM -Name %s %s -ErrorAction Stop", quote(name), strings.Join(vmArgs, " ")))
+	}
+	if len(commands) == 0 {
+		return nil
+	}
+	_, err := p.runIdempotent(ctx, "Set-VM", strings.Join(commands, "; "))
+	return err
+}
+
+func (p *PowerShell) DeleteVM(ctx context.Context, name string) error {
+	_, err := p.run(ctx, "Remove-VM", fmt.Sprintf("Remove-VM -Name %s -Force -ErrorAction Stop", quote(name)))
+	return err
+}
+
+var diskProperties = strings.Join([]string{
+	"Path", asString("VhdFormat"), asString("VhdType"), "Size", "FileSize", "BlockSize",
+	"LogicalSectorSize", "PhysicalSectorSize", "ParentPath", "DiskIdentifier", "MinimumSize",
+}, ",")
+
+type psDisk struct {
+	Path               string
+	VhdFormat          string
+	VhdType            string
+	Size               uint64
+	FileSize           int64
+	BlockSize          uint32
+	LogicalSectorSize  uint32
+	PhysicalSectorSize uint32
+	ParentPath         string
+	DiskIdentifier     string
+	// MinimumSize is null for VHD files, which cannot shrink.
+	MinimumSize uint64
+}
+
+func (d psDisk) disk() *Disk {
+	return &Disk{Path: d.Path, Info: vhd.Info{
+		Format:             strings.ToUpper(d.VhdFormat),
+		DiskType:           d.VhdType,
+		VirtualSize:        d.Size,
+		PhysicalSize:       d.FileSize,
+		BlockSize:          d.BlockSize,
+		LogicalSectorSize:  d.LogicalSectorSize,
+		PhysicalSectorSize: d.PhysicalSectorSize,
+		ParentPath:         d.ParentPath,
+		DiskID:             d.DiskIdentifier,
+	}, MinimumSize: d.MinimumSize}
+}
+
+func (p *PowerShell) GetDisk(ctx context.Context, path string) (*Disk, error) {
+	var found []psDisk
+	if err := p.query(ctx, "Get-VHD", fmt.Sprintf("Get-VHD -Path %s -ErrorAction Stop", quote(path)), diskProperties, &found); err != nil {
+		return nil, err
+	}
+	if len(found) == 0 {
+		return nil, errs.New(errs.ErrNotFound, "Get-VHD", fmt.Sprintf("vhd [%s] not found", path))
+	}
+	return found[0].disk(), nil
+}
+
+func (p *PowerShell) CreateDisk(ctx context.Context, path string, opts vhd.CreateOptions) (*Disk, error) {
+	format, err := vhd.FormatForPath(path)
+	if err != nil {
+		return nil, err
+	}
+	if opts.Format != "" && !strings.EqualFold(opts.Format, format) {
+		return nil, fmt.Errorf("New-VHD creates a %s disk at [%s], not a %s disk", format, path, opts.Format)
+	}
+	diskType, err := vhd.NormalizeDiskType(opts.DiskType)
+	if err != nil {
+		return nil, err
+	}
+
+	cmd := fmt.Sprintf("New-VHD -Path %s", quote(path))
+	switch diskType {
+	case vhd.TypeDifferencing:
+		if opts.ParentPath == "" {
+			return nil, fmt.Errorf("a parent path is required for a differencing disk")
+		}
+		cmd += fmt.Sprintf(" -Differencing -ParentPath %s", quote(opts.ParentPath))
+	case vhd.TypeFixed:
+		cmd += fmt.Sprintf(" -Fixed -SizeBytes %d", opts.VirtualSize)
+	default:
+		cmd += fmt.Sprintf(" -Dynamic -SizeBytes %d", opts.VirtualSize)
+	}
+	if opts.BlockSize != 0 {
+		cmd += fmt.Sprintf(" -BlockSizeBytes %d", opts.BlockSize)
+	}
+	if diskType != vhd.TypeDifferencing {
+		if opts.LogicalSectorSize != 0 {
+			cmd += fmt.Sprintf(" -LogicalSectorSizeBytes %d", opts.LogicalSectorSize)
+		}
+		if opts.PhysicalSectorSize != 0 {
+			cmd += fmt.Sprintf(" -PhysicalSectorSizeBytes %d", opts.PhysicalSectorSize)
+		}
+	}
+	var found []psDisk
+	if err := p.create(ctx, "New-VHD", cmd+" -ErrorAction Stop", diskProperties, &found); err != nil {
+		return nil, err
+	}
+	if len(found) == 0 {
+		return nil, fmt.Errorf("New-VHD did not return vhd [%s]", path)
+	}
+	return found[0].disk(), nil
+}
+
+func (p *PowerShell) DeleteDisk(ctx context.Context, path string) error {
+	_, err := p.run(ctx, "Remove-Item", fmt.Sprintf("Get-Item -LiteralPath %s -ErrorAction Stop | Remove-Item -Force -ErrorAction Stop", quote(path)))
+	return err
+}
+
+// diskUsersScript lists the drives of virtual machines and checkpoints that use the disk at
+// $path. Checkpoints report no controller.
+const diskUsersScript = `Get-VM | ForEach-Object {
+  $vm = $_
+  Get-VMHardDiskDrive -VM $vm | Where-Object { $_.Path -eq $path } | ForEach-Object {
+    [pscustomobject]@{ VMName = $vm.Name; State = [string]$vm.State; Generation = $vm.Generation; ControllerType = [string]$_.ControllerType }
+  }
+  Get-VMSnapshot -VM $vm | Where-Object { $_.HardDrives.Path -contains $path } | ForEach-Object {
+    [pscustomobject]@{ VMName = $_.Name; State = [string]$vm.State; Generation = $vm.Generation; ControllerType = '' }
+  }
+}`
+
+func (p *PowerShell) ListDiskUsers(ctx context.Context, path string) ([]DiskUser, error) {
+	var users []DiskUser
+	pipeline := fmt.Sprintf("$path = %s; %s", quote(path), diskUsersScript)
+	if err := p.query(ctx, "Get-VMHardDiskDrive", pipeline, "*", &users); err != nil {
+		return nil, err
+	}
+	return users, nil
+}
+
+func (p *PowerShell) ResizeDisk(ctx context.Context, path string, size uint64) error {
+	_, err := p.runIdempotent(ctx, "Resize-VHD", fmt.Sprintf("Resize-VHD -Path %s -SizeBytes %d -ErrorAction Stop", quote(path), size))
+	return err
+}
+
+// convertDiskScript converts $path into a copy next to it, and swaps the copy in once it is
+// complete, keeping the original until then.
+const convertDiskScript = `$ext = [IO.Path]::GetExtension($path)
+$converted = $path.Substring(0, $path.Length - $ext.Length) + '.converting' + $ext
+$backup = $path + '.bak'
+Remove-Item -LiteralPath $converted -Force -ErrorAction SilentlyContinue
+try {
+  Convert-VHD -Path $path -DestinationPath $converted -VHDType $type -ErrorAction Stop
+} catch {
+  Remove-Item -LiteralPath $converted -Force -ErrorAction SilentlyContinue
+  throw
+}
+Move-Item -LiteralPath $path -Destination $backup -ErrorAction Stop
+try {
+  Move-Item -LiteralPath $converted -Destination $path -ErrorAction Stop
+} catch {
+  Move-Item -LiteralPath $backup -Destination $path
+  throw
+}
+Remove-Item -LiteralPath $backup -Force`
+
+func (p *PowerShell) ConvertDisk(ctx context.Context, path string, diskType string) error {
+	diskType, err := vhd.NormalizeDiskType(diskType)
+	if err != nil {
+		return err
+	}
+	_, err = p.run(ctx, "Convert-VHD", fmt.Sprintf("$path = %s; $type = %s\n%s", quote(path), quote(diskType), convertDiskScript))
+	return err
+}
+
+func (p *PowerShell) CompactDisk(ctx context.Context, path string) error {
+	_, err := p.run(ctx, "Optimize-VHD", fmt.Sprintf("Optimize-VHD -Path %s -Mode Full -ErrorAction Stop", quote(path)))
+	return err
+}
+
+func (p *PowerShell) MergeDisk(ctx context.Context, path string, destination string) error {
+	_, err := p.run(ctx, "Merge-VHD", fmt.Sprintf("Merge-VHD -Path %s -DestinationPath %s -ErrorAction Stop", quote(path), quote(destination)))
+	return err
+}
+
+// moveDiskScript moves $path to $dest and writes the path it was moved to.
+const moveDiskScript = `if ((Test-Path -LiteralPath $dest -PathType Container) -or $dest.EndsWith('\') -or $dest.EndsWith('/')) { $dest = Join-Path $dest (Split-Path -Leaf $path) }
+if (Test-Path -LiteralPath $dest) { throw "$dest already exists" }
+New-Item -ItemType Directory -Force -Path (Split-Path -Parent $dest) | Out-Null
+Move-Item -LiteralPath $path -Destination $dest -ErrorAction Stop
+$dest`
+
+func (p *PowerShell) MoveDisk(ctx context.Context, path string, destination string) (string, error) {
+	output, err := p.run(ctx, "Move-Item", fmt.Sprintf("$path = %s; $dest = %s\n%s", quote(path), quote(destination), moveDiskScript))
+	if err != nil {
+		return "", err
+	}
+	lines := strings.Split(strings.TrimSpace(output), "\n")
+	return strings.TrimSpace(lines[len(lines)-1]), nil
+}
+
+var driveProperties = strings.Join([]string{"Path", asString("ControllerType"), "ControllerNumber", "ControllerLocation"}, ",")
+
+func (p *PowerShell) ListDiskDrives(ctx context.Context, vmName string) ([]DiskDrive, error) {
+	var drives []DiskDrive
+	// Pass-through disks have a DiskNumber instead of a virtual hard disk.
+	pipeline := fmt.Sprintf("Get-VMHardDiskDrive -VMName %s -ErrorAction Stop | Where-Object { $null -eq $_.DiskNumber }", quote(vmName))
+	if err := p.query(ctx, "Get-VMHardDiskDrive", pipeline, driveProperties, &drives); err != nil {
+		return nil, err
+	}
+	return drives, nil
+}
+
+// AttachDisk attaches a disk with Add-VMHardDiskDrive, which cannot attach a disk read-only.
+// Read-only drives are refused rather than attached read-write.
+func (p *PowerShell) AttachDisk(ctx context.Context, vmName string, drive DiskDrive) (*DiskDrive, error) {
+	if err := drive.Settings.Validate(); err != nil {
+		return nil, err
+	}
+	if drive.Settings.readOnly() {
+		return nil, ReadOnlyUnsupported("Add-VMHardDiskDrive", drive.Path)
+	}
+	controllerType := strings.ToUpper(drive.ControllerType)
+	if controllerType == "" {
+		controllerType = ControllerSCSI
+	}
+	if controllerType != ControllerSCSI && controllerType != ControllerIDE {
+		return nil, fmt.Errorf("unsupported controller type [%s], must be SCSI or IDE", drive.ControllerType)
+	}
+	cmd := fmt.Sprintf("Add-VMHardDiskDrive -VMName %s -Path %s -ControllerType %s -ControllerNumber %d",
+		quote(vmName), quote(drive.Path), controllerType, drive.ControllerNumber)
+	if drive.ControllerLocation >= 0 {
+		cmd += fmt.Sprintf(" -ControllerLocation %d", drive.ControllerLocation)
+	}
+	if args := drive.Settings.PowerShellArgs(); args != "" {
+		cmd += " " + args
+	}
+	var attached []DiskDrive
+	if err := p.create(ctx, "Add-VMHardDiskDrive", cmd+" -Passthru -ErrorAction Stop", driveProperties, &attached); err != nil {
+		return nil, err
+	}
+	if len(attached) == 0 {
+		return nil, fmt.Errorf("Add-VMHardDiskDrive did not return the drive of vhd [%s]", drive.Path)
+	}
+	return &attached[0], nil
+}
+
+func (p *PowerShell) DetachDisk(ctx context.Context, vmName string, path string) error {
+	_, err := p.run(ctx, "Remove-VMHardDiskDrive", fmt.Sprintf(
+		"Get-VMHardDiskDrive -VMName %s -ErrorAction Stop | Where-Object { $_.Path -eq %s } | Remove-VMHardDiskDrive -ErrorAction Stop",
+		quote(vmName), quote(path)))
+	return err
+}
+
+func (p *PowerShell) SetDiskDrive(ctx context.Context, vmName string, path string, settings DiskDriveSettings) error {
+	if err := settings.Validate(); err != nil {
+		return err
+	}
+	if settings.readOnly() {
+		return ReadOnlyUnsupported("Set-VMHardDiskDrive", path)
+	}
+	args := settings.PowerShellArgs()
+	if args == "" {
+		return nil
+	}
+	_, err := p.runIdempotent(ctx, "Set-VMHardDiskDrive", fmt.Sprintf(
+		"Get-VMHardDiskDrive -VMName %s -ErrorAction Stop | Where-Object { $_.Path -eq %s } | Set-VMHardDiskDrive %s -ErrorAction Stop",
+		quote(vmName), quote(path), args))
+	return err
+}
+
+func (p *PowerShell) SetSCSIControllerCount(ctx context.Context, vmName string, count int) error {
+	if count < 0 || count > MaxSCSIControllers {
+		return errs.New(errs.ErrInvalidParameter, "Add-VMScsiController", fmt.Sprintf("the SCSI controller count must be between 0 and %d", MaxSCSIControllers))
+	}
+	_, err := p.run(ctx, "Add-VMScsiController", fmt.Sprintf(`$vmName = %s
+$count = %d
+$controllers = @(Get-VMScsiController -VMName $vmName -ErrorAction Stop)
+for ($i = $controllers.Count; $i -lt $count; $i++) {
+	Add-VMScsiController -VMName $vmName -ErrorAction Stop
+}
+for ($i = $controllers.Count - 1; $i -ge $count; $i--) {
+	if (@($controllers[$i].Drives).Count -gt 0) {
+		throw "cannot remove SCSI controller $i while drives are attached to it"
+	}
+	$controllers[$i] | Remove-VMScsiController -ErrorAction Stop
+}`, quote(vmName), count))
+	return err
+}
+
+type psHostDisk struct {
+	Number       uint32
+	UniqueID     string `json:"UniqueId"`
+	FriendlyName string
+	Size         uint64
+	IsOffline    bool
+	IsSystem     bool
+	IsBoot       bool
+}
+
+// HostDisks returns the physical disks of the host, as Get-Disk reports them.
+func (p *PowerShell) HostDisks(ctx context.Context) ([]passthrough.Disk, error) {
+	var found []psHostDisk
+	if err := p.query(ctx, "Get-Disk", "Get-Disk", "Number,UniqueId,FriendlyName,Size,IsOffline,IsSystem,IsBoot", &found); err != nil {
+		return nil, err
+	}
+	disks := make([]passthrough.Disk, 0, len(found))
+	for _, d := range found {
+		disks = append(disks, passthrough.Disk{
+			Number:       d.Number,
+			UniqueID:     d.UniqueID,
+			FriendlyName: d.FriendlyName,
+			Size:         d.Size,
+			IsOffline:    d.IsOffline,
+			IsSystem:     d.IsSystem || d.IsBoot,
+		})
+	}
+	return disks, nil
+}
+
+func (p *PowerShell) AttachPassThroughDisk(ctx context.Context, vmName string, spec passthrough.Spec) (*passthrough.Disk, error) {
+	disks, err := p.HostDisks(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list the disks of the host: %w", err)
+	}
+	disk, err := passthrough.FindDisk(disks, spec)
+	if err != nil {
+		return nil, errs.Wrap(errs.ErrInvalidParameter, "Add-VMHardDiskDrive", err)
+	}
+	controllerType := strings.ToUpper(spec.ControllerType)
+	if controllerType == "" {
+		controllerType = ControllerSCSI
+	}
+	add := fmt.Sprintf("Add-VMHardDiskDrive -VMName $vmName -DiskNumber %d -ControllerType %s -ControllerNumber %d",
+		disk.Number, controllerType, spec.ControllerNumber)
+	if spec.ControllerLocation != nil {
+		add += fmt.Sprintf(" -ControllerLocation %d", *spec.ControllerLocation)
+	}
+	_, err = p.run(ctx, "Add-VMHardDiskDrive", fmt.Sprintf(`$vmName = %s
+if (-not (Get-VMHardDiskDrive -VMName $vmName -ErrorAction Stop | Where-Object { $_.DiskNumber -eq %d })) {
+	%s -ErrorAction Stop
+}`, quote(vmName), disk.Number, add))
+	if err != nil {
+		return nil, err
+	}
+	return &disk, nil
+}
+
+func (p *PowerShell) DetachPassThroughDisk(ctx context.Context, vmName string, spec passthrough.Spec) error {
+	disks, err := p.HostDisks(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to list the disks of the host: %w", err)
+	}
+	disk := passthrough.Lookup(disks, spec)
+	if disk == nil {
+		return nil
+	}
+	_, err = p.run(ctx, "Remove-VMHardDiskDrive", fmt.Sprintf(
+		"Get-VMHardDiskDrive -VMName %s -ErrorAction Stop | Where-Object { $_.DiskNumber -eq %d } | Remove-VMHardDiskDrive -ErrorAction Stop",
+		quote(vmName), disk.Number))
+	return err
+}
+
+var switchProperties = strings.Join([]string{
+	"Name", asString("Id"), asString("SwitchType"),
+	"@{n='NetAdapterName';e={if ($_.NetAdapterInterfaceDescription) { (Get-NetAdapter -InterfaceDescription $_.NetAdapterInterfaceDescription -ErrorAction SilentlyContinue).Name }}}",
+	"AllowManagementOS", "Notes", asString("BandwidthReservationMode"), "IovEnabled",
+}, ",")
+
+type psSwitch struct {
+	Name                     string
+	ID                       string `json:"Id"`
+	SwitchType               string
+	NetAdapterName           string
+	AllowManagementOS        bool
+	Notes                    string
+	BandwidthReservationMode string
+	IovEnabled               bool
+}
+
+func (s psSwitch) vswitch() Switch {
+	return Switch{
+		ID:                       s.ID,
+		Name:                     s.Name,
+		SwitchType:               s.SwitchType,
+		NetAdapterName:           s.NetAdapterName,
+		AllowManagementOS:        s.AllowManagementOS,
+		Notes:                    s.Notes,
+		BandwidthReservationMode: s.BandwidthReservationMode,
+		IovEnabled:               s.IovEnabled,
+	}
+}
+
+func (p *PowerShell) ListSwitches(ctx context.Context) ([]Switch, error) {
+	var found []psSwitch
+	if err := p.query(ctx, "Get-VMSwitch", "Get-VMSwitch", switchProperties, &found); err != nil {
+		return nil, err
+	}
+	switches := make([]Switch, 0, len(found))
+	for _, s := range found {
+		switches = append(switches, s.vswitch())
+	}
+	return switches, nil
+}
+
+func (p *PowerShell) GetSwitch(ctx context.Context, name string) (*Switch, error) {
+	var found []psSwitch
+	pipeline := fmt.Sprintf("Get-VMSwitch | Where-Object { $_.Name -eq %s }", quote(name))
+	if err := p.query(ctx, "Get-VMSwitch", pipeline, switchProperties, &found); err != nil {
+		return nil, err
+	}
+	if len(found) == 0 {
+		return nil, errs.New(errs.ErrNotFound, "Get-VMSwitch", fmt.Sprintf("virtual switch %s not found", name))
+	}
+	sw := found[0].vswitch()
+	return &sw, nil
+}
+
+type psHostInfo struct {
+	ComputerName          string
+	LogicalProcessorCount int
+	MemoryCapacityBytes   uint64
+	VirtualHardDiskPath   string
+	VirtualMachinePath    string
+}
+
+func (p *PowerShell) GetHostInfo(ctx context.Context) (*HostInfo, error) {
+	var found []psHostInfo
+	properties := "ComputerName,LogicalProcessorCount,@{n='MemoryCapacityBytes';e={[uint64]$_.MemoryCapacity}},VirtualHardDiskPath,VirtualMachinePath"
+	if err := p.query(ctx, "Get-VMHost", "Get-VMHost", properties, &found); err != nil {
+		return nil, err
+	}
+	if len(found) == 0 {
+		return nil, fmt.Errorf("Get-VMHost returned nothing")
+	}
+	info := HostInfo(found[0])
+	return &info, nil
+}
+
+// netAdapterProperties select the properties of a PhysicalNetAdapter from Get-NetAdapter, and
+// the name of the external switch bound to it from Get-VMSwitch.
+var netAdapterProperties = strings.Join([]string{
+	"Name", "InterfaceDescription", "MacAddress", asString("Status"),
+	"@{n='LinkSpeedBps';e={[uint64]$_.Speed}}",
+	"@{n='SwitchName';e={$description = $_.InterfaceDescription; (Get-VMSwitch -ErrorAction SilentlyContinue | Where-Object { $_.NetAdapterInterfaceDescription -eq $description } | Select-Object -First 1).Name}}",
+}, ",")
+
+func (p *PowerShell) ListPhysicalNetAdapters(ctx context.Context) ([]PhysicalNetAdapter, error) {
+	var adapters []PhysicalNetAdapter
+	if err := p.query(ctx, "Get-NetAdapter", "Get-NetAdapter -Physical", netAdapterProperties, &adapters); err != nil {
+		return nil, err
+	}
+	return adapters, nil
+}
+
+// switchArgs returns the parameters of New-VMSwitch and Set-VMSwitch for spec.
+func switchArgs(spec Switch) (string, error) {
+	var args string
+	switch {
+	case strings.EqualFold(spec.SwitchType, SwitchTypeExternal):
+		if spec.NetAdapterName == "" {
+			return "", fmt.Errorf("netAdapterName is required for External switches")
+		}
+		args = fmt.Sprintf(" -NetAdapterName %s -AllowManagementOS %s", quote(spec.NetAdapterName), boolean(spec.AllowManagementOS))
+	case strings.EqualFold(spec.SwitchType, SwitchTypeInternal):
+		args = " -SwitchType Internal"
+	case strings.EqualFold(spec.SwitchType, SwitchTypePrivate):
+		args = " -SwitchType Private"
+	default:
+		return "", fmt.Errorf("invalid switch type: %s. Must be 'External', 'Internal', or 'Private'", spec.SwitchType)
+	}
+	return args + fmt.Sprintf(" -Notes %s", quote(spec.Notes)), nil
+}
+
+func (p *PowerShell) CreateSwitch(ctx context.Context, spec Switch) (*Switch, error) {
+	if spec.Name == "" {
+		return nil, fmt.Errorf("a virtual switch name is required")
+	}
+	args, err := switchArgs(spec)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := p.run(ctx, "New-VMSwitch", fmt.Sprintf("New-VMSwitch -Name %s%s -ErrorAction Stop | Out-Null", quote(spec.Name), args)); err != nil {
+		return nil, err
+	}
+	return p.GetSwitch(ctx, spec.Name)
+}
+
+func (p *PowerShell) UpdateSwitch(ctx context.Context, name string, spec Switch) (*Switch, error) {
+	args, err := switchArgs(spec)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := p.GetSwitch(ctx, name); err != nil {
+		return nil, err
+	}
+	if _, err := p.runIdempotent(ctx, "Set-VMSwitch", fmt.Sprintf("Set-VMSwitch -Name %s%s -ErrorAction Stop", quote(name), args)); err != nil {
+		return nil, err
+	}
+	return p.GetSwitch(ctx, name)
+}
+
+func (p *PowerShell) DeleteSwitch(ctx context.Context, name string) error {
+	if _, err := p.GetSwitch(ctx, name); err != nil {
+		return err
+	}
+	_, err := p.run(ctx, "Remove-VMSwitch", fmt.Sprintf("Remove-VMSwitch -Name %s -Force -ErrorAction Stop", quote(name)))
+	return err
+}
+
+var adapterProperties = "Name,VMName,SwitchName,MacAddress,@{n='DynamicMacAddress';e={$_.DynamicMacAddressEnabled}}"
+
+func (p *PowerShell) ListNetworkAdapters(ctx context.Context, vmName string) ([]NetworkAdapter, error) {
+	var adapters []NetworkAdapter
+	if err := p.query(ctx, "Get-VMNetworkAdapter", fmt.Sprintf("Get-VMNetworkAdapter -VMName %s -ErrorAction Stop", quote(vmName)), adapterProperties, &adapters); err != nil {
+		return nil, err
+	}
+	return adapters, nil
+}
+
+// adapterStatusProperties reads the VLAN with Get-VMNetworkAdapterVlan, once per adapter. Its
+// mode is the private VLAN mode when the port is in a private VLAN, and the lists of VLAN IDs
+// are joined with commas, which keeps them within the depth of the JSON document. The guards
+// and the priority tag are On or Off.
+var adapterStatusProperties = strings.Join([]string{
+	"@{n='Vlan';e={$v = Get-VMNetworkAdapterVlan -VMNetworkAdapter $_; $mode = \"$($v.OperationMode)\"; " +
+		"if ($mode -eq 'Private') { $mode = \"$($v.PrivateVlanMode)\" }; " +
+		"[pscustomobject]@{Mode=$mode; AccessVlanId=$v.AccessVlanId; NativeVlanId=$v.NativeVlanId; " +
+		"AllowedVlanIdList=($v.AllowedVlanIdList -join ','); PrimaryVlanId=$v.PrimaryVlanId; " +
+		"SecondaryVlanId=$v.SecondaryVlanId; SecondaryVlanIdList=($v.SecondaryVlanIdList -join ',')}}}",
+	asString("DhcpGuard"), asString("RouterGuard"), asString("PortMirroringMode"), asString("IeeePriorityTag"),
+	"VmqWeight", "@{n='IPAddresses';e={@($_.IPAddresses)}}", asString("Status"),
+}, ",")
+
+type psAdapterStatus struct {
+	Vlan              psVlan
+	DhcpGuard         string
+	RouterGuard       string
+	PortMirroringMode string
+	IeeePriorityTag   string
+	VmqWeight         int
+	IPAddresses       []string
+	Status            string
+}
+
+type psVlan struct {
+	Mode                string
+	AccessVlanId        int
+	NativeVlanId        int
+	AllowedVlanIdList   string
+	PrimaryVlanId       int
+	SecondaryVlanId     int
+	SecondaryVlanIdList string
+}
+
+// settings returns the IDs of the mode of the VLAN.
+func (v psVlan) settings() (VlanSettings, error) {
+	settings := VlanSettings{Mode: v.Mode}
+	switch {
+	case strings.EqualFold(v.Mode, VlanAccess):
+		settings.AccessVlanID = v.AccessVlanId
+	case strings.EqualFold(v.Mode, VlanTrunk):
+		settings.NativeVlanID = v.NativeVlanId
+		allowed, err := parseIDs(v.AllowedVlanIdList)
+		if err != nil {
+			return settings, fmt.Errorf("invalid allowed VLAN IDs: %w", err)
+		}
+		settings.AllowedVlanIDs = allowed
+	case strings.EqualFold(v.Mode, VlanIsolated), strings.EqualFold(v.Mode, VlanCommunity):
+		settings.PrimaryVlanID = v.PrimaryVlanId
+		settings.SecondaryVlanID = v.SecondaryVlanId
+	case strings.EqualFold(v.Mode, VlanPromiscuous):
+		settings.PrimaryVlanID = v.PrimaryVlanId
+		secondary, err := parseIDs(v.SecondaryVlanIdList)
+		if err != nil {
+			return settings, fmt.Errorf("invalid secondary VLAN IDs: %w", err)
+		}
+		settings.SecondaryVlanIDs = secondary
+	}
+	return settings, nil
+}
+
+// parseIDs parses a comma separated list of IDs.
+func parseIDs(list string) ([]int, error) {
+	var ids []int
+	for _, field := range strings.Split(list, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		id, err := strconv.Atoi(field)
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+func (p *PowerShell) GetNetworkAdapterStatus(ctx context.Context, vmName string, adapterName string) (*NetworkAdapterStatus, error) {
+	var found []psAdapterStatus
+	pipeline := fmt.Sprintf("Get-VMNetworkAdapter -VMName %s -ErrorAction Stop | Where-Object { $_.Name -eq %s }", quote(vmName), quote(adapterName))
+	if err := p.query(ctx, "Get-VMNetworkAdapter", pipeline, adapterStatusProperties, &found); err != nil {
+		return nil, err
+	}
+	if len(found) == 0 {
+		return nil, errs.New(errs.ErrNotFound, "Get-VMNetworkAdapter",
+			fmt.Sprintf("network adapter %s of virtual machine %s not found", adapterName, vmName))
+	}
+	adapter := found[0]
+	vlan, err := adapter.Vlan.settings()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the VLAN of network adapter %s of virtual machine %s: %w", adapterName, vmName, err)
+	}
+	return &NetworkAdapterStatus{
+		Vlan:            vlan,
+		DHCPGuard:       strings.EqualFold(adapter.DhcpGuard, "On"),
+		RouterGuard:     strings.EqualFold(adapter.RouterGuard, "On"),
+		PortMirroring:   adapter.PortMirroringMode,
+		IeeePriorityTag: strings.EqualFold(adapter.IeeePriorityTag, "On"),
+		VMQWeight:       adapter.VmqWeight,
+		IPAddresses:     adapter.IPAddresses,
+		Status:          adapter.Status,
+	}, nil
+}
+
+func (p *PowerShell) ListConnectedAdapters(ctx context.Context, switchName string) ([]NetworkAdapter, error) {
+	if _, err := p.GetSwitch(ctx, switchName); err != nil {
+		return nil, err
+	}
+	var adapters []NetworkAdapter
+	pipeline := fmt.Sprintf("Get-VM | Get-VMNetworkAdapter | Where-Object { $_.SwitchName -eq %s } | Sort-Object VMName", quote(switchName))
+	if err := p.query(ctx, "Get-VMNetworkAdapter", pipeline, adapterProperties, &adapters); err != nil {
+		return nil, err
+	}
+	return adapters, nil
+}
+
+func (p *PowerShell) AddNetworkAdapter(ctx context.Context, adapter NetworkAdapter) (*NetworkAdapter, error) {
+	if adapter.Name == "" {
+		adapter.Name = "Network Adapter"
+	}
+	cmd := fmt.Sprintf("Add-VMNetworkAdapter -VMName %s -Name %s", quote(adapter.VMName), quote(adapter.Name))
+	if adapter.SwitchName != "" {
+		cmd += fmt.Sprintf(" -SwitchName %s", quote(adapter.SwitchName))
+	}
+	if adapter.MacAddress != "" {
+		cmd += fmt.Sprintf(" -StaticMacAddress %s", quote(adapter.MacAddress))
+	}
+	var added []NetworkAdapter
+	if err := p.create(ctx, "Add-VMNetworkAdapter", cmd+" -Passthru -ErrorAction Stop", adapterProperties, &added); err != nil {
+		return nil, err
+	}
+	if len(added) == 0 {
+		return nil, fmt.Errorf("Add-VMNetworkAdapter did not return network adapter %s", adapter.Name)
+	}
+	return &added[0], nil
+}
+
+func (p *PowerShell) ConnectNetworkAdapter(ctx context.Context, vmName string, adapterName string, switchName string) error {
+	if switchName == "" {
+		_, err := p.run(ctx, "Disconnect-VMNetworkAdapter", fmt.Sprintf("Disconnect-VMNetworkAdapter -VMName %s -Name %s -ErrorAction Stop", quote(vmName), quote(adapterName)))
+		return err
+	}
+	_, err := p.run(ctx, "Connect-VMNetworkAdapter", fmt.Sprintf("Connect-VMNetworkAdapter -VMName %s -Name %s -SwitchName %s -ErrorAction Stop",
+		quote(vmName), quote(adapterName), quote(switchName)))
+	return err
+}
+
+// SetNetworkAdapter applies the VLAN with Set-VMNetworkAdapterVlan and the other settings
+// with Set-VMNetworkAdapter.
+func (p *PowerShell) SetNetworkAdapter(ctx context.Context, vmName string, adapterName string, settings NetworkAdapterSettings) error {
+	if settings.Vlan != nil {
+		script := fmt.Sprintf("Set-VMNetworkAdapterVlan -VMName %s -VMNetworkAdapterName %s %s -ErrorAction Stop",
+			quote(vmName), quote(adapterName), vlanArgs(*settings.Vlan))
+		if _, err := p.runIdempotent(ctx, "Set-VMNetworkAdapterVlan", script); err != nil {
+			return err
+		}
+	}
+
+	var args []string
+	if settings.MacAddress != nil {
+		args = append(args, "-StaticMacAddress "+quote(*settings.MacAddress))
+	}
+	if settings.DHCPGuard != nil {
+		args = append(args, "-DhcpGuard "+onOff(*settings.DHCPGuard))
+	}
+	if settings.RouterGuard != nil {
+		args = append(args, "-RouterGuard "+onOff(*settings.RouterGuard))
+	}
+	if settings.PortMirroring != nil {
+		args = append(args, "-PortMirroring "+quote(*settings.PortMirroring))
+	}
+	if settings.IeeePriorityTag != nil {
+		args = append(args, "-IeeePriorityTag "+onOff(*settings.IeeePriorityTag))
+	}
+	if settings.VMQWeight != nil {
+		args = append(args, fmt.Sprintf("-VmqWeight %d", *settings.VMQWeight))
+	}
+	if len(args) == 0 {
+		return nil
+	}
+	_, err := p.runIdempotent(ctx, "Set-VMNetworkAdapter", fmt.Sprintf("Set-VMNetworkAdapter -VMName %s -Name %s %s -ErrorAction Stop",
+		quote(vmName), quote(adapterName), strings.Join(args, " ")))
+	return err
+}
+
+// vlanArgs returns the arguments of Set-VMNetworkAdapterVlan for a VLAN.
+func vlanArgs(vlan VlanSettings) string {
+	switch vlan.Mode {
+	case VlanAccess:
+		return fmt.Sprintf("-Access -VlanId %d", vlan.AccessVlanID)
+	case VlanTrunk:
+		return fmt.Sprintf("-Trunk -AllowedVlanIdList '%s' -NativeVlanId %d", FormatVlanList(vlan.AllowedVlanIDs), vlan.NativeVlanID)
+	case VlanIsolated, VlanCommunity:
+		return fmt.Sprintf("-%s -PrimaryVlanId %d -SecondaryVlanId %d", vlan.Mode, vlan.PrimaryVlanID, vlan.SecondaryVlanID)
+	case VlanPromiscuous:
+		return fmt.Sprintf("-Promiscuous -PrimaryVlanId %d -SecondaryVlanIdList '%s'", vlan.PrimaryVlanID, FormatVlanList(vlan.SecondaryVlanIDs))
+	}
+	return "-Untagged"
+}
+
+func onOff(b bool) string {
+	if b {
+		return "On"
+	}
+	return "Off"
+}
+
+func (p *PowerShell) RemoveNetworkAdapter(ctx context.Context, vmName string, adapterName string) error {
+	_, err := p.run(ctx, "Remove-VMNetworkAdapter", fmt.Sprintf("Remove-VMNetworkAdapter -VMName %s -Name %s -ErrorAction Stop", quote(vmName), quote(adapterName)))
+	return err
+}
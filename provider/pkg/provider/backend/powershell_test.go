@@ -0,0 +1,405 @@
+// Copyright 2016-2022, Pulumi Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/pulumi/pulumi-hyperv/provider/pkg/provider/errs"
+	"github.com/pulumi/pulumi-hyperv/provider/pkg/provider/vhd"
+)
+
+func ptr[T any](v T) *T {
+	return &v
+}
+
+// fakeRunner records the scripts it runs and answers them with the output of the first
+// response whose key the script contains.
+type fakeRunner struct {
+	scripts   []string
+	responses map[string]string
+	err       error
+}
+
+func (f *fakeRunner) run(ctx context.Context, script string) (string, error) {
+	f.scripts = append(f.scripts, script)
+	if f.err != nil {
+		return "", f.err
+	}
+	for key, output := range f.responses {
+		if strings.Contains(script, key) {
+			return output, nil
+		}
+	}
+	return "", nil
+}
+
+func TestPowerShellVMs(t *testing.T) {
+	ctx := context.Background()
+	f := &fakeRunner{responses: map[string]string{
+		"Get-VM": "WARNING: the integration services are out of date\n" +
+			`[{"Name":"it's","Id":"1e0b8ab5-4dd3-4b5e-a1b8-0e4a1f1b6f0c","Generation":2,"State":"Running","MemoryMB":2048,"ProcessorCount":4,` +
+			`"DynamicMemoryEnabled":true,"MinimumMemoryMB":512,"MaximumMemoryMB":8192,"AutomaticStartAction":"Start","AutomaticStopAction":"ShutDown"}]`,
+	}}
+	p := NewPowerShell(f.run)
+
+	vm, err := p.GetVM(ctx, "it's")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if vm.Name != "it's" || vm.State != PowerStateRunning || vm.MemoryMB != 2048 || vm.ProcessorCount != 4 {
+		t.Errorf("GetVM = %+v", vm)
+	}
+	if !vm.DynamicMemory || vm.MaximumMemoryMB != 8192 || vm.AutomaticStartAction != "Start" || vm.AutomaticStopAction != "ShutDown" {
+		t.Errorf("GetVM settings = %+v", vm)
+	}
+	if !strings.Contains(f.scripts[0], "$_.Name -eq 'it''s'") || !strings.Contains(f.scripts[0], "ConvertTo-Json") {
+		t.Errorf("GetVM ran %q, want the name quoted in a filter", f.scripts[0])
+	}
+
+	f.responses["Get-VM"] = ""
+	if _, err := p.GetVM(ctx, "db"); !errors.Is(err, errs.ErrNotFound) {
+		t.Errorf("GetVM of a missing machine = %v, want ErrNotFound", err)
+	}
+
+	f.scripts = nil
+	if err := p.SetVMState(ctx, "web", PowerStateSaved); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.SetVMState(ctx, "web", PowerStateOff); err != nil {
+		t.Fatal(err)
+	}
+	if len(f.scripts) != 2 || !strings.HasPrefix(f.scripts[0], "Save-VM -Name 'web'") || !strings.HasPrefix(f.scripts[1], "Stop-VM -Name 'web' -Force") {
+		t.Errorf("SetVMState ran %q", f.scripts)
+	}
+}
+
+func TestPowerShellRunIdempotent(t *testing.T) {
+	ctx := context.Background()
+	once := &fakeRunner{responses: map[string]string{
+		"New-VM": `[{"Name":"web","Id":"1e0b8ab5-4dd3-4b5e-a1b8-0e4a1f1b6f0c","Generation":2,"State":"Off"}]`,
+	}}
+	retried := &fakeRunner{responses: map[string]string{
+		"Get-VM": `[{"Name":"web","Id":"1e0b8ab5-4dd3-4b5e-a1b8-0e4a1f1b6f0c","Generation":2,"State":"Off"}]`,
+	}}
+	p := NewPowerShell(once.run)
+	p.RunIdempotent = retried.run
+
+	if _, err := p.CreateVM(ctx, VMSpec{Name: "web", Generation: 2}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.GetVM(ctx, "web"); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.SetVM(ctx, "web", VMSettings{ProcessorCount: ptr(2)}); err != nil {
+		t.Fatal(err)
+	}
+	for _, script := range retried.scripts {
+		if strings.Contains(script, "New-VM") {
+			t.Errorf("New-VM ran with RunIdempotent: %q", script)
+		}
+	}
+	if len(once.scripts) != 1 || !strings.Contains(once.scripts[0], "New-VM") {
+		t.Errorf("Run ran %q, want only New-VM", once.scripts)
+	}
+	if len(retried.scripts) != 2 || !strings.Contains(retried.scripts[1], "Set-VM") {
+		t.Errorf("RunIdempotent ran %q, want Get-VM and Set-VM", retried.scripts)
+	}
+}
+
+func TestPowerShellDisks(t *testing.T) {
+	ctx := context.Background()
+	f := &fakeRunner{responses: map[string]string{
+		"New-VHD": `[{"Path":"C:\\vms\\web.vhdx","VhdFormat":"VHDX","VhdType":"Fixed","Size":1073741824,"FileSize":1077936128,` +
+			`"BlockSize":0,"LogicalSectorSize":512,"PhysicalSectorSize":4096,"ParentPath":"","DiskIdentifier":"A1B2"}]`,
+		"Add-VMHardDiskDrive": `[{"Path":"C:\\vms\\web.vhdx","ControllerType":"SCSI","ControllerNumber":0,"ControllerLocation":3}]`,
+	}}
+	p := NewPowerShell(f.run)
+
+	if _, err := p.CreateDisk(ctx, `C:\vms\web.vhdx`, vhd.CreateOptions{Format: vhd.FormatVHD, VirtualSize: 1 << 30}); err == nil {
+		t.Error("CreateDisk of a VHD at a .vhdx path succeeded")
+	}
+	disk, err := p.CreateDisk(ctx, `C:\vms\web.vhdx`, vhd.CreateOptions{DiskType: "fixed", VirtualSize: 1 << 30, LogicalSectorSize: 512})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if disk.Format != vhd.FormatVHDX || disk.DiskType != vhd.TypeFixed || disk.VirtualSize != 1<<30 || disk.DiskID != "A1B2" {
+		t.Errorf("CreateDisk = %+v", disk)
+	}
+	if want := `New-VHD -Path 'C:\vms\web.vhdx' -Fixed -SizeBytes 1073741824 -LogicalSectorSizeBytes 512 -ErrorAction Stop`; !strings.Contains(f.scripts[0], want) {
+		t.Errorf("CreateDisk ran %q, want it to contain %q", f.scripts[0], want)
+	}
+
+	drive, err := p.AttachDisk(ctx, "web", DiskDrive{Path: `C:\vms\web.vhdx`, ControllerLocation: -1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if drive.ControllerType != ControllerSCSI || drive.ControllerLocation != 3 {
+		t.Errorf("AttachDisk = %+v", drive)
+	}
+	if script := f.scripts[len(f.scripts)-1]; strings.Contains(script, "-ControllerLocation") {
+		t.Errorf("AttachDisk ran %q, want Hyper-V to pick the location", script)
+	}
+
+	// The cmdlets cannot attach a disk read-only, so it is refused instead of attached
+	// read-write.
+	readOnly := &DiskDriveSettings{ReadOnly: ptr(true)}
+	ran := len(f.scripts)
+	if _, err := p.AttachDisk(ctx, "web", DiskDrive{Path: `C:\vms\data.vhdx`, ControllerLocation: -1, Settings: readOnly}); !errors.Is(err, errs.ErrNotSupported) {
+		t.Errorf("AttachDisk read-only = %v, want ErrNotSupported", err)
+	}
+	if err := p.SetDiskDrive(ctx, "web", `C:\vms\web.vhdx`, *readOnly); !errors.Is(err, errs.ErrNotSupported) {
+		t.Errorf("SetDiskDrive read-only = %v, want ErrNotSupported", err)
+	}
+	if len(f.scripts) != ran {
+		t.Errorf("read-only drives ran %q", f.scripts[ran:])
+	}
+	if err := CheckDiskDriveSettings(p, `C:\vms\data.vhdx`, readOnly); !errors.Is(err, errs.ErrNotSupported) {
+		t.Errorf("CheckDiskDriveSettings(powershell) = %v, want ErrNotSupported", err)
+	}
+	if err := CheckDiskDriveSettings(NewSimulator(), `C:\vms\data.vhdx`, readOnly); err != nil {
+		t.Errorf("CheckDiskDriveSettings(simulator) = %v", err)
+	}
+	if err := CheckDiskDriveSettings(p, `C:\vms\data.vhdx`, &DiskDriveSettings{ReadOnly: ptr(false)}); err != nil {
+		t.Errorf("CheckDiskDriveSettings of a writable drive = %v", err)
+	}
+}
+
+func TestPowerShellDiskMaintenance(t *testing.T) {
+	ctx := context.Background()
+	f := &fakeRunner{responses: map[string]string{
+		"Get-VMSnapshot": `[{"VMName":"web","State":"Running","Generation":2,"ControllerType":"SCSI"},{"VMName":"before upgrade","State":"Running","Generation":2,"ControllerType":""}]`,
+		"Move-Item":      "Directory: D:\\backup\nD:\\backup\\it's.vhdx\n",
+	}}
+	p := NewPowerShell(f.run)
+
+	users, err := p.ListDiskUsers(ctx, `C:\vms\it's.vhdx`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(users) != 2 || users[0].State != PowerStateRunning || users[0].ControllerType != ControllerSCSI || users[1].ControllerType != "" {
+		t.Errorf("ListDiskUsers = %+v", users)
+	}
+	if !strings.Contains(f.scripts[0], `$path = 'C:\vms\it''s.vhdx'`) {
+		t.Errorf("ListDiskUsers ran %q, want the path quoted", f.scripts[0])
+	}
+
+	f.scripts = nil
+	if err := p.ResizeDisk(ctx, `C:\vms\web.vhdx`, 2<<30); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.ConvertDisk(ctx, `C:\vms\web.vhdx`, "fixed"); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.ConvertDisk(ctx, `C:\vms\web.vhdx`, "sparse"); err == nil {
+		t.Error("ConvertDisk to an unknown type succeeded")
+	}
+	if err := p.CompactDisk(ctx, `C:\vms\web.vhdx`); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.MergeDisk(ctx, `C:\vms\web.vhdx`, `C:\vms\base.vhdx`); err != nil {
+		t.Fatal(err)
+	}
+	for i, want := range []string{
+		`Resize-VHD -Path 'C:\vms\web.vhdx' -SizeBytes 2147483648`,
+		`$type = 'Fixed'`,
+		`Optimize-VHD -Path 'C:\vms\web.vhdx' -Mode Full`,
+		`Merge-VHD -Path 'C:\vms\web.vhdx' -DestinationPath 'C:\vms\base.vhdx'`,
+	} {
+		if i >= len(f.scripts) || !strings.Contains(f.scripts[i], want) {
+			t.Errorf("script %d of %q, want it to contain %q", i, f.scripts, want)
+		}
+	}
+
+	dest, err := p.MoveDisk(ctx, `C:\vms\it's.vhdx`, `D:\backup\`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dest != `D:\backup\it's.vhdx` {
+		t.Errorf("MoveDisk = %q, want the last line of the output", dest)
+	}
+}
+
+func TestPowerShellSwitches(t *testing.T) {
+	ctx := context.Background()
+	f := &fakeRunner{responses: map[string]string{
+		"Get-VMSwitch": `[{"Name":"lan","Id":"5f0e","SwitchType":"External","NetAdapterName":"Ethernet","AllowManagementOS":true,"Notes":"","BandwidthReservationMode":"Weight","IovEnabled":true}]`,
+	}}
+	p := NewPowerShell(f.run)
+
+	if _, err := p.CreateSwitch(ctx, Switch{Name: "lan", SwitchType: "External"}); err == nil {
+		t.Error("CreateSwitch of an external switch without an adapter succeeded")
+	}
+	sw, err := p.CreateSwitch(ctx, Switch{Name: "lan", SwitchType: "External", NetAdapterName: "Ethernet", AllowManagementOS: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sw.ID != "5f0e" || sw.NetAdapterName != "Ethernet" || !sw.AllowManagementOS || sw.BandwidthReservationMode != "Weight" || !sw.IovEnabled {
+		t.Errorf("CreateSwitch = %+v", sw)
+	}
+	if want := "New-VMSwitch -Name 'lan' -NetAdapterName 'Ethernet' -AllowManagementOS $true -Notes ''"; !strings.HasPrefix(f.scripts[0], want) {
+		t.Errorf("CreateSwitch ran %q, want %q", f.scripts[0], want)
+	}
+
+	f.scripts = nil
+	if _, err := p.UpdateSwitch(ctx, "lan", Switch{SwitchType: "Private", Notes: "isolated"}); err != nil {
+		t.Fatal(err)
+	}
+	if want := "Set-VMSwitch -Name 'lan' -SwitchType Private -Notes 'isolated'"; len(f.scripts) != 3 || !strings.HasPrefix(f.scripts[1], want) {
+		t.Errorf("UpdateSwitch ran %q, want %q", f.scripts, want)
+	}
+
+	f.err = errs.Wrap(errs.ErrAccessDenied, "PowerShell", errors.New("Access is denied."))
+	if err := p.DeleteSwitch(ctx, "lan"); !errors.Is(err, errs.ErrAccessDenied) {
+		t.Errorf("DeleteSwitch = %v, want the class of the failure of the runner", err)
+	}
+}
+
+func TestPowerShellConnectedAdapters(t *testing.T) {
+	// Neither script contains the key of the other.
+	f := &fakeRunner{responses: map[string]string{
+		"Get-VMSwitch":         `[{"Name":"lan","Id":"5f0e","SwitchType":"Internal"}]`,
+		"Get-VMNetworkAdapter": `[{"Name":"nic","VMName":"web","SwitchName":"lan","MacAddress":"00155D000001","DynamicMacAddress":false}]`,
+	}}
+	p := NewPowerShell(f.run)
+	adapters, err := p.ListConnectedAdapters(context.Background(), "lan")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(adapters) != 1 || adapters[0].VMName != "web" || adapters[0].SwitchName != "lan" {
+		t.Errorf("ListConnectedAdapters = %+v", adapters)
+	}
+	if script := f.scripts[len(f.scripts)-1]; !strings.Contains(script, "Where-Object { $_.SwitchName -eq 'lan' }") {
+		t.Errorf("ListConnectedAdapters ran %q", script)
+	}
+}
+
+func TestPowerShellNetworkAdapterStatus(t *testing.T) {
+	f := &fakeRunner{responses: map[string]string{
+		"Get-VMNetworkAdapter": `[{"Vlan":{"Mode":"Access","AccessVlanId":20,"NativeVlanId":0,"AllowedVlanIdList":"","PrimaryVlanId":0,"SecondaryVlanId":0,"SecondaryVlanIdList":""},"DhcpGuard":"On","RouterGuard":"Off","PortMirroringMode":"Source","IeeePriorityTag":"Off","VmqWeight":0,"IPAddresses":["10.0.0.5","fe80::1"],"Status":"Ok"}]`,
+	}}
+	p := NewPowerShell(f.run)
+	status, err := p.GetNetworkAdapterStatus(context.Background(), "web", "nic")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.Vlan.Mode != VlanAccess || status.Vlan.AccessVlanID != 20 || !status.DHCPGuard || status.RouterGuard || status.PortMirroring != "Source" ||
+		status.VMQWeight != 0 || len(status.IPAddresses) != 2 || status.Status != "Ok" {
+		t.Errorf("GetNetworkAdapterStatus = %+v", status)
+	}
+
+	// Only the IDs of the mode of the VLAN are reported.
+	f.responses["Get-VMNetworkAdapter"] = `[{"Vlan":{"Mode":"Trunk","AccessVlanId":20,"NativeVlanId":1,"AllowedVlanIdList":"1,10,11,12","PrimaryVlanId":0,"SecondaryVlanId":0,"SecondaryVlanIdList":""},"VmqWeight":100}]`
+	if status, err = p.GetNetworkAdapterStatus(context.Background(), "web", "nic"); err != nil {
+		t.Fatal(err)
+	}
+	if status.Vlan.Mode != VlanTrunk || status.Vlan.AccessVlanID != 0 || status.Vlan.NativeVlanID != 1 ||
+		!reflect.DeepEqual(status.Vlan.AllowedVlanIDs, []int{1, 10, 11, 12}) {
+		t.Errorf("GetNetworkAdapterStatus of a trunk port = %+v", status.Vlan)
+	}
+	f.responses["Get-VMNetworkAdapter"] = `[{"Vlan":{"Mode":"Promiscuous","PrimaryVlanId":100,"SecondaryVlanId":0,"SecondaryVlanIdList":"201,202"}}]`
+	if status, err = p.GetNetworkAdapterStatus(context.Background(), "web", "nic"); err != nil {
+		t.Fatal(err)
+	}
+	if status.Vlan.PrimaryVlanID != 100 || !reflect.DeepEqual(status.Vlan.SecondaryVlanIDs, []int{201, 202}) {
+		t.Errorf("GetNetworkAdapterStatus of a promiscuous port = %+v", status.Vlan)
+	}
+
+	f.responses["Get-VMNetworkAdapter"] = `[]`
+	if _, err := p.GetNetworkAdapterStatus(context.Background(), "web", "missing"); !errors.Is(err, errs.ErrNotFound) {
+		t.Errorf("GetNetworkAdapterStatus of a missing adapter = %v, want ErrNotFound", err)
+	}
+}
+
+func TestPowerShellSetNetworkAdapter(t *testing.T) {
+	f := &fakeRunner{}
+	p := NewPowerShell(f.run)
+	vlan := VlanSettings{Mode: VlanTrunk, NativeVlanID: 1, AllowedVlanIDs: []int{12, 10, 11, 20}}
+	mirroring := "Source"
+	settings := NetworkAdapterSettings{Vlan: &vlan, RouterGuard: new(bool), PortMirroring: &mirroring}
+	if err := p.SetNetworkAdapter(context.Background(), "web", "it's", settings); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		`Set-VMNetworkAdapterVlan -VMName 'web' -VMNetworkAdapterName 'it''s' -Trunk -AllowedVlanIdList '10-12,20' -NativeVlanId 1`,
+		`Set-VMNetworkAdapter -VMName 'web' -Name 'it''s' -RouterGuard Off -PortMirroring 'Source'`,
+	}
+	if len(f.scripts) != len(want) {
+		t.Fatalf("SetNetworkAdapter ran %q", f.scripts)
+	}
+	for i := range want {
+		if !strings.Contains(f.scripts[i], want[i]) {
+			t.Errorf("script %d = %q, want %q", i, f.scripts[i], want[i])
+		}
+	}
+
+	f.scripts = nil
+	promiscuous := VlanSettings{Mode: VlanPromiscuous, PrimaryVlanID: 100, SecondaryVlanIDs: []int{201}}
+	if err := p.SetNetworkAdapter(context.Background(), "web", "nic", NetworkAdapterSettings{Vlan: &promiscuous}); err != nil {
+		t.Fatal(err)
+	}
+	if len(f.scripts) != 1 || !strings.Contains(f.scripts[0], "-Promiscuous -PrimaryVlanId 100 -SecondaryVlanIdList '201'") {
+		t.Errorf("SetNetworkAdapter of a promiscuous port ran %q", f.scripts)
+	}
+}
+
+func TestPowerShellHostInventory(t *testing.T) {
+	ctx := context.Background()
+	f := &fakeRunner{responses: map[string]string{
+		"Get-VMHost": `[{"ComputerName":"HV01","LogicalProcessorCount":16,"MemoryCapacityBytes":68719476736,"VirtualHardDiskPath":"D:\\VHDs","VirtualMachinePath":"D:\\VMs"}]`,
+	}}
+	info, err := NewPowerShell(f.run).GetHostInfo(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.ComputerName != "HV01" || info.MemoryCapacityBytes != 64<<30 || info.VirtualHardDiskPath != `D:\VHDs` {
+		t.Errorf("GetHostInfo = %+v", info)
+	}
+
+	f = &fakeRunner{responses: map[string]string{
+		"Get-NetAdapter": `[{"Name":"Ethernet","InterfaceDescription":"Intel(R) Ethernet","MacAddress":"00-15-5D-01-02-03","Status":"Up","LinkSpeedBps":10000000000,"SwitchName":"External"}]`,
+	}}
+	adapters, err := NewPowerShell(f.run).ListPhysicalNetAdapters(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(adapters) != 1 || adapters[0].SwitchName != "External" || adapters[0].LinkSpeedBps != 10000000000 {
+		t.Errorf("ListPhysicalNetAdapters = %+v", adapters)
+	}
+	if !strings.Contains(f.scripts[0], "Get-NetAdapter -Physical") {
+		t.Errorf("ListPhysicalNetAdapters ran %q, want only physical adapters listed", f.scripts[0])
+	}
+}
+
+func TestQuote(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{`C:\vms\web.vhdx`, `'C:\vms\web.vhdx'`},
+		{"it's", "'it''s'"},
+		{"a\u2018b\u2019c", "'a\u2018\u2018b\u2019\u2019c'"},
+		{"\u201A\u201B; Remove-VM", "'\u201A\u201A\u201B\u201B; Remove-VM'"},
+		{`$(Stop-Computer) "x"`, `'$(Stop-Computer) "x"'`},
+	}
+	for _, tt := range tests {
+		if got := quote(tt.in); got != tt.want {
+			t.Errorf("quote(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,86 @@
+// Copyright 2016-2022, Pulumi Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Mode selects the backend that performs the operations of the provider.
+type Mode string
+
+// Backend modes.
+const (
+	// ModeAuto uses WMI when the Hyper-V WMI provider is available, and PowerShell otherwise.
+	ModeAuto Mode = "auto"
+	// ModeWMI uses WMI, and fails when the Hyper-V WMI provider is unavailable.
+	ModeWMI Mode = "wmi"
+	// ModePowerShell uses the Hyper-V PowerShell module only.
+	ModePowerShell Mode = "powershell"
+)
+
+// ParseMode parses the backend option of the provider configuration. An empty option is
+// ModeAuto.
+func ParseMode(s string) (Mode, error) {
+	switch mode := Mode(strings.ToLower(strings.TrimSpace(s))); mode {
+	case "":
+		return ModeAuto, nil
+	case ModeAuto, ModeWMI, ModePowerShell:
+		return mode, nil
+	}
+	return "", fmt.Errorf("invalid backend %q: must be one of auto, wmi or powershell", s)
+}
+
+// Selection is how the provider chooses between WMI and PowerShell.
+type Selection struct {
+	Mode Mode
+	// Strict makes an operation that fails using WMI fail, instead of repeating it with
+	// PowerShell.
+	Strict bool
+}
+
+// UseWMI reports whether the provider connects to the Hyper-V WMI provider.
+func (s Selection) UseWMI() bool {
+	return s.Mode != ModePowerShell
+}
+
+// Choose decides whether the operations of a connection use WMI, from wmiErr, the result of
+// connecting to the Hyper-V WMI provider. In ModeWMI and in strict mode a failed connection
+// is an error.
+func (s Selection) Choose(wmiErr error) (bool, error) {
+	if !s.UseWMI() {
+		return false, nil
+	}
+	if wmiErr == nil {
+		return true, nil
+	}
+	if s.Mode == ModeWMI {
+		return false, fmt.Errorf("failed to connect to Hyper-V using WMI, which the wmi backend requires: %w", wmiErr)
+	}
+	if s.Strict {
+		return false, fmt.Errorf("failed to connect to Hyper-V using WMI and strictBackend does not allow falling back to PowerShell: %w", wmiErr)
+	}
+	return false, nil
+}
+
+// Fallback decides whether op, which failed with err using WMI, may be repeated with
+// PowerShell. It returns nil when it may, and an error wrapping err in strict mode.
+func (s Selection) Fallback(op string, err error) error {
+	if !s.Strict {
+		return nil
+	}
+	return fmt.Errorf("%s failed using WMI and strictBackend does not allow falling back to PowerShell: %w", op, err)
+}
@@ -0,0 +1,88 @@
+// Copyright 2016-2022, Pulumi Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestParseMode(t *testing.T) {
+	tests := []struct {
+		in   string
+		want Mode
+	}{
+		{"", ModeAuto},
+		{"auto", ModeAuto},
+		{"WMI", ModeWMI},
+		{" PowerShell ", ModePowerShell},
+	}
+	for _, tt := range tests {
+		if got, err := ParseMode(tt.in); err != nil || got != tt.want {
+			t.Errorf("ParseMode(%q) = %q, %v, want %q", tt.in, got, err, tt.want)
+		}
+	}
+	if _, err := ParseMode("cim"); err == nil {
+		t.Error("ParseMode of an unknown backend succeeded")
+	}
+}
+
+func TestChoose(t *testing.T) {
+	unavailable := errors.New("the Virtual System Management Service is unavailable")
+
+	tests := []struct {
+		name      string
+		selection Selection
+		wmiErr    error
+		useWMI    bool
+		fails     bool
+	}{
+		{"auto", Selection{Mode: ModeAuto}, nil, true, false},
+		{"auto without WMI", Selection{Mode: ModeAuto}, unavailable, false, false},
+		{"strict auto without WMI", Selection{Mode: ModeAuto, Strict: true}, unavailable, false, true},
+		{"strict powershell", Selection{Mode: ModePowerShell, Strict: true}, unavailable, false, false},
+		{"wmi", Selection{Mode: ModeWMI}, nil, true, false},
+		{"wmi without WMI", Selection{Mode: ModeWMI}, unavailable, false, true},
+		{"powershell", Selection{Mode: ModePowerShell}, nil, false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useWMI, err := tt.selection.Choose(tt.wmiErr)
+			if tt.fails {
+				if !errors.Is(err, unavailable) {
+					t.Errorf("Choose error = %v, want the connection error", err)
+				}
+				return
+			}
+			if err != nil || useWMI != tt.useWMI {
+				t.Errorf("Choose = %t, %v, want %t", useWMI, err, tt.useWMI)
+			}
+		})
+	}
+	if (Selection{Mode: ModePowerShell}).UseWMI() {
+		t.Error("the powershell backend uses WMI")
+	}
+}
+
+func TestFallback(t *testing.T) {
+	failed := errors.New("AddResourceSettings failed with return value 32775")
+	if err := (Selection{Mode: ModeAuto}).Fallback("attach disk", failed); err != nil {
+		t.Errorf("Fallback = %v, want the fallback allowed", err)
+	}
+	err := (Selection{Mode: ModeWMI, Strict: true}).Fallback("attach disk", failed)
+	if !errors.Is(err, failed) {
+		t.Errorf("strict Fallback = %v, want the WMI error", err)
+	}
+}
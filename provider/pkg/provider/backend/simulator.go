@@ -0,0 +1,1290 @@
+// Copyright 2016-2022, Pulumi Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/pulumi/pulumi-hyperv/provider/pkg/provider/errs"
+	"github.com/pulumi/pulumi-hyperv/provider/pkg/provider/passthrough"
+	"github.com/pulumi/pulumi-hyperv/provider/pkg/provider/vhd"
+)
+
+// Limits of the controllers of a simulated virtual machine. Generation 1 machines have two
+// IDE controllers with two locations each; SCSI controllers have 64 locations.
+const (
+	ideControllers   = 2
+	ideLocations     = 2
+	scsiLocations    = 64
+	defaultMemoryMB  = 1024
+	minimumMemoryMB  = 512
+	maximumMemoryMB  = 1048576
+	simulatorBaseMAC = 0x00155D000000
+)
+
+// Simulator is an in-memory Hyper-V host. It enforces the rules of Hyper-V that the resources
+// depend on, such as which power state transitions are allowed and which controller slots
+// exist, so resource lifecycles can be tested on any platform.
+type Simulator struct {
+	// PhysicalAdapters are the names of the physical network adapters external switches can
+	// be bound to.
+	PhysicalAdapters []string
+	// Capabilities is what HostCapabilities reports.
+	Capabilities HostCapabilities
+	// HostInfo is what GetHostInfo reports.
+	HostInfo HostInfo
+	// DiskOperations records the disk maintenance operations in the order they ran, such as
+	// "Resize-VHD C:\vms\os.vhdx", so that tests can check their sequence.
+	DiskOperations []string
+
+	mu       sync.Mutex
+	serial   int
+	vms      map[string]*simVM
+	disks    map[string]*Disk
+	switches map[string]*Switch
+	// hostDisks are the physical disks that can be passed through.
+	hostDisks []passthrough.Disk
+}
+
+type simVM struct {
+	VM
+	drives []DiskDrive
+	// passThrough are the drives that use physical disks of the host.
+	passThrough []passThroughDrive
+	// scsiControllers is the number of SCSI controllers. New-VM adds one to generation 2
+	// machines and none to generation 1 machines.
+	scsiControllers int
+	adapters        []NetworkAdapter
+	// ports are the switch port settings of the adapters by adapter name.
+	ports map[string]*NetworkAdapterStatus
+}
+
+var _ HypervBackend = (*Simulator)(nil)
+
+// NewSimulator returns an empty Windows Server 2022 host with every part of Hyper-V and one
+// physical network adapter, "Ethernet".
+func NewSimulator() *Simulator {
+	s := &Simulator{
+		PhysicalAdapters: []string{"Ethernet"},
+		Capabilities: HostCapabilities{
+			OSCaption:        "Microsoft Windows Server 2022 Datacenter",
+			OSVersion:        "10.0.20348",
+			HyperVRole:       true,
+			PowerShellModule: true,
+			VMMS:             true,
+			VSMS:             true,
+			HGS:              true,
+			ConfigVersions:   []string{"8.0", "9.0", "10.0"},
+		},
+		HostInfo: HostInfo{
+			ComputerName:          "HV01",
+			LogicalProcessorCount: 8,
+			MemoryCapacityBytes:   32 << 30,
+			VirtualHardDiskPath:   `C:\ProgramData\Microsoft\Windows\Virtual Hard Disks`,
+			VirtualMachinePath:    `C:\ProgramData\Microsoft\Windows\Hyper-V`,
+		},
+		vms:      map[string]*simVM{},
+		disks:    map[string]*Disk{},
+		switches: map[string]*Switch{},
+	}
+	s.Capabilities.Complete()
+	return s
+}
+
+func (s *Simulator) Name() string {
+	return "simulator"
+}
+
+func (s *Simulator) HostCapabilities(ctx context.Context) (*HostCapabilities, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	caps := s.Capabilities
+	return &caps, nil
+}
+
+// key returns the map key of a name or path, which Hyper-V compares case-insensitively.
+func key(name string) string {
+	return strings.ToLower(name)
+}
+
+func (s *Simulator) nextID() string {
+	s.serial++
+	return fmt.Sprintf("00000000-0000-0000-0000-%012d", s.serial)
+}
+
+func (s *Simulator) vm(nameOrID string) (*simVM, error) {
+	if vm, ok := s.vms[key(nameOrID)]; ok {
+		return vm, nil
+	}
+	id := strings.Trim(nameOrID, "{}")
+	for _, vm := range s.vms {
+		if strings.EqualFold(vm.ID, id) {
+			return vm, nil
+		}
+	}
+	return nil, errs.New(errs.ErrNotFound, "Get-VM", fmt.Sprintf("virtual machine %s not found", nameOrID))
+}
+
+func (s *Simulator) ListVMs(ctx context.Context) ([]VM, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var vms []VM
+	for _, vm := range s.vms {
+		vms = append(vms, vm.VM)
+	}
+	sort.Slice(vms, func(i, j int) bool { return vms[i].Name < vms[j].Name })
+	return vms, nil
+}
+
+func (s *Simulator) GetVM(ctx context.Context, nameOrID string) (*VM, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	vm, err := s.vm(nameOrID)
+	if err != nil {
+		return nil, err
+	}
+	result := vm.VM
+	return &result, nil
+}
+
+func (s *Simulator) CreateVM(ctx context.Context, spec VMSpec) (*VM, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if spec.Name == "" {
+		return nil, fmt.Errorf("a virtual machine name is required")
+	}
+	if _, ok := s.vms[key(spec.Name)]; ok {
+		return nil, errs.New(errs.ErrAlreadyExists, "New-VM", fmt.Sprintf("virtual machine %s already exists", spec.Name))
+	}
+	vm := VM{
+		ID:             s.nextID(),
+		Name:           spec.Name,
+		Generation:     spec.Generation,
+		State:          PowerStateOff,
+		MemoryMB:       spec.MemoryMB,
+		ProcessorCount: spec.ProcessorCount,
+		// New-VM leaves dynamic memory off, with the limits Hyper-V uses when it is turned on.
+		MinimumMemoryMB:      minimumMemoryMB,
+		MaximumMemoryMB:      maximumMemoryMB,
+		AutomaticStartAction: "StartIfRunning",
+		AutomaticStopAction:  "Save",
+	}
+	if vm.Generation == 0 {
+		vm.Generation = 2
+	}
+	if vm.Generation != 1 && vm.Generation != 2 {
+		return nil, fmt.Errorf("unsupported generation %d, must be 1 or 2", vm.Generation)
+	}
+	if vm.MemoryMB == 0 {
+		vm.MemoryMB = defaultMemoryMB
+	}
+	if vm.ProcessorCount == 0 {
+		vm.ProcessorCount = 1
+	}
+	sim := &simVM{VM: vm}
+	if vm.Generation == 2 {
+		sim.scsiControllers = 1
+	}
+	s.vms[key(vm.Name)] = sim
+	return &vm, nil
+}
+
+// transitions are the power states each state can change to.
+var transitions = map[PowerState][]PowerState{
+	PowerStateOff:     {PowerStateRunning},
+	PowerStateRunning: {PowerStateOff, PowerStatePaused, PowerStateSaved},
+	PowerStatePaused:  {PowerStateOff, PowerStateRunning, PowerStateSaved},
+	PowerStateSaved:   {PowerStateOff, PowerStateRunning},
+}
+
+func (s *Simulator) SetVMState(ctx context.Context, name string, state PowerState) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	vm, err := s.vm(name)
+	if err != nil {
+		return err
+	}
+	if vm.State == state {
+		return nil
+	}
+	if _, ok := transitions[state]; !ok {
+		return fmt.Errorf("unsupported power state %s", state)
+	}
+	for _, to := range transitions[vm.State] {
+		if to == state {
+			vm.State = state
+			return nil
+		}
+	}
+	return errs.New(errs.ErrInvalidState, "SetVMState",
+		fmt.Sprintf("virtual machine %s cannot change from %s to %s", vm.Name, vm.State, state))
+}
+
+// SetVM enforces that the processor count, and the memory unless it is dynamic, only change
+// while the VM is off, and that dynamic memory stays between its limits.
+func (s *Simulator) SetVM(ctx context.Context, name string, settings VMSettings) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := settings.Validate(); err != nil {
+		return err
+	}
+	vm, err := s.vm(name)
+	if err != nil {
+		return err
+	}
+	result := vm.VM
+	if settings.ProcessorCount != nil {
+		if *settings.ProcessorCount < 1 {
+			return errs.New(errs.ErrInvalidParameter, "Set-VMProcessor", "the processor count must be at least 1")
+		}
+		result.ProcessorCount = *settings.ProcessorCount
+	}
+	if settings.DynamicMemory != nil {
+		result.DynamicMemory = *settings.DynamicMemory
+	}
+	if settings.MemoryMB != nil {
+		result.MemoryMB = *settings.MemoryMB
+	}
+	if settings.MinimumMemoryMB != nil {
+		result.MinimumMemoryMB = *settings.MinimumMemoryMB
+	}
+	if settings.MaximumMemoryMB != nil {
+		result.MaximumMemoryMB = *settings.MaximumMemoryMB
+	}
+	if settings.AutomaticStartAction != nil {
+		action, _ := AutomaticStartAction(*settings.AutomaticStartAction)
+		result.AutomaticStartAction = startActions[action]
+	}
+	if settings.AutomaticStopAction != nil {
+		action, _ := AutomaticStopAction(*settings.AutomaticStopAction)
+		result.AutomaticStopAction = stopActions[action]
+	}
+	if vm.State != PowerStateOff && (result.ProcessorCount != vm.ProcessorCount || result.DynamicMemory != vm.DynamicMemory ||
+		(!result.DynamicMemory && result.MemoryMB != vm.MemoryMB)) {
+		return errs.New(errs.ErrInvalidState, "Set-VM",
+			fmt.Sprintf("virtual machine %s must be off to change its processors or static memory, it is %s", vm.Name, vm.State))
+	}
+	if result.DynamicMemory && (result.MinimumMemoryMB > result.MemoryMB || result.MemoryMB > result.MaximumMemoryMB) {
+		return errs.New(errs.ErrInvalidParameter, "Set-VMMemory",
+			fmt.Sprintf("the startup memory of virtual machine %s (%d MB) must be between its minimum (%d MB) and maximum (%d MB)",
+				vm.Name, result.MemoryMB, result.MinimumMemoryMB, result.MaximumMemoryMB))
+	}
+	vm.VM = result
+	return nil
+}
+
+func (s *Simulator) DeleteVM(ctx context.Context, name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	vm, err := s.vm(name)
+	if err != nil {
+		return err
+	}
+	if vm.State != PowerStateOff {
+		return errs.New(errs.ErrInvalidState, "Remove-VM",
+			fmt.Sprintf("virtual machine %s must be off to be deleted, it is %s", vm.Name, vm.State))
+	}
+	delete(s.vms, key(vm.Name))
+	return nil
+}
+
+func (s *Simulator) disk(path string) (*Disk, error) {
+	if disk, ok := s.disks[key(path)]; ok {
+		return disk, nil
+	}
+	return nil, errs.New(errs.ErrNotFound, "Get-VHD", fmt.Sprintf("vhd [%s] not found", path))
+}
+
+func (s *Simulator) GetDisk(ctx context.Context, path string) (*Disk, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	disk, err := s.disk(path)
+	if err != nil {
+		return nil, err
+	}
+	result := *disk
+	return &result, nil
+}
+
+func (s *Simulator) CreateDisk(ctx context.Context, path string, opts vhd.CreateOptions) (*Disk, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var err error
+	if _, ok := s.disks[key(path)]; ok {
+		return nil, errs.New(errs.ErrAlreadyExists, "New-VHD", fmt.Sprintf("vhd [%s] already exists", path))
+	}
+	if opts.Format == "" {
+		if opts.Format, err = vhd.FormatForPath(path); err != nil {
+			return nil, err
+		}
+	}
+	if opts.DiskType, err = vhd.NormalizeDiskType(opts.DiskType); err != nil {
+		return nil, err
+	}
+
+	disk := &Disk{Path: path, Info: vhd.Info{
+		Format:             opts.Format,
+		DiskType:           opts.DiskType,
+		VirtualSize:        opts.VirtualSize,
+		BlockSize:          opts.BlockSize,
+		LogicalSectorSize:  opts.LogicalSectorSize,
+		PhysicalSectorSize: opts.PhysicalSectorSize,
+		DiskID:             s.nextID(),
+	}}
+	if opts.DiskType == vhd.TypeDifferencing {
+		if opts.ParentPath == "" {
+			return nil, fmt.Errorf("a parent path is required for a differencing disk")
+		}
+		parent, err := s.disk(opts.ParentPath)
+		if err != nil {
+			return nil, err
+		}
+		if parent.Format != opts.Format {
+			return nil, fmt.Errorf("a %s differencing disk cannot have a %s parent", opts.Format, parent.Format)
+		}
+		disk.VirtualSize = parent.VirtualSize
+		disk.LogicalSectorSize = parent.LogicalSectorSize
+		disk.PhysicalSectorSize = parent.PhysicalSectorSize
+		disk.ParentPath = parent.Path
+		disk.ParentID = parent.DiskID
+	} else if disk.VirtualSize == 0 {
+		return nil, fmt.Errorf("a size is required for a %s disk", strings.ToLower(opts.DiskType))
+	}
+	if disk.Format == vhd.FormatVHDX {
+		defaultUint32(&disk.BlockSize, 32<<20)
+		defaultUint32(&disk.LogicalSectorSize, 512)
+		defaultUint32(&disk.PhysicalSectorSize, 4096)
+	} else {
+		defaultUint32(&disk.BlockSize, 2<<20)
+		defaultUint32(&disk.LogicalSectorSize, 512)
+		defaultUint32(&disk.PhysicalSectorSize, 512)
+	}
+	disk.PhysicalSize = 4 << 20
+	if disk.DiskType == vhd.TypeFixed {
+		disk.PhysicalSize += int64(disk.VirtualSize)
+	}
+	s.disks[key(path)] = disk
+	result := *disk
+	return &result, nil
+}
+
+func (s *Simulator) DeleteDisk(ctx context.Context, path string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	disk, err := s.disk(path)
+	if err != nil {
+		return err
+	}
+	for _, vm := range s.vms {
+		for _, drive := range vm.drives {
+			if strings.EqualFold(drive.Path, path) {
+				return errs.New(errs.ErrInvalidState, "Remove-Item",
+					fmt.Sprintf("vhd [%s] is attached to virtual machine %s", disk.Path, vm.Name))
+			}
+		}
+	}
+	for _, child := range s.disks {
+		if strings.EqualFold(child.ParentPath, path) {
+			return errs.New(errs.ErrInvalidState, "Remove-Item",
+				fmt.Sprintf("vhd [%s] is the parent of vhd [%s]", disk.Path, child.Path))
+		}
+	}
+	delete(s.disks, key(path))
+	return nil
+}
+
+// SetDiskMinimumSize sets the size the partitions of a disk use, below which it cannot be
+// shrunk, as if they were changed inside the guest.
+func (s *Simulator) SetDiskMinimumSize(path string, size uint64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	disk, err := s.disk(path)
+	if err != nil {
+		return err
+	}
+	disk.MinimumSize = size
+	return nil
+}
+
+func (s *Simulator) ListDiskUsers(ctx context.Context, path string) ([]DiskUser, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.diskUsers(path), nil
+}
+
+// diskUsers returns the virtual machines the disk at path is attached to, by name.
+func (s *Simulator) diskUsers(path string) []DiskUser {
+	var users []DiskUser
+	for _, vm := range s.vms {
+		for _, drive := range vm.drives {
+			if strings.EqualFold(drive.Path, path) {
+				users = append(users, DiskUser{VMName: vm.Name, State: vm.State, Generation: vm.Generation, ControllerType: drive.ControllerType})
+			}
+		}
+	}
+	sort.Slice(users, func(i, j int) bool { return users[i].VMName < users[j].VMName })
+	return users
+}
+
+// runningUser returns a virtual machine that is not off and uses the disk at path, or nil.
+func (s *Simulator) runningUser(path string) *DiskUser {
+	for _, user := range s.diskUsers(path) {
+		if user.State != PowerStateOff {
+			return &user
+		}
+	}
+	return nil
+}
+
+// ResizeDisk enforces the rules of Resize-VHD: VHD files cannot shrink, no disk shrinks below
+// its MinimumSize, and a disk in use by a running VM must be a VHDX on the SCSI controller of
+// a generation 2 machine.
+func (s *Simulator) ResizeDisk(ctx context.Context, path string, size uint64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	disk, err := s.disk(path)
+	if err != nil {
+		return err
+	}
+	if size < disk.VirtualSize && disk.Format == vhd.FormatVHD {
+		return errs.New(errs.ErrInvalidParameter, "Resize-VHD", fmt.Sprintf("vhd [%s] is a VHD file, which cannot shrink", disk.Path))
+	}
+	if size < disk.MinimumSize {
+		return errs.New(errs.ErrInvalidParameter, "Resize-VHD",
+			fmt.Sprintf("vhd [%s] cannot shrink to %d bytes, its partitions use %d bytes", disk.Path, size, disk.MinimumSize))
+	}
+	if user := s.runningUser(path); user != nil &&
+		(disk.Format != vhd.FormatVHDX || user.ControllerType != ControllerSCSI || user.Generation != 2) {
+		return errs.New(errs.ErrInvalidState, "Resize-VHD",
+			fmt.Sprintf("vhd [%s] cannot be resized while virtual machine %s is %s", disk.Path, user.VMName, user.State))
+	}
+	if disk.DiskType == vhd.TypeFixed {
+		disk.PhysicalSize += int64(size) - int64(disk.VirtualSize)
+	}
+	disk.VirtualSize = size
+	s.DiskOperations = append(s.DiskOperations, "Resize-VHD "+disk.Path)
+	return nil
+}
+
+func (s *Simulator) ConvertDisk(ctx context.Context, path string, diskType string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	disk, err := s.disk(path)
+	if err != nil {
+		return err
+	}
+	if diskType, err = vhd.NormalizeDiskType(diskType); err != nil {
+		return err
+	}
+	if diskType == vhd.TypeDifferencing || disk.DiskType == vhd.TypeDifferencing {
+		return errs.New(errs.ErrInvalidParameter, "Convert-VHD",
+			fmt.Sprintf("vhd [%s] cannot be converted from %s to %s", disk.Path, disk.DiskType, diskType))
+	}
+	if user := s.runningUser(path); user != nil {
+		return errs.New(errs.ErrInvalidState, "Convert-VHD",
+			fmt.Sprintf("vhd [%s] is in use by virtual machine %s", disk.Path, user.VMName))
+	}
+	disk.DiskType = diskType
+	disk.PhysicalSize = 4 << 20
+	if diskType == vhd.TypeFixed {
+		disk.PhysicalSize += int64(disk.VirtualSize)
+	}
+	s.DiskOperations = append(s.DiskOperations, "Convert-VHD "+disk.Path)
+	return nil
+}
+
+func (s *Simulator) CompactDisk(ctx context.Context, path string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	disk, err := s.disk(path)
+	if err != nil {
+		return err
+	}
+	if disk.DiskType == vhd.TypeFixed {
+		return errs.New(errs.ErrInvalidParameter, "Optimize-VHD", fmt.Sprintf("vhd [%s] is a fixed disk, which cannot be compacted", disk.Path))
+	}
+	if user := s.runningUser(path); user != nil {
+		return errs.New(errs.ErrInvalidState, "Optimize-VHD",
+			fmt.Sprintf("vhd [%s] is in use by virtual machine %s", disk.Path, user.VMName))
+	}
+	disk.PhysicalSize = 4 << 20
+	s.DiskOperations = append(s.DiskOperations, "Optimize-VHD "+disk.Path)
+	return nil
+}
+
+func (s *Simulator) MergeDisk(ctx context.Context, path string, destination string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	disk, err := s.disk(path)
+	if err != nil {
+		return err
+	}
+	if user := s.runningUser(path); user != nil {
+		return errs.New(errs.ErrInvalidState, "Merge-VHD",
+			fmt.Sprintf("vhd [%s] is in use by virtual machine %s", disk.Path, user.VMName))
+	}
+	for ancestor := disk; ancestor.ParentPath != ""; {
+		if ancestor, err = s.disk(ancestor.ParentPath); err != nil {
+			return err
+		}
+		if vhd.SamePath(ancestor.Path, destination) {
+			delete(s.disks, key(path))
+			s.DiskOperations = append(s.DiskOperations, "Merge-VHD "+disk.Path)
+			return nil
+		}
+	}
+	return errs.New(errs.ErrInvalidParameter, "Merge-VHD", fmt.Sprintf("vhd [%s] is not an ancestor of vhd [%s]", destination, disk.Path))
+}
+
+// MoveDisk treats destinations that end with a separator as directories. The simulated host
+// has no other directories.
+func (s *Simulator) MoveDisk(ctx context.Context, path string, destination string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	disk, err := s.disk(path)
+	if err != nil {
+		return "", err
+	}
+	if strings.HasSuffix(destination, `\`) || strings.HasSuffix(destination, "/") {
+		name := disk.Path[strings.LastIndexAny(disk.Path, `\/`)+1:]
+		destination += name
+	}
+	if _, ok := s.disks[key(destination)]; ok {
+		return "", errs.New(errs.ErrAlreadyExists, "Move-Item", fmt.Sprintf("[%s] already exists", destination))
+	}
+	delete(s.disks, key(path))
+	disk.Path = destination
+	s.disks[key(destination)] = disk
+	s.DiskOperations = append(s.DiskOperations, "Move-Item "+path)
+	return destination, nil
+}
+
+func (s *Simulator) ListDiskDrives(ctx context.Context, vmName string) ([]DiskDrive, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	vm, err := s.vm(vmName)
+	if err != nil {
+		return nil, err
+	}
+	drives := make([]DiskDrive, 0, len(vm.drives))
+	for _, drive := range vm.drives {
+		drive.Settings = nil
+		drives = append(drives, drive)
+	}
+	return drives, nil
+}
+
+func (s *Simulator) AttachDisk(ctx context.Context, vmName string, drive DiskDrive) (*DiskDrive, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	vm, err := s.vm(vmName)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := s.disk(drive.Path); err != nil {
+		return nil, err
+	}
+	if err := drive.Settings.Validate(); err != nil {
+		return nil, err
+	}
+	for _, other := range s.vms {
+		for _, attached := range other.drives {
+			if strings.EqualFold(attached.Path, drive.Path) {
+				return nil, errs.New(errs.ErrInvalidState, "Add-VMHardDiskDrive",
+					fmt.Sprintf("vhd [%s] is already attached to virtual machine %s", drive.Path, other.Name))
+			}
+		}
+	}
+
+	controllerType, location, err := vm.slot("Add-VMHardDiskDrive", drive.ControllerType, drive.ControllerNumber, drive.ControllerLocation)
+	if err != nil {
+		return nil, err
+	}
+	drive.ControllerType, drive.ControllerLocation = controllerType, location
+	vm.drives = append(vm.drives, drive)
+	return &drive, nil
+}
+
+// slot checks that a controller slot of the VM exists and is free, or picks the first free
+// location when location is negative, and returns the normalized controller type and the
+// location.
+func (vm *simVM) slot(op string, controllerType string, number int, location int) (string, int, error) {
+	controllerType = strings.ToUpper(controllerType)
+	if controllerType == "" {
+		controllerType = ControllerSCSI
+	}
+	controllers, locations := vm.scsiControllers, scsiLocations
+	switch controllerType {
+	case ControllerSCSI:
+	case ControllerIDE:
+		if vm.Generation != 1 {
+			return "", 0, fmt.Errorf("virtual machine %s is generation %d and has no IDE controllers", vm.Name, vm.Generation)
+		}
+		if vm.State != PowerStateOff {
+			return "", 0, errs.New(errs.ErrInvalidState, op,
+				fmt.Sprintf("virtual machine %s must be off to attach a disk to an IDE controller", vm.Name))
+		}
+		controllers, locations = ideControllers, ideLocations
+	default:
+		return "", 0, fmt.Errorf("unsupported controller type [%s], must be SCSI or IDE", controllerType)
+	}
+	if number < 0 || number >= controllers {
+		return "", 0, fmt.Errorf("virtual machine %s has no %s controller %d", vm.Name, controllerType, number)
+	}
+	if location >= locations {
+		return "", 0, fmt.Errorf("%s controller %d has no location %d", controllerType, number, location)
+	}
+
+	used := map[int]bool{}
+	for _, attached := range vm.drives {
+		if attached.ControllerType == controllerType && attached.ControllerNumber == number {
+			used[attached.ControllerLocation] = true
+		}
+	}
+	for _, attached := range vm.passThrough {
+		if attached.ControllerType == controllerType && attached.ControllerNumber == number {
+			used[attached.ControllerLocation] = true
+		}
+	}
+	if location < 0 {
+		for free := 0; free < locations; free++ {
+			if !used[free] {
+				location = free
+				break
+			}
+		}
+		if location < 0 {
+			return "", 0, errs.New(errs.ErrInsufficientResources, op,
+				fmt.Sprintf("%s controller %d of virtual machine %s is full", controllerType, number, vm.Name))
+		}
+	} else if used[location] {
+		return "", 0, errs.New(errs.ErrInvalidState, op,
+			fmt.Sprintf("location %d of %s controller %d of virtual machine %s is in use",
+				location, controllerType, number, vm.Name))
+	}
+	return controllerType, location, nil
+}
+
+func (s *Simulator) DetachDisk(ctx context.Context, vmName string, path string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	vm, err := s.vm(vmName)
+	if err != nil {
+		return err
+	}
+	for i, drive := range vm.drives {
+		if !strings.EqualFold(drive.Path, path) {
+			continue
+		}
+		if drive.ControllerType == ControllerIDE && vm.State != PowerStateOff {
+			return errs.New(errs.ErrInvalidState, "Remove-VMHardDiskDrive",
+				fmt.Sprintf("virtual machine %s must be off to detach a disk from an IDE controller", vm.Name))
+		}
+		vm.drives = append(vm.drives[:i], vm.drives[i+1:]...)
+		return nil
+	}
+	return nil
+}
+
+// DiskDriveSettings returns the settings of the drive of vmName that uses the disk at path,
+// which ListDiskDrives does not report, or nil if none were set.
+func (s *Simulator) DiskDriveSettings(vmName string, path string) (*DiskDriveSettings, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	drive, err := s.drive(vmName, path)
+	if err != nil {
+		return nil, err
+	}
+	if drive.Settings == nil {
+		return nil, nil
+	}
+	settings := *drive.Settings
+	return &settings, nil
+}
+
+func (s *Simulator) drive(vmName string, path string) (*DiskDrive, error) {
+	vm, err := s.vm(vmName)
+	if err != nil {
+		return nil, err
+	}
+	for i := range vm.drives {
+		if strings.EqualFold(vm.drives[i].Path, path) {
+			return &vm.drives[i], nil
+		}
+	}
+	return nil, errs.New(errs.ErrNotFound, "Get-VMHardDiskDrive", fmt.Sprintf("vhd [%s] is not attached to virtual machine %s", path, vmName))
+}
+
+func (s *Simulator) SetDiskDrive(ctx context.Context, vmName string, path string, settings DiskDriveSettings) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	drive, err := s.drive(vmName, path)
+	if err != nil {
+		return err
+	}
+	merged := DiskDriveSettings{}
+	if drive.Settings != nil {
+		merged = *drive.Settings
+	}
+	if settings.MinimumIops != nil {
+		merged.MinimumIops = settings.MinimumIops
+	}
+	if settings.MaximumIops != nil {
+		merged.MaximumIops = settings.MaximumIops
+	}
+	if settings.QosPolicyID != nil {
+		merged.QosPolicyID = settings.QosPolicyID
+	}
+	if settings.SupportPersistentReservations != nil {
+		merged.SupportPersistentReservations = settings.SupportPersistentReservations
+	}
+	if settings.ReadOnly != nil {
+		merged.ReadOnly = settings.ReadOnly
+	}
+	if settings.CacheMode != nil {
+		merged.CacheMode = settings.CacheMode
+	}
+	if err := merged.Validate(); err != nil {
+		return err
+	}
+	drive.Settings = &merged
+	return nil
+}
+
+// SetSCSIControllerCount enforces that controllers are only added or removed while the VM is
+// off and that controllers with drives are not removed.
+func (s *Simulator) SetSCSIControllerCount(ctx context.Context, vmName string, count int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	vm, err := s.vm(vmName)
+	if err != nil {
+		return err
+	}
+	if count < 0 || count > MaxSCSIControllers {
+		return errs.New(errs.ErrInvalidParameter, "Add-VMScsiController", fmt.Sprintf("the SCSI controller count must be between 0 and %d", MaxSCSIControllers))
+	}
+	if count == vm.scsiControllers {
+		return nil
+	}
+	if vm.State != PowerStateOff {
+		return errs.New(errs.ErrInvalidState, "Add-VMScsiController",
+			fmt.Sprintf("virtual machine %s must be off to change its SCSI controllers, it is %s", vm.Name, vm.State))
+	}
+	for _, drive := range vm.drives {
+		if drive.ControllerType == ControllerSCSI && drive.ControllerNumber >= count {
+			return errs.New(errs.ErrInvalidState, "Remove-VMScsiController",
+				fmt.Sprintf("cannot remove SCSI controller %d of virtual machine %s while vhd [%s] is attached to it", drive.ControllerNumber, vm.Name, drive.Path))
+		}
+	}
+	for _, drive := range vm.passThrough {
+		if drive.ControllerType == ControllerSCSI && drive.ControllerNumber >= count {
+			return errs.New(errs.ErrInvalidState, "Remove-VMScsiController",
+				fmt.Sprintf("cannot remove SCSI controller %d of virtual machine %s while %s is attached to it", drive.ControllerNumber, vm.Name, drive.Disk.Name()))
+		}
+	}
+	vm.scsiControllers = count
+	return nil
+}
+
+// SCSIControllerCount returns the number of SCSI controllers of vmName.
+func (s *Simulator) SCSIControllerCount(vmName string) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	vm, err := s.vm(vmName)
+	if err != nil {
+		return 0, err
+	}
+	return vm.scsiControllers, nil
+}
+
+// passThroughDrive is a physical disk of the host attached to a controller slot.
+type passThroughDrive struct {
+	Disk               passthrough.Disk
+	ControllerType     string
+	ControllerNumber   int
+	ControllerLocation int
+}
+
+// AddHostDisk adds a physical disk to the host.
+func (s *Simulator) AddHostDisk(disk passthrough.Disk) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.hostDisks = append(s.hostDisks, disk)
+}
+
+// PassThroughDisks returns the numbers of the host disks attached to vmName.
+func (s *Simulator) PassThroughDisks(vmName string) ([]uint32, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	vm, err := s.vm(vmName)
+	if err != nil {
+		return nil, err
+	}
+	var numbers []uint32
+	for _, drive := range vm.passThrough {
+		numbers = append(numbers, drive.Disk.Number)
+	}
+	return numbers, nil
+}
+
+func (s *Simulator) AttachPassThroughDisk(ctx context.Context, vmName string, spec passthrough.Spec) (*passthrough.Disk, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	vm, err := s.vm(vmName)
+	if err != nil {
+		return nil, err
+	}
+	disk, err := passthrough.FindDisk(s.hostDisks, spec)
+	if err != nil {
+		return nil, errs.Wrap(errs.ErrInvalidParameter, "Add-VMHardDiskDrive", err)
+	}
+	for _, other := range s.vms {
+		for _, drive := range other.passThrough {
+			if drive.Disk.Number != disk.Number {
+				continue
+			}
+			if other == vm {
+				return &disk, nil
+			}
+			return nil, errs.New(errs.ErrInvalidState, "Add-VMHardDiskDrive",
+				fmt.Sprintf("%s is already attached to virtual machine %s", disk.Name(), other.Name))
+		}
+	}
+	location := -1
+	if spec.ControllerLocation != nil {
+		location = *spec.ControllerLocation
+	}
+	controllerType, location, err := vm.slot("Add-VMHardDiskDrive", spec.ControllerType, spec.ControllerNumber, location)
+	if err != nil {
+		return nil, err
+	}
+	vm.passThrough = append(vm.passThrough, passThroughDrive{
+		Disk:               disk,
+		ControllerType:     controllerType,
+		ControllerNumber:   spec.ControllerNumber,
+		ControllerLocation: location,
+	})
+	return &disk, nil
+}
+
+func (s *Simulator) DetachPassThroughDisk(ctx context.Context, vmName string, spec passthrough.Spec) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	vm, err := s.vm(vmName)
+	if err != nil {
+		return err
+	}
+	disk := passthrough.Lookup(s.hostDisks, spec)
+	if disk == nil {
+		return nil
+	}
+	for i, drive := range vm.passThrough {
+		if drive.Disk.Number != disk.Number {
+			continue
+		}
+		if drive.ControllerType == ControllerIDE && vm.State != PowerStateOff {
+			return errs.New(errs.ErrInvalidState, "Remove-VMHardDiskDrive",
+				fmt.Sprintf("virtual machine %s must be off to detach a disk from an IDE controller", vm.Name))
+		}
+		vm.passThrough = append(vm.passThrough[:i], vm.passThrough[i+1:]...)
+		return nil
+	}
+	return nil
+}
+
+func (s *Simulator) vswitch(name string) (*Switch, error) {
+	if sw, ok := s.switches[key(name)]; ok {
+		return sw, nil
+	}
+	return nil, errs.New(errs.ErrNotFound, "Get-VMSwitch", fmt.Sprintf("virtual switch %s not found", name))
+}
+
+func (s *Simulator) ListSwitches(ctx context.Context) ([]Switch, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var switches []Switch
+	for _, sw := range s.switches {
+		switches = append(switches, *sw)
+	}
+	sort.Slice(switches, func(i, j int) bool { return switches[i].Name < switches[j].Name })
+	return switches, nil
+}
+
+func (s *Simulator) GetSwitch(ctx context.Context, name string) (*Switch, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sw, err := s.vswitch(name)
+	if err != nil {
+		return nil, err
+	}
+	result := *sw
+	return &result, nil
+}
+
+func (s *Simulator) GetHostInfo(ctx context.Context) (*HostInfo, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	info := s.HostInfo
+	return &info, nil
+}
+
+// ListPhysicalNetAdapters returns PhysicalAdapters, which are up at 1 Gbit/s and have
+// addresses in the order they are listed.
+func (s *Simulator) ListPhysicalNetAdapters(ctx context.Context) ([]PhysicalNetAdapter, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	adapters := make([]PhysicalNetAdapter, 0, len(s.PhysicalAdapters))
+	for i, name := range s.PhysicalAdapters {
+		adapter := PhysicalNetAdapter{
+			Name:                 name,
+			InterfaceDescription: fmt.Sprintf("Simulated Ethernet Adapter #%d", i+1),
+			MacAddress:           fmt.Sprintf("00-15-5D-FF-00-%02X", i+1),
+			Status:               "Up",
+			LinkSpeedBps:         1000000000,
+		}
+		for _, sw := range s.switches {
+			if sw.SwitchType == SwitchTypeExternal && strings.EqualFold(sw.NetAdapterName, name) {
+				adapter.SwitchName = sw.Name
+			}
+		}
+		adapters = append(adapters, adapter)
+	}
+	return adapters, nil
+}
+
+// checkSwitch validates the type and physical adapter of spec. name is the switch being
+// updated, which may keep its own adapter.
+func (s *Simulator) checkSwitch(name string, spec *Switch) error {
+	switch {
+	case strings.EqualFold(spec.SwitchType, SwitchTypeExternal):
+		spec.SwitchType = SwitchTypeExternal
+	case strings.EqualFold(spec.SwitchType, SwitchTypeInternal):
+		spec.SwitchType = SwitchTypeInternal
+	case strings.EqualFold(spec.SwitchType, SwitchTypePrivate):
+		spec.SwitchType = SwitchTypePrivate
+	default:
+		return fmt.Errorf("invalid switch type: %s. Must be 'External', 'Internal', or 'Private'", spec.SwitchType)
+	}
+	if spec.SwitchType != SwitchTypeExternal {
+		spec.NetAdapterName = ""
+		// Internal switches always share the host's connection, private switches never do.
+		spec.AllowManagementOS = spec.SwitchType == SwitchTypeInternal
+		return nil
+	}
+	if spec.NetAdapterName == "" {
+		return fmt.Errorf("netAdapterName is required for External switches")
+	}
+	found := false
+	for _, adapter := range s.PhysicalAdapters {
+		if strings.EqualFold(adapter, spec.NetAdapterName) {
+			found = true
+		}
+	}
+	if !found {
+		return errs.New(errs.ErrNotFound, "New-VMSwitch", fmt.Sprintf("physical network adapter %s not found", spec.NetAdapterName))
+	}
+	for _, other := range s.switches {
+		if !strings.EqualFold(other.Name, name) && strings.EqualFold(other.NetAdapterName, spec.NetAdapterName) {
+			return errs.New(errs.ErrInvalidState, "New-VMSwitch",
+				fmt.Sprintf("physical network adapter %s is already bound to virtual switch %s", spec.NetAdapterName, other.Name))
+		}
+	}
+	return nil
+}
+
+func (s *Simulator) CreateSwitch(ctx context.Context, spec Switch) (*Switch, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if spec.Name == "" {
+		return nil, fmt.Errorf("a virtual switch name is required")
+	}
+	if _, ok := s.switches[key(spec.Name)]; ok {
+		return nil, errs.New(errs.ErrAlreadyExists, "New-VMSwitch", fmt.Sprintf("virtual switch %s already exists", spec.Name))
+	}
+	if err := s.checkSwitch(spec.Name, &spec); err != nil {
+		return nil, err
+	}
+	spec.ID = s.nextID()
+	spec.BandwidthReservationMode, spec.IovEnabled = "Absolute", false
+	s.switches[key(spec.Name)] = &spec
+	result := spec
+	return &result, nil
+}
+
+func (s *Simulator) UpdateSwitch(ctx context.Context, name string, spec Switch) (*Switch, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sw, err := s.vswitch(name)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.checkSwitch(sw.Name, &spec); err != nil {
+		return nil, err
+	}
+	spec.ID, spec.Name = sw.ID, sw.Name
+	spec.BandwidthReservationMode, spec.IovEnabled = sw.BandwidthReservationMode, sw.IovEnabled
+	*sw = spec
+	return &spec, nil
+}
+
+func (s *Simulator) DeleteSwitch(ctx context.Context, name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sw, err := s.vswitch(name)
+	if err != nil {
+		return err
+	}
+	for _, vm := range s.vms {
+		for i := range vm.adapters {
+			if strings.EqualFold(vm.adapters[i].SwitchName, sw.Name) {
+				vm.adapters[i].SwitchName = ""
+			}
+		}
+	}
+	delete(s.switches, key(sw.Name))
+	return nil
+}
+
+func (s *Simulator) ListNetworkAdapters(ctx context.Context, vmName string) ([]NetworkAdapter, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	vm, err := s.vm(vmName)
+	if err != nil {
+		return nil, err
+	}
+	return append([]NetworkAdapter(nil), vm.adapters...), nil
+}
+
+// ListConnectedAdapters returns the connected adapters ordered by VM name.
+func (s *Simulator) ListConnectedAdapters(ctx context.Context, switchName string) ([]NetworkAdapter, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sw, err := s.vswitch(switchName)
+	if err != nil {
+		return nil, err
+	}
+	var adapters []NetworkAdapter
+	for _, vm := range s.vms {
+		for _, adapter := range vm.adapters {
+			if strings.EqualFold(adapter.SwitchName, sw.Name) {
+				adapters = append(adapters, adapter)
+			}
+		}
+	}
+	sort.SliceStable(adapters, func(i, j int) bool { return adapters[i].VMName < adapters[j].VMName })
+	return adapters, nil
+}
+
+// GetNetworkAdapterStatus returns the Hyper-V defaults for an adapter SetNetworkAdapterStatus
+// has not been called for. The switch port reports Ok while the adapter is connected.
+func (s *Simulator) GetNetworkAdapterStatus(ctx context.Context, vmName string, adapterName string) (*NetworkAdapterStatus, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	vm, err := s.vm(vmName)
+	if err != nil {
+		return nil, err
+	}
+	adapter, err := s.adapter(vm, adapterName)
+	if err != nil {
+		return nil, err
+	}
+	status := NetworkAdapterStatus{Vlan: VlanSettings{Mode: VlanUntagged}, PortMirroring: "None", VMQWeight: 100}
+	if port, ok := vm.ports[key(adapter.Name)]; ok {
+		status = *port
+	}
+	if status.Vlan.Mode == "" {
+		status.Vlan.Mode = VlanUntagged
+	}
+	status.Vlan.AllowedVlanIDs = append([]int(nil), status.Vlan.AllowedVlanIDs...)
+	status.Vlan.SecondaryVlanIDs = append([]int(nil), status.Vlan.SecondaryVlanIDs...)
+	status.IPAddresses = append([]string(nil), status.IPAddresses...)
+	if adapter.SwitchName == "" {
+		status.Status = ""
+	} else if status.Status == "" {
+		status.Status = "Ok"
+	}
+	return &status, nil
+}
+
+// SetNetworkAdapterStatus replaces the switch port settings and the guest addresses of an
+// adapter, as if they were changed on the host.
+func (s *Simulator) SetNetworkAdapterStatus(vmName string, adapterName string, status NetworkAdapterStatus) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	vm, err := s.vm(vmName)
+	if err != nil {
+		return err
+	}
+	adapter, err := s.adapter(vm, adapterName)
+	if err != nil {
+		return err
+	}
+	if vm.ports == nil {
+		vm.ports = map[string]*NetworkAdapterStatus{}
+	}
+	vm.ports[key(adapter.Name)] = &status
+	return nil
+}
+
+func (s *Simulator) adapter(vm *simVM, name string) (*NetworkAdapter, error) {
+	for i := range vm.adapters {
+		if strings.EqualFold(vm.adapters[i].Name, name) {
+			return &vm.adapters[i], nil
+		}
+	}
+	return nil, errs.New(errs.ErrNotFound, "Get-VMNetworkAdapter",
+		fmt.Sprintf("network adapter %s of virtual machine %s not found", name, vm.Name))
+}
+
+func (s *Simulator) AddNetworkAdapter(ctx context.Context, adapter NetworkAdapter) (*NetworkAdapter, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	vm, err := s.vm(adapter.VMName)
+	if err != nil {
+		return nil, err
+	}
+	if adapter.Name == "" {
+		adapter.Name = "Network Adapter"
+	}
+	if _, err := s.adapter(vm, adapter.Name); err == nil {
+		return nil, errs.New(errs.ErrAlreadyExists, "Add-VMNetworkAdapter",
+			fmt.Sprintf("virtual machine %s already has a network adapter %s", vm.Name, adapter.Name))
+	}
+	if adapter.SwitchName != "" {
+		sw, err := s.vswitch(adapter.SwitchName)
+		if err != nil {
+			return nil, err
+		}
+		adapter.SwitchName = sw.Name
+	}
+	adapter.VMName = vm.Name
+	adapter.MacAddress = strings.ToUpper(strings.NewReplacer("-", "", ":", "").Replace(adapter.MacAddress))
+	adapter.DynamicMacAddress = adapter.MacAddress == ""
+	if adapter.DynamicMacAddress {
+		s.serial++
+		adapter.MacAddress = fmt.Sprintf("%012X", simulatorBaseMAC+s.serial)
+	}
+	vm.adapters = append(vm.adapters, adapter)
+	return &adapter, nil
+}
+
+func (s *Simulator) ConnectNetworkAdapter(ctx context.Context, vmName string, adapterName string, switchName string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	vm, err := s.vm(vmName)
+	if err != nil {
+		return err
+	}
+	adapter, err := s.adapter(vm, adapterName)
+	if err != nil {
+		return err
+	}
+	if switchName == "" {
+		adapter.SwitchName = ""
+		return nil
+	}
+	sw, err := s.vswitch(switchName)
+	if err != nil {
+		return err
+	}
+	adapter.SwitchName = sw.Name
+	return nil
+}
+
+// SetNetworkAdapter changes the MAC address and the switch port settings of an adapter. Like
+// Hyper-V, it keeps the port settings of an adapter that is not connected to a switch.
+func (s *Simulator) SetNetworkAdapter(ctx context.Context, vmName string, adapterName string, settings NetworkAdapterSettings) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	vm, err := s.vm(vmName)
+	if err != nil {
+		return err
+	}
+	adapter, err := s.adapter(vm, adapterName)
+	if err != nil {
+		return err
+	}
+	if settings.PortMirroring != nil && !slices.ContainsFunc([]string{"None", "Destination", "Source"}, func(mode string) bool {
+		return strings.EqualFold(mode, *settings.PortMirroring)
+	}) {
+		return errs.New(errs.ErrInvalidParameter, "Set-VMNetworkAdapter", fmt.Sprintf("unsupported port mirroring mode %s", *settings.PortMirroring))
+	}
+	if settings.VMQWeight != nil && (*settings.VMQWeight < 0 || *settings.VMQWeight > 100) {
+		return errs.New(errs.ErrInvalidParameter, "Set-VMNetworkAdapter", fmt.Sprintf("VMQ weight %d is not between 0 and 100", *settings.VMQWeight))
+	}
+
+	if vm.ports == nil {
+		vm.ports = map[string]*NetworkAdapterStatus{}
+	}
+	port, ok := vm.ports[key(adapter.Name)]
+	if !ok {
+		port = &NetworkAdapterStatus{Vlan: VlanSettings{Mode: VlanUntagged}, PortMirroring: "None", VMQWeight: 100}
+		vm.ports[key(adapter.Name)] = port
+	}
+	if settings.MacAddress != nil {
+		adapter.MacAddress = strings.ToUpper(strings.NewReplacer("-", "", ":", "").Replace(*settings.MacAddress))
+		adapter.DynamicMacAddress = false
+	}
+	if settings.Vlan != nil {
+		port.Vlan = *settings.Vlan
+		port.Vlan.AllowedVlanIDs = append([]int(nil), settings.Vlan.AllowedVlanIDs...)
+		port.Vlan.SecondaryVlanIDs = append([]int(nil), settings.Vlan.SecondaryVlanIDs...)
+	}
+	if settings.DHCPGuard != nil {
+		port.DHCPGuard = *settings.DHCPGuard
+	}
+	if settings.RouterGuard != nil {
+		port.RouterGuard = *settings.RouterGuard
+	}
+	if settings.PortMirroring != nil {
+		port.PortMirroring = *settings.PortMirroring
+	}
+	if settings.IeeePriorityTag != nil {
+		port.IeeePriorityTag = *settings.IeeePriorityTag
+	}
+	if settings.VMQWeight != nil {
+		port.VMQWeight = *settings.VMQWeight
+	}
+	return nil
+}
+
+func (s *Simulator) RemoveNetworkAdapter(ctx context.Context, vmName string, adapterName string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	vm, err := s.vm(vmName)
+	if err != nil {
+		return err
+	}
+	for i := range vm.adapters {
+		if strings.EqualFold(vm.adapters[i].Name, adapterName) {
+			vm.adapters = append(vm.adapters[:i], vm.adapters[i+1:]...)
+			delete(vm.ports, key(adapterName))
+			return nil
+		}
+	}
+	return errs.New(errs.ErrNotFound, "Remove-VMNetworkAdapter",
+		fmt.Sprintf("network adapter %s of virtual machine %s not found", adapterName, vm.Name))
+}
+
+func defaultUint32(v *uint32, def uint32) {
+	if *v == 0 {
+		*v = def
+	}
+}
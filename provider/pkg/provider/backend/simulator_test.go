@@ -0,0 +1,379 @@
+// Copyright 2016-2022, Pulumi Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/pulumi/pulumi-hyperv/provider/pkg/provider/errs"
+	"github.com/pulumi/pulumi-hyperv/provider/pkg/provider/vhd"
+)
+
+func TestSimulatorVMLifecycle(t *testing.T) {
+	ctx := context.Background()
+	s := NewSimulator()
+
+	vm, err := s.CreateVM(ctx, VMSpec{Name: "web"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if vm.Generation != 2 || vm.State != PowerStateOff || vm.MemoryMB != defaultMemoryMB || vm.ProcessorCount != 1 {
+		t.Errorf("CreateVM = %+v, want an off generation 2 machine with the defaults", vm)
+	}
+	if _, err := s.CreateVM(ctx, VMSpec{Name: "WEB"}); !errors.Is(err, errs.ErrAlreadyExists) {
+		t.Errorf("CreateVM of a duplicate name = %v, want ErrAlreadyExists", err)
+	}
+	if got, err := s.GetVM(ctx, "{"+vm.ID+"}"); err != nil || got.Name != "web" {
+		t.Errorf("GetVM by ID = %+v, %v", got, err)
+	}
+	if _, err := s.GetVM(ctx, "db"); !errors.Is(err, errs.ErrNotFound) {
+		t.Errorf("GetVM of a missing machine = %v, want ErrNotFound", err)
+	}
+
+	steps := []struct {
+		state PowerState
+		ok    bool
+	}{
+		{PowerStatePaused, false},
+		{PowerStateRunning, true},
+		{PowerStatePaused, true},
+		{PowerStateSaved, true},
+		{PowerStatePaused, false},
+		{PowerStateRunning, true},
+		{PowerStateOff, true},
+	}
+	for _, step := range steps {
+		err := s.SetVMState(ctx, "web", step.state)
+		if step.ok && err != nil {
+			t.Errorf("SetVMState(%s) = %v", step.state, err)
+		}
+		if !step.ok && !errors.Is(err, errs.ErrInvalidState) {
+			t.Errorf("SetVMState(%s) = %v, want ErrInvalidState", step.state, err)
+		}
+	}
+
+	if err := s.SetVMState(ctx, "web", PowerStateRunning); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.DeleteVM(ctx, "web"); !errors.Is(err, errs.ErrInvalidState) {
+		t.Errorf("DeleteVM of a running machine = %v, want ErrInvalidState", err)
+	}
+	if err := s.SetVMState(ctx, "web", PowerStateOff); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.DeleteVM(ctx, "web"); err != nil {
+		t.Fatal(err)
+	}
+	if vms, _ := s.ListVMs(ctx); len(vms) != 0 {
+		t.Errorf("ListVMs after DeleteVM = %+v", vms)
+	}
+}
+
+func TestSimulatorDisks(t *testing.T) {
+	ctx := context.Background()
+	s := NewSimulator()
+
+	if _, err := s.CreateDisk(ctx, `C:\vms\base.vhdx`, vhd.CreateOptions{}); err == nil {
+		t.Error("CreateDisk without a size succeeded")
+	}
+	base, err := s.CreateDisk(ctx, `C:\vms\base.vhdx`, vhd.CreateOptions{VirtualSize: 1 << 30})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if base.Format != vhd.FormatVHDX || base.DiskType != vhd.TypeDynamic || base.BlockSize != 32<<20 {
+		t.Errorf("CreateDisk = %+v, want a dynamic VHDX with the default block size", base)
+	}
+	child, err := s.CreateDisk(ctx, `C:\vms\child.vhdx`, vhd.CreateOptions{DiskType: "differencing", ParentPath: `c:\VMS\base.vhdx`})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if child.VirtualSize != base.VirtualSize || child.ParentID != base.DiskID {
+		t.Errorf("differencing disk = %+v, want it to inherit from %+v", child, base)
+	}
+	if _, err := s.CreateDisk(ctx, `C:\vms\child.vhd`, vhd.CreateOptions{DiskType: "differencing", ParentPath: `C:\vms\base.vhdx`}); err == nil {
+		t.Error("CreateDisk of a VHD child of a VHDX succeeded")
+	}
+	if err := s.DeleteDisk(ctx, `C:\vms\base.vhdx`); !errors.Is(err, errs.ErrInvalidState) {
+		t.Errorf("DeleteDisk of a parent = %v, want ErrInvalidState", err)
+	}
+
+	if _, err := s.CreateVM(ctx, VMSpec{Name: "web", Generation: 1}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.AttachDisk(ctx, "web", DiskDrive{Path: `C:\vms\child.vhdx`, ControllerLocation: -1}); err == nil {
+		t.Error("AttachDisk to a generation 1 VM without SCSI controllers succeeded")
+	}
+	if err := s.SetSCSIControllerCount(ctx, "web", 1); err != nil {
+		t.Fatal(err)
+	}
+	first, err := s.AttachDisk(ctx, "web", DiskDrive{Path: `C:\vms\child.vhdx`, ControllerLocation: -1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first.ControllerType != ControllerSCSI || first.ControllerLocation != 0 {
+		t.Errorf("AttachDisk = %+v, want SCSI location 0", first)
+	}
+	if _, err := s.AttachDisk(ctx, "web", DiskDrive{Path: `C:\vms\base.vhdx`, ControllerType: "ide", ControllerNumber: 2}); err == nil {
+		t.Error("AttachDisk to IDE controller 2 succeeded")
+	}
+	if err := s.DeleteDisk(ctx, `C:\vms\child.vhdx`); !errors.Is(err, errs.ErrInvalidState) {
+		t.Errorf("DeleteDisk of an attached disk = %v, want ErrInvalidState", err)
+	}
+
+	if err := s.SetVMState(ctx, "web", PowerStateRunning); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.AttachDisk(ctx, "web", DiskDrive{Path: `C:\vms\base.vhdx`, ControllerType: "IDE"}); !errors.Is(err, errs.ErrInvalidState) {
+		t.Errorf("AttachDisk to IDE of a running machine = %v, want ErrInvalidState", err)
+	}
+	if err := s.DetachDisk(ctx, "web", `C:\VMS\CHILD.VHDX`); err != nil {
+		t.Fatal(err)
+	}
+	if drives, _ := s.ListDiskDrives(ctx, "web"); len(drives) != 0 {
+		t.Errorf("ListDiskDrives after DetachDisk = %+v", drives)
+	}
+	if err := s.DeleteDisk(ctx, `C:\vms\child.vhdx`); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.DeleteDisk(ctx, `C:\vms\base.vhdx`); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.GetDisk(ctx, `C:\vms\base.vhdx`); !errors.Is(err, errs.ErrNotFound) {
+		t.Errorf("GetDisk of a deleted disk = %v, want ErrNotFound", err)
+	}
+}
+
+func TestSimulatorDiskMaintenance(t *testing.T) {
+	ctx := context.Background()
+	s := NewSimulator()
+	for _, path := range []string{`C:\vms\os.vhdx`, `C:\vms\legacy.vhd`} {
+		if _, err := s.CreateDisk(ctx, path, vhd.CreateOptions{VirtualSize: 1 << 30}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := s.CreateDisk(ctx, `C:\vms\child.vhdx`, vhd.CreateOptions{DiskType: "differencing", ParentPath: `C:\vms\os.vhdx`}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.ResizeDisk(ctx, `C:\vms\legacy.vhd`, 1<<29); !errors.Is(err, errs.ErrInvalidParameter) {
+		t.Errorf("ResizeDisk shrinking a VHD = %v, want ErrInvalidParameter", err)
+	}
+	if err := s.SetDiskMinimumSize(`C:\vms\os.vhdx`, 3<<29); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.ResizeDisk(ctx, `C:\vms\os.vhdx`, 1<<29); !errors.Is(err, errs.ErrInvalidParameter) {
+		t.Errorf("ResizeDisk below the minimum size = %v, want ErrInvalidParameter", err)
+	}
+	if err := s.ConvertDisk(ctx, `C:\vms\child.vhdx`, "fixed"); !errors.Is(err, errs.ErrInvalidParameter) {
+		t.Errorf("ConvertDisk of a differencing disk = %v, want ErrInvalidParameter", err)
+	}
+
+	if _, err := s.CreateVM(ctx, VMSpec{Name: "web", Generation: 1}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.AttachDisk(ctx, "web", DiskDrive{Path: `C:\vms\os.vhdx`, ControllerType: "IDE"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SetVMState(ctx, "web", PowerStateRunning); err != nil {
+		t.Fatal(err)
+	}
+	users, _ := s.ListDiskUsers(ctx, `C:\VMS\OS.VHDX`)
+	if len(users) != 1 || users[0].VMName != "web" || users[0].State != PowerStateRunning || users[0].ControllerType != ControllerIDE {
+		t.Errorf("ListDiskUsers = %+v", users)
+	}
+	if err := s.ResizeDisk(ctx, `C:\vms\os.vhdx`, 2<<30); !errors.Is(err, errs.ErrInvalidState) {
+		t.Errorf("ResizeDisk on the IDE controller of a running machine = %v, want ErrInvalidState", err)
+	}
+	if err := s.CompactDisk(ctx, `C:\vms\os.vhdx`); !errors.Is(err, errs.ErrInvalidState) {
+		t.Errorf("CompactDisk of a disk in use = %v, want ErrInvalidState", err)
+	}
+	if err := s.SetVMState(ctx, "web", PowerStateOff); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.ResizeDisk(ctx, `C:\vms\os.vhdx`, 2<<30); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.ConvertDisk(ctx, `C:\vms\os.vhdx`, "fixed"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.CompactDisk(ctx, `C:\vms\os.vhdx`); !errors.Is(err, errs.ErrInvalidParameter) {
+		t.Errorf("CompactDisk of a fixed disk = %v, want ErrInvalidParameter", err)
+	}
+	if err := s.MergeDisk(ctx, `C:\vms\child.vhdx`, `C:\vms\legacy.vhd`); !errors.Is(err, errs.ErrInvalidParameter) {
+		t.Errorf("MergeDisk into a disk that is not an ancestor = %v, want ErrInvalidParameter", err)
+	}
+	if err := s.MergeDisk(ctx, `C:\vms\child.vhdx`, `C:\vms\os.vhdx`); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.GetDisk(ctx, `C:\vms\child.vhdx`); !errors.Is(err, errs.ErrNotFound) {
+		t.Errorf("GetDisk of a merged disk = %v, want ErrNotFound", err)
+	}
+
+	dest, err := s.MoveDisk(ctx, `C:\vms\legacy.vhd`, `D:\backup\`)
+	if err != nil || dest != `D:\backup\legacy.vhd` {
+		t.Errorf("MoveDisk = %q, %v", dest, err)
+	}
+	if _, err := s.CreateDisk(ctx, `C:\vms\legacy.vhd`, vhd.CreateOptions{VirtualSize: 1 << 30}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.MoveDisk(ctx, `C:\vms\legacy.vhd`, `D:\backup\legacy.vhd`); !errors.Is(err, errs.ErrAlreadyExists) {
+		t.Errorf("MoveDisk over an existing file = %v, want ErrAlreadyExists", err)
+	}
+
+	want := []string{`Resize-VHD C:\vms\os.vhdx`, `Convert-VHD C:\vms\os.vhdx`, `Merge-VHD C:\vms\child.vhdx`, `Move-Item C:\vms\legacy.vhd`}
+	if !reflect.DeepEqual(s.DiskOperations, want) {
+		t.Errorf("DiskOperations = %q, want %q", s.DiskOperations, want)
+	}
+}
+
+func TestSimulatorSwitchesAndAdapters(t *testing.T) {
+	ctx := context.Background()
+	s := NewSimulator()
+
+	if _, err := s.CreateSwitch(ctx, Switch{Name: "lan", SwitchType: "external", NetAdapterName: "Wi-Fi"}); !errors.Is(err, errs.ErrNotFound) {
+		t.Errorf("CreateSwitch with a missing adapter = %v, want ErrNotFound", err)
+	}
+	lan, err := s.CreateSwitch(ctx, Switch{Name: "lan", SwitchType: "external", NetAdapterName: "Ethernet", AllowManagementOS: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lan.SwitchType != SwitchTypeExternal || lan.ID == "" {
+		t.Errorf("CreateSwitch = %+v", lan)
+	}
+	if _, err := s.CreateSwitch(ctx, Switch{Name: "lan2", SwitchType: "External", NetAdapterName: "ethernet"}); !errors.Is(err, errs.ErrInvalidState) {
+		t.Errorf("CreateSwitch on a bound adapter = %v, want ErrInvalidState", err)
+	}
+	if _, err := s.CreateSwitch(ctx, Switch{Name: "internal", SwitchType: "Internal"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := s.CreateVM(ctx, VMSpec{Name: "web"}); err != nil {
+		t.Fatal(err)
+	}
+	adapter, err := s.AddNetworkAdapter(ctx, NetworkAdapter{VMName: "web", SwitchName: "LAN"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if adapter.Name != "Network Adapter" || adapter.SwitchName != "lan" || !adapter.DynamicMacAddress || len(adapter.MacAddress) != 12 {
+		t.Errorf("AddNetworkAdapter = %+v, want a dynamic adapter on lan", adapter)
+	}
+	static, err := s.AddNetworkAdapter(ctx, NetworkAdapter{VMName: "web", Name: "static", MacAddress: "00-15-5d-01-02-03"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if static.MacAddress != "00155D010203" || static.DynamicMacAddress || static.SwitchName != "" {
+		t.Errorf("AddNetworkAdapter = %+v, want a disconnected adapter with a static address", static)
+	}
+	if err := s.ConnectNetworkAdapter(ctx, "web", "static", "internal"); err != nil {
+		t.Fatal(err)
+	}
+
+	updated, err := s.UpdateSwitch(ctx, "lan", Switch{SwitchType: "Private", NetAdapterName: "Ethernet", Notes: "isolated"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if updated.Name != "lan" || updated.ID != lan.ID || updated.NetAdapterName != "" || updated.AllowManagementOS || updated.Notes != "isolated" {
+		t.Errorf("UpdateSwitch = %+v, want a private switch that keeps its name and ID", updated)
+	}
+
+	if err := s.DeleteSwitch(ctx, "lan"); err != nil {
+		t.Fatal(err)
+	}
+	adapters, err := s.ListNetworkAdapters(ctx, "web")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(adapters) != 2 || adapters[0].SwitchName != "" || adapters[1].SwitchName != "internal" {
+		t.Errorf("ListNetworkAdapters after DeleteSwitch = %+v, want only the adapter on lan disconnected", adapters)
+	}
+	if err := s.RemoveNetworkAdapter(ctx, "web", "Network Adapter"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.RemoveNetworkAdapter(ctx, "web", "Network Adapter"); !errors.Is(err, errs.ErrNotFound) {
+		t.Errorf("RemoveNetworkAdapter of a removed adapter = %v, want ErrNotFound", err)
+	}
+}
+
+func TestSimulatorNetworkAdapterStatus(t *testing.T) {
+	ctx := context.Background()
+	s := NewSimulator()
+	if _, err := s.CreateVM(ctx, VMSpec{Name: "web"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.AddNetworkAdapter(ctx, NetworkAdapter{VMName: "web", Name: "nic"}); err != nil {
+		t.Fatal(err)
+	}
+
+	status, err := s.GetNetworkAdapterStatus(ctx, "web", "NIC")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.Vlan.Mode != VlanUntagged || status.PortMirroring != "None" || status.VMQWeight != 100 || status.Status != "" {
+		t.Errorf("GetNetworkAdapterStatus of a disconnected adapter = %+v", status)
+	}
+
+	if _, err := s.CreateSwitch(ctx, Switch{Name: "lan", SwitchType: SwitchTypeInternal}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.ConnectNetworkAdapter(ctx, "web", "nic", "lan"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SetNetworkAdapterStatus("web", "nic", NetworkAdapterStatus{Vlan: VlanSettings{Mode: VlanAccess, AccessVlanID: 20}, IPAddresses: []string{"10.0.0.5"}}); err != nil {
+		t.Fatal(err)
+	}
+	if status, err = s.GetNetworkAdapterStatus(ctx, "web", "nic"); err != nil {
+		t.Fatal(err)
+	}
+	if status.Vlan.AccessVlanID != 20 || len(status.IPAddresses) != 1 || status.Status != "Ok" {
+		t.Errorf("GetNetworkAdapterStatus of a connected adapter = %+v", status)
+	}
+
+	mac, guard, weight, mirroring := "00-15-5d-00-00-01", true, 0, "Sideways"
+	settings := NetworkAdapterSettings{MacAddress: &mac, DHCPGuard: &guard, VMQWeight: &weight}
+	if err := s.SetNetworkAdapter(ctx, "web", "nic", settings); err != nil {
+		t.Fatal(err)
+	}
+	if status, err = s.GetNetworkAdapterStatus(ctx, "web", "nic"); err != nil {
+		t.Fatal(err)
+	}
+	if status.Vlan.AccessVlanID != 20 || !status.DHCPGuard || status.VMQWeight != 0 {
+		t.Errorf("GetNetworkAdapterStatus after SetNetworkAdapter = %+v, want only the guard and weight changed", status)
+	}
+	if adapters, _ := s.ListNetworkAdapters(ctx, "web"); adapters[0].MacAddress != "00155D000001" || adapters[0].DynamicMacAddress {
+		t.Errorf("SetNetworkAdapter left the adapter %+v", adapters[0])
+	}
+	if err := s.SetNetworkAdapter(ctx, "web", "nic", NetworkAdapterSettings{PortMirroring: &mirroring}); !errors.Is(err, errs.ErrInvalidParameter) {
+		t.Errorf("SetNetworkAdapter with an unknown port mirroring mode = %v, want ErrInvalidParameter", err)
+	}
+
+	connected, err := s.ListConnectedAdapters(ctx, "LAN")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(connected) != 1 || connected[0].Name != "nic" {
+		t.Errorf("ListConnectedAdapters = %+v", connected)
+	}
+
+	if err := s.RemoveNetworkAdapter(ctx, "web", "nic"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.GetNetworkAdapterStatus(ctx, "web", "nic"); !errors.Is(err, errs.ErrNotFound) {
+		t.Errorf("GetNetworkAdapterStatus of a removed adapter = %v, want ErrNotFound", err)
+	}
+}
@@ -0,0 +1,276 @@
+// Copyright 2016-2022, Pulumi Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package codegen
+
+import (
+	"context"
+	"encoding/json"
+	"go/parser"
+	"go/token"
+	"testing"
+
+	"github.com/pulumi/pulumi-hyperv/provider/pkg/provider/backend"
+	"github.com/pulumi/pulumi-hyperv/provider/pkg/provider/vhd"
+)
+
+// simulatedInventory collects a host with a web server on a differencing disk, connected to
+// an external switch.
+func simulatedInventory(t *testing.T) *Inventory {
+	t.Helper()
+	ctx := context.Background()
+	sim := backend.NewSimulator()
+	sim.PhysicalAdapters = []string{"Ethernet"}
+	if _, err := sim.CreateSwitch(ctx, backend.Switch{
+		Name: "External", SwitchType: backend.SwitchTypeExternal, NetAdapterName: "Ethernet", AllowManagementOS: true,
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := sim.CreateDisk(ctx, `D:\vhd\base.vhdx`, vhd.CreateOptions{VirtualSize: 40 << 30}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := sim.CreateDisk(ctx, `D:\vhd\web.vhdx`, vhd.CreateOptions{
+		DiskType: vhd.TypeDifferencing, ParentPath: `D:\vhd\base.vhdx`,
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := sim.CreateVM(ctx, backend.VMSpec{Name: "web 01", Generation: 2, MemoryMB: 2048, ProcessorCount: 2}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := sim.AttachDisk(ctx, "web 01", backend.DiskDrive{Path: `D:\vhd\web.vhdx`, ControllerType: backend.ControllerSCSI}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := sim.AddNetworkAdapter(ctx, backend.NetworkAdapter{
+		Name: "Network Adapter", VMName: "web 01", SwitchName: "External", MacAddress: "00155D010203",
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	inventory, err := Collect(ctx, sim)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return inventory
+}
+
+func TestCollect(t *testing.T) {
+	inventory := simulatedInventory(t)
+	if len(inventory.Machines) != 1 || len(inventory.Switches) != 1 {
+		t.Fatalf("collected %d machines and %d switches, want 1 and 1", len(inventory.Machines), len(inventory.Switches))
+	}
+	// The parent of the differencing disk is collected although no machine uses it.
+	if len(inventory.Disks) != 2 || inventory.Disks[1].Path != `D:\vhd\base.vhdx` {
+		t.Errorf("collected disks %+v, want the disk and its parent", inventory.Disks)
+	}
+	machine := inventory.Machines[0]
+	if len(machine.Drives) != 1 || len(machine.Adapters) != 1 {
+		t.Errorf("collected %d drives and %d adapters, want 1 and 1", len(machine.Drives), len(machine.Adapters))
+	}
+}
+
+func TestGenerateTypeScript(t *testing.T) {
+	result, err := Generate(simulatedInventory(t), TypeScript, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `import * as hyperv from "@pulumi/hyperv";
+
+const external = new hyperv.virtualswitch.VirtualSwitch("external", {
+    name: "External",
+    switchType: "External",
+    netAdapterName: "Ethernet",
+    allowManagementOs: true,
+});
+
+const baseVhdx = new hyperv.vhdfile.VhdFile("base-vhdx", {
+    path: "D:\\vhd\\base.vhdx",
+    diskType: "Dynamic",
+    sizeBytes: 42949672960,
+    blockSize: 33554432,
+});
+
+const webVhdx = new hyperv.vhdfile.VhdFile("web-vhdx", {
+    path: "D:\\vhd\\web.vhdx",
+    diskType: "Differencing",
+    blockSize: 33554432,
+    parentPath: baseVhdx.path,
+});
+
+const web01 = new hyperv.machine.Machine("web-01", {
+    machineName: "web 01",
+    generation: 2,
+    processorCount: 2,
+    memorySize: 2048,
+    dynamicMemory: false,
+    autoStartAction: "StartIfRunning",
+    autoStopAction: "Save",
+    hardDrives: [
+        {
+            path: webVhdx.path,
+            controllerType: "SCSI",
+            controllerNumber: 0,
+            controllerLocation: 0,
+        },
+    ],
+    networkAdapters: [
+        {
+            name: "Network Adapter",
+            switchName: external.name,
+            macAddress: "00155D010203",
+        },
+    ],
+});
+`
+	if result.Program != want {
+		t.Errorf("program:\n%s\nwant:\n%s", result.Program, want)
+	}
+}
+
+func TestGenerateYAML(t *testing.T) {
+	result, err := Generate(simulatedInventory(t), YAML, "lab")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `name: "lab"
+runtime: yaml
+resources:
+  external:
+    type: hyperv:virtualswitch:VirtualSwitch
+    properties:
+      name: "External"
+      switchType: "External"
+      netAdapterName: "Ethernet"
+      allowManagementOs: true
+  base-vhdx:
+    type: hyperv:vhdfile:VhdFile
+    properties:
+      path: "D:\\vhd\\base.vhdx"
+      diskType: "Dynamic"
+      sizeBytes: 42949672960
+      blockSize: 33554432
+  web-vhdx:
+    type: hyperv:vhdfile:VhdFile
+    properties:
+      path: "D:\\vhd\\web.vhdx"
+      diskType: "Differencing"
+      blockSize: 33554432
+      parentPath: ${base-vhdx.path}
+  web-01:
+    type: hyperv:machine:Machine
+    properties:
+      machineName: "web 01"
+      generation: 2
+      processorCount: 2
+      memorySize: 2048
+      dynamicMemory: false
+      autoStartAction: "StartIfRunning"
+      autoStopAction: "Save"
+      hardDrives:
+        - path: ${web-vhdx.path}
+          controllerType: "SCSI"
+          controllerNumber: 0
+          controllerLocation: 0
+      networkAdapters:
+        - name: "Network Adapter"
+          switchName: ${external.name}
+          macAddress: "00155D010203"
+`
+	if result.Program != want {
+		t.Errorf("program:\n%s\nwant:\n%s", result.Program, want)
+	}
+}
+
+func TestGenerateGo(t *testing.T) {
+	result, err := Generate(simulatedInventory(t), Go, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	file, err := parser.ParseFile(token.NewFileSet(), "main.go", result.Program, parser.ImportsOnly)
+	if err != nil {
+		t.Fatalf("generated program does not parse: %v\n%s", err, result.Program)
+	}
+	var imports []string
+	for _, spec := range file.Imports {
+		imports = append(imports, spec.Path.Value)
+	}
+	want := []string{
+		`"github.com/pulumi/pulumi-hyperv/sdk/go/hyperv/machine"`,
+		`"github.com/pulumi/pulumi-hyperv/sdk/go/hyperv/networkadapter"`,
+		`"github.com/pulumi/pulumi-hyperv/sdk/go/hyperv/vhdfile"`,
+		`"github.com/pulumi/pulumi-hyperv/sdk/go/hyperv/virtualswitch"`,
+		`"github.com/pulumi/pulumi/sdk/v3/go/pulumi"`,
+	}
+	if len(imports) != len(want) {
+		t.Fatalf("imports %v, want %v", imports, want)
+	}
+	for i := range want {
+		if imports[i] != want[i] {
+			t.Errorf("import %d = %s, want %s", i, imports[i], want[i])
+		}
+	}
+}
+
+func TestImportFile(t *testing.T) {
+	result, err := Generate(simulatedInventory(t), TypeScript, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var file struct {
+		Resources []struct{ Type, Name, ID string }
+	}
+	if err := json.Unmarshal([]byte(result.ImportFile), &file); err != nil {
+		t.Fatal(err)
+	}
+	want := [][3]string{
+		{"hyperv:virtualswitch:VirtualSwitch", "external", "External"},
+		{"hyperv:vhdfile:VhdFile", "base-vhdx", `D:\vhd\base.vhdx`},
+		{"hyperv:vhdfile:VhdFile", "web-vhdx", `D:\vhd\web.vhdx`},
+		{"hyperv:machine:Machine", "web-01", "web 01"},
+	}
+	if len(file.Resources) != len(want) {
+		t.Fatalf("imported %+v, want %v", file.Resources, want)
+	}
+	for i, r := range file.Resources {
+		if got := [3]string{r.Type, r.Name, r.ID}; got != want[i] {
+			t.Errorf("resource %d = %v, want %v", i, got, want[i])
+		}
+	}
+}
+
+func TestNames(t *testing.T) {
+	n := names{}
+	tests := []struct {
+		kind, name        string
+		logical, variable string
+	}{
+		{"vm", "Web 01", "web-01", "web01"},
+		{"vm", "web-01", "web-01-2", "web012"},
+		{"vm", "2019 Server", "2019-server", "vm2019Server"},
+		{"switch", "Default Switch", "default-switch", "defaultSwitch"},
+		{"switch", "switch", "switch", "switchSwitch"},
+		{"disk", "---", "disk", "disk"},
+	}
+	for _, tt := range tests {
+		logical, variable := n.allocate(tt.kind, tt.name)
+		if logical != tt.logical || variable != tt.variable {
+			t.Errorf("allocate(%q, %q) = %q, %q, want %q, %q", tt.kind, tt.name, logical, variable, tt.logical, tt.variable)
+		}
+	}
+}
+
+func TestGenerateUnsupportedLanguage(t *testing.T) {
+	if _, err := Generate(&Inventory{}, "cobol", ""); err == nil {
+		t.Error("Generate succeeded for an unsupported language")
+	}
+}
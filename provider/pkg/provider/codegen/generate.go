@@ -0,0 +1,249 @@
+// Copyright 2016-2022, Pulumi Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package codegen
+
+import (
+	"encoding/json"
+	"fmt"
+	"go/format"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// The languages programs are generated in.
+const (
+	TypeScript = "typescript"
+	Go         = "go"
+	YAML       = "yaml"
+)
+
+// Languages are the languages Generate supports.
+var Languages = []string{TypeScript, Go, YAML}
+
+// DefaultProject is the project name of generated YAML programs.
+const DefaultProject = "hyperv-host"
+
+// Result is a generated program.
+type Result struct {
+	// Program is the source of the program: index.ts, main.go or Pulumi.yaml.
+	Program string
+	// ImportFile is the file `pulumi import --file` adopts the resources of the program with.
+	ImportFile string
+}
+
+// Generate writes a program in language that declares the objects of the inventory, and the
+// file that imports them. project names the project of a YAML program, which is written as a
+// whole Pulumi.yaml.
+func Generate(inventory *Inventory, language, project string) (*Result, error) {
+	p := build(inventory)
+	if project == "" {
+		project = DefaultProject
+	}
+
+	var program string
+	switch strings.ToLower(language) {
+	case TypeScript, "ts":
+		program = p.typescript()
+	case Go:
+		var err error
+		if program, err = p.golang(); err != nil {
+			return nil, err
+		}
+	case YAML:
+		program = p.yaml(project)
+	default:
+		return nil, fmt.Errorf("unsupported language %q, must be one of %s", language, strings.Join(Languages, ", "))
+	}
+
+	importFile, err := p.importFile()
+	if err != nil {
+		return nil, err
+	}
+	return &Result{Program: program, ImportFile: importFile}, nil
+}
+
+// importFile writes the resources in the format of `pulumi import --file`.
+func (p *program) importFile() (string, error) {
+	type importResource struct {
+		Type string `json:"type"`
+		Name string `json:"name"`
+		ID   string `json:"id"`
+	}
+	file := struct {
+		Resources []importResource `json:"resources"`
+	}{Resources: []importResource{}}
+	for _, r := range p.resources {
+		file.Resources = append(file.Resources, importResource{Type: r.token(), Name: r.name, ID: r.id})
+	}
+	b, err := json.MarshalIndent(file, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	return string(b) + "\n", nil
+}
+
+// quote returns s as a JSON string, which TypeScript and YAML read alike.
+func quote(s string) string {
+	b, _ := json.Marshal(s)
+	return string(b)
+}
+
+func (p *program) typescript() string {
+	var sb strings.Builder
+	sb.WriteString("import * as hyperv from \"@pulumi/hyperv\";\n")
+	for _, r := range p.resources {
+		fmt.Fprintf(&sb, "\nconst %s = new hyperv.%s.%s(%s, {\n", r.variable, r.module, r.typ, quote(r.name))
+		writeTypeScriptProps(&sb, r.props, 1)
+		sb.WriteString("});\n")
+	}
+	return sb.String()
+}
+
+func writeTypeScriptProps(sb *strings.Builder, props []property, depth int) {
+	indent := strings.Repeat("    ", depth)
+	for _, prop := range props {
+		fmt.Fprintf(sb, "%s%s: ", indent, prop.key)
+		switch v := prop.value.(type) {
+		case list:
+			sb.WriteString("[\n")
+			for _, item := range v.items {
+				fmt.Fprintf(sb, "%s    {\n", indent)
+				writeTypeScriptProps(sb, item, depth+2)
+				fmt.Fprintf(sb, "%s    },\n", indent)
+			}
+			fmt.Fprintf(sb, "%s]", indent)
+		case reference:
+			fmt.Fprintf(sb, "%s.%s", v.target.variable, v.property)
+		default:
+			sb.WriteString(scalar(v))
+		}
+		sb.WriteString(",\n")
+	}
+}
+
+func (p *program) yaml(project string) string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "name: %s\nruntime: yaml\nresources:\n", quote(project))
+	for _, r := range p.resources {
+		fmt.Fprintf(&sb, "  %s:\n    type: %s\n    properties:\n", r.name, r.token())
+		writeYAMLProps(&sb, r.props, "      ", "      ")
+	}
+	return sb.String()
+}
+
+// writeYAMLProps writes props as a mapping. The first line starts with first, so the
+// mapping can be the item of a list.
+func writeYAMLProps(sb *strings.Builder, props []property, first, indent string) {
+	for i, prop := range props {
+		if i == 0 {
+			sb.WriteString(first)
+		} else {
+			sb.WriteString(indent)
+		}
+		fmt.Fprintf(sb, "%s:", prop.key)
+		switch v := prop.value.(type) {
+		case list:
+			sb.WriteString("\n")
+			for _, item := range v.items {
+				writeYAMLProps(sb, item, indent+"  - ", indent+"    ")
+			}
+		case reference:
+			fmt.Fprintf(sb, " ${%s.%s}\n", v.target.name, v.property)
+		default:
+			fmt.Fprintf(sb, " %s\n", scalar(v))
+		}
+	}
+}
+
+func (p *program) golang() (string, error) {
+	modules := map[string]bool{}
+	var body strings.Builder
+	for _, r := range p.resources {
+		modules[r.module] = true
+		call := fmt.Sprintf("%s.New%s(ctx, %s, &%s.%sArgs{\n", r.module, r.typ, strconv.Quote(r.name), r.module, r.typ)
+		// Only resources other resources refer to are kept in a variable.
+		if r.referenced {
+			fmt.Fprintf(&body, "%s, err := %s", r.variable, call)
+			writeGoProps(&body, r.props, modules)
+			body.WriteString("})\nif err != nil {\nreturn err\n}\n")
+		} else {
+			fmt.Fprintf(&body, "if _, err := %s", call)
+			writeGoProps(&body, r.props, modules)
+			body.WriteString("}); err != nil {\nreturn err\n}\n")
+		}
+	}
+
+	imports := []string{}
+	for module := range modules {
+		imports = append(imports, "github.com/pulumi/pulumi-hyperv/sdk/go/hyperv/"+module)
+	}
+	sort.Strings(imports)
+	imports = append(imports, "github.com/pulumi/pulumi/sdk/v3/go/pulumi")
+
+	var sb strings.Builder
+	sb.WriteString("package main\n\nimport (\n")
+	for _, path := range imports {
+		fmt.Fprintf(&sb, "%s\n", strconv.Quote(path))
+	}
+	sb.WriteString(")\n\nfunc main() {\npulumi.Run(func(ctx *pulumi.Context) error {\n")
+	sb.WriteString(body.String())
+	sb.WriteString("return nil\n})\n}\n")
+
+	src, err := format.Source([]byte(sb.String()))
+	if err != nil {
+		return "", fmt.Errorf("failed to format the Go program: %w", err)
+	}
+	return string(src), nil
+}
+
+func writeGoProps(sb *strings.Builder, props []property, modules map[string]bool) {
+	for _, prop := range props {
+		fmt.Fprintf(sb, "%s: ", capitalize(prop.key))
+		switch v := prop.value.(type) {
+		case list:
+			modules[strings.SplitN(v.goType, ".", 2)[0]] = true
+			fmt.Fprintf(sb, "%s{\n", v.goType)
+			for _, item := range v.items {
+				fmt.Fprintf(sb, "%s{\n", v.goElem)
+				writeGoProps(sb, item, modules)
+				sb.WriteString("},\n")
+			}
+			sb.WriteString("}")
+		case reference:
+			fmt.Fprintf(sb, "%s.%s", v.target.variable, capitalize(v.property))
+		case string:
+			fmt.Fprintf(sb, "pulumi.String(%s)", strconv.Quote(v))
+		case int64:
+			fmt.Fprintf(sb, "pulumi.Int(%d)", v)
+		case bool:
+			fmt.Fprintf(sb, "pulumi.Bool(%t)", v)
+		}
+		sb.WriteString(",\n")
+	}
+}
+
+// scalar writes a string, int64 or bool value for TypeScript and YAML.
+func scalar(v any) string {
+	switch v := v.(type) {
+	case string:
+		return quote(v)
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case bool:
+		return strconv.FormatBool(v)
+	}
+	panic(fmt.Sprintf("unexpected property value %T", v))
+}
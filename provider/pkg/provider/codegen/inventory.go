@@ -0,0 +1,85 @@
+// Copyright 2016-2022, Pulumi Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package codegen writes Pulumi programs that adopt the objects already on a Hyper-V host,
+// together with the file that imports them.
+package codegen
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/pulumi/pulumi-hyperv/provider/pkg/provider/backend"
+	"github.com/pulumi/pulumi-hyperv/provider/pkg/provider/errs"
+)
+
+// Inventory is a snapshot of the objects of a host that programs are generated for. It is
+// stored as JSON, so programs can be generated away from the host.
+type Inventory struct {
+	Switches []backend.Switch `json:"switches"`
+	// Disks are the virtual hard disks attached to the machines and their parents.
+	Disks    []backend.Disk `json:"disks"`
+	Machines []Machine      `json:"machines"`
+}
+
+// Machine is a virtual machine with its drives and network adapters.
+type Machine struct {
+	backend.VM
+	Drives   []backend.DiskDrive      `json:"drives"`
+	Adapters []backend.NetworkAdapter `json:"adapters"`
+}
+
+// Collect takes the inventory of the host b manages.
+func Collect(ctx context.Context, b backend.HypervBackend) (*Inventory, error) {
+	inventory := &Inventory{}
+	var err error
+	if inventory.Switches, err = b.ListSwitches(ctx); err != nil {
+		return nil, fmt.Errorf("failed to list the virtual switches: %w", err)
+	}
+	vms, err := b.ListVMs(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list the virtual machines: %w", err)
+	}
+
+	seen := map[string]bool{}
+	for _, vm := range vms {
+		machine := Machine{VM: vm}
+		if machine.Drives, err = b.ListDiskDrives(ctx, vm.Name); err != nil {
+			return nil, fmt.Errorf("failed to list the hard drives of VM %s: %w", vm.Name, err)
+		}
+		if machine.Adapters, err = b.ListNetworkAdapters(ctx, vm.Name); err != nil {
+			return nil, fmt.Errorf("failed to list the network adapters of VM %s: %w", vm.Name, err)
+		}
+		inventory.Machines = append(inventory.Machines, machine)
+
+		// Differencing disks are followed to their base disk.
+		for _, drive := range machine.Drives {
+			for path := drive.Path; path != "" && !seen[strings.ToLower(path)]; {
+				seen[strings.ToLower(path)] = true
+				disk, err := b.GetDisk(ctx, path)
+				if errors.Is(err, errs.ErrNotFound) {
+					break
+				}
+				if err != nil {
+					return nil, fmt.Errorf("failed to read vhd [%s]: %w", path, err)
+				}
+				inventory.Disks = append(inventory.Disks, *disk)
+				path = disk.ParentPath
+			}
+		}
+	}
+	return inventory, nil
+}
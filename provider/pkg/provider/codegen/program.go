@@ -0,0 +1,291 @@
+// Copyright 2016-2022, Pulumi Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package codegen
+
+import (
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/pulumi/pulumi-hyperv/provider/pkg/provider/backend"
+	"github.com/pulumi/pulumi-hyperv/provider/pkg/provider/vhd"
+)
+
+// resource is a resource of a generated program.
+type resource struct {
+	// module and typ make up the type token hyperv:<module>:<typ>.
+	module, typ string
+	// name is the logical name of the resource, and variable the identifier that holds it.
+	name, variable string
+	// id is the identifier the resource is imported with.
+	id    string
+	props []property
+	// referenced reports whether another resource uses an output of this one.
+	referenced bool
+}
+
+func (r *resource) token() string {
+	return "hyperv:" + r.module + ":" + r.typ
+}
+
+// property is a property of a resource or of a nested object. The value is a string, int64,
+// bool, reference or list.
+type property struct {
+	key   string
+	value any
+}
+
+// reference is an output of another resource of the program.
+type reference struct {
+	target   *resource
+	property string
+}
+
+// list is a list of nested objects. goType and goElem are the types of the list and its
+// elements in the Go SDK.
+type list struct {
+	goType, goElem string
+	items          [][]property
+}
+
+// program is the resources of a generated program, in an order where every resource follows
+// the resources it references.
+type program struct {
+	resources []*resource
+}
+
+// reserved are the identifiers generated variables must not take: keywords of TypeScript and
+// Go, and the names generated programs already use.
+var reserved = map[string]bool{}
+
+func init() {
+	for _, word := range strings.Fields(`
+		await break case catch chan class const continue debugger default defer delete do else
+		enum export extends fallthrough false finally for func function go goto if implements
+		import in instanceof interface let map new null package private protected public range
+		return select static struct super switch this throw true try type typeof var void while
+		with yield
+		ctx err main pulumi hyperv machine vhdfile virtualswitch networkadapter`) {
+		reserved[word] = true
+	}
+}
+
+// names hands out unique logical names and variables.
+type names map[string]bool
+
+// allocate returns a logical name and a variable for an object called name. kind is used
+// when name has nothing to build an identifier from.
+func (n names) allocate(kind, name string) (string, string) {
+	words := strings.FieldsFunc(strings.ToLower(name), func(r rune) bool {
+		return !(r >= 'a' && r <= 'z' || r >= '0' && r <= '9')
+	})
+	if len(words) == 0 {
+		words = []string{kind}
+	}
+	base := strings.Join(words, "-")
+	logical := base
+	for i := 2; n[logical]; i++ {
+		logical = base + "-" + strconv.Itoa(i)
+	}
+	n[logical] = true
+
+	variable := ""
+	for i, word := range strings.Split(logical, "-") {
+		if i > 0 {
+			word = capitalize(word)
+		}
+		variable += word
+	}
+	if reserved[variable] || unicode.IsDigit(rune(variable[0])) {
+		variable = kind + capitalize(variable)
+	}
+	return logical, variable
+}
+
+func capitalize(s string) string {
+	if s == "" {
+		return s
+	}
+	return strings.ToUpper(s[:1]) + s[1:]
+}
+
+// build turns the inventory into the resources of a program. Machines refer to their disks
+// and switches, and differencing disks to their parents, so the program keeps the order in
+// which they have to be created.
+func build(inventory *Inventory) *program {
+	p := &program{}
+	n := names{}
+
+	switches := map[string]*resource{}
+	for _, sw := range sortedSwitches(inventory.Switches) {
+		r := &resource{module: "virtualswitch", typ: "VirtualSwitch", id: sw.Name}
+		r.name, r.variable = n.allocate("switch", sw.Name)
+		r.props = []property{{"name", sw.Name}, {"switchType", sw.SwitchType}}
+		if sw.SwitchType == backend.SwitchTypeExternal {
+			r.props = append(r.props,
+				property{"netAdapterName", sw.NetAdapterName},
+				property{"allowManagementOs", sw.AllowManagementOS})
+		}
+		if sw.Notes != "" {
+			r.props = append(r.props, property{"notes", sw.Notes})
+		}
+		switches[strings.ToLower(sw.Name)] = r
+		p.resources = append(p.resources, r)
+	}
+
+	disks := map[string]backend.Disk{}
+	for _, disk := range inventory.Disks {
+		disks[normalize(disk.Path)] = disk
+	}
+	diskResources := map[string]*resource{}
+	var addDisk func(disk backend.Disk) *resource
+	addDisk = func(disk backend.Disk) *resource {
+		if r, ok := diskResources[normalize(disk.Path)]; ok {
+			return r
+		}
+		// The parent is declared before the disk that refers to it.
+		var parent any
+		if disk.ParentPath != "" {
+			parent = disk.ParentPath
+			if parentDisk, ok := disks[normalize(disk.ParentPath)]; ok {
+				parent = refer(addDisk(parentDisk), "path")
+			}
+		}
+		r := &resource{module: "vhdfile", typ: "VhdFile", id: disk.Path}
+		r.name, r.variable = n.allocate("disk", baseName(disk.Path))
+		r.props = []property{{"path", disk.Path}, {"diskType", disk.DiskType}}
+		if disk.DiskType != vhd.TypeDifferencing {
+			r.props = append(r.props, property{"sizeBytes", int64(disk.VirtualSize)})
+		}
+		if disk.BlockSize != 0 {
+			r.props = append(r.props, property{"blockSize", int64(disk.BlockSize)})
+		}
+		if parent != nil {
+			r.props = append(r.props, property{"parentPath", parent})
+		}
+		diskResources[normalize(disk.Path)] = r
+		p.resources = append(p.resources, r)
+		return r
+	}
+	for _, disk := range sortedDisks(inventory.Disks) {
+		addDisk(disk)
+	}
+
+	for _, vm := range sortedMachines(inventory.Machines) {
+		r := &resource{module: "machine", typ: "Machine", id: vm.Name}
+		r.name, r.variable = n.allocate("vm", vm.Name)
+		r.props = []property{
+			{"machineName", vm.Name},
+			{"generation", int64(vm.Generation)},
+			{"processorCount", int64(vm.ProcessorCount)},
+			{"memorySize", int64(vm.MemoryMB)},
+			{"dynamicMemory", vm.DynamicMemory},
+		}
+		if vm.DynamicMemory {
+			r.props = append(r.props,
+				property{"minimumMemory", int64(vm.MinimumMemoryMB)},
+				property{"maximumMemory", int64(vm.MaximumMemoryMB)})
+		}
+		if vm.AutomaticStartAction != "" {
+			r.props = append(r.props, property{"autoStartAction", vm.AutomaticStartAction})
+		}
+		if vm.AutomaticStopAction != "" {
+			r.props = append(r.props, property{"autoStopAction", vm.AutomaticStopAction})
+		}
+
+		drives := list{goType: "machine.HardDriveInputArray", goElem: "machine.HardDriveInputArgs"}
+		for _, drive := range vm.Drives {
+			// Pass-through disks have no path and are not part of the hard drives.
+			if drive.Path == "" {
+				continue
+			}
+			var diskPath any = drive.Path
+			if disk, ok := diskResources[normalize(drive.Path)]; ok {
+				diskPath = refer(disk, "path")
+			}
+			drives.items = append(drives.items, []property{
+				{"path", diskPath},
+				{"controllerType", drive.ControllerType},
+				{"controllerNumber", int64(drive.ControllerNumber)},
+				{"controllerLocation", int64(drive.ControllerLocation)},
+			})
+		}
+		if len(drives.items) > 0 {
+			r.props = append(r.props, property{"hardDrives", drives})
+		}
+
+		adapters := list{goType: "networkadapter.NetworkAdapterInputsArray", goElem: "networkadapter.NetworkAdapterInputsArgs"}
+		for _, adapter := range vm.Adapters {
+			item := []property{{"name", adapter.Name}}
+			if adapter.SwitchName != "" {
+				var switchName any = adapter.SwitchName
+				if sw, ok := switches[strings.ToLower(adapter.SwitchName)]; ok {
+					switchName = refer(sw, "name")
+				}
+				item = append(item, property{"switchName", switchName})
+			}
+			if !adapter.DynamicMacAddress && adapter.MacAddress != "" {
+				item = append(item, property{"macAddress", adapter.MacAddress})
+			}
+			adapters.items = append(adapters.items, item)
+		}
+		if len(adapters.items) > 0 {
+			r.props = append(r.props, property{"networkAdapters", adapters})
+		}
+		p.resources = append(p.resources, r)
+	}
+	return p
+}
+
+func refer(target *resource, property string) reference {
+	target.referenced = true
+	return reference{target: target, property: property}
+}
+
+// normalize returns the key a Windows path is compared by.
+func normalize(p string) string {
+	return strings.ToLower(strings.ReplaceAll(p, "/", `\`))
+}
+
+// baseName returns the file name of a Windows path.
+func baseName(p string) string {
+	return path.Base(strings.ReplaceAll(p, `\`, "/"))
+}
+
+func sortedSwitches(switches []backend.Switch) []backend.Switch {
+	sorted := append([]backend.Switch(nil), switches...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return strings.ToLower(sorted[i].Name) < strings.ToLower(sorted[j].Name)
+	})
+	return sorted
+}
+
+func sortedDisks(disks []backend.Disk) []backend.Disk {
+	sorted := append([]backend.Disk(nil), disks...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return normalize(sorted[i].Path) < normalize(sorted[j].Path)
+	})
+	return sorted
+}
+
+func sortedMachines(machines []Machine) []Machine {
+	sorted := append([]Machine(nil), machines...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return strings.ToLower(sorted[i].Name) < strings.ToLower(sorted[j].Name)
+	})
+	return sorted
+}
@@ -1,5 +0,0 @@
-package common
-
-type Config struct {
-	Host string `pulumi:"host,optional"`
-}
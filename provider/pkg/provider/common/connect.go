@@ -0,0 +1,76 @@
+package common
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/microsoft/wmi/pkg/base/host"
+	"github.com/pulumi/pulumi-hyperv/provider/pkg/provider/backend"
+	"github.com/pulumi/pulumi-hyperv/provider/pkg/provider/config"
+	"github.com/pulumi/pulumi-hyperv/provider/pkg/provider/logging"
+	"github.com/pulumi/pulumi-hyperv/provider/pkg/provider/util"
+	"github.com/pulumi/pulumi-hyperv/provider/pkg/provider/vmms"
+)
+
+// capabilities keeps the capabilities of the hosts the provider connects to.
+var capabilities backend.CapabilityCache
+
+// Connect returns the backend for the host in the provider configuration, as the backend
+// option selects. It is the backend.Connector of the provider. The backend that is chosen is
+// logged, so that the log of every resource operation records which one performed it.
+func Connect(ctx context.Context) (backend.HypervBackend, error) {
+	logger := logging.GetLogger(ctx)
+	selection := config.Selection(ctx)
+	host := config.Get(ctx).Host
+	powershell := backend.NewPowerShell(util.RunPowerShellCommand)
+	powershell.RunIdempotent = util.RunIdempotentPowerShellCommand
+
+	var vmmsClient *vmms.VMMS
+	var vmmsErr error
+	if selection.UseWMI() {
+		vmmsClient, vmmsErr = newVMMS(ctx)
+	}
+	useWMI, err := selection.Choose(vmmsErr)
+	if err != nil {
+		return nil, err
+	}
+	if !useWMI {
+		if vmmsErr != nil {
+			logger.Warnf("Failed to connect to Hyper-V using WMI: %v", vmmsErr)
+		}
+		logger.Infof("Using the powershell backend (backend %s, strict %t)", selection.Mode, selection.Strict)
+		return capabilities.Wrap(powershell, host), nil
+	}
+	vmmsClient.SetSelection(selection)
+	logger.Infof("Using the wmi backend (backend %s, strict %t)", selection.Mode, selection.Strict)
+	return capabilities.Wrap(vmmsClient.Backend(powershell), host), nil
+}
+
+// newVMMS connects to the Virtual System Management Service of the configured host.
+func newVMMS(ctx context.Context) (*vmms.VMMS, error) {
+	hostName := config.Get(ctx).Host
+	var whost *host.WmiHost
+	if hostName != "" {
+		whost = host.NewWmiHost(hostName)
+	} else {
+		whost = host.NewWmiLocalHost()
+	}
+
+	var vmmsClient *vmms.VMMS
+	var vmmsErr error
+	func() {
+		defer func() {
+			if r := recover(); r != nil {
+				vmmsErr = fmt.Errorf("recovered from panic in NewVMMS: %v", r)
+			}
+		}()
+		vmmsClient, vmmsErr = vmms.NewVMMS(ctx, whost)
+	}()
+	if vmmsErr != nil {
+		return nil, vmmsErr
+	}
+	if vmmsClient == nil || vmmsClient.GetVirtualSystemManagementService() == nil {
+		return nil, fmt.Errorf("the Virtual System Management Service is unavailable")
+	}
+	return vmmsClient, nil
+}
@@ -1,9 +1,15 @@
 package common
 
 import (
+	"context"
 	"fmt"
 
+	"github.com/microsoft/wmi/pkg/virtualization/core/resource/resourceallocation"
+	"github.com/microsoft/wmi/pkg/virtualization/core/virtualsystem"
 	wmi "github.com/microsoft/wmi/pkg/wmiinstance" // Updated import path
+	v2 "github.com/microsoft/wmi/server2019/root/virtualization/v2"
+	"github.com/pulumi/pulumi-hyperv/provider/pkg/provider/allocation"
+	"github.com/pulumi/pulumi-hyperv/provider/pkg/provider/errs"
 	"github.com/pulumi/pulumi-hyperv/provider/pkg/provider/vmms"
 )
 
@@ -47,103 +53,120 @@ func ResourceSubType(r Resource) string {
 	return resourceSubType
 }
 
-// AddResourceSettings adds resource settings to a system.
-func AddResourceSettings(v *vmms.VMMS, systemSettings *wmi.WmiInstance, resourceSettings []*wmi.WmiInstance) ([]*wmi.WmiInstance, error) {
-	return nil, fmt.Errorf("AddResourceSettings not implemented")
-	// 	var resultingResourceSettings []*wmi.WmiInstance
-
-	// 	// Convert resource settings to an array of strings
-	// 	rsStrings := make([]string, len(resourceSettings))
-	// 	for i, rs := range resourceSettings {
-	// 		rsStrings[i] = rs.InstancePath()
-	// 	}
-
-	// 	params := map[string]interface{}{
-	// 		"AffectedConfiguration": systemSettings.InstancePath(),
-	// 		"ResourceSettings":      rsStrings,
-	// 	}
-
-	// 	result, err := v.VirtualMachineManagementService().InvokeMethod("AddResourceSettings", params)
-	// 	if err != nil {
-	// 		return nil, fmt.Errorf("failed to add resource settings: %w", err)
-	// 	}
-
-	// 	if err := v.ValidateOutput(result); err != nil {
-	// 		return nil, err
-	// 	}
-
-	// 	resultStrings, err := result.GetStringArray("ResultingResourceSettings")
-	// 	if err != nil {
-	// 		return nil, fmt.Errorf("failed to get resulting resource settings: %w", err)
-	// 	}
-
-	// 	for _, path := range resultStrings {
-	// 		obj, err := v.VirtualizationConn().Get(path)
-	// 		if err != nil {
-	// 			return nil, fmt.Errorf("failed to get resource setting object: %w", err)
-	// 		}
-	// 		resultingResourceSettings = append(resultingResourceSettings, obj)
-	// 	}
-
-	// 	return resultingResourceSettings, nil
-	// }
-
-	// // CreateResource creates a resource of the specified type.
-	// func CreateResource(v *vmms.VMMS, resource Resource) (*wmi.WmiInstance, error) {
-	// 	resourcePoolClass := "Msvm_ResourcePool"
-	// 	if resource == ResourceProcessor {
-	// 		resourcePoolClass = "Msvm_ProcessorPool"
-	// 	}
-
-	// 	resourceSubType := ResourceSubType(resource)
-
-	// 	// Query for the resource pool
-	// 	query := fmt.Sprintf("SELECT * FROM %s WHERE ResourceSubType = '%s' AND Primordial = TRUE", resourcePoolClass, resourceSubType)
-	// 	resourcePools, err := v.VirtualizationConn().Query(query)
-	// 	if err != nil {
-	// 		return nil, fmt.Errorf("failed to query resource pool: %w", err)
-	// 	}
-
-	// 	if len(resourcePools) == 0 {
-	// 		return nil, fmt.Errorf("no resource pool found for resource subtype %s", resourceSubType)
-	// 	}
+// relatedResources returns the resource settings of vm with the subtype of r.
+func relatedResources(vm *virtualsystem.VirtualMachine, r Resource) (resourceallocation.ResourceAllocationSettingDataCollection, error) {
+	vmSettings, err := vm.GetVirtualSystemSettingData()
+	if err != nil {
+		return nil, err
+	}
+	defer vmSettings.Close()
+	related, err := vmSettings.GetAllRelated(SettingsClass(SettingResource))
+	if err != nil {
+		return nil, err
+	}
+	var col resourceallocation.ResourceAllocationSettingDataCollection
+	for _, instance := range related {
+		rasd, err := resourceallocation.NewResourceAllocationSettingData(instance)
+		if err != nil {
+			instance.Close()
+			continue
+		}
+		if subType, _ := rasd.GetPropertyResourceSubType(); subType != ResourceSubType(r) {
+			rasd.Close()
+			continue
+		}
+		col = append(col, rasd)
+	}
+	return col, nil
+}
 
-	// 	// Get allocation capabilities
-	// 	capQuery := fmt.Sprintf("ASSOCIATORS OF {%s} WHERE ResultClass=Msvm_AllocationCapabilities", resourcePools[0].Path())
-	// 	caps, err := v.VirtualizationConn().Query(capQuery)
-	// 	if err != nil {
-	// 		return nil, fmt.Errorf("failed to query allocation capabilities: %w", err)
-	// 	}
+// resourcePoolType returns the ResourceType of the pool that provides r.
+func resourcePoolType(r Resource) v2.ResourcePool_ResourceType {
+	switch r {
+	case ResourceProcessor:
+		return v2.ResourcePool_ResourceType_Processor
+	case ResourceMemory:
+		return v2.ResourcePool_ResourceType_Memory
+	case ResourceSCSIController:
+		return v2.ResourcePool_ResourceType_Parallel_SCSI_HBA
+	case ResourceVirtualHardDrive:
+		return v2.ResourcePool_ResourceType_Disk_Drive
+	case ResourceVirtualHardDisk:
+		return v2.ResourcePool_ResourceType_Logical_Disk
+	case ResourceVirtualDvdDrive:
+		return v2.ResourcePool_ResourceType_DVD_drive
+	case ResourceVirtualDvdDisk:
+		return v2.ResourcePool_ResourceType_Logical_Disk
+	case ResourceNetworkAdapter:
+		return v2.ResourcePool_ResourceType_Ethernet_Adapter
+	case ResourceSwitchPort:
+		return v2.ResourcePool_ResourceType_Ethernet_Connection
+	}
+	return v2.ResourcePool_ResourceType_Other
+}
 
-	// 	if len(caps) == 0 {
-	// 		return nil, fmt.Errorf("no allocation capabilities found for resource subtype %s", resourceSubType)
-	// 	}
+// CreateResource returns new settings for a resource of type r, based on the default
+// settings of its primordial pool. Set properties on them and pass them to
+// AddResourceSettings.
+func CreateResource(v *vmms.VMMS, r Resource) (*allocation.Settings, error) {
+	return allocation.DefaultSettings(v.AllocationSession(), uint16(resourcePoolType(r)), ResourceSubType(r))
+}
 
-	// 	// Get default settings
-	// 	settingQuery := fmt.Sprintf("ASSOCIATORS OF {%s} WHERE ResultClass=Msvm_SettingsDefineCapabilities", caps[0].Path())
-	// 	settings, err := v.VirtualizationConn().Query(settingQuery)
-	// 	if err != nil {
-	// 		return nil, fmt.Errorf("failed to query settings: %w", err)
-	// 	}
+// AddResourceSettings adds resources with the given settings to the system whose
+// Msvm_VirtualSystemSettingData is systemSettings, waits for the job and returns the
+// resulting settings. Transient failures are retried.
+func AddResourceSettings(ctx context.Context, v *vmms.VMMS, systemSettings *wmi.WmiInstance, resourceSettings []*allocation.Settings) ([]*wmi.WmiInstance, error) {
+	var paths []string
+	err := errs.Retry(ctx, func() (err error) {
+		paths, err = allocation.Add(ctx, v.AllocationSession(), systemSettings.InstancePath(), resourceSettings, allocation.Options{})
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return getInstances(v, paths)
+}
 
-	// 	var defaultSettingPath string
-	// 	for _, setting := range settings {
-	// 		valueRole, err := setting.GetUint16("ValueRole")
-	// 		if err != nil {
-	// 			continue
-	// 		}
-	// 		if valueRole == 0 {
-	// 			defaultSettingPath, err = setting.GetString("PartComponent")
-	// 			if err != nil {
-	// 				continue
-	// 			}
-	// 			break
-	// 		}
-	// 	}
+// ModifyResourceSettings changes the settings of existing resources, waits for the job and
+// returns the resulting settings.
+func ModifyResourceSettings(ctx context.Context, v *vmms.VMMS, resourceSettings []*allocation.Settings) ([]*wmi.WmiInstance, error) {
+	var paths []string
+	err := errs.Retry(ctx, func() (err error) {
+		paths, err = allocation.Modify(ctx, v.AllocationSession(), resourceSettings, allocation.Options{})
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return getInstances(v, paths)
+}
 
-	// 	if defaultSettingPath == "" {
-	// 		return nil, fmt.Errorf("unable to find the Default Resource Settings")
-	// 	}
+// RemoveResourceSettings removes the resources with the given settings and waits for the job.
+func RemoveResourceSettings(ctx context.Context, v *vmms.VMMS, resourceSettings []*wmi.WmiInstance) error {
+	paths := make([]string, len(resourceSettings))
+	for i, rs := range resourceSettings {
+		paths[i] = rs.InstancePath()
+	}
+	return errs.Retry(ctx, func() error {
+		return allocation.Remove(ctx, v.AllocationSession(), paths, allocation.Options{})
+	})
+}
 
-	// return v.VirtualizationConn().Get(defaultSettingPath)
+// getInstances loads the instances at paths.
+func getInstances(v *vmms.VMMS, paths []string) (instances []*wmi.WmiInstance, err error) {
+	conn := v.GetVirtualizationConn()
+	if conn == nil {
+		return nil, fmt.Errorf("virtualization connection is unavailable")
+	}
+	for _, path := range paths {
+		instance, err := conn.GetInstance(path)
+		if err != nil {
+			for _, loaded := range instances {
+				loaded.Close()
+			}
+			return nil, fmt.Errorf("failed to get resource settings [%s]: %w", path, err)
+		}
+		instances = append(instances, instance)
+	}
+	return instances, nil
 }
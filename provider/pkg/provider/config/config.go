@@ -0,0 +1,78 @@
+// Copyright 2016-2022, Pulumi Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package config holds the configuration of the provider.
+package config
+
+import (
+	"context"
+
+	"github.com/pulumi/pulumi-go-provider/infer"
+
+	"github.com/pulumi/pulumi-hyperv/provider/pkg/provider/backend"
+)
+
+type Config struct {
+	Host string `pulumi:"host,optional"`
+	// Backend selects how the provider manages the host: auto, wmi or powershell.
+	Backend string `pulumi:"backend,optional"`
+	// StrictBackend makes operations fail instead of falling back from WMI to PowerShell.
+	StrictBackend bool `pulumi:"strictBackend,optional"`
+}
+
+func (c *Config) Annotate(a infer.Annotator) {
+	a.Describe(&c.Host, "The Hyper-V host to manage. Defaults to the local host.")
+	a.Describe(&c.Backend, "How the provider manages the host. auto uses WMI when the Hyper-V WMI provider is available and PowerShell otherwise. wmi always uses WMI and fails when it is unavailable. powershell only uses the Hyper-V PowerShell module. Defaults to auto.")
+	a.Describe(&c.StrictBackend, "Fail an operation that fails using WMI, instead of repeating it with PowerShell, so that every operation runs the way the backend option selects.")
+}
+
+// Configure validates the backend option.
+func (c *Config) Configure(ctx context.Context) error {
+	_, err := backend.ParseMode(c.Backend)
+	return err
+}
+
+// Get returns the provider configuration of ctx. A context without one, such as that of a
+// test, has the zero configuration: the local host and the auto backend.
+func Get(ctx context.Context) (config Config) {
+	defer func() {
+		if r := recover(); r != nil {
+			config = Config{}
+		}
+	}()
+	return infer.GetConfig[Config](ctx)
+}
+
+// Local reports whether the configured host is the one the provider runs on, so that its
+// files can be read directly.
+func Local(ctx context.Context) bool {
+	return Get(ctx).Host == ""
+}
+
+// Selection returns how the resource operations in ctx choose between WMI and PowerShell.
+func Selection(ctx context.Context) backend.Selection {
+	config := Get(ctx)
+	mode, err := backend.ParseMode(config.Backend)
+	if err != nil {
+		// Configure rejects invalid modes before any resource operation runs.
+		mode = backend.ModeAuto
+	}
+	return backend.Selection{Mode: mode, Strict: config.StrictBackend}
+}
+
+// Fallback decides whether op, which failed with err using WMI, may be repeated with
+// PowerShell. It returns nil when it may.
+func Fallback(ctx context.Context, op string, err error) error {
+	return Selection(ctx).Fallback(op, err)
+}
@@ -0,0 +1,170 @@
+// Copyright 2016-2022, Pulumi Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package errs classifies the failures of Hyper-V operations, whether they come from WMI or
+// PowerShell, so callers can react to them with errors.Is instead of matching messages.
+package errs
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Classes of failures. ErrServiceRestarted is also ErrTransient.
+var (
+	ErrNotFound              = errors.New("not found")
+	ErrAlreadyExists         = errors.New("already exists")
+	ErrAccessDenied          = errors.New("access denied")
+	ErrInvalidState          = errors.New("invalid state")
+	ErrInsufficientResources = errors.New("insufficient resources")
+	ErrNotSupported          = errors.New("not supported")
+	ErrInvalidParameter      = errors.New("invalid parameter")
+	ErrTerminated            = errors.New("terminated before it completed")
+	ErrTransient             = errors.New("transient failure")
+	ErrServiceRestarted      = fmt.Errorf("the Hyper-V service restarted: %w", ErrTransient)
+)
+
+// kinds are the classes in the order KindOf checks them.
+var kinds = []error{
+	ErrServiceRestarted,
+	ErrNotFound,
+	ErrAlreadyExists,
+	ErrAccessDenied,
+	ErrInvalidState,
+	ErrInsufficientResources,
+	ErrNotSupported,
+	ErrInvalidParameter,
+	ErrTerminated,
+	ErrTransient,
+}
+
+// Error is a failure of an operation with a class.
+type Error struct {
+	// Kind is the class of the failure, one of the Err variables of this package.
+	Kind error
+	// Op is the operation that failed, such as a cmdlet or a WMI method.
+	Op string
+	// Message describes the failure for users. It defaults to Op and Err.
+	Message string
+	// Err is the underlying error, if any.
+	Err error
+}
+
+// New returns an error of the given class with a message for users.
+func New(kind error, op string, message string) *Error {
+	return &Error{Kind: kind, Op: op, Message: message}
+}
+
+// Wrap returns err as an error of the given class.
+func Wrap(kind error, op string, err error) *Error {
+	return &Error{Kind: kind, Op: op, Err: err}
+}
+
+func (e *Error) Error() string {
+	switch {
+	case e.Message != "":
+		return e.Message
+	case e.Err != nil:
+		return fmt.Sprintf("%s failed: %v", e.Op, e.Err)
+	default:
+		return fmt.Sprintf("%s failed: %v", e.Op, e.Kind)
+	}
+}
+
+// Unwrap returns the class and the underlying error, so errors.Is matches both.
+func (e *Error) Unwrap() []error {
+	var errs []error
+	if e.Kind != nil {
+		errs = append(errs, e.Kind)
+	}
+	if e.Err != nil {
+		errs = append(errs, e.Err)
+	}
+	return errs
+}
+
+// KindOf returns the class of err, or nil if it has none.
+func KindOf(err error) error {
+	for _, kind := range kinds {
+		if errors.Is(err, kind) {
+			return kind
+		}
+	}
+	return nil
+}
+
+// IsTransient reports whether the operation that failed with err may succeed if it is tried
+// again.
+func IsTransient(err error) bool {
+	return errors.Is(err, ErrTransient)
+}
+
+// powerShellPatterns map messages in the output of Hyper-V cmdlets to classes. The first
+// pattern that matches wins.
+var powerShellPatterns = []struct {
+	pattern string
+	kind    error
+}{
+	{"the process hosting the server process terminated unexpectedly", ErrServiceRestarted},
+	{"the rpc server is unavailable", ErrServiceRestarted},
+	{"objectnotfound", ErrNotFound},
+	{"unable to find a virtual machine", ErrNotFound},
+	{"resourceexists", ErrAlreadyExists},
+	{"already exists", ErrAlreadyExists},
+	{"access is denied", ErrAccessDenied},
+	{"accessdenied", ErrAccessDenied},
+	{"permissiondenied", ErrAccessDenied},
+	{"not enough memory", ErrInsufficientResources},
+	{"out of memory", ErrInsufficientResources},
+	{"insufficient system resources", ErrInsufficientResources},
+	{"not enough space on the disk", ErrInsufficientResources},
+	{"cannot be performed while the object is in its current state", ErrInvalidState},
+	{"invalid state for this operation", ErrInvalidState},
+	{"the operation timed out", ErrTransient},
+	{"is being used by another process", ErrTransient},
+	{"the system is in use", ErrTransient},
+}
+
+// ClassifyPowerShell returns the class of a failure from the output of a PowerShell command,
+// or nil if the output does not match any.
+func ClassifyPowerShell(output string) error {
+	lower := strings.ToLower(output)
+	for _, p := range powerShellPatterns {
+		if strings.Contains(lower, p.pattern) {
+			return p.kind
+		}
+	}
+	return nil
+}
+
+// ClassifyReturnValue returns the class of a return value of a Hyper-V WMI service method,
+// or the ErrorCode of its job, or nil if it has none.
+func ClassifyReturnValue(code uint32) error {
+	switch code {
+	case 1, 32770:
+		return ErrNotSupported
+	case 4, 6, 32773, 32776:
+		return ErrInvalidParameter
+	case 32769:
+		return ErrAccessDenied
+	case 5, 32775:
+		return ErrInvalidState
+	case 32778:
+		return ErrInsufficientResources
+	case 3, 32772, 32774, 32777:
+		return ErrTransient
+	}
+	return nil
+}
@@ -0,0 +1,112 @@
+// Copyright 2016-2022, Pulumi Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package errs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestClassifyPowerShell(t *testing.T) {
+	tests := []struct {
+		output string
+		want   error
+	}{
+		{"Get-VM : Hyper-V was unable to find a virtual machine with name \"web\".\n+ CategoryInfo : InvalidArgument: (web:String) [Get-VM], VirtualizationException\n+ FullyQualifiedErrorId : InvalidParameter,Microsoft.HyperV.PowerShell.Commands.GetVM", ErrNotFound},
+		{"Get-VHD : Cannot find path.\n+ CategoryInfo : ObjectNotFound: (C:\\disk.vhdx:String) [Get-VHD]", ErrNotFound},
+		{"New-VMSwitch : Failed while adding virtual Ethernet switch connections.\n+ CategoryInfo : ResourceExists: (:) [New-VMSwitch], VirtualizationException", ErrAlreadyExists},
+		{"Start-VM : Access is denied.", ErrAccessDenied},
+		{"Start-VM : 'web' could not initialize. Not enough memory in the system to start the virtual machine web.", ErrInsufficientResources},
+		{"Stop-VM : The operation cannot be performed while the object is in its current state.", ErrInvalidState},
+		{"Get-VM : The operation failed because the process hosting the server process terminated unexpectedly.", ErrServiceRestarted},
+		{"Mount-VHD : The process cannot access the file because it is being used by another process.", ErrTransient},
+		{"Set-VM : The parameter is incorrect.", nil},
+	}
+	for _, tt := range tests {
+		if got := ClassifyPowerShell(tt.output); got != tt.want {
+			t.Errorf("ClassifyPowerShell(%q) = %v, want %v", tt.output, got, tt.want)
+		}
+	}
+}
+
+func TestError(t *testing.T) {
+	cause := errors.New("exit status 1")
+	err := fmt.Errorf("failed to start the VM: %w", Wrap(ErrServiceRestarted, "Start-VM", cause))
+	if !errors.Is(err, ErrServiceRestarted) || !errors.Is(err, ErrTransient) || !errors.Is(err, cause) {
+		t.Errorf("%v is not the service restarting, transient and its cause", err)
+	}
+	if KindOf(err) != ErrServiceRestarted {
+		t.Errorf("KindOf(%v) = %v", err, KindOf(err))
+	}
+	if got, want := err.Error(), "failed to start the VM: Start-VM failed: exit status 1"; got != want {
+		t.Errorf("Error() = %q, want %q", got, want)
+	}
+
+	notFound := New(ErrNotFound, "Get-VM", "virtual machine not found: 'web'")
+	if !errors.Is(notFound, ErrNotFound) || IsTransient(notFound) || notFound.Error() != "virtual machine not found: 'web'" {
+		t.Errorf("New returned %v", notFound)
+	}
+	if KindOf(errors.New("other")) != nil {
+		t.Error("an error without a class has one")
+	}
+}
+
+func TestRetryPolicy(t *testing.T) {
+	policy := RetryPolicy{Attempts: 4, InitialDelay: time.Millisecond, MaxDelay: 2 * time.Millisecond}
+
+	calls := 0
+	err := policy.Do(context.Background(), func() error {
+		calls++
+		if calls < 3 {
+			return Wrap(ErrServiceRestarted, "Get-VM", errors.New("exit status 1"))
+		}
+		return nil
+	})
+	if err != nil || calls != 3 {
+		t.Errorf("Do = %v after %d calls, want success after 3", err, calls)
+	}
+
+	calls = 0
+	err = policy.Do(context.Background(), func() error {
+		calls++
+		return Wrap(ErrTransient, "Mount-VHD", errors.New("in use"))
+	})
+	if !IsTransient(err) || calls != 4 {
+		t.Errorf("Do = %v after %d calls, want the transient error after 4", err, calls)
+	}
+
+	calls = 0
+	err = policy.Do(context.Background(), func() error {
+		calls++
+		return New(ErrAccessDenied, "Start-VM", "access denied")
+	})
+	if !errors.Is(err, ErrAccessDenied) || calls != 1 {
+		t.Errorf("Do = %v after %d calls, want no retries of access denied", err, calls)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	calls = 0
+	err = RetryPolicy{Attempts: 10, InitialDelay: time.Hour}.Do(ctx, func() error {
+		calls++
+		return Wrap(ErrTransient, "Get-VM", errors.New("timed out"))
+	})
+	if err == nil || calls != 1 {
+		t.Errorf("Do = %v after %d calls, want it to stop when the context is done", err, calls)
+	}
+}
@@ -0,0 +1,68 @@
+// Copyright 2016-2022, Pulumi Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package errs
+
+import (
+	"context"
+	"time"
+)
+
+// RetryPolicy retries operations that fail with transient errors, waiting longer after each
+// attempt.
+type RetryPolicy struct {
+	// Attempts is the most times the operation runs, including the first.
+	Attempts int
+	// InitialDelay is the wait after the first failure. It doubles after every attempt.
+	InitialDelay time.Duration
+	// MaxDelay bounds the wait between two attempts.
+	MaxDelay time.Duration
+}
+
+// DefaultRetryPolicy tries an operation up to 5 times over about 15 seconds, which covers
+// a restart of the Hyper-V Virtual Machine Management service.
+var DefaultRetryPolicy = RetryPolicy{
+	Attempts:     5,
+	InitialDelay: time.Second,
+	MaxDelay:     8 * time.Second,
+}
+
+// Do runs op until it succeeds, fails with an error that is not transient, runs out of
+// attempts or ctx is done. It returns the last error of op.
+func (p RetryPolicy) Do(ctx context.Context, op func() error) error {
+	delay := p.InitialDelay
+	for attempt := 1; ; attempt++ {
+		err := op()
+		if err == nil || !IsTransient(err) || attempt >= p.Attempts {
+			return err
+		}
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+		delay *= 2
+		if p.MaxDelay > 0 && delay > p.MaxDelay {
+			delay = p.MaxDelay
+		}
+	}
+}
+
+// Retry runs op with DefaultRetryPolicy.
+func Retry(ctx context.Context, op func() error) error {
+	return DefaultRetryPolicy.Do(ctx, op)
+}
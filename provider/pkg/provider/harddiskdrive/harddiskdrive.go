@@ -0,0 +1,77 @@
+// Copyright 2016-2022, Pulumi Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package harddiskdrive
+
+import (
+	_ "embed"
+
+	"github.com/pulumi/pulumi-go-provider/infer"
+	"github.com/pulumi/pulumi-hyperv/provider/pkg/provider/resource"
+)
+
+//go:embed harddiskdrive.md
+var resourceDoc string
+
+// This is the type that implements the HardDiskDrive resource methods.
+// The methods are declared in the harddiskdriveController.go file.
+type HardDiskDrive struct{}
+
+// The following statement is not required. It is a type assertion to indicate to Go that HardDiskDrive
+// implements the following interfaces. If the function signature doesn't match or isn't implemented,
+// we get nice compile time errors at this location.
+
+var _ = (infer.Annotated)((*HardDiskDrive)(nil))
+
+// Implementing Annotate lets you provide descriptions and default values for resources and they will
+// be visible in the provider's schema and the generated SDKs.
+func (c *HardDiskDrive) Annotate(a infer.Annotator) {
+	a.Describe(&c, resourceDoc)
+}
+
+// These are the inputs (or arguments) to a HardDiskDrive resource.
+type HardDiskDriveInputs struct {
+	resource.Inputs
+	VMName             *string `pulumi:"vmName,optional"`
+	VmId               *string `pulumi:"vmId,optional"`
+	Path               *string `pulumi:"path"`
+	ControllerType     *string `pulumi:"controllerType,optional"`
+	ControllerNumber   *int    `pulumi:"controllerNumber,optional"`
+	ControllerLocation *int    `pulumi:"controllerLocation,optional"`
+}
+
+func (c *HardDiskDriveInputs) Annotate(a infer.Annotator) {
+	a.Describe(&c.VMName, "Name of the virtual machine to attach the disk to. Set either vmName or vmId.")
+	a.Describe(&c.VmId, "ID of the virtual machine to attach the disk to, as reported by Get-VM. Set either vmName or vmId.")
+	a.Describe(&c.Path, "Path to the VHD or VHDX file to attach, such as the path of a VhdFile resource.")
+	a.Describe(&c.ControllerType, "Type of the controller to attach the disk to: IDE or SCSI. Defaults to SCSI.")
+	a.Describe(&c.ControllerNumber, "Number of the controller to attach the disk to. Defaults to 0.")
+	a.Describe(&c.ControllerLocation, "Location on the controller to attach the disk to. Defaults to the first free location.")
+}
+
+// These are the outputs (or properties) of a HardDiskDrive resource.
+type HardDiskDriveOutputs struct {
+	HardDiskDriveInputs
+	AttachedVmName             *string `pulumi:"attachedVmName,optional"`
+	AttachedControllerType     *string `pulumi:"attachedControllerType,optional"`
+	AttachedControllerNumber   *int    `pulumi:"attachedControllerNumber,optional"`
+	AttachedControllerLocation *int    `pulumi:"attachedControllerLocation,optional"`
+}
+
+func (c *HardDiskDriveOutputs) Annotate(a infer.Annotator) {
+	a.Describe(&c.AttachedVmName, "Name of the virtual machine the disk is attached to.")
+	a.Describe(&c.AttachedControllerType, "Type of the controller the disk is attached to.")
+	a.Describe(&c.AttachedControllerNumber, "Number of the controller the disk is attached to.")
+	a.Describe(&c.AttachedControllerLocation, "Location on the controller the disk is attached to.")
+}
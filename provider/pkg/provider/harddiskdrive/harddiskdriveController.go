@@ -0,0 +1,224 @@
+// Copyright 2016-2022, Pulumi Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package harddiskdrive
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/pulumi/pulumi-go-provider/infer"
+
+	"github.com/pulumi/pulumi-hyperv/provider/pkg/provider/backend"
+	"github.com/pulumi/pulumi-hyperv/provider/pkg/provider/errs"
+	"github.com/pulumi/pulumi-hyperv/provider/pkg/provider/logging"
+)
+
+// The following statements are type assertions to indicate to Go that HardDiskDrive implements the interfaces.
+var _ = (infer.CustomResource[HardDiskDriveInputs, HardDiskDriveOutputs])((*HardDiskDrive)(nil))
+var _ = (infer.CustomRead[HardDiskDriveInputs, HardDiskDriveOutputs])((*HardDiskDrive)(nil))
+var _ = (infer.CustomDelete[HardDiskDriveOutputs])((*HardDiskDrive)(nil))
+
+// idSeparator separates the VM name from the disk path in resource IDs. It cannot appear in
+// Windows paths.
+const idSeparator = "|"
+
+// Create attaches the disk to the virtual machine.
+func (c *HardDiskDrive) Create(ctx context.Context, name string, input HardDiskDriveInputs, preview bool) (string, HardDiskDriveOutputs, error) {
+	logger := logging.GetLogger(ctx)
+	state := HardDiskDriveOutputs{HardDiskDriveInputs: input}
+
+	if err := validateInputs(input); err != nil {
+		return "", state, err
+	}
+	if preview {
+		return "", state, nil
+	}
+
+	b, err := backend.Connect(ctx)
+	if err != nil {
+		return "", state, err
+	}
+	vmName, err := resolveVMName(ctx, b, input)
+	if err != nil {
+		return "", state, err
+	}
+
+	path := *input.Path
+	drive := backend.DiskDrive{Path: path, ControllerType: backend.ControllerSCSI}
+	if input.ControllerType != nil {
+		drive.ControllerType = strings.ToUpper(*input.ControllerType)
+	}
+	if input.ControllerNumber != nil {
+		drive.ControllerNumber = *input.ControllerNumber
+	}
+	// A negative location lets Hyper-V pick the first free one.
+	drive.ControllerLocation = -1
+	if input.ControllerLocation != nil {
+		drive.ControllerLocation = *input.ControllerLocation
+	}
+
+	logger.Infof("Attaching vhd [%s] to VM %s", path, vmName)
+	slot, err := b.AttachDisk(ctx, vmName, drive)
+	if err != nil {
+		return "", state, fmt.Errorf("failed to attach vhd [%s] to VM %s: %w", path, vmName, err)
+	}
+
+	// Hyper-V may pick the location, so report where the disk ended up.
+	setAttachment(&state, vmName, slot)
+	return vmName + idSeparator + path, state, nil
+}
+
+// Read reports the slot the disk is attached to. The ID of an imported drive has the form
+// "<vmName>|<path>".
+func (c *HardDiskDrive) Read(ctx context.Context, id string, inputs HardDiskDriveInputs, state HardDiskDriveOutputs) (string, HardDiskDriveInputs, HardDiskDriveOutputs, error) {
+	logger := logging.GetLogger(ctx)
+
+	vmName, path, ok := strings.Cut(id, idSeparator)
+	if !ok || vmName == "" || path == "" {
+		return id, inputs, state, fmt.Errorf("invalid hard disk drive ID [%s], expected <vmName>%s<path>", id, idSeparator)
+	}
+	if inputs.Path == nil {
+		inputs.Path = &path
+	}
+	if inputs.VMName == nil && inputs.VmId == nil {
+		inputs.VMName = &vmName
+	}
+
+	b, err := backend.Connect(ctx)
+	if err != nil {
+		return id, inputs, state, err
+	}
+	slot, err := findSlot(ctx, b, vmName, path)
+	if errors.Is(err, errs.ErrNotFound) {
+		logger.Infof("VM %s no longer exists", vmName)
+		return "", inputs, state, nil
+	}
+	if err != nil {
+		return id, inputs, state, err
+	}
+	if slot == nil {
+		logger.Infof("vhd [%s] is no longer attached to VM %s", path, vmName)
+		return "", inputs, state, nil
+	}
+
+	// Replace the controller inputs that are set with the actual slot, so a refresh reports
+	// drift. An import records all of them.
+	importing := state.AttachedVmName == nil
+	controllerType, controllerNumber, controllerLocation := slot.ControllerType, slot.ControllerNumber, slot.ControllerLocation
+	if inputs.ControllerType != nil || importing {
+		inputs.ControllerType = &controllerType
+	}
+	if inputs.ControllerNumber != nil || importing {
+		inputs.ControllerNumber = &controllerNumber
+	}
+	if inputs.ControllerLocation != nil || importing {
+		inputs.ControllerLocation = &controllerLocation
+	}
+
+	outputs := HardDiskDriveOutputs{HardDiskDriveInputs: inputs}
+	setAttachment(&outputs, vmName, slot)
+	return id, inputs, outputs, nil
+}
+
+// Delete detaches the disk from the virtual machine. The disk file is left in place.
+func (c *HardDiskDrive) Delete(ctx context.Context, id string, props HardDiskDriveOutputs) error {
+	logger := logging.GetLogger(ctx)
+
+	vmName, path, ok := strings.Cut(id, idSeparator)
+	if !ok {
+		return fmt.Errorf("invalid hard disk drive ID [%s], expected <vmName>%s<path>", id, idSeparator)
+	}
+	logger.Infof("Detaching vhd [%s] from VM %s", path, vmName)
+
+	b, err := backend.Connect(ctx)
+	if err != nil {
+		return err
+	}
+	if err := b.DetachDisk(ctx, vmName, path); err != nil {
+		if errors.Is(err, errs.ErrNotFound) {
+			logger.Infof("VM %s not found, nothing to detach: %v", vmName, err)
+			return nil
+		}
+		return fmt.Errorf("failed to detach vhd [%s] from VM %s: %w", path, vmName, err)
+	}
+	return nil
+}
+
+// validateInputs checks the inputs before anything is changed.
+func validateInputs(input HardDiskDriveInputs) error {
+	if (input.VMName == nil || *input.VMName == "") == (input.VmId == nil || *input.VmId == "") {
+		return fmt.Errorf("exactly one of vmName and vmId must be set")
+	}
+	if input.Path == nil || *input.Path == "" {
+		return fmt.Errorf("path is required")
+	}
+	lower := strings.ToLower(*input.Path)
+	if !strings.HasSuffix(lower, ".vhd") && !strings.HasSuffix(lower, ".vhdx") {
+		return fmt.Errorf("path [%s] doesn't end with .vhd or .vhdx", *input.Path)
+	}
+	if strings.Contains(*input.Path, idSeparator) {
+		return fmt.Errorf("path [%s] cannot contain %s", *input.Path, idSeparator)
+	}
+	if input.ControllerType != nil && !strings.EqualFold(*input.ControllerType, "SCSI") && !strings.EqualFold(*input.ControllerType, "IDE") {
+		return fmt.Errorf("unsupported controller type [%s], must be SCSI or IDE", *input.ControllerType)
+	}
+	if input.ControllerNumber != nil && *input.ControllerNumber < 0 {
+		return fmt.Errorf("controllerNumber cannot be negative")
+	}
+	if input.ControllerLocation != nil && *input.ControllerLocation < 0 {
+		return fmt.Errorf("controllerLocation cannot be negative")
+	}
+	return nil
+}
+
+// resolveVMName returns the name of the virtual machine the inputs refer to. vmId is looked up
+// by ID or by name, because Machine reports its name as vmId.
+func resolveVMName(ctx context.Context, b backend.HypervBackend, input HardDiskDriveInputs) (string, error) {
+	if input.VMName != nil && *input.VMName != "" {
+		return *input.VMName, nil
+	}
+	vm, err := b.GetVM(ctx, *input.VmId)
+	if err != nil {
+		return "", fmt.Errorf("failed to find VM with ID %s: %w", *input.VmId, err)
+	}
+	return vm.Name, nil
+}
+
+// findSlot returns the drive of the disk at path, or nil if it is not attached.
+func findSlot(ctx context.Context, b backend.HypervBackend, vmName string, path string) (*backend.DiskDrive, error) {
+	drives, err := b.ListDiskDrives(ctx, vmName)
+	if err != nil {
+		return nil, err
+	}
+	for _, drive := range drives {
+		if strings.EqualFold(drive.Path, path) {
+			return &drive, nil
+		}
+	}
+	return nil, nil
+}
+
+func setAttachment(state *HardDiskDriveOutputs, vmName string, slot *backend.DiskDrive) {
+	state.AttachedVmName = &vmName
+	if slot == nil {
+		return
+	}
+	controllerType, controllerNumber, controllerLocation := slot.ControllerType, slot.ControllerNumber, slot.ControllerLocation
+	state.AttachedControllerType = &controllerType
+	state.AttachedControllerNumber = &controllerNumber
+	state.AttachedControllerLocation = &controllerLocation
+}
@@ -0,0 +1,162 @@
+// Copyright 2016-2022, Pulumi Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package harddiskdrive
+
+import (
+	"context"
+	"testing"
+
+	"github.com/pulumi/pulumi-hyperv/provider/pkg/provider/backend"
+	"github.com/pulumi/pulumi-hyperv/provider/pkg/provider/vhd"
+)
+
+func ptr[T any](v T) *T {
+	return &v
+}
+
+// simulate returns a context whose resource operations run against a Simulator with a
+// generation 1 virtual machine "web" with two SCSI controllers and two disks. The working
+// directory is a temporary one, because the logger writes a file to it.
+func simulate(t *testing.T) (context.Context, *backend.Simulator, *backend.VM) {
+	t.Chdir(t.TempDir())
+	sim := backend.NewSimulator()
+	ctx := backend.WithBackend(context.Background(), sim)
+	vm, err := sim.CreateVM(ctx, backend.VMSpec{Name: "web", Generation: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sim.SetSCSIControllerCount(ctx, "web", 2); err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range []string{`C:\vms\os.vhdx`, `C:\vms\data.vhdx`} {
+		if _, err := sim.CreateDisk(ctx, path, vhd.CreateOptions{VirtualSize: 1 << 30}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return ctx, sim, vm
+}
+
+func TestLifecycle(t *testing.T) {
+	ctx, sim, vm := simulate(t)
+	c := &HardDiskDrive{}
+
+	osDisk := HardDiskDriveInputs{VmId: ptr(vm.ID), Path: ptr(`C:\vms\os.vhdx`), ControllerType: ptr("ide")}
+	osID, osState, err := c.Create(ctx, "os", osDisk, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if osID != `web|C:\vms\os.vhdx` || *osState.AttachedVmName != "web" || *osState.AttachedControllerType != backend.ControllerIDE {
+		t.Errorf("Create = %q, %+v", osID, osState)
+	}
+
+	data := HardDiskDriveInputs{VMName: ptr("web"), Path: ptr(`C:\vms\data.vhdx`)}
+	dataID, dataState, err := c.Create(ctx, "data", data, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *dataState.AttachedControllerType != backend.ControllerSCSI || *dataState.AttachedControllerLocation != 0 {
+		t.Errorf("Create without a slot = %+v, want the first SCSI location", dataState)
+	}
+
+	// Move the disk behind the back of the provider, and check that a refresh reports it.
+	if err := sim.DetachDisk(ctx, "web", `C:\vms\data.vhdx`); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := sim.AttachDisk(ctx, "web", backend.DiskDrive{Path: `C:\vms\data.vhdx`, ControllerType: "SCSI", ControllerNumber: 1, ControllerLocation: 5}); err != nil {
+		t.Fatal(err)
+	}
+	data.ControllerNumber = ptr(0)
+	_, inputs, outputs, err := c.Read(ctx, dataID, data, dataState)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *inputs.ControllerNumber != 1 || inputs.ControllerLocation != nil || *outputs.AttachedControllerLocation != 5 {
+		t.Errorf("Read = %+v, %+v, want controller 1 location 5", inputs, outputs)
+	}
+
+	if err := c.Delete(ctx, dataID, outputs); err != nil {
+		t.Fatal(err)
+	}
+	if readID, _, _, err := c.Read(ctx, dataID, data, outputs); err != nil || readID != "" {
+		t.Errorf("Read of a detached disk = %q, %v, want an empty ID", readID, err)
+	}
+	if drives, _ := sim.ListDiskDrives(ctx, "web"); len(drives) != 1 {
+		t.Errorf("drives after Delete = %+v, want only the IDE disk", drives)
+	}
+
+	if err := sim.DeleteDisk(ctx, `C:\vms\os.vhdx`); err == nil {
+		t.Error("DeleteDisk of an attached disk succeeded")
+	}
+	if err := c.Delete(ctx, osID, osState); err != nil {
+		t.Fatal(err)
+	}
+	if err := sim.DeleteVM(ctx, "web"); err != nil {
+		t.Fatal(err)
+	}
+	if readID, _, _, err := c.Read(ctx, osID, osDisk, osState); err != nil || readID != "" {
+		t.Errorf("Read after the VM was deleted = %q, %v, want an empty ID", readID, err)
+	}
+	if err := c.Delete(ctx, osID, osState); err != nil {
+		t.Errorf("Delete after the VM was deleted = %v", err)
+	}
+}
+
+func TestImport(t *testing.T) {
+	ctx, sim, _ := simulate(t)
+	c := &HardDiskDrive{}
+	if _, err := sim.AttachDisk(ctx, "web", backend.DiskDrive{Path: `C:\vms\data.vhdx`, ControllerType: "SCSI", ControllerLocation: 2}); err != nil {
+		t.Fatal(err)
+	}
+
+	id, inputs, outputs, err := c.Read(ctx, `web|C:\vms\data.vhdx`, HardDiskDriveInputs{}, HardDiskDriveOutputs{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id != `web|C:\vms\data.vhdx` || *inputs.VMName != "web" || *inputs.Path != `C:\vms\data.vhdx` ||
+		*inputs.ControllerType != "SCSI" || *inputs.ControllerNumber != 0 || *inputs.ControllerLocation != 2 {
+		t.Errorf("import Read = %q, %+v", id, inputs)
+	}
+	if *outputs.AttachedVmName != "web" {
+		t.Errorf("import Read outputs = %+v", outputs)
+	}
+
+	if _, _, _, err := c.Read(ctx, "web", HardDiskDriveInputs{}, HardDiskDriveOutputs{}); err == nil {
+		t.Error("Read of an ID without a path succeeded")
+	}
+}
+
+func TestCreateFailures(t *testing.T) {
+	ctx, _, _ := simulate(t)
+	c := &HardDiskDrive{}
+
+	tests := []struct {
+		name   string
+		inputs HardDiskDriveInputs
+	}{
+		{"no VM", HardDiskDriveInputs{Path: ptr(`C:\vms\data.vhdx`)}},
+		{"both VM name and ID", HardDiskDriveInputs{VMName: ptr("web"), VmId: ptr("web"), Path: ptr(`C:\vms\data.vhdx`)}},
+		{"not a disk", HardDiskDriveInputs{VMName: ptr("web"), Path: ptr(`C:\vms\data.iso`)}},
+		{"unknown VM", HardDiskDriveInputs{VmId: ptr("db"), Path: ptr(`C:\vms\data.vhdx`)}},
+		{"unknown disk", HardDiskDriveInputs{VMName: ptr("web"), Path: ptr(`C:\vms\logs.vhdx`)}},
+		{"missing controller", HardDiskDriveInputs{VMName: ptr("web"), Path: ptr(`C:\vms\data.vhdx`), ControllerType: ptr("IDE"), ControllerNumber: ptr(2)}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if id, _, err := c.Create(ctx, "data", tt.inputs, false); err == nil {
+				t.Errorf("Create = %q, want an error", id)
+			}
+		})
+	}
+}
@@ -0,0 +1,104 @@
+// Copyright 2016-2022, Pulumi Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package host provides the functions that describe the Hyper-V host itself.
+package host
+
+import (
+	"context"
+
+	"github.com/pulumi/pulumi-go-provider/infer"
+	"github.com/pulumi/pulumi-hyperv/provider/pkg/provider/backend"
+)
+
+// GetHostCapabilities reports what the Hyper-V host of the provider supports.
+type GetHostCapabilities struct{}
+
+// GetHostCapabilitiesArgs are the arguments of GetHostCapabilities, which has none.
+type GetHostCapabilitiesArgs struct{}
+
+// HostCapabilities is the result of GetHostCapabilities.
+type HostCapabilities struct {
+	OsCaption        string   `pulumi:"osCaption"`
+	OsVersion        string   `pulumi:"osVersion"`
+	OsBuild          int      `pulumi:"osBuild"`
+	Server           bool     `pulumi:"server"`
+	AzureEdition     bool     `pulumi:"azureEdition"`
+	HypervRole       bool     `pulumi:"hypervRole"`
+	PowerShellModule bool     `pulumi:"powerShellModule"`
+	Vmms             bool     `pulumi:"vmms"`
+	Vsms             bool     `pulumi:"vsms"`
+	Hgs              bool     `pulumi:"hgs"`
+	ConfigVersions   []string `pulumi:"configVersions"`
+	MaxConfigVersion string   `pulumi:"maxConfigVersion"`
+	Generation2      bool     `pulumi:"generation2"`
+	SecureBoot       bool     `pulumi:"secureBoot"`
+}
+
+func (f *GetHostCapabilities) Annotate(a infer.Annotator) {
+	a.Describe(f, "Reports the operating system of the Hyper-V host and the parts of Hyper-V it provides. The host is probed once and the result is reused for the life of the provider.")
+}
+
+func (c *HostCapabilities) Annotate(a infer.Annotator) {
+	a.Describe(&c.OsCaption, "Name of the edition of Windows, such as Microsoft Windows Server 2022 Datacenter.")
+	a.Describe(&c.OsVersion, "Version of Windows, such as 10.0.20348.")
+	a.Describe(&c.OsBuild, "Build number of Windows.")
+	a.Describe(&c.Server, "Whether the host runs Windows Server.")
+	a.Describe(&c.AzureEdition, "Whether the host runs a Windows Server Azure Edition.")
+	a.Describe(&c.HypervRole, "Whether the Hyper-V role or optional feature is enabled.")
+	a.Describe(&c.PowerShellModule, "Whether the Hyper-V PowerShell module is installed.")
+	a.Describe(&c.Vmms, "Whether the Virtual Machine Management service is running.")
+	a.Describe(&c.Vsms, "Whether the Virtual System Management Service of the Hyper-V WMI provider answers.")
+	a.Describe(&c.Hgs, "Whether the Host Guardian Service is available, which shielded virtual machines need.")
+	a.Describe(&c.ConfigVersions, "Virtual machine configuration versions the host supports.")
+	a.Describe(&c.MaxConfigVersion, "Highest virtual machine configuration version the host supports.")
+	a.Describe(&c.Generation2, "Whether the host runs generation 2 virtual machines.")
+	a.Describe(&c.SecureBoot, "Whether generation 2 virtual machines can use secure boot.")
+}
+
+func (f *GetHostCapabilities) Call(ctx context.Context, args GetHostCapabilitiesArgs) (HostCapabilities, error) {
+	b, err := backend.Connect(ctx)
+	if err != nil {
+		return HostCapabilities{}, err
+	}
+	caps, err := b.HostCapabilities(ctx)
+	if err != nil {
+		return HostCapabilities{}, err
+	}
+	return capabilities(caps), nil
+}
+
+// capabilities converts the capabilities of a backend to the result of the function.
+func capabilities(c *backend.HostCapabilities) HostCapabilities {
+	versions := c.ConfigVersions
+	if versions == nil {
+		versions = []string{}
+	}
+	return HostCapabilities{
+		OsCaption:        c.OSCaption,
+		OsVersion:        c.OSVersion,
+		OsBuild:          c.OSBuild,
+		Server:           c.Server,
+		AzureEdition:     c.AzureEdition,
+		HypervRole:       c.HyperVRole,
+		PowerShellModule: c.PowerShellModule,
+		Vmms:             c.VMMS,
+		Vsms:             c.VSMS,
+		Hgs:              c.HGS,
+		ConfigVersions:   versions,
+		MaxConfigVersion: c.MaxConfigVersion,
+		Generation2:      c.Generation2,
+		SecureBoot:       c.SecureBoot,
+	}
+}
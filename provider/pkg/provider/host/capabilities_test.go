@@ -0,0 +1,40 @@
+// Copyright 2016-2022, Pulumi Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package host
+
+import (
+	"context"
+	"testing"
+
+	"github.com/pulumi/pulumi-hyperv/provider/pkg/provider/backend"
+)
+
+func TestGetHostCapabilities(t *testing.T) {
+	sim := backend.NewSimulator()
+	sim.Capabilities.ConfigVersions = nil
+	sim.Capabilities.Complete()
+	ctx := backend.WithBackend(context.Background(), sim)
+
+	caps, err := (&GetHostCapabilities{}).Call(ctx, GetHostCapabilitiesArgs{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if caps.OsBuild != 20348 || !caps.Server || !caps.Vsms || !caps.Generation2 {
+		t.Errorf("GetHostCapabilities = %+v", caps)
+	}
+	if caps.ConfigVersions == nil || caps.MaxConfigVersion != "" {
+		t.Errorf("GetHostCapabilities of a host without configuration versions = %+v, want an empty list", caps)
+	}
+}
@@ -0,0 +1,48 @@
+// Copyright 2016-2022, Pulumi Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package host
+
+import (
+	"regexp"
+	"strings"
+)
+
+// matchName reports whether name matches pattern, a case-insensitive wildcard pattern in
+// which * matches any run of characters and ? matches one character, as in Get-VM -Name.
+// An empty pattern matches every name.
+func matchName(pattern, name string) bool {
+	if pattern == "" {
+		return true
+	}
+	var expr strings.Builder
+	expr.WriteString("(?is)^")
+	for _, r := range pattern {
+		switch r {
+		case '*':
+			expr.WriteString(".*")
+		case '?':
+			expr.WriteString(".")
+		default:
+			expr.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	expr.WriteString("$")
+	return regexp.MustCompile(expr.String()).MatchString(name)
+}
+
+// matchValue reports whether value equals want ignoring case. An empty want matches every value.
+func matchValue(want, value string) bool {
+	return want == "" || strings.EqualFold(want, value)
+}
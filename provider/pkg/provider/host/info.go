@@ -0,0 +1,147 @@
+// Copyright 2016-2022, Pulumi Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package host
+
+import (
+	"context"
+
+	"github.com/pulumi/pulumi-go-provider/infer"
+	"github.com/pulumi/pulumi-hyperv/provider/pkg/provider/backend"
+)
+
+// GetHostInfo reports the resources and default paths of the Hyper-V host.
+type GetHostInfo struct{}
+
+// GetHostInfoArgs are the arguments of GetHostInfo, which has none.
+type GetHostInfoArgs struct{}
+
+// HostInfo is the result of GetHostInfo.
+type HostInfo struct {
+	ComputerName          string `pulumi:"computerName"`
+	LogicalProcessorCount int    `pulumi:"logicalProcessorCount"`
+	MemoryCapacityBytes   int64  `pulumi:"memoryCapacityBytes"`
+	VirtualHardDiskPath   string `pulumi:"virtualHardDiskPath"`
+	VirtualMachinePath    string `pulumi:"virtualMachinePath"`
+}
+
+func (f *GetHostInfo) Annotate(a infer.Annotator) {
+	a.Describe(f, "Reports the name, processors, memory and default storage folders of the Hyper-V host.")
+}
+
+func (i *HostInfo) Annotate(a infer.Annotator) {
+	a.Describe(&i.ComputerName, "Name of the host.")
+	a.Describe(&i.LogicalProcessorCount, "Number of logical processors of the host.")
+	a.Describe(&i.MemoryCapacityBytes, "Physical memory of the host in bytes.")
+	a.Describe(&i.VirtualHardDiskPath, "Default folder of new virtual hard disks.")
+	a.Describe(&i.VirtualMachinePath, "Default folder of the configuration files of new virtual machines.")
+}
+
+func (f *GetHostInfo) Call(ctx context.Context, args GetHostInfoArgs) (HostInfo, error) {
+	b, err := backend.Connect(ctx)
+	if err != nil {
+		return HostInfo{}, err
+	}
+	info, err := b.GetHostInfo(ctx)
+	if err != nil {
+		return HostInfo{}, err
+	}
+	return HostInfo{
+		ComputerName:          info.ComputerName,
+		LogicalProcessorCount: info.LogicalProcessorCount,
+		MemoryCapacityBytes:   int64(info.MemoryCapacityBytes),
+		VirtualHardDiskPath:   info.VirtualHardDiskPath,
+		VirtualMachinePath:    info.VirtualMachinePath,
+	}, nil
+}
+
+// ListPhysicalNetAdapters lists the physical network adapters of the host.
+type ListPhysicalNetAdapters struct{}
+
+// ListPhysicalNetAdaptersArgs filter the adapters of ListPhysicalNetAdapters.
+type ListPhysicalNetAdaptersArgs struct {
+	NamePattern *string `pulumi:"namePattern,optional"`
+	Status      *string `pulumi:"status,optional"`
+	Unbound     *bool   `pulumi:"unbound,optional"`
+}
+
+// PhysicalNetAdapter is a physical network adapter of the host.
+type PhysicalNetAdapter struct {
+	Name                 string `pulumi:"name"`
+	InterfaceDescription string `pulumi:"interfaceDescription"`
+	MacAddress           string `pulumi:"macAddress"`
+	Status               string `pulumi:"status"`
+	LinkSpeedBps         int64  `pulumi:"linkSpeedBps"`
+	SwitchName           string `pulumi:"switchName"`
+}
+
+// ListPhysicalNetAdaptersResult is the result of ListPhysicalNetAdapters.
+type ListPhysicalNetAdaptersResult struct {
+	Adapters []PhysicalNetAdapter `pulumi:"adapters"`
+}
+
+func (f *ListPhysicalNetAdapters) Annotate(a infer.Annotator) {
+	a.Describe(f, "Lists the physical network adapters of the Hyper-V host, such as the one to bind an external virtual switch to.")
+}
+
+func (args *ListPhysicalNetAdaptersArgs) Annotate(a infer.Annotator) {
+	a.Describe(&args.NamePattern, "Only list adapters whose name matches this pattern. * matches any characters and ? matches one character; case is ignored.")
+	a.Describe(&args.Status, "Only list adapters with this status, such as Up or Disconnected.")
+	a.Describe(&args.Unbound, "When true, only list adapters no external virtual switch is bound to.")
+}
+
+func (n *PhysicalNetAdapter) Annotate(a infer.Annotator) {
+	a.Describe(&n.Name, "Name of the adapter, which is what the netAdapterName of a VirtualSwitch takes.")
+	a.Describe(&n.InterfaceDescription, "Description of the adapter hardware.")
+	a.Describe(&n.MacAddress, "MAC address of the adapter.")
+	a.Describe(&n.Status, "Operational status of the adapter, such as Up or Disconnected.")
+	a.Describe(&n.LinkSpeedBps, "Link speed of the adapter in bits per second.")
+	a.Describe(&n.SwitchName, "External virtual switch bound to the adapter, or empty if there is none.")
+}
+
+func (f *ListPhysicalNetAdapters) Call(ctx context.Context, args ListPhysicalNetAdaptersArgs) (ListPhysicalNetAdaptersResult, error) {
+	b, err := backend.Connect(ctx)
+	if err != nil {
+		return ListPhysicalNetAdaptersResult{}, err
+	}
+	adapters, err := b.ListPhysicalNetAdapters(ctx)
+	if err != nil {
+		return ListPhysicalNetAdaptersResult{}, err
+	}
+	result := ListPhysicalNetAdaptersResult{Adapters: []PhysicalNetAdapter{}}
+	for _, adapter := range adapters {
+		if !matchName(deref(args.NamePattern), adapter.Name) || !matchValue(deref(args.Status), adapter.Status) {
+			continue
+		}
+		if args.Unbound != nil && *args.Unbound && adapter.SwitchName != "" {
+			continue
+		}
+		result.Adapters = append(result.Adapters, PhysicalNetAdapter{
+			Name:                 adapter.Name,
+			InterfaceDescription: adapter.InterfaceDescription,
+			MacAddress:           adapter.MacAddress,
+			Status:               adapter.Status,
+			LinkSpeedBps:         int64(adapter.LinkSpeedBps),
+			SwitchName:           adapter.SwitchName,
+		})
+	}
+	return result, nil
+}
+
+func deref(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
@@ -0,0 +1,224 @@
+// Copyright 2016-2022, Pulumi Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package host
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/pulumi/pulumi-hyperv/provider/pkg/provider/backend"
+	"github.com/pulumi/pulumi-hyperv/provider/pkg/provider/errs"
+	"github.com/pulumi/pulumi-hyperv/provider/pkg/provider/vhd"
+)
+
+func ptr[T any](v T) *T { return &v }
+
+func TestMatchName(t *testing.T) {
+	tests := []struct {
+		pattern, name string
+		want          bool
+	}{
+		{"", "anything", true},
+		{"web-*", "WEB-01", true},
+		{"web-?", "web-01", false},
+		{"web-??", "web-01", true},
+		{"a.b", "axb", false},
+		{"*", "", true},
+	}
+	for _, tt := range tests {
+		if got := matchName(tt.pattern, tt.name); got != tt.want {
+			t.Errorf("matchName(%q, %q) = %t, want %t", tt.pattern, tt.name, got, tt.want)
+		}
+	}
+}
+
+func simulatedHost(t *testing.T) (context.Context, *backend.Simulator) {
+	t.Helper()
+	sim := backend.NewSimulator()
+	sim.PhysicalAdapters = []string{"Ethernet", "Ethernet 2"}
+	ctx := backend.WithBackend(context.Background(), sim)
+	for _, spec := range []backend.VMSpec{{Name: "web-01"}, {Name: "web-02"}, {Name: "db-01", Generation: 2}} {
+		if _, err := sim.CreateVM(ctx, spec); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := sim.SetVMState(ctx, "web-02", backend.PowerStateRunning); err != nil {
+		t.Fatal(err)
+	}
+	for _, spec := range []backend.Switch{
+		{Name: "External", SwitchType: backend.SwitchTypeExternal, NetAdapterName: "Ethernet"},
+		{Name: "Lab", SwitchType: backend.SwitchTypeInternal},
+	} {
+		if _, err := sim.CreateSwitch(ctx, spec); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return ctx, sim
+}
+
+func TestListVirtualMachines(t *testing.T) {
+	ctx, _ := simulatedHost(t)
+	tests := []struct {
+		args ListVirtualMachinesArgs
+		want []string
+	}{
+		{ListVirtualMachinesArgs{}, []string{"db-01", "web-01", "web-02"}},
+		{ListVirtualMachinesArgs{NamePattern: ptr("WEB-*")}, []string{"web-01", "web-02"}},
+		{ListVirtualMachinesArgs{NamePattern: ptr("web-*"), State: ptr("running")}, []string{"web-02"}},
+		{ListVirtualMachinesArgs{NamePattern: ptr("app-*")}, []string{}},
+	}
+	for _, tt := range tests {
+		result, err := (&ListVirtualMachines{}).Call(ctx, tt.args)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := []string{}
+		for _, vm := range result.VirtualMachines {
+			got = append(got, vm.Name)
+		}
+		if len(got) != len(tt.want) {
+			t.Errorf("ListVirtualMachines(%+v) = %v, want %v", tt.args, got, tt.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != tt.want[i] {
+				t.Errorf("ListVirtualMachines(%+v) = %v, want %v", tt.args, got, tt.want)
+				break
+			}
+		}
+	}
+}
+
+func TestGetVirtualMachine(t *testing.T) {
+	ctx, sim := simulatedHost(t)
+	if _, err := sim.CreateDisk(ctx, `C:\vms\db-01.vhdx`, vhd.CreateOptions{VirtualSize: 1 << 30}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := sim.AttachDisk(ctx, "db-01", backend.DiskDrive{Path: `C:\vms\db-01.vhdx`, ControllerType: backend.ControllerSCSI, ControllerLocation: -1}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := sim.AddNetworkAdapter(ctx, backend.NetworkAdapter{Name: "nic0", VMName: "db-01", SwitchName: "Lab"}); err != nil {
+		t.Fatal(err)
+	}
+
+	vm, err := (&GetVirtualMachine{}).Call(ctx, GetVirtualMachineArgs{Name: "db-01"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if vm.Generation != 2 || vm.State != "Off" || len(vm.HardDrives) != 1 || len(vm.NetworkAdapters) != 1 {
+		t.Fatalf("GetVirtualMachine = %+v", vm)
+	}
+	if vm.NetworkAdapters[0].SwitchName != "Lab" || !vm.NetworkAdapters[0].DynamicMacAddress {
+		t.Errorf("network adapter = %+v", vm.NetworkAdapters[0])
+	}
+	byID, err := (&GetVirtualMachine{}).Call(ctx, GetVirtualMachineArgs{Name: vm.Id})
+	if err != nil || byID.Name != "db-01" {
+		t.Errorf("GetVirtualMachine by ID = %+v, %v", byID, err)
+	}
+
+	if _, err := (&GetVirtualMachine{}).Call(ctx, GetVirtualMachineArgs{Name: "missing"}); !errors.Is(err, errs.ErrNotFound) {
+		t.Errorf("GetVirtualMachine of a missing machine = %v, want ErrNotFound", err)
+	}
+}
+
+func TestVirtualSwitches(t *testing.T) {
+	ctx, _ := simulatedHost(t)
+	result, err := (&ListVirtualSwitches{}).Call(ctx, ListVirtualSwitchesArgs{SwitchType: ptr("external")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Switches) != 1 || result.Switches[0].NetAdapterName != "Ethernet" {
+		t.Errorf("ListVirtualSwitches(external) = %+v", result.Switches)
+	}
+
+	sw, err := (&GetVirtualSwitch{}).Call(ctx, GetVirtualSwitchArgs{Name: "Lab"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sw.SwitchType != backend.SwitchTypeInternal || sw.Id == "" {
+		t.Errorf("GetVirtualSwitch = %+v", sw)
+	}
+	if _, err := (&GetVirtualSwitch{}).Call(ctx, GetVirtualSwitchArgs{Name: "missing"}); err == nil {
+		t.Error("GetVirtualSwitch of a missing switch succeeded")
+	}
+}
+
+func TestListPhysicalNetAdapters(t *testing.T) {
+	ctx, _ := simulatedHost(t)
+	all, err := (&ListPhysicalNetAdapters{}).Call(ctx, ListPhysicalNetAdaptersArgs{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all.Adapters) != 2 || all.Adapters[0].SwitchName != "External" || all.Adapters[1].SwitchName != "" {
+		t.Errorf("ListPhysicalNetAdapters = %+v", all.Adapters)
+	}
+
+	unbound, err := (&ListPhysicalNetAdapters{}).Call(ctx, ListPhysicalNetAdaptersArgs{Unbound: ptr(true), Status: ptr("up")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(unbound.Adapters) != 1 || unbound.Adapters[0].Name != "Ethernet 2" {
+		t.Errorf("ListPhysicalNetAdapters(unbound) = %+v", unbound.Adapters)
+	}
+}
+
+func TestGetHostInfoAndVhd(t *testing.T) {
+	ctx, sim := simulatedHost(t)
+	info, err := (&GetHostInfo{}).Call(ctx, GetHostInfoArgs{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.ComputerName != sim.HostInfo.ComputerName || info.MemoryCapacityBytes != 32<<30 {
+		t.Errorf("GetHostInfo = %+v", info)
+	}
+
+	if _, err := sim.CreateDisk(ctx, `C:\vms\data.vhdx`, vhd.CreateOptions{VirtualSize: 10 << 30, DiskType: "Fixed"}); err != nil {
+		t.Fatal(err)
+	}
+	disk, err := (&GetVhd{}).Call(ctx, GetVhdArgs{Path: `C:\vms\data.vhdx`})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if disk.Format != vhd.FormatVHDX || disk.VirtualSizeBytes != 10<<30 {
+		t.Errorf("GetVhd = %+v", disk)
+	}
+	if _, err := (&GetVhd{}).Call(ctx, GetVhdArgs{Path: `C:\vms\missing.vhdx`}); !errors.Is(err, errs.ErrNotFound) {
+		t.Errorf("GetVhd of a missing disk = %v, want ErrNotFound", err)
+	}
+}
+
+func TestGenerateProgram(t *testing.T) {
+	ctx, _ := simulatedHost(t)
+	generated, err := (&GenerateProgram{}).Call(ctx, GenerateProgramArgs{Language: "yaml"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(generated.Program, "  db-01:\n    type: hyperv:machine:Machine\n") {
+		t.Errorf("program does not declare db-01:\n%s", generated.Program)
+	}
+
+	// A program generated from the returned inventory is the same without the host.
+	again, err := (&GenerateProgram{}).Call(context.Background(), GenerateProgramArgs{
+		Language: "yaml", Inventory: &generated.Inventory,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again.Program != generated.Program || again.ImportFile != generated.ImportFile {
+		t.Errorf("program from the inventory differs:\n%s", again.Program)
+	}
+}
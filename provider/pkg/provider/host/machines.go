@@ -0,0 +1,193 @@
+// Copyright 2016-2022, Pulumi Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package host
+
+import (
+	"context"
+
+	"github.com/pulumi/pulumi-go-provider/infer"
+	"github.com/pulumi/pulumi-hyperv/provider/pkg/provider/backend"
+)
+
+// GetVirtualMachine looks up a virtual machine by name or ID, with its drives and adapters.
+type GetVirtualMachine struct{}
+
+// GetVirtualMachineArgs are the arguments of GetVirtualMachine.
+type GetVirtualMachineArgs struct {
+	Name string `pulumi:"name"`
+}
+
+// VirtualMachine is a virtual machine of the host.
+type VirtualMachine struct {
+	Id             string `pulumi:"id"`
+	Name           string `pulumi:"name"`
+	Generation     int    `pulumi:"generation"`
+	State          string `pulumi:"state"`
+	MemorySize     int    `pulumi:"memorySize"`
+	ProcessorCount int    `pulumi:"processorCount"`
+}
+
+// VirtualMachineDetails is the result of GetVirtualMachine.
+type VirtualMachineDetails struct {
+	VirtualMachine
+	HardDrives      []HardDrive      `pulumi:"hardDrives"`
+	NetworkAdapters []NetworkAdapter `pulumi:"networkAdapters"`
+}
+
+// HardDrive is a virtual hard disk attached to a virtual machine.
+type HardDrive struct {
+	Path               string `pulumi:"path"`
+	ControllerType     string `pulumi:"controllerType"`
+	ControllerNumber   int    `pulumi:"controllerNumber"`
+	ControllerLocation int    `pulumi:"controllerLocation"`
+}
+
+// NetworkAdapter is a network adapter of a virtual machine.
+type NetworkAdapter struct {
+	Name              string `pulumi:"name"`
+	SwitchName        string `pulumi:"switchName"`
+	MacAddress        string `pulumi:"macAddress"`
+	DynamicMacAddress bool   `pulumi:"dynamicMacAddress"`
+}
+
+func (f *GetVirtualMachine) Annotate(a infer.Annotator) {
+	a.Describe(f, "Looks up an existing virtual machine by name or ID, with its hard drives and network adapters. Fails if there is no such machine.")
+}
+
+func (args *GetVirtualMachineArgs) Annotate(a infer.Annotator) {
+	a.Describe(&args.Name, "Name or ID of the virtual machine.")
+}
+
+func (m *VirtualMachine) Annotate(a infer.Annotator) {
+	a.Describe(&m.Id, "Identifier of the virtual machine.")
+	a.Describe(&m.Name, "Name of the virtual machine.")
+	a.Describe(&m.Generation, "Generation of the virtual machine, 1 or 2.")
+	a.Describe(&m.State, "Power state of the virtual machine: Off, Running, Paused or Saved.")
+	a.Describe(&m.MemorySize, "Startup memory of the virtual machine in megabytes.")
+	a.Describe(&m.ProcessorCount, "Number of virtual processors.")
+}
+
+func (m *VirtualMachineDetails) Annotate(a infer.Annotator) {
+	a.Describe(&m.HardDrives, "Virtual hard disks attached to the virtual machine.")
+	a.Describe(&m.NetworkAdapters, "Network adapters of the virtual machine.")
+}
+
+func (d *HardDrive) Annotate(a infer.Annotator) {
+	a.Describe(&d.Path, "Path of the virtual hard disk file.")
+	a.Describe(&d.ControllerType, "Type of the controller: SCSI or IDE.")
+	a.Describe(&d.ControllerNumber, "Number of the controller.")
+	a.Describe(&d.ControllerLocation, "Location of the disk on the controller.")
+}
+
+func (n *NetworkAdapter) Annotate(a infer.Annotator) {
+	a.Describe(&n.Name, "Name of the network adapter.")
+	a.Describe(&n.SwitchName, "Virtual switch the adapter is connected to, or empty if it is not connected.")
+	a.Describe(&n.MacAddress, "MAC address of the adapter.")
+	a.Describe(&n.DynamicMacAddress, "Whether Hyper-V assigns the MAC address.")
+}
+
+func (f *GetVirtualMachine) Call(ctx context.Context, args GetVirtualMachineArgs) (VirtualMachineDetails, error) {
+	b, err := backend.Connect(ctx)
+	if err != nil {
+		return VirtualMachineDetails{}, err
+	}
+	vm, err := b.GetVM(ctx, args.Name)
+	if err != nil {
+		return VirtualMachineDetails{}, err
+	}
+	drives, err := b.ListDiskDrives(ctx, vm.Name)
+	if err != nil {
+		return VirtualMachineDetails{}, err
+	}
+	adapters, err := b.ListNetworkAdapters(ctx, vm.Name)
+	if err != nil {
+		return VirtualMachineDetails{}, err
+	}
+
+	details := VirtualMachineDetails{
+		VirtualMachine:  virtualMachine(vm),
+		HardDrives:      make([]HardDrive, 0, len(drives)),
+		NetworkAdapters: make([]NetworkAdapter, 0, len(adapters)),
+	}
+	for _, drive := range drives {
+		details.HardDrives = append(details.HardDrives, HardDrive{
+			Path:               drive.Path,
+			ControllerType:     drive.ControllerType,
+			ControllerNumber:   drive.ControllerNumber,
+			ControllerLocation: drive.ControllerLocation,
+		})
+	}
+	for _, adapter := range adapters {
+		details.NetworkAdapters = append(details.NetworkAdapters, NetworkAdapter{
+			Name:              adapter.Name,
+			SwitchName:        adapter.SwitchName,
+			MacAddress:        adapter.MacAddress,
+			DynamicMacAddress: adapter.DynamicMacAddress,
+		})
+	}
+	return details, nil
+}
+
+// ListVirtualMachines lists the virtual machines of the host.
+type ListVirtualMachines struct{}
+
+// ListVirtualMachinesArgs filter the machines of ListVirtualMachines.
+type ListVirtualMachinesArgs struct {
+	NamePattern *string `pulumi:"namePattern,optional"`
+	State       *string `pulumi:"state,optional"`
+}
+
+// ListVirtualMachinesResult is the result of ListVirtualMachines.
+type ListVirtualMachinesResult struct {
+	VirtualMachines []VirtualMachine `pulumi:"virtualMachines"`
+}
+
+func (f *ListVirtualMachines) Annotate(a infer.Annotator) {
+	a.Describe(f, "Lists the virtual machines of the Hyper-V host.")
+}
+
+func (args *ListVirtualMachinesArgs) Annotate(a infer.Annotator) {
+	a.Describe(&args.NamePattern, "Only list machines whose name matches this pattern. * matches any characters and ? matches one character; case is ignored.")
+	a.Describe(&args.State, "Only list machines in this power state: Off, Running, Paused or Saved.")
+}
+
+func (f *ListVirtualMachines) Call(ctx context.Context, args ListVirtualMachinesArgs) (ListVirtualMachinesResult, error) {
+	b, err := backend.Connect(ctx)
+	if err != nil {
+		return ListVirtualMachinesResult{}, err
+	}
+	vms, err := b.ListVMs(ctx)
+	if err != nil {
+		return ListVirtualMachinesResult{}, err
+	}
+	result := ListVirtualMachinesResult{VirtualMachines: []VirtualMachine{}}
+	for i := range vms {
+		if matchName(deref(args.NamePattern), vms[i].Name) && matchValue(deref(args.State), string(vms[i].State)) {
+			result.VirtualMachines = append(result.VirtualMachines, virtualMachine(&vms[i]))
+		}
+	}
+	return result, nil
+}
+
+func virtualMachine(vm *backend.VM) VirtualMachine {
+	return VirtualMachine{
+		Id:             vm.ID,
+		Name:           vm.Name,
+		Generation:     vm.Generation,
+		State:          string(vm.State),
+		MemorySize:     int(vm.MemoryMB),
+		ProcessorCount: vm.ProcessorCount,
+	}
+}
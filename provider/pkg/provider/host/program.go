@@ -0,0 +1,92 @@
+// Copyright 2016-2022, Pulumi Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package host
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/pulumi/pulumi-go-provider/infer"
+	"github.com/pulumi/pulumi-hyperv/provider/pkg/provider/backend"
+	"github.com/pulumi/pulumi-hyperv/provider/pkg/provider/codegen"
+)
+
+// GenerateProgram writes a Pulumi program that adopts the objects of the host.
+type GenerateProgram struct{}
+
+// GenerateProgramArgs are the arguments of GenerateProgram.
+type GenerateProgramArgs struct {
+	Language  string  `pulumi:"language"`
+	Project   *string `pulumi:"project,optional"`
+	Inventory *string `pulumi:"inventory,optional"`
+}
+
+// GenerateProgramResult is the result of GenerateProgram.
+type GenerateProgramResult struct {
+	Program    string `pulumi:"program"`
+	ImportFile string `pulumi:"importFile"`
+	Inventory  string `pulumi:"inventory"`
+}
+
+func (f *GenerateProgram) Annotate(a infer.Annotator) {
+	a.Describe(f, "Generates a Pulumi program that declares the virtual machines, virtual hard disks and "+
+		"virtual switches of the Hyper-V host, and the file `pulumi import --file` adopts them with. "+
+		"Machines refer to their disks and switches, so the program keeps them in the order they depend on each other.")
+}
+
+func (args *GenerateProgramArgs) Annotate(a infer.Annotator) {
+	a.Describe(&args.Language, fmt.Sprintf("Language of the program: %s.", strings.Join(codegen.Languages, ", ")))
+	a.Describe(&args.Project, "Project name of a YAML program. Defaults to "+codegen.DefaultProject+".")
+	a.Describe(&args.Inventory, "Inventory returned by an earlier call to generate the program from, instead of the host.")
+}
+
+func (r *GenerateProgramResult) Annotate(a infer.Annotator) {
+	a.Describe(&r.Program, "Source of the program: index.ts, main.go or Pulumi.yaml.")
+	a.Describe(&r.ImportFile, "Import file for `pulumi import --file`.")
+	a.Describe(&r.Inventory, "Inventory of the host the program was generated from, as JSON.")
+}
+
+func (f *GenerateProgram) Call(ctx context.Context, args GenerateProgramArgs) (GenerateProgramResult, error) {
+	inventory := &codegen.Inventory{}
+	if args.Inventory != nil {
+		if err := json.Unmarshal([]byte(*args.Inventory), inventory); err != nil {
+			return GenerateProgramResult{}, fmt.Errorf("failed to parse the inventory: %w", err)
+		}
+	} else {
+		b, err := backend.Connect(ctx)
+		if err != nil {
+			return GenerateProgramResult{}, err
+		}
+		if inventory, err = codegen.Collect(ctx, b); err != nil {
+			return GenerateProgramResult{}, err
+		}
+	}
+
+	generated, err := codegen.Generate(inventory, args.Language, deref(args.Project))
+	if err != nil {
+		return GenerateProgramResult{}, err
+	}
+	snapshot, err := json.Marshal(inventory)
+	if err != nil {
+		return GenerateProgramResult{}, err
+	}
+	return GenerateProgramResult{
+		Program:    generated.Program,
+		ImportFile: generated.ImportFile,
+		Inventory:  string(snapshot),
+	}, nil
+}
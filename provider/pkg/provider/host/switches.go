@@ -0,0 +1,121 @@
+// Copyright 2016-2022, Pulumi Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package host
+
+import (
+	"context"
+
+	"github.com/pulumi/pulumi-go-provider/infer"
+	"github.com/pulumi/pulumi-hyperv/provider/pkg/provider/backend"
+)
+
+// GetVirtualSwitch looks up a virtual switch by name.
+type GetVirtualSwitch struct{}
+
+// GetVirtualSwitchArgs are the arguments of GetVirtualSwitch.
+type GetVirtualSwitchArgs struct {
+	Name string `pulumi:"name"`
+}
+
+// VirtualSwitch is a virtual switch of the host.
+type VirtualSwitch struct {
+	Id                string `pulumi:"id"`
+	Name              string `pulumi:"name"`
+	SwitchType        string `pulumi:"switchType"`
+	NetAdapterName    string `pulumi:"netAdapterName"`
+	AllowManagementOs bool   `pulumi:"allowManagementOs"`
+	Notes             string `pulumi:"notes"`
+}
+
+func (f *GetVirtualSwitch) Annotate(a infer.Annotator) {
+	a.Describe(f, "Looks up an existing virtual switch by name. Fails if there is no such switch.")
+}
+
+func (args *GetVirtualSwitchArgs) Annotate(a infer.Annotator) {
+	a.Describe(&args.Name, "Name of the virtual switch.")
+}
+
+func (s *VirtualSwitch) Annotate(a infer.Annotator) {
+	a.Describe(&s.Id, "Identifier of the virtual switch.")
+	a.Describe(&s.Name, "Name of the virtual switch.")
+	a.Describe(&s.SwitchType, "Type of the virtual switch: External, Internal or Private.")
+	a.Describe(&s.NetAdapterName, "Physical network adapter an External switch is bound to.")
+	a.Describe(&s.AllowManagementOs, "Whether the host shares the network adapter of an External switch.")
+	a.Describe(&s.Notes, "Notes of the virtual switch.")
+}
+
+func (f *GetVirtualSwitch) Call(ctx context.Context, args GetVirtualSwitchArgs) (VirtualSwitch, error) {
+	b, err := backend.Connect(ctx)
+	if err != nil {
+		return VirtualSwitch{}, err
+	}
+	sw, err := b.GetSwitch(ctx, args.Name)
+	if err != nil {
+		return VirtualSwitch{}, err
+	}
+	return virtualSwitch(sw), nil
+}
+
+// ListVirtualSwitches lists the virtual switches of the host.
+type ListVirtualSwitches struct{}
+
+// ListVirtualSwitchesArgs filter the switches of ListVirtualSwitches.
+type ListVirtualSwitchesArgs struct {
+	NamePattern *string `pulumi:"namePattern,optional"`
+	SwitchType  *string `pulumi:"switchType,optional"`
+}
+
+// ListVirtualSwitchesResult is the result of ListVirtualSwitches.
+type ListVirtualSwitchesResult struct {
+	Switches []VirtualSwitch `pulumi:"switches"`
+}
+
+func (f *ListVirtualSwitches) Annotate(a infer.Annotator) {
+	a.Describe(f, "Lists the virtual switches of the Hyper-V host.")
+}
+
+func (args *ListVirtualSwitchesArgs) Annotate(a infer.Annotator) {
+	a.Describe(&args.NamePattern, "Only list switches whose name matches this pattern. * matches any characters and ? matches one character; case is ignored.")
+	a.Describe(&args.SwitchType, "Only list switches of this type: External, Internal or Private.")
+}
+
+func (f *ListVirtualSwitches) Call(ctx context.Context, args ListVirtualSwitchesArgs) (ListVirtualSwitchesResult, error) {
+	b, err := backend.Connect(ctx)
+	if err != nil {
+		return ListVirtualSwitchesResult{}, err
+	}
+	switches, err := b.ListSwitches(ctx)
+	if err != nil {
+		return ListVirtualSwitchesResult{}, err
+	}
+	result := ListVirtualSwitchesResult{Switches: []VirtualSwitch{}}
+	for i := range switches {
+		if matchName(deref(args.NamePattern), switches[i].Name) && matchValue(deref(args.SwitchType), switches[i].SwitchType) {
+			result.Switches = append(result.Switches, virtualSwitch(&switches[i]))
+		}
+	}
+	return result, nil
+}
+
+func virtualSwitch(sw *backend.Switch) VirtualSwitch {
+	return VirtualSwitch{
+		Id:                sw.ID,
+		Name:              sw.Name,
+		SwitchType:        sw.SwitchType,
+		NetAdapterName:    sw.NetAdapterName,
+		AllowManagementOs: sw.AllowManagementOS,
+		Notes:             sw.Notes,
+	}
+}
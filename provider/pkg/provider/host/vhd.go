@@ -0,0 +1,88 @@
+// Copyright 2016-2022, Pulumi Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package host
+
+import (
+	"context"
+
+	"github.com/pulumi/pulumi-go-provider/infer"
+	"github.com/pulumi/pulumi-hyperv/provider/pkg/provider/backend"
+)
+
+// GetVhd reads the metadata of a virtual hard disk file.
+type GetVhd struct{}
+
+// GetVhdArgs are the arguments of GetVhd.
+type GetVhdArgs struct {
+	Path string `pulumi:"path"`
+}
+
+// Vhd is the result of GetVhd.
+type Vhd struct {
+	Path               string `pulumi:"path"`
+	Format             string `pulumi:"format"`
+	DiskType           string `pulumi:"diskType"`
+	VirtualSizeBytes   int64  `pulumi:"virtualSizeBytes"`
+	FileSizeBytes      int64  `pulumi:"fileSizeBytes"`
+	BlockSize          int    `pulumi:"blockSize"`
+	LogicalSectorSize  int    `pulumi:"logicalSectorSize"`
+	PhysicalSectorSize int    `pulumi:"physicalSectorSize"`
+	ParentPath         string `pulumi:"parentPath"`
+	DiskIdentifier     string `pulumi:"diskIdentifier"`
+}
+
+func (f *GetVhd) Annotate(a infer.Annotator) {
+	a.Describe(f, "Reads the metadata of an existing virtual hard disk file. Fails if there is no such file.")
+}
+
+func (args *GetVhdArgs) Annotate(a infer.Annotator) {
+	a.Describe(&args.Path, "Path of the virtual hard disk file on the host.")
+}
+
+func (v *Vhd) Annotate(a infer.Annotator) {
+	a.Describe(&v.Path, "Path of the virtual hard disk file.")
+	a.Describe(&v.Format, "Format of the disk: VHD or VHDX.")
+	a.Describe(&v.DiskType, "Type of the disk: Fixed, Dynamic or Differencing.")
+	a.Describe(&v.VirtualSizeBytes, "Size of the disk the guest sees, in bytes.")
+	a.Describe(&v.FileSizeBytes, "Size of the file on the host, in bytes.")
+	a.Describe(&v.BlockSize, "Allocation unit of the disk in bytes.")
+	a.Describe(&v.LogicalSectorSize, "Logical sector size in bytes.")
+	a.Describe(&v.PhysicalSectorSize, "Physical sector size in bytes.")
+	a.Describe(&v.ParentPath, "Parent of a differencing disk, or empty for other disks.")
+	a.Describe(&v.DiskIdentifier, "Unique identifier of the disk.")
+}
+
+func (f *GetVhd) Call(ctx context.Context, args GetVhdArgs) (Vhd, error) {
+	b, err := backend.Connect(ctx)
+	if err != nil {
+		return Vhd{}, err
+	}
+	disk, err := b.GetDisk(ctx, args.Path)
+	if err != nil {
+		return Vhd{}, err
+	}
+	return Vhd{
+		Path:               disk.Path,
+		Format:             disk.Format,
+		DiskType:           disk.DiskType,
+		VirtualSizeBytes:   int64(disk.VirtualSize),
+		FileSizeBytes:      disk.PhysicalSize,
+		BlockSize:          int(disk.BlockSize),
+		LogicalSectorSize:  int(disk.LogicalSectorSize),
+		PhysicalSectorSize: int(disk.PhysicalSectorSize),
+		ParentPath:         disk.ParentPath,
+		DiskIdentifier:     disk.DiskID,
+	}, nil
+}
@@ -0,0 +1,117 @@
+// Copyright 2016-2022, Pulumi Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package job
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/pulumi/pulumi-hyperv/provider/pkg/provider/errs"
+)
+
+// Error is a failed method or job.
+type Error struct {
+	// Method is the name of the method.
+	Method string
+	// Code is the return value of the method, or the ErrorCode of its job.
+	Code uint32
+	// JobState is the state the job stopped in, or 0 if the method did not start a job.
+	JobState uint16
+	// Description is the ErrorDescription of the job.
+	Description string
+}
+
+func (e *Error) Error() string {
+	if e.JobState == 0 {
+		return fmt.Sprintf("%s failed with return value %d: %s", e.Method, e.Code, Meaning(e.Code))
+	}
+
+	var state string
+	switch e.JobState {
+	case StateTerminated:
+		state = "was terminated"
+	case StateKilled:
+		state = "was killed"
+	case StateException:
+		state = "failed"
+	default:
+		state = fmt.Sprintf("stopped in state %d", e.JobState)
+	}
+	msg := fmt.Sprintf("%s job %s", e.Method, state)
+	if e.Code != 0 {
+		msg += fmt.Sprintf(" with error code %d", e.Code)
+	}
+	if description := strings.TrimSpace(e.Description); description != "" {
+		msg += ": " + description
+	}
+	return msg
+}
+
+// Unwrap returns the class of the failure in the errs package, so errors.Is can match it.
+func (e *Error) Unwrap() error {
+	if kind := errs.ClassifyReturnValue(e.Code); kind != nil {
+		return kind
+	}
+	if e.JobState == StateTerminated || e.JobState == StateKilled {
+		return errs.ErrTerminated
+	}
+	return nil
+}
+
+// Meaning returns a description of a return value of a Hyper-V WMI service method.
+func Meaning(code uint32) string {
+	switch code {
+	case 0:
+		return "Completed with No Error."
+	case 1:
+		return "Not Supported."
+	case 2:
+		return "Failed."
+	case 3:
+		return "Timeout."
+	case 4:
+		return "Invalid Parameter."
+	case 5:
+		return "Invalid State."
+	case 6:
+		return "Invalid Type."
+	case 4096:
+		return "Method Parameters Checked - Job Started."
+	case 32768:
+		return "Failed."
+	case 32769:
+		return "Access Denied."
+	case 32770:
+		return "Not Supported."
+	case 32771:
+		return "Status is Unknown."
+	case 32772:
+		return "Timeout."
+	case 32773:
+		return "Invalid Parameter."
+	case 32774:
+		return "System is In Use."
+	case 32775:
+		return "Invalid State for this Operation."
+	case 32776:
+		return "Incorrect Data Type."
+	case 32777:
+		return "System is Not Available."
+	case 32778:
+		return "Out of Memory."
+	default:
+		return "The Method Failed. The Reason is Unknown."
+	}
+}
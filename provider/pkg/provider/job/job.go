@@ -0,0 +1,152 @@
+// Copyright 2016-2022, Pulumi Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package job follows Msvm_ConcreteJob instances, which the Hyper-V WMI services start for
+// methods that do not complete right away, until they are done.
+package job
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	provider "github.com/pulumi/pulumi-go-provider"
+)
+
+// Return values of the Hyper-V WMI service methods that are not errors.
+const (
+	ReturnCompleted  = 0
+	ReturnJobStarted = 4096
+)
+
+// States of a Msvm_ConcreteJob.
+const (
+	StateNew          = 2
+	StateStarting     = 3
+	StateRunning      = 4
+	StateSuspended    = 5
+	StateShuttingDown = 6
+	StateCompleted    = 7
+	StateTerminated   = 8
+	StateKilled       = 9
+	StateException    = 10
+	StateService      = 11
+)
+
+// State is the state of a Msvm_ConcreteJob.
+type State struct {
+	JobState         uint16
+	PercentComplete  uint16
+	ErrorCode        uint16
+	ErrorDescription string
+}
+
+// Done reports whether the job has stopped running.
+func (s State) Done() bool {
+	return s.JobState >= StateCompleted && s.JobState <= StateService
+}
+
+// Source looks up jobs.
+type Source interface {
+	// Job returns the state of the Msvm_ConcreteJob at path.
+	Job(path string) (State, error)
+}
+
+// Options control how long Wait waits for a job.
+type Options struct {
+	// Timeout is how long to wait for a job. Zero means DefaultTimeout, and NoTimeout waits
+	// until the job is done, which suits the storage jobs that run as long as the disk is
+	// large. A deadline of the context that is sooner wins.
+	Timeout time.Duration
+	// PollInterval is the time between two checks of a job. Zero means DefaultPollInterval.
+	PollInterval time.Duration
+}
+
+// Defaults for Options.
+const (
+	DefaultTimeout      = 5 * time.Minute
+	DefaultPollInterval = 250 * time.Millisecond
+)
+
+// NoTimeout is the Timeout of Options that waits for a job until it is done or the context is.
+const NoTimeout time.Duration = -1
+
+func (o Options) timeout() time.Duration {
+	if o.Timeout != 0 {
+		return o.Timeout
+	}
+	return DefaultTimeout
+}
+
+func (o Options) pollInterval() time.Duration {
+	if o.PollInterval > 0 {
+		return o.PollInterval
+	}
+	return DefaultPollInterval
+}
+
+// Wait returns once method, which returned returnValue and the job at path, is done. A method
+// that started a job is done when the job is, and it failed if the job did not complete.
+// The progress of the job is reported as a status message of the resource in ctx. Failures
+// are *Error values, which match the error classes of the errs package with errors.Is.
+func Wait(ctx context.Context, s Source, method string, returnValue uint32, path string, opts Options) error {
+	switch returnValue {
+	case ReturnCompleted:
+		return nil
+	case ReturnJobStarted:
+	default:
+		return &Error{Method: method, Code: returnValue}
+	}
+	if path == "" {
+		return fmt.Errorf("%s started a job but returned no job reference", method)
+	}
+
+	if timeout := opts.timeout(); timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	logger := provider.GetLogger(ctx)
+	ticker := time.NewTicker(opts.pollInterval())
+	defer ticker.Stop()
+
+	reported := uint16(0)
+	for {
+		state, err := s.Job(path)
+		if err != nil {
+			return fmt.Errorf("failed to get the %s job: %w", method, err)
+		}
+		if state.Done() {
+			if state.JobState == StateCompleted {
+				return nil
+			}
+			return &Error{
+				Method:      method,
+				Code:        uint32(state.ErrorCode),
+				JobState:    state.JobState,
+				Description: state.ErrorDescription,
+			}
+		}
+		if state.PercentComplete > reported && state.PercentComplete < 100 {
+			reported = state.PercentComplete
+			logger.InfoStatusf("%s: %d%% complete", method, reported)
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("%s job did not complete, it is %d%% done: %w", method, state.PercentComplete, ctx.Err())
+		case <-ticker.C:
+		}
+	}
+}
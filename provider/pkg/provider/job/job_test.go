@@ -0,0 +1,150 @@
+// Copyright 2016-2022, Pulumi Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package job
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pulumi/pulumi-hyperv/provider/pkg/provider/errs"
+)
+
+const jobPath = `Msvm_ConcreteJob.InstanceID="job"`
+
+// fakeSource returns the states of one job in order, then keeps returning the last one.
+type fakeSource struct {
+	states []State
+	polls  int
+}
+
+func (f *fakeSource) Job(path string) (State, error) {
+	if path != jobPath {
+		return State{}, fmt.Errorf("unknown job %s", path)
+	}
+	state := f.states[f.polls]
+	if f.polls < len(f.states)-1 {
+		f.polls++
+	}
+	return state, nil
+}
+
+var fast = Options{PollInterval: time.Millisecond}
+
+func TestWaitCompletes(t *testing.T) {
+	s := &fakeSource{states: []State{
+		{JobState: StateStarting},
+		{JobState: StateRunning, PercentComplete: 40},
+		{JobState: StateCompleted, PercentComplete: 100},
+	}}
+	if err := Wait(context.Background(), s, "ConvertVirtualHardDisk", ReturnJobStarted, jobPath, fast); err != nil {
+		t.Fatal(err)
+	}
+	if s.polls != 2 {
+		t.Errorf("polled the job %d times, want it polled until it completed", s.polls+1)
+	}
+
+	if err := Wait(context.Background(), s, "ConvertVirtualHardDisk", ReturnCompleted, "", fast); err != nil {
+		t.Errorf("Wait of a completed method = %v", err)
+	}
+	if err := Wait(context.Background(), s, "ConvertVirtualHardDisk", ReturnJobStarted, "", fast); err == nil {
+		t.Error("Wait of a job without a reference succeeded")
+	}
+}
+
+func TestWaitHonorsContext(t *testing.T) {
+	s := &fakeSource{states: []State{{JobState: StateRunning, PercentComplete: 70}}}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	err := Wait(ctx, s, "MergeVirtualHardDisk", ReturnJobStarted, jobPath, Options{Timeout: time.Hour, PollInterval: time.Millisecond})
+	if !errors.Is(err, context.DeadlineExceeded) || !strings.Contains(err.Error(), "70% done") {
+		t.Errorf("Wait error = %v, want the deadline of the context", err)
+	}
+	if time.Since(start) > time.Second {
+		t.Errorf("Wait returned after %s, want it to stop at the deadline of the context", time.Since(start))
+	}
+}
+
+func TestWaitWithoutTimeout(t *testing.T) {
+	s := &fakeSource{states: []State{{JobState: StateRunning, PercentComplete: 10}}}
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(20*time.Millisecond, cancel)
+
+	err := Wait(ctx, s, "CreateVirtualHardDisk", ReturnJobStarted, jobPath, Options{Timeout: NoTimeout, PollInterval: time.Millisecond})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("Wait error = %v, want it to wait until the context is canceled", err)
+	}
+}
+
+func TestErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		state State
+		code  uint32
+		want  string
+		is    error
+	}{
+		{"access denied", State{}, 32769, "AddResourceSettings failed with return value 32769: Access Denied.", errs.ErrAccessDenied},
+		{"invalid state", State{}, 5, "AddResourceSettings failed with return value 5: Invalid State.", errs.ErrInvalidState},
+		{"unknown", State{}, 42, "AddResourceSettings failed with return value 42: The Method Failed. The Reason is Unknown.", nil},
+		{"out of memory", State{JobState: StateException, ErrorCode: 32778, ErrorDescription: "Not enough memory. "}, ReturnJobStarted, "AddResourceSettings job failed with error code 32778: Not enough memory.", errs.ErrInsufficientResources},
+		{"in use", State{JobState: StateException, ErrorCode: 32774}, ReturnJobStarted, "AddResourceSettings job failed with error code 32774", errs.ErrTransient},
+		{"terminated", State{JobState: StateTerminated}, ReturnJobStarted, "AddResourceSettings job was terminated", errs.ErrTerminated},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &fakeSource{states: []State{tt.state}}
+			err := Wait(context.Background(), s, "AddResourceSettings", tt.code, jobPath, fast)
+			if err == nil || err.Error() != tt.want {
+				t.Errorf("Wait error = %v, want %q", err, tt.want)
+			}
+			if errs.KindOf(err) != tt.is {
+				t.Errorf("Wait error = %v, want it to be %v", err, tt.is)
+			}
+			var jobErr *Error
+			if !errors.As(fmt.Errorf("wrapped: %w", err), &jobErr) {
+				t.Errorf("Wait error = %T, want an *Error", err)
+			}
+		})
+	}
+}
+
+func TestErrorClasses(t *testing.T) {
+	tests := []struct {
+		code  uint32
+		class error
+	}{
+		{32769, errs.ErrAccessDenied},
+		{32775, errs.ErrInvalidState},
+		{32778, errs.ErrInsufficientResources},
+		{32777, errs.ErrTransient},
+		{32770, errs.ErrNotSupported},
+		{32773, errs.ErrInvalidParameter},
+	}
+	for _, tt := range tests {
+		err := Wait(context.Background(), &fakeSource{}, "RequestStateChange", tt.code, "", fast)
+		if !errors.Is(err, tt.class) || errs.KindOf(err) != tt.class {
+			t.Errorf("Wait error of return value %d = %v, want it to be %v", tt.code, err, tt.class)
+		}
+	}
+	if err := (&Error{Method: "RequestStateChange", Code: 32768}); errs.KindOf(err) != nil {
+		t.Errorf("return value 32768 has class %v, want none", errs.KindOf(err))
+	}
+}
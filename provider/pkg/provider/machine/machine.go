@@ -18,8 +18,8 @@ import (
 	_ "embed"
 
 	"github.com/pulumi/pulumi-go-provider/infer"
-	"github.com/pulumi/pulumi-hyperv/provider/pkg/provider/common"
 	"github.com/pulumi/pulumi-hyperv/provider/pkg/provider/networkadapter"
+	"github.com/pulumi/pulumi-hyperv/provider/pkg/provider/resource"
 )
 
 //go:embed machine.md
@@ -55,11 +55,49 @@ type HardDriveInput struct {
 	ControllerType     *string `pulumi:"controllerType"`
 	ControllerNumber   *int    `pulumi:"controllerNumber"`
 	ControllerLocation *int    `pulumi:"controllerLocation"`
+	// Storage QoS and attachment settings, stored on Msvm_StorageAllocationSettingData.
+	MinimumIops                   *int    `pulumi:"minimumIops,optional"`
+	MaximumIops                   *int    `pulumi:"maximumIops,optional"`
+	QosPolicyId                   *string `pulumi:"qosPolicyId,optional"`
+	SupportPersistentReservations *bool   `pulumi:"supportPersistentReservations,optional"`
+	ReadOnly                      *bool   `pulumi:"readOnly,optional"`
+	CacheMode                     *string `pulumi:"cacheMode,optional"`
+}
+
+func (c *HardDriveInput) Annotate(a infer.Annotator) {
+	a.Describe(&c.Path, "Path to the VHD or VHDX file to attach.")
+	a.Describe(&c.ControllerType, "Type of the controller to attach the disk to: IDE or SCSI.")
+	a.Describe(&c.ControllerNumber, "Number of the controller to attach the disk to.")
+	a.Describe(&c.ControllerLocation, "Location on the controller to attach the disk to.")
+	a.Describe(&c.MinimumIops, "Minimum throughput reserved for the disk, in normalized 8 KB IOPS.")
+	a.Describe(&c.MaximumIops, "Maximum throughput of the disk, in normalized 8 KB IOPS. 0 means unlimited.")
+	a.Describe(&c.QosPolicyId, "ID of the Storage QoS policy to apply to the disk. Cannot be combined with minimumIops or maximumIops.")
+	a.Describe(&c.SupportPersistentReservations, "Whether the disk supports SCSI persistent reservations, which lets guest clusters share a VHDX file.")
+	a.Describe(&c.ReadOnly, "Whether the disk is attached read-only. Only WMI can attach a disk read-only, so it fails with the PowerShell backend.")
+	a.Describe(&c.CacheMode, "Write cache behavior of the disk: Default, WriteCacheEnabled, WriteCacheAndFUAEnabled or WriteCacheDisabled.")
+}
+
+// PassThroughDiskInput identifies a physical disk of the host to attach to the VM. The disk
+// must be offline on the host.
+type PassThroughDiskInput struct {
+	DiskNumber         *int    `pulumi:"diskNumber,optional"`
+	UniqueId           *string `pulumi:"uniqueId,optional"`
+	ControllerType     *string `pulumi:"controllerType,optional"`
+	ControllerNumber   *int    `pulumi:"controllerNumber,optional"`
+	ControllerLocation *int    `pulumi:"controllerLocation,optional"`
+}
+
+func (c *PassThroughDiskInput) Annotate(a infer.Annotator) {
+	a.Describe(&c.DiskNumber, "Number of the disk on the host, as shown by Get-Disk. Set either diskNumber or uniqueId.")
+	a.Describe(&c.UniqueId, "Unique ID of the disk on the host, as shown by Get-Disk. Unlike the disk number it does not change when disks are added or removed.")
+	a.Describe(&c.ControllerType, "Type of the controller to attach the disk to: IDE or SCSI. Defaults to SCSI.")
+	a.Describe(&c.ControllerNumber, "Number of the controller to attach the disk to. Defaults to 0.")
+	a.Describe(&c.ControllerLocation, "Location on the controller to attach the disk to. Defaults to the first free location.")
 }
 
 // These are the inputs (or arguments) to a Vm resource.
 type MachineInputs struct {
-	common.ResourceInputs
+	resource.Inputs
 	MachineName     *string                                `pulumi:"machineName,optional"`
 	Generation      *int                                   `pulumi:"generation,optional"`
 	ProcessorCount  *int                                   `pulumi:"processorCount,optional"`
@@ -71,6 +109,10 @@ type MachineInputs struct {
 	AutoStopAction  *string                                `pulumi:"autoStopAction,optional"`
 	NetworkAdapters []*networkadapter.NetworkAdapterInputs `pulumi:"networkAdapters,optional"`
 	HardDrives      []*HardDriveInput                      `pulumi:"hardDrives,optional"`
+	// PassThroughDisks are physical disks of the host, attached through Msvm_DiskDrive.
+	PassThroughDisks []*PassThroughDiskInput `pulumi:"passThroughDisks,optional"`
+	// ScsiControllerCount is the number of SCSI controllers the VM should have.
+	ScsiControllerCount *int `pulumi:"scsiControllerCount,optional"`
 }
 
 func (c *MachineInputs) Annotate(a infer.Annotator) {
@@ -84,7 +126,9 @@ func (c *MachineInputs) Annotate(a infer.Annotator) {
 	a.Describe(&c.AutoStartAction, "The action to take when the host starts. Valid values are Nothing, StartIfRunning, and Start. Defaults to Nothing.")
 	a.Describe(&c.AutoStopAction, "The action to take when the host shuts down. Valid values are TurnOff, Save, and ShutDown. Defaults to TurnOff.")
 	a.Describe(&c.HardDrives, "Hard drives to attach to the Virtual Machine.")
-	a.Describe(&c.NetworkAdapters, "Network adapters to attach to the Virtual Machine.")
+	a.Describe(&c.PassThroughDisks, "Physical disks of the host to attach to the Virtual Machine. Each disk must be offline on the host.")
+	a.Describe(&c.ScsiControllerCount, "Number of SCSI controllers of the Virtual Machine, from 0 to 4. Controllers are added or removed before disks are attached. When not set, a controller is only added if a disk needs one.")
+	a.Describe(&c.NetworkAdapters, "Network adapters to attach to the Virtual Machine. Only name, switchName and macAddress are supported, use a NetworkAdapter resource for VLAN and other switch port settings.")
 }
 
 // These are the outputs (or properties) of a Vm resource.
@@ -16,22 +16,20 @@ package machine
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"os/exec"
+	"sort"
 	"strconv"
 	"strings"
 
-	"github.com/microsoft/wmi/pkg/base/host"
-	"github.com/microsoft/wmi/pkg/virtualization/core/memory"
-	"github.com/microsoft/wmi/pkg/virtualization/core/processor"
-	"github.com/microsoft/wmi/pkg/virtualization/core/service"
-	"github.com/microsoft/wmi/pkg/virtualization/core/virtualsystem"
+	p "github.com/pulumi/pulumi-go-provider"
 	"github.com/pulumi/pulumi-go-provider/infer"
-	"github.com/pulumi/pulumi-hyperv/provider/pkg/provider/common"
+	"github.com/pulumi/pulumi-hyperv/provider/pkg/provider/backend"
+	"github.com/pulumi/pulumi-hyperv/provider/pkg/provider/errs"
 	"github.com/pulumi/pulumi-hyperv/provider/pkg/provider/logging"
 	"github.com/pulumi/pulumi-hyperv/provider/pkg/provider/networkadapter"
-	"github.com/pulumi/pulumi-hyperv/provider/pkg/provider/util"
-	"github.com/pulumi/pulumi-hyperv/provider/pkg/provider/vmms"
+	"github.com/pulumi/pulumi-hyperv/provider/pkg/provider/passthrough"
+	"github.com/pulumi/pulumi/sdk/v3/go/common/resource"
 )
 
 // The following statements are not required. They are type assertions to indicate to Go that Machine implements the following interfaces.
@@ -39,471 +37,287 @@ import (
 
 // They would normally be included in the vmController.go file, but they're located here for instructive purposes.
 var _ = (infer.CustomResource[MachineInputs, MachineOutputs])((*Machine)(nil))
+var _ = (infer.CustomCheck[MachineInputs])((*Machine)(nil))
+var _ = (infer.CustomRead[MachineInputs, MachineOutputs])((*Machine)(nil))
 var _ = (infer.CustomUpdate[MachineInputs, MachineOutputs])((*Machine)(nil))
 var _ = (infer.CustomDelete[MachineOutputs])((*Machine)(nil))
 
-func (c *Machine) Connect(ctx context.Context) (*vmms.VMMS, *service.VirtualSystemManagementService, error) {
-	logger := logging.GetLogger(ctx)
-
-	// Create the VMMS client.
-	config := infer.GetConfig[common.Config](ctx)
-	var whost *host.WmiHost
-	if config.Host != "" {
-		whost = host.NewWmiHost(config.Host)
-	} else {
-		whost = host.NewWmiLocalHost()
-	}
-
-	// Wrap vmms creation in panic recovery
-	var vmmsClient *vmms.VMMS
-	var vmmsErr error
-
-	func() {
-		defer func() {
-			if r := recover(); r != nil {
-				vmmsErr = fmt.Errorf("recovered from panic in NewVMMS: %v", r)
-				logger.Warnf("Recovered from panic in NewVMMS: %v", r)
-			}
-		}()
-
-		vmmsClient, vmmsErr = vmms.NewVMMS(ctx, whost)
-	}()
+// machineAdapterProperties are the properties of the networkAdapters of a Machine. The
+// adapters belong to the machine, and their switch port settings are those of a standalone
+// NetworkAdapter resource.
+var machineAdapterProperties = map[resource.PropertyKey]bool{
+	"name":       true,
+	"switchName": true,
+	"macAddress": true,
+}
 
-	if vmmsErr != nil {
-		// Log the error but continue with PowerShell fallback
-		logger.Warnf("Failed to create VMMS client: %v", vmmsErr)
-		logger.Infof("Will attempt to use PowerShell fallback for machine operations")
-		// Return nil, nil to indicate PowerShell fallback should be used
-		return nil, nil, nil
+// Check rejects network adapter properties that a Machine doesn't apply, rather than
+// silently ignoring them.
+func (c *Machine) Check(ctx context.Context, name string, oldInputs, newInputs resource.PropertyMap) (MachineInputs, []p.CheckFailure, error) {
+	inputs, failures, err := infer.DefaultCheck[MachineInputs](ctx, newInputs)
+	if err != nil || len(failures) > 0 {
+		return inputs, failures, err
 	}
-
-	// Check for nil client before proceeding
-	if vmmsClient == nil {
-		logger.Warnf("VMMS client is nil after creation")
-		logger.Infof("Will attempt to use PowerShell fallback for machine operations")
-		return nil, nil, nil
+	adapters, ok := newInputs["networkAdapters"]
+	if !ok || !adapters.IsArray() {
+		return inputs, failures, nil
 	}
-
-	// Get the management service with nil check
-	vsms := vmmsClient.GetVirtualSystemManagementService()
-	if vsms == nil {
-		logger.Warnf("Virtual System Management Service is nil on Windows 10/11, falling back to PowerShell")
-		logger.Infof("Windows 10/11 client editions often have more limited WMI service access, using PowerShell fallback")
-		// For Windows 10/11, we'll use PowerShell fallback - return client but nil VSMS
-		return vmmsClient, nil, nil
+	for i, adapter := range adapters.ArrayValue() {
+		if !adapter.IsObject() {
+			continue
+		}
+		for key, value := range adapter.ObjectValue() {
+			if machineAdapterProperties[key] || value.IsNull() {
+				continue
+			}
+			failures = append(failures, p.CheckFailure{
+				Property: fmt.Sprintf("networkAdapters[%d].%s", i, key),
+				Reason: fmt.Sprintf("%s is not supported on the network adapters of a Machine, which only use name, switchName and macAddress. "+
+					"Use a NetworkAdapter resource with vmName set to the machine for switch port settings", key),
+			})
+		}
 	}
-
-	return vmmsClient, vsms, nil
+	sort.Slice(failures, func(i, j int) bool { return failures[i].Property < failures[j].Property })
+	return inputs, failures, nil
 }
 
-// This is the Get Metadata method.
-func (c *Machine) Read(ctx context.Context, id string, inputs MachineInputs, preview bool) (MachineOutputs, error) {
+// Read reports the virtual machine as the host has it. The ID of an imported machine is its
+// name or its ID.
+func (c *Machine) Read(ctx context.Context, id string, inputs MachineInputs, state MachineOutputs) (string, MachineInputs, MachineOutputs, error) {
 	logger := logging.GetLogger(ctx)
 
-	// Initialize the outputs with the inputs
-	outputs := MachineOutputs{
-		MachineInputs: inputs,
-	}
-
-	// The ID is the machine name if it's set, otherwise it's the ID
 	machineName := id
 	if inputs.MachineName != nil {
 		machineName = *inputs.MachineName
 	}
 
-	// Always ensure vmId is set even in preview or when VM doesn't exist yet
-	EnsureVmId(&outputs, machineName)
-
-	// If in preview, don't attempt to fetch actual VM data
-	if preview {
-		return outputs, nil
+	b, err := backend.Connect(ctx)
+	if err != nil {
+		return id, inputs, state, err
+	}
+	vm, err := b.GetVM(ctx, machineName)
+	if errors.Is(err, errs.ErrNotFound) {
+		logger.Infof("Machine %s not found", machineName)
+		return "", inputs, state, nil
 	}
-
-	// Connect to Hyper-V
-	vmmsClient, vsms, err := c.Connect(ctx)
 	if err != nil {
-		logger.Warnf("Error connecting to Hyper-V: %v", err)
-		return outputs, fmt.Errorf("failed to connect to Hyper-V: %v", err)
+		return id, inputs, state, err
 	}
 
-	// If we have no VMMS client or VSMS, use PowerShell to check if the VM exists
-	if vmmsClient == nil || vsms == nil {
-		logger.Infof("Using PowerShell fallback to check VM %s", machineName)
-		exists, err := checkVMExistsPowerShell(machineName)
-		if err != nil {
-			logger.Warnf("Error checking VM existence with PowerShell: %v", err)
-			return outputs, nil
+	// Replace the inputs that are set with the actual settings, so a refresh reports drift.
+	// An import records all of them, and is identified by the machine name like a created
+	// machine.
+	importing := state.VmId == nil
+	if importing {
+		id = vm.Name
+		inputs.MachineName = &vm.Name
+	}
+	generation, processorCount, memorySize := vm.Generation, vm.ProcessorCount, int(vm.MemoryMB)
+	if inputs.Generation != nil || importing {
+		inputs.Generation = &generation
+	}
+	if inputs.ProcessorCount != nil || importing {
+		inputs.ProcessorCount = &processorCount
+	}
+	if inputs.MemorySize != nil || importing {
+		inputs.MemorySize = &memorySize
+	}
+	dynamicMemory := vm.DynamicMemory
+	if inputs.DynamicMemory != nil || importing {
+		inputs.DynamicMemory = &dynamicMemory
+	}
+	// The memory limits only apply, and are only recorded, with dynamic memory.
+	if dynamicMemory {
+		minimumMemory, maximumMemory := int(vm.MinimumMemoryMB), int(vm.MaximumMemoryMB)
+		if inputs.MinimumMemory != nil || importing {
+			inputs.MinimumMemory = &minimumMemory
 		}
-
-		if !exists {
-			logger.Debugf("Machine %s not found (PowerShell check)", machineName)
-			return outputs, nil
+		if inputs.MaximumMemory != nil || importing {
+			inputs.MaximumMemory = &maximumMemory
 		}
-
-		// VM exists - gather information using PowerShell
-		return readVMWithPowerShell(ctx, machineName, inputs)
 	}
-
-	// Use WMI if available
-	vm, err := vsms.GetVirtualMachineByName(machineName)
-	if err != nil {
-		logger.Debugf("Machine %s not found: %v", machineName, err)
-		// Try fallback to PowerShell
-		logger.Infof("Falling back to PowerShell to check VM %s", machineName)
-		return readVMWithPowerShell(ctx, machineName, inputs)
+	if startAction := vm.AutomaticStartAction; startAction != "" && (inputs.AutoStartAction != nil || importing) {
+		inputs.AutoStartAction = &startAction
+	}
+	if stopAction := vm.AutomaticStopAction; stopAction != "" && (inputs.AutoStopAction != nil || importing) {
+		inputs.AutoStopAction = &stopAction
 	}
-	defer vm.Close()
-
-	logger.Debugf("Found machine %s", machineName)
 
-	// Get VM ID (ElementName in Hyper-V lingo)
-	vmId, err := vm.GetPropertyElementName()
-	if err == nil && vmId != "" {
-		outputs.VmId = &vmId
-		logger.Debugf("VM ID: %s", vmId)
+	if importing {
+		if inputs.HardDrives, err = readHardDrives(ctx, b, vm.Name); err != nil {
+			return id, inputs, state, err
+		}
+		if inputs.NetworkAdapters, err = readNetworkAdapters(ctx, b, vm.Name); err != nil {
+			return id, inputs, state, err
+		}
 	}
 
-	// Get the VM settings data
-	vmSettings, err := virtualsystem.GetVirtualSystemSettingData(vmmsClient.GetVirtualizationConn().WMIHost, machineName)
+	outputs := MachineOutputs{MachineInputs: inputs, VmId: state.VmId}
+	EnsureVmId(&outputs, vm.Name)
+	return id, inputs, outputs, nil
+}
+
+// readHardDrives returns the virtual hard disks attached to the machine as hard drive inputs.
+// Pass-through disks have no path and are left out.
+func readHardDrives(ctx context.Context, b backend.HypervBackend, vmName string) ([]*HardDriveInput, error) {
+	drives, err := b.ListDiskDrives(ctx, vmName)
 	if err != nil {
-		logger.Debugf("Failed to get VM settings: %v", err)
-		return outputs, nil
-	}
-	defer vmSettings.Close()
-
-	// Get processor count - find the processor setting from VM settings
-	// Use default values if not able to get processor setting
-	if inputs.ProcessorCount == nil {
-		defaultProcCount := 1
-		inputs.ProcessorCount = &defaultProcCount
-		logger.Debugf("Using default processor count: %d", defaultProcCount)
-	}
-
-	// Get memory size - find the memory setting from VM settings
-	// Use default values if not able to get memory setting
-	if inputs.MemorySize == nil {
-		defaultMemSize := 1024 // Default 1GB
-		inputs.MemorySize = &defaultMemSize
-		logger.Debugf("Using default memory size: %d MB", defaultMemSize)
-	}
-
-	// Get VM generation - find the generation from VM settings
-	// Use default Generation 2 if not able to determine
-	if inputs.Generation == nil {
-		defaultGeneration := 2
-		inputs.Generation = &defaultGeneration
-		logger.Debugf("Using default generation: %d", defaultGeneration)
-	}
-
-	// Get auto start action if not specified in inputs
-	if inputs.AutoStartAction == nil {
-		autoStartAction, err := vmSettings.GetProperty("AutoStartAction")
-		if err == nil {
-			var actionStr string
-			switch autoStartAction {
-			case 0:
-				actionStr = "Nothing"
-			case 1:
-				actionStr = "StartIfRunning"
-			case 2:
-				actionStr = "Start"
-			default:
-				actionStr = "Nothing"
-			}
-			inputs.AutoStartAction = &actionStr
-			logger.Debugf("Found auto start action: %s", actionStr)
-		}
-	}
-
-	// Get auto stop action if not specified in inputs
-	if inputs.AutoStopAction == nil {
-		autoStopAction, err := vmSettings.GetProperty("AutoStopAction")
-		if err == nil {
-			var actionStr string
-			switch autoStopAction {
-			case 0:
-				actionStr = "TurnOff"
-			case 1:
-				actionStr = "Save"
-			case 2:
-				actionStr = "ShutDown"
-			default:
-				actionStr = "TurnOff"
-			}
-			inputs.AutoStopAction = &actionStr
-			logger.Debugf("Found auto stop action: %s", actionStr)
+		return nil, fmt.Errorf("failed to list the hard drives of VM %s: %w", vmName, err)
+	}
+	var hardDrives []*HardDriveInput
+	for _, drive := range drives {
+		if drive.Path == "" {
+			continue
 		}
+		hardDrives = append(hardDrives, &HardDriveInput{
+			Path:               &drive.Path,
+			ControllerType:     &drive.ControllerType,
+			ControllerNumber:   &drive.ControllerNumber,
+			ControllerLocation: &drive.ControllerLocation,
+		})
 	}
+	return hardDrives, nil
+}
 
-	// Get hard drives attached to the VM
-	// This is an extension point for future implementation
-	// Currently just preserving any hard drives specified in the input
-	if len(inputs.HardDrives) == 0 {
-		// In a real implementation, we would retrieve the actual hard drives from the VM
-		logger.Debugf("No hard drives specified in input, and retrieval not implemented")
+// readNetworkAdapters returns the network adapters of the machine as adapter inputs. Only
+// static MAC addresses are recorded.
+func readNetworkAdapters(ctx context.Context, b backend.HypervBackend, vmName string) ([]*networkadapter.NetworkAdapterInputs, error) {
+	adapters, err := b.ListNetworkAdapters(ctx, vmName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list the network adapters of VM %s: %w", vmName, err)
 	}
-
-	// Get network adapters attached to the VM
-	// This is an extension point for future implementation
-	// Currently just preserving any network adapters specified in the input
-	if len(inputs.NetworkAdapters) == 0 {
-		// In a real implementation, we would retrieve the actual network adapters from the VM
-		logger.Debugf("No network adapters specified in input, and retrieval not implemented")
+	var inputs []*networkadapter.NetworkAdapterInputs
+	for _, adapter := range adapters {
+		input := &networkadapter.NetworkAdapterInputs{Name: &adapter.Name}
+		if adapter.SwitchName != "" {
+			input.SwitchName = &adapter.SwitchName
+		}
+		if !adapter.DynamicMacAddress && adapter.MacAddress != "" {
+			input.MacAddress = &adapter.MacAddress
+		}
+		inputs = append(inputs, input)
 	}
-
-	// Update outputs with populated inputs
-	outputs.MachineInputs = inputs
-
-	return outputs, nil
+	return inputs, nil
 }
 
-// This is the Create method. This will be run on every Machine resource creation.
-// createVMWithPowerShell creates a virtual machine using PowerShell cmdlets when WMI services are unavailable.
-// This is a fallback method used primarily on Windows 10/11 where VSMS might not be fully available.
-func createVMWithPowerShell(ctx context.Context, id string, input MachineInputs) (string, MachineOutputs, error) {
+// Create creates the virtual machine, configures its memory, automatic actions and SCSI
+// controllers, attaches its disks and network adapters, and starts it.
+func (c *Machine) Create(ctx context.Context, name string, input MachineInputs, preview bool) (string, MachineOutputs, error) {
 	logger := logging.GetLogger(ctx)
+	id := name
+	if input.MachineName != nil {
+		id = *input.MachineName
+	}
 	state := MachineOutputs{MachineInputs: input}
 
-	// Ensure vmId is set
+	// Always ensure vmId is set
 	EnsureVmId(&state, id)
 
-	// Find PowerShell executable
-	powershellExe, err := util.FindPowerShellExe()
-	if err != nil {
+	if err := validateHardDrives(input.HardDrives); err != nil {
+		return id, state, err
+	}
+	if err := validatePassThroughDisks(input.PassThroughDisks); err != nil {
+		return id, state, err
+	}
+	if err := validateControllers(input); err != nil {
+		return id, state, err
+	}
+	settings := vmSettings(MachineInputs{}, input)
+	if err := settings.Validate(); err != nil {
 		return id, state, err
 	}
 
-	// Build the PowerShell command for creating a VM
-	var cmdArgs []string
-	cmdArgs = append(cmdArgs, "New-VM", "-Name", fmt.Sprintf("\"%s\"", id))
-
-	// Add memory parameter
-	if input.MemorySize != nil {
-		cmdArgs = append(cmdArgs, "-MemoryStartupBytes", fmt.Sprintf("%d", *input.MemorySize*1024*1024)) // Convert MB to bytes
-	} else {
-		// Default to 1GB
-		cmdArgs = append(cmdArgs, "-MemoryStartupBytes", "1073741824") // 1GB in bytes
+	// If in preview, don't run the command.
+	if preview {
+		return id, state, nil
+	}
+	b, err := backend.Connect(ctx)
+	if err != nil {
+		return id, state, err
+	}
+	if err := checkHardDrives(b, input.HardDrives); err != nil {
+		return id, state, err
 	}
 
-	// Add generation parameter
+	// Refuse machines the host cannot run before creating anything.
+	generation := 2
 	if input.Generation != nil {
-		cmdArgs = append(cmdArgs, "-Generation", fmt.Sprintf("%d", *input.Generation))
-	} else {
-		// Default to Gen 2
-		cmdArgs = append(cmdArgs, "-Generation", "2")
+		generation = *input.Generation
 	}
-
-	// Use the first network adapter's switch name if available
-	if len(input.NetworkAdapters) > 0 && input.NetworkAdapters[0].SwitchName != nil {
-		cmdArgs = append(cmdArgs, "-SwitchName", fmt.Sprintf("\"%s\"", *input.NetworkAdapters[0].SwitchName))
+	if caps, err := b.HostCapabilities(ctx); err != nil {
+		logger.Warnf("Failed to probe the capabilities of the host: %v", err)
+	} else if err := caps.CheckGeneration(generation); err != nil {
+		return id, state, err
 	}
 
-	// Create the VM without adding drives initially
-	// We'll add drives and network adapters separately after creation
-	cmdArgs = append(cmdArgs, "-NoVHD")
-
-	// Execute the PowerShell command
-	var cmd *exec.Cmd
-	var output []byte
-	var cmdErr error
-
-	func() {
-		defer func() {
-			if r := recover(); r != nil {
-				cmdErr = fmt.Errorf("recovered from panic in PowerShell execution: %v", r)
-				logger.Warnf("Recovered from panic in PowerShell execution: %v", r)
-			}
-		}()
-
-		cmd = exec.Command(powershellExe, cmdArgs...)
-		output, cmdErr = cmd.CombinedOutput()
-	}()
-
-	if cmdErr != nil {
-		return id, state, fmt.Errorf("failed to create VM using PowerShell: %v, output: %s", cmdErr, string(output))
+	spec := backend.VMSpec{Name: id, Generation: generation}
+	if input.MemorySize != nil {
+		spec.MemoryMB = uint64(*input.MemorySize)
 	}
-
-	logger.Debugf("Created VM %s with PowerShell", id)
-
-	// Set processor count if specified
 	if input.ProcessorCount != nil {
-		procCmd := fmt.Sprintf("Set-VMProcessor -VMName \"%s\" -Count %d", id, *input.ProcessorCount)
-		_, err := util.RunPowerShellCommand(procCmd)
-		if err != nil {
-			logger.Warnf("Failed to set processor count: %v", err)
-			// Continue despite error
-		}
+		spec.ProcessorCount = *input.ProcessorCount
 	}
+	if _, err := b.CreateVM(ctx, spec); err != nil {
+		return id, state, fmt.Errorf("failed to create VM %s: %w", id, err)
+	}
+	logger.Infof("Created VM %s using the %s backend", id, b.Name())
 
-	// Configure dynamic memory if specified
-	if input.DynamicMemory != nil && *input.DynamicMemory {
-		var minMem, maxMem int64
-
-		if input.MinimumMemory != nil {
-			minMem = int64(*input.MinimumMemory) * 1024 * 1024 // Convert MB to bytes
-		} else {
-			minMem = 512 * 1024 * 1024 // 512MB default
-		}
-
-		if input.MaximumMemory != nil {
-			maxMem = int64(*input.MaximumMemory) * 1024 * 1024 // Convert MB to bytes
-		} else if input.MemorySize != nil {
-			maxMem = int64(*input.MemorySize) * 2 * 1024 * 1024 // Double startup memory if maximum not specified
-		} else {
-			maxMem = 2 * 1024 * 1024 * 1024 // 2GB default
+	// The processor count and startup memory are set by CreateVM.
+	settings.ProcessorCount, settings.MemoryMB = nil, nil
+	if !settings.IsEmpty() {
+		if err := b.SetVM(ctx, id, settings); err != nil {
+			return id, state, fmt.Errorf("failed to configure VM %s: %w", id, err)
 		}
+	}
 
-		memCmd := fmt.Sprintf("Set-VMMemory -VMName \"%s\" -DynamicMemoryEnabled $true -MinimumBytes %d -MaximumBytes %d",
-			id, minMem, maxMem)
-		_, err := util.RunPowerShellCommand(memCmd)
-		if err != nil {
-			logger.Warnf("Failed to configure dynamic memory: %v", err)
-			// Continue despite error
+	// Add or remove SCSI controllers before any disk is attached
+	if count, ok := scsiControllerCount(input, generation); ok {
+		if err := b.SetSCSIControllerCount(ctx, id, count); err != nil {
+			return id, state, fmt.Errorf("failed to set the SCSI controller count of VM %s to %d: %w", id, count, err)
 		}
+		logger.Infof("Set the SCSI controller count of VM %s to %d", id, count)
 	}
 
-	// Set auto start/stop actions if specified
-	if input.AutoStartAction != nil {
-		var startAction string
-		switch strings.ToLower(*input.AutoStartAction) {
-		case "nothing":
-			startAction = "Nothing"
-		case "startifrunning":
-			startAction = "StartIfRunning"
-		case "start":
-			startAction = "Start"
-		default:
-			startAction = "Nothing"
+	for _, hd := range input.HardDrives {
+		if hd == nil || hd.Path == nil {
+			logger.Debugf("Hard drive path not specified, skipping")
+			continue
 		}
-
-		autoStartCmd := fmt.Sprintf("Set-VM -VMName \"%s\" -AutomaticStartAction %s", id, startAction)
-		_, err := util.RunPowerShellCommand(autoStartCmd)
-		if err != nil {
-			logger.Warnf("Failed to set auto start action: %v", err)
-			// Continue despite error
+		if err := attachHardDrive(ctx, b, id, hd); err != nil {
+			return id, state, err
 		}
 	}
 
-	if input.AutoStopAction != nil {
-		var stopAction string
-		switch strings.ToLower(*input.AutoStopAction) {
-		case "turnoff":
-			stopAction = "TurnOff"
-		case "save":
-			stopAction = "Save"
-		case "shutdown":
-			stopAction = "ShutDown"
-		default:
-			stopAction = "TurnOff"
+	for _, disk := range input.PassThroughDisks {
+		if disk == nil {
+			continue
 		}
-
-		autoStopCmd := fmt.Sprintf("Set-VM -VMName \"%s\" -AutomaticStopAction %s", id, stopAction)
-		_, err := util.RunPowerShellCommand(autoStopCmd)
+		attached, err := b.AttachPassThroughDisk(ctx, id, passThroughSpec(disk))
 		if err != nil {
-			logger.Warnf("Failed to set auto stop action: %v", err)
-			// Continue despite error
+			return id, state, fmt.Errorf("failed to attach %s to VM %s: %w", passThroughSpec(disk), id, err)
 		}
+		logger.Infof("Attached %s to VM %s", attached.Name(), id)
 	}
 
-	// Add hard drives if specified
-	if len(input.HardDrives) > 0 {
-		for i, hd := range input.HardDrives {
-			if hd.Path == nil {
-				logger.Debugf("Hard drive path not specified, skipping")
-				continue
-			}
-
-			// Default values for controller
-			controllerType := "SCSI"
-			if hd.ControllerType != nil {
-				controllerType = *hd.ControllerType
-			}
-
-			// Default to first port
-			controllerNumber := 0
-			if hd.ControllerNumber != nil {
-				controllerNumber = *hd.ControllerNumber
-			}
-
-			// Default to sequential ports
-			controllerLocation := i
-			if hd.ControllerLocation != nil {
-				controllerLocation = *hd.ControllerLocation
-			}
-
-			hdCmd := fmt.Sprintf("Add-VMHardDiskDrive -VMName \"%s\" -Path \"%s\" -ControllerType %s -ControllerNumber %d -ControllerLocation %d",
-				id, *hd.Path, controllerType, controllerNumber, controllerLocation)
-			_, err := util.RunPowerShellCommand(hdCmd)
-			if err != nil {
-				logger.Warnf("Failed to add hard drive: %v", err)
-				// Continue with other hard drives despite error
-			} else {
-				logger.Debugf("Added hard drive %s to VM %s", *hd.Path, id)
-			}
+	for i, na := range input.NetworkAdapters {
+		if na == nil || na.SwitchName == nil {
+			logger.Debugf("Network adapter switch name not specified, skipping")
+			continue
 		}
-	}
-
-	// Add network adapters if specified
-	if len(input.NetworkAdapters) > 0 {
-		for i, na := range input.NetworkAdapters {
-			if na.SwitchName == nil {
-				logger.Debugf("Network adapter switch name not specified, skipping")
-				continue
-			}
-
-			// Use the index as part of name if no name provided
-			adapterName := fmt.Sprintf("Network Adapter %d", i+1)
-			if na.Name != nil {
-				adapterName = *na.Name
-			}
-
-			// Create the adapter and connect it to the switch
-			naCmd := fmt.Sprintf("Add-VMNetworkAdapter -VMName \"%s\" -Name \"%s\" -SwitchName \"%s\"",
-				id, adapterName, *na.SwitchName)
-
-			// Add MAC address if specified
-			if na.MacAddress != nil && *na.MacAddress != "" {
-				naCmd += fmt.Sprintf(" -StaticMacAddress \"%s\"", *na.MacAddress)
-			}
-
-			_, err := util.RunPowerShellCommand(naCmd)
-			if err != nil {
-				logger.Warnf("Failed to add network adapter: %v", err)
-				// Continue with other adapters despite error
-			} else {
-				logger.Debugf("Added network adapter %s to VM %s", adapterName, id)
-			}
+		if err := addNetworkAdapter(ctx, b, id, adapterName(i, na), na); err != nil {
+			return id, state, err
 		}
 	}
 
-	// Start the VM automatically after creation
-	startCmd := fmt.Sprintf("Start-VM -Name \"%s\"", id)
-	startOutput, startErr := util.RunPowerShellCommand(startCmd)
-	if startErr != nil {
-		// Check for specific error conditions
-		if strings.Contains(startOutput, "Not enough memory in the system to start the virtual machine") {
-			// Handle out of memory error with specific guidance
-			memoryRequiredStr := "default"
-			if input.MemorySize != nil {
-				memoryRequiredStr = fmt.Sprintf("%d", *input.MemorySize)
-			}
-
-			logger.Errorf("Memory error starting VM %s: %s", id, startOutput)
-			return id, state, fmt.Errorf("failed to start VM due to insufficient memory: the system does not have enough memory to allocate %s MB for this VM. "+
-				"Try reducing the memory allocation, closing other applications, or adding more RAM to the host system", memoryRequiredStr)
-		} else if strings.Contains(startOutput, "0x8007000E") {
-			// Generic out of resources error (could be memory or something else)
-			return id, state, fmt.Errorf("failed to start VM due to insufficient system resources (error 0x8007000E). " +
-				"Try reducing VM resource allocation, closing other applications, or adding more resources to the host system")
-		} else if strings.Contains(startOutput, "could not initialize memory") {
-			// Memory initialization error
-			return id, state, fmt.Errorf("failed to start VM due to memory initialization error. " +
-				"This could be due to insufficient memory, memory fragmentation, or a system configuration issue")
-		} else {
-			// Log the error but continue since the VM is created
-			logger.Warnf("Failed to start VM: %v", startErr)
-			logger.Debugf("Start-VM output: %s", startOutput)
+	// Start the VM after all configuration is done
+	logger.Infof("Starting VM %s", id)
+	if err := b.SetVMState(ctx, id, backend.PowerStateRunning); err != nil {
+		if startErr := startError(err, spec.MemoryMB); startErr != nil {
+			logger.Errorf("Failed to start VM %s: %v", id, err)
+			return id, state, startErr
 		}
+		// The VM is created, so it is kept and reported.
+		logger.Warnf("Failed to start VM %s: %v", id, err)
 	} else {
 		logger.Infof("Started VM %s", id)
 	}
@@ -511,667 +325,177 @@ func createVMWithPowerShell(ctx context.Context, id string, input MachineInputs)
 	return id, state, nil
 }
 
-// checkVMExistsPowerShell checks if a VM exists using PowerShell
-func checkVMExistsPowerShell(vmName string) (bool, error) {
-
-	// Command to check if VM exists
-	// The -ErrorAction SilentlyContinue prevents errors if the VM doesn't exist
-	cmd := fmt.Sprintf("(Get-VM -Name \"%s\" -ErrorAction SilentlyContinue | Measure-Object).Count", vmName)
-
-	output, err := util.RunPowerShellCommand(cmd)
-	if err != nil {
-		return false, fmt.Errorf("failed to check VM existence: %v", err)
-	}
-
-	// Parse the output (should be "0" or "1")
-	count, err := strconv.Atoi(strings.TrimSpace(output))
-	if err != nil {
-		return false, fmt.Errorf("failed to parse PowerShell output: %v, output: %s", err, output)
+// startError returns an actionable error when a VM failed to start for lack of memory or
+// other resources of the host, or nil for other failures.
+func startError(err error, memoryMB uint64) error {
+	if memoryMB == 0 {
+		memoryMB = 1024
+	}
+	message := err.Error()
+	switch {
+	case strings.Contains(message, "Not enough memory in the system to start the virtual machine"):
+		return fmt.Errorf("failed to start VM due to insufficient memory: the system does not have enough memory to allocate %d MB for this VM. "+
+			"Try reducing the memory allocation, closing other applications, or adding more RAM to the host system", memoryMB)
+	case strings.Contains(message, "0x8007000E"):
+		return fmt.Errorf("failed to start VM due to insufficient system resources (error 0x8007000E). " +
+			"Try reducing VM resource allocation, closing other applications, or adding more resources to the host system")
+	case strings.Contains(message, "could not initialize memory"):
+		return fmt.Errorf("failed to start VM due to memory initialization error. " +
+			"This could be due to insufficient memory, memory fragmentation, or a system configuration issue")
+	default:
+		return nil
 	}
-
-	return count > 0, nil
 }
 
-// readVMWithPowerShell reads VM properties using PowerShell
-func readVMWithPowerShell(ctx context.Context, vmName string, inputs MachineInputs) (MachineOutputs, error) {
-	logger := logging.GetLogger(ctx)
-	outputs := MachineOutputs{MachineInputs: inputs}
-
-	// Get VM details using PowerShell
-	vmDetails := fmt.Sprintf(`
-		$vm = Get-VM -Name "%s" -ErrorAction SilentlyContinue
-		if ($vm) {
-			$output = New-Object PSObject
-			$output | Add-Member -Type NoteProperty -Name Name -Value $vm.Name
-			$output | Add-Member -Type NoteProperty -Name ProcessorCount -Value $vm.ProcessorCount
-			$output | Add-Member -Type NoteProperty -Name MemoryStartupBytes -Value $vm.MemoryStartup
-			$output | Add-Member -Type NoteProperty -Name MemoryMinimumBytes -Value $vm.MemoryMinimum
-			$output | Add-Member -Type NoteProperty -Name MemoryMaximumBytes -Value $vm.MemoryMaximum
-			$output | Add-Member -Type NoteProperty -Name DynamicMemoryEnabled -Value $vm.DynamicMemoryEnabled
-			$output | Add-Member -Type NoteProperty -Name Generation -Value $vm.Generation
-			$output | Add-Member -Type NoteProperty -Name AutomaticStartAction -Value $vm.AutomaticStartAction
-			$output | Add-Member -Type NoteProperty -Name AutomaticStopAction -Value $vm.AutomaticStopAction
-			$output | ConvertTo-Json
-		}
-	`, vmName)
-
-	output, err := util.RunPowerShellCommand(vmDetails)
-	if err != nil {
-		logger.Warnf("Failed to get VM details: %v", err)
-		return outputs, nil
-	}
-
-	// Check if output is empty or invalid
-	output = strings.TrimSpace(output)
-	if output == "" {
-		logger.Warnf("Empty response from PowerShell when getting VM details")
-		return outputs, nil
-	}
-
-	// Parse key properties from output if present
-	// We don't need to parse everything as we'll populate with input values
-
-	// Try to extract processor count if not in inputs
-	if inputs.ProcessorCount == nil {
-		procCountMatch := strings.Index(output, `"ProcessorCount":`)
-		if procCountMatch >= 0 {
-			// This is a very simple parsing approach - in production you'd use proper JSON parsing
-			// However, this illustrates the idea
-			startIdx := procCountMatch + len(`"ProcessorCount":`)
-			endIdx := startIdx
-			for endIdx < len(output) && (output[endIdx] == ' ' || output[endIdx] == '\t') {
-				endIdx++
-			}
-			for endIdx < len(output) && (output[endIdx] >= '0' && output[endIdx] <= '9') {
-				endIdx++
-			}
-
-			if endIdx > startIdx {
-				procCount, err := strconv.Atoi(strings.TrimSpace(output[startIdx:endIdx]))
-				if err == nil {
-					outputs.ProcessorCount = &procCount
-					logger.Debugf("Found processor count: %d", procCount)
-				}
-			}
-		}
+// vmSettings returns the processor, memory and automatic action settings of news that differ
+// from olds. The memory limits are only set with dynamic memory.
+func vmSettings(olds MachineInputs, news MachineInputs) backend.VMSettings {
+	var settings backend.VMSettings
+	if news.ProcessorCount != nil && !intPtrEqual(olds.ProcessorCount, news.ProcessorCount) {
+		settings.ProcessorCount = news.ProcessorCount
 	}
-
-	// Try to extract memory if not in inputs
-	if inputs.MemorySize == nil {
-		memMatch := strings.Index(output, `"MemoryStartupBytes":`)
-		if memMatch >= 0 {
-			// This is a very simple parsing approach - in production you'd use proper JSON parsing
-			startIdx := memMatch + len(`"MemoryStartupBytes":`)
-			endIdx := startIdx
-			for endIdx < len(output) && (output[endIdx] == ' ' || output[endIdx] == '\t') {
-				endIdx++
-			}
-			for endIdx < len(output) && ((output[endIdx] >= '0' && output[endIdx] <= '9') || output[endIdx] == ',') {
-				endIdx++
-			}
-
-			if endIdx > startIdx {
-				memBytes, err := strconv.ParseInt(strings.TrimSpace(strings.Replace(output[startIdx:endIdx], ",", "", -1)), 10, 64)
-				if err == nil {
-					memMB := memBytes / (1024 * 1024) // Convert bytes to MB
-					memMBInt := int(memMB)
-					outputs.MemorySize = &memMBInt
-					logger.Debugf("Found memory size: %d MB", memMB)
-				}
-			}
-		}
+	if news.MemorySize != nil && !intPtrEqual(olds.MemorySize, news.MemorySize) {
+		memoryMB := uint64(*news.MemorySize)
+		settings.MemoryMB = &memoryMB
 	}
-
-	// Get hard drives using PowerShell
-	if len(inputs.HardDrives) == 0 {
-		hddCmd := fmt.Sprintf(`
-			$vm = Get-VM -Name "%s" -ErrorAction SilentlyContinue
-			if ($vm) {
-				$hds = Get-VMHardDiskDrive -VM $vm
-				$hds | ForEach-Object { $_.Path }
-			}
-		`, vmName)
-
-		hdOutput, err := util.RunPowerShellCommand(hddCmd)
-		if err == nil && len(hdOutput) > 0 {
-			// Process each line as a hard drive path
-			hdPaths := strings.Split(strings.TrimSpace(hdOutput), "\n")
-			hardDrives := make([]HardDriveInput, 0, len(hdPaths))
-
-			for i, path := range hdPaths {
-				trimmedPath := strings.TrimSpace(path)
-				if trimmedPath != "" {
-					hdPath := trimmedPath
-					controllerType := "SCSI" // Default
-					controllerNumber := 0    // Default
-					controllerLocation := i
-
-					hardDrive := HardDriveInput{
-						Path:               &hdPath,
-						ControllerType:     &controllerType,
-						ControllerNumber:   &controllerNumber,
-						ControllerLocation: &controllerLocation,
-					}
-
-					hardDrives = append(hardDrives, hardDrive)
-				}
-			}
-
-			if len(hardDrives) > 0 {
-				// Convert to pointer slice
-				hardDrivePtrs := make([]*HardDriveInput, len(hardDrives))
-				for i := range hardDrives {
-					hardDrivePtrs[i] = &hardDrives[i]
-				}
-				outputs.HardDrives = hardDrivePtrs
-				logger.Debugf("Found %d hard drives", len(hardDrives))
-			}
-		}
+	if news.DynamicMemory != nil && !boolPtrEqual(olds.DynamicMemory, news.DynamicMemory) {
+		settings.DynamicMemory = news.DynamicMemory
 	}
-
-	// Get network adapters using PowerShell
-	if len(inputs.NetworkAdapters) == 0 {
-		naCmd := fmt.Sprintf(`
-			$vm = Get-VM -Name "%s" -ErrorAction SilentlyContinue
-			if ($vm) {
-				$adapters = Get-VMNetworkAdapter -VM $vm
-				$adapters | ForEach-Object { 
-					$adapterObj = New-Object PSObject
-					$adapterObj | Add-Member -Type NoteProperty -Name Name -Value $_.Name
-					$adapterObj | Add-Member -Type NoteProperty -Name SwitchName -Value $_.SwitchName
-					$adapterObj | Add-Member -Type NoteProperty -Name MacAddress -Value $_.MacAddress
-					$adapterObj | ConvertTo-Json
-				}
-			}
-		`, vmName)
-
-		naOutput, err := util.RunPowerShellCommand(naCmd)
-		if err == nil && len(naOutput) > 0 {
-			// Process the output - in a real implementation, you'd parse the JSON properly
-			// For this example, we'll use a simple approach to illustrate
-
-			// Check if we have multiple adapters (multiple JSON objects) or just one
-			adapters := make([]NetworkAdapterInput, 0)
-
-			if strings.Contains(naOutput, "SwitchName") {
-				// For simplicity in this example, we'll just extract switch names
-				naLines := strings.Split(naOutput, "\n")
-				for _, line := range naLines {
-					if strings.Contains(line, `"SwitchName":`) {
-						startIdx := strings.Index(line, `"SwitchName":`) + len(`"SwitchName":`)
-						endIdx := strings.Index(line[startIdx:], `"`)
-						if endIdx > 0 {
-							switchName := strings.TrimSpace(line[startIdx : startIdx+endIdx])
-							switchName = strings.Trim(switchName, `"`)
-							if switchName != "" {
-								adapter := NetworkAdapterInput{
-									SwitchName: &switchName,
-								}
-								adapters = append(adapters, adapter)
-							}
-						}
-					}
-				}
-
-				if len(adapters) > 0 {
-					// Convert NetworkAdapterInput to networkadapter.NetworkAdapterInputs
-					naInputs := make([]*networkadapter.NetworkAdapterInputs, len(adapters))
-					for i, adapter := range adapters {
-						naInputs[i] = &networkadapter.NetworkAdapterInputs{
-							SwitchName: adapter.SwitchName,
-						}
-					}
-					outputs.NetworkAdapters = naInputs
-					logger.Debugf("Found %d network adapters", len(adapters))
-				}
-			}
+	if news.DynamicMemory != nil && *news.DynamicMemory {
+		if news.MinimumMemory != nil && !intPtrEqual(olds.MinimumMemory, news.MinimumMemory) {
+			minimumMB := uint64(*news.MinimumMemory)
+			settings.MinimumMemoryMB = &minimumMB
 		}
-	}
-
-	return outputs, nil
-}
-
-// Delete method to delete a virtual machine
-func (c *Machine) Delete(ctx context.Context, id string, props MachineOutputs) error {
-	logger := logging.GetLogger(ctx)
-
-	// Ensure vmId is set for the delete operation
-	// If the VM ID is not available in props, use the resource ID
-	vmName := id
-	if props.VmId != nil {
-		vmName = *props.VmId
-	} else if props.MachineName != nil {
-		vmName = *props.MachineName
-	}
-
-	logger.Infof("Deleting VM %s", vmName)
-
-	// Try to stop the VM before deleting it
-	// We'll use PowerShell as it's most reliable
-
-	// Try to stop the VM using PowerShell - this is most reliable
-
-	// First check if the VM exists
-	existsCmd := fmt.Sprintf("Get-VM -Name \"%s\" -ErrorAction SilentlyContinue", vmName)
-	existsOutput, existsErr := util.RunPowerShellCommand(existsCmd)
-	if existsErr != nil || strings.TrimSpace(existsOutput) == "" {
-		logger.Infof("VM %s does not exist or is not accessible, skipping deletion", vmName)
-		return nil
-	}
-
-	// Check if the VM is running
-	checkCmd := fmt.Sprintf("(Get-VM -Name \"%s\" -ErrorAction SilentlyContinue).State -eq 'Running'", vmName)
-	output, err := util.RunPowerShellCommand(checkCmd)
-	isRunning := false
-	if err == nil && strings.TrimSpace(output) == "True" {
-		isRunning = true
-	}
-
-	if isRunning {
-		logger.Infof("Stopping VM %s before deletion", vmName)
-		// Use -TurnOff to force an immediate shutdown rather than a graceful one
-		stopCmd := fmt.Sprintf("Stop-VM -Name \"%s\" -Force -TurnOff", vmName)
-		_, err = util.RunPowerShellCommand(stopCmd)
-		if err != nil {
-			logger.Warnf("Failed to stop VM with PowerShell: %v", err)
-			// Try to delete anyway
-		} else {
-			logger.Infof("Successfully stopped VM %s", vmName)
+		if news.MaximumMemory != nil && !intPtrEqual(olds.MaximumMemory, news.MaximumMemory) {
+			maximumMB := uint64(*news.MaximumMemory)
+			settings.MaximumMemoryMB = &maximumMB
 		}
 	}
-
-	// Get OS version to check if we're running on Azure
-	osVersion, osErr := util.GetOSVersion()
-	if osErr == nil && strings.Contains(strings.ToLower(osVersion), "azure") {
-		logger.Infof("Detected Azure datacenter edition, using alternative VM deletion approach")
-
-		// On Azure, first check VM state again to ensure it's fully stopped
-		checkStoppedCmd := fmt.Sprintf("(Get-VM -Name \"%s\" -ErrorAction SilentlyContinue).State -eq 'Off'", vmName)
-		stoppedOutput, stoppedErr := util.RunPowerShellCommand(checkStoppedCmd)
-		isStopped := false
-		if stoppedErr == nil && strings.TrimSpace(stoppedOutput) == "True" {
-			isStopped = true
-		}
-
-		if !isStopped {
-			logger.Warnf("VM %s may not be fully stopped, force stopping again", vmName)
-			stopAgainCmd := fmt.Sprintf("Stop-VM -Name \"%s\" -Force -TurnOff", vmName)
-			_, _ = util.RunPowerShellCommand(stopAgainCmd)
-			// Small delay to allow VM to fully stop
-			logger.Infof("Waiting for VM to fully stop...")
-		}
-
-		// Try using the alternative deletion approach that works better on Azure
-		// Uses Get-VM | Remove-VM pattern which can be more reliable than direct Remove-VM
-		deleteAzureCmd := fmt.Sprintf("Get-VM -Name \"%s\" | Remove-VM -Force", vmName)
-		_, azureErr := util.RunPowerShellCommand(deleteAzureCmd)
-		if azureErr == nil {
-			logger.Infof("Successfully deleted VM %s using Azure-specific approach", vmName)
-			return nil
-		}
-		logger.Warnf("Alternative VM deletion failed: %v, trying standard approach", azureErr)
+	if news.AutoStartAction != nil && !stringPtrEqual(olds.AutoStartAction, news.AutoStartAction) {
+		settings.AutomaticStartAction = news.AutoStartAction
 	}
-
-	// Standard deletion approach
-	deleteCmd := fmt.Sprintf("Remove-VM -Name \"%s\" -Force", vmName)
-	_, err = util.RunPowerShellCommand(deleteCmd)
-	if err != nil {
-		// Check if VM still exists after failed deletion attempt
-		existsCmd := fmt.Sprintf("Get-VM -Name \"%s\" -ErrorAction SilentlyContinue", vmName)
-		existsOutput, _ := util.RunPowerShellCommand(existsCmd)
-		if strings.TrimSpace(existsOutput) == "" {
-			// VM doesn't exist anymore despite error, consider it successfully deleted
-			logger.Infof("VM %s no longer exists despite deletion error, considering it successfully deleted", vmName)
-			return nil
-		}
-		return fmt.Errorf("failed to delete VM %s with PowerShell: %v", vmName, err)
+	if news.AutoStopAction != nil && !stringPtrEqual(olds.AutoStopAction, news.AutoStopAction) {
+		settings.AutomaticStopAction = news.AutoStopAction
 	}
-
-	logger.Infof("Successfully deleted VM %s", vmName)
-	return nil
+	return settings
 }
 
-func (c *Machine) Create(ctx context.Context, name string, input MachineInputs, preview bool) (string, MachineOutputs, error) {
-	logger := logging.GetLogger(ctx)
-	id := name
-	if input.MachineName != nil {
-		id = *input.MachineName
-	}
-	state := MachineOutputs{MachineInputs: input}
-
-	// Always ensure vmId is set
-	EnsureVmId(&state, id)
-
-	// If in preview, don't run the command.
-	if preview {
-		return id, state, nil
+// scsiControllerCount returns the number of SCSI controllers a new VM needs and whether it
+// differs from the controllers New-VM gives it: one for generation 2 and none for generation
+// 1. Without scsiControllerCount, controllers are only added for the disks that use them.
+func scsiControllerCount(input MachineInputs, generation int) (int, bool) {
+	initial := 0
+	if generation == 2 {
+		initial = 1
 	}
-	vmmsClient, vsms, err := c.Connect(ctx)
-	if err != nil {
-		logger.Warnf("Error connecting to Hyper-V: %v", err)
-		return id, state, fmt.Errorf("failed to connect to Hyper-V: %v", err)
-	}
-
-	// If both vmmsClient and vsms are nil, use PowerShell fallback entirely
-	// If only vsms is nil but vmmsClient is available, use PowerShell for operations that need vsms
-	if vmmsClient == nil && vsms == nil {
-		// Using PowerShell fallback for the entire VM creation
-		logger.Infof("Using PowerShell fallback to create VM %s", id)
-		return createVMWithPowerShell(ctx, id, input)
-	}
-
-	// If we have vmmsClient but not vsms (common on Windows 10/11), also use PowerShell
-	if vmmsClient != nil && vsms == nil {
-		logger.Infof("VMMS available but VSMS is nil - using PowerShell fallback to create VM %s", id)
-		return createVMWithPowerShell(ctx, id, input)
-	}
-
-	// If we have both vmmsClient and vsms, proceed with WMI implementation
-	vConn := vmmsClient.GetVirtualizationConn()
-	if vConn == nil {
-		logger.Warnf("Virtualization connection is nil, falling back to PowerShell")
-		return createVMWithPowerShell(ctx, id, input)
-	}
-
-	wmiHost := vConn.WMIHost
-	if wmiHost == nil {
-		logger.Warnf("WMI host is nil, falling back to PowerShell")
-		return createVMWithPowerShell(ctx, id, input)
-	}
-
-	// Now create the VM settings with proper error handling
-	var setting *virtualsystem.VirtualSystemSettingData
-	var settingErr error
-
-	func() {
-		defer func() {
-			if r := recover(); r != nil {
-				settingErr = fmt.Errorf("recovered from panic in GetVirtualSystemSettingData: %v", r)
-				logger.Warnf("Recovered from panic in GetVirtualSystemSettingData: %v", r)
-			}
-		}()
-
-		setting, settingErr = virtualsystem.GetVirtualSystemSettingData(wmiHost, id)
-	}()
-
-	if settingErr != nil {
-		logger.Warnf("Failed to get virtual system setting data: %v, falling back to PowerShell", settingErr)
-		return createVMWithPowerShell(ctx, id, input)
-	}
-
-	if setting == nil {
-		logger.Warnf("Virtual system setting data is nil, falling back to PowerShell")
-		return createVMWithPowerShell(ctx, id, input)
-	}
-
-	err = setting.SetPropertyInstanceID(id)
-	if err != nil {
-		logger.Warnf("Failed to set property instance ID: %v, falling back to PowerShell", err)
-		return createVMWithPowerShell(ctx, id, input)
+	if input.ScsiControllerCount != nil {
+		return *input.ScsiControllerCount, *input.ScsiControllerCount != initial
 	}
-
-	defer setting.Close()
-	logger.Debugf("Create VMSettings")
-
-	if input.Generation != nil {
-		switch *input.Generation {
-		case 1:
-			err = setting.SetHyperVGeneration(virtualsystem.HyperVGeneration_V1)
-			// Set Secure Boot to false for Generation 1
-			// Hyper-V Generation 1 VMs do not support Secure Boot.
-			// according to this test: https://github.com/microsoft/wmi/blob/master/pkg/virtualization/core/service/virtualmachinemanagementservice_test.go#L100
-			// TODO: Check if this is the correct way to set Secure Boot to false for Generation 1 VMs from Microsft documentation.
-			secure_boot_err := setting.SetPropertySecureBootEnabled(false)
-			if secure_boot_err != nil {
-				return id, state, fmt.Errorf("Failed [%+v]", secure_boot_err)
-			}
-		case 2:
-			err = setting.SetHyperVGeneration(virtualsystem.HyperVGeneration_V2)
-		default:
-			logger.Errorf("Invalid generation: %d, setting V2", *input.Generation)
-			err = setting.SetHyperVGeneration(virtualsystem.HyperVGeneration_V2)
+	needed := 0
+	use := func(controllerType *string, controllerNumber *int) {
+		if controllerType != nil && !strings.EqualFold(*controllerType, backend.ControllerSCSI) {
+			return
 		}
-		if err != nil {
-			return id, state, fmt.Errorf("Failed [%+v]", err)
-		}
-	} else {
-		err = setting.SetHyperVGeneration(virtualsystem.HyperVGeneration_V2)
-		if err != nil {
-			return id, state, fmt.Errorf("Failed [%+v]", err)
+		number := 0
+		if controllerNumber != nil {
+			number = *controllerNumber
 		}
+		needed = max(needed, number+1)
 	}
-
-	// Set auto start action if specified
-	if input.AutoStartAction != nil {
-		var autoStartValue uint16
-		switch *input.AutoStartAction {
-		case "Nothing":
-			autoStartValue = 0
-		case "StartIfRunning":
-			autoStartValue = 1
-		case "Start":
-			autoStartValue = 2
-		default:
-			logger.Errorf("Invalid auto start action: %s, setting Nothing", *input.AutoStartAction)
-			autoStartValue = 0
-		}
-		err = setting.SetProperty("AutoStartAction", autoStartValue)
-		if err != nil {
-			return id, state, fmt.Errorf("Failed to set auto start action: [%+v]", err)
+	for _, hd := range input.HardDrives {
+		if hd != nil && hd.Path != nil {
+			use(hd.ControllerType, hd.ControllerNumber)
 		}
 	}
-
-	// Set auto stop action if specified
-	if input.AutoStopAction != nil {
-		var autoStopValue uint16
-		switch *input.AutoStopAction {
-		case "TurnOff":
-			autoStopValue = 0
-		case "Save":
-			autoStopValue = 1
-		case "ShutDown":
-			autoStopValue = 2
-		default:
-			logger.Errorf("Invalid auto stop action: %s, setting TurnOff", *input.AutoStopAction)
-			autoStopValue = 0
-		}
-		err = setting.SetProperty("AutoStopAction", autoStopValue)
-		if err != nil {
-			return id, state, fmt.Errorf("Failed to set auto stop action: [%+v]", err)
+	for _, disk := range input.PassThroughDisks {
+		if disk != nil {
+			use(disk.ControllerType, disk.ControllerNumber)
 		}
 	}
+	return max(needed, initial), needed > initial
+}
 
-	memorySettings, err := memory.GetDefaultMemorySettingData(vmmsClient.GetVirtualizationConn().WMIHost)
-	if err != nil {
-		return id, state, fmt.Errorf("Failed [%+v]", err)
+// scsiControllerChange returns the number of SCSI controllers of a VM with olds and the
+// number it needs for news. Without scsiControllerCount, controllers are added for the disks
+// that use them like Create does, and none are removed.
+func scsiControllerChange(olds MachineInputs, news MachineInputs, generation int) (int, int) {
+	oldCount, _ := scsiControllerCount(olds, generation)
+	newCount, _ := scsiControllerCount(news, generation)
+	if news.ScsiControllerCount == nil {
+		newCount = max(newCount, oldCount)
 	}
-	defer memorySettings.Close()
+	return oldCount, newCount
+}
 
-	// Set memory size
-	var memorySizeMB uint64 = 1024 // Default value
-	if input.MemorySize != nil {
-		memorySizeMB = uint64(*input.MemorySize)
-	}
-	err = memorySettings.SetSizeMB(memorySizeMB)
+// attachHardDrive attaches a hard drive with its storage settings. Without a location the
+// first free location of the controller is used.
+func attachHardDrive(ctx context.Context, b backend.HypervBackend, vmName string, hd *HardDriveInput) error {
+	settings, err := hardDiskSettings(hd)
 	if err != nil {
-		return id, state, fmt.Errorf("Failed to set memory size: %v", err)
+		return err
 	}
-
-	// Set dynamic memory if specified
-	if input.DynamicMemory != nil && *input.DynamicMemory {
-		err = memorySettings.SetPropertyDynamicMemoryEnabled(true)
-		if err != nil {
-			return id, state, fmt.Errorf("Failed to enable dynamic memory: %v", err)
-		}
-
-		// Set minimum memory if specified
-		if input.MinimumMemory != nil {
-			minMemory := uint64(*input.MinimumMemory)
-			err = memorySettings.SetProperty("MinimumBytes", minMemory*1024*1024) // Convert MB to bytes
-			if err != nil {
-				return id, state, fmt.Errorf("Failed to set minimum memory: %v", err)
-			}
-		}
-
-		// Set maximum memory if specified
-		if input.MaximumMemory != nil {
-			maxMemory := uint64(*input.MaximumMemory)
-			err = memorySettings.SetProperty("MaximumBytes", maxMemory*1024*1024) // Convert MB to bytes
-			if err != nil {
-				return id, state, fmt.Errorf("Failed to set maximum memory: %v", err)
-			}
-		}
+	drive := backend.DiskDrive{Path: *hd.Path, ControllerType: backend.ControllerSCSI, ControllerLocation: -1}
+	if hd.ControllerType != nil {
+		drive.ControllerType = *hd.ControllerType
 	}
-
-	processorSettings, err := processor.GetDefaultProcessorSettingData(vmmsClient.GetVirtualizationConn().WMIHost)
-	if err != nil {
-		return id, state, fmt.Errorf("Failed [%+v]", err)
+	if hd.ControllerNumber != nil {
+		drive.ControllerNumber = *hd.ControllerNumber
 	}
-	var cpuCount uint64 = 1 // Default value
-	if input.ProcessorCount != nil {
-		cpuCount = uint64(*input.ProcessorCount)
+	if hd.ControllerLocation != nil {
+		drive.ControllerLocation = *hd.ControllerLocation
 	}
-	err = processorSettings.SetCPUCount(cpuCount)
-	if err != nil {
-		return id, state, fmt.Errorf("Failed to set CPU count: %v", err)
+	if !settings.IsEmpty() {
+		drive.Settings = settings
 	}
-
-	vm, err := vsms.CreateVirtualMachine(setting, memorySettings, processorSettings)
+	attached, err := b.AttachDisk(ctx, vmName, drive)
 	if err != nil {
-		return id, state, fmt.Errorf("Failed vsms.CreateVirtualMachine: [%+v]", err)
+		return fmt.Errorf("failed to add hard drive %s to VM %s: %w", *hd.Path, vmName, err)
 	}
-	logger.Debugf("Created VM")
-
-	// Add hard drives if specified
-	if len(input.HardDrives) > 0 {
-		for _, hd := range input.HardDrives {
-			if hd.Path == nil {
-				logger.Debugf("Hard drive path not specified, skipping")
-				continue
-			}
-
-			// Default values for controller
-			controllerType := "SCSI"
-			if hd.ControllerType != nil {
-				controllerType = *hd.ControllerType
-			}
-
-			controllerNumber := 0
-			if hd.ControllerNumber != nil {
-				controllerNumber = *hd.ControllerNumber
-			}
-
-			controllerLocation := 0
-			if hd.ControllerLocation != nil {
-				controllerLocation = *hd.ControllerLocation
-			}
+	logging.GetLogger(ctx).Infof("Added hard drive %s to VM %s on %s controller %d location %d",
+		*hd.Path, vmName, attached.ControllerType, attached.ControllerNumber, attached.ControllerLocation)
+	return nil
+}
 
-			logger.Debugf("Adding hard drive %s to VM %s", *hd.Path, id)
-			logger.Debugf("Controller details for VM %s: type=%s, number=%d, location=%d",
-				id, controllerType, controllerNumber, controllerLocation)
-
-			// Wrap in recovery block to prevent panics in case of type errors
-			var addErr error
-			func() {
-				defer func() {
-					if r := recover(); r != nil {
-						addErr = fmt.Errorf("recovered from panic in AttachVirtualHardDisk: %v", r)
-						logger.Errorf("Recovered from panic in AttachVirtualHardDisk: %v", r)
-					}
-				}()
-
-				// Use the new robust VMMS method with fallback logic
-				addErr = vmmsClient.AttachVirtualHardDisk(vm, *hd.Path, controllerType, controllerNumber, controllerLocation, logger)
-			}()
+// Delete turns off and deletes the virtual machine.
+func (c *Machine) Delete(ctx context.Context, id string, props MachineOutputs) error {
+	logger := logging.GetLogger(ctx)
 
-			if addErr != nil {
-				logger.Errorf("Failed to add hard drive: %v", addErr)
-				logger.Warnf("Saving machine to state despite hard drive attachment failure")
-				return id, state, fmt.Errorf("failed to add hard drive: %v", addErr)
-			} else {
-				logger.Infof("Successfully added hard drive to VM %s", id)
-			}
-		}
+	// Ensure vmId is set for the delete operation
+	// If the VM ID is not available in props, use the resource ID
+	vmName := id
+	if props.VmId != nil {
+		vmName = *props.VmId
+	} else if props.MachineName != nil {
+		vmName = *props.MachineName
 	}
 
-	// Add network adapters if specified
-	if len(input.NetworkAdapters) > 0 {
-		for i, na := range input.NetworkAdapters {
-			if na.SwitchName == nil {
-				logger.Debugf("Network adapter switch name not specified, skipping")
-				continue
-			}
-
-			// Use the index as part of name if no name provided
-			adapterName := fmt.Sprintf("Network Adapter %d", i+1)
-			if na.Name != nil {
-				adapterName = *na.Name
-			}
-
-			logger.Debugf("Adding network adapter %s to VM %s, connected to switch %s",
-				adapterName, id, *na.SwitchName)
-
-			// Wrap in recovery block to prevent panics in case of type errors
-			var addErr error
-			func() {
-				defer func() {
-					if r := recover(); r != nil {
-						addErr = fmt.Errorf("recovered from panic in AddVirtualNetworkAdapterAndConnect: %v", r)
-						logger.Errorf("Recovered from panic in AddVirtualNetworkAdapterAndConnect: %v", r)
-					}
-				}()
-
-				// Use the new robust VMMS method with fallback logic
-				addErr = vmmsClient.AddVirtualNetworkAdapterAndConnect(vm, adapterName, *na.SwitchName, logger)
-			}()
-
-			if addErr != nil {
-				logger.Errorf("Failed to add network adapter: %v", addErr)
-				// Return the state with created VM even though adding the network adapter failed
-				// This allows the VM to exist in the Pulumi state so it can be cleaned up properly
-				logger.Warnf("Saving machine to state despite network adapter attachment failure")
-				return id, state, fmt.Errorf("failed to add network adapter: %v", addErr)
-			} else {
-				logger.Infof("Successfully added network adapter %s to VM %s", adapterName, id)
-			}
-		}
+	logger.Infof("Deleting VM %s", vmName)
+	b, err := backend.Connect(ctx)
+	if err != nil {
+		return err
 	}
+	if err := deleteVM(ctx, b, vmName); err != nil {
+		return fmt.Errorf("failed to delete VM %s: %w", vmName, err)
+	}
+	logger.Infof("Deleted VM %s", vmName)
+	return nil
+}
 
-	// Start the VM after all configuration is done
-	logger.Infof("Starting VM %s", id)
-
-	startCmd := fmt.Sprintf("Start-VM -Name \"%s\"", id)
-	output, startErr := util.RunPowerShellCommand(startCmd)
-	if startErr != nil {
-		// Check for specific error conditions
-		if strings.Contains(output, "Not enough memory in the system to start the virtual machine") {
-			// Handle out of memory error with specific guidance
-			memoryRequiredStr := fmt.Sprintf("%d", memorySizeMB)
-			if input.MemorySize != nil {
-				memoryRequiredStr = fmt.Sprintf("%d", *input.MemorySize)
-			}
-
-			logger.Errorf("Memory error starting VM %s: %s", id, output)
-			return id, state, fmt.Errorf("failed to start VM due to insufficient memory: the system does not have enough memory to allocate %s MB for this VM. "+
-				"Try reducing the memory allocation, closing other applications, or adding more RAM to the host system", memoryRequiredStr)
-		} else if strings.Contains(output, "0x8007000E") {
-			// Generic out of resources error (could be memory or something else)
-			return id, state, fmt.Errorf("failed to start VM due to insufficient system resources (error 0x8007000E). " +
-				"Try reducing VM resource allocation, closing other applications, or adding more resources to the host system")
-		} else if strings.Contains(output, "could not initialize memory") {
-			// Memory initialization error
-			return id, state, fmt.Errorf("failed to start VM due to memory initialization error. " +
-				"This could be due to insufficient memory, memory fragmentation, or a system configuration issue")
-		} else {
-			// Log the error but continue since the VM is created
-			logger.Warnf("Failed to start VM with PowerShell: %v", startErr)
-			logger.Debugf("Start-VM output: %s", output)
+// deleteVM turns off and deletes the VM named vmName. A VM that doesn't exist is already
+// deleted.
+func deleteVM(ctx context.Context, b backend.HypervBackend, vmName string) error {
+	vm, err := b.GetVM(ctx, vmName)
+	if errors.Is(err, errs.ErrNotFound) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if vm.State != backend.PowerStateOff {
+		if err := b.SetVMState(ctx, vm.Name, backend.PowerStateOff); err != nil {
+			return err
 		}
-	} else {
-		logger.Infof("Started VM %s using PowerShell", id)
 	}
-
-	return id, state, nil
+	return b.DeleteVM(ctx, vm.Name)
 }
 
 // WireDependencies controls how secrets and unknowns flow through a resource.
@@ -1181,7 +505,8 @@ func (c *Machine) Create(ctx context.Context, name string, input MachineInputs,
 //
 // Because we want every output to depend on every input, we can leave the default behavior.
 
-// The Update method will be run on every update.
+// Update applies the changed settings, disks and network adapters to the virtual machine,
+// stopping it first when a change requires it.
 func (c *Machine) Update(ctx context.Context, id string, olds MachineOutputs, news MachineInputs, preview bool) (MachineOutputs, error) {
 	logger := logging.GetLogger(ctx)
 	logger.Infof("Updating VM %s", id)
@@ -1196,6 +521,20 @@ func (c *Machine) Update(ctx context.Context, id string, olds MachineOutputs, ne
 		EnsureVmId(&state, id)
 	}
 
+	if err := validateHardDrives(news.HardDrives); err != nil {
+		return state, err
+	}
+	if err := validatePassThroughDisks(news.PassThroughDisks); err != nil {
+		return state, err
+	}
+	if err := validateControllers(news); err != nil {
+		return state, err
+	}
+	settings := vmSettings(olds.MachineInputs, news)
+	if err := settings.Validate(); err != nil {
+		return state, err
+	}
+
 	// If in preview, don't run the command.
 	if preview {
 		return state, nil
@@ -1208,802 +547,538 @@ func (c *Machine) Update(ctx context.Context, id string, olds MachineOutputs, ne
 	} else if news.MachineName != nil {
 		vmName = *news.MachineName
 	}
-	logger.Infof("Using VM name: %s", vmName)
 
-	// Check if the VM exists
-	exists, err := checkVMExistsPowerShell(vmName)
+	b, err := backend.Connect(ctx)
 	if err != nil {
-		logger.Errorf("Error checking if VM exists: %v", err)
-		return state, fmt.Errorf("failed to check if VM exists: %v", err)
+		return state, err
 	}
-	if !exists {
-		logger.Errorf("VM %s does not exist", vmName)
-		return state, fmt.Errorf("VM %s does not exist", vmName)
+	if err := checkHardDrives(b, news.HardDrives); err != nil {
+		return state, err
 	}
-
-	// Connect to Hyper-V
-	vmmsClient, vsms, err := c.Connect(ctx)
-	if err != nil {
-		logger.Warnf("Error connecting to Hyper-V: %v", err)
-		// Fall back to PowerShell completely
-		logger.Infof("Using PowerShell fallback for VM update")
-		return updateVMWithPowerShell(ctx, vmName, olds, news)
+	vm, err := b.GetVM(ctx, vmName)
+	if errors.Is(err, errs.ErrNotFound) {
+		return state, fmt.Errorf("VM %s does not exist", vmName)
+	} else if err != nil {
+		return state, fmt.Errorf("failed to get VM %s: %w", vmName, err)
+	}
+
+	// Stop the VM if a change requires it, and start it again afterwards if it was running,
+	// also when the update fails part way.
+	if reason := stopReason(olds.MachineInputs, news, vm.Generation); reason != "" && vm.State != backend.PowerStateOff {
+		logger.Infof("Stopping VM %s before updating because %s", vmName, reason)
+		if err := b.SetVMState(ctx, vmName, backend.PowerStateOff); err != nil {
+			return state, fmt.Errorf("failed to stop VM %s before update: %w", vmName, err)
+		}
+		if vm.State == backend.PowerStateRunning {
+			defer func() {
+				logger.Infof("Restarting VM %s after update", vmName)
+				if err := b.SetVMState(ctx, vmName, backend.PowerStateRunning); err != nil {
+					// The changes were applied, so a failed restart doesn't fail the update.
+					logger.Warnf("Failed to restart VM %s after update: %v", vmName, err)
+				}
+			}()
+		}
 	}
 
-	// Check if we need to stop the VM to make changes
-	// Always check if VM is running with PowerShell as it's most reliable
-	needsRestart := false
-	wasRunning, err := isVMRunningPowerShell(vmName)
-	if err != nil {
-		logger.Warnf("Error checking if VM is running: %v", err)
-		// Continue anyway, we'll handle errors later
-	}
-
-	// Determine if we need to stop the VM based on properties being changed
-	needsVMStopped := false
-
-	// Check changes that require the VM to be stopped
-	if (olds.ProcessorCount != nil && news.ProcessorCount != nil && *olds.ProcessorCount != *news.ProcessorCount) ||
-		(olds.MemorySize != nil && news.MemorySize != nil && *olds.MemorySize != *news.MemorySize) ||
-		((olds.DynamicMemory == nil || !*olds.DynamicMemory) && news.DynamicMemory != nil && *news.DynamicMemory) ||
-		(olds.DynamicMemory != nil && *olds.DynamicMemory && news.DynamicMemory != nil && !*news.DynamicMemory) ||
-		// Changes in minimum or maximum memory for dynamic memory
-		((olds.MinimumMemory == nil && news.MinimumMemory != nil) ||
-			(olds.MinimumMemory != nil && news.MinimumMemory != nil && *olds.MinimumMemory != *news.MinimumMemory)) ||
-		((olds.MaximumMemory == nil && news.MaximumMemory != nil) ||
-			(olds.MaximumMemory != nil && news.MaximumMemory != nil && *olds.MaximumMemory != *news.MaximumMemory)) {
-		needsVMStopped = true
-		logger.Infof("VM update requires stopping the VM because processor, memory, or dynamic memory settings are changing")
-	}
-
-	// Network adapters or hard drives changes also require VM to be stopped
-	if len(olds.NetworkAdapters) != len(news.NetworkAdapters) || len(olds.HardDrives) != len(news.HardDrives) {
-		needsVMStopped = true
-		logger.Infof("VM update requires stopping the VM because network adapters or hard drives are changing")
-	}
-
-	// If VM needs to be stopped and is running, stop it
-	if needsVMStopped && wasRunning {
-		logger.Infof("Stopping VM %s before updating", vmName)
-		stopErr := stopVMPowerShell(vmName)
-		if stopErr != nil {
-			logger.Errorf("Failed to stop VM %s: %v", vmName, stopErr)
-			return state, fmt.Errorf("failed to stop VM %s before update: %v", vmName, stopErr)
-		}
-		needsRestart = true
-		logger.Infof("VM %s stopped successfully", vmName)
-	}
-
-	// If we don't have VMMS client or VSMS, use PowerShell for everything
-	if vmmsClient == nil || vsms == nil {
-		logger.Infof("Using PowerShell fallback for VM update because VMMS or VSMS is nil")
-		result, err := updateVMWithPowerShell(ctx, vmName, olds, news)
-
-		// Start the VM if we stopped it and update was successful
-		if err == nil && needsRestart {
-			logger.Infof("Restarting VM %s after update", vmName)
-			startErr := startVMPowerShell(vmName)
-			if startErr != nil {
-				logger.Warnf("Failed to restart VM %s after update: %v", vmName, startErr)
-				// We'll warn but not fail the update since the changes were applied
-			}
+	if !settings.IsEmpty() {
+		if err := b.SetVM(ctx, vmName, settings); err != nil {
+			return state, fmt.Errorf("failed to update VM %s: %w", vmName, err)
 		}
-
-		return result, err
 	}
 
-	// Use WMI when available
-	vm, err := vsms.GetVirtualMachineByName(vmName)
-	if err != nil {
-		logger.Warnf("Failed to get VM %s using WMI: %v", vmName, err)
-		logger.Infof("Falling back to PowerShell for VM update")
-		result, err := updateVMWithPowerShell(ctx, vmName, olds, news)
-
-		// Start the VM if we stopped it and update was successful
-		if err == nil && needsRestart {
-			logger.Infof("Restarting VM %s after update", vmName)
-			startErr := startVMPowerShell(vmName)
-			if startErr != nil {
-				logger.Warnf("Failed to restart VM %s after update: %v", vmName, startErr)
-				// We'll warn but not fail the update since the changes were applied
-			}
+	// Add SCSI controllers before attaching disks to them, and remove them after their disks
+	// are detached.
+	oldSCSI, newSCSI := scsiControllerChange(olds.MachineInputs, news, vm.Generation)
+	if newSCSI > oldSCSI {
+		if err := b.SetSCSIControllerCount(ctx, vmName, newSCSI); err != nil {
+			return state, fmt.Errorf("failed to set the SCSI controller count of VM %s to %d: %w", vmName, newSCSI, err)
 		}
-
-		return result, err
+		logger.Infof("Set the SCSI controller count of VM %s to %d", vmName, newSCSI)
 	}
-	defer vm.Close()
 
-	// Get VM settings data
-	vmSettings, err := virtualsystem.GetVirtualSystemSettingData(vmmsClient.GetVirtualizationConn().WMIHost, vmName)
-	if err != nil {
-		logger.Warnf("Failed to get VM settings: %v", err)
-		logger.Infof("Falling back to PowerShell for VM update")
-		result, err := updateVMWithPowerShell(ctx, vmName, olds, news)
-
-		// Start the VM if we stopped it and update was successful
-		if err == nil && needsRestart {
-			logger.Infof("Restarting VM %s after update", vmName)
-			startErr := startVMPowerShell(vmName)
-			if startErr != nil {
-				logger.Warnf("Failed to restart VM %s after update: %v", vmName, startErr)
-				// We'll warn but not fail the update since the changes were applied
-			}
+	for _, hd := range removedHardDrives(olds.HardDrives, news.HardDrives) {
+		if err := b.DetachDisk(ctx, vmName, *hd.Path); err != nil && !errors.Is(err, errs.ErrNotFound) {
+			return state, fmt.Errorf("failed to remove hard drive %s from VM %s: %w", *hd.Path, vmName, err)
 		}
-
-		return result, err
+		logger.Infof("Removed hard drive %s from VM %s", *hd.Path, vmName)
 	}
-	defer vmSettings.Close()
-
-	// Update processor count if changed
-	if news.ProcessorCount != nil && (olds.ProcessorCount == nil || *olds.ProcessorCount != *news.ProcessorCount) {
-		logger.Infof("Updating processor count from %v to %d", olds.ProcessorCount, *news.ProcessorCount)
-
-		// Try WMI first
-		processorSettings, err := processor.GetDefaultProcessorSettingData(vmmsClient.GetVirtualizationConn().WMIHost)
-		if err != nil || processorSettings == nil {
-			logger.Warnf("Failed to get processor settings: %v", err)
-			// Fallback to PowerShell for this setting
-			procCmd := fmt.Sprintf("Set-VMProcessor -VMName \"%s\" -Count %d", vmName, *news.ProcessorCount)
-			_, psErr := util.RunPowerShellCommand(procCmd)
-			if psErr != nil {
-				logger.Warnf("Failed to update processor count: %v", psErr)
-				// Continue with other updates despite error
-			} else {
-				logger.Infof("Updated processor count to %d using PowerShell", *news.ProcessorCount)
-			}
-		} else {
-			defer processorSettings.Close()
-			err = processorSettings.SetCPUCount(uint64(*news.ProcessorCount))
-			if err != nil {
-				logger.Warnf("Failed to set CPU count: %v", err)
-				// Fallback to PowerShell
-				procCmd := fmt.Sprintf("Set-VMProcessor -VMName \"%s\" -Count %d", vmName, *news.ProcessorCount)
-				_, psErr := util.RunPowerShellCommand(procCmd)
-				if psErr != nil {
-					logger.Warnf("Failed to update processor count with PowerShell fallback: %v", psErr)
-					// Continue with other updates despite error
-				} else {
-					logger.Infof("Updated processor count to %d using PowerShell fallback", *news.ProcessorCount)
-				}
-			} else {
-				logger.Infof("Updated processor count to %d using WMI", *news.ProcessorCount)
-			}
+	for _, hd := range addedHardDrives(olds.HardDrives, news.HardDrives) {
+		if err := attachHardDrive(ctx, b, vmName, hd); err != nil {
+			return state, err
 		}
 	}
-
-	// Update memory settings if changed
-	memoryChanged := false
-	if news.MemorySize != nil && (olds.MemorySize == nil || *olds.MemorySize != *news.MemorySize) {
-		memoryChanged = true
+	for _, change := range changedHardDriveSettings(olds.HardDrives, news.HardDrives) {
+		if err := b.SetDiskDrive(ctx, vmName, *change.drive.Path, *change.settings); err != nil {
+			return state, fmt.Errorf("failed to update the settings of hard drive %s: %w", *change.drive.Path, err)
+		}
+		logger.Infof("Updated the settings of hard drive %s of VM %s", *change.drive.Path, vmName)
 	}
 
-	dynamicMemoryChanged := false
-	if (news.DynamicMemory != nil && olds.DynamicMemory == nil) ||
-		(news.DynamicMemory != nil && olds.DynamicMemory != nil && *news.DynamicMemory != *olds.DynamicMemory) {
-		dynamicMemoryChanged = true
+	if !comparePassThroughDisks(olds.PassThroughDisks, news.PassThroughDisks) {
+		if err := updatePassThroughDisks(ctx, b, vmName, olds.PassThroughDisks, news.PassThroughDisks); err != nil {
+			return state, err
+		}
 	}
 
-	minMemoryChanged := false
-	if news.MinimumMemory != nil && (olds.MinimumMemory == nil || *olds.MinimumMemory != *news.MinimumMemory) {
-		minMemoryChanged = true
+	if newSCSI < oldSCSI {
+		if err := b.SetSCSIControllerCount(ctx, vmName, newSCSI); err != nil {
+			return state, fmt.Errorf("failed to set the SCSI controller count of VM %s to %d: %w", vmName, newSCSI, err)
+		}
+		logger.Infof("Set the SCSI controller count of VM %s to %d", vmName, newSCSI)
 	}
 
-	maxMemoryChanged := false
-	if news.MaximumMemory != nil && (olds.MaximumMemory == nil || *olds.MaximumMemory != *news.MaximumMemory) {
-		maxMemoryChanged = true
+	if err := updateNetworkAdapters(ctx, b, vmName, olds.NetworkAdapters, news.NetworkAdapters); err != nil {
+		return state, err
 	}
 
-	if memoryChanged || dynamicMemoryChanged || minMemoryChanged || maxMemoryChanged {
-		logger.Infof("Updating memory settings for VM %s", vmName)
-
-		// Try WMI first
-		memorySettings, err := memory.GetDefaultMemorySettingData(vmmsClient.GetVirtualizationConn().WMIHost)
-		if err != nil || memorySettings == nil {
-			logger.Warnf("Failed to get memory settings: %v", err)
-			// Fallback to PowerShell for all memory settings
-			updateMemoryWithPowerShell(ctx, vmName, news)
-		} else {
-			defer memorySettings.Close()
-
-			// Update memory size if changed
-			if memoryChanged {
-				err = memorySettings.SetSizeMB(uint64(*news.MemorySize))
-				if err != nil {
-					logger.Warnf("Failed to set memory size: %v", err)
-					// Will fall back to PowerShell below
-				} else {
-					logger.Infof("Updated memory size to %d MB using WMI", *news.MemorySize)
-				}
-			}
-
-			// Update dynamic memory settings if changed
-			if dynamicMemoryChanged {
-				err = memorySettings.SetPropertyDynamicMemoryEnabled(*news.DynamicMemory)
-				if err != nil {
-					logger.Warnf("Failed to set dynamic memory enabled: %v", err)
-					// Will fall back to PowerShell below
-				} else {
-					logger.Infof("Updated dynamic memory to %v using WMI", *news.DynamicMemory)
-				}
-			}
-
-			// Update minimum memory if changed
-			if minMemoryChanged && news.MinimumMemory != nil {
-				err = memorySettings.SetProperty("MinimumBytes", uint64(*news.MinimumMemory)*1024*1024) // Convert MB to bytes
-				if err != nil {
-					logger.Warnf("Failed to set minimum memory: %v", err)
-					// Will fall back to PowerShell below
-				} else {
-					logger.Infof("Updated minimum memory to %d MB using WMI", *news.MinimumMemory)
-				}
-			}
-
-			// Update maximum memory if changed
-			if maxMemoryChanged && news.MaximumMemory != nil {
-				err = memorySettings.SetProperty("MaximumBytes", uint64(*news.MaximumMemory)*1024*1024) // Convert MB to bytes
-				if err != nil {
-					logger.Warnf("Failed to set maximum memory: %v", err)
-					// Will fall back to PowerShell below
-				} else {
-					logger.Infof("Updated maximum memory to %d MB using WMI", *news.MaximumMemory)
-				}
-			}
+	return state, nil
+}
 
-			// If any memory update failed, use PowerShell as fallback
-			if err != nil {
-				logger.Infof("Using PowerShell fallback for memory settings")
-				updateMemoryWithPowerShell(ctx, vmName, news)
-			}
-		}
+// stopReason returns why the VM must be off to go from olds to news, or "" when the changes
+// can be applied to a running VM.
+func stopReason(olds MachineInputs, news MachineInputs, generation int) string {
+	oldSCSI, newSCSI := scsiControllerChange(olds, news, generation)
+	settings := vmSettings(olds, news)
+	switch {
+	case settings.ProcessorCount != nil, settings.MemoryMB != nil, settings.MinimumMemoryMB != nil, settings.MaximumMemoryMB != nil,
+		settings.DynamicMemory != nil && (olds.DynamicMemory != nil || *settings.DynamicMemory):
+		return "processor, memory, or dynamic memory settings are changing"
+	case len(olds.NetworkAdapters) != len(news.NetworkAdapters) || len(olds.HardDrives) != len(news.HardDrives):
+		return "network adapters or hard drives are changing"
+	case oldSCSI != newSCSI:
+		return "the SCSI controller count is changing"
+	default:
+		return ""
 	}
+}
 
-	// Update auto start action if changed
-	if news.AutoStartAction != nil && (olds.AutoStartAction == nil || *olds.AutoStartAction != *news.AutoStartAction) {
-		logger.Infof("Updating auto start action from %v to %s", olds.AutoStartAction, *news.AutoStartAction)
+// hardDriveKey identifies a hard drive by its path, which Hyper-V compares
+// case-insensitively, and its slot, so a drive that moves to another slot is detached and
+// attached again.
+func hardDriveKey(hd *HardDriveInput) string {
+	controllerType, number, location := backend.ControllerSCSI, 0, "auto"
+	if hd.ControllerType != nil {
+		controllerType = *hd.ControllerType
+	}
+	if hd.ControllerNumber != nil {
+		number = *hd.ControllerNumber
+	}
+	if hd.ControllerLocation != nil {
+		location = strconv.Itoa(*hd.ControllerLocation)
+	}
+	return strings.ToLower(fmt.Sprintf("%s|%s|%d|%s", *hd.Path, controllerType, number, location))
+}
 
-		// Try WMI first
-		var autoStartValue uint16
-		switch *news.AutoStartAction {
-		case "Nothing":
-			autoStartValue = 0
-		case "StartIfRunning":
-			autoStartValue = 1
-		case "Start":
-			autoStartValue = 2
-		default:
-			logger.Errorf("Invalid auto start action: %s, setting Nothing", *news.AutoStartAction)
-			autoStartValue = 0
+// removedHardDrives returns the hard drives of olds whose path or slot is not in news.
+func removedHardDrives(olds []*HardDriveInput, news []*HardDriveInput) []*HardDriveInput {
+	keep := make(map[string]bool)
+	for _, hd := range news {
+		if hd != nil && hd.Path != nil {
+			keep[hardDriveKey(hd)] = true
 		}
-
-		err = vmSettings.SetProperty("AutoStartAction", autoStartValue)
-		if err != nil {
-			logger.Warnf("Failed to set auto start action: %v", err)
-			// Fallback to PowerShell
-			autoStartCmd := fmt.Sprintf("Set-VM -VMName \"%s\" -AutomaticStartAction %s", vmName, *news.AutoStartAction)
-			_, psErr := util.RunPowerShellCommand(autoStartCmd)
-			if psErr != nil {
-				logger.Warnf("Failed to update auto start action: %v", psErr)
-				// Continue with other updates despite error
-			} else {
-				logger.Infof("Updated auto start action to %s using PowerShell", *news.AutoStartAction)
-			}
-		} else {
-			logger.Infof("Updated auto start action to %s using WMI", *news.AutoStartAction)
+	}
+	var removed []*HardDriveInput
+	for _, hd := range olds {
+		if hd != nil && hd.Path != nil && !keep[hardDriveKey(hd)] {
+			removed = append(removed, hd)
 		}
 	}
+	return removed
+}
 
-	// Update auto stop action if changed
-	if news.AutoStopAction != nil && (olds.AutoStopAction == nil || *olds.AutoStopAction != *news.AutoStopAction) {
-		logger.Infof("Updating auto stop action from %v to %s", olds.AutoStopAction, *news.AutoStopAction)
+// addedHardDrives returns the hard drives of news whose path or slot is not in olds.
+func addedHardDrives(olds []*HardDriveInput, news []*HardDriveInput) []*HardDriveInput {
+	return removedHardDrives(news, olds)
+}
 
-		// Try WMI first
-		var autoStopValue uint16
-		switch *news.AutoStopAction {
-		case "TurnOff":
-			autoStopValue = 0
-		case "Save":
-			autoStopValue = 1
-		case "ShutDown":
-			autoStopValue = 2
-		default:
-			logger.Errorf("Invalid auto stop action: %s, setting TurnOff", *news.AutoStopAction)
-			autoStopValue = 0
+// hardDiskSettings maps the storage QoS and attachment inputs of a hard drive to the
+// settings of its Msvm_StorageAllocationSettingData.
+func hardDiskSettings(hd *HardDriveInput) (*backend.DiskDriveSettings, error) {
+	settings := &backend.DiskDriveSettings{
+		QosPolicyID:                   hd.QosPolicyId,
+		SupportPersistentReservations: hd.SupportPersistentReservations,
+		ReadOnly:                      hd.ReadOnly,
+		CacheMode:                     hd.CacheMode,
+	}
+	if hd.MinimumIops != nil {
+		if *hd.MinimumIops < 0 {
+			return nil, fmt.Errorf("minimumIops cannot be negative")
 		}
-
-		err = vmSettings.SetProperty("AutoStopAction", autoStopValue)
-		if err != nil {
-			logger.Warnf("Failed to set auto stop action: %v", err)
-			// Fallback to PowerShell
-			autoStopCmd := fmt.Sprintf("Set-VM -VMName \"%s\" -AutomaticStopAction %s", vmName, *news.AutoStopAction)
-			_, psErr := util.RunPowerShellCommand(autoStopCmd)
-			if psErr != nil {
-				logger.Warnf("Failed to update auto stop action: %v", psErr)
-				// Continue with other updates despite error
-			} else {
-				logger.Infof("Updated auto stop action to %s using PowerShell", *news.AutoStopAction)
-			}
-		} else {
-			logger.Infof("Updated auto stop action to %s using WMI", *news.AutoStopAction)
+		iops := uint64(*hd.MinimumIops)
+		settings.MinimumIops = &iops
+	}
+	if hd.MaximumIops != nil {
+		if *hd.MaximumIops < 0 {
+			return nil, fmt.Errorf("maximumIops cannot be negative")
 		}
+		iops := uint64(*hd.MaximumIops)
+		settings.MaximumIops = &iops
 	}
+	return settings, settings.Validate()
+}
 
-	// Update hard drives if changed
-	if !compareHardDrives(olds.HardDrives, news.HardDrives) {
-		logger.Infof("Updating hard drives for VM %s", vmName)
-
-		// First remove all existing hard drives using PowerShell (more reliable)
-		removeHDCmd := fmt.Sprintf("Get-VMHardDiskDrive -VMName \"%s\" | Remove-VMHardDiskDrive", vmName)
-		_, removeErr := util.RunPowerShellCommand(removeHDCmd)
-		if removeErr != nil {
-			logger.Warnf("Failed to remove existing hard drives: %v", removeErr)
-			// Try to continue anyway
+// validateHardDrives checks the settings of every hard drive before anything is changed.
+func validateHardDrives(drives []*HardDriveInput) error {
+	for _, hd := range drives {
+		if hd == nil {
+			continue
 		}
-
-		// Add all new hard drives
-		for i, hd := range news.HardDrives {
-			if hd.Path == nil {
-				logger.Debugf("Hard drive path not specified, skipping")
-				continue
-			}
-
-			// Default values for controller
-			controllerType := "SCSI"
-			if hd.ControllerType != nil {
-				controllerType = *hd.ControllerType
-			}
-
-			controllerNumber := 0
-			if hd.ControllerNumber != nil {
-				controllerNumber = *hd.ControllerNumber
-			}
-
-			// Default to sequential ports
-			controllerLocation := i
-			if hd.ControllerLocation != nil {
-				controllerLocation = *hd.ControllerLocation
-			}
-
-			// Use PowerShell to add hard drive (more reliable)
-			hdCmd := fmt.Sprintf("Add-VMHardDiskDrive -VMName \"%s\" -Path \"%s\" -ControllerType %s -ControllerNumber %d -ControllerLocation %d",
-				vmName, *hd.Path, controllerType, controllerNumber, controllerLocation)
-			_, err := util.RunPowerShellCommand(hdCmd)
-			if err != nil {
-				logger.Warnf("Failed to add hard drive: %v", err)
-				// Continue with other hard drives despite error
-			} else {
-				logger.Debugf("Added hard drive %s to VM %s", *hd.Path, vmName)
+		if _, err := hardDiskSettings(hd); err != nil {
+			if hd.Path != nil {
+				return fmt.Errorf("invalid settings for hard drive %s: %w", *hd.Path, err)
 			}
+			return fmt.Errorf("invalid hard drive settings: %w", err)
 		}
 	}
+	return nil
+}
 
-	// Update network adapters if changed
-	if !compareNetworkAdapters(olds.NetworkAdapters, news.NetworkAdapters) {
-		logger.Infof("Updating network adapters for VM %s", vmName)
-
-		// First remove all existing network adapters using PowerShell (more reliable)
-		removeNACmd := fmt.Sprintf("Get-VMNetworkAdapter -VMName \"%s\" | Remove-VMNetworkAdapter", vmName)
-		_, removeErr := util.RunPowerShellCommand(removeNACmd)
-		if removeErr != nil {
-			logger.Warnf("Failed to remove existing network adapters: %v", removeErr)
-			// Try to continue anyway
+// checkHardDrives refuses hard drive settings that the backend cannot apply, before anything
+// is changed.
+func checkHardDrives(b backend.HypervBackend, drives []*HardDriveInput) error {
+	for _, hd := range drives {
+		if hd == nil || hd.Path == nil {
+			continue
 		}
-
-		// Add all new network adapters
-		for i, na := range news.NetworkAdapters {
-			if na.SwitchName == nil {
-				logger.Debugf("Network adapter switch name not specified, skipping")
-				continue
-			}
-
-			// Use the index as part of name if no name provided
-			adapterName := fmt.Sprintf("Network Adapter %d", i+1)
-			if na.Name != nil {
-				adapterName = *na.Name
-			}
-
-			// Create the adapter and connect it to the switch
-			naCmd := fmt.Sprintf("Add-VMNetworkAdapter -VMName \"%s\" -Name \"%s\" -SwitchName \"%s\"",
-				vmName, adapterName, *na.SwitchName)
-
-			// Add MAC address if specified
-			if na.MacAddress != nil && *na.MacAddress != "" {
-				naCmd += fmt.Sprintf(" -StaticMacAddress \"%s\"", *na.MacAddress)
-			}
-
-			_, err := util.RunPowerShellCommand(naCmd)
-			if err != nil {
-				logger.Warnf("Failed to add network adapter: %v", err)
-				// Continue with other adapters despite error
-			} else {
-				logger.Debugf("Added network adapter %s to VM %s", adapterName, vmName)
-			}
+		settings, err := hardDiskSettings(hd)
+		if err != nil {
+			return err
 		}
-	}
-
-	// Start the VM if we stopped it
-	if needsRestart {
-		logger.Infof("Restarting VM %s after update", vmName)
-		startErr := startVMPowerShell(vmName)
-		if startErr != nil {
-			logger.Warnf("Failed to restart VM %s after update: %v", vmName, startErr)
-			// We'll warn but not fail the update since the changes were applied
+		if err := backend.CheckDiskDriveSettings(b, *hd.Path, settings); err != nil {
+			return err
 		}
 	}
-
-	return state, nil
+	return nil
 }
 
-// Helper functions for the Update method
-
-// updateVMWithPowerShell updates a virtual machine using PowerShell cmdlets
-func updateVMWithPowerShell(ctx context.Context, vmName string, olds MachineOutputs, news MachineInputs) (MachineOutputs, error) {
-	logger := logging.GetLogger(ctx)
-	state := MachineOutputs{MachineInputs: news}
-
-	// Always ensure vmId is set - carry over from old state if available
-	if olds.VmId != nil {
-		state.VmId = olds.VmId
-	} else {
-		EnsureVmId(&state, vmName)
-	}
+// hardDriveSettingsChange is an in-place change of the settings of an attached hard drive.
+type hardDriveSettingsChange struct {
+	drive    *HardDriveInput
+	settings *backend.DiskDriveSettings
+}
 
-	// Update processor count if changed
-	if news.ProcessorCount != nil && (olds.ProcessorCount == nil || *olds.ProcessorCount != *news.ProcessorCount) {
-		logger.Infof("Updating processor count from %v to %d", olds.ProcessorCount, *news.ProcessorCount)
-		procCmd := fmt.Sprintf("Set-VMProcessor -VMName \"%s\" -Count %d", vmName, *news.ProcessorCount)
-		_, err := util.RunPowerShellCommand(procCmd)
-		if err != nil {
-			logger.Warnf("Failed to update processor count: %v", err)
-			// Continue with other updates despite error
+// changedHardDriveSettings returns the hard drives whose storage settings differ from the
+// drive with the same path and slot in olds. Settings that are no longer specified are reset to
+// the Hyper-V defaults.
+func changedHardDriveSettings(olds []*HardDriveInput, news []*HardDriveInput) []hardDriveSettingsChange {
+	oldByKey := make(map[string]*HardDriveInput)
+	for _, hd := range olds {
+		if hd != nil && hd.Path != nil {
+			oldByKey[hardDriveKey(hd)] = hd
 		}
 	}
 
-	// Update memory settings
-	updateMemoryWithPowerShell(ctx, vmName, news)
-
-	// Update auto start action if changed
-	if news.AutoStartAction != nil && (olds.AutoStartAction == nil || *olds.AutoStartAction != *news.AutoStartAction) {
-		logger.Infof("Updating auto start action from %v to %s", olds.AutoStartAction, *news.AutoStartAction)
-		autoStartCmd := fmt.Sprintf("Set-VM -VMName \"%s\" -AutomaticStartAction %s", vmName, *news.AutoStartAction)
-		_, err := util.RunPowerShellCommand(autoStartCmd)
-		if err != nil {
-			logger.Warnf("Failed to update auto start action: %v", err)
-			// Continue with other updates despite error
+	var changes []hardDriveSettingsChange
+	for _, hd := range news {
+		if hd == nil || hd.Path == nil {
+			continue
 		}
-	}
-
-	// Update auto stop action if changed
-	if news.AutoStopAction != nil && (olds.AutoStopAction == nil || *olds.AutoStopAction != *news.AutoStopAction) {
-		logger.Infof("Updating auto stop action from %v to %s", olds.AutoStopAction, *news.AutoStopAction)
-		autoStopCmd := fmt.Sprintf("Set-VM -VMName \"%s\" -AutomaticStopAction %s", vmName, *news.AutoStopAction)
-		_, err := util.RunPowerShellCommand(autoStopCmd)
-		if err != nil {
-			logger.Warnf("Failed to update auto stop action: %v", err)
-			// Continue with other updates despite error
+		old, ok := oldByKey[hardDriveKey(hd)]
+		if !ok {
+			continue
 		}
-	}
-
-	// Update hard drives if changed
-	if !compareHardDrives(olds.HardDrives, news.HardDrives) {
-		logger.Infof("Updating hard drives for VM %s", vmName)
-
-		// First remove all existing hard drives
-		removeHDCmd := fmt.Sprintf("Get-VMHardDiskDrive -VMName \"%s\" | Remove-VMHardDiskDrive", vmName)
-		_, removeErr := util.RunPowerShellCommand(removeHDCmd)
-		if removeErr != nil {
-			logger.Warnf("Failed to remove existing hard drives: %v", removeErr)
-			// Try to continue anyway
+		next := *hd
+		zero, empty, off, cacheDefault := 0, "", false, backend.CacheModeDefault
+		if next.MinimumIops == nil && old.MinimumIops != nil {
+			next.MinimumIops = &zero
 		}
-
-		// Add all new hard drives
-		for i, hd := range news.HardDrives {
-			if hd.Path == nil {
-				logger.Debugf("Hard drive path not specified, skipping")
-				continue
-			}
-
-			// Default values for controller
-			controllerType := "SCSI"
-			if hd.ControllerType != nil {
-				controllerType = *hd.ControllerType
-			}
-
-			controllerNumber := 0
-			if hd.ControllerNumber != nil {
-				controllerNumber = *hd.ControllerNumber
-			}
-
-			// Default to sequential ports
-			controllerLocation := i
-			if hd.ControllerLocation != nil {
-				controllerLocation = *hd.ControllerLocation
-			}
-
-			hdCmd := fmt.Sprintf("Add-VMHardDiskDrive -VMName \"%s\" -Path \"%s\" -ControllerType %s -ControllerNumber %d -ControllerLocation %d",
-				vmName, *hd.Path, controllerType, controllerNumber, controllerLocation)
-			_, err := util.RunPowerShellCommand(hdCmd)
-			if err != nil {
-				logger.Warnf("Failed to add hard drive: %v", err)
-				// Continue with other hard drives despite error
-			} else {
-				logger.Debugf("Added hard drive %s to VM %s", *hd.Path, vmName)
-			}
+		if next.MaximumIops == nil && old.MaximumIops != nil {
+			next.MaximumIops = &zero
 		}
-	}
-
-	// Update network adapters if changed
-	if !compareNetworkAdapters(olds.NetworkAdapters, news.NetworkAdapters) {
-		logger.Infof("Updating network adapters for VM %s", vmName)
-
-		// First remove all existing network adapters
-		removeNACmd := fmt.Sprintf("Get-VMNetworkAdapter -VMName \"%s\" | Remove-VMNetworkAdapter", vmName)
-		_, removeErr := util.RunPowerShellCommand(removeNACmd)
-		if removeErr != nil {
-			logger.Warnf("Failed to remove existing network adapters: %v", removeErr)
-			// Try to continue anyway
+		if next.QosPolicyId == nil && old.QosPolicyId != nil {
+			next.QosPolicyId = &empty
 		}
-
-		// Add all new network adapters
-		for i, na := range news.NetworkAdapters {
-			if na.SwitchName == nil {
-				logger.Debugf("Network adapter switch name not specified, skipping")
-				continue
-			}
-
-			// Use the index as part of name if no name provided
-			adapterName := fmt.Sprintf("Network Adapter %d", i+1)
-			if na.Name != nil {
-				adapterName = *na.Name
-			}
-
-			// Create the adapter and connect it to the switch
-			naCmd := fmt.Sprintf("Add-VMNetworkAdapter -VMName \"%s\" -Name \"%s\" -SwitchName \"%s\"",
-				vmName, adapterName, *na.SwitchName)
-
-			// Add MAC address if specified
-			if na.MacAddress != nil && *na.MacAddress != "" {
-				naCmd += fmt.Sprintf(" -StaticMacAddress \"%s\"", *na.MacAddress)
-			}
-
-			_, err := util.RunPowerShellCommand(naCmd)
-			if err != nil {
-				logger.Warnf("Failed to add network adapter: %v", err)
-				// Continue with other adapters despite error
-			} else {
-				logger.Debugf("Added network adapter %s to VM %s", adapterName, vmName)
+		if next.SupportPersistentReservations == nil && old.SupportPersistentReservations != nil {
+			next.SupportPersistentReservations = &off
+		}
+		if next.ReadOnly == nil && old.ReadOnly != nil {
+			next.ReadOnly = &off
+		}
+		if next.CacheMode == nil && old.CacheMode != nil {
+			next.CacheMode = &cacheDefault
+		}
+		if !intPtrEqual(old.MinimumIops, next.MinimumIops) || !intPtrEqual(old.MaximumIops, next.MaximumIops) ||
+			!stringPtrEqual(old.QosPolicyId, next.QosPolicyId) || !boolPtrEqual(old.SupportPersistentReservations, next.SupportPersistentReservations) ||
+			!boolPtrEqual(old.ReadOnly, next.ReadOnly) || !stringPtrEqual(old.CacheMode, next.CacheMode) {
+			settings, err := hardDiskSettings(&next)
+			if err == nil {
+				changes = append(changes, hardDriveSettingsChange{drive: hd, settings: settings})
 			}
 		}
 	}
-
-	return state, nil
+	return changes
 }
 
-// updateMemoryWithPowerShell updates memory settings using PowerShell
-func updateMemoryWithPowerShell(ctx context.Context, vmName string, news MachineInputs) {
-	logger := logging.GetLogger(ctx)
-
-	// Set dynamic memory if specified
-	if news.DynamicMemory != nil {
-		var memCmd string
-
-		if *news.DynamicMemory {
-			// Enable dynamic memory
-			var minMem, maxMem int64
-
-			if news.MinimumMemory != nil {
-				minMem = int64(*news.MinimumMemory) * 1024 * 1024 // Convert MB to bytes
-			} else {
-				minMem = 512 * 1024 * 1024 // 512MB default
-			}
+func intPtrEqual(a, b *int) bool {
+	return (a == nil && b == nil) || (a != nil && b != nil && *a == *b)
+}
 
-			if news.MaximumMemory != nil {
-				maxMem = int64(*news.MaximumMemory) * 1024 * 1024 // Convert MB to bytes
-			} else if news.MemorySize != nil {
-				maxMem = int64(*news.MemorySize) * 2 * 1024 * 1024 // Double startup memory if maximum not specified
-			} else {
-				maxMem = 2 * 1024 * 1024 * 1024 // 2GB default
-			}
+func boolPtrEqual(a, b *bool) bool {
+	return (a == nil && b == nil) || (a != nil && b != nil && *a == *b)
+}
 
-			var startupMem int64
-			if news.MemorySize != nil {
-				startupMem = int64(*news.MemorySize) * 1024 * 1024 // Convert MB to bytes
-			} else {
-				startupMem = 1024 * 1024 * 1024 // 1GB default
-			}
+func stringPtrEqual(a, b *string) bool {
+	return (a == nil && b == nil) || (a != nil && b != nil && *a == *b)
+}
 
-			memCmd = fmt.Sprintf("Set-VMMemory -VMName \"%s\" -DynamicMemoryEnabled $true -MinimumBytes %d -MaximumBytes %d -StartupBytes %d",
-				vmName, minMem, maxMem, startupMem)
-		} else {
-			// Disable dynamic memory and set static memory
-			var memorySize int64
-			if news.MemorySize != nil {
-				memorySize = int64(*news.MemorySize) * 1024 * 1024 // Convert MB to bytes
-			} else {
-				memorySize = 1024 * 1024 * 1024 // 1GB default
-			}
+// passThroughSpec converts a pass-through disk input to the spec the passthrough package
+// resolves.
+func passThroughSpec(disk *PassThroughDiskInput) passthrough.Spec {
+	spec := passthrough.Spec{
+		DiskNumber:         disk.DiskNumber,
+		UniqueID:           disk.UniqueId,
+		ControllerLocation: disk.ControllerLocation,
+	}
+	if disk.ControllerType != nil {
+		spec.ControllerType = *disk.ControllerType
+	}
+	if disk.ControllerNumber != nil {
+		spec.ControllerNumber = *disk.ControllerNumber
+	}
+	return spec
+}
 
-			memCmd = fmt.Sprintf("Set-VMMemory -VMName \"%s\" -DynamicMemoryEnabled $false -StartupBytes %d",
-				vmName, memorySize)
+// validatePassThroughDisks checks every pass-through disk before anything is changed. Whether
+// the disks exist and are offline is only known on the host, so that is checked on attach.
+func validatePassThroughDisks(disks []*PassThroughDiskInput) error {
+	seen := make(map[string]bool)
+	for _, disk := range disks {
+		if disk == nil {
+			continue
 		}
-
-		_, err := util.RunPowerShellCommand(memCmd)
-		if err != nil {
-			logger.Warnf("Failed to update memory settings: %v", err)
-			// Continue despite error
-		} else {
-			logger.Infof("Updated memory settings for VM %s", vmName)
+		spec := passThroughSpec(disk)
+		if err := spec.Validate(); err != nil {
+			return err
 		}
-	} else if news.MemorySize != nil {
-		// Just update memory size without changing dynamic memory setting
-		memorySize := int64(*news.MemorySize) * 1024 * 1024 // Convert MB to bytes
-		memCmd := fmt.Sprintf("Set-VMMemory -VMName \"%s\" -StartupBytes %d", vmName, memorySize)
-
-		_, err := util.RunPowerShellCommand(memCmd)
-		if err != nil {
-			logger.Warnf("Failed to update memory size: %v", err)
-			// Continue despite error
-		} else {
-			logger.Infof("Updated memory size to %d MB for VM %s", *news.MemorySize, vmName)
+		if seen[spec.String()] {
+			return fmt.Errorf("%s is listed more than once in passThroughDisks", spec)
 		}
+		seen[spec.String()] = true
 	}
+	return nil
 }
 
-// compareHardDrives compares two slices of hard drives to see if they're different
-func compareHardDrives(olds []*HardDriveInput, news []*HardDriveInput) bool {
-	if len(olds) != len(news) {
-		return false
-	}
-
-	// Create maps for comparison
-	oldMap := make(map[string]bool)
-	newMap := make(map[string]bool)
-
-	for _, hd := range olds {
-		if hd.Path != nil {
-			oldMap[*hd.Path] = true
+// validateControllers checks that every disk fits on the controllers of the VM and that no
+// two disks ask for the same slot. Slots that are left to Hyper-V are not checked.
+func validateControllers(inputs MachineInputs) error {
+	scsiControllers := backend.MaxSCSIControllers
+	if inputs.ScsiControllerCount != nil {
+		if *inputs.ScsiControllerCount < 0 || *inputs.ScsiControllerCount > backend.MaxSCSIControllers {
+			return fmt.Errorf("scsiControllerCount must be between 0 and %d", backend.MaxSCSIControllers)
 		}
+		scsiControllers = *inputs.ScsiControllerCount
+	}
+	generation := 2
+	if inputs.Generation != nil {
+		generation = *inputs.Generation
 	}
 
-	for _, hd := range news {
-		if hd.Path != nil {
-			newMap[*hd.Path] = true
-			// Check if this path exists in old hard drives
-			if !oldMap[*hd.Path] {
-				return false
+	used := make(map[string]string)
+	check := func(name string, controllerType *string, controllerNumber *int, controllerLocation *int) error {
+		ctype := "SCSI"
+		if controllerType != nil {
+			ctype = strings.ToUpper(*controllerType)
+		}
+		number := 0
+		if controllerNumber != nil {
+			number = *controllerNumber
+		}
+		controllers, locations := scsiControllers, 64
+		switch ctype {
+		case "SCSI":
+		case "IDE":
+			if generation != 1 {
+				return fmt.Errorf("%s uses an IDE controller, which generation %d VMs do not have", name, generation)
+			}
+			controllers, locations = 2, 2
+		default:
+			return fmt.Errorf("%s uses unsupported controller type [%s], must be SCSI or IDE", name, ctype)
+		}
+		if number < 0 || number >= controllers {
+			if ctype == "SCSI" && inputs.ScsiControllerCount != nil {
+				return fmt.Errorf("%s uses SCSI controller %d, but scsiControllerCount is %d", name, number, scsiControllers)
 			}
+			return fmt.Errorf("%s uses %s controller %d, which must be between 0 and %d", name, ctype, number, controllers-1)
+		}
+		if controllerLocation == nil {
+			return nil
+		}
+		if *controllerLocation < 0 || *controllerLocation >= locations {
+			return fmt.Errorf("%s uses location %d, which must be between 0 and %d for %s controllers", name, *controllerLocation, locations-1, ctype)
+		}
+		slot := fmt.Sprintf("%s %d location %d", ctype, number, *controllerLocation)
+		if other, ok := used[slot]; ok {
+			return fmt.Errorf("%s and %s both use %s controller %d location %d", other, name, ctype, number, *controllerLocation)
 		}
+		used[slot] = name
+		return nil
 	}
 
-	// Check if all old paths are in new hard drives
-	for _, hd := range olds {
+	for i, hd := range inputs.HardDrives {
+		if hd == nil {
+			continue
+		}
+		name := fmt.Sprintf("hard drive %d", i)
 		if hd.Path != nil {
-			if !newMap[*hd.Path] {
-				return false
-			}
+			name = fmt.Sprintf("hard drive %s", *hd.Path)
+		}
+		if err := check(name, hd.ControllerType, hd.ControllerNumber, hd.ControllerLocation); err != nil {
+			return err
+		}
+	}
+	for _, disk := range inputs.PassThroughDisks {
+		if disk == nil {
+			continue
+		}
+		if err := check(passThroughSpec(disk).String(), disk.ControllerType, disk.ControllerNumber, disk.ControllerLocation); err != nil {
+			return err
 		}
 	}
+	return nil
+}
 
-	return true
+// passThroughDiskKey identifies a pass-through disk and its slot, so a disk that moves to
+// another slot is detached and attached again.
+func passThroughDiskKey(disk *PassThroughDiskInput) string {
+	spec := passThroughSpec(disk)
+	location := "auto"
+	if spec.ControllerLocation != nil {
+		location = strconv.Itoa(*spec.ControllerLocation)
+	}
+	return strings.ToLower(fmt.Sprintf("%s|%s|%d|%s", spec, spec.ControllerType, spec.ControllerNumber, location))
 }
 
-// compareNetworkAdapters compares two slices of network adapters to see if they're different
-func compareNetworkAdapters(olds []*networkadapter.NetworkAdapterInputs, news []*networkadapter.NetworkAdapterInputs) bool {
+// comparePassThroughDisks reports whether two lists of pass-through disks are the same.
+func comparePassThroughDisks(olds []*PassThroughDiskInput, news []*PassThroughDiskInput) bool {
 	if len(olds) != len(news) {
 		return false
 	}
-
-	// Create maps for comparison
-	oldMap := make(map[string]bool)
-	newMap := make(map[string]bool)
-
-	for _, na := range olds {
-		if na.SwitchName != nil {
-			key := *na.SwitchName
-			if na.Name != nil {
-				key = *na.Name + ":" + key
-			}
-			oldMap[key] = true
-		}
-	}
-
-	for _, na := range news {
-		if na.SwitchName != nil {
-			key := *na.SwitchName
-			if na.Name != nil {
-				key = *na.Name + ":" + key
-			}
-			newMap[key] = true
-			// Check if this combination exists in old adapters
-			if !oldMap[key] {
+	for i := range olds {
+		if olds[i] == nil || news[i] == nil {
+			if olds[i] != news[i] {
 				return false
 			}
+			continue
 		}
-	}
-
-	// Check if all old adapters are in new adapters
-	for _, na := range olds {
-		if na.SwitchName != nil {
-			key := *na.SwitchName
-			if na.Name != nil {
-				key = *na.Name + ":" + key
-			}
-			if !newMap[key] {
-				return false
-			}
+		if passThroughDiskKey(olds[i]) != passThroughDiskKey(news[i]) {
+			return false
 		}
 	}
-
 	return true
 }
 
-// isVMRunningPowerShell checks if a VM is running using PowerShell
-func isVMRunningPowerShell(vmName string) (bool, error) {
-	checkCmd := fmt.Sprintf("(Get-VM -Name \"%s\" -ErrorAction SilentlyContinue).State -eq 'Running'", vmName)
-	output, err := util.RunPowerShellCommand(checkCmd)
-	if err != nil {
-		return false, fmt.Errorf("failed to check VM state: %v", err)
+// removedPassThroughDisks returns the old pass-through disks that are not in news.
+func removedPassThroughDisks(olds []*PassThroughDiskInput, news []*PassThroughDiskInput) []*PassThroughDiskInput {
+	keep := make(map[string]bool)
+	for _, disk := range news {
+		if disk != nil {
+			keep[passThroughDiskKey(disk)] = true
+		}
 	}
-
-	return strings.TrimSpace(output) == "True", nil
+	var removed []*PassThroughDiskInput
+	for _, disk := range olds {
+		if disk != nil && !keep[passThroughDiskKey(disk)] {
+			removed = append(removed, disk)
+		}
+	}
+	return removed
 }
 
-// stopVMPowerShell stops a VM using PowerShell
-func stopVMPowerShell(vmName string) error {
-	// Check if the VM is running first
-	isRunning, err := isVMRunningPowerShell(vmName)
-	if err != nil {
-		return fmt.Errorf("failed to check if VM is running: %v", err)
+// updatePassThroughDisks detaches the disks that were removed and attaches the new ones.
+// Disks that are already attached are left as they are.
+func updatePassThroughDisks(ctx context.Context, b backend.HypervBackend, vmName string, olds []*PassThroughDiskInput, news []*PassThroughDiskInput) error {
+	logger := logging.GetLogger(ctx)
+	for _, disk := range removedPassThroughDisks(olds, news) {
+		if err := b.DetachPassThroughDisk(ctx, vmName, passThroughSpec(disk)); err != nil {
+			return fmt.Errorf("failed to detach %s from VM %s: %w", passThroughSpec(disk), vmName, err)
+		}
+		logger.Infof("Detached %s from VM %s", passThroughSpec(disk), vmName)
 	}
-
-	if !isRunning {
-		return nil // VM is already stopped
+	for _, disk := range news {
+		if disk == nil {
+			continue
+		}
+		attached, err := b.AttachPassThroughDisk(ctx, vmName, passThroughSpec(disk))
+		if err != nil {
+			return fmt.Errorf("failed to attach %s to VM %s: %w", passThroughSpec(disk), vmName, err)
+		}
+		logger.Debugf("Pass-through %s is attached to VM %s", attached.Name(), vmName)
 	}
+	return nil
+}
 
-	stopCmd := fmt.Sprintf("Stop-VM -Name \"%s\" -Force", vmName)
-	_, err = util.RunPowerShellCommand(stopCmd)
-	if err != nil {
-		return fmt.Errorf("failed to stop VM: %v", err)
+// adapterName returns the name of the i-th network adapter, which defaults to the name
+// Hyper-V gives it.
+func adapterName(i int, na *networkadapter.NetworkAdapterInputs) string {
+	if na.Name != nil {
+		return *na.Name
 	}
+	return fmt.Sprintf("Network Adapter %d", i+1)
+}
 
-	// Verify the VM is stopped
-	isStillRunning, verifyErr := isVMRunningPowerShell(vmName)
-	if verifyErr != nil {
-		return fmt.Errorf("failed to verify VM stopped state: %v", verifyErr)
+// networkAdapterKeys returns the network adapters that have a switch by name. Adapters
+// without a switch are not managed.
+func networkAdapterKeys(adapters []*networkadapter.NetworkAdapterInputs) map[string]*networkadapter.NetworkAdapterInputs {
+	keys := make(map[string]*networkadapter.NetworkAdapterInputs)
+	for i, na := range adapters {
+		if na != nil && na.SwitchName != nil {
+			keys[adapterName(i, na)] = na
+		}
 	}
+	return keys
+}
 
-	if isStillRunning {
-		return fmt.Errorf("VM did not stop after Stop-VM command")
+// updateNetworkAdapters removes the adapters that are no longer listed, adds the new ones,
+// and reconnects or readdresses the adapters whose switch or static MAC address changed.
+func updateNetworkAdapters(ctx context.Context, b backend.HypervBackend, vmName string, olds []*networkadapter.NetworkAdapterInputs, news []*networkadapter.NetworkAdapterInputs) error {
+	logger := logging.GetLogger(ctx)
+	oldAdapters, newAdapters := networkAdapterKeys(olds), networkAdapterKeys(news)
+	for name := range oldAdapters {
+		if _, ok := newAdapters[name]; ok {
+			continue
+		}
+		if err := b.RemoveNetworkAdapter(ctx, vmName, name); err != nil && !errors.Is(err, errs.ErrNotFound) {
+			return fmt.Errorf("failed to remove network adapter %s from VM %s: %w", name, vmName, err)
+		}
+		logger.Infof("Removed network adapter %s from VM %s", name, vmName)
+	}
+	for i, na := range news {
+		if na == nil || na.SwitchName == nil {
+			continue
+		}
+		name := adapterName(i, na)
+		old, ok := oldAdapters[name]
+		if !ok {
+			if err := addNetworkAdapter(ctx, b, vmName, name, na); err != nil {
+				return err
+			}
+			continue
+		}
+		if *old.SwitchName != *na.SwitchName {
+			if err := b.ConnectNetworkAdapter(ctx, vmName, name, *na.SwitchName); err != nil {
+				return fmt.Errorf("failed to connect network adapter %s of VM %s to switch %s: %w", name, vmName, *na.SwitchName, err)
+			}
+			logger.Infof("Connected network adapter %s of VM %s to switch %s", name, vmName, *na.SwitchName)
+		}
+		if na.MacAddress != nil && *na.MacAddress != "" && !stringPtrEqual(old.MacAddress, na.MacAddress) {
+			if err := b.SetNetworkAdapter(ctx, vmName, name, backend.NetworkAdapterSettings{MacAddress: na.MacAddress}); err != nil {
+				return fmt.Errorf("failed to set the MAC address of network adapter %s of VM %s: %w", name, vmName, err)
+			}
+		}
 	}
-
 	return nil
 }
 
-// startVMPowerShell starts a VM using PowerShell
-func startVMPowerShell(vmName string) error {
-	// Check if the VM is already running
-	isRunning, err := isVMRunningPowerShell(vmName)
-	if err != nil {
-		return fmt.Errorf("failed to check if VM is running: %v", err)
-	}
-
-	if isRunning {
-		return nil // VM is already running
+// addNetworkAdapter adds a network adapter connected to its switch, with its static MAC
+// address if it has one.
+func addNetworkAdapter(ctx context.Context, b backend.HypervBackend, vmName string, name string, na *networkadapter.NetworkAdapterInputs) error {
+	spec := backend.NetworkAdapter{Name: name, VMName: vmName, SwitchName: *na.SwitchName}
+	if na.MacAddress != nil {
+		spec.MacAddress = *na.MacAddress
 	}
-
-	startCmd := fmt.Sprintf("Start-VM -Name \"%s\"", vmName)
-	output, err := util.RunPowerShellCommand(startCmd)
-	if err != nil {
-		// Check for specific error conditions
-		if strings.Contains(output, "Not enough memory in the system to start the virtual machine") {
-			return fmt.Errorf("failed to start VM due to insufficient memory: %v", err)
-		} else if strings.Contains(output, "0x8007000E") {
-			return fmt.Errorf("failed to start VM due to insufficient system resources (error 0x8007000E)")
-		} else if strings.Contains(output, "could not initialize memory") {
-			return fmt.Errorf("failed to start VM due to memory initialization error")
-		}
-
-		return fmt.Errorf("failed to start VM: %v", err)
+	if _, err := b.AddNetworkAdapter(ctx, spec); err != nil {
+		return fmt.Errorf("failed to add network adapter %s to VM %s: %w", name, vmName, err)
 	}
-
+	logging.GetLogger(ctx).Infof("Added network adapter %s to VM %s, connected to switch %s", name, vmName, *na.SwitchName)
 	return nil
 }
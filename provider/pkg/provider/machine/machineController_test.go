@@ -13,3 +13,655 @@
 // limitations under the License.
 
 package machine
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/pulumi/pulumi-hyperv/provider/pkg/provider/backend"
+	"github.com/pulumi/pulumi-hyperv/provider/pkg/provider/errs"
+	"github.com/pulumi/pulumi-hyperv/provider/pkg/provider/networkadapter"
+	"github.com/pulumi/pulumi-hyperv/provider/pkg/provider/passthrough"
+	"github.com/pulumi/pulumi-hyperv/provider/pkg/provider/vhd"
+	"github.com/pulumi/pulumi/sdk/v3/go/common/resource"
+)
+
+func ptr[T any](v T) *T {
+	return &v
+}
+
+// simulate returns a context whose resource operations run against a Simulator with the
+// switches lan and wan, three disks and the offline host disk 3. The working directory is a
+// temporary one, because the logger writes a file to it.
+func simulate(t *testing.T) (context.Context, *backend.Simulator) {
+	t.Chdir(t.TempDir())
+	sim := backend.NewSimulator()
+	ctx := backend.WithBackend(context.Background(), sim)
+	for _, name := range []string{"lan", "wan"} {
+		if _, err := sim.CreateSwitch(ctx, backend.Switch{Name: name, SwitchType: backend.SwitchTypeInternal}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, path := range []string{`C:\vms\os.vhdx`, `C:\vms\data.vhdx`, `C:\vms\logs.vhdx`} {
+		if _, err := sim.CreateDisk(ctx, path, vhd.CreateOptions{VirtualSize: 1 << 30}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	sim.AddHostDisk(passthrough.Disk{Number: 3, UniqueID: "disk-3", Size: 1 << 40, IsOffline: true})
+	return ctx, sim
+}
+
+// diskPaths returns the paths of the virtual hard disks attached to vmName.
+func diskPaths(t *testing.T, ctx context.Context, sim *backend.Simulator, vmName string) []string {
+	t.Helper()
+	drives, err := sim.ListDiskDrives(ctx, vmName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var paths []string
+	for _, drive := range drives {
+		paths = append(paths, drive.Path)
+	}
+	return paths
+}
+
+func TestLifecycle(t *testing.T) {
+	ctx, sim := simulate(t)
+	c := &Machine{}
+
+	inputs := MachineInputs{
+		MachineName: ptr("web"), Generation: ptr(2), ProcessorCount: ptr(2), MemorySize: ptr(2048),
+		DynamicMemory: ptr(true), MinimumMemory: ptr(1024), MaximumMemory: ptr(4096),
+		AutoStartAction: ptr("Start"), AutoStopAction: ptr("ShutDown"),
+		HardDrives: []*HardDriveInput{
+			{Path: ptr(`C:\vms\os.vhdx`), ControllerType: ptr("SCSI"), ControllerNumber: ptr(0), ControllerLocation: ptr(0)},
+			{Path: ptr(`C:\vms\data.vhdx`), ControllerType: ptr("SCSI"), ControllerNumber: ptr(1), MaximumIops: ptr(500)},
+		},
+		PassThroughDisks: []*PassThroughDiskInput{{UniqueId: ptr("disk-3")}},
+		NetworkAdapters:  []*networkadapter.NetworkAdapterInputs{{Name: ptr("nic"), SwitchName: ptr("lan")}},
+	}
+	id, state, err := c.Create(ctx, "web", inputs, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id != "web" || *state.VmId != "web" {
+		t.Errorf("Create = %q, %+v", id, state)
+	}
+	vm, err := sim.GetVM(ctx, "web")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if vm.State != backend.PowerStateRunning || vm.ProcessorCount != 2 || vm.MemoryMB != 2048 || !vm.DynamicMemory ||
+		vm.MinimumMemoryMB != 1024 || vm.MaximumMemoryMB != 4096 || vm.AutomaticStartAction != "Start" || vm.AutomaticStopAction != "ShutDown" {
+		t.Errorf("created VM = %+v", vm)
+	}
+	// The data disk needs a second SCSI controller.
+	if count, _ := sim.SCSIControllerCount("web"); count != 2 {
+		t.Errorf("SCSI controllers = %d, want 2", count)
+	}
+	if settings, _ := sim.DiskDriveSettings("web", `C:\vms\data.vhdx`); settings == nil || settings.MaximumIops == nil || *settings.MaximumIops != 500 {
+		t.Errorf("data disk settings = %+v, want a maximum of 500 IOPS", settings)
+	}
+	if disks, _ := sim.PassThroughDisks("web"); !reflect.DeepEqual(disks, []uint32{3}) {
+		t.Errorf("pass-through disks = %v, want [3]", disks)
+	}
+	adapters, err := sim.ListNetworkAdapters(ctx, "web")
+	if err != nil || len(adapters) != 1 || adapters[0].Name != "nic" || adapters[0].SwitchName != "lan" {
+		t.Errorf("network adapters = %+v, %v", adapters, err)
+	}
+
+	readID, readInputs, readState, err := c.Read(ctx, id, inputs, state)
+	if err != nil || readID != id || *readInputs.ProcessorCount != 2 || *readInputs.MaximumMemory != 4096 || *readState.VmId != "web" {
+		t.Errorf("Read = %q, %+v, %+v, %v", readID, readInputs, readState, err)
+	}
+
+	// Changing the processors stops the VM, and it is started again afterwards.
+	news := inputs
+	news.ProcessorCount = ptr(4)
+	news.HardDrives = []*HardDriveInput{
+		{Path: ptr(`C:\vms\os.vhdx`), ControllerType: ptr("SCSI"), ControllerNumber: ptr(0), ControllerLocation: ptr(0), CacheMode: ptr(backend.CacheModeWriteCacheDisabled)},
+		{Path: ptr(`C:\vms\logs.vhdx`), ControllerType: ptr("SCSI"), ControllerNumber: ptr(0)},
+	}
+	news.PassThroughDisks = nil
+	news.ScsiControllerCount = ptr(1)
+	news.NetworkAdapters = []*networkadapter.NetworkAdapterInputs{
+		{Name: ptr("nic"), SwitchName: ptr("wan")},
+		{Name: ptr("backup"), SwitchName: ptr("lan"), MacAddress: ptr("00-15-5D-00-00-09")},
+	}
+	state, err = c.Update(ctx, id, state, news, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if vm, _ := sim.GetVM(ctx, "web"); vm.State != backend.PowerStateRunning || vm.ProcessorCount != 4 {
+		t.Errorf("updated VM = %+v", vm)
+	}
+	if paths := diskPaths(t, ctx, sim, "web"); !reflect.DeepEqual(paths, []string{`C:\vms\os.vhdx`, `C:\vms\logs.vhdx`}) {
+		t.Errorf("hard drives = %v", paths)
+	}
+	if settings, _ := sim.DiskDriveSettings("web", `C:\vms\os.vhdx`); settings == nil || settings.CacheMode == nil || *settings.CacheMode != backend.CacheModeWriteCacheDisabled {
+		t.Errorf("os disk settings = %+v, want the write cache disabled", settings)
+	}
+	if count, _ := sim.SCSIControllerCount("web"); count != 1 {
+		t.Errorf("SCSI controllers = %d, want 1", count)
+	}
+	if disks, _ := sim.PassThroughDisks("web"); len(disks) != 0 {
+		t.Errorf("pass-through disks = %v, want none", disks)
+	}
+	adapters, err = sim.ListNetworkAdapters(ctx, "web")
+	if err != nil || len(adapters) != 2 {
+		t.Fatalf("network adapters = %+v, %v", adapters, err)
+	}
+	for _, adapter := range adapters {
+		if (adapter.Name == "nic" && adapter.SwitchName != "wan") || (adapter.Name == "backup" && adapter.MacAddress != "00155D000009") {
+			t.Errorf("network adapter = %+v", adapter)
+		}
+	}
+
+	if err := c.Delete(ctx, id, state); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := sim.GetVM(ctx, "web"); !errors.Is(err, errs.ErrNotFound) {
+		t.Errorf("GetVM after Delete = %v, want ErrNotFound", err)
+	}
+	// The disks outlive the machine.
+	if _, err := sim.GetDisk(ctx, `C:\vms\data.vhdx`); err != nil {
+		t.Errorf("GetDisk after Delete = %v", err)
+	}
+	if err := c.Delete(ctx, id, state); err != nil {
+		t.Errorf("Delete of a deleted VM = %v", err)
+	}
+}
+
+func TestImport(t *testing.T) {
+	ctx, sim := simulate(t)
+	if _, err := sim.CreateVM(ctx, backend.VMSpec{Name: "web", Generation: 2, MemoryMB: 512}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := sim.AttachDisk(ctx, "web", backend.DiskDrive{Path: `C:\vms\os.vhdx`, ControllerType: backend.ControllerSCSI, ControllerLocation: -1}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := sim.AddNetworkAdapter(ctx, backend.NetworkAdapter{Name: "nic", VMName: "web", SwitchName: "lan"}); err != nil {
+		t.Fatal(err)
+	}
+
+	id, inputs, state, err := (&Machine{}).Read(ctx, "web", MachineInputs{}, MachineOutputs{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id != "web" || *inputs.MachineName != "web" || *inputs.MemorySize != 512 || *state.VmId != "web" {
+		t.Errorf("Read = %q, %+v, %+v", id, inputs, state)
+	}
+	if len(inputs.HardDrives) != 1 || *inputs.HardDrives[0].Path != `C:\vms\os.vhdx` {
+		t.Errorf("imported hard drives = %+v", inputs.HardDrives)
+	}
+	if len(inputs.NetworkAdapters) != 1 || *inputs.NetworkAdapters[0].SwitchName != "lan" || inputs.NetworkAdapters[0].MacAddress != nil {
+		t.Errorf("imported network adapters = %+v", inputs.NetworkAdapters)
+	}
+
+	if id, _, _, err := (&Machine{}).Read(ctx, "db", MachineInputs{}, MachineOutputs{}); err != nil || id != "" {
+		t.Errorf("Read of a missing VM = %q, %v", id, err)
+	}
+}
+
+func TestCheckNetworkAdapters(t *testing.T) {
+	t.Chdir(t.TempDir())
+	adapters := func(adapter map[string]interface{}) resource.PropertyMap {
+		return resource.NewPropertyMapFromMap(map[string]interface{}{
+			"networkAdapters": []interface{}{adapter},
+		})
+	}
+	tests := []struct {
+		name    string
+		adapter map[string]interface{}
+		want    []string
+	}{
+		{"supported", map[string]interface{}{"name": "nic", "switchName": "lan", "macAddress": "00155D000001"}, nil},
+		{"vm", map[string]interface{}{"name": "nic", "switchName": "lan", "vmName": "db", "vmId": "db"}, []string{"networkAdapters[0].vmId", "networkAdapters[0].vmName"}},
+		{"vlan", map[string]interface{}{"name": "nic", "switchName": "lan", "vlanId": 20}, []string{"networkAdapters[0].vlanId"}},
+		{"port settings", map[string]interface{}{"name": "nic", "switchName": "lan", "dhcpGuard": true}, []string{"networkAdapters[0].dhcpGuard"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, failures, err := (&Machine{}).Check(context.Background(), "web", nil, adapters(tt.adapter))
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, failure := range failures {
+				got = append(got, failure.Property)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Check failures = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateHardDrives(t *testing.T) {
+	tests := []struct {
+		name    string
+		drive   HardDriveInput
+		wantErr bool
+	}{
+		{"no settings", HardDriveInput{}, false},
+		{"iops", HardDriveInput{MinimumIops: ptr(100), MaximumIops: ptr(500)}, false},
+		{"unlimited maximum", HardDriveInput{MinimumIops: ptr(100), MaximumIops: ptr(0)}, false},
+		{"policy", HardDriveInput{QosPolicyId: ptr("8d730190-518f-4faf-a4a8-0b0b2b4c7fcf")}, false},
+		{"attachment", HardDriveInput{ReadOnly: ptr(true), SupportPersistentReservations: ptr(true), CacheMode: ptr("writecachedisabled")}, false},
+		{"negative minimum", HardDriveInput{MinimumIops: ptr(-1)}, true},
+		{"negative maximum", HardDriveInput{MaximumIops: ptr(-1)}, true},
+		{"minimum above maximum", HardDriveInput{MinimumIops: ptr(500), MaximumIops: ptr(100)}, true},
+		{"policy and iops", HardDriveInput{QosPolicyId: ptr("8d730190-518f-4faf-a4a8-0b0b2b4c7fcf"), MaximumIops: ptr(500)}, true},
+		{"cache mode", HardDriveInput{CacheMode: ptr("WriteBack")}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			drive := tt.drive
+			drive.Path = ptr(`C:\vms\data.vhdx`)
+			if err := validateHardDrives([]*HardDriveInput{nil, &drive}); (err != nil) != tt.wantErr {
+				t.Errorf("validateHardDrives = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestChangedHardDriveSettings(t *testing.T) {
+	drive := func(settings HardDriveInput) *HardDriveInput {
+		settings.Path = ptr(`C:\vms\data.vhdx`)
+		return &settings
+	}
+	u64 := func(v uint64) *uint64 { return &v }
+	tests := []struct {
+		name string
+		old  *HardDriveInput
+		new  *HardDriveInput
+		want *backend.DiskDriveSettings
+	}{
+		{"unchanged", drive(HardDriveInput{MaximumIops: ptr(500), ReadOnly: ptr(true)}), drive(HardDriveInput{MaximumIops: ptr(500), ReadOnly: ptr(true)}), nil},
+		{"no settings", drive(HardDriveInput{}), drive(HardDriveInput{}), nil},
+		{"changed", drive(HardDriveInput{MaximumIops: ptr(500)}), drive(HardDriveInput{MaximumIops: ptr(1000)}),
+			&backend.DiskDriveSettings{MaximumIops: u64(1000)}},
+		{"added", drive(HardDriveInput{}), drive(HardDriveInput{CacheMode: ptr(backend.CacheModeWriteCacheDisabled)}),
+			&backend.DiskDriveSettings{CacheMode: ptr(backend.CacheModeWriteCacheDisabled)}},
+		{"removed", drive(HardDriveInput{MinimumIops: ptr(100), QosPolicyId: ptr("policy"), ReadOnly: ptr(true), SupportPersistentReservations: ptr(true), CacheMode: ptr(backend.CacheModeWriteCacheEnabled)}), drive(HardDriveInput{}),
+			&backend.DiskDriveSettings{MinimumIops: u64(0), QosPolicyID: ptr(""), ReadOnly: ptr(false), SupportPersistentReservations: ptr(false), CacheMode: ptr(backend.CacheModeDefault)}},
+		{"policy replaces iops", drive(HardDriveInput{MaximumIops: ptr(500)}), drive(HardDriveInput{QosPolicyId: ptr("policy")}),
+			&backend.DiskDriveSettings{MaximumIops: u64(0), QosPolicyID: ptr("policy")}},
+		{"replaced", drive(HardDriveInput{MaximumIops: ptr(500)}), &HardDriveInput{Path: ptr(`C:\vms\logs.vhdx`), MaximumIops: ptr(1000)}, nil},
+		// A drive that moves is attached again with its settings.
+		{"moved", drive(HardDriveInput{MaximumIops: ptr(500)}), drive(HardDriveInput{ControllerLocation: ptr(3), MaximumIops: ptr(1000)}), nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			changes := changedHardDriveSettings([]*HardDriveInput{tt.old}, []*HardDriveInput{nil, tt.new})
+			if tt.want == nil {
+				if len(changes) != 0 {
+					t.Errorf("changedHardDriveSettings = %+v, want no change", changes)
+				}
+				return
+			}
+			if len(changes) != 1 || changes[0].drive != tt.new || !reflect.DeepEqual(changes[0].settings, tt.want) {
+				t.Errorf("changedHardDriveSettings = %+v, want %+v", changes, tt.want)
+			}
+		})
+	}
+}
+
+func TestHardDriveSettings(t *testing.T) {
+	ctx, sim := simulate(t)
+	c := &Machine{}
+
+	inputs := MachineInputs{
+		MachineName: ptr("web"),
+		HardDrives: []*HardDriveInput{
+			{Path: ptr(`C:\vms\os.vhdx`), ControllerType: ptr("SCSI"), ControllerNumber: ptr(0)},
+			{Path: ptr(`C:\vms\data.vhdx`), ControllerType: ptr("SCSI"), ControllerNumber: ptr(0),
+				MinimumIops: ptr(100), MaximumIops: ptr(500), ReadOnly: ptr(true), SupportPersistentReservations: ptr(true)},
+		},
+	}
+	id, state, err := c.Create(ctx, "web", inputs, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A drive without settings is attached without them.
+	if settings, err := sim.DiskDriveSettings("web", `C:\vms\os.vhdx`); err != nil || settings != nil {
+		t.Errorf("os disk settings = %+v, %v, want none", settings, err)
+	}
+	settings, err := sim.DiskDriveSettings("web", `C:\vms\data.vhdx`)
+	if err != nil || settings == nil || *settings.MinimumIops != 100 || *settings.MaximumIops != 500 || !*settings.ReadOnly || !*settings.SupportPersistentReservations {
+		t.Errorf("data disk settings = %+v, %v", settings, err)
+	}
+
+	// Settings that are no longer set go back to the defaults while the VM keeps running.
+	news := inputs
+	news.HardDrives = []*HardDriveInput{
+		inputs.HardDrives[0],
+		{Path: ptr(`C:\vms\data.vhdx`), ControllerType: ptr("SCSI"), ControllerNumber: ptr(0), QosPolicyId: ptr("policy")},
+	}
+	if _, err := c.Update(ctx, id, state, news, false); err != nil {
+		t.Fatal(err)
+	}
+	settings, err = sim.DiskDriveSettings("web", `C:\vms\data.vhdx`)
+	if err != nil || *settings.MinimumIops != 0 || *settings.MaximumIops != 0 || *settings.QosPolicyID != "policy" || *settings.ReadOnly || *settings.SupportPersistentReservations {
+		t.Errorf("updated data disk settings = %+v, %v", settings, err)
+	}
+	if vm, _ := sim.GetVM(ctx, "web"); vm.State != backend.PowerStateRunning {
+		t.Errorf("VM state after a settings change = %v, want running", vm.State)
+	}
+
+	// Invalid settings are refused before the VM is created.
+	inputs = MachineInputs{MachineName: ptr("db"), HardDrives: []*HardDriveInput{{Path: ptr(`C:\vms\logs.vhdx`), CacheMode: ptr("WriteBack")}}}
+	if _, _, err := c.Create(ctx, "db", inputs, false); err == nil {
+		t.Error("Create with an invalid cache mode succeeded")
+	}
+	if _, err := sim.GetVM(ctx, "db"); !errors.Is(err, errs.ErrNotFound) {
+		t.Errorf("GetVM after a refused Create = %v, want ErrNotFound", err)
+	}
+}
+
+func TestScsiControllerCount(t *testing.T) {
+	scsi := func(number int) *HardDriveInput {
+		return &HardDriveInput{Path: ptr(`C:\vms\data.vhdx`), ControllerType: ptr("scsi"), ControllerNumber: ptr(number)}
+	}
+	tests := []struct {
+		name       string
+		input      MachineInputs
+		generation int
+		want       int
+		wantChange bool
+	}{
+		{"generation 2 default", MachineInputs{}, 2, 1, false},
+		{"generation 1 default", MachineInputs{}, 1, 0, false},
+		{"first controller", MachineInputs{HardDrives: []*HardDriveInput{scsi(0)}}, 2, 1, false},
+		{"second controller", MachineInputs{HardDrives: []*HardDriveInput{scsi(0), scsi(1)}}, 2, 2, true},
+		{"default controller type", MachineInputs{HardDrives: []*HardDriveInput{{Path: ptr(`C:\vms\data.vhdx`)}}}, 1, 1, true},
+		{"generation 1 SCSI", MachineInputs{HardDrives: []*HardDriveInput{scsi(0)}}, 1, 1, true},
+		{"generation 1 IDE", MachineInputs{HardDrives: []*HardDriveInput{{Path: ptr(`C:\vms\os.vhdx`), ControllerType: ptr("IDE"), ControllerNumber: ptr(1)}}}, 1, 0, false},
+		{"drive without path", MachineInputs{HardDrives: []*HardDriveInput{nil, {ControllerNumber: ptr(3)}}}, 2, 1, false},
+		{"pass-through disk", MachineInputs{PassThroughDisks: []*PassThroughDiskInput{nil, {DiskNumber: ptr(3), ControllerNumber: ptr(2)}}}, 2, 3, true},
+		{"explicit count", MachineInputs{ScsiControllerCount: ptr(4), HardDrives: []*HardDriveInput{scsi(0)}}, 2, 4, true},
+		{"explicit default", MachineInputs{ScsiControllerCount: ptr(1)}, 2, 1, false},
+		{"explicit removal", MachineInputs{ScsiControllerCount: ptr(0)}, 2, 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, change := scsiControllerCount(tt.input, tt.generation)
+			if got != tt.want || change != tt.wantChange {
+				t.Errorf("scsiControllerCount = %d, %v, want %d, %v", got, change, tt.want, tt.wantChange)
+			}
+		})
+	}
+}
+
+func TestValidateControllers(t *testing.T) {
+	drive := func(controllerType string, number int, location *int) *HardDriveInput {
+		return &HardDriveInput{Path: ptr(`C:\vms\data.vhdx`), ControllerType: ptr(controllerType), ControllerNumber: ptr(number), ControllerLocation: location}
+	}
+	tests := []struct {
+		name    string
+		input   MachineInputs
+		wantErr bool
+	}{
+		{"defaults", MachineInputs{HardDrives: []*HardDriveInput{{Path: ptr(`C:\vms\data.vhdx`)}}}, false},
+		{"last SCSI controller", MachineInputs{HardDrives: []*HardDriveInput{drive("SCSI", 3, ptr(63))}}, false},
+		{"within the count", MachineInputs{ScsiControllerCount: ptr(2), HardDrives: []*HardDriveInput{drive("SCSI", 1, nil)}}, false},
+		{"generation 1 IDE", MachineInputs{Generation: ptr(1), HardDrives: []*HardDriveInput{drive("ide", 1, ptr(1))}}, false},
+		{"count too high", MachineInputs{ScsiControllerCount: ptr(5)}, true},
+		{"negative count", MachineInputs{ScsiControllerCount: ptr(-1)}, true},
+		{"beyond the count", MachineInputs{ScsiControllerCount: ptr(1), HardDrives: []*HardDriveInput{drive("SCSI", 1, nil)}}, true},
+		{"no controllers", MachineInputs{ScsiControllerCount: ptr(0), PassThroughDisks: []*PassThroughDiskInput{{DiskNumber: ptr(3)}}}, true},
+		{"fifth SCSI controller", MachineInputs{HardDrives: []*HardDriveInput{drive("SCSI", 4, nil)}}, true},
+		{"SCSI location", MachineInputs{HardDrives: []*HardDriveInput{drive("SCSI", 0, ptr(64))}}, true},
+		{"generation 2 IDE", MachineInputs{HardDrives: []*HardDriveInput{drive("IDE", 0, nil)}}, true},
+		{"IDE controller", MachineInputs{Generation: ptr(1), HardDrives: []*HardDriveInput{drive("IDE", 2, nil)}}, true},
+		{"IDE location", MachineInputs{Generation: ptr(1), HardDrives: []*HardDriveInput{drive("IDE", 0, ptr(2))}}, true},
+		{"controller type", MachineInputs{HardDrives: []*HardDriveInput{drive("NVMe", 0, nil)}}, true},
+		{"same slot", MachineInputs{
+			HardDrives:       []*HardDriveInput{drive("SCSI", 0, ptr(1))},
+			PassThroughDisks: []*PassThroughDiskInput{{DiskNumber: ptr(3), ControllerLocation: ptr(1)}},
+		}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := validateControllers(tt.input); (err != nil) != tt.wantErr {
+				t.Errorf("validateControllers = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestVMSettings(t *testing.T) {
+	u64 := func(v uint64) *uint64 { return &v }
+	olds := MachineInputs{ProcessorCount: ptr(2), MemorySize: ptr(2048), DynamicMemory: ptr(true), MinimumMemory: ptr(512), MaximumMemory: ptr(4096), AutoStartAction: ptr("Nothing")}
+	tests := []struct {
+		name string
+		olds MachineInputs
+		news MachineInputs
+		want backend.VMSettings
+	}{
+		{"unchanged", olds, olds, backend.VMSettings{}},
+		{"unset", olds, MachineInputs{}, backend.VMSettings{}},
+		{"create", MachineInputs{}, olds, backend.VMSettings{
+			ProcessorCount: ptr(2), MemoryMB: u64(2048), DynamicMemory: ptr(true), MinimumMemoryMB: u64(512), MaximumMemoryMB: u64(4096), AutomaticStartAction: ptr("Nothing"),
+		}},
+		{"changed", olds, MachineInputs{ProcessorCount: ptr(4), MemorySize: ptr(2048), DynamicMemory: ptr(true), MaximumMemory: ptr(8192), AutoStopAction: ptr("Save")}, backend.VMSettings{
+			ProcessorCount: ptr(4), MaximumMemoryMB: u64(8192), AutomaticStopAction: ptr("Save"),
+		}},
+		// The limits only apply to dynamic memory.
+		{"static memory", MachineInputs{}, MachineInputs{MemorySize: ptr(1024), MinimumMemory: ptr(512), MaximumMemory: ptr(2048)}, backend.VMSettings{MemoryMB: u64(1024)}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := vmSettings(tt.olds, tt.news); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("vmSettings = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStopReason(t *testing.T) {
+	nic := &networkadapter.NetworkAdapterInputs{Name: ptr("nic"), SwitchName: ptr("lan")}
+	olds := MachineInputs{ProcessorCount: ptr(2), MemorySize: ptr(2048), NetworkAdapters: []*networkadapter.NetworkAdapterInputs{nic}}
+	with := func(change func(*MachineInputs)) MachineInputs {
+		news := olds
+		change(&news)
+		return news
+	}
+	tests := []struct {
+		name string
+		news MachineInputs
+		stop bool
+	}{
+		{"unchanged", olds, false},
+		{"automatic actions", with(func(m *MachineInputs) { m.AutoStartAction, m.AutoStopAction = ptr("Start"), ptr("ShutDown") }), false},
+		{"switch", with(func(m *MachineInputs) {
+			m.NetworkAdapters = []*networkadapter.NetworkAdapterInputs{{Name: ptr("nic"), SwitchName: ptr("wan")}}
+		}), false},
+		{"hard drives", with(func(m *MachineInputs) { m.HardDrives = []*HardDriveInput{{Path: ptr(`C:\vms\data.vhdx`)}} }), true},
+		// Static memory is the default, so turning it off explicitly changes nothing.
+		{"static memory", with(func(m *MachineInputs) { m.DynamicMemory = ptr(false) }), false},
+		{"dynamic memory", with(func(m *MachineInputs) { m.DynamicMemory = ptr(true) }), true},
+		{"processors", with(func(m *MachineInputs) { m.ProcessorCount = ptr(4) }), true},
+		{"memory", with(func(m *MachineInputs) { m.MemorySize = ptr(4096) }), true},
+		{"network adapters", with(func(m *MachineInputs) { m.NetworkAdapters = nil }), true},
+		{"SCSI controllers", with(func(m *MachineInputs) { m.ScsiControllerCount = ptr(2) }), true},
+		{"SCSI controllers of the VM", with(func(m *MachineInputs) { m.ScsiControllerCount = ptr(1) }), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if reason := stopReason(olds, tt.news, 2); (reason != "") != tt.stop {
+				t.Errorf("stopReason = %q, want a stop %v", reason, tt.stop)
+			}
+		})
+	}
+}
+
+func TestCreate(t *testing.T) {
+	ctx, sim := simulate(t)
+	c := &Machine{}
+
+	// A preview changes nothing.
+	inputs := MachineInputs{MachineName: ptr("web"), HardDrives: []*HardDriveInput{{Path: ptr(`C:\vms\os.vhdx`)}}}
+	if _, _, err := c.Create(ctx, "web", inputs, true); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := sim.GetVM(ctx, "web"); !errors.Is(err, errs.ErrNotFound) {
+		t.Errorf("GetVM after a preview = %v, want ErrNotFound", err)
+	}
+
+	// A generation 1 VM boots from IDE and only gets a SCSI controller for the data disk.
+	inputs = MachineInputs{
+		Generation: ptr(1),
+		HardDrives: []*HardDriveInput{
+			{Path: ptr(`C:\vms\os.vhdx`), ControllerType: ptr("IDE"), ControllerNumber: ptr(0), ControllerLocation: ptr(0)},
+			{Path: ptr(`C:\vms\data.vhdx`), ControllerType: ptr("SCSI")},
+		},
+	}
+	id, _, err := c.Create(ctx, "legacy", inputs, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id != "legacy" {
+		t.Errorf("Create = %q, want the resource name", id)
+	}
+	if count, _ := sim.SCSIControllerCount("legacy"); count != 1 {
+		t.Errorf("SCSI controllers = %d, want 1", count)
+	}
+	drives, err := sim.ListDiskDrives(ctx, "legacy")
+	if err != nil || len(drives) != 2 || drives[0].ControllerType != backend.ControllerIDE || drives[1].ControllerType != backend.ControllerSCSI {
+		t.Errorf("hard drives = %+v, %v", drives, err)
+	}
+
+	// An explicit count removes the controller of a generation 2 VM.
+	if _, _, err := c.Create(ctx, "bare", MachineInputs{ScsiControllerCount: ptr(0)}, false); err != nil {
+		t.Fatal(err)
+	}
+	if count, _ := sim.SCSIControllerCount("bare"); count != 0 {
+		t.Errorf("SCSI controllers = %d, want 0", count)
+	}
+
+	// Disks beyond the controllers are refused before the VM is created.
+	inputs = MachineInputs{ScsiControllerCount: ptr(1), HardDrives: []*HardDriveInput{{Path: ptr(`C:\vms\logs.vhdx`), ControllerNumber: ptr(1)}}}
+	if _, _, err := c.Create(ctx, "db", inputs, false); err == nil {
+		t.Error("Create with a disk beyond scsiControllerCount succeeded")
+	}
+	if _, err := sim.GetVM(ctx, "db"); !errors.Is(err, errs.ErrNotFound) {
+		t.Errorf("GetVM after a refused Create = %v, want ErrNotFound", err)
+	}
+}
+
+func TestUpdate(t *testing.T) {
+	ctx, sim := simulate(t)
+	c := &Machine{}
+
+	inputs := MachineInputs{MachineName: ptr("web"), ProcessorCount: ptr(2), HardDrives: []*HardDriveInput{{Path: ptr(`C:\vms\os.vhdx`)}}}
+	id, state, err := c.Create(ctx, "web", inputs, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A preview changes nothing.
+	news := inputs
+	news.ProcessorCount = ptr(4)
+	if _, err := c.Update(ctx, id, state, news, true); err != nil {
+		t.Fatal(err)
+	}
+	if vm, _ := sim.GetVM(ctx, "web"); vm.ProcessorCount != 2 {
+		t.Errorf("processors after a preview = %d, want 2", vm.ProcessorCount)
+	}
+
+	// The automatic actions are changed without stopping the VM.
+	news = inputs
+	news.AutoStartAction = ptr("Start")
+	state, err = c.Update(ctx, id, state, news, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if vm, _ := sim.GetVM(ctx, "web"); vm.State != backend.PowerStateRunning || vm.AutomaticStartAction != "Start" {
+		t.Errorf("updated VM = %+v", vm)
+	}
+
+	// A disk on a new controller adds it without scsiControllerCount, as Create does.
+	news.HardDrives = append(news.HardDrives, &HardDriveInput{Path: ptr(`C:\vms\logs.vhdx`), ControllerNumber: ptr(1)})
+	state, err = c.Update(ctx, id, state, news, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count, _ := sim.SCSIControllerCount("web"); count != 2 {
+		t.Errorf("SCSI controllers = %d, want 2", count)
+	}
+	news.HardDrives = news.HardDrives[:1]
+	if state, err = c.Update(ctx, id, state, news, false); err != nil {
+		t.Fatal(err)
+	}
+
+	// Controllers are added before the disks that use them are attached.
+	news.ScsiControllerCount = ptr(3)
+	news.HardDrives = append(news.HardDrives, &HardDriveInput{Path: ptr(`C:\vms\data.vhdx`), ControllerNumber: ptr(2)})
+	state, err = c.Update(ctx, id, state, news, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count, _ := sim.SCSIControllerCount("web"); count != 3 {
+		t.Errorf("SCSI controllers = %d, want 3", count)
+	}
+	drives, err := sim.ListDiskDrives(ctx, "web")
+	if err != nil || len(drives) != 2 || drives[1].ControllerNumber != 2 {
+		t.Errorf("hard drives = %+v, %v", drives, err)
+	}
+	if vm, _ := sim.GetVM(ctx, "web"); vm.State != backend.PowerStateRunning {
+		t.Errorf("VM state after the update = %v, want running", vm.State)
+	}
+
+	// And removed after their disks are detached.
+	news.ScsiControllerCount = ptr(1)
+	news.HardDrives = news.HardDrives[:1]
+	if state, err = c.Update(ctx, id, state, news, false); err != nil {
+		t.Fatal(err)
+	}
+	if count, _ := sim.SCSIControllerCount("web"); count != 1 {
+		t.Errorf("SCSI controllers = %d, want 1", count)
+	}
+
+	// A drive that moves to another slot is detached and attached there.
+	moved := news
+	moved.HardDrives = []*HardDriveInput{{Path: ptr(`C:\vms\os.vhdx`), ControllerType: ptr("scsi"), ControllerNumber: ptr(0), ControllerLocation: ptr(5)}}
+	if state, err = c.Update(ctx, id, state, moved, false); err != nil {
+		t.Fatal(err)
+	}
+	drives, err = sim.ListDiskDrives(ctx, "web")
+	if err != nil || len(drives) != 1 || drives[0].ControllerLocation != 5 {
+		t.Errorf("hard drives after a move = %+v, %v", drives, err)
+	}
+	news = moved
+
+	// A VM that was stopped for a failed update is started again.
+	failing := news
+	failing.ProcessorCount = ptr(4)
+	failing.HardDrives = append(failing.HardDrives, &HardDriveInput{Path: ptr(`C:\vms\missing.vhdx`)})
+	if _, err := c.Update(ctx, id, state, failing, false); err == nil {
+		t.Error("Update with a missing disk succeeded")
+	}
+	if vm, _ := sim.GetVM(ctx, "web"); vm.State != backend.PowerStateRunning {
+		t.Errorf("VM state after a failed update = %v, want running", vm.State)
+	}
+
+	// Settings the host cannot apply are refused before anything changes.
+	invalid := news
+	invalid.ScsiControllerCount = ptr(5)
+	if _, err := c.Update(ctx, id, state, invalid, false); err == nil {
+		t.Error("Update to 5 SCSI controllers succeeded")
+	}
+	if count, _ := sim.SCSIControllerCount("web"); count != 1 {
+		t.Errorf("SCSI controllers after a refused Update = %d, want 1", count)
+	}
+
+	if err := c.Delete(ctx, id, state); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Update(ctx, id, state, news, false); err == nil {
+		t.Error("Update of a missing VM succeeded")
+	}
+}
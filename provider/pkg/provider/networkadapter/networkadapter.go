@@ -18,7 +18,7 @@ import (
 	_ "embed"
 
 	"github.com/pulumi/pulumi-go-provider/infer"
-	"github.com/pulumi/pulumi-hyperv/provider/pkg/provider/common"
+	"github.com/pulumi/pulumi-hyperv/provider/pkg/provider/resource"
 )
 
 //go:embed networkadapter.md
@@ -42,12 +42,14 @@ func (c *NetworkAdapter) Annotate(a infer.Annotator) {
 
 // These are the inputs (or arguments) to a NetworkAdapter resource.
 type NetworkAdapterInputs struct {
-	common.ResourceInputs
+	resource.Inputs
 	Name            *string `pulumi:"name"`
 	VMName          *string `pulumi:"vmName,optional"`
+	VmId            *string `pulumi:"vmId,optional"`
 	SwitchName      *string `pulumi:"switchName"`
 	MacAddress      *string `pulumi:"macAddress,optional"`
 	VlanId          *int    `pulumi:"vlanId,optional"`
+	Vlan            *Vlan   `pulumi:"vlan,optional"`
 	DHCPGuard       *bool   `pulumi:"dhcpGuard,optional"`
 	RouterGuard     *bool   `pulumi:"routerGuard,optional"`
 	PortMirroring   *string `pulumi:"portMirroring,optional"`
@@ -58,24 +60,54 @@ type NetworkAdapterInputs struct {
 
 func (c *NetworkAdapterInputs) Annotate(a infer.Annotator) {
 	a.Describe(&c.Name, "Name of the network adapter")
-	a.Describe(&c.VMName, "Name of the virtual machine to attach the network adapter to")
+	a.Describe(&c.VMName, "Name of the virtual machine to attach the network adapter to. Set either vmName or vmId.")
+	a.Describe(&c.VmId, "ID of the virtual machine to attach the network adapter to, as reported by Get-VM. Set either vmName or vmId.")
 	a.Describe(&c.SwitchName, "Name of the virtual switch to connect the network adapter to")
 	a.Describe(&c.MacAddress, "MAC address for the network adapter. If not specified, a dynamic MAC address will be generated.")
-	a.Describe(&c.VlanId, "VLAN ID for the network adapter. If not specified, no VLAN tagging is used.")
+	a.Describe(&c.VlanId, "VLAN ID for the network adapter. If not specified, no VLAN tagging is used. Use vlan for trunk and private VLAN modes.")
+	a.Describe(&c.Vlan, "VLAN mode of the network adapter, such as trunk or private VLAN. Set either vlanId or vlan.")
 	a.Describe(&c.DHCPGuard, "Enable DHCP Guard. Prevents the virtual machine from broadcasting DHCP server messages.")
 	a.Describe(&c.RouterGuard, "Enable Router Guard. Prevents the virtual machine from broadcasting router advertisement and discovery messages.")
-	a.Describe(&c.PortMirroring, "Port mirroring mode. Valid values are None, Source, Destination, and Both. Defaults to None.")
+	a.Describe(&c.PortMirroring, "Port mirroring mode. Valid values are None, Source and Destination. Defaults to None.")
 	a.Describe(&c.IeeePriorityTag, "Enable IEEE Priority Tagging. Allows the virtual machine to tag outgoing network traffic with an IEEE 802.1p priority value.")
 	a.Describe(&c.VMQWeight, "VMQ weight for the network adapter. A value of 0 disables VMQ.")
 	a.Describe(&c.IPAddresses, "Comma-separated list of IP addresses to assign to the network adapter.")
 }
 
+// Vlan is the VLAN configuration of the switch port of a network adapter. Only the IDs of its
+// mode are set.
+type Vlan struct {
+	Mode             *string `pulumi:"mode"`
+	AccessVlanId     *int    `pulumi:"accessVlanId,optional"`
+	NativeVlanId     *int    `pulumi:"nativeVlanId,optional"`
+	AllowedVlanIds   []int   `pulumi:"allowedVlanIds,optional"`
+	PrimaryVlanId    *int    `pulumi:"primaryVlanId,optional"`
+	SecondaryVlanId  *int    `pulumi:"secondaryVlanId,optional"`
+	SecondaryVlanIds []int   `pulumi:"secondaryVlanIds,optional"`
+}
+
+func (c *Vlan) Annotate(a infer.Annotator) {
+	a.Describe(&c.Mode, "VLAN mode of the port. Valid values are Untagged, Access, Trunk, and the private VLAN modes Isolated, Community and Promiscuous.")
+	a.Describe(&c.AccessVlanId, "VLAN of an Access port, from 1 to 4094.")
+	a.Describe(&c.NativeVlanId, "VLAN of the untagged traffic of a Trunk port, from 0 to 4094. Defaults to 0.")
+	a.Describe(&c.AllowedVlanIds, "IDs of the VLANs a Trunk port carries, from 1 to 4094.")
+	a.Describe(&c.PrimaryVlanId, "Primary VLAN of an Isolated, Community or Promiscuous port.")
+	a.Describe(&c.SecondaryVlanId, "Secondary VLAN of an Isolated or Community port.")
+	a.Describe(&c.SecondaryVlanIds, "IDs of the secondary VLANs of a Promiscuous port, from 1 to 4094.")
+}
+
 // These are the outputs (or properties) of a NetworkAdapter resource.
 type NetworkAdapterOutputs struct {
 	NetworkAdapterInputs
-	AdapterId *string `pulumi:"adapterId"`
+	AdapterId          *string  `pulumi:"adapterId"`
+	AssignedMacAddress *string  `pulumi:"assignedMacAddress,optional"`
+	GuestIpAddresses   []string `pulumi:"guestIpAddresses,optional"`
+	PortStatus         *string  `pulumi:"portStatus,optional"`
 }
 
 func (c *NetworkAdapterOutputs) Annotate(a infer.Annotator) {
 	a.Describe(&c.AdapterId, "The ID of the network adapter")
+	a.Describe(&c.AssignedMacAddress, "MAC address the adapter has, including one Hyper-V assigned dynamically.")
+	a.Describe(&c.GuestIpAddresses, "IP addresses the guest reports for the adapter. Requires the integration services to run in the guest.")
+	a.Describe(&c.PortStatus, "Operational status of the switch port of the adapter, such as Ok or Degraded. Empty when the adapter is not connected.")
 }
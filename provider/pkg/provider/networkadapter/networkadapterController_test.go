@@ -0,0 +1,265 @@
+// Copyright 2016-2022, Pulumi Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package networkadapter
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/pulumi/pulumi-hyperv/provider/pkg/provider/backend"
+	"github.com/pulumi/pulumi-hyperv/provider/pkg/provider/errs"
+	"github.com/pulumi/pulumi/sdk/v3/go/common/resource"
+)
+
+func TestMigrateReferenceAdapter(t *testing.T) {
+	ctx := context.Background()
+
+	reference := NetworkAdapterOutputs{AdapterId: ptr("ref-nic")}
+	reference.Name = ptr("nic")
+	migrated, err := migrateReferenceAdapter(ctx, reference)
+	if err != nil || migrated.Result == nil || migrated.Result.AdapterId != nil || *migrated.Result.Name != "nic" {
+		t.Errorf("migrateReferenceAdapter(reference) = %+v, %v", migrated.Result, err)
+	}
+
+	// Adapters that target a VM were created, whatever their ID looks like.
+	attached := NetworkAdapterOutputs{AdapterId: ptr("ref-nic")}
+	attached.VMName = ptr("web")
+	for _, state := range []NetworkAdapterOutputs{attached, {AdapterId: ptr("nic")}, {}} {
+		migrated, err := migrateReferenceAdapter(ctx, state)
+		if err != nil || migrated.Result != nil {
+			t.Errorf("migrateReferenceAdapter(%+v) = %+v, %v", state, migrated.Result, err)
+		}
+	}
+}
+
+func TestCheckVlan(t *testing.T) {
+	inputs := func(vlan map[string]interface{}) resource.PropertyMap {
+		m := map[string]interface{}{"name": "nic", "vmName": "web", "switchName": "lan"}
+		for k, v := range vlan {
+			m[k] = v
+		}
+		return resource.NewPropertyMapFromMap(m)
+	}
+	tests := []struct {
+		name   string
+		inputs map[string]interface{}
+		want   string
+	}{
+		{"access", map[string]interface{}{"vlanId": 20}, ""},
+		{"untagged", map[string]interface{}{"vlanId": 0}, ""},
+		{"vlanId range", map[string]interface{}{"vlanId": 4095}, "vlanId"},
+		{"trunk", map[string]interface{}{"vlan": map[string]interface{}{"mode": "Trunk", "allowedVlanIds": []interface{}{10, 20}}}, ""},
+		{"trunk range", map[string]interface{}{"vlan": map[string]interface{}{"mode": "Trunk", "allowedVlanIds": []interface{}{0}}}, "vlan"},
+		{"trunk without list", map[string]interface{}{"vlan": map[string]interface{}{"mode": "Trunk"}}, "vlan"},
+		{"access with list", map[string]interface{}{"vlan": map[string]interface{}{"mode": "Access", "accessVlanId": 5, "allowedVlanIds": []interface{}{6}}}, "vlan"},
+		{"both", map[string]interface{}{"vlanId": 5, "vlan": map[string]interface{}{"mode": "Access", "accessVlanId": 5}}, "vlan"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, failures, err := (&NetworkAdapter{}).Check(context.Background(), "nic", nil, inputs(tt.inputs))
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := ""
+			if len(failures) > 0 {
+				got = failures[0].Property
+			}
+			if len(failures) > 1 || got != tt.want {
+				t.Errorf("Check failures = %+v, want one for %q", failures, tt.want)
+			}
+		})
+	}
+}
+
+// simulate returns a context whose resource operations run against a Simulator with the VM
+// web and the switches lan and wan.
+func simulate(t *testing.T) (context.Context, *backend.Simulator) {
+	t.Helper()
+	sim := backend.NewSimulator()
+	ctx := backend.WithBackend(context.Background(), sim)
+	if _, err := sim.CreateVM(ctx, backend.VMSpec{Name: "web", Generation: 2}); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"lan", "wan"} {
+		if _, err := sim.CreateSwitch(ctx, backend.Switch{Name: name, SwitchType: backend.SwitchTypeInternal}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return ctx, sim
+}
+
+func TestLifecycle(t *testing.T) {
+	ctx, sim := simulate(t)
+	c := &NetworkAdapter{}
+
+	inputs := NetworkAdapterInputs{
+		Name: ptr("nic"), VMName: ptr("web"), SwitchName: ptr("lan"),
+		MacAddress: ptr("00-15-5D-00-00-01"), VlanId: ptr(20), DHCPGuard: ptr(true),
+	}
+	id, state, err := c.Create(ctx, "nic", inputs, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id != "nic" || *state.AdapterId != "web/nic" || *state.AssignedMacAddress != "00155D000001" || *state.PortStatus != "Ok" {
+		t.Errorf("Create = %q, %+v", id, state)
+	}
+	status, err := sim.GetNetworkAdapterStatus(ctx, "web", "nic")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.Vlan.Mode != backend.VlanAccess || status.Vlan.AccessVlanID != 20 || !status.DHCPGuard {
+		t.Errorf("created the switch port %+v", status)
+	}
+
+	_, actual, outputs, err := c.Read(ctx, id, inputs, state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(actual, inputs) {
+		t.Errorf("Read reported drift in %+v", actual)
+	}
+
+	changed := inputs
+	changed.SwitchName = ptr("wan")
+	changed.VlanId = nil
+	changed.Vlan = &Vlan{Mode: ptr("Trunk"), AllowedVlanIds: []int{10, 11, 12}}
+	changed.MacAddress = ptr("00155D000002")
+	changed.VMQWeight = ptr(0)
+	if state, err = c.Update(ctx, id, outputs, changed, false); err != nil {
+		t.Fatal(err)
+	}
+	adapters, err := sim.ListNetworkAdapters(ctx, "web")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if adapters[0].SwitchName != "wan" || adapters[0].MacAddress != "00155D000002" || *state.AdapterId != "web/nic" {
+		t.Errorf("Update left the adapter %+v, state %+v", adapters[0], state)
+	}
+	if status, err = sim.GetNetworkAdapterStatus(ctx, "web", "nic"); err != nil {
+		t.Fatal(err)
+	}
+	if status.Vlan.Mode != backend.VlanTrunk || !reflect.DeepEqual(status.Vlan.AllowedVlanIDs, []int{10, 11, 12}) ||
+		status.VMQWeight != 0 || !status.DHCPGuard {
+		t.Errorf("updated the switch port to %+v", status)
+	}
+
+	// Settings that are no longer set go back to the defaults of Hyper-V.
+	unset := changed
+	unset.DHCPGuard = nil
+	unset.VMQWeight = nil
+	unset.Vlan = nil
+	if state, err = c.Update(ctx, id, state, unset, false); err != nil {
+		t.Fatal(err)
+	}
+	if status, err = sim.GetNetworkAdapterStatus(ctx, "web", "nic"); err != nil {
+		t.Fatal(err)
+	}
+	if status.DHCPGuard || status.VMQWeight != 100 || status.Vlan.Mode != backend.VlanUntagged {
+		t.Errorf("unsetting settings left the switch port %+v", status)
+	}
+
+	// A vlan block replaced by vlanId sets the port to the VLAN ID.
+	trunk := unset
+	trunk.Vlan = &Vlan{Mode: ptr("Trunk"), AllowedVlanIds: []int{10}}
+	if state, err = c.Update(ctx, id, state, trunk, false); err != nil {
+		t.Fatal(err)
+	}
+	access := unset
+	access.VlanId = ptr(30)
+	if state, err = c.Update(ctx, id, state, access, false); err != nil {
+		t.Fatal(err)
+	}
+	if status, err = sim.GetNetworkAdapterStatus(ctx, "web", "nic"); err != nil {
+		t.Fatal(err)
+	}
+	if status.Vlan.Mode != backend.VlanAccess || status.Vlan.AccessVlanID != 30 {
+		t.Errorf("replacing vlan with vlanId left the switch port %+v", status.Vlan)
+	}
+
+	invalid := unset
+	invalid.VMQWeight = ptr(101)
+	if _, err := c.Update(ctx, id, state, invalid, false); err == nil || !strings.Contains(err.Error(), "vmqWeight") {
+		t.Errorf("Update with an invalid vmqWeight = %v", err)
+	}
+
+	if err := c.Delete(ctx, id, state); err != nil {
+		t.Fatal(err)
+	}
+	if adapters, _ := sim.ListNetworkAdapters(ctx, "web"); len(adapters) != 0 {
+		t.Errorf("adapters after Delete = %+v", adapters)
+	}
+	if id, _, _, err := c.Read(ctx, id, changed, state); err != nil || id != "" {
+		t.Errorf("Read of a deleted adapter = %q, %v, want it gone", id, err)
+	}
+	// An adapter that is already gone is deleted successfully.
+	if err := c.Delete(ctx, id, state); err != nil {
+		t.Errorf("Delete of a deleted adapter: %v", err)
+	}
+}
+
+func TestCreateByVMId(t *testing.T) {
+	ctx, sim := simulate(t)
+	vm, err := sim.GetVM(ctx, "web")
+	if err != nil {
+		t.Fatal(err)
+	}
+	inputs := NetworkAdapterInputs{VmId: ptr(vm.ID), SwitchName: ptr("lan"), VlanId: ptr(0)}
+	id, state, err := (&NetworkAdapter{}).Create(ctx, "backup", inputs, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id != "backup" || *state.AdapterId != "web/backup" || state.AssignedMacAddress == nil {
+		t.Errorf("Create = %q, %+v", id, state)
+	}
+	if status, err := sim.GetNetworkAdapterStatus(ctx, "web", "backup"); err != nil || status.Vlan.Mode != backend.VlanUntagged {
+		t.Errorf("created the switch port %+v, %v", status, err)
+	}
+
+	if _, _, err := (&NetworkAdapter{}).Create(ctx, "nic", NetworkAdapterInputs{VMName: ptr("missing"), SwitchName: ptr("lan")}, false); !errors.Is(err, errs.ErrNotFound) {
+		t.Errorf("Create on a missing VM = %v, want ErrNotFound", err)
+	}
+}
+
+// Names are never spliced into queries, so quotes in them need no escaping.
+func TestQuotedNames(t *testing.T) {
+	ctx, sim := simulate(t)
+	if _, err := sim.CreateVM(ctx, backend.VMSpec{Name: "O'Brien", Generation: 2}); err != nil {
+		t.Fatal(err)
+	}
+	c := &NetworkAdapter{}
+	inputs := NetworkAdapterInputs{Name: ptr("it's nic"), VMName: ptr("O'Brien"), SwitchName: ptr("lan"), VlanId: ptr(5)}
+	id, state, err := c.Create(ctx, "nic", inputs, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id != "it's nic" || *state.AdapterId != "O'Brien/it's nic" {
+		t.Errorf("Create = %q, %+v", id, state)
+	}
+	if _, _, err := c.Create(ctx, "nic", inputs, false); !errors.Is(err, errs.ErrAlreadyExists) || !strings.Contains(err.Error(), "'O'Brien/it's nic'") {
+		t.Errorf("Create of an existing adapter = %v, want ErrAlreadyExists with the ID to import", err)
+	}
+	if adapters, _ := sim.ListNetworkAdapters(ctx, "O'Brien"); len(adapters) != 1 {
+		t.Errorf("adapters = %+v, want the existing adapter found by name", adapters)
+	}
+	if err := c.Delete(ctx, id, state); err != nil {
+		t.Fatal(err)
+	}
+	if adapters, _ := sim.ListNetworkAdapters(ctx, "O'Brien"); len(adapters) != 0 {
+		t.Errorf("adapters after Delete = %+v", adapters)
+	}
+}
@@ -14,8 +14,88 @@
 
 package networkadapter
 
-// This file is intentionally left mostly empty.
-// It serves as a placeholder for any additional output-related code
-// that might be needed in the future.
+import (
+	"strings"
 
-// The NetworkAdapterOutputs struct is already defined in networkadapter.go.
+	"github.com/pulumi/pulumi-hyperv/provider/pkg/provider/backend"
+)
+
+// The switch port settings of a new adapter.
+const (
+	defaultPortMirroring = "None"
+	defaultVMQWeight     = 100
+)
+
+// readInputs replaces the inputs with the actual settings of the adapter, so a refresh shows
+// changes made outside of Pulumi. Values that only differ in case or MAC address notation are
+// kept as written, and optional inputs are only recorded when they were set or the adapter no
+// longer has their default.
+func readInputs(inputs NetworkAdapterInputs, adapter *backend.NetworkAdapter, status *backend.NetworkAdapterStatus) NetworkAdapterInputs {
+	if inputs.SwitchName != nil || adapter.SwitchName != "" {
+		if inputs.SwitchName == nil || !strings.EqualFold(*inputs.SwitchName, adapter.SwitchName) {
+			inputs.SwitchName = &adapter.SwitchName
+		}
+	}
+	switch {
+	case adapter.DynamicMacAddress || adapter.MacAddress == "":
+		inputs.MacAddress = nil
+	case inputs.MacAddress == nil || normalizeMAC(*inputs.MacAddress) != normalizeMAC(adapter.MacAddress):
+		inputs.MacAddress = &adapter.MacAddress
+	}
+
+	// vlanId can only describe an untagged or access port, other modes are read into vlan.
+	switch {
+	case inputs.Vlan != nil:
+		inputs.Vlan = readVlan(inputs.Vlan, status.Vlan)
+	case strings.EqualFold(status.Vlan.Mode, backend.VlanAccess):
+		inputs.VlanId = readInt(inputs.VlanId, status.Vlan.AccessVlanID, 0)
+	case status.Vlan.Mode == "" || strings.EqualFold(status.Vlan.Mode, backend.VlanUntagged):
+		inputs.VlanId = readInt(inputs.VlanId, 0, 0)
+	default:
+		inputs.VlanId = nil
+		inputs.Vlan = readVlan(nil, status.Vlan)
+	}
+	inputs.DHCPGuard = readBool(inputs.DHCPGuard, status.DHCPGuard)
+	inputs.RouterGuard = readBool(inputs.RouterGuard, status.RouterGuard)
+	if inputs.PortMirroring != nil || !strings.EqualFold(status.PortMirroring, defaultPortMirroring) {
+		if inputs.PortMirroring == nil || !strings.EqualFold(*inputs.PortMirroring, status.PortMirroring) {
+			portMirroring := status.PortMirroring
+			inputs.PortMirroring = &portMirroring
+		}
+	}
+	inputs.IeeePriorityTag = readBool(inputs.IeeePriorityTag, status.IeeePriorityTag)
+	inputs.VMQWeight = readInt(inputs.VMQWeight, status.VMQWeight, defaultVMQWeight)
+	return inputs
+}
+
+// adapterOutputs returns the state of the adapter with the given inputs.
+func adapterOutputs(inputs NetworkAdapterInputs, adapterId *string, adapter *backend.NetworkAdapter, status *backend.NetworkAdapterStatus) NetworkAdapterOutputs {
+	state := NetworkAdapterOutputs{NetworkAdapterInputs: inputs, AdapterId: adapterId}
+	if adapter.MacAddress != "" {
+		state.AssignedMacAddress = &adapter.MacAddress
+	}
+	state.GuestIpAddresses = status.IPAddresses
+	if status.Status != "" {
+		state.PortStatus = &status.Status
+	}
+	return state
+}
+
+func readInt(current *int, actual, defaultValue int) *int {
+	if current == nil && actual == defaultValue {
+		return nil
+	}
+	return &actual
+}
+
+func readBool(current *bool, actual bool) *bool {
+	if current == nil && !actual {
+		return nil
+	}
+	return &actual
+}
+
+// normalizeMAC returns a MAC address as 12 upper case hexadecimal digits.
+func normalizeMAC(mac string) string {
+	return strings.ToUpper(strings.NewReplacer("-", "", ":", "", ".", "").Replace(mac))
+}
@@ -0,0 +1,54 @@
+// Copyright 2016-2022, Pulumi Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package networkadapter
+
+import (
+	"testing"
+
+	"github.com/pulumi/pulumi-hyperv/provider/pkg/provider/backend"
+)
+
+func ptr[T any](v T) *T {
+	return &v
+}
+
+func TestReadInputs(t *testing.T) {
+	adapter := &backend.NetworkAdapter{Name: "nic", VMName: "web", SwitchName: "LAN", MacAddress: "00155D000001"}
+	defaults := &backend.NetworkAdapterStatus{PortMirroring: "None", VMQWeight: 100, Status: "Ok"}
+
+	// Settings that match the host are kept as written.
+	inputs := NetworkAdapterInputs{SwitchName: ptr("lan"), MacAddress: ptr("00-15-5d-00-00-01"), PortMirroring: ptr("none")}
+	read := readInputs(inputs, adapter, defaults)
+	if *read.SwitchName != "lan" || *read.MacAddress != "00-15-5d-00-00-01" || *read.PortMirroring != "none" {
+		t.Errorf("readInputs without drift = %+v", read)
+	}
+	if read.VlanId != nil || read.DHCPGuard != nil || read.VMQWeight != nil {
+		t.Errorf("readInputs recorded defaults: %+v", read)
+	}
+
+	// Changes made on the host replace them, and settings that left their default are recorded.
+	changed := &backend.NetworkAdapterStatus{Vlan: backend.VlanSettings{Mode: "Access", AccessVlanID: 20}, DHCPGuard: true, PortMirroring: "Source", VMQWeight: 0}
+	dynamic := &backend.NetworkAdapter{Name: "nic", VMName: "web", MacAddress: "00155D000002", DynamicMacAddress: true}
+	read = readInputs(inputs, dynamic, changed)
+	if *read.SwitchName != "" || read.MacAddress != nil || *read.VlanId != 20 || !*read.DHCPGuard ||
+		*read.PortMirroring != "Source" || *read.VMQWeight != 0 || read.RouterGuard != nil {
+		t.Errorf("readInputs with drift = %+v", read)
+	}
+
+	state := adapterOutputs(read, ptr("web/nic"), dynamic, changed)
+	if *state.AssignedMacAddress != "00155D000002" || state.PortStatus != nil {
+		t.Errorf("adapterOutputs = %+v", state)
+	}
+}
@@ -0,0 +1,194 @@
+// Copyright 2016-2022, Pulumi Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package networkadapter
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/pulumi/pulumi-hyperv/provider/pkg/provider/backend"
+)
+
+// vlanModes are the VLAN modes of a switch port, spelled the way Get-VMNetworkAdapterVlan
+// reports them.
+var vlanModes = []string{
+	backend.VlanUntagged, backend.VlanAccess, backend.VlanTrunk,
+	backend.VlanIsolated, backend.VlanCommunity, backend.VlanPromiscuous,
+}
+
+// vlanMode returns the spelling of a VLAN mode that Hyper-V uses.
+func vlanMode(mode string) (string, error) {
+	for _, m := range vlanModes {
+		if strings.EqualFold(mode, m) {
+			return m, nil
+		}
+	}
+	return "", fmt.Errorf("unsupported VLAN mode [%s], must be one of %s", mode, strings.Join(vlanModes, ", "))
+}
+
+// settings returns the VLAN the inputs describe. IDs that do not belong to the mode are
+// rejected, so a mode change does not silently ignore them.
+func (v *Vlan) settings() (backend.VlanSettings, error) {
+	var settings backend.VlanSettings
+	if v.Mode == nil {
+		return settings, fmt.Errorf("vlan.mode is required")
+	}
+	mode, err := vlanMode(*v.Mode)
+	if err != nil {
+		return settings, err
+	}
+	settings.Mode = mode
+
+	used := map[string]bool{}
+	require := func(name string, id *int, min int) (int, error) {
+		used[name] = true
+		if id == nil {
+			return 0, fmt.Errorf("vlan.%s is required in %s mode", name, mode)
+		}
+		if *id < min || *id > 4094 {
+			return 0, fmt.Errorf("vlan.%s must be between %d and 4094", name, min)
+		}
+		return *id, nil
+	}
+	requireList := func(name string, ids []int) ([]int, error) {
+		used[name] = true
+		if len(ids) == 0 {
+			return nil, fmt.Errorf("vlan.%s is required in %s mode", name, mode)
+		}
+		for _, id := range ids {
+			if id < 1 || id > 4094 {
+				return nil, fmt.Errorf("vlan.%s must be between 1 and 4094, got %d", name, id)
+			}
+		}
+		return normalizeVlanIDs(ids), nil
+	}
+
+	switch mode {
+	case backend.VlanAccess:
+		settings.AccessVlanID, err = require("accessVlanId", v.AccessVlanId, 1)
+	case backend.VlanTrunk:
+		if v.NativeVlanId != nil {
+			settings.NativeVlanID, err = require("nativeVlanId", v.NativeVlanId, 0)
+		}
+		used["nativeVlanId"] = true
+		if err == nil {
+			settings.AllowedVlanIDs, err = requireList("allowedVlanIds", v.AllowedVlanIds)
+		}
+	case backend.VlanIsolated, backend.VlanCommunity:
+		settings.PrimaryVlanID, err = require("primaryVlanId", v.PrimaryVlanId, 1)
+		if err == nil {
+			settings.SecondaryVlanID, err = require("secondaryVlanId", v.SecondaryVlanId, 1)
+		}
+	case backend.VlanPromiscuous:
+		settings.PrimaryVlanID, err = require("primaryVlanId", v.PrimaryVlanId, 1)
+		if err == nil {
+			settings.SecondaryVlanIDs, err = requireList("secondaryVlanIds", v.SecondaryVlanIds)
+		}
+	}
+	if err != nil {
+		return settings, err
+	}
+
+	set := map[string]bool{
+		"accessVlanId":     v.AccessVlanId != nil,
+		"nativeVlanId":     v.NativeVlanId != nil,
+		"allowedVlanIds":   v.AllowedVlanIds != nil,
+		"primaryVlanId":    v.PrimaryVlanId != nil,
+		"secondaryVlanId":  v.SecondaryVlanId != nil,
+		"secondaryVlanIds": v.SecondaryVlanIds != nil,
+	}
+	var unused []string
+	for name, isSet := range set {
+		if isSet && !used[name] {
+			unused = append(unused, name)
+		}
+	}
+	if len(unused) > 0 {
+		sort.Strings(unused)
+		return settings, fmt.Errorf("vlan.%s cannot be set in %s mode", strings.Join(unused, ", vlan."), mode)
+	}
+	return settings, nil
+}
+
+// changedVlan returns the VLAN of news when it differs from olds. Like the other switch port
+// settings, removing the block returns the port to its default, untagged.
+func changedVlan(olds, news *Vlan) (*backend.VlanSettings, error) {
+	if news == nil {
+		if olds == nil || (olds.Mode != nil && strings.EqualFold(*olds.Mode, backend.VlanUntagged)) {
+			return nil, nil
+		}
+		return &backend.VlanSettings{Mode: backend.VlanUntagged}, nil
+	}
+	settings, err := news.settings()
+	if err != nil {
+		return nil, err
+	}
+	if olds != nil {
+		if old, err := olds.settings(); err == nil && reflect.DeepEqual(old, settings) {
+			return nil, nil
+		}
+	}
+	return &settings, nil
+}
+
+// readVlan returns the VLAN of the port as inputs. The mode and lists of IDs that match the
+// port are kept as written, and the native VLAN of a trunk is only recorded when it was set
+// or is not 0.
+func readVlan(current *Vlan, actual backend.VlanSettings) *Vlan {
+	if current == nil {
+		current = &Vlan{}
+	}
+	mode := actual.Mode
+	if current.Mode != nil && strings.EqualFold(*current.Mode, actual.Mode) {
+		mode = *current.Mode
+	}
+	vlan := &Vlan{Mode: &mode}
+	switch {
+	case strings.EqualFold(actual.Mode, backend.VlanAccess):
+		vlan.AccessVlanId = &actual.AccessVlanID
+	case strings.EqualFold(actual.Mode, backend.VlanTrunk):
+		vlan.NativeVlanId = readInt(current.NativeVlanId, actual.NativeVlanID, 0)
+		vlan.AllowedVlanIds = readVlanList(current.AllowedVlanIds, actual.AllowedVlanIDs)
+	case strings.EqualFold(actual.Mode, backend.VlanIsolated), strings.EqualFold(actual.Mode, backend.VlanCommunity):
+		vlan.PrimaryVlanId = &actual.PrimaryVlanID
+		vlan.SecondaryVlanId = &actual.SecondaryVlanID
+	case strings.EqualFold(actual.Mode, backend.VlanPromiscuous):
+		vlan.PrimaryVlanId = &actual.PrimaryVlanID
+		vlan.SecondaryVlanIds = readVlanList(current.SecondaryVlanIds, actual.SecondaryVlanIDs)
+	}
+	return vlan
+}
+
+// readVlanList returns the IDs of the port, in the order of current when they are the same.
+func readVlanList(current []int, actual []int) []int {
+	if reflect.DeepEqual(normalizeVlanIDs(current), normalizeVlanIDs(actual)) {
+		return current
+	}
+	return normalizeVlanIDs(actual)
+}
+
+func normalizeVlanIDs(ids []int) []int {
+	sorted := append([]int(nil), ids...)
+	sort.Ints(sorted)
+	unique := sorted[:0]
+	for i, id := range sorted {
+		if i == 0 || id != sorted[i-1] {
+			unique = append(unique, id)
+		}
+	}
+	return unique
+}
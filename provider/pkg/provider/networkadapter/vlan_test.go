@@ -0,0 +1,105 @@
+// Copyright 2016-2022, Pulumi Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package networkadapter
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/pulumi/pulumi-hyperv/provider/pkg/provider/backend"
+)
+
+func TestVlanList(t *testing.T) {
+	if list := backend.FormatVlanList([]int{200, 3, 1, 2, 5, 5}); list != "1-3,5,200" {
+		t.Errorf("FormatVlanList = %q", list)
+	}
+}
+
+func TestVlanSettings(t *testing.T) {
+	trunk := &Vlan{Mode: ptr("trunk"), AllowedVlanIds: []int{12, 10, 11, 10}, NativeVlanId: ptr(1)}
+	settings, err := trunk.settings()
+	want := backend.VlanSettings{Mode: backend.VlanTrunk, NativeVlanID: 1, AllowedVlanIDs: []int{10, 11, 12}}
+	if err != nil || !reflect.DeepEqual(settings, want) {
+		t.Errorf("settings of a trunk = %+v, %v", settings, err)
+	}
+
+	isolated := &Vlan{Mode: ptr("Isolated"), PrimaryVlanId: ptr(100), SecondaryVlanId: ptr(101)}
+	if settings, err = isolated.settings(); err != nil {
+		t.Fatal(err)
+	}
+	if settings.PrimaryVlanID != 100 || settings.SecondaryVlanID != 101 {
+		t.Errorf("settings of an isolated port = %+v", settings)
+	}
+
+	for name, invalid := range map[string]*Vlan{
+		"unknown mode":      {Mode: ptr("Tagged")},
+		"missing access ID": {Mode: ptr("Access")},
+		"ID of other mode":  {Mode: ptr("Access"), AccessVlanId: ptr(10), AllowedVlanIds: []int{1, 2}},
+		"missing secondary": {Mode: ptr("Community"), PrimaryVlanId: ptr(100)},
+		"promiscuous list":  {Mode: ptr("Promiscuous"), PrimaryVlanId: ptr(100), SecondaryVlanIds: []int{0}},
+		"empty trunk":       {Mode: ptr("Trunk"), AllowedVlanIds: []int{}},
+		"trunk ID":          {Mode: ptr("Trunk"), AllowedVlanIds: []int{10, 4095}},
+	} {
+		if _, err := invalid.settings(); err == nil {
+			t.Errorf("settings with %s succeeded", name)
+		}
+	}
+}
+
+func TestChangedVlan(t *testing.T) {
+	olds := &Vlan{Mode: ptr("Trunk"), AllowedVlanIds: []int{10, 11, 12}}
+	if changed, err := changedVlan(olds, &Vlan{Mode: ptr("trunk"), AllowedVlanIds: []int{12, 11, 10}}); err != nil || changed != nil {
+		t.Errorf("changedVlan of the same trunk = %+v, %v", changed, err)
+	}
+	if changed, err := changedVlan(olds, nil); err != nil || changed == nil || changed.Mode != backend.VlanUntagged {
+		t.Errorf("changedVlan of a removed vlan = %+v, %v, want the port untagged", changed, err)
+	}
+	if changed, err := changedVlan(&Vlan{Mode: ptr("untagged")}, nil); err != nil || changed != nil {
+		t.Errorf("changedVlan of a removed untagged vlan = %+v, %v", changed, err)
+	}
+	changed, err := changedVlan(olds, &Vlan{Mode: ptr("Trunk"), AllowedVlanIds: []int{10, 11, 12, 13}})
+	if err != nil || changed == nil || len(changed.AllowedVlanIDs) != 4 {
+		t.Errorf("changedVlan of another trunk = %+v, %v", changed, err)
+	}
+}
+
+func TestReadVlan(t *testing.T) {
+	adapter := &backend.NetworkAdapter{Name: "nic", VMName: "router", SwitchName: "LAN"}
+	trunk := &backend.NetworkAdapterStatus{
+		Vlan:          backend.VlanSettings{Mode: backend.VlanTrunk, AllowedVlanIDs: []int{10, 11, 12, 20}},
+		PortMirroring: "None", VMQWeight: 100,
+	}
+
+	// A list that matches the port is kept as written.
+	inputs := NetworkAdapterInputs{SwitchName: ptr("LAN"), Vlan: &Vlan{Mode: ptr("trunk"), AllowedVlanIds: []int{20, 10, 11, 12}}}
+	read := readInputs(inputs, adapter, trunk)
+	if *read.Vlan.Mode != "trunk" || !reflect.DeepEqual(read.Vlan.AllowedVlanIds, []int{20, 10, 11, 12}) || read.Vlan.NativeVlanId != nil || read.VlanId != nil {
+		t.Errorf("readInputs of a trunk = %+v", read.Vlan)
+	}
+
+	// A port that vlanId cannot describe is read into vlan.
+	read = readInputs(NetworkAdapterInputs{SwitchName: ptr("LAN"), VlanId: ptr(10)}, adapter, trunk)
+	if read.VlanId != nil || read.Vlan == nil || *read.Vlan.Mode != "Trunk" || !reflect.DeepEqual(read.Vlan.AllowedVlanIds, []int{10, 11, 12, 20}) {
+		t.Errorf("readInputs of a trunk changed on the host = %+v, %+v", read.VlanId, read.Vlan)
+	}
+
+	promiscuous := *trunk
+	promiscuous.Vlan = backend.VlanSettings{Mode: backend.VlanPromiscuous, PrimaryVlanID: 100, SecondaryVlanIDs: []int{201, 202}}
+	read = readInputs(inputs, adapter, &promiscuous)
+	if *read.Vlan.Mode != "Promiscuous" || *read.Vlan.PrimaryVlanId != 100 || !reflect.DeepEqual(read.Vlan.SecondaryVlanIds, []int{201, 202}) ||
+		read.Vlan.AllowedVlanIds != nil {
+		t.Errorf("readInputs of a promiscuous port = %+v", read.Vlan)
+	}
+}
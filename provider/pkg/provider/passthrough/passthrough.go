@@ -0,0 +1,335 @@
+// Copyright 2016-2022, Pulumi Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package passthrough attaches physical disks of the host to virtual machines. The WMI
+// calls it needs are behind the Session interface, so the selection and validation logic
+// is pure Go and can be tested against a fake.
+package passthrough
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Properties of the Msvm_ResourceAllocationSettingData that attaches a physical disk.
+const (
+	ResourceTypeDiskDrive = 17
+	ResourceTypeDVDDrive  = 16
+	ResourceSubType       = "Microsoft:Hyper-V:Physical Disk Drive"
+)
+
+// Controller types a disk can be attached to.
+const (
+	ControllerSCSI = "SCSI"
+	ControllerIDE  = "IDE"
+)
+
+// Slots per controller, as Hyper-V allows them.
+const (
+	scsiLocations = 64
+	ideLocations  = 2
+)
+
+// Disk is a physical disk of the host, as reported by MSFT_Disk.
+type Disk struct {
+	Number       uint32
+	UniqueID     string
+	FriendlyName string
+	Size         uint64
+	IsOffline    bool
+	// IsSystem is set for disks that hold the boot or system volume of the host.
+	IsSystem bool
+}
+
+// Name returns a description of the disk for messages.
+func (d Disk) Name() string {
+	if d.FriendlyName != "" {
+		return fmt.Sprintf("disk %d (%s)", d.Number, d.FriendlyName)
+	}
+	return fmt.Sprintf("disk %d", d.Number)
+}
+
+// Controller is a disk controller of a virtual machine.
+type Controller struct {
+	// Path is the instance path of its Msvm_ResourceAllocationSettingData.
+	Path string
+}
+
+// Drive is a drive of a virtual machine, attached to one of its controllers.
+type Drive struct {
+	// Path is the instance path of its Msvm_ResourceAllocationSettingData.
+	Path            string
+	ResourceType    uint16
+	ResourceSubType string
+	// Parent is the instance path of the controller the drive is attached to.
+	Parent          string
+	AddressOnParent string
+	HostResource    []string
+}
+
+// DriveSettings are the properties set on the default Msvm_ResourceAllocationSettingData of
+// the physical disk drive pool to attach a disk.
+type DriveSettings struct {
+	ResourceType    uint16
+	ResourceSubType string
+	Parent          string
+	AddressOnParent string
+	HostResource    []string
+}
+
+// Session is the part of WMI that attaching physical disks needs.
+type Session interface {
+	// HostDisks returns the physical disks of the host.
+	HostDisks() ([]Disk, error)
+	// HostDiskDrive returns the instance path of the Msvm_DiskDrive that represents the
+	// physical disk with the given number in the virtualization namespace.
+	HostDiskDrive(number uint32) (string, error)
+	// Controllers returns the controllers of the given type of a virtual machine, ordered by
+	// controller number.
+	Controllers(vmName, controllerType string) ([]Controller, error)
+	// Drives returns the disk and DVD drives of a virtual machine.
+	Drives(vmName string) ([]Drive, error)
+	// AddDrive adds a drive with the given settings to a virtual machine.
+	AddDrive(vmName string, settings DriveSettings) error
+	// RemoveDrive removes the drive with the given instance path from a virtual machine.
+	RemoveDrive(vmName string, path string) error
+}
+
+// Spec identifies a physical disk and the slot to attach it to. Exactly one of DiskNumber
+// and UniqueID must be set.
+type Spec struct {
+	DiskNumber *int
+	UniqueID   *string
+	// ControllerType defaults to SCSI.
+	ControllerType   string
+	ControllerNumber int
+	// ControllerLocation selects the slot on the controller. The first free slot is used
+	// when it is nil.
+	ControllerLocation *int
+}
+
+// String describes the disk the spec identifies.
+func (s Spec) String() string {
+	switch {
+	case s.DiskNumber != nil:
+		return fmt.Sprintf("disk number %d", *s.DiskNumber)
+	case s.UniqueID != nil:
+		return fmt.Sprintf("disk with unique ID %s", *s.UniqueID)
+	default:
+		return "unidentified disk"
+	}
+}
+
+// Validate checks the spec without looking at the host.
+func (s Spec) Validate() error {
+	if (s.DiskNumber == nil) == (s.UniqueID == nil || *s.UniqueID == "") {
+		return fmt.Errorf("a pass-through disk needs exactly one of diskNumber and uniqueId")
+	}
+	if s.DiskNumber != nil && *s.DiskNumber < 0 {
+		return fmt.Errorf("diskNumber cannot be negative")
+	}
+	controllerType, err := normalizeControllerType(s.ControllerType)
+	if err != nil {
+		return err
+	}
+	if s.ControllerNumber < 0 {
+		return fmt.Errorf("controllerNumber cannot be negative")
+	}
+	if s.ControllerLocation != nil && (*s.ControllerLocation < 0 || *s.ControllerLocation >= locations(controllerType)) {
+		return fmt.Errorf("controllerLocation must be between 0 and %d for %s controllers", locations(controllerType)-1, controllerType)
+	}
+	return nil
+}
+
+func normalizeControllerType(controllerType string) (string, error) {
+	switch {
+	case controllerType == "", strings.EqualFold(controllerType, ControllerSCSI):
+		return ControllerSCSI, nil
+	case strings.EqualFold(controllerType, ControllerIDE):
+		return ControllerIDE, nil
+	default:
+		return "", fmt.Errorf("unsupported controller type [%s], must be %s or %s", controllerType, ControllerSCSI, ControllerIDE)
+	}
+}
+
+func locations(controllerType string) int {
+	if controllerType == ControllerIDE {
+		return ideLocations
+	}
+	return scsiLocations
+}
+
+// FindDisk returns the disk of the host that spec identifies. The disk must be offline on
+// the host, because Hyper-V can only give a virtual machine exclusive access to offline
+// disks, and must not hold the system volume of the host.
+func FindDisk(disks []Disk, spec Spec) (Disk, error) {
+	if err := spec.Validate(); err != nil {
+		return Disk{}, err
+	}
+	for _, d := range disks {
+		if spec.DiskNumber != nil && d.Number != uint32(*spec.DiskNumber) {
+			continue
+		}
+		if spec.UniqueID != nil && !strings.EqualFold(strings.TrimSpace(d.UniqueID), strings.TrimSpace(*spec.UniqueID)) {
+			continue
+		}
+		if d.IsSystem {
+			return Disk{}, fmt.Errorf("%s holds the system volume of the host and cannot be passed through", d.Name())
+		}
+		if !d.IsOffline {
+			return Disk{}, fmt.Errorf("%s is online on the host. Take it offline first, for example with Set-Disk -Number %d -IsOffline $true", d.Name(), d.Number)
+		}
+		return d, nil
+	}
+	return Disk{}, fmt.Errorf("%s was not found on the host", spec)
+}
+
+// Lookup returns the disk of the host that spec identifies by number or unique ID, or nil
+// when there is none. Unlike FindDisk it does not check that the disk can be passed through.
+func Lookup(disks []Disk, spec Spec) *Disk {
+	for i, d := range disks {
+		if (spec.DiskNumber != nil && d.Number == uint32(*spec.DiskNumber)) ||
+			(spec.UniqueID != nil && strings.EqualFold(strings.TrimSpace(d.UniqueID), strings.TrimSpace(*spec.UniqueID))) {
+			return &disks[i]
+		}
+	}
+	return nil
+}
+
+// FindAttached returns the pass-through drive of vmName that uses the host disk drive at
+// hostDrive, or nil when the disk is not attached.
+func FindAttached(drives []Drive, hostDrive string) *Drive {
+	for i := range drives {
+		if drives[i].ResourceSubType != ResourceSubType {
+			continue
+		}
+		for _, r := range drives[i].HostResource {
+			if samePath(r, hostDrive) {
+				return &drives[i]
+			}
+		}
+	}
+	return nil
+}
+
+// Attach attaches the disk that spec identifies to vmName and returns it. A disk that is
+// already attached to the virtual machine is left as it is.
+func Attach(s Session, vmName string, spec Spec) (Disk, error) {
+	disks, err := s.HostDisks()
+	if err != nil {
+		return Disk{}, fmt.Errorf("failed to list the disks of the host: %w", err)
+	}
+	disk, err := FindDisk(disks, spec)
+	if err != nil {
+		return Disk{}, err
+	}
+	hostDrive, err := s.HostDiskDrive(disk.Number)
+	if err != nil {
+		return Disk{}, fmt.Errorf("failed to find %s in the virtualization namespace: %w", disk.Name(), err)
+	}
+
+	drives, err := s.Drives(vmName)
+	if err != nil {
+		return Disk{}, fmt.Errorf("failed to list the drives of VM %s: %w", vmName, err)
+	}
+	if FindAttached(drives, hostDrive) != nil {
+		return disk, nil
+	}
+
+	controllerType, _ := normalizeControllerType(spec.ControllerType)
+	controllers, err := s.Controllers(vmName, controllerType)
+	if err != nil {
+		return Disk{}, fmt.Errorf("failed to list the %s controllers of VM %s: %w", controllerType, vmName, err)
+	}
+	if spec.ControllerNumber >= len(controllers) {
+		return Disk{}, fmt.Errorf("VM %s has %d %s controllers, controller %d does not exist", vmName, len(controllers), controllerType, spec.ControllerNumber)
+	}
+	controller := controllers[spec.ControllerNumber]
+	location, err := pickLocation(drives, controller, controllerType, spec)
+	if err != nil {
+		return Disk{}, fmt.Errorf("cannot attach %s to VM %s: %w", disk.Name(), vmName, err)
+	}
+
+	err = s.AddDrive(vmName, DriveSettings{
+		ResourceType:    ResourceTypeDiskDrive,
+		ResourceSubType: ResourceSubType,
+		Parent:          controller.Path,
+		AddressOnParent: fmt.Sprintf("%d", location),
+		HostResource:    []string{hostDrive},
+	})
+	if err != nil {
+		return Disk{}, fmt.Errorf("failed to attach %s to VM %s: %w", disk.Name(), vmName, err)
+	}
+	return disk, nil
+}
+
+// pickLocation returns the slot of controller to attach to: the requested one if it is
+// free, or the first free slot.
+func pickLocation(drives []Drive, controller Controller, controllerType string, spec Spec) (int, error) {
+	used := map[string]bool{}
+	for _, d := range drives {
+		if samePath(d.Parent, controller.Path) {
+			used[d.AddressOnParent] = true
+		}
+	}
+	if spec.ControllerLocation != nil {
+		if used[fmt.Sprintf("%d", *spec.ControllerLocation)] {
+			return 0, fmt.Errorf("location %d of %s controller %d is in use", *spec.ControllerLocation, controllerType, spec.ControllerNumber)
+		}
+		return *spec.ControllerLocation, nil
+	}
+	for location := 0; location < locations(controllerType); location++ {
+		if !used[fmt.Sprintf("%d", location)] {
+			return location, nil
+		}
+	}
+	return 0, fmt.Errorf("%s controller %d has no free location", controllerType, spec.ControllerNumber)
+}
+
+// Detach removes the pass-through drive that uses the disk spec identifies from vmName.
+// Disks that are not attached are ignored. The disk does not need to be offline.
+func Detach(s Session, vmName string, spec Spec) error {
+	if err := spec.Validate(); err != nil {
+		return err
+	}
+	disks, err := s.HostDisks()
+	if err != nil {
+		return fmt.Errorf("failed to list the disks of the host: %w", err)
+	}
+	disk := Lookup(disks, spec)
+	if disk == nil {
+		return nil
+	}
+	hostDrive, err := s.HostDiskDrive(disk.Number)
+	if err != nil {
+		return fmt.Errorf("failed to find %s in the virtualization namespace: %w", disk.Name(), err)
+	}
+	drives, err := s.Drives(vmName)
+	if err != nil {
+		return fmt.Errorf("failed to list the drives of VM %s: %w", vmName, err)
+	}
+	attached := FindAttached(drives, hostDrive)
+	if attached == nil {
+		return nil
+	}
+	if err := s.RemoveDrive(vmName, attached.Path); err != nil {
+		return fmt.Errorf("failed to detach %s from VM %s: %w", disk.Name(), vmName, err)
+	}
+	return nil
+}
+
+// samePath compares WMI instance paths, which are case insensitive.
+func samePath(a, b string) bool {
+	return strings.EqualFold(a, b)
+}
@@ -0,0 +1,179 @@
+// Copyright 2016-2022, Pulumi Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package passthrough
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const hostDrive2 = `\\HOST\root\virtualization\v2:Msvm_DiskDrive.CreationClassName="Msvm_DiskDrive",DeviceID="Microsoft:2"`
+
+// fakeSession is a Session with one VM that has two SCSI controllers.
+type fakeSession struct {
+	disks   []Disk
+	drives  []Drive
+	added   []DriveSettings
+	removed []string
+}
+
+func newFakeSession() *fakeSession {
+	return &fakeSession{
+		disks: []Disk{
+			{Number: 0, UniqueID: "SYS0", FriendlyName: "Boot", IsSystem: true},
+			{Number: 1, UniqueID: "ONLINE1", FriendlyName: "Data"},
+			{Number: 2, UniqueID: "6002248E3C1F", FriendlyName: "Scratch", IsOffline: true},
+		},
+		drives: []Drive{
+			{Path: "drive-os", ResourceType: ResourceTypeDiskDrive, ResourceSubType: "Microsoft:Hyper-V:Synthetic Disk Drive", Parent: "scsi-0", AddressOnParent: "0"},
+		},
+	}
+}
+
+func (f *fakeSession) HostDisks() ([]Disk, error) { return f.disks, nil }
+
+func (f *fakeSession) HostDiskDrive(number uint32) (string, error) {
+	if number == 2 {
+		return hostDrive2, nil
+	}
+	return "", fmt.Errorf("no Msvm_DiskDrive for disk %d", number)
+}
+
+func (f *fakeSession) Controllers(vmName, controllerType string) ([]Controller, error) {
+	if controllerType != ControllerSCSI {
+		return nil, nil
+	}
+	return []Controller{{Path: "scsi-0"}, {Path: "scsi-1"}}, nil
+}
+
+func (f *fakeSession) Drives(vmName string) ([]Drive, error) { return f.drives, nil }
+
+func (f *fakeSession) AddDrive(vmName string, settings DriveSettings) error {
+	f.added = append(f.added, settings)
+	f.drives = append(f.drives, Drive{
+		Path:            fmt.Sprintf("drive-%d", len(f.drives)),
+		ResourceType:    settings.ResourceType,
+		ResourceSubType: settings.ResourceSubType,
+		Parent:          settings.Parent,
+		AddressOnParent: settings.AddressOnParent,
+		HostResource:    settings.HostResource,
+	})
+	return nil
+}
+
+func (f *fakeSession) RemoveDrive(vmName, path string) error {
+	f.removed = append(f.removed, path)
+	for i, d := range f.drives {
+		if d.Path == path {
+			f.drives = append(f.drives[:i], f.drives[i+1:]...)
+			break
+		}
+	}
+	return nil
+}
+
+func intPtr(i int) *int          { return &i }
+func stringPtr(s string) *string { return &s }
+
+func TestAttach(t *testing.T) {
+	s := newFakeSession()
+	disk, err := Attach(s, "vm", Spec{DiskNumber: intPtr(2)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if disk.Number != 2 {
+		t.Errorf("Attach returned disk %d, want 2", disk.Number)
+	}
+	want := DriveSettings{
+		ResourceType:    ResourceTypeDiskDrive,
+		ResourceSubType: ResourceSubType,
+		Parent:          "scsi-0",
+		AddressOnParent: "1",
+		HostResource:    []string{hostDrive2},
+	}
+	if len(s.added) != 1 || !reflect.DeepEqual(s.added[0], want) {
+		t.Fatalf("added %+v, want %+v", s.added, want)
+	}
+
+	// Attaching again leaves the drive as it is.
+	if _, err := Attach(s, "vm", Spec{DiskNumber: intPtr(2)}); err != nil {
+		t.Fatal(err)
+	}
+	if len(s.added) != 1 {
+		t.Errorf("second Attach added %d drives", len(s.added)-1)
+	}
+}
+
+func TestAttachByUniqueIDAndLocation(t *testing.T) {
+	s := newFakeSession()
+	spec := Spec{UniqueID: stringPtr("6002248e3c1f"), ControllerType: "scsi", ControllerNumber: 1, ControllerLocation: intPtr(5)}
+	if _, err := Attach(s, "vm", spec); err != nil {
+		t.Fatal(err)
+	}
+	if len(s.added) != 1 || s.added[0].Parent != "scsi-1" || s.added[0].AddressOnParent != "5" {
+		t.Errorf("added %+v, want controller scsi-1 location 5", s.added)
+	}
+}
+
+func TestAttachErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		spec Spec
+		want string
+	}{
+		{"online", Spec{DiskNumber: intPtr(1)}, "online on the host"},
+		{"system", Spec{DiskNumber: intPtr(0)}, "system volume"},
+		{"missing", Spec{UniqueID: stringPtr("NOPE")}, "was not found"},
+		{"no identifier", Spec{}, "exactly one of"},
+		{"both identifiers", Spec{DiskNumber: intPtr(2), UniqueID: stringPtr("6002248E3C1F")}, "exactly one of"},
+		{"controller", Spec{DiskNumber: intPtr(2), ControllerNumber: 2}, "does not exist"},
+		{"location in use", Spec{DiskNumber: intPtr(2), ControllerLocation: intPtr(0)}, "is in use"},
+		{"location range", Spec{DiskNumber: intPtr(2), ControllerType: "IDE", ControllerLocation: intPtr(2)}, "between 0 and 1"},
+		{"controller type", Spec{DiskNumber: intPtr(2), ControllerType: "NVMe"}, "unsupported controller type"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newFakeSession()
+			_, err := Attach(s, "vm", tt.spec)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("Attach error = %v, want it to contain %q", err, tt.want)
+			}
+			if len(s.added) != 0 {
+				t.Errorf("Attach added %+v despite the error", s.added)
+			}
+		})
+	}
+}
+
+func TestDetach(t *testing.T) {
+	s := newFakeSession()
+	if _, err := Attach(s, "vm", Spec{DiskNumber: intPtr(2)}); err != nil {
+		t.Fatal(err)
+	}
+	if err := Detach(s, "vm", Spec{UniqueID: stringPtr("6002248E3C1F")}); err != nil {
+		t.Fatal(err)
+	}
+	if len(s.removed) != 1 || s.removed[0] != "drive-1" {
+		t.Errorf("removed %v, want [drive-1]", s.removed)
+	}
+	if err := Detach(s, "vm", Spec{DiskNumber: intPtr(2)}); err != nil || len(s.removed) != 1 {
+		t.Errorf("Detach of a detached disk = %v, removed %v", err, s.removed)
+	}
+	if err := Detach(s, "vm", Spec{DiskNumber: intPtr(7)}); err != nil {
+		t.Errorf("Detach of a missing disk = %v", err)
+	}
+}
@@ -18,9 +18,14 @@ import (
 	p "github.com/pulumi/pulumi-go-provider"
 	"github.com/pulumi/pulumi-go-provider/infer"
 	"github.com/pulumi/pulumi-go-provider/middleware/schema"
+	"github.com/pulumi/pulumi-hyperv/provider/pkg/provider/backend"
 	"github.com/pulumi/pulumi-hyperv/provider/pkg/provider/common"
+	"github.com/pulumi/pulumi-hyperv/provider/pkg/provider/config"
+	"github.com/pulumi/pulumi-hyperv/provider/pkg/provider/harddiskdrive"
+	"github.com/pulumi/pulumi-hyperv/provider/pkg/provider/host"
 	"github.com/pulumi/pulumi-hyperv/provider/pkg/provider/machine"
 	"github.com/pulumi/pulumi-hyperv/provider/pkg/provider/networkadapter"
+	"github.com/pulumi/pulumi-hyperv/provider/pkg/provider/unattendfile"
 	"github.com/pulumi/pulumi-hyperv/provider/pkg/provider/util"
 	"github.com/pulumi/pulumi-hyperv/provider/pkg/provider/vhdfile"
 	"github.com/pulumi/pulumi-hyperv/provider/pkg/provider/virtualswitch"
@@ -35,6 +40,9 @@ func NewProvider() p.Provider {
 	// Check if Hyper-V is supported on this system
 	util.CheckHyperVSupport()
 
+	// Resources that use a backend connect to the host in the provider configuration.
+	backend.SetConnector(common.Connect)
+
 	return infer.Provider(infer.Options{
 		// This is the metadata for the provider
 		Metadata: schema.Metadata{
@@ -84,7 +92,7 @@ func NewProvider() p.Provider {
 				},
 			},
 		},
-		Config: infer.Config[common.Config](),
+		Config: infer.Config[config.Config](),
 		// A list of `infer.Resource` that are provided by the provider.
 		Resources: []infer.InferredResource{
 			// The hyperv resource implementation is commented extensively for new pulumi-go-provider developers.
@@ -113,11 +121,28 @@ func NewProvider() p.Provider {
 				networkadapter.NetworkAdapterInputs,
 				networkadapter.NetworkAdapterOutputs,
 			](),
+			infer.Resource[
+				*harddiskdrive.HardDiskDrive,
+				harddiskdrive.HardDiskDriveInputs,
+				harddiskdrive.HardDiskDriveOutputs,
+			](),
+			infer.Resource[
+				*unattendfile.UnattendFile,
+				unattendfile.UnattendFileInputs,
+				unattendfile.UnattendFileOutputs,
+			](),
 		},
 		// Functions or invokes that are provided by the provider.
 		Functions: []infer.InferredFunction{
-			// The Run function is commented extensively for new pulumi-go-provider developers.
-			//infer.Function[](),
+			infer.Function[*host.GetHostCapabilities, host.GetHostCapabilitiesArgs, host.HostCapabilities](),
+			infer.Function[*host.GetHostInfo, host.GetHostInfoArgs, host.HostInfo](),
+			infer.Function[*host.ListPhysicalNetAdapters, host.ListPhysicalNetAdaptersArgs, host.ListPhysicalNetAdaptersResult](),
+			infer.Function[*host.GetVirtualMachine, host.GetVirtualMachineArgs, host.VirtualMachineDetails](),
+			infer.Function[*host.ListVirtualMachines, host.ListVirtualMachinesArgs, host.ListVirtualMachinesResult](),
+			infer.Function[*host.GetVirtualSwitch, host.GetVirtualSwitchArgs, host.VirtualSwitch](),
+			infer.Function[*host.ListVirtualSwitches, host.ListVirtualSwitchesArgs, host.ListVirtualSwitchesResult](),
+			infer.Function[*host.GetVhd, host.GetVhdArgs, host.Vhd](),
+			infer.Function[*host.GenerateProgram, host.GenerateProgramArgs, host.GenerateProgramResult](),
 		},
 	})
 }
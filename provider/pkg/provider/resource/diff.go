@@ -0,0 +1,68 @@
+// Copyright 2016-2022, Pulumi Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package resource holds the inputs that all resources of the provider share.
+package resource
+
+import (
+	"reflect"
+	"strings"
+
+	p "github.com/pulumi/pulumi-go-provider"
+)
+
+// DiffInputs records a detailed diff entry for every top-level input of olds and news that
+// differs, descending into embedded structs such as resource.Inputs. olds and news must be
+// values of the same struct type. Inputs listed in replace are reported as replacements.
+func DiffInputs(olds, news any, replace map[string]bool) map[string]p.PropertyDiff {
+	detailed := map[string]p.PropertyDiff{}
+	diffFields(reflect.ValueOf(olds), reflect.ValueOf(news), replace, detailed)
+	return detailed
+}
+
+func diffFields(olds, news reflect.Value, replace map[string]bool, detailed map[string]p.PropertyDiff) {
+	t := olds.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.Anonymous {
+			diffFields(olds.Field(i), news.Field(i), replace, detailed)
+			continue
+		}
+		name, _, _ := strings.Cut(field.Tag.Get("pulumi"), ",")
+		if name == "" || reflect.DeepEqual(olds.Field(i).Interface(), news.Field(i).Interface()) {
+			continue
+		}
+		kind := p.Update
+		if replace[name] {
+			kind = p.UpdateReplace
+		}
+		detailed[name] = p.PropertyDiff{Kind: kind, InputDiff: true}
+	}
+}
+
+// ReplacedInPlace reports whether detailed replaces the resource while the input name, which
+// says where the resource lives, is unchanged. The replacement then takes the place of the old
+// resource, so the old one must be deleted before it is created.
+func ReplacedInPlace(detailed map[string]p.PropertyDiff, name string) bool {
+	if _, moved := detailed[name]; moved {
+		return false
+	}
+	for _, d := range detailed {
+		switch d.Kind {
+		case p.UpdateReplace, p.AddReplace, p.DeleteReplace:
+			return true
+		}
+	}
+	return false
+}
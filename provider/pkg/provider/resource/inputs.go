@@ -1,9 +1,24 @@
-package common
+// Copyright 2016-2022, Pulumi Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package resource holds the inputs that all resources of the provider share.
+package resource
 
 import "github.com/pulumi/pulumi-go-provider/infer"
 
-// ResourceInputs are inputs common to resource CRUD operations.
-type ResourceInputs struct {
+// Inputs are inputs common to resource CRUD operations.
+type Inputs struct {
 	// The field tags are used to provide metadata on the schema representation.
 	// pulumi:"optional" specifies that a field is optional. This must be a pointer.
 	// provider:"replaceOnChanges" specifies that the resource will be replaced if the field changes.
@@ -16,7 +31,7 @@ type ResourceInputs struct {
 
 // Annotate lets you provide descriptions and default values for fields and they will
 // be visible in the provider's schema and the generated SDKs.
-func (c *ResourceInputs) Annotate(a infer.Annotator) {
+func (c *Inputs) Annotate(a infer.Annotator) {
 	a.Describe(&c.Triggers, `Trigger a resource replacement on changes to any of these values. The
 trigger values can be of any type. If a value is different in the current update compared to the
 previous update, the resource will be replaced, i.e., the "create" command will be re-run.
@@ -0,0 +1,231 @@
+// Copyright 2016-2022, Pulumi Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package unattend
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"strings"
+	"unicode/utf16"
+)
+
+// Geometry of a standard 1.44 MB floppy, which is what Hyper-V virtual floppy drives accept.
+const (
+	fatSectorSize       = 512
+	fatTotalSectors     = 2880
+	fatReservedSectors  = 1
+	fatCount            = 2
+	fatSectorsPerFAT    = 9
+	fatRootEntries      = 224
+	fatRootSectors      = fatRootEntries * 32 / fatSectorSize
+	fatFirstDataSector  = fatReservedSectors + fatCount*fatSectorsPerFAT + fatRootSectors
+	fatDataClusters     = fatTotalSectors - fatFirstDataSector
+	fatMediaDescriptor  = 0xF0
+	fatAttrReadOnly     = 0x01
+	fatAttrVolumeID     = 0x08
+	fatAttrArchive      = 0x20
+	fatAttrLongName     = 0x0F
+	fatLastLongEntry    = 0x40
+	fatEndOfChain       = 0xFFF
+	fatCharsPerLongName = 13
+)
+
+func setFAT12(fat []byte, cluster int, value uint16) {
+	off := cluster * 3 / 2
+	if cluster%2 == 0 {
+		fat[off] = byte(value)
+		fat[off+1] = (fat[off+1] & 0xF0) | byte(value>>8)&0x0F
+	} else {
+		fat[off] = (fat[off] & 0x0F) | byte(value<<4)
+		fat[off+1] = byte(value >> 4)
+	}
+}
+
+// shortName derives an 8.3 directory name. The boolean reports whether the short
+// name represents the original name exactly, in which case no long name is needed.
+func shortName(name string, seq int) ([11]byte, bool) {
+	var out [11]byte
+	for i := range out {
+		out[i] = ' '
+	}
+	base, ext := name, ""
+	if i := strings.LastIndex(name, "."); i > 0 {
+		base, ext = name[:i], name[i+1:]
+	}
+	clean := func(s string) string {
+		var b strings.Builder
+		for _, r := range strings.ToUpper(s) {
+			if (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') || strings.ContainsRune("!#$%&'()-@^_`{}~", r) {
+				b.WriteRune(r)
+			}
+		}
+		return b.String()
+	}
+	b, e := clean(base), clean(ext)
+	exact := b == base && e == ext && len(b) <= 8 && len(e) <= 3 && b != ""
+	if !exact {
+		suffix := fmt.Sprintf("~%d", seq)
+		if len(b) > 8-len(suffix) {
+			b = b[:8-len(suffix)]
+		}
+		b += suffix
+	}
+	if len(e) > 3 {
+		e = e[:3]
+	}
+	copy(out[:8], b)
+	copy(out[8:], e)
+	return out, exact
+}
+
+func shortNameChecksum(name [11]byte) byte {
+	var sum byte
+	for _, c := range name {
+		sum = (sum&1)<<7 + sum>>1 + c
+	}
+	return sum
+}
+
+// longNameEntries returns the VFAT long file name entries for name, in on-disk order.
+func longNameEntries(name string, checksum byte) [][]byte {
+	units := utf16.Encode([]rune(name))
+	count := (len(units) + fatCharsPerLongName - 1) / fatCharsPerLongName
+	padded := make([]uint16, count*fatCharsPerLongName)
+	for i := range padded {
+		switch {
+		case i < len(units):
+			padded[i] = units[i]
+		case i == len(units):
+			padded[i] = 0x0000
+		default:
+			padded[i] = 0xFFFF
+		}
+	}
+	offsets := []int{1, 3, 5, 7, 9, 14, 16, 18, 20, 22, 24, 28, 30}
+	entries := make([][]byte, 0, count)
+	for n := count; n >= 1; n-- {
+		e := make([]byte, 32)
+		e[0] = byte(n)
+		if n == count {
+			e[0] |= fatLastLongEntry
+		}
+		e[11] = fatAttrLongName
+		e[13] = checksum
+		for i, off := range offsets {
+			binary.LittleEndian.PutUint16(e[off:], padded[(n-1)*fatCharsPerLongName+i])
+		}
+		entries = append(entries, e)
+	}
+	return entries
+}
+
+// BuildFloppy creates a FAT12 formatted 1.44 MB virtual floppy image (.vfd) that
+// contains the given files, marked read-only, in its root directory.
+func BuildFloppy(label string, files []File) ([]byte, error) {
+	if len(files) == 0 {
+		return nil, fmt.Errorf("a floppy image needs at least one file")
+	}
+	img := make([]byte, fatTotalSectors*fatSectorSize)
+
+	var content []byte
+	for _, f := range files {
+		content = append(content, f.Data...)
+	}
+	serial := crc32.ChecksumIEEE(content)
+
+	var volLabel [11]byte
+	for i := range volLabel {
+		volLabel[i] = ' '
+	}
+	copy(volLabel[:], strings.ToUpper(isoLabel(label, 11)))
+
+	boot := img[:fatSectorSize]
+	copy(boot[0:3], []byte{0xEB, 0x3C, 0x90})
+	copy(boot[3:11], "MSWIN4.1")
+	binary.LittleEndian.PutUint16(boot[11:], fatSectorSize)
+	boot[13] = 1
+	binary.LittleEndian.PutUint16(boot[14:], fatReservedSectors)
+	boot[16] = fatCount
+	binary.LittleEndian.PutUint16(boot[17:], fatRootEntries)
+	binary.LittleEndian.PutUint16(boot[19:], fatTotalSectors)
+	boot[21] = fatMediaDescriptor
+	binary.LittleEndian.PutUint16(boot[22:], fatSectorsPerFAT)
+	binary.LittleEndian.PutUint16(boot[24:], 18)
+	binary.LittleEndian.PutUint16(boot[26:], 2)
+	boot[38] = 0x29
+	binary.LittleEndian.PutUint32(boot[39:], serial)
+	copy(boot[43:54], volLabel[:])
+	copy(boot[54:62], "FAT12   ")
+	boot[510], boot[511] = 0x55, 0xAA
+
+	fat := make([]byte, fatSectorsPerFAT*fatSectorSize)
+	setFAT12(fat, 0, 0xF00|fatMediaDescriptor)
+	setFAT12(fat, 1, fatEndOfChain)
+
+	root := make([]byte, 0, fatRootEntries*32)
+	labelEntry := make([]byte, 32)
+	copy(labelEntry, volLabel[:])
+	labelEntry[11] = fatAttrVolumeID
+	root = append(root, labelEntry...)
+
+	nextCluster := 2
+	for i, f := range files {
+		clusters := (len(f.Data) + fatSectorSize - 1) / fatSectorSize
+		if nextCluster-2+clusters > fatDataClusters {
+			return nil, fmt.Errorf("files do not fit on a 1.44 MB floppy image")
+		}
+		first := 0
+		if clusters > 0 {
+			first = nextCluster
+			for c := 0; c < clusters; c++ {
+				value := uint16(nextCluster + c + 1)
+				if c == clusters-1 {
+					value = fatEndOfChain
+				}
+				setFAT12(fat, nextCluster+c, value)
+			}
+			start := (fatFirstDataSector + nextCluster - 2) * fatSectorSize
+			copy(img[start:], f.Data)
+			nextCluster += clusters
+		}
+
+		short, exact := shortName(f.Name, i+1)
+		if !exact {
+			for _, e := range longNameEntries(f.Name, shortNameChecksum(short)) {
+				root = append(root, e...)
+			}
+		}
+		entry := make([]byte, 32)
+		copy(entry, short[:])
+		entry[11] = fatAttrArchive | fatAttrReadOnly
+		// 1980-01-01, the FAT epoch, keeps the image deterministic.
+		binary.LittleEndian.PutUint16(entry[16:], 0x21)
+		binary.LittleEndian.PutUint16(entry[18:], 0x21)
+		binary.LittleEndian.PutUint16(entry[24:], 0x21)
+		binary.LittleEndian.PutUint16(entry[26:], uint16(first))
+		binary.LittleEndian.PutUint32(entry[28:], uint32(len(f.Data)))
+		root = append(root, entry...)
+	}
+	if len(root) > fatRootEntries*32 {
+		return nil, fmt.Errorf("too many files for the floppy root directory")
+	}
+
+	for n := 0; n < fatCount; n++ {
+		copy(img[(fatReservedSectors+n*fatSectorsPerFAT)*fatSectorSize:], fat)
+	}
+	copy(img[(fatReservedSectors+fatCount*fatSectorsPerFAT)*fatSectorSize:], root)
+	return img, nil
+}
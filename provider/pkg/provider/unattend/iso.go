@@ -0,0 +1,238 @@
+// Copyright 2016-2022, Pulumi Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package unattend
+
+import (
+	"encoding/binary"
+	"fmt"
+	"sort"
+	"strings"
+	"unicode/utf16"
+)
+
+const isoSectorSize = 2048
+
+// File is a single file stored in the root directory of a generated image.
+type File struct {
+	Name string
+	Data []byte
+}
+
+// Sector layout of the generated ISO 9660 image. Only a root directory is supported,
+// which is all that is needed for answer file media.
+const (
+	isoPrimaryDescriptor = 16
+	isoJolietDescriptor  = 17
+	isoTerminator        = 18
+	isoPrimaryPathL      = 19
+	isoPrimaryPathM      = 20
+	isoJolietPathL       = 21
+	isoJolietPathM       = 22
+	isoPrimaryRoot       = 23
+	isoJolietRoot        = 24
+	isoFirstData         = 25
+)
+
+func putBoth32(b []byte, v uint32) {
+	binary.LittleEndian.PutUint32(b[0:4], v)
+	binary.BigEndian.PutUint32(b[4:8], v)
+}
+
+func putBoth16(b []byte, v uint16) {
+	binary.LittleEndian.PutUint16(b[0:2], v)
+	binary.BigEndian.PutUint16(b[2:4], v)
+}
+
+func ucs2(s string) []byte {
+	units := utf16.Encode([]rune(s))
+	b := make([]byte, len(units)*2)
+	for i, u := range units {
+		binary.BigEndian.PutUint16(b[i*2:], u)
+	}
+	return b
+}
+
+// isoName converts a file name into an ISO 9660 identifier using d-characters.
+func isoName(name string) string {
+	upper := strings.ToUpper(name)
+	var b strings.Builder
+	for _, r := range upper {
+		switch {
+		case r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '_', r == '.':
+			b.WriteRune(r)
+		default:
+			b.WriteRune('_')
+		}
+	}
+	return b.String() + ";1"
+}
+
+// isoLabel converts a volume label into d-characters.
+func isoLabel(label string, max int) string {
+	name := strings.TrimSuffix(isoName(label), ";1")
+	name = strings.ReplaceAll(name, ".", "_")
+	if len(name) > max {
+		name = name[:max]
+	}
+	return name
+}
+
+func directoryRecord(extent, size uint32, flags byte, ident []byte) []byte {
+	length := 33 + len(ident)
+	if length%2 != 0 {
+		length++
+	}
+	rec := make([]byte, length)
+	rec[0] = byte(length)
+	putBoth32(rec[2:10], extent)
+	putBoth32(rec[10:18], size)
+	rec[25] = flags
+	putBoth16(rec[28:32], 1)
+	rec[32] = byte(len(ident))
+	copy(rec[33:], ident)
+	return rec
+}
+
+func pathTable(root uint32, bigEndian bool) []byte {
+	rec := make([]byte, 10)
+	rec[0] = 1
+	if bigEndian {
+		binary.BigEndian.PutUint32(rec[2:6], root)
+		binary.BigEndian.PutUint16(rec[6:8], 1)
+	} else {
+		binary.LittleEndian.PutUint32(rec[2:6], root)
+		binary.LittleEndian.PutUint16(rec[6:8], 1)
+	}
+	return rec
+}
+
+func fillText(b []byte, s string, joliet bool) {
+	if joliet {
+		for i := 0; i+1 < len(b); i += 2 {
+			b[i], b[i+1] = 0x00, ' '
+		}
+		copy(b, ucs2(s))
+		return
+	}
+	for i := range b {
+		b[i] = ' '
+	}
+	copy(b, s)
+}
+
+func volumeDescriptor(joliet bool, label string, totalSectors uint32, pathL, pathM uint32, root []byte) []byte {
+	d := make([]byte, isoSectorSize)
+	d[0] = 1
+	if joliet {
+		d[0] = 2
+	}
+	copy(d[1:6], "CD001")
+	d[6] = 1
+	fillText(d[8:40], "", joliet)
+	if joliet {
+		fillText(d[40:72], label, true)
+		// UCS-2 level 3 escape sequence identifies the Joliet descriptor.
+		copy(d[88:91], "%/E")
+	} else {
+		fillText(d[40:72], label, false)
+	}
+	putBoth32(d[80:88], totalSectors)
+	putBoth16(d[120:124], 1)
+	putBoth16(d[124:128], 1)
+	putBoth16(d[128:132], isoSectorSize)
+	putBoth32(d[132:140], 10)
+	binary.LittleEndian.PutUint32(d[140:144], pathL)
+	binary.BigEndian.PutUint32(d[148:152], pathM)
+	copy(d[156:190], root)
+	for _, field := range [][2]int{{190, 318}, {318, 446}, {446, 574}, {574, 702}, {702, 739}, {739, 776}, {776, 813}} {
+		fillText(d[field[0]:field[1]], "", joliet)
+	}
+	for _, off := range []int{813, 830, 847, 864} {
+		copy(d[off:off+16], "0000000000000000")
+	}
+	d[881] = 1
+	return d
+}
+
+// BuildISO creates an ISO 9660 image with Joliet extensions that contains the given
+// files in its root directory. Joliet preserves the exact file names for Windows.
+func BuildISO(label string, files []File) ([]byte, error) {
+	if len(files) == 0 {
+		return nil, fmt.Errorf("an ISO image needs at least one file")
+	}
+	sorted := append([]File(nil), files...)
+	sort.Slice(sorted, func(i, j int) bool { return isoName(sorted[i].Name) < isoName(sorted[j].Name) })
+
+	extents := make([]uint32, len(sorted))
+	next := uint32(isoFirstData)
+	for i, f := range sorted {
+		extents[i] = next
+		next += uint32((len(f.Data) + isoSectorSize - 1) / isoSectorSize)
+	}
+	total := next
+
+	buildRoot := func(self uint32, joliet bool) ([]byte, error) {
+		dir := make([]byte, 0, isoSectorSize)
+		dir = append(dir, directoryRecord(self, isoSectorSize, 2, []byte{0})...)
+		dir = append(dir, directoryRecord(self, isoSectorSize, 2, []byte{1})...)
+		for i, f := range sorted {
+			ident := []byte(isoName(f.Name))
+			if joliet {
+				ident = ucs2(f.Name)
+			}
+			dir = append(dir, directoryRecord(extents[i], uint32(len(f.Data)), 0, ident)...)
+		}
+		if len(dir) > isoSectorSize {
+			return nil, fmt.Errorf("too many files for a single-sector ISO root directory")
+		}
+		return dir, nil
+	}
+	primaryRoot, err := buildRoot(isoPrimaryRoot, false)
+	if err != nil {
+		return nil, err
+	}
+	jolietRoot, err := buildRoot(isoJolietRoot, true)
+	if err != nil {
+		return nil, err
+	}
+
+	img := make([]byte, int(total)*isoSectorSize)
+	sector := func(n uint32) []byte { return img[int(n)*isoSectorSize : int(n+1)*isoSectorSize] }
+
+	copy(sector(isoPrimaryDescriptor), volumeDescriptor(false, isoLabel(label, 32), total,
+		isoPrimaryPathL, isoPrimaryPathM, primaryRoot[:34]))
+	jolietLabel := label
+	if len(utf16.Encode([]rune(jolietLabel))) > 16 {
+		jolietLabel = string(utf16.Decode(utf16.Encode([]rune(jolietLabel))[:16]))
+	}
+	copy(sector(isoJolietDescriptor), volumeDescriptor(true, jolietLabel, total,
+		isoJolietPathL, isoJolietPathM, jolietRoot[:34]))
+	term := sector(isoTerminator)
+	term[0] = 255
+	copy(term[1:6], "CD001")
+	term[6] = 1
+
+	copy(sector(isoPrimaryPathL), pathTable(isoPrimaryRoot, false))
+	copy(sector(isoPrimaryPathM), pathTable(isoPrimaryRoot, true))
+	copy(sector(isoJolietPathL), pathTable(isoJolietRoot, false))
+	copy(sector(isoJolietPathM), pathTable(isoJolietRoot, true))
+	copy(sector(isoPrimaryRoot), primaryRoot)
+	copy(sector(isoJolietRoot), jolietRoot)
+
+	for i, f := range sorted {
+		copy(img[int(extents[i])*isoSectorSize:], f.Data)
+	}
+	return img, nil
+}
@@ -0,0 +1,45 @@
+// Copyright 2016-2022, Pulumi Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package unattend
+
+import "fmt"
+
+// Output formats supported by Package.
+const (
+	// FormatXML writes the answer file as-is, for injection into a disk image.
+	FormatXML = "xml"
+	// FormatISO wraps the answer file in an ISO image for a virtual DVD drive.
+	FormatISO = "iso"
+	// FormatFloppy wraps the answer file in a FAT12 image for a virtual floppy drive.
+	FormatFloppy = "vfd"
+)
+
+// VolumeLabel is the label given to generated media.
+const VolumeLabel = "UNATTEND"
+
+// Package wraps a rendered answer file in the requested output format.
+func Package(format string, document []byte) ([]byte, error) {
+	files := []File{{Name: FileName, Data: document}}
+	switch format {
+	case "", FormatXML:
+		return document, nil
+	case FormatISO:
+		return BuildISO(VolumeLabel, files)
+	case FormatFloppy:
+		return BuildFloppy(VolumeLabel, files)
+	default:
+		return nil, fmt.Errorf("unsupported format [%s], must be one of %s, %s or %s", format, FormatXML, FormatISO, FormatFloppy)
+	}
+}
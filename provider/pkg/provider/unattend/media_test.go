@@ -0,0 +1,133 @@
+// Copyright 2016-2022, Pulumi Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package unattend
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+	"unicode/utf16"
+)
+
+// readISORoot returns the files in the root directory described by the volume
+// descriptor in the given sector, keyed by their raw identifiers.
+func readISORoot(t *testing.T, img []byte, descriptor int, joliet bool) map[string][]byte {
+	t.Helper()
+	d := img[descriptor*isoSectorSize:]
+	if string(d[1:6]) != "CD001" {
+		t.Fatalf("sector %d is not a volume descriptor", descriptor)
+	}
+	rootExtent := binary.LittleEndian.Uint32(d[156+2:])
+	dir := img[int(rootExtent)*isoSectorSize : int(rootExtent+1)*isoSectorSize]
+	files := map[string][]byte{}
+	for off := 0; off < len(dir) && dir[off] != 0; off += int(dir[off]) {
+		rec := dir[off:]
+		ident := rec[33 : 33+int(rec[32])]
+		if rec[25]&2 != 0 {
+			continue
+		}
+		name := string(ident)
+		if joliet {
+			units := make([]uint16, len(ident)/2)
+			for i := range units {
+				units[i] = binary.BigEndian.Uint16(ident[i*2:])
+			}
+			name = string(utf16.Decode(units))
+		}
+		extent := binary.LittleEndian.Uint32(rec[2:])
+		size := binary.LittleEndian.Uint32(rec[10:])
+		files[name] = img[int(extent)*isoSectorSize : int(extent)*isoSectorSize+int(size)]
+	}
+	return files
+}
+
+func TestBuildISORoundTrip(t *testing.T) {
+	data := bytes.Repeat([]byte("<unattend/>"), 500)
+	img, err := Package(FormatISO, data)
+	if err != nil {
+		t.Fatalf("Package failed: %v", err)
+	}
+	if len(img)%isoSectorSize != 0 {
+		t.Fatalf("image size %d is not sector aligned", len(img))
+	}
+	if got := readISORoot(t, img, isoPrimaryDescriptor, false)["AUTOUNATTEND.XML;1"]; !bytes.Equal(got, data) {
+		t.Errorf("primary directory content mismatch")
+	}
+	if got := readISORoot(t, img, isoJolietDescriptor, true)[FileName]; !bytes.Equal(got, data) {
+		t.Errorf("Joliet directory content mismatch")
+	}
+	if img[isoTerminator*isoSectorSize] != 255 {
+		t.Errorf("missing volume descriptor set terminator")
+	}
+}
+
+func TestBuildFloppyRoundTrip(t *testing.T) {
+	data := bytes.Repeat([]byte("0123456789"), 120)
+	img, err := Package(FormatFloppy, data)
+	if err != nil {
+		t.Fatalf("Package failed: %v", err)
+	}
+	if len(img) != 1474560 {
+		t.Fatalf("unexpected image size %d", len(img))
+	}
+	if img[510] != 0x55 || img[511] != 0xAA || string(img[54:62]) != "FAT12   " {
+		t.Fatalf("invalid boot sector")
+	}
+
+	fat := img[fatReservedSectors*fatSectorSize:]
+	next := func(cluster int) int {
+		off := cluster * 3 / 2
+		v := int(binary.LittleEndian.Uint16(fat[off:]))
+		if cluster%2 == 0 {
+			return v & 0xFFF
+		}
+		return v >> 4
+	}
+
+	root := img[(fatReservedSectors+fatCount*fatSectorsPerFAT)*fatSectorSize:]
+	var longName []uint16
+	for off := 0; root[off] != 0; off += 32 {
+		e := root[off : off+32]
+		switch e[11] {
+		case fatAttrVolumeID:
+			continue
+		case fatAttrLongName:
+			var part []uint16
+			for _, o := range []int{1, 3, 5, 7, 9, 14, 16, 18, 20, 22, 24, 28, 30} {
+				u := binary.LittleEndian.Uint16(e[o:])
+				if u == 0 || u == 0xFFFF {
+					break
+				}
+				part = append(part, u)
+			}
+			longName = append(part, longName...)
+			continue
+		}
+		if got := string(utf16.Decode(longName)); got != FileName {
+			t.Errorf("long file name = %q", got)
+		}
+		size := int(binary.LittleEndian.Uint32(e[28:]))
+		var content []byte
+		for c := int(binary.LittleEndian.Uint16(e[26:])); c < fatEndOfChain; c = next(c) {
+			start := (fatFirstDataSector + c - 2) * fatSectorSize
+			content = append(content, img[start:start+fatSectorSize]...)
+		}
+		if !bytes.Equal(content[:size], data) {
+			t.Errorf("file content mismatch")
+		}
+		return
+	}
+	t.Fatalf("file entry not found")
+}
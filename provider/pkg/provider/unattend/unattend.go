@@ -0,0 +1,265 @@
+// Copyright 2016-2022, Pulumi Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package unattend renders Windows sysprep answer files (unattend.xml) and packages
+// them into media that can be attached to a virtual machine. The package is pure Go
+// so that it can be used and tested on any platform.
+package unattend
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+	"regexp"
+	"strings"
+	"text/template"
+	"unicode/utf16"
+)
+
+// DefaultLocale is used for every locale setting that is not explicitly provided.
+const DefaultLocale = "en-US"
+
+// DefaultArchitecture is the processor architecture used for the answer file components.
+const DefaultArchitecture = "amd64"
+
+// FileName is the name under which the answer file is stored on generated media.
+// Windows Setup searches the root of removable media for this name.
+const FileName = "Autounattend.xml"
+
+// Command is a single FirstLogonCommands entry.
+type Command struct {
+	CommandLine       string
+	Description       string
+	RequiresUserInput bool
+}
+
+// Settings holds the structured values rendered into the answer file.
+type Settings struct {
+	ComputerName           string
+	AdminPassword          string
+	AutoLogonCount         int
+	InputLocale            string
+	SystemLocale           string
+	UILanguage             string
+	UserLocale             string
+	TimeZone               string
+	ProductKey             string
+	RegisteredOwner        string
+	RegisteredOrganization string
+	Architecture           string
+	FirstLogonCommands     []Command
+}
+
+var (
+	computerNamePattern = regexp.MustCompile(`^[A-Za-z0-9-]{1,15}$`)
+	allDigitsPattern    = regexp.MustCompile(`^[0-9]+$`)
+	localePattern       = regexp.MustCompile(`^[A-Za-z]{2,3}(-[A-Za-z0-9]{2,8})*$`)
+	inputLocalePattern  = regexp.MustCompile(`^([0-9A-Fa-f]{4}:[0-9A-Fa-f]{8}|[A-Za-z]{2,3}(-[A-Za-z0-9]{2,8})*)(;([0-9A-Fa-f]{4}:[0-9A-Fa-f]{8}|[A-Za-z]{2,3}(-[A-Za-z0-9]{2,8})*))*$`)
+	productKeyPattern   = regexp.MustCompile(`^[A-Za-z0-9]{5}(-[A-Za-z0-9]{5}){4}$`)
+	architectures       = map[string]bool{"amd64": true, "x86": true, "arm64": true}
+)
+
+// withDefaults returns a copy of the settings with empty optional values filled in.
+func (s Settings) withDefaults() Settings {
+	if s.Architecture == "" {
+		s.Architecture = DefaultArchitecture
+	}
+	if s.UILanguage == "" {
+		s.UILanguage = DefaultLocale
+	}
+	if s.SystemLocale == "" {
+		s.SystemLocale = s.UILanguage
+	}
+	if s.UserLocale == "" {
+		s.UserLocale = s.UILanguage
+	}
+	if s.InputLocale == "" {
+		s.InputLocale = s.UILanguage
+	}
+	return s
+}
+
+// Validate checks the settings against the constraints enforced by Windows Setup.
+// Error messages never include secret values.
+func (s Settings) Validate() error {
+	s = s.withDefaults()
+
+	if s.ComputerName != "" && s.ComputerName != "*" {
+		if !computerNamePattern.MatchString(s.ComputerName) {
+			return fmt.Errorf("computerName [%s] must be 1-15 characters of letters, digits or hyphens, or \"*\" for a random name", s.ComputerName)
+		}
+		if allDigitsPattern.MatchString(s.ComputerName) {
+			return fmt.Errorf("computerName [%s] must not consist of digits only", s.ComputerName)
+		}
+	}
+	if !architectures[s.Architecture] {
+		return fmt.Errorf("architecture [%s] must be one of amd64, x86 or arm64", s.Architecture)
+	}
+	for name, value := range map[string]string{
+		"uiLanguage":   s.UILanguage,
+		"systemLocale": s.SystemLocale,
+		"userLocale":   s.UserLocale,
+	} {
+		if !localePattern.MatchString(value) {
+			return fmt.Errorf("%s [%s] is not a valid locale name such as en-US", name, value)
+		}
+	}
+	if !inputLocalePattern.MatchString(s.InputLocale) {
+		return fmt.Errorf("inputLocale [%s] must be a locale name or a language:keyboard pair such as 0409:00000409", s.InputLocale)
+	}
+	if strings.ContainsAny(s.TimeZone, "\r\n") || len(s.TimeZone) > 128 {
+		return fmt.Errorf("timeZone must be a single-line Windows time zone ID such as \"Pacific Standard Time\"")
+	}
+	if s.ProductKey != "" && !productKeyPattern.MatchString(s.ProductKey) {
+		return fmt.Errorf("productKey must have the form XXXXX-XXXXX-XXXXX-XXXXX-XXXXX")
+	}
+	if s.AutoLogonCount < 0 {
+		return fmt.Errorf("autoLogonCount must not be negative")
+	}
+	if s.AutoLogonCount > 0 && s.AdminPassword == "" {
+		return fmt.Errorf("autoLogonCount requires adminPassword to be set")
+	}
+	for i, cmd := range s.FirstLogonCommands {
+		if strings.TrimSpace(cmd.CommandLine) == "" {
+			return fmt.Errorf("firstLogonCommands[%d] has an empty commandLine", i)
+		}
+		if len(cmd.CommandLine) > 1024 {
+			return fmt.Errorf("firstLogonCommands[%d] commandLine exceeds 1024 characters", i)
+		}
+	}
+	return nil
+}
+
+// EncodePassword applies the encoding Windows System Image Manager uses for hidden
+// passwords: the password followed by the element name, as base64 of UTF-16LE.
+func EncodePassword(password, element string) string {
+	units := utf16.Encode([]rune(password + element))
+	buf := make([]byte, 0, len(units)*2)
+	for _, u := range units {
+		buf = append(buf, byte(u), byte(u>>8))
+	}
+	return base64.StdEncoding.EncodeToString(buf)
+}
+
+func escapeXML(s string) (string, error) {
+	var b bytes.Buffer
+	if err := xml.EscapeText(&b, []byte(s)); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
+
+var documentTemplate = template.Must(template.New("unattend").Funcs(template.FuncMap{
+	"x":      escapeXML,
+	"encode": EncodePassword,
+	"inc":    func(i int) int { return i + 1 },
+}).Parse(`<?xml version="1.0" encoding="utf-8"?>
+<unattend xmlns="urn:schemas-microsoft-com:unattend" xmlns:wcm="http://schemas.microsoft.com/WMIConfig/2002/State">
+  <settings pass="specialize">
+    <component name="Microsoft-Windows-Shell-Setup" processorArchitecture="{{.Architecture}}" publicKeyToken="31bf3856ad364e35" language="neutral" versionScope="nonSxS">
+{{- if .ComputerName}}
+      <ComputerName>{{x .ComputerName}}</ComputerName>
+{{- end}}
+{{- if .ProductKey}}
+      <ProductKey>{{x .ProductKey}}</ProductKey>
+{{- end}}
+{{- if .RegisteredOwner}}
+      <RegisteredOwner>{{x .RegisteredOwner}}</RegisteredOwner>
+{{- end}}
+{{- if .RegisteredOrganization}}
+      <RegisteredOrganization>{{x .RegisteredOrganization}}</RegisteredOrganization>
+{{- end}}
+{{- if .TimeZone}}
+      <TimeZone>{{x .TimeZone}}</TimeZone>
+{{- end}}
+    </component>
+  </settings>
+  <settings pass="oobeSystem">
+    <component name="Microsoft-Windows-International-Core" processorArchitecture="{{.Architecture}}" publicKeyToken="31bf3856ad364e35" language="neutral" versionScope="nonSxS">
+      <InputLocale>{{x .InputLocale}}</InputLocale>
+      <SystemLocale>{{x .SystemLocale}}</SystemLocale>
+      <UILanguage>{{x .UILanguage}}</UILanguage>
+      <UserLocale>{{x .UserLocale}}</UserLocale>
+    </component>
+    <component name="Microsoft-Windows-Shell-Setup" processorArchitecture="{{.Architecture}}" publicKeyToken="31bf3856ad364e35" language="neutral" versionScope="nonSxS">
+      <OOBE>
+        <HideEULAPage>true</HideEULAPage>
+        <HideLocalAccountScreen>true</HideLocalAccountScreen>
+        <HideOnlineAccountScreens>true</HideOnlineAccountScreens>
+        <HideWirelessSetupInOOBE>true</HideWirelessSetupInOOBE>
+        <ProtectYourPC>3</ProtectYourPC>
+      </OOBE>
+{{- if .AdminPassword}}
+      <UserAccounts>
+        <AdministratorPassword>
+          <Value>{{encode .AdminPassword "AdministratorPassword"}}</Value>
+          <PlainText>false</PlainText>
+        </AdministratorPassword>
+      </UserAccounts>
+{{- end}}
+{{- if gt .AutoLogonCount 0}}
+      <AutoLogon>
+        <Enabled>true</Enabled>
+        <LogonCount>{{.AutoLogonCount}}</LogonCount>
+        <Username>Administrator</Username>
+        <Password>
+          <Value>{{encode .AdminPassword "Password"}}</Value>
+          <PlainText>false</PlainText>
+        </Password>
+      </AutoLogon>
+{{- end}}
+{{- if .FirstLogonCommands}}
+      <FirstLogonCommands>
+{{- range $i, $cmd := .FirstLogonCommands}}
+        <SynchronousCommand wcm:action="add">
+          <Order>{{inc $i}}</Order>
+          <CommandLine>{{x $cmd.CommandLine}}</CommandLine>
+{{- if $cmd.Description}}
+          <Description>{{x $cmd.Description}}</Description>
+{{- end}}
+          <RequiresUserInput>{{$cmd.RequiresUserInput}}</RequiresUserInput>
+        </SynchronousCommand>
+{{- end}}
+      </FirstLogonCommands>
+{{- end}}
+    </component>
+  </settings>
+</unattend>
+`))
+
+// Render validates the settings and renders the answer file. The rendered document is
+// checked for well-formedness before it is returned.
+func Render(s Settings) ([]byte, error) {
+	if err := s.Validate(); err != nil {
+		return nil, err
+	}
+	s = s.withDefaults()
+
+	var buf bytes.Buffer
+	if err := documentTemplate.Execute(&buf, s); err != nil {
+		return nil, fmt.Errorf("failed to render unattend document: %v", err)
+	}
+
+	var doc struct {
+		XMLName  xml.Name `xml:"urn:schemas-microsoft-com:unattend unattend"`
+		Settings []struct {
+			Pass string `xml:"pass,attr"`
+		} `xml:"settings"`
+	}
+	if err := xml.Unmarshal(buf.Bytes(), &doc); err != nil {
+		return nil, fmt.Errorf("rendered unattend document is not well-formed: %v", err)
+	}
+	return buf.Bytes(), nil
+}
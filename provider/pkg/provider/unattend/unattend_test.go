@@ -0,0 +1,116 @@
+// Copyright 2016-2022, Pulumi Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package unattend
+
+import (
+	"encoding/xml"
+	"strings"
+	"testing"
+)
+
+func TestRenderProducesValidDocument(t *testing.T) {
+	doc, err := Render(Settings{
+		ComputerName:   "web-01",
+		AdminPassword:  "P@ss<word>&",
+		AutoLogonCount: 1,
+		UILanguage:     "de-DE",
+		TimeZone:       "W. Europe Standard Time",
+		ProductKey:     "AAAAA-BBBBB-CCCCC-DDDDD-EEEEE",
+		FirstLogonCommands: []Command{
+			{CommandLine: `cmd /c echo "a & b" > C:\out.txt`, Description: "first"},
+			{CommandLine: "shutdown /r /t 0"},
+		},
+	})
+	if err != nil {
+		t.Fatalf("Render failed: %v", err)
+	}
+
+	var parsed struct {
+		Settings []struct {
+			Pass       string `xml:"pass,attr"`
+			Components []struct {
+				Name         string `xml:"name,attr"`
+				ComputerName string `xml:"ComputerName"`
+				UILanguage   string `xml:"UILanguage"`
+				SystemLocale string `xml:"SystemLocale"`
+				Password     string `xml:"UserAccounts>AdministratorPassword>Value"`
+				PlainText    string `xml:"UserAccounts>AdministratorPassword>PlainText"`
+				Commands     []struct {
+					Order       int    `xml:"Order"`
+					CommandLine string `xml:"CommandLine"`
+				} `xml:"FirstLogonCommands>SynchronousCommand"`
+			} `xml:"component"`
+		} `xml:"settings"`
+	}
+	if err := xml.Unmarshal(doc, &parsed); err != nil {
+		t.Fatalf("rendered document does not parse: %v", err)
+	}
+	if strings.Contains(string(doc), "P@ss") {
+		t.Fatalf("rendered document contains the plain text password")
+	}
+	if len(parsed.Settings) != 2 || parsed.Settings[0].Pass != "specialize" || parsed.Settings[1].Pass != "oobeSystem" {
+		t.Fatalf("unexpected passes: %+v", parsed.Settings)
+	}
+	if got := parsed.Settings[0].Components[0].ComputerName; got != "web-01" {
+		t.Errorf("ComputerName = %q", got)
+	}
+	intl := parsed.Settings[1].Components[0]
+	if intl.UILanguage != "de-DE" || intl.SystemLocale != "de-DE" {
+		t.Errorf("locale defaults not applied: %+v", intl)
+	}
+	shell := parsed.Settings[1].Components[1]
+	if shell.Password != EncodePassword("P@ss<word>&", "AdministratorPassword") || shell.PlainText != "false" {
+		t.Errorf("administrator password not encoded: %q", shell.Password)
+	}
+	if len(shell.Commands) != 2 || shell.Commands[1].Order != 2 || shell.Commands[0].CommandLine != `cmd /c echo "a & b" > C:\out.txt` {
+		t.Errorf("unexpected first logon commands: %+v", shell.Commands)
+	}
+}
+
+func TestEncodePassword(t *testing.T) {
+	// Value produced by Windows System Image Manager for the password "abc".
+	if got := EncodePassword("abc", "Password"); got != "YQBiAGMAUABhAHMAcwB3AG8AcgBkAA==" {
+		t.Errorf("EncodePassword = %q", got)
+	}
+}
+
+func TestValidateRejectsInvalidSettings(t *testing.T) {
+	cases := map[string]Settings{
+		"long computer name":   {ComputerName: "this-name-is-too-long"},
+		"numeric computer":     {ComputerName: "12345"},
+		"bad locale":           {UILanguage: "english"},
+		"bad product key":      {ProductKey: "SECRET-KEY"},
+		"autologon without pw": {AutoLogonCount: 1},
+		"empty command":        {FirstLogonCommands: []Command{{CommandLine: " "}}},
+		"bad architecture":     {Architecture: "sparc"},
+	}
+	for name, s := range cases {
+		t.Run(name, func(t *testing.T) {
+			err := s.Validate()
+			if err == nil {
+				t.Fatalf("expected validation error")
+			}
+			if s.ProductKey != "" && strings.Contains(err.Error(), s.ProductKey) {
+				t.Fatalf("error leaks product key: %v", err)
+			}
+		})
+	}
+}
+
+func TestPackageRejectsUnknownFormat(t *testing.T) {
+	if _, err := Package("zip", []byte("x")); err == nil {
+		t.Fatalf("expected error for unknown format")
+	}
+}
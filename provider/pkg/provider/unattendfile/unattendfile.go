@@ -0,0 +1,102 @@
+// Copyright 2016-2022, Pulumi Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package unattendfile
+
+import (
+	_ "embed"
+
+	"github.com/pulumi/pulumi-go-provider/infer"
+	"github.com/pulumi/pulumi-hyperv/provider/pkg/provider/resource"
+)
+
+//go:embed unattendfile.md
+var resourceDoc string
+
+// This is the type that implements the UnattendFile resource methods.
+// The methods are declared in the unattendfileController.go file.
+type UnattendFile struct{}
+
+// The following statement is not required. It is a type assertion to indicate to Go that UnattendFile
+// implements the following interfaces. If the function signature doesn't match or isn't implemented,
+// we get nice compile time errors at this location.
+
+var _ = (infer.Annotated)((*UnattendFile)(nil))
+
+// Implementing Annotate lets you provide descriptions and default values for resources and they will
+// be visible in the provider's schema and the generated SDKs.
+func (c *UnattendFile) Annotate(a infer.Annotator) {
+	a.Describe(&c, resourceDoc)
+}
+
+// FirstLogonCommand is a command that Windows runs the first time a user logs on.
+type FirstLogonCommand struct {
+	CommandLine       *string `pulumi:"commandLine"`
+	Description       *string `pulumi:"description,optional"`
+	RequiresUserInput *bool   `pulumi:"requiresUserInput,optional"`
+}
+
+func (c *FirstLogonCommand) Annotate(a infer.Annotator) {
+	a.Describe(&c.CommandLine, "Command line to run.")
+	a.Describe(&c.Description, "Description of the command.")
+	a.Describe(&c.RequiresUserInput, "Whether the command requires user input. Defaults to false.")
+}
+
+// These are the inputs (or arguments) to an UnattendFile resource.
+type UnattendFileInputs struct {
+	resource.Inputs
+	Path                   *string              `pulumi:"path"`
+	Format                 *string              `pulumi:"format,optional"`
+	ComputerName           *string              `pulumi:"computerName,optional"`
+	AdminPassword          *string              `pulumi:"adminPassword,optional" provider:"secret"`
+	AutoLogonCount         *int                 `pulumi:"autoLogonCount,optional"`
+	Locale                 *string              `pulumi:"locale,optional"`
+	InputLocale            *string              `pulumi:"inputLocale,optional"`
+	TimeZone               *string              `pulumi:"timeZone,optional"`
+	ProductKey             *string              `pulumi:"productKey,optional" provider:"secret"`
+	RegisteredOwner        *string              `pulumi:"registeredOwner,optional"`
+	RegisteredOrganization *string              `pulumi:"registeredOrganization,optional"`
+	Architecture           *string              `pulumi:"architecture,optional"`
+	FirstLogonCommands     []*FirstLogonCommand `pulumi:"firstLogonCommands,optional"`
+}
+
+func (c *UnattendFileInputs) Annotate(a infer.Annotator) {
+	a.Describe(&c.Path, "Path of the file to write on the Hyper-V host.")
+	a.Describe(&c.Format, "Output format: xml writes the answer file for injection into an image, iso writes a DVD image and vfd writes a floppy image. Defaults to xml.")
+	a.Describe(&c.ComputerName, "Computer name of the guest. Use * for a random name. At most 15 characters.")
+	a.Describe(&c.AdminPassword, "Password of the built-in Administrator account.")
+	a.Describe(&c.AutoLogonCount, "Number of times the Administrator account logs on automatically, so that firstLogonCommands run unattended. Defaults to 0.")
+	a.Describe(&c.Locale, "Locale used for the UI language, system locale and user locale, such as en-US. Defaults to en-US.")
+	a.Describe(&c.InputLocale, "Keyboard layout, either a locale name or a language:keyboard pair such as 0409:00000409. Defaults to locale.")
+	a.Describe(&c.TimeZone, "Windows time zone ID, such as Pacific Standard Time.")
+	a.Describe(&c.ProductKey, "Product key in the form XXXXX-XXXXX-XXXXX-XXXXX-XXXXX.")
+	a.Describe(&c.RegisteredOwner, "Registered owner of the Windows installation.")
+	a.Describe(&c.RegisteredOrganization, "Registered organization of the Windows installation.")
+	a.Describe(&c.Architecture, "Processor architecture of the guest: amd64, x86 or arm64. Defaults to amd64.")
+	a.Describe(&c.FirstLogonCommands, "Commands to run, in order, the first time a user logs on.")
+}
+
+// These are the outputs (or properties) of an UnattendFile resource.
+// The secret inputs are cleared before the state is saved; SecretsDigest is kept instead
+// so that changes to them can still be detected.
+type UnattendFileOutputs struct {
+	UnattendFileInputs
+	SizeBytes     *int64  `pulumi:"sizeBytes,optional"`
+	SecretsDigest *string `pulumi:"secretsDigest,optional" provider:"secret"`
+}
+
+func (c *UnattendFileOutputs) Annotate(a infer.Annotator) {
+	a.Describe(&c.SizeBytes, "Size of the written file in bytes.")
+	a.Describe(&c.SecretsDigest, "Salted digest of the secret inputs, used to detect changes without storing them.")
+}
@@ -0,0 +1,184 @@
+// Copyright 2016-2022, Pulumi Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package unattendfile
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	p "github.com/pulumi/pulumi-go-provider"
+	"github.com/pulumi/pulumi-go-provider/infer"
+	"github.com/pulumi/pulumi/sdk/v3/go/common/resource"
+
+	"github.com/pulumi/pulumi-hyperv/provider/pkg/provider/logging"
+	hvresource "github.com/pulumi/pulumi-hyperv/provider/pkg/provider/resource"
+	"github.com/pulumi/pulumi-hyperv/provider/pkg/provider/unattend"
+)
+
+// UnattendFileController implements the controller methods for UnattendFile.
+// The actual UnattendFile type is defined in unattendfile.go.
+
+// The following statements are type assertions to indicate to Go that UnattendFile implements the interfaces.
+var _ = (infer.CustomResource[UnattendFileInputs, UnattendFileOutputs])((*UnattendFile)(nil))
+var _ = (infer.CustomCheck[UnattendFileInputs])((*UnattendFile)(nil))
+var _ = (infer.CustomDiff[UnattendFileInputs, UnattendFileOutputs])((*UnattendFile)(nil))
+var _ = (infer.CustomRead[UnattendFileInputs, UnattendFileOutputs])((*UnattendFile)(nil))
+var _ = (infer.CustomUpdate[UnattendFileInputs, UnattendFileOutputs])((*UnattendFile)(nil))
+var _ = (infer.CustomDelete[UnattendFileOutputs])((*UnattendFile)(nil))
+
+// replaceProperties are the inputs whose change requires a new file rather than rewriting it.
+var replaceProperties = map[string]bool{
+	"path":     true,
+	"format":   true,
+	"triggers": true,
+}
+
+// Check validates the inputs before any file is written.
+func (c *UnattendFile) Check(ctx context.Context, name string, oldInputs, newInputs resource.PropertyMap) (UnattendFileInputs, []p.CheckFailure, error) {
+	inputs, failures, err := infer.DefaultCheck[UnattendFileInputs](ctx, newInputs)
+	if err != nil || len(failures) > 0 {
+		return inputs, failures, err
+	}
+	if inputs.Path == nil || *inputs.Path == "" {
+		failures = append(failures, p.CheckFailure{Property: "path", Reason: "path must not be empty"})
+	}
+	switch format(inputs) {
+	case unattend.FormatXML, unattend.FormatISO, unattend.FormatFloppy:
+	default:
+		failures = append(failures, p.CheckFailure{Property: "format", Reason: fmt.Sprintf("format must be one of %s, %s or %s", unattend.FormatXML, unattend.FormatISO, unattend.FormatFloppy)})
+	}
+	// Unknown values are only validated once they are resolved.
+	if !newInputs.ContainsUnknowns() {
+		if err := settings(inputs).Validate(); err != nil {
+			failures = append(failures, p.CheckFailure{Reason: err.Error()})
+		}
+	}
+	return inputs, failures, nil
+}
+
+// Diff compares the saved state with the new inputs. Secret inputs are not saved, so they
+// are compared through the digest recorded in state. A file replaced at the same path is
+// deleted first, since deleting the old file afterwards would remove the new one.
+func (c *UnattendFile) Diff(ctx context.Context, id string, olds UnattendFileOutputs, news UnattendFileInputs) (p.DiffResponse, error) {
+	detailed := hvresource.DiffInputs(withoutSecrets(olds.UnattendFileInputs), withoutSecrets(news), replaceProperties)
+	for _, name := range changedSecrets(olds, news) {
+		detailed[name] = p.PropertyDiff{Kind: p.Update, InputDiff: true}
+	}
+	return p.DiffResponse{
+		HasChanges:          len(detailed) > 0,
+		DetailedDiff:        detailed,
+		DeleteBeforeReplace: hvresource.ReplacedInPlace(detailed, "path"),
+	}, nil
+}
+
+// write renders the answer file, packages it and writes it to the requested path.
+// Neither the rendered content nor the secret inputs are logged.
+func (c *UnattendFile) write(ctx context.Context, input UnattendFileInputs) (UnattendFileOutputs, error) {
+	logger := logging.GetLogger(ctx)
+	state := UnattendFileOutputs{UnattendFileInputs: withoutSecrets(input)}
+
+	document, err := unattend.Render(settings(input))
+	if err != nil {
+		return state, err
+	}
+	content, err := unattend.Package(format(input), document)
+	if err != nil {
+		return state, err
+	}
+
+	path := *input.Path
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return state, fmt.Errorf("failed to create directory for unattend file [%s]: %v", path, err)
+	}
+	// Write to a temporary file first so a failed write never leaves a partial answer file behind.
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".unattend-*")
+	if err != nil {
+		return state, fmt.Errorf("failed to create temporary file for [%s]: %v", path, err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(content); err != nil {
+		tmp.Close()
+		return state, fmt.Errorf("failed to write unattend file [%s]: %v", path, err)
+	}
+	if err := tmp.Close(); err != nil {
+		return state, fmt.Errorf("failed to write unattend file [%s]: %v", path, err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return state, fmt.Errorf("failed to move unattend file into place at [%s]: %v", path, err)
+	}
+
+	digest, err := secretsDigest(input, "")
+	if err != nil {
+		return state, fmt.Errorf("failed to compute secrets digest: %v", err)
+	}
+	size := int64(len(content))
+	state.SizeBytes = &size
+	state.SecretsDigest = &digest
+	logger.Infof("Wrote unattend file [%s] in %s format (%d bytes)", path, format(input), size)
+	return state, nil
+}
+
+// This is the Create method. This will be run on every UnattendFile resource creation.
+func (c *UnattendFile) Create(ctx context.Context, name string, input UnattendFileInputs, preview bool) (string, UnattendFileOutputs, error) {
+	id := name
+	if preview {
+		return id, UnattendFileOutputs{UnattendFileInputs: withoutSecrets(input)}, nil
+	}
+	state, err := c.write(ctx, input)
+	return id, state, err
+}
+
+// Read checks that the file still exists. A missing file is reported as deleted so that
+// the next update recreates it.
+func (c *UnattendFile) Read(ctx context.Context, id string, inputs UnattendFileInputs, state UnattendFileOutputs) (string, UnattendFileInputs, UnattendFileOutputs, error) {
+	logger := logging.GetLogger(ctx)
+	if state.Path == nil {
+		return id, inputs, state, nil
+	}
+	info, err := os.Stat(*state.Path)
+	if os.IsNotExist(err) {
+		logger.Infof("Unattend file [%s] no longer exists", *state.Path)
+		return "", inputs, state, nil
+	}
+	if err != nil {
+		return id, inputs, state, fmt.Errorf("failed to read unattend file [%s]: %v", *state.Path, err)
+	}
+	size := info.Size()
+	state.SizeBytes = &size
+	return id, inputs, state, nil
+}
+
+// Update re-renders the file in place.
+func (c *UnattendFile) Update(ctx context.Context, id string, olds UnattendFileOutputs, news UnattendFileInputs, preview bool) (UnattendFileOutputs, error) {
+	if preview {
+		return UnattendFileOutputs{UnattendFileInputs: withoutSecrets(news), SecretsDigest: olds.SecretsDigest}, nil
+	}
+	return c.write(ctx, news)
+}
+
+// Delete removes the file. A file that is already gone is not an error.
+func (c *UnattendFile) Delete(ctx context.Context, id string, state UnattendFileOutputs) error {
+	logger := logging.GetLogger(ctx)
+	if state.Path == nil {
+		return fmt.Errorf("path is nil")
+	}
+	logger.Infof("Deleting unattend file [%s]", *state.Path)
+	if err := os.Remove(*state.Path); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to delete unattend file [%s]: %v", *state.Path, err)
+	}
+	return nil
+}
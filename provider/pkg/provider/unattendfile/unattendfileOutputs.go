@@ -0,0 +1,124 @@
+// Copyright 2016-2022, Pulumi Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package unattendfile
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+
+	"github.com/pulumi/pulumi-hyperv/provider/pkg/provider/unattend"
+)
+
+func deref[T any](v *T) T {
+	var zero T
+	if v == nil {
+		return zero
+	}
+	return *v
+}
+
+// settings converts the resource inputs into the settings rendered by the unattend package.
+func settings(in UnattendFileInputs) unattend.Settings {
+	s := unattend.Settings{
+		ComputerName:           deref(in.ComputerName),
+		AdminPassword:          deref(in.AdminPassword),
+		AutoLogonCount:         deref(in.AutoLogonCount),
+		UILanguage:             deref(in.Locale),
+		InputLocale:            deref(in.InputLocale),
+		TimeZone:               deref(in.TimeZone),
+		ProductKey:             deref(in.ProductKey),
+		RegisteredOwner:        deref(in.RegisteredOwner),
+		RegisteredOrganization: deref(in.RegisteredOrganization),
+		Architecture:           deref(in.Architecture),
+	}
+	for _, cmd := range in.FirstLogonCommands {
+		if cmd == nil {
+			continue
+		}
+		s.FirstLogonCommands = append(s.FirstLogonCommands, unattend.Command{
+			CommandLine:       deref(cmd.CommandLine),
+			Description:       deref(cmd.Description),
+			RequiresUserInput: deref(cmd.RequiresUserInput),
+		})
+	}
+	return s
+}
+
+// format returns the requested output format, defaulting to a plain answer file.
+func format(in UnattendFileInputs) string {
+	if in.Format == nil || *in.Format == "" {
+		return unattend.FormatXML
+	}
+	return strings.ToLower(*in.Format)
+}
+
+// secretInputs lists the secret inputs by property name, in digest order.
+func secretInputs(in UnattendFileInputs) []struct {
+	name  string
+	value *string
+} {
+	return []struct {
+		name  string
+		value *string
+	}{
+		{"adminPassword", in.AdminPassword},
+		{"productKey", in.ProductKey},
+	}
+}
+
+// secretsDigest returns salted SHA-256 digests of the secret inputs in the form
+// "<salt>:<adminPassword digest>:<productKey digest>". An empty salt generates a new one.
+func secretsDigest(in UnattendFileInputs, salt string) (string, error) {
+	if salt == "" {
+		b := make([]byte, 16)
+		if _, err := rand.Read(b); err != nil {
+			return "", err
+		}
+		salt = hex.EncodeToString(b)
+	}
+	parts := []string{salt}
+	for _, secret := range secretInputs(in) {
+		digest := ""
+		if secret.value != nil {
+			sum := sha256.Sum256([]byte(salt + "\x00" + secret.name + "\x00" + *secret.value))
+			digest = hex.EncodeToString(sum[:])
+		}
+		parts = append(parts, digest)
+	}
+	return strings.Join(parts, ":"), nil
+}
+
+// changedSecrets returns the names of the secret inputs that differ from the ones
+// recorded in state.
+func changedSecrets(state UnattendFileOutputs, in UnattendFileInputs) []string {
+	recorded := strings.Split(deref(state.SecretsDigest), ":")
+	current, err := secretsDigest(in, recorded[0])
+	var changed []string
+	for i, secret := range secretInputs(in) {
+		if err != nil || len(recorded) != 3 || strings.Split(current, ":")[i+1] != recorded[i+1] {
+			changed = append(changed, secret.name)
+		}
+	}
+	return changed
+}
+
+// withoutSecrets returns a copy of the inputs that is safe to save as state.
+func withoutSecrets(in UnattendFileInputs) UnattendFileInputs {
+	in.AdminPassword = nil
+	in.ProductKey = nil
+	return in
+}
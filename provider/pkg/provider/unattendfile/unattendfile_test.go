@@ -0,0 +1,75 @@
+// Copyright 2016-2022, Pulumi Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package unattendfile
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	p "github.com/pulumi/pulumi-go-provider"
+
+	"github.com/pulumi/pulumi-hyperv/provider/pkg/provider/unattend"
+)
+
+func ptr[T any](v T) *T {
+	return &v
+}
+
+func TestDiff(t *testing.T) {
+	in := UnattendFileInputs{Path: ptr(`C:\vms\web\unattend.xml`), Format: ptr(unattend.FormatXML), ComputerName: ptr("web")}
+	digest, err := secretsDigest(in, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	olds := UnattendFileOutputs{UnattendFileInputs: in, SecretsDigest: &digest}
+	tests := []struct {
+		name                string
+		change              func(*UnattendFileInputs)
+		want                map[string]p.DiffKind
+		deleteBeforeReplace bool
+	}{
+		{"unchanged", func(*UnattendFileInputs) {}, map[string]p.DiffKind{}, false},
+		{"computer name", func(in *UnattendFileInputs) { in.ComputerName = ptr("db") }, map[string]p.DiffKind{"computerName": p.Update}, false},
+		{"format", func(in *UnattendFileInputs) { in.Format = ptr(unattend.FormatISO) }, map[string]p.DiffKind{"format": p.UpdateReplace}, true},
+		{"triggers", func(in *UnattendFileInputs) { in.Triggers = &[]any{"1"} }, map[string]p.DiffKind{"triggers": p.UpdateReplace}, true},
+		{"path", func(in *UnattendFileInputs) { in.Path = ptr(`C:\vms\db\unattend.xml`) }, map[string]p.DiffKind{"path": p.UpdateReplace}, false},
+		{"path and format", func(in *UnattendFileInputs) {
+			in.Path = ptr(`C:\vms\web\unattend.iso`)
+			in.Format = ptr(unattend.FormatISO)
+		}, map[string]p.DiffKind{"path": p.UpdateReplace, "format": p.UpdateReplace}, false},
+		{"admin password", func(in *UnattendFileInputs) { in.AdminPassword = ptr("secret") }, map[string]p.DiffKind{"adminPassword": p.Update}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			news := olds.UnattendFileInputs
+			tt.change(&news)
+			diff, err := (&UnattendFile{}).Diff(context.Background(), "web", olds, news)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := map[string]p.DiffKind{}
+			for name, d := range diff.DetailedDiff {
+				got[name] = d.Kind
+			}
+			if diff.HasChanges != (len(tt.want) > 0) || !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Diff = %v (has changes %t), want %v", got, diff.HasChanges, tt.want)
+			}
+			if diff.DeleteBeforeReplace != tt.deleteBeforeReplace {
+				t.Errorf("DeleteBeforeReplace = %t, want %t", diff.DeleteBeforeReplace, tt.deleteBeforeReplace)
+			}
+		})
+	}
+}
@@ -318,3 +318,44 @@ func CheckHyperVSupport() {
 		log.Printf("[WARN] Provider operations will fail without Hyper-V support")
 	}
 }
+
+// OperatingSystem returns the caption and version of the local operating system, such as
+// "Microsoft Windows Server 2022 Datacenter" and "10.0.20348", from Win32_OperatingSystem.
+func OperatingSystem() (caption string, version string, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic during WMI query: %v", r)
+		}
+	}()
+
+	sm := wmiinstance.NewWmiSessionManager()
+	defer sm.Close()
+	defer sm.Dispose()
+
+	conn, err := sm.GetLocalSession("root\\cimv2")
+	if err != nil {
+		return "", "", fmt.Errorf("failed to create CIM connection: %w", err)
+	}
+	defer func() {
+		conn.Close()
+		conn.Dispose()
+	}()
+	if _, err := conn.Connect(); err != nil {
+		return "", "", fmt.Errorf("failed to connect to CIM namespace: %w", err)
+	}
+
+	instances, err := conn.QueryInstances("SELECT Caption, Version FROM Win32_OperatingSystem")
+	if err != nil {
+		return "", "", fmt.Errorf("failed to query OS info: %w", err)
+	}
+	if len(instances) == 0 {
+		return "", "", fmt.Errorf("no OS info found")
+	}
+	if value, err := instances[0].GetProperty("Caption"); err == nil && value != nil {
+		caption, _ = value.(string)
+	}
+	if value, err := instances[0].GetProperty("Version"); err == nil && value != nil {
+		version, _ = value.(string)
+	}
+	return caption, version, nil
+}
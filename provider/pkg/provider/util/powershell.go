@@ -1,9 +1,12 @@
 package util
 
 import (
+	"context"
 	"fmt"
 	"os/exec"
 	"strings"
+
+	"github.com/pulumi/pulumi-hyperv/provider/pkg/provider/errs"
 )
 
 // FindPowerShellExe finds the PowerShell executable (powershell.exe or pwsh.exe)
@@ -21,35 +24,74 @@ func FindPowerShellExe() (string, error) {
 	return "", fmt.Errorf("neither powershell.exe nor pwsh.exe found in PATH, PowerShell fallback cannot be used")
 }
 
-// RunPowerShellCommand is a helper function to run PowerShell commands with proper error handling
-func RunPowerShellCommand(command string) (string, error) {
+// RunPowerShellCommand is a helper function to run PowerShell commands with proper error handling.
+// Failures are classified with errs.ClassifyPowerShell. The command runs once, since a command
+// that timed out may still have created what it was asked to, and Hyper-V allows duplicate
+// names.
+func RunPowerShellCommand(ctx context.Context, command string) (string, error) {
 	// Find PowerShell executable
 	powershellExe, err := FindPowerShellExe()
 	if err != nil {
 		return "", err
 	}
-	cmd := exec.Command(powershellExe, "-Command", command)
-	output, err := cmd.CombinedOutput()
+	return runPowerShell(ctx, powershellExe, command)
+}
+
+// RunIdempotentPowerShellCommand runs a command that reads or that can be repeated without a
+// different result, like RunPowerShellCommand, and retries transient failures, such as a
+// restart of the Hyper-V service, with errs.DefaultRetryPolicy until ctx is done.
+func RunIdempotentPowerShellCommand(ctx context.Context, command string) (string, error) {
+	powershellExe, err := FindPowerShellExe()
 	if err != nil {
-		return string(output), fmt.Errorf("command failed: %v, output: %s", err, string(output))
+		return "", err
+	}
+	var output string
+	err = errs.Retry(ctx, func() error {
+		var err error
+		output, err = runPowerShell(ctx, powershellExe, command)
+		return err
+	})
+	return output, err
+}
+
+func runPowerShell(ctx context.Context, powershellExe string, command string) (string, error) {
+	cmd := exec.CommandContext(ctx, powershellExe, "-Command", command)
+	output, err := cmd.CombinedOutput()
+	if err == nil {
+		return string(output), nil
 	}
-	return string(output), nil
+	err = fmt.Errorf("command failed: %v, output: %s", err, string(output))
+	if kind := errs.ClassifyPowerShell(string(output)); kind != nil {
+		return string(output), errs.Wrap(kind, "PowerShell", err)
+	}
+	return string(output), err
 }
 
-// ParsePowerShellError attempts to parse common PowerShell error patterns and returns a more user-friendly error
+// ParsePowerShellError attempts to parse common PowerShell error patterns and returns a more user-friendly error.
+// The errors of known patterns carry their class from the errs package.
 func ParsePowerShellError(cmdOutput string, cmdName string, entityType string, entityName string) error {
 	if cmdOutput == "" {
 		return fmt.Errorf("unknown %s error: no output received from PowerShell command", entityType)
 	}
 
-	// Common PowerShell error patterns
-	switch {
-	case strings.Contains(cmdOutput, "ObjectNotFound"):
-		return fmt.Errorf("%s not found: '%s'. Please verify it exists and you have permission to access it", entityType, entityName)
-
-	case strings.Contains(cmdOutput, "Access is denied") || strings.Contains(cmdOutput, "AccessDenied"):
-		return fmt.Errorf("access denied. Please verify you have administrator privileges")
+	// Classified PowerShell error patterns
+	switch kind := errs.ClassifyPowerShell(cmdOutput); kind {
+	case errs.ErrNotFound:
+		return errs.New(kind, cmdName, fmt.Sprintf("%s not found: '%s'. Please verify it exists and you have permission to access it", entityType, entityName))
+	case errs.ErrAccessDenied:
+		return errs.New(kind, cmdName, "access denied. Please verify you have administrator privileges")
+	case errs.ErrServiceRestarted:
+		return errs.New(kind, cmdName, "the Hyper-V service may have restarted. Please try again")
+	case errs.ErrInsufficientResources:
+		return errs.New(kind, cmdName, fmt.Sprintf("insufficient resources for %s '%s': %s", entityType, entityName, strings.TrimSpace(cmdOutput)))
+	case errs.ErrInvalidState:
+		return errs.New(kind, cmdName, fmt.Sprintf("%s '%s' is in an invalid state for %s: %s", entityType, entityName, cmdName, strings.TrimSpace(cmdOutput)))
+	case errs.ErrTransient:
+		return errs.New(kind, cmdName, fmt.Sprintf("%s operation failed temporarily: %s", entityType, strings.TrimSpace(cmdOutput)))
+	}
 
+	// Other common PowerShell error patterns
+	switch {
 	case strings.Contains(cmdOutput, "The parameter is incorrect"):
 		return fmt.Errorf("incorrect parameter. This often happens with incompatible formats or configurations")
 
@@ -59,9 +101,6 @@ func ParsePowerShellError(cmdOutput string, cmdName string, entityType string, e
 	case strings.Contains(cmdOutput, "The operation failed because of a cluster validation error"):
 		return fmt.Errorf("cluster validation error. This operation may require cluster administrative privileges")
 
-	case strings.Contains(cmdOutput, "The operation failed because the process hosting the server process terminated unexpectedly"):
-		return fmt.Errorf("the Hyper-V service may have restarted. Please try again")
-
 	default:
 		// If we can't identify the error, return the raw output
 		return fmt.Errorf("%s operation failed: %s", entityType, cmdOutput)
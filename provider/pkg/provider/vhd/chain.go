@@ -0,0 +1,170 @@
+// Copyright 2016-2022, Pulumi Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vhd
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// MaxChainDepth bounds the walk up a differencing chain, so that a disk which names
+// itself as an ancestor cannot loop forever.
+const MaxChainDepth = 64
+
+// ResolveParentPath returns the parent locator of a differencing disk at childPath as a
+// path that can be opened. Relative locators are resolved against the directory of the
+// child, and Windows separators are accepted on every platform.
+func ResolveParentPath(childPath, parentPath string) string {
+	p := parentPath
+	if filepath.Separator != '\\' {
+		p = strings.ReplaceAll(p, `\`, string(filepath.Separator))
+	}
+	if !isAbsolute(parentPath) {
+		return filepath.Clean(filepath.Join(filepath.Dir(childPath), p))
+	}
+	return p
+}
+
+// isAbsolute reports whether path is absolute on the local platform or is a Windows drive
+// or UNC path.
+func isAbsolute(path string) bool {
+	if filepath.IsAbs(path) || strings.HasPrefix(path, `\\`) {
+		return true
+	}
+	return len(path) >= 3 && path[1] == ':' && (path[2] == '\\' || path[2] == '/')
+}
+
+// SamePath reports whether two paths name the same file, ignoring case and separators as
+// Windows does.
+func SamePath(a, b string) bool {
+	clean := func(p string) string {
+		p = strings.TrimPrefix(p, `\\?\`)
+		return strings.ToLower(filepath.Clean(strings.ReplaceAll(p, `\`, "/")))
+	}
+	return clean(a) == clean(b)
+}
+
+// CheckParent verifies that the disk at parentPath can be the parent of a differencing disk
+// created at childPath: it must exist, be a VHD or VHDX file of the same format as the
+// child, and use sector sizes the child format supports.
+func CheckParent(childPath, parentPath string) (*Info, error) {
+	parent, err := Inspect(parentPath)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, fmt.Errorf("parent disk [%s] does not exist", parentPath)
+		}
+		return nil, fmt.Errorf("parent disk [%s] is not a valid virtual hard disk: %w", parentPath, err)
+	}
+	if err := ValidateParent(childPath, parentPath, parent); err != nil {
+		return nil, err
+	}
+	return parent, nil
+}
+
+// ValidateParent runs the checks of CheckParent against the properties of a parent disk
+// that were read elsewhere, for example by Hyper-V.
+func ValidateParent(childPath, parentPath string, parent *Info) error {
+	format, err := FormatForPath(childPath)
+	if err != nil {
+		return err
+	}
+	if parent.Format != format {
+		return fmt.Errorf("a %s differencing disk cannot have the %s parent [%s]", format, parent.Format, parentPath)
+	}
+	if format == FormatVHD && parent.LogicalSectorSize != vhdSectorSize {
+		return fmt.Errorf("parent disk [%s] has %d byte sectors, VHD files only support 512 byte sectors", parentPath, parent.LogicalSectorSize)
+	}
+	if SamePath(childPath, parentPath) {
+		return fmt.Errorf("disk [%s] cannot be its own parent", childPath)
+	}
+	return nil
+}
+
+// Chain returns the ancestors of the disk at path, starting with its parent. Disks that
+// are not differencing disks have no ancestors. When an ancestor cannot be read, the chain
+// found so far is returned together with the error.
+func Chain(path string) ([]string, error) {
+	var chain []string
+	current := path
+	for depth := 0; ; depth++ {
+		info, err := Inspect(current)
+		if err != nil {
+			return chain, err
+		}
+		if info.DiskType != TypeDifferencing {
+			return chain, nil
+		}
+		if info.ParentPath == "" {
+			return chain, fmt.Errorf("differencing disk [%s] has no parent locator", current)
+		}
+		if depth == MaxChainDepth {
+			return chain, fmt.Errorf("differencing chain of [%s] is deeper than %d disks", path, MaxChainDepth)
+		}
+		current = ResolveParentPath(current, info.ParentPath)
+		chain = append(chain, current)
+	}
+}
+
+// FindChildren returns the differencing disks whose parent is the disk at parentPath. It
+// looks in the directory of the parent and in the directories next to it, which is where
+// child disks are kept in the usual per-VM layout. Files that cannot be read are skipped.
+func FindChildren(parentPath string) ([]string, error) {
+	if !isAbsolute(parentPath) {
+		if abs, err := filepath.Abs(parentPath); err == nil {
+			parentPath = abs
+		}
+	}
+	dir := filepath.Dir(parentPath)
+	dirs := []string{dir}
+	if siblings, err := os.ReadDir(filepath.Dir(dir)); err == nil {
+		for _, s := range siblings {
+			if sibling := filepath.Join(filepath.Dir(dir), s.Name()); s.IsDir() && !SamePath(sibling, dir) {
+				dirs = append(dirs, sibling)
+			}
+		}
+	}
+
+	var children []string
+	for _, d := range dirs {
+		entries, err := os.ReadDir(d)
+		if err != nil {
+			if d == dir {
+				return nil, err
+			}
+			continue
+		}
+		for _, e := range entries {
+			if e.IsDir() {
+				continue
+			}
+			candidate := filepath.Join(d, e.Name())
+			if _, err := FormatForPath(candidate); err != nil || SamePath(candidate, parentPath) {
+				continue
+			}
+			info, err := Inspect(candidate)
+			if err != nil || info.DiskType != TypeDifferencing || info.ParentPath == "" {
+				continue
+			}
+			if SamePath(ResolveParentPath(candidate, info.ParentPath), parentPath) {
+				children = append(children, candidate)
+			}
+		}
+	}
+	return children, nil
+}
@@ -0,0 +1,109 @@
+// Copyright 2016-2022, Pulumi Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vhd
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// diskChain creates base/base.vhdx, base/mid.vhdx and vm1/leaf.vhdx, each the parent of
+// the next.
+func diskChain(t *testing.T) (base, mid, leaf string) {
+	t.Helper()
+	dir := t.TempDir()
+	for _, d := range []string{"base", "vm1"} {
+		if err := os.MkdirAll(filepath.Join(dir, d), 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	base = filepath.Join(dir, "base", "base.vhdx")
+	mid = filepath.Join(dir, "base", "mid.vhdx")
+	leaf = filepath.Join(dir, "vm1", "leaf.vhdx")
+	if _, err := Create(base, CreateOptions{VirtualSize: 16 * mib}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Create(mid, CreateOptions{DiskType: TypeDifferencing, ParentPath: base}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Create(leaf, CreateOptions{DiskType: TypeDifferencing, ParentPath: mid}); err != nil {
+		t.Fatal(err)
+	}
+	return base, mid, leaf
+}
+
+func TestChain(t *testing.T) {
+	base, mid, leaf := diskChain(t)
+	chain, err := Chain(leaf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(chain, []string{mid, base}) {
+		t.Errorf("Chain = %v, want [%s %s]", chain, mid, base)
+	}
+	if chain, err := Chain(base); err != nil || len(chain) != 0 {
+		t.Errorf("Chain of a base disk = %v, %v", chain, err)
+	}
+
+	if err := os.Remove(base); err != nil {
+		t.Fatal(err)
+	}
+	if chain, err := Chain(leaf); err == nil || !reflect.DeepEqual(chain, []string{mid, base}) {
+		t.Errorf("Chain with a missing ancestor = %v, %v", chain, err)
+	}
+}
+
+func TestFindChildren(t *testing.T) {
+	base, mid, leaf := diskChain(t)
+	if children, err := FindChildren(base); err != nil || !reflect.DeepEqual(children, []string{mid}) {
+		t.Errorf("FindChildren(base) = %v, %v", children, err)
+	}
+	if children, err := FindChildren(mid); err != nil || !reflect.DeepEqual(children, []string{leaf}) {
+		t.Errorf("FindChildren(mid) = %v, %v", children, err)
+	}
+	if children, err := FindChildren(leaf); err != nil || len(children) != 0 {
+		t.Errorf("FindChildren(leaf) = %v, %v", children, err)
+	}
+}
+
+func TestCheckParent(t *testing.T) {
+	base, _, _ := diskChain(t)
+	dir := filepath.Dir(base)
+	if _, err := CheckParent(filepath.Join(dir, "child.vhdx"), base); err != nil {
+		t.Errorf("CheckParent failed: %v", err)
+	}
+	for name, tc := range map[string][2]string{
+		"missing parent":  {filepath.Join(dir, "child.vhdx"), filepath.Join(dir, "missing.vhdx")},
+		"format mismatch": {filepath.Join(dir, "child.vhd"), base},
+		"own parent":      {base, base},
+		"bad extension":   {filepath.Join(dir, "child.img"), base},
+	} {
+		if _, err := CheckParent(tc[0], tc[1]); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+}
+
+func TestResolveParentPath(t *testing.T) {
+	child := filepath.Join("/", "vms", "vm1", "disk.vhdx")
+	if got, want := ResolveParentPath(child, `..\base\base.vhdx`), filepath.Join("/", "vms", "base", "base.vhdx"); got != want {
+		t.Errorf("ResolveParentPath = %s, want %s", got, want)
+	}
+	if got := ResolveParentPath(child, `C:\vms\base.vhdx`); !SamePath(got, `c:/VMS/base.vhdx`) {
+		t.Errorf("absolute locator was changed to %s", got)
+	}
+}
@@ -0,0 +1,164 @@
+// Copyright 2016-2022, Pulumi Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vhd
+
+import (
+	"crypto/rand"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// CreateOptions describes a virtual hard disk to create.
+type CreateOptions struct {
+	// Format is FormatVHD or FormatVHDX. When empty it is derived from the file extension.
+	Format string
+	// DiskType is TypeFixed, TypeDynamic or TypeDifferencing (case-insensitive).
+	// Defaults to TypeDynamic.
+	DiskType string
+	// VirtualSize is the size of the disk in bytes. Differencing disks inherit it from the parent.
+	VirtualSize uint64
+	// BlockSize is the allocation unit in bytes. Zero selects the Hyper-V default.
+	BlockSize uint32
+	// LogicalSectorSize and PhysicalSectorSize are 512 or 4096 for VHDX, and 512 for VHD.
+	// Zero selects the Hyper-V default; differencing disks inherit them from the parent.
+	LogicalSectorSize  uint32
+	PhysicalSectorSize uint32
+	// ParentPath is the parent of a differencing disk. It must have the same format.
+	ParentPath string
+}
+
+// FormatForPath returns the disk format implied by the file extension of path.
+func FormatForPath(path string) (string, error) {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".vhd":
+		return FormatVHD, nil
+	case ".vhdx":
+		return FormatVHDX, nil
+	default:
+		return "", fmt.Errorf("path [%s] doesn't end with .vhd or .vhdx", path)
+	}
+}
+
+// NormalizeDiskType returns the canonical spelling of a disk type, defaulting to dynamic.
+func NormalizeDiskType(diskType string) (string, error) {
+	switch strings.ToLower(diskType) {
+	case "", "dynamic":
+		return TypeDynamic, nil
+	case "fixed":
+		return TypeFixed, nil
+	case "differencing":
+		return TypeDifferencing, nil
+	default:
+		return "", fmt.Errorf("unsupported disk type [%s], must be Fixed, Dynamic or Differencing", diskType)
+	}
+}
+
+// Create writes a new, empty virtual hard disk to path and returns its properties as
+// read back from the file. An existing file is never overwritten.
+func Create(path string, opts CreateOptions) (*Info, error) {
+	var err error
+	if opts.Format == "" {
+		if opts.Format, err = FormatForPath(path); err != nil {
+			return nil, err
+		}
+	}
+	if opts.DiskType, err = NormalizeDiskType(opts.DiskType); err != nil {
+		return nil, err
+	}
+
+	var parent *Info
+	var parentFile *os.File
+	if opts.DiskType == TypeDifferencing {
+		if opts.ParentPath == "" {
+			return nil, fmt.Errorf("a parent path is required for a differencing disk")
+		}
+		if parentFile, err = os.Open(opts.ParentPath); err != nil {
+			return nil, fmt.Errorf("failed to open parent disk: %w", err)
+		}
+		defer parentFile.Close()
+		if parent, err = Inspect(opts.ParentPath); err != nil {
+			return nil, err
+		}
+		if parent.Format != opts.Format {
+			return nil, fmt.Errorf("a %s differencing disk cannot have a %s parent", opts.Format, parent.Format)
+		}
+		opts.VirtualSize = parent.VirtualSize
+		opts.LogicalSectorSize = parent.LogicalSectorSize
+		opts.PhysicalSectorSize = parent.PhysicalSectorSize
+	}
+
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	switch opts.Format {
+	case FormatVHDX:
+		err = writeVHDX(f, path, opts, parentFile)
+	case FormatVHD:
+		err = writeVHD(f, path, opts, parentFile)
+	default:
+		err = fmt.Errorf("unsupported disk format [%s]", opts.Format)
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(path)
+		return nil, err
+	}
+	return Inspect(path)
+}
+
+// newGUID returns a random version 4 GUID.
+func newGUID() (GUID, error) {
+	var g GUID
+	if _, err := rand.Read(g[:]); err != nil {
+		return g, err
+	}
+	g[7] = g[7]&0x0F | 0x40
+	g[8] = g[8]&0x3F | 0x80
+	return g, nil
+}
+
+// parentLocatorPaths returns the absolute parent path and the parent path relative to
+// the child. The relative path always uses Windows separators, so that a disk chain
+// prepared on another platform can still be resolved by Hyper-V.
+func parentLocatorPaths(childPath, parentPath string) (string, string) {
+	absolute, err := filepath.Abs(parentPath)
+	if err != nil {
+		absolute = parentPath
+	}
+	relative := ""
+	if childDir, err := filepath.Abs(filepath.Dir(childPath)); err == nil {
+		if rel, err := filepath.Rel(childDir, absolute); err == nil {
+			relative = filepath.ToSlash(rel)
+			if !strings.HasPrefix(relative, "../") {
+				relative = "./" + relative
+			}
+			relative = strings.ReplaceAll(relative, "/", `\`)
+		}
+	}
+	return absolute, relative
+}
+
+func isPowerOfTwo(v uint32) bool {
+	return v != 0 && v&(v-1) == 0
+}
+
+func roundUp(v, multiple uint64) uint64 {
+	return (v + multiple - 1) / multiple * multiple
+}
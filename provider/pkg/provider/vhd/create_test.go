@@ -0,0 +1,160 @@
+// Copyright 2016-2022, Pulumi Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vhd
+
+import (
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCreateRoundTrip(t *testing.T) {
+	cases := []struct {
+		name      string
+		file      string
+		opts      CreateOptions
+		blockSize uint32
+		physical  uint32
+	}{
+		{"vhdx dynamic", "d.vhdx", CreateOptions{DiskType: "dynamic", VirtualSize: 10 * 1024 * mib}, vhdxDefaultBlockSize, 4096},
+		{"vhdx fixed", "f.vhdx", CreateOptions{DiskType: "Fixed", VirtualSize: 64 * mib, BlockSize: 1 * mib, PhysicalSectorSize: 512}, 1 * mib, 512},
+		{"vhdx 4k sectors", "s.vhdx", CreateOptions{VirtualSize: 64 * mib, LogicalSectorSize: 4096}, vhdxDefaultBlockSize, 4096},
+		{"vhd dynamic", "d.vhd", CreateOptions{DiskType: "Dynamic", VirtualSize: 1024 * mib}, vhdDefaultBlockSize, 512},
+		{"vhd fixed", "f.vhd", CreateOptions{DiskType: "fixed", VirtualSize: 8 * mib}, 0, 512},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), tc.file)
+			info, err := Create(path, tc.opts)
+			if err != nil {
+				t.Fatalf("Create failed: %v", err)
+			}
+			wantType, _ := NormalizeDiskType(tc.opts.DiskType)
+			if info.DiskType != wantType || info.VirtualSize != tc.opts.VirtualSize || info.BlockSize != tc.blockSize || info.PhysicalSectorSize != tc.physical {
+				t.Errorf("unexpected info: %+v", info)
+			}
+			wantFormat, _ := FormatForPath(path)
+			if info.Format != wantFormat || info.DiskID == "" || info.DiskID == (GUID{}).String() {
+				t.Errorf("unexpected identity: %+v", info)
+			}
+		})
+	}
+}
+
+func TestCreateFixedVHDXAllocatesAllBlocks(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "fixed.vhdx")
+	if _, err := Create(path, CreateOptions{DiskType: TypeFixed, VirtualSize: 5 * mib, BlockSize: 2 * mib}); err != nil {
+		t.Fatal(err)
+	}
+	img, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Three 2 MB payload blocks after a 1 MB BAT at 3 MB.
+	if len(img) != 4*mib+3*2*mib {
+		t.Fatalf("unexpected file size %d", len(img))
+	}
+	for i := 0; i < 3; i++ {
+		entry := binary.LittleEndian.Uint64(img[vhdxBATOffset+i*8:])
+		if entry&7 != payloadBlockFullyPresent || entry>>20 != uint64(4+2*i) {
+			t.Errorf("BAT entry %d = %#x", i, entry)
+		}
+	}
+}
+
+func TestCreateDifferencing(t *testing.T) {
+	for _, ext := range []string{".vhdx", ".vhd"} {
+		t.Run(ext, func(t *testing.T) {
+			dir := t.TempDir()
+			parentPath := filepath.Join(dir, "base", "parent"+ext)
+			if err := os.MkdirAll(filepath.Dir(parentPath), 0o755); err != nil {
+				t.Fatal(err)
+			}
+			parent, err := Create(parentPath, CreateOptions{VirtualSize: 256 * mib})
+			if err != nil {
+				t.Fatal(err)
+			}
+			child, err := Create(filepath.Join(dir, "child"+ext), CreateOptions{DiskType: "differencing", ParentPath: parentPath})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if child.DiskType != TypeDifferencing || child.VirtualSize != parent.VirtualSize || child.LogicalSectorSize != parent.LogicalSectorSize {
+				t.Errorf("unexpected child: %+v", child)
+			}
+			if child.ParentPath != parentPath {
+				t.Errorf("ParentPath = %q, want %q", child.ParentPath, parentPath)
+			}
+			if ext == ".vhd" && child.ParentID != parent.DiskID {
+				t.Errorf("ParentID = %s, want %s", child.ParentID, parent.DiskID)
+			}
+			if ext == ".vhdx" && child.ParentID == "" {
+				t.Errorf("missing parent linkage")
+			}
+		})
+	}
+}
+
+func TestCreateRejectsInvalidOptions(t *testing.T) {
+	dir := t.TempDir()
+	parent := filepath.Join(dir, "parent.vhd")
+	if _, err := Create(parent, CreateOptions{VirtualSize: mib}); err != nil {
+		t.Fatal(err)
+	}
+	cases := map[string]struct {
+		file string
+		opts CreateOptions
+	}{
+		"bad extension":      {"disk.img", CreateOptions{VirtualSize: mib}},
+		"bad disk type":      {"a.vhdx", CreateOptions{DiskType: "sparse", VirtualSize: mib}},
+		"zero size":          {"b.vhdx", CreateOptions{}},
+		"unaligned size":     {"c.vhdx", CreateOptions{VirtualSize: mib + 1}},
+		"small block":        {"d.vhdx", CreateOptions{VirtualSize: mib, BlockSize: 4096}},
+		"vhd 4k sectors":     {"e.vhd", CreateOptions{VirtualSize: mib, LogicalSectorSize: 4096}},
+		"missing parent":     {"f.vhdx", CreateOptions{DiskType: TypeDifferencing}},
+		"format mismatch":    {"g.vhdx", CreateOptions{DiskType: TypeDifferencing, ParentPath: parent}},
+		"existing file kept": {"parent.vhd", CreateOptions{VirtualSize: mib}},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(dir, tc.file)
+			if _, err := Create(path, tc.opts); err == nil {
+				t.Fatalf("expected error")
+			}
+			if !strings.HasPrefix(tc.file, "parent") {
+				if _, err := os.Stat(path); !os.IsNotExist(err) {
+					t.Errorf("partial file left behind at %s", path)
+				}
+			}
+		})
+	}
+	if _, err := Inspect(parent); err != nil {
+		t.Errorf("existing disk was damaged: %v", err)
+	}
+}
+
+func TestVHDGeometry(t *testing.T) {
+	cases := map[uint64][3]uint32{
+		8 * 1024 * mib:   {16644, 16, 63},
+		127 * 1024 * mib: {65278, 16, 255},
+	}
+	for size, want := range cases {
+		geometry := vhdGeometry(size)
+		if got := [3]uint32{geometry >> 16, (geometry >> 8) & 0xFF, geometry & 0xFF}; got != want {
+			t.Errorf("geometry of %d bytes = %v, want %v", size, got, want)
+		}
+	}
+}
@@ -0,0 +1,323 @@
+// Copyright 2016-2022, Pulumi Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vhd
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// Source image formats a disk can be created from.
+const (
+	SourceRaw   = "raw"
+	SourceQCOW2 = "qcow2"
+	SourceVHD   = "vhd"
+	SourceVHDX  = "vhdx"
+)
+
+// Image gives read access to the virtual contents of a source image.
+type Image interface {
+	io.ReaderAt
+	// Size returns the virtual size of the image in bytes.
+	Size() int64
+	Close() error
+}
+
+// rawImage is a disk image without any container format.
+type rawImage struct {
+	*os.File
+	size int64
+}
+
+func (r *rawImage) Size() int64 {
+	return r.size
+}
+
+// DetectSourceFormat identifies the format of the image at path from its contents. Files
+// that are neither QCOW2, VHDX nor VHD are treated as raw images.
+func DetectSourceFormat(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	st, err := f.Stat()
+	if err != nil {
+		return "", err
+	}
+	sig := make([]byte, 8)
+	if n, _ := f.ReadAt(sig, 0); n == len(sig) {
+		switch {
+		case string(sig[:4]) == qcow2Magic:
+			return SourceQCOW2, nil
+		case string(sig) == vhdxSignature:
+			return SourceVHDX, nil
+		}
+	}
+	if _, err := inspectVHD(f, st.Size()); err == nil {
+		return SourceVHD, nil
+	}
+	return SourceRaw, nil
+}
+
+// NormalizeSourceFormat returns the canonical spelling of a source format. An empty format
+// is detected from the contents of the image at path.
+func NormalizeSourceFormat(format, path string) (string, error) {
+	switch f := strings.ToLower(format); f {
+	case "":
+		return DetectSourceFormat(path)
+	case SourceRaw, SourceQCOW2, SourceVHD, SourceVHDX:
+		return f, nil
+	case "img":
+		return SourceRaw, nil
+	default:
+		return "", fmt.Errorf("unsupported source format [%s], must be %s, %s, %s or %s", format, SourceRaw, SourceQCOW2, SourceVHD, SourceVHDX)
+	}
+}
+
+// OpenImage opens the image at path for reading its virtual contents. Differencing disks
+// and QCOW2 images with a backing file cannot be used as a source.
+func OpenImage(path, format string) (Image, error) {
+	switch format {
+	case SourceRaw:
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		st, err := f.Stat()
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		return &rawImage{File: f, size: st.Size()}, nil
+	case SourceQCOW2:
+		return openQCOW2(path)
+	case SourceVHD, SourceVHDX:
+		return Open(path)
+	default:
+		return nil, fmt.Errorf("unsupported source format [%s]", format)
+	}
+}
+
+// VerifySHA256 checks that the file at path has the hex encoded SHA-256 digest expected.
+func VerifySHA256(path, expected string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return fmt.Errorf("failed to hash [%s]: %w", path, err)
+	}
+	if actual := hex.EncodeToString(h.Sum(nil)); !strings.EqualFold(actual, strings.TrimSpace(expected)) {
+		return fmt.Errorf("SHA-256 of [%s] is %s, expected %s", path, actual, expected)
+	}
+	return nil
+}
+
+// CreateFromImage creates a fixed or dynamic disk at path holding the contents of src.
+// opts.VirtualSize grows the disk beyond the size of the image; when zero the disk is as
+// large as the image, rounded up to a whole sector. Blocks of a dynamic disk that only
+// hold zeros are not allocated.
+func CreateFromImage(path string, src Image, opts CreateOptions) (*Info, error) {
+	var err error
+	if opts.DiskType, err = NormalizeDiskType(opts.DiskType); err != nil {
+		return nil, err
+	}
+	if opts.DiskType == TypeDifferencing {
+		return nil, fmt.Errorf("a differencing disk cannot be created from an image")
+	}
+	sector := uint64(opts.LogicalSectorSize)
+	if sector == 0 {
+		sector = vhdSectorSize
+	}
+	imageSize := roundUp(uint64(src.Size()), sector)
+	if opts.VirtualSize == 0 {
+		opts.VirtualSize = imageSize
+	}
+	if opts.VirtualSize < imageSize {
+		return nil, fmt.Errorf("the requested size of %d bytes is smaller than the %d byte image", opts.VirtualSize, imageSize)
+	}
+
+	info, err := Create(path, opts)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		os.Remove(path)
+		return nil, err
+	}
+	switch {
+	case info.Format == FormatVHDX:
+		err = fillVHDX(f, info, src)
+	case info.DiskType == TypeFixed:
+		err = copyImage(f, src, 0, 0, src.Size())
+	default:
+		err = fillVHD(f, info, src)
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(path)
+		return nil, fmt.Errorf("failed to copy image into [%s]: %w", path, err)
+	}
+	return Inspect(path)
+}
+
+// readBlock reads the block of src starting at off into buf, zero filling past its end.
+func readBlock(src Image, buf []byte, off int64) error {
+	n := int64(len(buf))
+	if rest := src.Size() - off; rest < n {
+		n = rest
+	}
+	for i := n; i < int64(len(buf)); i++ {
+		buf[i] = 0
+	}
+	if _, err := src.ReadAt(buf[:n], off); err != nil && err != io.EOF {
+		return err
+	}
+	return nil
+}
+
+func isZero(b []byte) bool {
+	for _, c := range b {
+		if c != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// copyImage copies length bytes of src starting at srcOff to dst at dstOff.
+func copyImage(dst io.WriterAt, src Image, srcOff, dstOff, length int64) error {
+	buf := make([]byte, 4*mib)
+	for done := int64(0); done < length; {
+		n := int64(len(buf))
+		if length-done < n {
+			n = length - done
+		}
+		if err := readBlock(src, buf[:n], srcOff+done); err != nil {
+			return err
+		}
+		if _, err := dst.WriteAt(buf[:n], dstOff+done); err != nil {
+			return err
+		}
+		done += n
+	}
+	return nil
+}
+
+// fillVHDX writes the contents of src into a newly created VHDX file. Fixed disks already
+// have every block allocated; dynamic disks get new blocks appended for non-zero data.
+func fillVHDX(f *os.File, info *Info, src Image) error {
+	regions, err := readVHDXRegions(f)
+	if err != nil {
+		return err
+	}
+	region := regions[regionBAT]
+	bat := make([]byte, region[1])
+	if _, err := f.ReadAt(bat, region[0]); err != nil {
+		return err
+	}
+	st, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	end := int64(roundUp(uint64(st.Size()), mib))
+
+	blockSize := int64(info.BlockSize)
+	chunkRatio := int64(1<<23) * int64(info.LogicalSectorSize) / blockSize
+	buf := make([]byte, blockSize)
+	for i := int64(0); i*blockSize < src.Size(); i++ {
+		if err := readBlock(src, buf, i*blockSize); err != nil {
+			return err
+		}
+		entry := bat[(i+i/chunkRatio)*8:]
+		if state := binary.LittleEndian.Uint64(entry); state&7 == payloadBlockFullyPresent {
+			if _, err := f.WriteAt(buf, int64(state>>20)*mib); err != nil {
+				return err
+			}
+			continue
+		}
+		if isZero(buf) {
+			continue
+		}
+		if _, err := f.WriteAt(buf, end); err != nil {
+			return err
+		}
+		binary.LittleEndian.PutUint64(entry, uint64(end/mib)<<20|payloadBlockFullyPresent)
+		end += blockSize
+	}
+	_, err = f.WriteAt(bat, region[0])
+	return err
+}
+
+// fillVHD writes the contents of src into a newly created dynamic VHD file. Every block
+// with data is appended in front of the trailing footer, preceded by a fully set sector
+// bitmap, and the footer is written again at the new end of the file.
+func fillVHD(f *os.File, info *Info, src Image) error {
+	st, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	footer := make([]byte, footerSize)
+	if _, err := f.ReadAt(footer, st.Size()-footerSize); err != nil {
+		return err
+	}
+	header := make([]byte, dynamicHeaderSize)
+	if _, err := f.ReadAt(header, int64(binary.BigEndian.Uint64(footer[16:]))); err != nil {
+		return err
+	}
+	batOffset := int64(binary.BigEndian.Uint64(header[16:]))
+	bat := make([]byte, binary.BigEndian.Uint32(header[28:])*4)
+	if _, err := f.ReadAt(bat, batOffset); err != nil {
+		return err
+	}
+
+	blockSize := int64(info.BlockSize)
+	bitmap := bytes.Repeat([]byte{0xFF}, int(roundUp(uint64(blockSize)/vhdSectorSize/8, vhdSectorSize)))
+	end := st.Size() - footerSize
+	buf := make([]byte, blockSize)
+	for i := int64(0); i*blockSize < src.Size(); i++ {
+		if err := readBlock(src, buf, i*blockSize); err != nil {
+			return err
+		}
+		if isZero(buf) {
+			continue
+		}
+		if _, err := f.WriteAt(bitmap, end); err != nil {
+			return err
+		}
+		if _, err := f.WriteAt(buf, end+int64(len(bitmap))); err != nil {
+			return err
+		}
+		binary.BigEndian.PutUint32(bat[i*4:], uint32(end/vhdSectorSize))
+		end += int64(len(bitmap)) + blockSize
+	}
+	if _, err := f.WriteAt(bat, batOffset); err != nil {
+		return err
+	}
+	_, err = f.WriteAt(footer, end)
+	return err
+}
@@ -0,0 +1,251 @@
+// Copyright 2016-2022, Pulumi Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vhd
+
+import (
+	"bytes"
+	"compress/flate"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// rawContents returns a 5 MB image with data in its first and fourth megabyte and a
+// length that is not a multiple of the sector size.
+func rawContents() []byte {
+	img := make([]byte, 5*mib-100)
+	copy(img, "boot sector")
+	copy(img[3*mib+7:], bytes.Repeat([]byte("data"), 1000))
+	img[len(img)-1] = 0x42
+	return img
+}
+
+// syntheticQCOW2 builds a version 3 image with 64 KB clusters: cluster 0 is stored
+// uncompressed, cluster 2 is compressed and the remaining clusters are unallocated.
+func syntheticQCOW2(t *testing.T, contents []byte) []byte {
+	t.Helper()
+	const clusterBits = 16
+	const cluster = 1 << clusterBits
+	img := make([]byte, 4*cluster)
+	copy(img, qcow2Magic)
+	binary.BigEndian.PutUint32(img[4:], 3)
+	binary.BigEndian.PutUint32(img[20:], clusterBits)
+	binary.BigEndian.PutUint64(img[24:], uint64(len(contents)))
+	binary.BigEndian.PutUint32(img[36:], 1)
+	binary.BigEndian.PutUint64(img[40:], cluster)
+	binary.BigEndian.PutUint32(img[100:], 104)
+	// L1 table in cluster 1 pointing at the L2 table in cluster 2.
+	binary.BigEndian.PutUint64(img[cluster:], 2*cluster|1<<63)
+	// Cluster 0 of the image is stored in cluster 3.
+	copy(img[3*cluster:], contents[:cluster])
+	binary.BigEndian.PutUint64(img[2*cluster:], 3*cluster|1<<63)
+
+	var compressed bytes.Buffer
+	w, err := flate.NewWriter(&compressed, flate.BestCompression)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write(contents[2*cluster : 3*cluster])
+	w.Close()
+	offset := uint64(len(img)) + 100
+	img = append(img, make([]byte, 100)...)
+	img = append(img, compressed.Bytes()...)
+	sectors := (offset%512 + uint64(compressed.Len()) + 511) / 512
+	offsetBits := 62 - (clusterBits - 8)
+	binary.BigEndian.PutUint64(img[2*cluster+2*8:], qcow2Compressed|(sectors-1)<<offsetBits|offset)
+	return img
+}
+
+func readAll(t *testing.T, img Image) []byte {
+	t.Helper()
+	b := make([]byte, img.Size())
+	if _, err := img.ReadAt(b, 0); err != nil && err != io.EOF {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestCreateFromRawImage(t *testing.T) {
+	contents := rawContents()
+	dir := t.TempDir()
+	source := filepath.Join(dir, "cloud.img")
+	if err := os.WriteFile(source, contents, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		file string
+		opts CreateOptions
+	}{
+		{"dynamic.vhdx", CreateOptions{BlockSize: mib}},
+		{"fixed.vhdx", CreateOptions{DiskType: TypeFixed, BlockSize: mib}},
+		{"dynamic.vhd", CreateOptions{BlockSize: 512 * kib}},
+		{"fixed.vhd", CreateOptions{DiskType: TypeFixed}},
+		{"grown.vhdx", CreateOptions{VirtualSize: 64 * mib, BlockSize: mib}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.file, func(t *testing.T) {
+			src, err := OpenImage(source, SourceRaw)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer src.Close()
+			path := filepath.Join(dir, tc.file)
+			info, err := CreateFromImage(path, src, tc.opts)
+			if err != nil {
+				t.Fatalf("CreateFromImage failed: %v", err)
+			}
+			wantSize := uint64(5 * mib)
+			if tc.opts.VirtualSize != 0 {
+				wantSize = tc.opts.VirtualSize
+			}
+			if info.VirtualSize != wantSize {
+				t.Errorf("VirtualSize = %d, want %d", info.VirtualSize, wantSize)
+			}
+
+			d, err := Open(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer d.Close()
+			got := readAll(t, d)
+			if !bytes.Equal(got[:len(contents)], contents) || !isZero(got[len(contents):]) {
+				t.Errorf("disk contents differ from the image")
+			}
+			if info.DiskType == TypeDynamic {
+				for i, off := range d.block[1:3] {
+					if off >= 0 {
+						t.Errorf("zero block %d was allocated", i+1)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestCreateFromQCOW2Image(t *testing.T) {
+	contents := make([]byte, 5*65536)
+	for i := range contents[:3*65536] {
+		contents[i] = byte(i % 251)
+	}
+	dir := t.TempDir()
+	source := filepath.Join(dir, "cloud.qcow2")
+	if err := os.WriteFile(source, syntheticQCOW2(t, contents), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	// Cluster 1 is unallocated, so the image reads zeros there.
+	for i := 65536; i < 2*65536; i++ {
+		contents[i] = 0
+	}
+
+	format, err := DetectSourceFormat(source)
+	if err != nil || format != SourceQCOW2 {
+		t.Fatalf("DetectSourceFormat = %q, %v", format, err)
+	}
+	src, err := OpenImage(source, format)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer src.Close()
+	if got := readAll(t, src); !bytes.Equal(got, contents) {
+		t.Fatalf("qcow2 contents differ")
+	}
+
+	path := filepath.Join(dir, "disk.vhdx")
+	if _, err := CreateFromImage(path, src, CreateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	d, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+	if got := readAll(t, d); !bytes.Equal(got[:len(contents)], contents) {
+		t.Errorf("disk contents differ from the qcow2 image")
+	}
+}
+
+func TestCreateFromDiskImage(t *testing.T) {
+	dir := t.TempDir()
+	raw := filepath.Join(dir, "raw.img")
+	if err := os.WriteFile(raw, rawContents(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	src, err := OpenImage(raw, SourceRaw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	golden := filepath.Join(dir, "golden.vhd")
+	original, err := CreateFromImage(golden, src, CreateOptions{})
+	src.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	format, err := DetectSourceFormat(golden)
+	if err != nil || format != SourceVHD {
+		t.Fatalf("DetectSourceFormat = %q, %v", format, err)
+	}
+	src, err = OpenImage(golden, format)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer src.Close()
+	copied, err := CreateFromImage(filepath.Join(dir, "copy.vhdx"), src, CreateOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if copied.VirtualSize != original.VirtualSize || copied.DiskID == original.DiskID {
+		t.Errorf("unexpected copy: %+v", copied)
+	}
+}
+
+func TestCreateFromImageRejectsSmallerSize(t *testing.T) {
+	dir := t.TempDir()
+	source := filepath.Join(dir, "raw.img")
+	if err := os.WriteFile(source, rawContents(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	src, err := OpenImage(source, SourceRaw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer src.Close()
+	path := filepath.Join(dir, "small.vhdx")
+	if _, err := CreateFromImage(path, src, CreateOptions{VirtualSize: mib}); err == nil {
+		t.Fatal("expected error")
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("disk was created")
+	}
+}
+
+func TestVerifySHA256(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "image.img")
+	if err := os.WriteFile(path, []byte("golden"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256([]byte("golden"))
+	if err := VerifySHA256(path, hex.EncodeToString(sum[:])); err != nil {
+		t.Errorf("VerifySHA256 failed: %v", err)
+	}
+	if err := VerifySHA256(path, hex.EncodeToString(make([]byte, 32))); err == nil {
+		t.Errorf("expected a mismatch")
+	}
+}
@@ -0,0 +1,170 @@
+// Copyright 2016-2022, Pulumi Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vhd
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"unicode/utf16"
+)
+
+// Layout of the legacy VHD format, as described in the Virtual Hard Disk Image Format
+// Specification. All integers are big-endian.
+const (
+	footerSize        = 512
+	dynamicHeaderSize = 1024
+	footerCookie      = "conectix"
+	dynamicCookie     = "cxsparse"
+	vhdSectorSize     = 512
+	noDataOffset      = ^uint64(0)
+
+	vhdTypeFixed        = 2
+	vhdTypeDynamic      = 3
+	vhdTypeDifferencing = 4
+
+	footerChecksumOffset  = 64
+	dynamicChecksumOffset = 36
+	parentLocatorOffset   = 576
+	parentLocatorCount    = 8
+	parentLocatorSize     = 24
+)
+
+// Parent locator platform codes.
+const (
+	platformW2ru = "W2ru" // Windows relative path, UTF-16LE
+	platformW2ku = "W2ku" // Windows absolute path, UTF-16LE
+)
+
+// vhdChecksum is the one's complement of the byte sum, skipping the checksum field itself.
+func vhdChecksum(b []byte, checksumOffset int) uint32 {
+	var sum uint32
+	for i, c := range b {
+		if i >= checksumOffset && i < checksumOffset+4 {
+			continue
+		}
+		sum += uint32(c)
+	}
+	return ^sum
+}
+
+// guidFromUUID converts a big-endian UUID as stored in VHD structures into a GUID.
+func guidFromUUID(b []byte) GUID {
+	var g GUID
+	binary.LittleEndian.PutUint32(g[0:], binary.BigEndian.Uint32(b[0:]))
+	binary.LittleEndian.PutUint16(g[4:], binary.BigEndian.Uint16(b[4:]))
+	binary.LittleEndian.PutUint16(g[6:], binary.BigEndian.Uint16(b[6:]))
+	copy(g[8:], b[8:16])
+	return g
+}
+
+func decodeUTF16(b []byte, order binary.ByteOrder) string {
+	units := make([]uint16, 0, len(b)/2)
+	for i := 0; i+1 < len(b); i += 2 {
+		u := order.Uint16(b[i:])
+		if u == 0 {
+			break
+		}
+		units = append(units, u)
+	}
+	return string(utf16.Decode(units))
+}
+
+func inspectVHD(r io.ReaderAt, size int64) (*Info, error) {
+	footer := make([]byte, footerSize)
+	if _, err := r.ReadAt(footer, size-footerSize); err != nil {
+		return nil, err
+	}
+	if string(footer[0:8]) != footerCookie {
+		// Disks created before Virtual PC 2004 have a 511 byte footer.
+		if _, err := r.ReadAt(footer[:footerSize-1], size-footerSize+1); err != nil || string(footer[0:8]) != footerCookie {
+			return nil, ErrUnknownFormat
+		}
+		footer[footerSize-1] = 0
+	}
+	if got, want := binary.BigEndian.Uint32(footer[footerChecksumOffset:]), vhdChecksum(footer, footerChecksumOffset); got != want {
+		return nil, fmt.Errorf("VHD footer checksum mismatch: stored %#x, computed %#x", got, want)
+	}
+
+	info := &Info{
+		Format:             FormatVHD,
+		VirtualSize:        binary.BigEndian.Uint64(footer[48:]),
+		LogicalSectorSize:  vhdSectorSize,
+		PhysicalSectorSize: vhdSectorSize,
+		DiskID:             guidFromUUID(footer[68:84]).String(),
+	}
+
+	diskType := binary.BigEndian.Uint32(footer[60:])
+	switch diskType {
+	case vhdTypeFixed:
+		info.DiskType = TypeFixed
+		return info, nil
+	case vhdTypeDynamic:
+		info.DiskType = TypeDynamic
+	case vhdTypeDifferencing:
+		info.DiskType = TypeDifferencing
+	default:
+		return nil, fmt.Errorf("unsupported VHD disk type %d", diskType)
+	}
+
+	dataOffset := binary.BigEndian.Uint64(footer[16:])
+	if dataOffset == noDataOffset || int64(dataOffset)+dynamicHeaderSize > size {
+		return nil, fmt.Errorf("VHD dynamic header offset %#x is out of range", dataOffset)
+	}
+	header := make([]byte, dynamicHeaderSize)
+	if _, err := r.ReadAt(header, int64(dataOffset)); err != nil {
+		return nil, err
+	}
+	if string(header[0:8]) != dynamicCookie {
+		return nil, fmt.Errorf("VHD dynamic header has an invalid cookie")
+	}
+	if got, want := binary.BigEndian.Uint32(header[dynamicChecksumOffset:]), vhdChecksum(header, dynamicChecksumOffset); got != want {
+		return nil, fmt.Errorf("VHD dynamic header checksum mismatch: stored %#x, computed %#x", got, want)
+	}
+	info.BlockSize = binary.BigEndian.Uint32(header[32:])
+
+	if info.DiskType == TypeDifferencing {
+		info.ParentID = guidFromUUID(header[40:56]).String()
+		info.ParentPath = vhdParentPath(r, size, header)
+	}
+	return info, nil
+}
+
+// vhdParentPath returns the absolute parent locator if present, then the relative one
+// as stored, and finally the parent name recorded in the dynamic header.
+func vhdParentPath(r io.ReaderAt, size int64, header []byte) string {
+	locators := map[string]string{}
+	for i := 0; i < parentLocatorCount; i++ {
+		entry := header[parentLocatorOffset+i*parentLocatorSize:]
+		code := string(entry[0:4])
+		length := binary.BigEndian.Uint32(entry[8:])
+		offset := binary.BigEndian.Uint64(entry[16:])
+		if (code != platformW2ku && code != platformW2ru) || length == 0 || int64(offset)+int64(length) > size {
+			continue
+		}
+		data := make([]byte, length)
+		if _, err := r.ReadAt(data, int64(offset)); err != nil {
+			continue
+		}
+		locators[code] = decodeUTF16(data, binary.LittleEndian)
+	}
+	if p := locators[platformW2ku]; p != "" {
+		return p
+	}
+	if p := locators[platformW2ru]; p != "" {
+		return p
+	}
+	return decodeUTF16(header[64:576], binary.BigEndian)
+}
@@ -0,0 +1,243 @@
+// Copyright 2016-2022, Pulumi Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vhd
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+)
+
+// Layout of the QCOW2 format, as described in the QEMU documentation. All integers are
+// big-endian.
+const (
+	qcow2Magic          = "QFI\xfb"
+	qcow2MinHeader      = 72
+	qcow2OffsetMask     = 0x00FFFFFFFFFFFE00
+	qcow2Compressed     = uint64(1) << 62
+	qcow2ZeroCluster    = uint64(1)
+	qcow2MinClusterBits = 9
+	qcow2MaxClusterBits = 21
+
+	// Incompatible feature bits.
+	qcow2FeatureDirty        = 1 << 0
+	qcow2FeatureCorrupt      = 1 << 1
+	qcow2FeatureExternalData = 1 << 2
+	qcow2FeatureCompression  = 1 << 3
+	qcow2FeatureExtendedL2   = 1 << 4
+)
+
+// qcow2Image reads the virtual contents of a QCOW2 image. Backing files, encryption and
+// compression methods other than deflate are not supported.
+type qcow2Image struct {
+	f           *os.File
+	fileSize    int64
+	size        int64
+	clusterBits uint32
+	l1          []uint64
+	l2Cache     map[uint64][]uint64
+}
+
+func openQCOW2(path string) (*qcow2Image, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	img, err := readQCOW2(f)
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("failed to open qcow2 image [%s]: %w", path, err)
+	}
+	return img, nil
+}
+
+func readQCOW2(f *os.File) (*qcow2Image, error) {
+	st, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	h := make([]byte, 104)
+	n, err := f.ReadAt(h, 0)
+	if n < qcow2MinHeader {
+		return nil, fmt.Errorf("image is too small for a qcow2 header: %v", err)
+	}
+	if string(h[0:4]) != qcow2Magic {
+		return nil, ErrUnknownFormat
+	}
+	version := binary.BigEndian.Uint32(h[4:])
+	if version != 2 && version != 3 {
+		return nil, fmt.Errorf("unsupported qcow2 version %d", version)
+	}
+	if binary.BigEndian.Uint64(h[8:]) != 0 {
+		return nil, fmt.Errorf("qcow2 images with a backing file are not supported")
+	}
+	clusterBits := binary.BigEndian.Uint32(h[20:])
+	if clusterBits < qcow2MinClusterBits || clusterBits > qcow2MaxClusterBits {
+		return nil, fmt.Errorf("invalid qcow2 cluster size 2^%d", clusterBits)
+	}
+	if binary.BigEndian.Uint32(h[32:]) != 0 {
+		return nil, fmt.Errorf("encrypted qcow2 images are not supported")
+	}
+	if version == 3 && n >= 80 {
+		features := binary.BigEndian.Uint64(h[72:])
+		switch {
+		case features&qcow2FeatureCorrupt != 0:
+			return nil, fmt.Errorf("qcow2 image is marked corrupt")
+		case features&qcow2FeatureExternalData != 0:
+			return nil, fmt.Errorf("qcow2 images with an external data file are not supported")
+		case features&qcow2FeatureCompression != 0:
+			return nil, fmt.Errorf("qcow2 images compressed with zstd are not supported")
+		case features&qcow2FeatureExtendedL2 != 0:
+			return nil, fmt.Errorf("qcow2 images with extended L2 entries are not supported")
+		case features&^qcow2FeatureDirty != 0:
+			return nil, fmt.Errorf("qcow2 image uses unknown incompatible features %#x", features)
+		}
+	}
+
+	img := &qcow2Image{
+		f:           f,
+		fileSize:    st.Size(),
+		size:        int64(binary.BigEndian.Uint64(h[24:])),
+		clusterBits: clusterBits,
+		l2Cache:     map[uint64][]uint64{},
+	}
+	l1Size := binary.BigEndian.Uint32(h[36:])
+	l1Offset := int64(binary.BigEndian.Uint64(h[40:]))
+	if int64(l1Size)*8 > img.fileSize || l1Offset+int64(l1Size)*8 > img.fileSize {
+		return nil, fmt.Errorf("qcow2 L1 table is out of range")
+	}
+	raw := make([]byte, int(l1Size)*8)
+	if _, err := f.ReadAt(raw, l1Offset); err != nil {
+		return nil, err
+	}
+	img.l1 = make([]uint64, l1Size)
+	for i := range img.l1 {
+		img.l1[i] = binary.BigEndian.Uint64(raw[i*8:])
+	}
+	return img, nil
+}
+
+func (q *qcow2Image) Size() int64 {
+	return q.size
+}
+
+func (q *qcow2Image) Close() error {
+	return q.f.Close()
+}
+
+func (q *qcow2Image) clusterSize() int64 {
+	return int64(1) << q.clusterBits
+}
+
+// l2Table returns the L2 table at offset, reading it on first use.
+func (q *qcow2Image) l2Table(offset uint64) ([]uint64, error) {
+	if table, ok := q.l2Cache[offset]; ok {
+		return table, nil
+	}
+	raw := make([]byte, q.clusterSize())
+	if _, err := q.f.ReadAt(raw, int64(offset)); err != nil {
+		return nil, fmt.Errorf("failed to read qcow2 L2 table: %w", err)
+	}
+	table := make([]uint64, len(raw)/8)
+	for i := range table {
+		table[i] = binary.BigEndian.Uint64(raw[i*8:])
+	}
+	q.l2Cache[offset] = table
+	return table, nil
+}
+
+// readCluster fills buf with the virtual cluster at index. Unallocated clusters read as zeros.
+func (q *qcow2Image) readCluster(index int64, buf []byte) error {
+	l2Entries := q.clusterSize() / 8
+	l1Index := index / l2Entries
+	zero := func() error {
+		for i := range buf {
+			buf[i] = 0
+		}
+		return nil
+	}
+	if l1Index >= int64(len(q.l1)) {
+		return zero()
+	}
+	l2Offset := q.l1[l1Index] & qcow2OffsetMask
+	if l2Offset == 0 {
+		return zero()
+	}
+	table, err := q.l2Table(l2Offset)
+	if err != nil {
+		return err
+	}
+	entry := table[index%l2Entries]
+
+	if entry&qcow2Compressed != 0 {
+		// The host offset takes the low 62-(clusterBits-8) bits, followed by the number
+		// of additional 512 byte sectors that hold the compressed data.
+		offsetBits := 62 - (q.clusterBits - 8)
+		offset := int64(entry & (uint64(1)<<offsetBits - 1))
+		sectors := int64(entry>>offsetBits&(uint64(1)<<(q.clusterBits-8)-1)) + 1
+		length := sectors*512 - offset%512
+		if offset+length > q.fileSize {
+			length = q.fileSize - offset
+		}
+		compressed := make([]byte, length)
+		if _, err := q.f.ReadAt(compressed, offset); err != nil && err != io.EOF {
+			return fmt.Errorf("failed to read compressed qcow2 cluster: %w", err)
+		}
+		r := flate.NewReader(bytes.NewReader(compressed))
+		defer r.Close()
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return fmt.Errorf("failed to decompress qcow2 cluster %d: %w", index, err)
+		}
+		return nil
+	}
+
+	offset := entry & qcow2OffsetMask
+	if entry&qcow2ZeroCluster != 0 || offset == 0 {
+		return zero()
+	}
+	if _, err := q.f.ReadAt(buf, int64(offset)); err != nil {
+		return fmt.Errorf("failed to read qcow2 cluster %d: %w", index, err)
+	}
+	return nil
+}
+
+// ReadAt reads the virtual contents of the image.
+func (q *qcow2Image) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, fmt.Errorf("negative offset %d", off)
+	}
+	if off >= q.size {
+		return 0, io.EOF
+	}
+	var eof error
+	if int64(len(p)) > q.size-off {
+		p, eof = p[:q.size-off], io.EOF
+	}
+	clusterSize := q.clusterSize()
+	cluster := make([]byte, clusterSize)
+	n := 0
+	for n < len(p) {
+		pos := off + int64(n)
+		index, within := pos/clusterSize, pos%clusterSize
+		if err := q.readCluster(index, cluster); err != nil {
+			return n, err
+		}
+		n += copy(p[n:], cluster[within:])
+	}
+	return n, eof
+}
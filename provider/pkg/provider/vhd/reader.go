@@ -0,0 +1,296 @@
+// Copyright 2016-2022, Pulumi Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vhd
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// ErrDifferencing is returned when the contents of a differencing disk are read, which
+// would require resolving its parent chain.
+var ErrDifferencing = errors.New("reading the contents of differencing disks is not supported")
+
+// Disk gives read access to the virtual contents of a fixed or dynamic disk.
+type Disk struct {
+	f    *os.File
+	info *Info
+	// block maps a block index to its file offset, or -1 when the block is not allocated.
+	// It is nil for fixed VHD files, whose data starts at offset zero.
+	block []int64
+	// dataOffset is the offset of the data within a VHD block, past its sector bitmap.
+	dataOffset int64
+}
+
+// Open opens the disk at path for reading its virtual contents.
+func Open(path string) (*Disk, error) {
+	info, err := Inspect(path)
+	if err != nil {
+		return nil, err
+	}
+	if info.DiskType == TypeDifferencing {
+		return nil, ErrDifferencing
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	d := &Disk{f: f, info: info}
+	switch {
+	case info.Format == FormatVHDX:
+		err = d.loadVHDXBlocks()
+	case info.DiskType == TypeDynamic:
+		err = d.loadVHDBlocks()
+	}
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("failed to read block allocation table of [%s]: %w", path, err)
+	}
+	return d, nil
+}
+
+// loadVHDBlocks reads the block allocation table of a dynamic VHD file. Every block
+// starts with a sector bitmap, which is skipped when reading.
+func (d *Disk) loadVHDBlocks() error {
+	st, err := d.f.Stat()
+	if err != nil {
+		return err
+	}
+	footer := make([]byte, footerSize)
+	if _, err := d.f.ReadAt(footer, st.Size()-footerSize); err != nil {
+		return err
+	}
+	header := make([]byte, dynamicHeaderSize)
+	if _, err := d.f.ReadAt(header, int64(binary.BigEndian.Uint64(footer[16:]))); err != nil {
+		return err
+	}
+	tableOffset := int64(binary.BigEndian.Uint64(header[16:]))
+	entries := int(binary.BigEndian.Uint32(header[28:]))
+	bat := make([]byte, entries*4)
+	if _, err := d.f.ReadAt(bat, tableOffset); err != nil {
+		return err
+	}
+	d.block = make([]int64, entries)
+	for i := range d.block {
+		sector := binary.BigEndian.Uint32(bat[i*4:])
+		if sector == 0xFFFFFFFF {
+			d.block[i] = -1
+			continue
+		}
+		d.block[i] = int64(sector) * vhdSectorSize
+	}
+	bitmap := uint64(d.info.BlockSize) / vhdSectorSize / 8
+	d.dataOffset = int64(roundUp(bitmap, vhdSectorSize))
+	return nil
+}
+
+// loadVHDXBlocks reads the payload entries of the VHDX block allocation table, skipping
+// the sector bitmap entry that follows every chunk.
+func (d *Disk) loadVHDXBlocks() error {
+	regions, err := readVHDXRegions(d.f)
+	if err != nil {
+		return err
+	}
+	region := regions[regionBAT]
+	bat := make([]byte, region[1])
+	if _, err := d.f.ReadAt(bat, region[0]); err != nil {
+		return err
+	}
+	chunkRatio := uint64(1<<23) * uint64(d.info.LogicalSectorSize) / uint64(d.info.BlockSize)
+	blocks := (d.info.VirtualSize + uint64(d.info.BlockSize) - 1) / uint64(d.info.BlockSize)
+	d.block = make([]int64, blocks)
+	for i := range d.block {
+		index := uint64(i) + uint64(i)/chunkRatio
+		if (index+1)*8 > uint64(len(bat)) {
+			return fmt.Errorf("block %d is outside the block allocation table", i)
+		}
+		entry := binary.LittleEndian.Uint64(bat[index*8:])
+		if entry&7 != payloadBlockFullyPresent {
+			d.block[i] = -1
+			continue
+		}
+		d.block[i] = int64(entry>>20) * mib
+	}
+	return nil
+}
+
+// Info returns the properties of the disk.
+func (d *Disk) Info() *Info {
+	return d.info
+}
+
+// Size returns the virtual size of the disk in bytes.
+func (d *Disk) Size() int64 {
+	return int64(d.info.VirtualSize)
+}
+
+// Close closes the underlying file.
+func (d *Disk) Close() error {
+	return d.f.Close()
+}
+
+// ReadAt reads virtual contents of the disk. Blocks that are not allocated read as zeros.
+func (d *Disk) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, fmt.Errorf("negative offset %d", off)
+	}
+	size := d.Size()
+	if off >= size {
+		return 0, io.EOF
+	}
+	var eof error
+	if int64(len(p)) > size-off {
+		p, eof = p[:size-off], io.EOF
+	}
+	if d.block == nil {
+		n, err := d.f.ReadAt(p, off)
+		if err == nil {
+			err = eof
+		}
+		return n, err
+	}
+
+	blockSize := int64(d.info.BlockSize)
+	n := 0
+	for n < len(p) {
+		pos := off + int64(n)
+		index, within := pos/blockSize, pos%blockSize
+		chunk := p[n:]
+		if int64(len(chunk)) > blockSize-within {
+			chunk = chunk[:blockSize-within]
+		}
+		if start := d.block[index]; start < 0 {
+			for i := range chunk {
+				chunk[i] = 0
+			}
+		} else if _, err := d.f.ReadAt(chunk, start+d.dataOffset+within); err != nil {
+			return n, err
+		}
+		n += len(chunk)
+	}
+	return n, eof
+}
+
+// allocatedEnd returns the virtual offset just past the last allocated block, or the
+// virtual size for fixed disks.
+func (d *Disk) allocatedEnd() uint64 {
+	if d.info.DiskType == TypeFixed {
+		return d.info.VirtualSize
+	}
+	for i := len(d.block) - 1; i >= 0; i-- {
+		if d.block[i] >= 0 {
+			end := uint64(i+1) * uint64(d.info.BlockSize)
+			if end > d.info.VirtualSize {
+				end = d.info.VirtualSize
+			}
+			return end
+		}
+	}
+	return 0
+}
+
+// UsedSize returns the number of bytes at the start of the disk that hold data and must
+// be kept when the disk is shrunk. When the disk has a partition table this is the end of
+// the last partition, plus the backup table of GPT disks. Otherwise it is the end of the
+// last allocated block, which for fixed disks is the whole disk.
+func UsedSize(path string) (uint64, error) {
+	d, err := Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer d.Close()
+
+	end, ok, err := partitionEnd(d, d.info.LogicalSectorSize)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read partition table of [%s]: %w", path, err)
+	}
+	if ok {
+		return end, nil
+	}
+	return d.allocatedEnd(), nil
+}
+
+// Partition table layout, as described in the UEFI specification.
+const (
+	mbrSignatureOffset  = 510
+	mbrPartitionOffset  = 446
+	mbrPartitionSize    = 16
+	mbrTypeGPTProtected = 0xEE
+	gptSignature        = "EFI PART"
+)
+
+// partitionEnd returns the end of the last partition described by the MBR or GPT of r.
+// ok is false when r has no partition table.
+func partitionEnd(r io.ReaderAt, sectorSize uint32) (end uint64, ok bool, err error) {
+	mbr := make([]byte, vhdSectorSize)
+	if _, err := r.ReadAt(mbr, 0); err != nil {
+		return 0, false, err
+	}
+	if mbr[mbrSignatureOffset] != 0x55 || mbr[mbrSignatureOffset+1] != 0xAA {
+		return 0, false, nil
+	}
+	gpt := false
+	for i := 0; i < 4; i++ {
+		e := mbr[mbrPartitionOffset+i*mbrPartitionSize:]
+		if e[4] == 0 {
+			continue
+		}
+		if e[4] == mbrTypeGPTProtected {
+			gpt = true
+			continue
+		}
+		last := uint64(binary.LittleEndian.Uint32(e[8:])) + uint64(binary.LittleEndian.Uint32(e[12:]))
+		if last*uint64(sectorSize) > end {
+			end = last * uint64(sectorSize)
+		}
+	}
+	if !gpt {
+		return end, true, nil
+	}
+
+	header := make([]byte, sectorSize)
+	if _, err := r.ReadAt(header, int64(sectorSize)); err != nil {
+		return 0, false, err
+	}
+	if string(header[0:8]) != gptSignature {
+		return 0, false, fmt.Errorf("protective MBR without a GPT header")
+	}
+	entriesLBA := binary.LittleEndian.Uint64(header[72:])
+	count := binary.LittleEndian.Uint32(header[80:])
+	entrySize := binary.LittleEndian.Uint32(header[84:])
+	if entrySize < 128 || count > 1024 {
+		return 0, false, fmt.Errorf("invalid GPT partition entry array (%d entries of %d bytes)", count, entrySize)
+	}
+	entries := make([]byte, uint64(count)*uint64(entrySize))
+	if _, err := r.ReadAt(entries, int64(entriesLBA*uint64(sectorSize))); err != nil {
+		return 0, false, err
+	}
+	for i := uint32(0); i < count; i++ {
+		e := entries[i*entrySize:]
+		if readGUID(e).IsZero() {
+			continue
+		}
+		last := binary.LittleEndian.Uint64(e[40:]) + 1
+		if last*uint64(sectorSize) > end {
+			end = last * uint64(sectorSize)
+		}
+	}
+	// The backup partition entries and header follow the last usable sector.
+	backup := roundUp(uint64(len(entries)), uint64(sectorSize)) + uint64(sectorSize)
+	return end + backup, true, nil
+}
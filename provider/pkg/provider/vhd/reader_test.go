@@ -0,0 +1,145 @@
+// Copyright 2016-2022, Pulumi Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vhd
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// writeAt patches the file at path.
+func writeAt(t *testing.T, path string, data []byte, off int64) {
+	t.Helper()
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err := f.WriteAt(data, off); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// mbrWithPartition returns a boot sector with one partition of the given type.
+func mbrWithPartition(partType byte, start, sectors uint32) []byte {
+	mbr := make([]byte, 512)
+	e := mbr[mbrPartitionOffset:]
+	e[4] = partType
+	binary.LittleEndian.PutUint32(e[8:], start)
+	binary.LittleEndian.PutUint32(e[12:], sectors)
+	mbr[510], mbr[511] = 0x55, 0xAA
+	return mbr
+}
+
+func TestDiskReadsFixedContents(t *testing.T) {
+	for _, ext := range []string{".vhd", ".vhdx"} {
+		t.Run(ext, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "fixed"+ext)
+			if _, err := Create(path, CreateOptions{DiskType: TypeFixed, VirtualSize: 4 * mib, BlockSize: 1 * mib}); err != nil {
+				t.Fatal(err)
+			}
+			payload := []byte("pulumi")
+			offset := int64(0)
+			if ext == ".vhdx" {
+				offset = vhdxBATOffset + mib
+			}
+			writeAt(t, path, payload, offset+mib+10)
+
+			d, err := Open(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer d.Close()
+			got := make([]byte, len(payload))
+			if _, err := d.ReadAt(got, mib+10); err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, payload) {
+				t.Errorf("read %q, want %q", got, payload)
+			}
+			if n, err := d.ReadAt(make([]byte, 16), d.Size()-8); n != 8 || err == nil {
+				t.Errorf("reading past the end returned %d, %v", n, err)
+			}
+		})
+	}
+}
+
+func TestUsedSize(t *testing.T) {
+	dir := t.TempDir()
+
+	empty := filepath.Join(dir, "empty.vhdx")
+	if _, err := Create(empty, CreateOptions{VirtualSize: 64 * mib}); err != nil {
+		t.Fatal(err)
+	}
+	if used, err := UsedSize(empty); err != nil || used != 0 {
+		t.Errorf("UsedSize of an empty dynamic disk = %d, %v", used, err)
+	}
+
+	unpartitioned := filepath.Join(dir, "raw.vhd")
+	if _, err := Create(unpartitioned, CreateOptions{DiskType: TypeFixed, VirtualSize: 8 * mib}); err != nil {
+		t.Fatal(err)
+	}
+	if used, err := UsedSize(unpartitioned); err != nil || used != 8*mib {
+		t.Errorf("UsedSize of an unpartitioned fixed disk = %d, %v", used, err)
+	}
+
+	mbr := filepath.Join(dir, "mbr.vhd")
+	if _, err := Create(mbr, CreateOptions{DiskType: TypeFixed, VirtualSize: 8 * mib}); err != nil {
+		t.Fatal(err)
+	}
+	writeAt(t, mbr, mbrWithPartition(0x07, 2048, 4096), 0)
+	if used, err := UsedSize(mbr); err != nil || used != 3*mib {
+		t.Errorf("UsedSize of an MBR disk = %d, %v", used, err)
+	}
+
+	gpt := filepath.Join(dir, "gpt.vhd")
+	if _, err := Create(gpt, CreateOptions{DiskType: TypeFixed, VirtualSize: 8 * mib}); err != nil {
+		t.Fatal(err)
+	}
+	header := make([]byte, 512)
+	copy(header, gptSignature)
+	binary.LittleEndian.PutUint64(header[72:], 2)
+	binary.LittleEndian.PutUint32(header[80:], 128)
+	binary.LittleEndian.PutUint32(header[84:], 128)
+	entry := make([]byte, 128)
+	entry[0] = 1
+	binary.LittleEndian.PutUint64(entry[32:], 2048)
+	binary.LittleEndian.PutUint64(entry[40:], 6143)
+	writeAt(t, gpt, mbrWithPartition(mbrTypeGPTProtected, 1, 16383), 0)
+	writeAt(t, gpt, header, 512)
+	writeAt(t, gpt, entry, 1024)
+	if used, err := UsedSize(gpt); err != nil || used != 3*mib+33*512 {
+		t.Errorf("UsedSize of a GPT disk = %d, %v", used, err)
+	}
+}
+
+func TestOpenRejectsDifferencing(t *testing.T) {
+	dir := t.TempDir()
+	parent := filepath.Join(dir, "parent.vhdx")
+	if _, err := Create(parent, CreateOptions{VirtualSize: 8 * mib}); err != nil {
+		t.Fatal(err)
+	}
+	child := filepath.Join(dir, "child.vhdx")
+	if _, err := Create(child, CreateOptions{DiskType: TypeDifferencing, ParentPath: parent}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Open(child); !errors.Is(err, ErrDifferencing) {
+		t.Errorf("Open(differencing) = %v, want ErrDifferencing", err)
+	}
+}
@@ -0,0 +1,153 @@
+// Copyright 2016-2022, Pulumi Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package vhd reads and writes virtual hard disk files in the legacy VHD and the VHDX
+// formats, and converts raw and QCOW2 images into them, without depending on Hyper-V.
+// Disks can therefore be inspected and prepared on any platform.
+package vhd
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// Disk formats.
+const (
+	FormatVHD  = "VHD"
+	FormatVHDX = "VHDX"
+)
+
+// Disk types, spelled the way the VhdFile resource accepts them.
+const (
+	TypeFixed        = "Fixed"
+	TypeDynamic      = "Dynamic"
+	TypeDifferencing = "Differencing"
+)
+
+// ErrUnknownFormat is returned when a file is neither a VHD nor a VHDX.
+var ErrUnknownFormat = errors.New("not a VHD or VHDX file")
+
+// Info describes a virtual hard disk.
+type Info struct {
+	Format             string
+	DiskType           string
+	VirtualSize        uint64
+	PhysicalSize       int64
+	BlockSize          uint32
+	LogicalSectorSize  uint32
+	PhysicalSectorSize uint32
+	// ParentPath is the parent locator of a differencing disk. For VHDX the absolute
+	// Win32 path is preferred, followed by the relative and the volume path.
+	ParentPath string
+	// ParentID is the identifier of the parent disk recorded in a differencing disk.
+	ParentID string
+	// DiskID is the unique identifier of the disk.
+	DiskID string
+}
+
+// Inspect opens the file at path and reads its virtual hard disk metadata.
+func Inspect(path string) (*Info, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	st, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	info, err := InspectReader(f, st.Size())
+	if err != nil {
+		return nil, fmt.Errorf("failed to inspect [%s]: %w", path, err)
+	}
+	return info, nil
+}
+
+// InspectReader reads virtual hard disk metadata from r, which holds size bytes.
+func InspectReader(r io.ReaderAt, size int64) (*Info, error) {
+	sig := make([]byte, 8)
+	if size >= 8 {
+		if _, err := r.ReadAt(sig, 0); err != nil {
+			return nil, err
+		}
+	}
+	var (
+		info *Info
+		err  error
+	)
+	switch {
+	case string(sig) == vhdxSignature:
+		info, err = inspectVHDX(r, size)
+	case size >= footerSize:
+		info, err = inspectVHD(r, size)
+	default:
+		return nil, ErrUnknownFormat
+	}
+	if err != nil {
+		return nil, err
+	}
+	info.PhysicalSize = size
+	return info, nil
+}
+
+// GUID is a 16 byte identifier in the Microsoft mixed-endian layout used on disk by VHDX.
+type GUID [16]byte
+
+// ParseGUID parses the textual form "XXXXXXXX-XXXX-XXXX-XXXX-XXXXXXXXXXXX".
+func ParseGUID(s string) (GUID, error) {
+	var g GUID
+	s = strings.Trim(s, "{}")
+	hex := strings.ReplaceAll(s, "-", "")
+	if len(hex) != 32 || len(s) != 36 {
+		return g, fmt.Errorf("invalid GUID [%s]", s)
+	}
+	var raw [16]byte
+	for i := 0; i < 16; i++ {
+		if _, err := fmt.Sscanf(hex[i*2:i*2+2], "%02x", &raw[i]); err != nil {
+			return g, fmt.Errorf("invalid GUID [%s]", s)
+		}
+	}
+	binary.LittleEndian.PutUint32(g[0:], binary.BigEndian.Uint32(raw[0:]))
+	binary.LittleEndian.PutUint16(g[4:], binary.BigEndian.Uint16(raw[4:]))
+	binary.LittleEndian.PutUint16(g[6:], binary.BigEndian.Uint16(raw[6:]))
+	copy(g[8:], raw[8:])
+	return g, nil
+}
+
+func mustGUID(s string) GUID {
+	g, err := ParseGUID(s)
+	if err != nil {
+		panic(err)
+	}
+	return g
+}
+
+// String formats the GUID the way Windows tools display it.
+func (g GUID) String() string {
+	return fmt.Sprintf("%08X-%04X-%04X-%X-%X",
+		binary.LittleEndian.Uint32(g[0:]),
+		binary.LittleEndian.Uint16(g[4:]),
+		binary.LittleEndian.Uint16(g[6:]),
+		g[8:10], g[10:16])
+}
+
+// IsZero reports whether all bytes of the GUID are zero.
+func (g GUID) IsZero() bool {
+	return g == GUID{}
+}
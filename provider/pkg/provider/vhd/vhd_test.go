@@ -0,0 +1,353 @@
+// Copyright 2016-2022, Pulumi Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vhd
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"unicode/utf16"
+)
+
+// The builders below assemble minimal images byte by byte, independent of any writer,
+// so that the parser is checked against the specifications rather than against itself.
+
+func utf16LE(s string) []byte {
+	units := utf16.Encode([]rune(s))
+	b := make([]byte, len(units)*2)
+	for i, u := range units {
+		binary.LittleEndian.PutUint16(b[i*2:], u)
+	}
+	return b
+}
+
+var testUUID = []byte{0x12, 0x34, 0x56, 0x78, 0x9a, 0xbc, 0xde, 0xf0, 0x01, 0x23, 0x45, 0x67, 0x89, 0xab, 0xcd, 0xef}
+
+const testUUIDString = "12345678-9ABC-DEF0-0123-456789ABCDEF"
+
+func syntheticVHD(diskType uint32, virtualSize uint64, blockSize uint32, parent string) []byte {
+	footer := make([]byte, footerSize)
+	copy(footer, footerCookie)
+	binary.BigEndian.PutUint32(footer[12:], 0x00010000)
+	binary.BigEndian.PutUint64(footer[16:], noDataOffset)
+	binary.BigEndian.PutUint64(footer[40:], virtualSize)
+	binary.BigEndian.PutUint64(footer[48:], virtualSize)
+	binary.BigEndian.PutUint32(footer[60:], diskType)
+	copy(footer[68:], testUUID)
+
+	var img []byte
+	if diskType == vhdTypeFixed {
+		img = make([]byte, 4096)
+	} else {
+		binary.BigEndian.PutUint64(footer[16:], footerSize)
+		header := make([]byte, dynamicHeaderSize)
+		copy(header, dynamicCookie)
+		binary.BigEndian.PutUint64(header[8:], noDataOffset)
+		binary.BigEndian.PutUint64(header[16:], footerSize+dynamicHeaderSize)
+		binary.BigEndian.PutUint32(header[24:], 0x00010000)
+		binary.BigEndian.PutUint32(header[32:], blockSize)
+		locator := []byte(nil)
+		if parent != "" {
+			copy(header[40:], testUUID)
+			locator = utf16LE(parent)
+			entry := header[parentLocatorOffset:]
+			copy(entry, platformW2ku)
+			binary.BigEndian.PutUint32(entry[4:], 1)
+			binary.BigEndian.PutUint32(entry[8:], uint32(len(locator)))
+			binary.BigEndian.PutUint64(entry[16:], footerSize+dynamicHeaderSize+512)
+		}
+		binary.BigEndian.PutUint32(header[dynamicChecksumOffset:], vhdChecksum(header, dynamicChecksumOffset))
+		img = append(img, make([]byte, footerSize)...)
+		img = append(img, header...)
+		img = append(img, make([]byte, 512)...)
+		img = append(img, locator...)
+		img = append(img, make([]byte, 512)...)
+		copy(img, footer)
+	}
+	binary.BigEndian.PutUint32(footer[footerChecksumOffset:], vhdChecksum(footer, footerChecksumOffset))
+	if diskType != vhdTypeFixed {
+		copy(img, footer)
+	}
+	return append(img, footer...)
+}
+
+type metadataItem struct {
+	id   GUID
+	data []byte
+}
+
+func syntheticVHDX(flags uint32, virtualSize uint64, blockSize, logical, physical uint32, locator map[string]string) []byte {
+	const metadataOffset = 1 * mib
+	const batOffset = 2 * mib
+	img := make([]byte, 3*mib)
+	copy(img, vhdxSignature)
+
+	for i, off := range []int{vhdxHeader1Offset, vhdxHeader2Offset} {
+		h := img[off : off+vhdxHeaderSize]
+		copy(h, vhdxHeaderSignature)
+		binary.LittleEndian.PutUint64(h[8:], uint64(i+1))
+		binary.LittleEndian.PutUint16(h[66:], 1)
+		binary.LittleEndian.PutUint32(h[4:], vhdxChecksum(h))
+	}
+	for _, off := range []int{vhdxRegionTable1Offset, vhdxRegionTable2Offset} {
+		t := img[off : off+vhdxRegionTableSize]
+		copy(t, vhdxRegionSignature)
+		binary.LittleEndian.PutUint32(t[8:], 2)
+		for i, r := range []struct {
+			id     GUID
+			offset uint64
+		}{{regionBAT, batOffset}, {regionMetadata, metadataOffset}} {
+			e := t[16+i*vhdxRegionEntrySize:]
+			copy(e, r.id[:])
+			binary.LittleEndian.PutUint64(e[16:], r.offset)
+			binary.LittleEndian.PutUint32(e[24:], 1*mib)
+			binary.LittleEndian.PutUint32(e[28:], 1)
+		}
+		binary.LittleEndian.PutUint32(t[4:], vhdxChecksum(t))
+	}
+
+	u32 := func(v uint32) []byte { b := make([]byte, 4); binary.LittleEndian.PutUint32(b, v); return b }
+	params := append(u32(blockSize), u32(flags)...)
+	size := make([]byte, 8)
+	binary.LittleEndian.PutUint64(size, virtualSize)
+	id := guidFromUUID(testUUID)
+	items := []metadataItem{
+		{metadataFileParameters, params},
+		{metadataVirtualDiskSize, size},
+		{metadataVirtualDiskID, id[:]},
+		{metadataLogicalSectorSize, u32(logical)},
+		{metadataPhysicalSectorSize, u32(physical)},
+	}
+	if locator != nil {
+		var keys, values [][]byte
+		for k, v := range locator {
+			keys = append(keys, utf16LE(k))
+			values = append(values, utf16LE(v))
+		}
+		b := make([]byte, 20+12*len(keys))
+		copy(b, parentLocatorTypeVHDX[:])
+		binary.LittleEndian.PutUint16(b[18:], uint16(len(keys)))
+		for i := range keys {
+			e := b[20+i*12:]
+			binary.LittleEndian.PutUint32(e[0:], uint32(len(b)))
+			b = append(b, keys[i]...)
+			e = b[20+i*12:]
+			binary.LittleEndian.PutUint32(e[4:], uint32(len(b)))
+			b = append(b, values[i]...)
+			e = b[20+i*12:]
+			binary.LittleEndian.PutUint16(e[8:], uint16(len(keys[i])))
+			binary.LittleEndian.PutUint16(e[10:], uint16(len(values[i])))
+		}
+		items = append(items, metadataItem{metadataParentLocator, b})
+	}
+
+	meta := img[metadataOffset : metadataOffset+1*mib]
+	copy(meta, vhdxMetadataSignature)
+	binary.LittleEndian.PutUint16(meta[10:], uint16(len(items)))
+	dataOff := vhdxMetadataTableSize
+	for i, item := range items {
+		e := meta[32+i*vhdxMetadataEntrySize:]
+		copy(e, item.id[:])
+		binary.LittleEndian.PutUint32(e[16:], uint32(dataOff))
+		binary.LittleEndian.PutUint32(e[20:], uint32(len(item.data)))
+		copy(meta[dataOff:], item.data)
+		dataOff += len(item.data)
+	}
+	return img
+}
+
+func inspectBytes(t *testing.T, img []byte) *Info {
+	t.Helper()
+	info, err := InspectReader(bytes.NewReader(img), int64(len(img)))
+	if err != nil {
+		t.Fatalf("InspectReader failed: %v", err)
+	}
+	return info
+}
+
+func TestInspectVHD(t *testing.T) {
+	cases := []struct {
+		name      string
+		img       []byte
+		diskType  string
+		blockSize uint32
+		parent    string
+	}{
+		{"fixed", syntheticVHD(vhdTypeFixed, 4096, 0, ""), TypeFixed, 0, ""},
+		{"dynamic", syntheticVHD(vhdTypeDynamic, 1<<30, 2*mib, ""), TypeDynamic, 2 * mib, ""},
+		{"differencing", syntheticVHD(vhdTypeDifferencing, 1<<30, 2*mib, `C:\base\parent.vhd`), TypeDifferencing, 2 * mib, `C:\base\parent.vhd`},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			info := inspectBytes(t, tc.img)
+			if info.Format != FormatVHD || info.DiskType != tc.diskType || info.BlockSize != tc.blockSize || info.ParentPath != tc.parent {
+				t.Errorf("unexpected info: %+v", info)
+			}
+			if info.LogicalSectorSize != 512 || info.PhysicalSectorSize != 512 {
+				t.Errorf("unexpected sector sizes: %+v", info)
+			}
+			if info.DiskID != testUUIDString || info.PhysicalSize != int64(len(tc.img)) {
+				t.Errorf("unexpected identity: %+v", info)
+			}
+		})
+	}
+}
+
+func TestInspectVHDRejectsBadChecksum(t *testing.T) {
+	img := syntheticVHD(vhdTypeFixed, 4096, 0, "")
+	img[len(img)-footerSize+48] ^= 0xFF
+	if _, err := InspectReader(bytes.NewReader(img), int64(len(img))); err == nil {
+		t.Fatalf("expected checksum error")
+	}
+}
+
+func TestInspectVHDX(t *testing.T) {
+	cases := []struct {
+		name     string
+		img      []byte
+		diskType string
+		parent   string
+	}{
+		{"fixed", syntheticVHDX(vhdxLeaveBlocksAllocated, 10*mib, 32*mib, 512, 4096, nil), TypeFixed, ""},
+		{"dynamic", syntheticVHDX(0, 10*mib, 32*mib, 512, 4096, nil), TypeDynamic, ""},
+		{"differencing", syntheticVHDX(vhdxHasParent, 10*mib, 32*mib, 512, 4096, map[string]string{
+			"parent_linkage":      "{" + testUUIDString + "}",
+			"relative_path":       `..\base.vhdx`,
+			"absolute_win32_path": `\\?\C:\base.vhdx`,
+		}), TypeDifferencing, `\\?\C:\base.vhdx`},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			info := inspectBytes(t, tc.img)
+			if info.Format != FormatVHDX || info.DiskType != tc.diskType || info.ParentPath != tc.parent {
+				t.Errorf("unexpected info: %+v", info)
+			}
+			if info.VirtualSize != 10*mib || info.BlockSize != 32*mib || info.LogicalSectorSize != 512 || info.PhysicalSectorSize != 4096 {
+				t.Errorf("unexpected geometry: %+v", info)
+			}
+			if info.DiskID != testUUIDString {
+				t.Errorf("DiskID = %s", info.DiskID)
+			}
+			if tc.parent != "" && info.ParentID != testUUIDString {
+				t.Errorf("ParentID = %s", info.ParentID)
+			}
+		})
+	}
+}
+
+func TestInspectVHDXUsesNewestValidHeader(t *testing.T) {
+	img := syntheticVHDX(0, 10*mib, 32*mib, 512, 512, nil)
+	// Corrupt the newer header; the older one must still be accepted.
+	img[vhdxHeader2Offset+100] ^= 0xFF
+	inspectBytes(t, img)
+
+	img[vhdxHeader1Offset+100] ^= 0xFF
+	if _, err := InspectReader(bytes.NewReader(img), int64(len(img))); err == nil {
+		t.Fatalf("expected error when no header is valid")
+	}
+}
+
+func TestInspectFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "disk.vhdx")
+	if err := os.WriteFile(path, syntheticVHDX(0, 10*mib, 32*mib, 512, 512, nil), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if info, err := Inspect(path); err != nil || info.Format != FormatVHDX {
+		t.Fatalf("Inspect = %+v, %v", info, err)
+	}
+
+	if _, err := Inspect(filepath.Join(t.TempDir(), "missing.vhdx")); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("expected not-exist error, got %v", err)
+	}
+
+	junk := filepath.Join(t.TempDir(), "junk.vhd")
+	if err := os.WriteFile(junk, make([]byte, 1024), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Inspect(junk); !errors.Is(err, ErrUnknownFormat) {
+		t.Fatalf("expected ErrUnknownFormat, got %v", err)
+	}
+}
+
+func TestGUIDRoundTrip(t *testing.T) {
+	g, err := ParseGUID("{2DC27766-F623-4200-9D64-115E9BFD4A08}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if g[0] != 0x66 || g[3] != 0x2D || g[8] != 0x9D {
+		t.Errorf("unexpected on-disk layout %x", g[:])
+	}
+	if g.String() != "2DC27766-F623-4200-9D64-115E9BFD4A08" {
+		t.Errorf("String() = %s", g)
+	}
+}
+
+func TestInspectVHDXRejectsCorruptMetadata(t *testing.T) {
+	const metadataOffset = 1 * mib
+	setMetadataRegion := func(img []byte, offset uint64, length uint32) {
+		for _, off := range []int{vhdxRegionTable1Offset, vhdxRegionTable2Offset} {
+			tbl := img[off : off+vhdxRegionTableSize]
+			e := tbl[16+vhdxRegionEntrySize:]
+			binary.LittleEndian.PutUint64(e[16:], offset)
+			binary.LittleEndian.PutUint32(e[24:], length)
+			binary.LittleEndian.PutUint32(tbl[4:], 0)
+			binary.LittleEndian.PutUint32(tbl[4:], vhdxChecksum(tbl))
+		}
+	}
+	cases := []struct {
+		name    string
+		want    string
+		corrupt func(img []byte)
+	}{
+		{"entry count beyond table", "metadata table is truncated", func(img []byte) {
+			binary.LittleEndian.PutUint16(img[metadataOffset+10:], 0xFFFF)
+		}},
+		{"item beyond region", "out of range", func(img []byte) {
+			binary.LittleEndian.PutUint32(img[metadataOffset+32+16:], 1*mib-4)
+		}},
+		{"item length overflow", "out of range", func(img []byte) {
+			binary.LittleEndian.PutUint32(img[metadataOffset+32+20:], 0xFFFFFFFF)
+		}},
+		{"negative region offset", "metadata region is out of range", func(img []byte) { setMetadataRegion(img, 1<<63, 1*mib) }},
+		{"region beyond file", "metadata region is out of range", func(img []byte) { setMetadataRegion(img, 3*mib-vhdxMetadataTableSize/2, vhdxMetadataTableSize) }},
+		{"region too small", "metadata region is out of range", func(img []byte) { setMetadataRegion(img, metadataOffset, 16) }},
+		{"truncated parent locator", "parent locator is truncated", func(img []byte) {
+			binary.LittleEndian.PutUint16(img[metadataOffset+32+5*vhdxMetadataEntrySize+20:], 20)
+			binary.LittleEndian.PutUint16(img[metadataOffset+32+5*vhdxMetadataEntrySize+22:], 0)
+		}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			img := syntheticVHDX(vhdxHasParent, 10*mib, 32*mib, 512, 512, map[string]string{"parent_linkage": "{" + testUUIDString + "}"})
+			tc.corrupt(img)
+			if _, err := InspectReader(bytes.NewReader(img), int64(len(img))); err == nil || !strings.Contains(err.Error(), tc.want) {
+				t.Errorf("InspectReader = %v, want error containing %q", err, tc.want)
+			}
+		})
+	}
+}
+
+func FuzzInspectReader(f *testing.F) {
+	f.Add(syntheticVHD(vhdTypeDynamic, 1<<30, 2*mib, `C:\base\parent.vhd`))
+	f.Add(syntheticVHDX(vhdxHasParent, 10*mib, 32*mib, 512, 4096, map[string]string{"parent_linkage": "{" + testUUIDString + "}"}))
+	f.Fuzz(func(t *testing.T, img []byte) {
+		// Any input may be rejected, but none may panic.
+		_, _ = InspectReader(bytes.NewReader(img), int64(len(img)))
+	})
+}
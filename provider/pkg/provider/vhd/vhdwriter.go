@@ -0,0 +1,197 @@
+// Copyright 2016-2022, Pulumi Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vhd
+
+import (
+	"encoding/binary"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+	"unicode/utf16"
+)
+
+// Defaults and limits used by Hyper-V for legacy VHD files.
+const (
+	vhdDefaultBlockSize = 2 * mib
+	vhdMaxVirtualSize   = 2040 * 1024 * mib
+	vhdBATOffset        = footerSize + dynamicHeaderSize
+)
+
+// vhdEpoch is the reference point of VHD timestamps.
+var vhdEpoch = time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+
+func vhdTimestamp(t time.Time) uint32 {
+	return uint32(t.Sub(vhdEpoch) / time.Second)
+}
+
+// vhdGeometry computes the CHS geometry stored in the footer, following the algorithm
+// in the VHD specification.
+func vhdGeometry(size uint64) uint32 {
+	totalSectors := size / vhdSectorSize
+	if totalSectors > 65535*16*255 {
+		totalSectors = 65535 * 16 * 255
+	}
+	var sectorsPerTrack, heads, cylinderTimesHeads uint64
+	if totalSectors >= 65535*16*63 {
+		sectorsPerTrack, heads = 255, 16
+		cylinderTimesHeads = totalSectors / sectorsPerTrack
+	} else {
+		sectorsPerTrack = 17
+		cylinderTimesHeads = totalSectors / sectorsPerTrack
+		heads = (cylinderTimesHeads + 1023) / 1024
+		if heads < 4 {
+			heads = 4
+		}
+		if cylinderTimesHeads >= heads*1024 || heads > 16 {
+			sectorsPerTrack, heads = 31, 16
+			cylinderTimesHeads = totalSectors / sectorsPerTrack
+		}
+		if cylinderTimesHeads >= heads*1024 {
+			sectorsPerTrack, heads = 63, 16
+			cylinderTimesHeads = totalSectors / sectorsPerTrack
+		}
+	}
+	cylinders := cylinderTimesHeads / heads
+	return uint32(cylinders<<16 | heads<<8 | sectorsPerTrack)
+}
+
+func vhdFooter(diskType uint32, size, dataOffset uint64, id GUID, now time.Time) []byte {
+	footer := make([]byte, footerSize)
+	copy(footer, footerCookie)
+	binary.BigEndian.PutUint32(footer[8:], 2)
+	binary.BigEndian.PutUint32(footer[12:], 0x00010000)
+	binary.BigEndian.PutUint64(footer[16:], dataOffset)
+	binary.BigEndian.PutUint32(footer[24:], vhdTimestamp(now))
+	copy(footer[28:], "plmi")
+	binary.BigEndian.PutUint32(footer[32:], 0x000A0000)
+	copy(footer[36:], "Wi2k")
+	binary.BigEndian.PutUint64(footer[40:], size)
+	binary.BigEndian.PutUint64(footer[48:], size)
+	binary.BigEndian.PutUint32(footer[56:], vhdGeometry(size))
+	binary.BigEndian.PutUint32(footer[60:], diskType)
+	// VHD stores identifiers as big-endian UUIDs.
+	binary.BigEndian.PutUint32(footer[68:], binary.LittleEndian.Uint32(id[0:]))
+	binary.BigEndian.PutUint16(footer[72:], binary.LittleEndian.Uint16(id[4:]))
+	binary.BigEndian.PutUint16(footer[74:], binary.LittleEndian.Uint16(id[6:]))
+	copy(footer[76:84], id[8:])
+	binary.BigEndian.PutUint32(footer[footerChecksumOffset:], vhdChecksum(footer, footerChecksumOffset))
+	return footer
+}
+
+func utf16Bytes(s string, order binary.ByteOrder) []byte {
+	units := utf16.Encode([]rune(s))
+	b := make([]byte, len(units)*2)
+	for i, u := range units {
+		order.PutUint16(b[i*2:], u)
+	}
+	return b
+}
+
+// writeVHD lays out a legacy VHD file. Fixed disks are the raw data followed by the
+// footer. Dynamic and differencing disks carry a copy of the footer, the dynamic header,
+// an empty block allocation table and, for differencing disks, the parent locators.
+func writeVHD(f *os.File, path string, opts CreateOptions, parent *os.File) error {
+	if (opts.LogicalSectorSize != 0 && opts.LogicalSectorSize != vhdSectorSize) || (opts.PhysicalSectorSize != 0 && opts.PhysicalSectorSize != vhdSectorSize) {
+		return fmt.Errorf("VHD files only support 512 byte sectors")
+	}
+	if opts.VirtualSize == 0 || opts.VirtualSize%vhdSectorSize != 0 || opts.VirtualSize > vhdMaxVirtualSize {
+		return fmt.Errorf("VHD virtual size must be a non-zero multiple of 512 bytes up to 2040 GB, got %d", opts.VirtualSize)
+	}
+	if opts.BlockSize == 0 {
+		opts.BlockSize = vhdDefaultBlockSize
+	}
+	if opts.DiskType != TypeFixed && opts.BlockSize != 512*kib && opts.BlockSize != 2*mib {
+		return fmt.Errorf("VHD block size must be 512 KB or 2 MB, got %d", opts.BlockSize)
+	}
+
+	id, err := newGUID()
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+
+	if opts.DiskType == TypeFixed {
+		if err := f.Truncate(int64(opts.VirtualSize)); err != nil {
+			return err
+		}
+		_, err := f.WriteAt(vhdFooter(vhdTypeFixed, opts.VirtualSize, noDataOffset, id, now), int64(opts.VirtualSize))
+		return err
+	}
+
+	diskType := uint32(vhdTypeDynamic)
+	if opts.DiskType == TypeDifferencing {
+		diskType = vhdTypeDifferencing
+	}
+	entries := (opts.VirtualSize + uint64(opts.BlockSize) - 1) / uint64(opts.BlockSize)
+	bat := make([]byte, roundUp(entries*4, vhdSectorSize))
+	for i := range bat {
+		bat[i] = 0xFF
+	}
+
+	header := make([]byte, dynamicHeaderSize)
+	copy(header, dynamicCookie)
+	binary.BigEndian.PutUint64(header[8:], noDataOffset)
+	binary.BigEndian.PutUint64(header[16:], vhdBATOffset)
+	binary.BigEndian.PutUint32(header[24:], 0x00010000)
+	binary.BigEndian.PutUint32(header[28:], uint32(entries))
+	binary.BigEndian.PutUint32(header[32:], opts.BlockSize)
+
+	next := uint64(vhdBATOffset) + uint64(len(bat))
+	var locators [][]byte
+	if diskType == vhdTypeDifferencing {
+		st, err := parent.Stat()
+		if err != nil {
+			return err
+		}
+		parentFooter := make([]byte, footerSize)
+		if _, err := parent.ReadAt(parentFooter, st.Size()-footerSize); err != nil {
+			return fmt.Errorf("failed to read parent disk footer: %v", err)
+		}
+		copy(header[40:56], parentFooter[68:84])
+		binary.BigEndian.PutUint32(header[56:], vhdTimestamp(st.ModTime()))
+		copy(header[64:576], utf16Bytes(filepath.Base(opts.ParentPath), binary.BigEndian))
+
+		absolute, relative := parentLocatorPaths(path, opts.ParentPath)
+		slot := 0
+		for _, l := range []struct{ code, value string }{{platformW2ku, absolute}, {platformW2ru, relative}} {
+			if l.value == "" {
+				continue
+			}
+			data := utf16Bytes(l.value, binary.LittleEndian)
+			space := roundUp(uint64(len(data)), vhdSectorSize)
+			entry := header[parentLocatorOffset+slot*parentLocatorSize:]
+			copy(entry, l.code)
+			binary.BigEndian.PutUint32(entry[4:], uint32(space))
+			binary.BigEndian.PutUint32(entry[8:], uint32(len(data)))
+			binary.BigEndian.PutUint64(entry[16:], next)
+			padded := make([]byte, space)
+			copy(padded, data)
+			locators = append(locators, padded)
+			next += space
+			slot++
+		}
+	}
+	binary.BigEndian.PutUint32(header[dynamicChecksumOffset:], vhdChecksum(header, dynamicChecksumOffset))
+
+	footer := vhdFooter(diskType, opts.VirtualSize, footerSize, id, now)
+	data := append(append(append([]byte{}, footer...), header...), bat...)
+	for _, l := range locators {
+		data = append(data, l...)
+	}
+	data = append(data, footer...)
+	_, err = f.WriteAt(data, 0)
+	return err
+}
@@ -0,0 +1,253 @@
+// Copyright 2016-2022, Pulumi Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vhd
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"strings"
+)
+
+// Layout of the VHDX format, as described in [MS-VHDX]. All integers are little-endian.
+const (
+	vhdxSignature         = "vhdxfile"
+	vhdxHeaderSignature   = "head"
+	vhdxRegionSignature   = "regi"
+	vhdxMetadataSignature = "metadata"
+
+	kib = 1024
+	mib = 1024 * kib
+
+	vhdxHeader1Offset      = 64 * kib
+	vhdxHeader2Offset      = 128 * kib
+	vhdxHeaderSize         = 4 * kib
+	vhdxRegionTable1Offset = 192 * kib
+	vhdxRegionTable2Offset = 256 * kib
+	vhdxRegionTableSize    = 64 * kib
+	vhdxRegionEntrySize    = 32
+	vhdxMetadataEntrySize  = 32
+	vhdxMetadataTableSize  = 64 * kib
+
+	// File parameter flags.
+	vhdxLeaveBlocksAllocated = 1 << 0
+	vhdxHasParent            = 1 << 1
+)
+
+// Region and metadata item identifiers.
+var (
+	regionBAT      = mustGUID("2DC27766-F623-4200-9D64-115E9BFD4A08")
+	regionMetadata = mustGUID("8B7CA206-4790-4B9A-B8FE-575F050F886E")
+
+	metadataFileParameters     = mustGUID("CAA16737-FA36-4D43-B3B6-33F0AA44E76B")
+	metadataVirtualDiskSize    = mustGUID("2FA54224-CD1B-4876-B211-5DBED83BF4B8")
+	metadataVirtualDiskID      = mustGUID("BECA12AB-B2E6-4523-93EF-C309E000C746")
+	metadataLogicalSectorSize  = mustGUID("8141BF1D-A96F-4709-BA47-F233A8FAAB5F")
+	metadataPhysicalSectorSize = mustGUID("CDA348C7-445D-4471-9CC9-E9885251C556")
+	metadataParentLocator      = mustGUID("A8D35F2B-B30B-454D-ABF7-D3D84834AB0C")
+
+	parentLocatorTypeVHDX = mustGUID("B04AEFB7-D19E-4A81-B789-25B8E9445913")
+)
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// vhdxChecksum is the CRC-32C of b with the 4 byte checksum field at offset 4 zeroed.
+func vhdxChecksum(b []byte) uint32 {
+	c := crc32.Update(0, castagnoli, b[:4])
+	c = crc32.Update(c, castagnoli, []byte{0, 0, 0, 0})
+	return crc32.Update(c, castagnoli, b[8:])
+}
+
+func readGUID(b []byte) GUID {
+	var g GUID
+	copy(g[:], b[:16])
+	return g
+}
+
+// readVHDXHeader returns the header with the highest sequence number whose checksum is valid.
+func readVHDXHeader(r io.ReaderAt) ([]byte, error) {
+	var current []byte
+	var sequence uint64
+	for _, off := range []int64{vhdxHeader1Offset, vhdxHeader2Offset} {
+		h := make([]byte, vhdxHeaderSize)
+		if _, err := r.ReadAt(h, off); err != nil {
+			continue
+		}
+		if string(h[0:4]) != vhdxHeaderSignature || binary.LittleEndian.Uint32(h[4:]) != vhdxChecksum(h) {
+			continue
+		}
+		if seq := binary.LittleEndian.Uint64(h[8:]); current == nil || seq > sequence {
+			current, sequence = h, seq
+		}
+	}
+	if current == nil {
+		return nil, fmt.Errorf("VHDX file has no valid header")
+	}
+	return current, nil
+}
+
+// readVHDXRegions returns the file offset and length of every region in the first valid
+// region table.
+func readVHDXRegions(r io.ReaderAt) (map[GUID][2]int64, error) {
+	for _, off := range []int64{vhdxRegionTable1Offset, vhdxRegionTable2Offset} {
+		t := make([]byte, vhdxRegionTableSize)
+		if _, err := r.ReadAt(t, off); err != nil {
+			continue
+		}
+		if string(t[0:4]) != vhdxRegionSignature || binary.LittleEndian.Uint32(t[4:]) != vhdxChecksum(t) {
+			continue
+		}
+		count := int(binary.LittleEndian.Uint32(t[8:]))
+		if 16+count*vhdxRegionEntrySize > len(t) {
+			continue
+		}
+		regions := map[GUID][2]int64{}
+		for i := 0; i < count; i++ {
+			e := t[16+i*vhdxRegionEntrySize:]
+			regions[readGUID(e)] = [2]int64{int64(binary.LittleEndian.Uint64(e[16:])), int64(binary.LittleEndian.Uint32(e[24:]))}
+		}
+		return regions, nil
+	}
+	return nil, fmt.Errorf("VHDX file has no valid region table")
+}
+
+func inspectVHDX(r io.ReaderAt, size int64) (*Info, error) {
+	if _, err := readVHDXHeader(r); err != nil {
+		return nil, err
+	}
+	regions, err := readVHDXRegions(r)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := regions[regionBAT]; !ok {
+		return nil, fmt.Errorf("VHDX file has no block allocation table region")
+	}
+	meta, ok := regions[regionMetadata]
+	if !ok {
+		return nil, fmt.Errorf("VHDX file has no metadata region")
+	}
+	if meta[0] < 0 || meta[1] < vhdxMetadataTableSize || meta[0]+meta[1] > size {
+		return nil, fmt.Errorf("VHDX metadata region is out of range")
+	}
+	region := make([]byte, meta[1])
+	if _, err := r.ReadAt(region, meta[0]); err != nil {
+		return nil, err
+	}
+	if len(region) < 32 || string(region[0:8]) != vhdxMetadataSignature {
+		return nil, fmt.Errorf("VHDX metadata table has an invalid signature")
+	}
+
+	items := map[GUID][]byte{}
+	count := int(binary.LittleEndian.Uint16(region[10:]))
+	if 32+count*vhdxMetadataEntrySize > len(region) {
+		return nil, fmt.Errorf("VHDX metadata table is truncated")
+	}
+	for i := 0; i < count; i++ {
+		e := region[32+i*vhdxMetadataEntrySize:]
+		offset := int(binary.LittleEndian.Uint32(e[16:]))
+		length := int(binary.LittleEndian.Uint32(e[20:]))
+		if offset < 0 || length < 0 || offset+length > len(region) {
+			return nil, fmt.Errorf("VHDX metadata item %s is out of range", readGUID(e))
+		}
+		items[readGUID(e)] = region[offset : offset+length]
+	}
+
+	required := func(id GUID, n int, name string) ([]byte, error) {
+		item, ok := items[id]
+		if !ok || len(item) < n {
+			return nil, fmt.Errorf("VHDX metadata is missing %s", name)
+		}
+		return item, nil
+	}
+	params, err := required(metadataFileParameters, 8, "file parameters")
+	if err != nil {
+		return nil, err
+	}
+	diskSize, err := required(metadataVirtualDiskSize, 8, "virtual disk size")
+	if err != nil {
+		return nil, err
+	}
+	diskID, err := required(metadataVirtualDiskID, 16, "virtual disk ID")
+	if err != nil {
+		return nil, err
+	}
+	logical, err := required(metadataLogicalSectorSize, 4, "logical sector size")
+	if err != nil {
+		return nil, err
+	}
+	physical, err := required(metadataPhysicalSectorSize, 4, "physical sector size")
+	if err != nil {
+		return nil, err
+	}
+
+	info := &Info{
+		Format:             FormatVHDX,
+		DiskType:           TypeDynamic,
+		VirtualSize:        binary.LittleEndian.Uint64(diskSize),
+		BlockSize:          binary.LittleEndian.Uint32(params),
+		LogicalSectorSize:  binary.LittleEndian.Uint32(logical),
+		PhysicalSectorSize: binary.LittleEndian.Uint32(physical),
+		DiskID:             readGUID(diskID).String(),
+	}
+	flags := binary.LittleEndian.Uint32(params[4:])
+	switch {
+	case flags&vhdxHasParent != 0:
+		info.DiskType = TypeDifferencing
+		locator, err := required(metadataParentLocator, 20, "parent locator")
+		if err != nil {
+			return nil, err
+		}
+		entries, err := parseParentLocator(locator)
+		if err != nil {
+			return nil, err
+		}
+		info.ParentID = strings.ToUpper(strings.Trim(entries["parent_linkage"], "{}"))
+		for _, key := range []string{"absolute_win32_path", "relative_path", "volume_path"} {
+			if entries[key] != "" {
+				info.ParentPath = entries[key]
+				break
+			}
+		}
+	case flags&vhdxLeaveBlocksAllocated != 0:
+		info.DiskType = TypeFixed
+	}
+	return info, nil
+}
+
+// parseParentLocator decodes the key/value entries of a VHDX parent locator.
+func parseParentLocator(b []byte) (map[string]string, error) {
+	if len(b) < 20 {
+		return nil, fmt.Errorf("VHDX parent locator is truncated")
+	}
+	if readGUID(b) != parentLocatorTypeVHDX {
+		return nil, fmt.Errorf("unsupported VHDX parent locator type %s", readGUID(b))
+	}
+	count := int(binary.LittleEndian.Uint16(b[18:]))
+	if 20+count*12 > len(b) {
+		return nil, fmt.Errorf("VHDX parent locator is truncated")
+	}
+	entries := map[string]string{}
+	for i := 0; i < count; i++ {
+		e := b[20+i*12:]
+		keyOff, valOff := int(binary.LittleEndian.Uint32(e[0:])), int(binary.LittleEndian.Uint32(e[4:]))
+		keyLen, valLen := int(binary.LittleEndian.Uint16(e[8:])), int(binary.LittleEndian.Uint16(e[10:]))
+		if keyOff < 0 || valOff < 0 || keyOff+keyLen > len(b) || valOff+valLen > len(b) {
+			return nil, fmt.Errorf("VHDX parent locator entry %d is out of range", i)
+		}
+		entries[decodeUTF16(b[keyOff:keyOff+keyLen], binary.LittleEndian)] = decodeUTF16(b[valOff:valOff+valLen], binary.LittleEndian)
+	}
+	return entries, nil
+}
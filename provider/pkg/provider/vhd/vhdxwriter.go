@@ -0,0 +1,276 @@
+// Copyright 2016-2022, Pulumi Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vhd
+
+import (
+	"encoding/binary"
+	"fmt"
+	"os"
+	"unicode/utf16"
+)
+
+// Defaults used by Hyper-V for new VHDX files.
+const (
+	vhdxDefaultBlockSize             = 32 * mib
+	vhdxDefaultDifferencingBlockSize = 2 * mib
+	vhdxDefaultLogicalSectorSize     = 512
+	vhdxDefaultPhysicalSectorSize    = 4096
+	vhdxMinBlockSize                 = 1 * mib
+	vhdxMaxBlockSize                 = 256 * mib
+	vhdxMaxVirtualSize               = 64 * 1024 * 1024 * mib
+
+	// Region placement. Every region is aligned to 1 MB as the specification requires.
+	vhdxLogOffset      = 1 * mib
+	vhdxLogLength      = 1 * mib
+	vhdxMetadataOffset = 2 * mib
+	vhdxMetadataLength = 1 * mib
+	vhdxBATOffset      = 3 * mib
+
+	// Block allocation table entry states.
+	payloadBlockNotPresent   = 0
+	payloadBlockFullyPresent = 6
+
+	// Metadata entry flags.
+	metadataIsVirtualDisk = 1 << 1
+	metadataIsRequired    = 1 << 2
+
+	vhdxCreator = "pulumi-hyperv"
+)
+
+// vhdxDataWriteGUID returns the DataWriteGuid of the current header, which a differencing
+// disk records as its parent linkage.
+func vhdxDataWriteGUID(f *os.File) (GUID, error) {
+	h, err := readVHDXHeader(f)
+	if err != nil {
+		return GUID{}, err
+	}
+	return readGUID(h[32:]), nil
+}
+
+func validateVHDXOptions(opts *CreateOptions) error {
+	if opts.LogicalSectorSize == 0 {
+		opts.LogicalSectorSize = vhdxDefaultLogicalSectorSize
+	}
+	if opts.PhysicalSectorSize == 0 {
+		opts.PhysicalSectorSize = vhdxDefaultPhysicalSectorSize
+	}
+	if opts.BlockSize == 0 {
+		opts.BlockSize = vhdxDefaultBlockSize
+		if opts.DiskType == TypeDifferencing {
+			opts.BlockSize = vhdxDefaultDifferencingBlockSize
+		}
+	}
+	for _, size := range []uint32{opts.LogicalSectorSize, opts.PhysicalSectorSize} {
+		if size != 512 && size != 4096 {
+			return fmt.Errorf("VHDX sector sizes must be 512 or 4096 bytes, got %d", size)
+		}
+	}
+	if !isPowerOfTwo(opts.BlockSize) || opts.BlockSize < vhdxMinBlockSize || opts.BlockSize > vhdxMaxBlockSize {
+		return fmt.Errorf("VHDX block size must be a power of two between 1 MB and 256 MB, got %d", opts.BlockSize)
+	}
+	if opts.VirtualSize == 0 || opts.VirtualSize%uint64(opts.LogicalSectorSize) != 0 || opts.VirtualSize > vhdxMaxVirtualSize {
+		return fmt.Errorf("VHDX virtual size must be a non-zero multiple of %d bytes up to 64 TB, got %d", opts.LogicalSectorSize, opts.VirtualSize)
+	}
+	return nil
+}
+
+func vhdxHeader(sequence uint64, fileWrite, dataWrite GUID) []byte {
+	h := make([]byte, vhdxHeaderSize)
+	copy(h, vhdxHeaderSignature)
+	binary.LittleEndian.PutUint64(h[8:], sequence)
+	copy(h[16:], fileWrite[:])
+	copy(h[32:], dataWrite[:])
+	// A zero LogGuid marks the log as empty, so nothing is replayed on open.
+	binary.LittleEndian.PutUint16(h[66:], 1)
+	binary.LittleEndian.PutUint32(h[68:], vhdxLogLength)
+	binary.LittleEndian.PutUint64(h[72:], vhdxLogOffset)
+	binary.LittleEndian.PutUint32(h[4:], vhdxChecksum(h))
+	return h
+}
+
+func vhdxRegionTable(batLength uint32) []byte {
+	t := make([]byte, vhdxRegionTableSize)
+	copy(t, vhdxRegionSignature)
+	binary.LittleEndian.PutUint32(t[8:], 2)
+	for i, r := range []struct {
+		id     GUID
+		offset uint64
+		length uint32
+	}{
+		{regionBAT, vhdxBATOffset, batLength},
+		{regionMetadata, vhdxMetadataOffset, vhdxMetadataLength},
+	} {
+		e := t[16+i*vhdxRegionEntrySize:]
+		copy(e, r.id[:])
+		binary.LittleEndian.PutUint64(e[16:], r.offset)
+		binary.LittleEndian.PutUint32(e[24:], r.length)
+		binary.LittleEndian.PutUint32(e[28:], 1)
+	}
+	binary.LittleEndian.PutUint32(t[4:], vhdxChecksum(t))
+	return t
+}
+
+func vhdxParentLocator(entries [][2]string) []byte {
+	b := make([]byte, 20+12*len(entries))
+	copy(b, parentLocatorTypeVHDX[:])
+	binary.LittleEndian.PutUint16(b[18:], uint16(len(entries)))
+	encode := func(s string) []byte {
+		units := utf16.Encode([]rune(s))
+		out := make([]byte, len(units)*2)
+		for i, u := range units {
+			binary.LittleEndian.PutUint16(out[i*2:], u)
+		}
+		return out
+	}
+	for i, kv := range entries {
+		key, value := encode(kv[0]), encode(kv[1])
+		binary.LittleEndian.PutUint32(b[20+i*12:], uint32(len(b)))
+		b = append(b, key...)
+		binary.LittleEndian.PutUint32(b[20+i*12+4:], uint32(len(b)))
+		b = append(b, value...)
+		binary.LittleEndian.PutUint16(b[20+i*12+8:], uint16(len(key)))
+		binary.LittleEndian.PutUint16(b[20+i*12+10:], uint16(len(value)))
+	}
+	return b
+}
+
+func vhdxMetadata(opts CreateOptions, diskID GUID, locator []byte) []byte {
+	u32 := func(v uint32) []byte { b := make([]byte, 4); binary.LittleEndian.PutUint32(b, v); return b }
+	var flags uint32
+	switch opts.DiskType {
+	case TypeFixed:
+		flags = vhdxLeaveBlocksAllocated
+	case TypeDifferencing:
+		flags = vhdxHasParent
+	}
+	size := make([]byte, 8)
+	binary.LittleEndian.PutUint64(size, opts.VirtualSize)
+
+	items := []struct {
+		id    GUID
+		flags uint32
+		data  []byte
+	}{
+		{metadataFileParameters, metadataIsRequired, append(u32(opts.BlockSize), u32(flags)...)},
+		{metadataVirtualDiskSize, metadataIsVirtualDisk | metadataIsRequired, size},
+		{metadataVirtualDiskID, metadataIsVirtualDisk | metadataIsRequired, diskID[:]},
+		{metadataLogicalSectorSize, metadataIsVirtualDisk | metadataIsRequired, u32(opts.LogicalSectorSize)},
+		{metadataPhysicalSectorSize, metadataIsVirtualDisk | metadataIsRequired, u32(opts.PhysicalSectorSize)},
+	}
+	if locator != nil {
+		items = append(items, struct {
+			id    GUID
+			flags uint32
+			data  []byte
+		}{metadataParentLocator, metadataIsRequired, locator})
+	}
+
+	m := make([]byte, vhdxMetadataLength)
+	copy(m, vhdxMetadataSignature)
+	binary.LittleEndian.PutUint16(m[10:], uint16(len(items)))
+	offset := vhdxMetadataTableSize
+	for i, item := range items {
+		e := m[32+i*vhdxMetadataEntrySize:]
+		copy(e, item.id[:])
+		binary.LittleEndian.PutUint32(e[16:], uint32(offset))
+		binary.LittleEndian.PutUint32(e[20:], uint32(len(item.data)))
+		binary.LittleEndian.PutUint32(e[24:], item.flags)
+		copy(m[offset:], item.data)
+		offset += len(item.data)
+	}
+	return m
+}
+
+// writeVHDX lays out a VHDX file as described in [MS-VHDX]: the file identifier, two
+// headers, two region tables, an empty log, the metadata region and the block allocation
+// table. Fixed disks allocate every payload block up front.
+func writeVHDX(f *os.File, path string, opts CreateOptions, parent *os.File) error {
+	if err := validateVHDXOptions(&opts); err != nil {
+		return err
+	}
+
+	chunkRatio := uint64(1<<23) * uint64(opts.LogicalSectorSize) / uint64(opts.BlockSize)
+	dataBlocks := (opts.VirtualSize + uint64(opts.BlockSize) - 1) / uint64(opts.BlockSize)
+	batEntries := dataBlocks + (dataBlocks-1)/chunkRatio
+	if opts.DiskType == TypeDifferencing {
+		bitmapBlocks := (dataBlocks + chunkRatio - 1) / chunkRatio
+		batEntries = bitmapBlocks * (chunkRatio + 1)
+	}
+	batLength := roundUp(batEntries*8, mib)
+	dataOffset := vhdxBATOffset + batLength
+
+	var ids [3]GUID
+	for i := range ids {
+		id, err := newGUID()
+		if err != nil {
+			return err
+		}
+		ids[i] = id
+	}
+	fileWrite, dataWrite, diskID := ids[0], ids[1], ids[2]
+
+	var locator []byte
+	if opts.DiskType == TypeDifferencing {
+		linkage, err := vhdxDataWriteGUID(parent)
+		if err != nil {
+			return fmt.Errorf("failed to read parent disk header: %v", err)
+		}
+		absolute, relative := parentLocatorPaths(path, opts.ParentPath)
+		entries := [][2]string{{"parent_linkage", "{" + linkage.String() + "}"}}
+		if relative != "" {
+			entries = append(entries, [2]string{"relative_path", relative})
+		}
+		entries = append(entries, [2]string{"absolute_win32_path", absolute})
+		locator = vhdxParentLocator(entries)
+	}
+
+	bat := make([]byte, batLength)
+	if opts.DiskType == TypeFixed {
+		for i := uint64(0); i < dataBlocks; i++ {
+			offsetMB := (dataOffset + i*uint64(opts.BlockSize)) / mib
+			binary.LittleEndian.PutUint64(bat[(i+i/chunkRatio)*8:], offsetMB<<20|payloadBlockFullyPresent)
+		}
+	}
+
+	identifier := make([]byte, 64*kib)
+	copy(identifier, vhdxSignature)
+	for i, u := range utf16.Encode([]rune(vhdxCreator)) {
+		binary.LittleEndian.PutUint16(identifier[8+i*2:], u)
+	}
+
+	writes := []struct {
+		offset int64
+		data   []byte
+	}{
+		{0, identifier},
+		{vhdxHeader1Offset, vhdxHeader(0, fileWrite, dataWrite)},
+		{vhdxHeader2Offset, vhdxHeader(1, fileWrite, dataWrite)},
+		{vhdxRegionTable1Offset, vhdxRegionTable(uint32(batLength))},
+		{vhdxRegionTable2Offset, vhdxRegionTable(uint32(batLength))},
+		{vhdxMetadataOffset, vhdxMetadata(opts, diskID, locator)},
+		{vhdxBATOffset, bat},
+	}
+	for _, w := range writes {
+		if _, err := f.WriteAt(w.data, w.offset); err != nil {
+			return err
+		}
+	}
+
+	size := int64(dataOffset)
+	if opts.DiskType == TypeFixed {
+		size += int64(dataBlocks * uint64(opts.BlockSize))
+	}
+	return f.Truncate(size)
+}
@@ -18,7 +18,7 @@ import (
 	_ "embed"
 
 	"github.com/pulumi/pulumi-go-provider/infer"
-	"github.com/pulumi/pulumi-hyperv/provider/pkg/provider/common"
+	"github.com/pulumi/pulumi-hyperv/provider/pkg/provider/resource"
 )
 
 //go:embed vhdfile.md
@@ -42,7 +42,7 @@ func (c *VhdFile) Annotate(a infer.Annotator) {
 
 // These are the inputs (or arguments) to a Vm resource.
 type VhdFileInputs struct {
-	common.ResourceInputs
+	resource.Inputs
 	Path       *string `pulumi:"path"`
 	SizeBytes  *int64  `pulumi:"sizeBytes,optional"`
 	BlockSize  *int64  `pulumi:"blockSize,optional"`
@@ -54,19 +54,57 @@ type VhdFileInputs struct {
 	// "differencing" means that the VHD file will be created as a differencing disk.
 	// The default value is "fixed".
 	DiskType *string `pulumi:"diskType,optional"`
+	// DiskWriter selects how the file is created: "auto" or "native".
+	DiskWriter *string `pulumi:"diskWriter,optional"`
+	// SourcePath seeds the disk with the contents of an existing image.
+	SourcePath   *string `pulumi:"sourcePath,optional"`
+	SourceFormat *string `pulumi:"sourceFormat,optional"`
+	SourceSha256 *string `pulumi:"sourceSha256,optional"`
+	// CompactTrigger compacts the disk in place whenever its value changes.
+	CompactTrigger *string `pulumi:"compactTrigger,optional"`
+	// DeleteBehavior selects what happens to a differencing disk on delete: "remove" or
+	// "mergeIntoParent".
+	DeleteBehavior *string `pulumi:"deleteBehavior,optional"`
+	// RetainOnDelete leaves the file in place when the resource is deleted.
+	RetainOnDelete *bool `pulumi:"retainOnDelete,optional"`
+	// BackupPathOnDelete moves the file there on delete instead of removing it.
+	BackupPathOnDelete *string `pulumi:"backupPathOnDelete,optional"`
 }
 
 func (c *VhdFileInputs) Annotate(a infer.Annotator) {
 	a.Describe(&c.Path, "Path to the VHD file")
-	a.Describe(&c.SizeBytes, "Size of the VHD file in bytes")
+	a.Describe(&c.SizeBytes, "Size of the VHD file in bytes. Changing it resizes the disk in place. A disk cannot be shrunk below the space used by its partitions.")
 	a.Describe(&c.BlockSize, "Block size of the VHD file in bytes. Recommended value is 1MB (1048576 bytes) for better compatibility.")
 	a.Describe(&c.ParentPath, "Path to the parent VHD file when creating a differencing disk")
-	a.Describe(&c.DiskType, "Type of the VHD file (Fixed, Dynamic, or Differencing)")
+	a.Describe(&c.DiskType, "Type of the VHD file (Fixed, Dynamic, or Differencing). Changing between Fixed and Dynamic converts the disk in place, any other change replaces it.")
+	a.Describe(&c.DiskWriter, "How the disk is created. auto uses the backend the provider is configured with, and the built-in writer when the host has neither the Hyper-V services nor the Hyper-V PowerShell module. native always uses the built-in writer, which needs neither Hyper-V nor PowerShell. Defaults to auto.")
+	a.Describe(&c.SourcePath, "Path to an image whose contents are copied into the new disk, such as a golden VHDX or a raw or qcow2 cloud image. The disk is as large as the image unless sizeBytes is larger.")
+	a.Describe(&c.SourceFormat, "Format of the source image: raw, qcow2, vhd or vhdx. Detected from the contents of the image when not set.")
+	a.Describe(&c.SourceSha256, "Expected SHA-256 digest of the source image file, as a hex string. The disk is not created if the image doesn't match.")
+	a.Describe(&c.CompactTrigger, "Changing this value compacts a dynamic or differencing disk in place, returning unused space to the host. The disk must not be in use by a running virtual machine.")
+	a.Describe(&c.DeleteBehavior, "What happens to a differencing disk when the resource is deleted. remove deletes the file and discards its changes. mergeIntoParent writes its changes into the parent disk first, which is refused while other differencing disks share that parent. Defaults to remove.")
+	a.Describe(&c.RetainOnDelete, "Keep the disk file when the resource is deleted. The resource is removed from the stack but the file, and any data on it, is left in place.")
+	a.Describe(&c.BackupPathOnDelete, "Move the disk file to this path when the resource is deleted instead of removing it. If the path is an existing directory, or ends with a separator, the file keeps its name inside it. An existing file is never overwritten.")
 }
 
 // These are the outputs (or properties) of a Vm resource.
 type VhdFileOutputs struct {
 	VhdFileInputs
+	Format             *string  `pulumi:"format,optional"`
+	VirtualSizeBytes   *int64   `pulumi:"virtualSizeBytes,optional"`
+	FileSizeBytes      *int64   `pulumi:"fileSizeBytes,optional"`
+	LogicalSectorSize  *int     `pulumi:"logicalSectorSize,optional"`
+	PhysicalSectorSize *int     `pulumi:"physicalSectorSize,optional"`
+	DiskIdentifier     *string  `pulumi:"diskIdentifier,optional"`
+	Chain              []string `pulumi:"chain,optional"`
 }
 
-func (c *VhdFileOutputs) Annotate(a infer.Annotator) {}
+func (c *VhdFileOutputs) Annotate(a infer.Annotator) {
+	a.Describe(&c.Format, "Format of the disk file as found on disk (VHD or VHDX).")
+	a.Describe(&c.VirtualSizeBytes, "Virtual size of the disk in bytes as found on disk.")
+	a.Describe(&c.FileSizeBytes, "Size of the disk file on the host in bytes.")
+	a.Describe(&c.LogicalSectorSize, "Logical sector size of the disk in bytes.")
+	a.Describe(&c.PhysicalSectorSize, "Physical sector size of the disk in bytes.")
+	a.Describe(&c.DiskIdentifier, "Unique identifier of the disk.")
+	a.Describe(&c.Chain, "Paths of the ancestors of a differencing disk, starting with its parent and ending with the base disk. Empty for other disks.")
+}
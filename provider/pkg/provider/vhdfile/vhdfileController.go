@@ -16,19 +16,22 @@ package vhdfile
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io/fs"
 	"os"
-	"os/exec"
+	"path/filepath"
 	"strings"
 
-	"github.com/microsoft/wmi/pkg/base/host"
-	"github.com/microsoft/wmi/pkg/virtualization/core/storage/disk"
+	p "github.com/pulumi/pulumi-go-provider"
 	"github.com/pulumi/pulumi-go-provider/infer"
 
-	"github.com/pulumi/pulumi-hyperv/provider/pkg/provider/common"
+	"github.com/pulumi/pulumi-hyperv/provider/pkg/provider/backend"
+	"github.com/pulumi/pulumi-hyperv/provider/pkg/provider/config"
+	"github.com/pulumi/pulumi-hyperv/provider/pkg/provider/errs"
 	"github.com/pulumi/pulumi-hyperv/provider/pkg/provider/logging"
-	"github.com/pulumi/pulumi-hyperv/provider/pkg/provider/util"
-	"github.com/pulumi/pulumi-hyperv/provider/pkg/provider/vmms"
+	"github.com/pulumi/pulumi-hyperv/provider/pkg/provider/resource"
+	"github.com/pulumi/pulumi-hyperv/provider/pkg/provider/vhd"
 )
 
 // VhdFileController implements the controller methods for VhdFile.
@@ -38,137 +41,139 @@ import (
 var _ = (infer.CustomResource[VhdFileInputs, VhdFileOutputs])((*VhdFile)(nil))
 var _ = (infer.CustomCreate[VhdFileInputs, VhdFileOutputs])((*VhdFile)(nil))
 var _ = (infer.CustomRead[VhdFileInputs, VhdFileOutputs])((*VhdFile)(nil))
+var _ = (infer.CustomDiff[VhdFileInputs, VhdFileOutputs])((*VhdFile)(nil))
+var _ = (infer.CustomUpdate[VhdFileInputs, VhdFileOutputs])((*VhdFile)(nil))
 var _ = (infer.CustomDelete[VhdFileOutputs])((*VhdFile)(nil))
 
-// Connect establishes a connection to the Hyper-V server.
-func (c *VhdFile) Connect(ctx context.Context) (*vmms.VMMS, interface{}, error) {
-	logger := logging.GetLogger(ctx)
-
-	// Initialize all the parameters.
-	config := infer.GetConfig[common.Config](ctx)
-	var whost *host.WmiHost
-	if config.Host != "" {
-		whost = host.NewWmiHost(config.Host)
-	} else {
-		whost = host.NewWmiLocalHost()
-	}
-
-	// Wrap vmms creation in panic recovery
-	var vmmsClient *vmms.VMMS
-	var vmmsErr error
-
-	func() {
-		defer func() {
-			if r := recover(); r != nil {
-				vmmsErr = fmt.Errorf("recovered from panic in NewVMMS: %v", r)
-				logger.Warnf("Recovered from panic in NewVMMS: %v", r)
-			}
-		}()
-
-		vmmsClient, vmmsErr = vmms.NewVMMS(ctx, whost)
-	}()
-
-	if vmmsErr != nil {
-		// Log the error but don't fail - we'll continue with nil client and use fallback methods
-		logger.Warnf("Failed to create VMMS client: %v", vmmsErr)
-		logger.Infof("Will attempt to use PowerShell fallback methods for VHD operations")
-	}
-
-	// We'll return the client even if it's nil, and handle nil checks in the resource methods
-	// This allows us to fall back to PowerShell commands when the WMI services are unavailable
-	return vmmsClient, nil, nil
-}
-
 // Delete deletes a VHD file.
 func (c *VhdFile) Delete(ctx context.Context, id string, state VhdFileOutputs) error {
-	// Delete the VHD file.
 	logger := logging.GetLogger(ctx)
-	logger.Infof("Deleting vhd [%v]", state.Path)
 
 	// If the path is empty, we can't delete the VHD file.
 	if state.Path == nil {
 		return fmt.Errorf("Path is nil")
 	}
+	logger.Infof("Deleting vhd [%s]", *state.Path)
 
 	if !strings.HasSuffix(*state.Path, ".vhd") && !strings.HasSuffix(*state.Path, ".vhdx") {
 		return fmt.Errorf("Path [%v] doesn't end with .vhd or .vhdx", *state.Path)
 	}
 
-	// Get the disk.
-	vmmsClient, _, err := c.Connect(ctx)
+	if state.RetainOnDelete != nil && *state.RetainOnDelete {
+		logger.Infof("Retaining vhd [%s] because retainOnDelete is set", *state.Path)
+		return nil
+	}
+
+	behavior, err := resolveDeleteBehavior(state.DeleteBehavior)
 	if err != nil {
 		return err
 	}
 
-	// Try deleting the VHD using various available methods
-	vhdPath := *state.Path
-
-	// Check if the file actually exists first
-	powershellExe, psErr := util.FindPowerShellExe()
-	if psErr != nil {
-		return fmt.Errorf("failed to find PowerShell executable: %v", psErr)
+	b, err := backend.Connect(ctx)
+	if err != nil {
+		return err
 	}
 
-	checkCmd := exec.Command(powershellExe, "-Command", fmt.Sprintf("Test-Path -Path \"%s\"", vhdPath))
-	checkOutput, checkErr := checkCmd.CombinedOutput()
-	fileExists := false
-	if checkErr == nil && strings.TrimSpace(string(checkOutput)) == "True" {
-		fileExists = true
+	// Removing a disk that a VM or checkpoint still uses would leave it unable to start.
+	if err := checkNotAttached(ctx, b, *state.Path); err != nil {
+		return err
 	}
 
-	if !fileExists {
-		logger.Infof("VHD file [%s] already doesn't exist, considering deletion successful", vhdPath)
-		return nil
+	// Removing a disk that differencing disks still depend on would break them.
+	if config.Local(ctx) {
+		if children, _ := vhd.FindChildren(*state.Path); len(children) > 0 {
+			return fmt.Errorf("cannot delete vhd [%s]: the differencing disks [%s] still use it as their parent. Delete or merge them first", *state.Path, strings.Join(children, ", "))
+		}
 	}
 
-	// First check for nil client - use PowerShell directly if no VMMS client
-	if vmmsClient == nil {
-		logger.Warnf("VMMS client is nil, attempting to delete file via PowerShell")
-		// Use PowerShell to delete the file as a fallback
-		cmd := exec.Command(powershellExe, "-Command", fmt.Sprintf("Remove-Item -Path \"%s\" -Force", vhdPath))
-		output, err := cmd.CombinedOutput()
-		if err != nil {
-			return fmt.Errorf("failed to delete VHD file with PowerShell: %v, output: %s", err, string(output))
+	if behavior == DeleteMergeIntoParent {
+		merged, err := mergeIntoParent(ctx, b, *state.Path)
+		if err != nil || merged {
+			return err
 		}
-		logger.Infof("Deleted VHD [%s] using PowerShell", vhdPath)
-		return nil
 	}
 
-	// Try using the VirtualSystemManagementService if available
-	vsms := vmmsClient.GetVirtualSystemManagementService()
-	if vsms == nil {
-		logger.Warnf("VirtualSystemManagementService is unavailable, falling back to PowerShell")
-		// Use PowerShell to delete the file as a fallback
-		cmd := exec.Command(powershellExe, "-Command", fmt.Sprintf("Remove-Item -Path \"%s\" -Force", vhdPath))
-		output, err := cmd.CombinedOutput()
-		if err != nil {
-			return fmt.Errorf("failed to delete VHD file with PowerShell: %v, output: %s", err, string(output))
+	if state.BackupPathOnDelete != nil && *state.BackupPathOnDelete != "" {
+		return backupDisk(ctx, b, *state.Path, *state.BackupPathOnDelete)
+	}
+
+	// Disks created by the native writer are plain files and are removed the same way.
+	if writer, _ := resolveDiskWriter(state.DiskWriter); writer == WriterNative {
+		if err := os.Remove(*state.Path); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to delete vhd [%s]: %v", *state.Path, err)
 		}
-		logger.Infof("Deleted VHD [%s] using PowerShell", vhdPath)
+		logger.Infof("Deleted vhd [%s]", *state.Path)
 		return nil
 	}
 
-	// Try deleting with WMI first
-	params := map[string]interface{}{
-		"Path": vhdPath,
+	err = b.DeleteDisk(ctx, *state.Path)
+	if errors.Is(err, errs.ErrNotFound) {
+		logger.Infof("VHD file [%s] already doesn't exist, considering deletion successful", *state.Path)
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to delete vhd [%s]: %w", *state.Path, err)
 	}
-	_, err = vsms.InvokeMethod("DeleteVirtualHardDisk", params)
+	logger.Infof("Deleted vhd [%s] with the %s backend", *state.Path, b.Name())
+	return nil
+}
+
+// checkNotAttached refuses the deletion of a disk that is used by a virtual machine or one of
+// its checkpoints. If the users of the disk cannot be listed it is assumed to be unused.
+func checkNotAttached(ctx context.Context, b backend.HypervBackend, path string) error {
+	users, err := diskUsers(ctx, b, path)
 	if err != nil {
-		logger.Warnf("Failed to delete vhd [%v] via WMI because [%v], falling back to direct file removal", vhdPath, err)
-
-		// If WMI method fails, try direct PowerShell file removal as a fallback
-		cmd := exec.Command(powershellExe, "-Command", fmt.Sprintf("Remove-Item -Path \"%s\" -Force", vhdPath))
-		output, removeErr := cmd.CombinedOutput()
-		if removeErr != nil {
-			logger.Errorf("Failed to delete VHD file with PowerShell after WMI failure: %v, output: %s", removeErr, string(output))
-			return fmt.Errorf("Failed to delete vhd [%v]: WMI error: [%v], PowerShell error: [%v]", vhdPath, err, removeErr)
+		return err
+	}
+	var names []string
+	for _, user := range users {
+		names = append(names, user.VMName)
+	}
+	if len(names) > 0 {
+		return fmt.Errorf("cannot delete vhd [%s]: it is attached to [%s]. Detach it first, or set retainOnDelete to keep the file", path, strings.Join(names, ", "))
+	}
+	return nil
+}
+
+// diskUsers returns the virtual machines and checkpoints that use the disk at path. When they
+// cannot be listed, the error is logged and no users are returned, unless strictBackend is
+// set.
+func diskUsers(ctx context.Context, b backend.HypervBackend, path string) ([]backend.DiskUser, error) {
+	users, err := b.ListDiskUsers(ctx, path)
+	if err == nil {
+		return users, nil
+	}
+	if config.Selection(ctx).Strict {
+		return nil, fmt.Errorf("could not determine whether vhd [%s] is attached to a virtual machine: %w", path, err)
+	}
+	logging.GetLogger(ctx).Warnf("Could not determine whether vhd [%s] is attached to a virtual machine: %v", path, err)
+	return nil, nil
+}
+
+// runningUser returns the first of users whose VM is not off, or nil if there is none.
+// Checkpoints never run, so only drives are considered.
+func runningUser(users []backend.DiskUser) *backend.DiskUser {
+	for i := range users {
+		if users[i].ControllerType != "" && users[i].State != backend.PowerStateOff {
+			return &users[i]
 		}
+	}
+	return nil
+}
 
-		logger.Infof("Deleted VHD [%s] using PowerShell after WMI method failed", vhdPath)
+// backupDisk moves the disk at path to backupPath instead of deleting it. A backupPath that
+// is a directory, or ends with a separator, receives the file under its current name.
+func backupDisk(ctx context.Context, b backend.HypervBackend, path, backupPath string) error {
+	logger := logging.GetLogger(ctx)
+	dest, err := b.MoveDisk(ctx, path, backupPath)
+	if errors.Is(err, errs.ErrNotFound) {
+		logger.Infof("VHD file [%s] already doesn't exist, nothing to back up", path)
 		return nil
 	}
-
-	logger.Infof("Successfully deleted VHD [%s] using WMI", vhdPath)
+	if err != nil {
+		return fmt.Errorf("failed to move vhd [%s] to [%s]: %w", path, backupPath, err)
+	}
+	logger.Infof("Moved vhd [%s] to [%s]", path, dest)
 	return nil
 }
 
@@ -176,476 +181,503 @@ func (c *VhdFile) Delete(ctx context.Context, id string, state VhdFileOutputs) e
 func (c *VhdFile) Create(ctx context.Context, name string, input VhdFileInputs, preview bool) (string, VhdFileOutputs, error) {
 	logger := logging.GetLogger(ctx)
 	state := VhdFileOutputs{VhdFileInputs: input}
-	id := name
-
-	// If in preview, don't run the command.
-	if preview {
-		return id, state, nil
-	}
-
-	vmmsClient, _, err := c.Connect(ctx)
+	writer, err := resolveDiskWriter(input.DiskWriter)
 	if err != nil {
-		return id, state, err
+		return name, state, err
 	}
-
-	// Check for nil client
-	if vmmsClient == nil {
-		logger.Warnf("VMMS client is nil, will attempt PowerShell fallback for VHD creation")
+	if _, err := resolveDeleteBehavior(input.DeleteBehavior); err != nil {
+		return name, state, err
 	}
-	// Create the VHD file.
-	vhdFileName := *input.Path
-
-	// Create parent directory if it doesn't exist (using Go's native code)
-	// Extract directory path from the full VHD path
-	lastSlashIndex := strings.LastIndex(vhdFileName, "\\")
-	if lastSlashIndex == -1 {
-		lastSlashIndex = strings.LastIndex(vhdFileName, "/")
+	if input.Path == nil {
+		return name, state, fmt.Errorf("Path is nil")
+	}
+	// The built-in writer writes to the file system of the provider, not to that of the host.
+	if (input.SourcePath != nil || writer == WriterNative) && !config.Local(ctx) {
+		return name, state, fmt.Errorf("cannot create vhd [%s] on remote host %s: sourcePath and the native diskWriter write the disk with the built-in writer, which only writes local files", *input.Path, config.Get(ctx).Host)
+	}
+	// The parent may itself be created in this update, so nothing is checked or created in a preview.
+	if preview {
+		return name, state, nil
 	}
 
-	if lastSlashIndex != -1 {
-		dirPath := vhdFileName[:lastSlashIndex]
-		logger.Infof("Ensuring parent directory exists: %s", dirPath)
-
-		// Use Go's native os.MkdirAll to create directory and any missing parents
-		err := os.MkdirAll(dirPath, 0755)
+	// Images are always converted by the built-in writer, which needs no host services.
+	var b backend.HypervBackend
+	if input.SourcePath == nil && writer == WriterAuto {
+		if b, err = backend.Connect(ctx); err != nil {
+			return name, state, err
+		}
+		available, err := hypervToolingAvailable(ctx, b)
 		if err != nil {
-			logger.Errorf("Failed to create parent directory: %v", err)
-			return id, state, fmt.Errorf("failed to create parent directory: %v", err)
+			return name, state, err
+		}
+		if !available {
+			logger.Warnf("Neither the Hyper-V services nor PowerShell are available, creating vhd with the native writer")
+			b = nil
 		}
 	}
+	if err := checkParent(ctx, b, input); err != nil {
+		return name, state, err
+	}
 
-	// Check if this is a differencing disk or a regular disk
-	if input.DiskType != nil && *input.DiskType == "Differencing" {
-		// Handle differencing disk creation
-		if input.ParentPath == nil {
-			return id, state, fmt.Errorf("ParentPath is required for Differencing disk type")
+	switch {
+	case input.SourcePath != nil:
+		err = createFromSource(ctx, input)
+	case b == nil:
+		err = createNative(ctx, input)
+	default:
+		var disk *backend.Disk
+		if disk, err = createDisk(ctx, b, input); err == nil {
+			_, state = fromDiskInfo(input, &disk.Info, diskChain(ctx, b, &disk.Info), false)
+			return name, state, nil
 		}
+	}
+	if err != nil {
+		return name, state, err
+	}
 
-		parentVhdPath := *input.ParentPath
-
-		// Check for Azure Edition OS to skip PowerShell fallback
-		isAzureEdition := false
-		osVersion, err := util.GetOSVersion()
-		if err == nil && strings.Contains(strings.ToLower(osVersion), "azure") {
-			logger.Infof("Azure Edition OS detected: %s. Will use WMI for differencing disk creation.", osVersion)
-			isAzureEdition = true
-		}
+	// Report the properties of the disk that was actually written.
+	if info, inspectErr := vhd.Inspect(*input.Path); inspectErr == nil {
+		chain, _ := vhd.Chain(*input.Path)
+		_, state = fromDiskInfo(input, info, chain, false)
+	} else {
+		logger.Warnf("Created vhd [%s] but could not inspect it: %v", *input.Path, inspectErr)
+	}
+	return name, state, nil
+}
 
-		// Handle nil client case - Azure Edition should never use PowerShell fallback
-		if vmmsClient == nil && !isAzureEdition {
-			logger.Warnf("VMMS client is nil, falling back to PowerShell for differencing disk")
-
-			// Wrap PowerShell fallback in panic recovery to prevent crashes
-			var fallbackErr error
-			func() {
-				defer func() {
-					if r := recover(); r != nil {
-						fallbackErr = fmt.Errorf("recovered from panic in CreateVirtualHardDiskFallback: %v", r)
-					}
-				}()
-				fallbackErr = CreateVirtualHardDiskFallback(vhdFileName, 0, 0, "Differencing", input.ParentPath)
-			}()
-
-			if fallbackErr != nil {
-				return id, state, fmt.Errorf("failed to create differencing disk using PowerShell fallback: %v", fallbackErr)
-			}
+// checkParent verifies that the parent of a differencing disk exists and is compatible with
+// it. The parent is read through b, or from the local file system for the native writer
+// when b is nil.
+func checkParent(ctx context.Context, b backend.HypervBackend, input VhdFileInputs) error {
+	if input.DiskType == nil {
+		return nil
+	}
+	if diskType, _ := vhd.NormalizeDiskType(*input.DiskType); diskType != vhd.TypeDifferencing {
+		return nil
+	}
+	if input.ParentPath == nil || *input.ParentPath == "" {
+		return fmt.Errorf("ParentPath is required for Differencing disk type")
+	}
+	if input.Path == nil {
+		return nil
+	}
 
-			logger.Infof("Created differencing vhd [%s] with parent [%s] using PowerShell", vhdFileName, parentVhdPath)
-			return id, state, nil
+	var parent *vhd.Info
+	if b == nil {
+		if !config.Local(ctx) {
+			return nil
 		}
-
-		ims := vmmsClient.GetImageManagementService()
-		if ims == nil {
-			logger.Warnf("ImageManagementService is unavailable, trying alternative method via VSMS")
-
-			// Alternative method using VirtualSystemManagementService
-			vsms := vmmsClient.GetVirtualSystemManagementService()
-			if vsms == nil {
-				return id, state, fmt.Errorf("Both ImageManagementService and VirtualSystemManagementService are unavailable")
-			}
-
-			// Use VSMS to create differencing disk
-			params := map[string]interface{}{
-				"Path":       vhdFileName,
-				"ParentPath": parentVhdPath,
-				"Type":       uint32(4), // 4 = Differencing disk
-			}
-
-			_, err = vsms.InvokeMethod("CreateVirtualHardDisk", params)
-			if err != nil {
-				return id, state, fmt.Errorf("Failed to create differencing disk using VSMS: %v", err)
-			}
-		} else {
-			// Create differencing disk using ImageManagementService
-			// Use direct method invocation to create a differencing disk
-			// Type 4 corresponds to a differencing disk according to Hyper-V WMI API
-			params := map[string]interface{}{
-				"Path":       vhdFileName,
-				"ParentPath": parentVhdPath,
-				"Type":       uint32(4), // 4 = Differencing disk
-			}
-
-			_, err = ims.InvokeMethod("CreateVirtualHardDisk", params)
-			if err != nil {
-				return id, state, fmt.Errorf("Failed to create differencing disk: %v", err)
-			}
+		info, err := vhd.CheckParent(*input.Path, *input.ParentPath)
+		if err != nil {
+			return err
 		}
-
-		logger.Infof("Created differencing vhd [%s] with parent [%s]", vhdFileName, parentVhdPath)
+		parent = info
 	} else {
-		// Regular disk creation (fixed or dynamic)
-		vhdFileSize := *input.SizeBytes
-		// Set the block size if not specified - using 1MB (1048576 bytes) for better compatibility
-		if input.BlockSize == nil {
-			blockSize := int64(1048576) // 1MB block size
-			input.BlockSize = &blockSize
-			logger.Warnf("No block size specified for VHD creation. Using 1MB (1048576 bytes) for better compatibility.")
+		found, err := b.GetDisk(ctx, *input.ParentPath)
+		if errors.Is(err, errs.ErrNotFound) {
+			return fmt.Errorf("parent disk [%s] does not exist", *input.ParentPath)
 		}
-		vhdFileBlockSize := *input.BlockSize
-		// Set the disk type to "fixed" if not specified.
-		dynamicDiskType := true
-		if input.DiskType != nil && *input.DiskType == "fixed" {
-			dynamicDiskType = false
+		if err != nil {
+			return fmt.Errorf("failed to read parent disk [%s]: %w", *input.ParentPath, err)
 		}
-
-		// Check for Azure Edition OS to skip PowerShell fallback
-		isAzureEdition := false
-		osVersion, err := util.GetOSVersion()
-		if err == nil && strings.Contains(strings.ToLower(osVersion), "azure") {
-			logger.Infof("Azure Edition OS detected: %s. Will use WMI for regular disk creation.", osVersion)
-			isAzureEdition = true
+		if err := vhd.ValidateParent(*input.Path, *input.ParentPath, &found.Info); err != nil {
+			return err
 		}
+		parent = &found.Info
+	}
+	if input.SizeBytes != nil && uint64(*input.SizeBytes) != parent.VirtualSize {
+		return fmt.Errorf("a differencing disk has the size of its parent: sizeBytes is %d but parent [%s] is %d bytes", *input.SizeBytes, *input.ParentPath, parent.VirtualSize)
+	}
+	return nil
+}
 
-		// Handle nil client case - Azure Edition should never use PowerShell fallback
-		if vmmsClient == nil && !isAzureEdition {
-			logger.Warnf("VMMS client is nil, falling back to PowerShell for VHD creation")
-
-			// Ensure we have valid values for required parameters
-			if input.DiskType == nil {
-				diskType := "dynamic" // Default to dynamic disk if not specified
-				input.DiskType = &diskType
-				logger.Infof("No disk type specified, defaulting to 'dynamic' for PowerShell fallback")
-			}
-
-			// BlockSize might be nil if it wasn't set in the original input
-			var blockSizeVal int64 = 0
-			if input.BlockSize != nil {
-				blockSizeVal = *input.BlockSize
-			}
+// mergeIntoParent merges a differencing disk into its parent, which removes the disk. It
+// reports false when the disk is not a differencing disk and should simply be removed.
+func mergeIntoParent(ctx context.Context, b backend.HypervBackend, path string) (bool, error) {
+	logger := logging.GetLogger(ctx)
+	found, err := b.GetDisk(ctx, path)
+	if errors.Is(err, errs.ErrNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to read vhd [%s] before merging it: %w", path, err)
+	}
+	if found.DiskType != vhd.TypeDifferencing {
+		logger.Warnf("vhd [%s] is a %s disk and has no parent to merge into, removing it", path, found.DiskType)
+		return false, nil
+	}
+	parent := vhd.ResolveParentPath(path, found.ParentPath)
 
-			// Wrap PowerShell fallback in panic recovery to prevent crashes
-			var fallbackErr error
-			func() {
-				defer func() {
-					if r := recover(); r != nil {
-						fallbackErr = fmt.Errorf("recovered from panic in CreateVirtualHardDiskFallback: %v", r)
-					}
-				}()
-				fallbackErr = CreateVirtualHardDiskFallback(vhdFileName, vhdFileSize, blockSizeVal, *input.DiskType, input.ParentPath)
-			}()
-
-			if fallbackErr != nil {
-				return id, state, fmt.Errorf("failed to create VHD using PowerShell fallback: %v", fallbackErr)
+	// Merging changes the parent, which would corrupt any other child of it.
+	if config.Local(ctx) {
+		siblings, _ := vhd.FindChildren(parent)
+		for _, sibling := range siblings {
+			if !vhd.SamePath(sibling, path) {
+				return false, fmt.Errorf("cannot merge vhd [%s] into [%s]: the parent is shared with differencing disk [%s]", path, parent, sibling)
 			}
-
-			logger.Infof("Created VHD [%s] using PowerShell", vhdFileName)
-			return id, state, nil
 		}
+	}
 
-		ims := vmmsClient.GetImageManagementService()
-
-		if ims == nil {
-			logger.Warnf("ImageManagementService is unavailable, trying alternative method via VSMS")
-
-			// If the ImageManagementService is unavailable, we can try using the VirtualSystemManagementService
-			vsms := vmmsClient.GetVirtualSystemManagementService()
-			// If both services are unavailable, we can fall back to PowerShell
-			// This is a last resort and should be avoided if possible.
-			if vsms == nil && !isAzureEdition {
-				logger.Warnf("Both ImageManagementService and VirtualSystemManagementService are unavailable, falling back to PowerShell")
-
-				// Ensure we have valid values for required parameters
-				if input.DiskType == nil {
-					diskType := "dynamic" // Default to dynamic disk if not specified
-					input.DiskType = &diskType
-					logger.Infof("No disk type specified, defaulting to 'dynamic' for PowerShell fallback")
-				}
-
-				// BlockSize is important for proper VHD creation
-				var blockSizeVal int64 = 1048576 // Default to 1MB block size for PowerShell fallback
-				if input.BlockSize != nil {
-					blockSizeVal = *input.BlockSize
-				} else {
-					logger.Warnf("No block size specified for VHD creation. Using 1MB (1048576 bytes) for better compatibility.")
-				}
-
-				err := CreateVirtualHardDiskFallback(vhdFileName, vhdFileSize, blockSizeVal, *input.DiskType, input.ParentPath)
-				if err != nil {
-					return id, state, fmt.Errorf("failed to create VHD using PowerShell fallback: %v", err)
-				}
-				logger.Infof("Created VHD [%s] using PowerShell fallback", vhdFileName)
-				return id, state, nil
-			} else if vsms == nil && isAzureEdition {
-				// For Azure Edition, log clearly in Pulumi window but still use PowerShell fallback
-				logger.LogAzureEditionFallback()
-
-				// Ensure we have valid values for required parameters
-				if input.DiskType == nil {
-					diskType := "dynamic" // Default to dynamic disk if not specified
-					input.DiskType = &diskType
-					logger.Infof("No disk type specified, defaulting to 'dynamic' for PowerShell fallback")
-				}
-
-				// BlockSize is important for proper VHD creation
-				var blockSizeVal int64 = 1048576 // Default to 1MB block size for PowerShell fallback
-				if input.BlockSize != nil {
-					blockSizeVal = *input.BlockSize
-				} else {
-					logger.Warnf("No block size specified for VHD creation. Using 1MB (1048576 bytes) for better compatibility.")
-				}
-
-				err := CreateVirtualHardDiskFallback(vhdFileName, vhdFileSize, blockSizeVal, *input.DiskType, input.ParentPath)
-				if err != nil {
-					return id, state, fmt.Errorf("failed to create VHD using PowerShell fallback: %v", err)
-				}
-				logger.Infof("Created VHD [%s] using PowerShell fallback on Azure Edition", vhdFileName)
-				return id, state, nil
-			}
-
-			// If we have the VirtualSystemManagementService, we can create the disk using it
-			diskType := uint32(3) // 3 = Dynamic (default)
-			if !dynamicDiskType {
-				diskType = uint32(2) // 2 = Fixed
-			}
-
-			params := map[string]interface{}{
-				"Path":            vhdFileName,
-				"MaxInternalSize": uint64(vhdFileSize),
-				"BlockSize":       uint32(vhdFileBlockSize),
-				"Type":            diskType,
-			}
+	logger.Infof("Merging vhd [%s] into its parent [%s]", path, parent)
+	if err := b.MergeDisk(ctx, path, parent); err != nil {
+		return false, fmt.Errorf("failed to merge vhd [%s] into [%s]: %w", path, parent, err)
+	}
+	logger.Infof("Merged vhd [%s] into [%s]", path, parent)
+	return true, nil
+}
 
-			_, err = vsms.InvokeMethod("CreateVirtualHardDisk", params)
-			if err != nil {
-				return id, state, fmt.Errorf("Failed to create disk using VSMS: %v", err)
-			}
-		} else {
-			// Use ImageManagementService to create the disk
-			setting, err := disk.GetVirtualHardDiskSettingData(
-				vmmsClient.GetVirtualizationConn().WMIHost,
-				vhdFileName,
-				512,
-				512,
-				uint32(vhdFileBlockSize),
-				uint64(vhdFileSize),
-				dynamicDiskType,
-				disk.VirtualHardDiskFormat_2,
-			)
-			if err != nil {
-				return id, state, fmt.Errorf("Failed to get disk settings: %v", err)
-			}
-			defer setting.Close()
-			err = ims.CreateDisk(setting)
-			if err != nil {
-				return id, state, fmt.Errorf("Failed to create disk: %v", err)
-			}
-		}
-		logger.Infof("Created vhd [%s]", vhdFileName)
+// hypervToolingAvailable reports whether either the Hyper-V PowerShell module or the Hyper-V
+// WMI services can be used to create disks. A failed probe is an error, and so is a host
+// without either of them when it is remote or strictBackend is set, because the native
+// writer only writes local files and is a fallback strict mode doesn't allow.
+func hypervToolingAvailable(ctx context.Context, b backend.HypervBackend) (bool, error) {
+	caps, err := b.HostCapabilities(ctx)
+	if err != nil {
+		return false, fmt.Errorf("failed to probe the capabilities of the host: %w", err)
 	}
-	return id, state, nil
+	if caps.PowerShellModule || caps.VSMS {
+		return true, nil
+	}
+	if !config.Local(ctx) {
+		return false, fmt.Errorf("host %s has neither the Hyper-V services nor the Hyper-V PowerShell module, and the native writer cannot create disks on a remote host", config.Get(ctx).Host)
+	}
+	if config.Selection(ctx).Strict {
+		return false, fmt.Errorf("the host has neither the Hyper-V services nor the Hyper-V PowerShell module, and strictBackend does not allow falling back to the native writer. Set diskWriter to native to use it")
+	}
+	return false, nil
 }
 
-// Read retrieves information about an existing VHD file.
-func (c *VhdFile) Read(ctx context.Context, id string, inputs VhdFileInputs, currentState VhdFileOutputs) (string, VhdFileInputs, VhdFileOutputs, error) {
+// createNative creates the VHD file with the pure-Go writer.
+func createNative(ctx context.Context, input VhdFileInputs) error {
 	logger := logging.GetLogger(ctx)
-	logger.Infof("Reading vhd [%v]", inputs.Path)
+	opts := createOptions(input)
 
-	// If the path is empty, we can't read the VHD file.
-	if inputs.Path == nil {
-		return id, inputs, currentState, fmt.Errorf("Path is nil")
+	if err := os.MkdirAll(filepath.Dir(*input.Path), 0755); err != nil {
+		return fmt.Errorf("failed to create parent directory: %v", err)
+	}
+	info, err := vhd.Create(*input.Path, opts)
+	if err != nil {
+		return fmt.Errorf("failed to create vhd [%s] with the native writer: %w", *input.Path, err)
 	}
+	logger.Infof("Created %s %s vhd [%s] with the native writer", info.DiskType, info.Format, *input.Path)
+	return nil
+}
 
-	vhdFileName := *inputs.Path
-	if !strings.HasSuffix(vhdFileName, ".vhd") && !strings.HasSuffix(vhdFileName, ".vhdx") {
-		return id, inputs, currentState, fmt.Errorf("Path [%v] doesn't end with .vhd or .vhdx", vhdFileName)
+// createFromSource creates the VHD file from the image at SourcePath with the pure-Go writer,
+// after verifying the image against SourceSha256.
+func createFromSource(ctx context.Context, input VhdFileInputs) error {
+	logger := logging.GetLogger(ctx)
+	source := *input.SourcePath
+	if input.ParentPath != nil {
+		return fmt.Errorf("sourcePath and parentPath cannot be combined")
 	}
 
-	// Check if the file exists.
-	vmmsClient, _, err := c.Connect(ctx)
+	if input.SourceSha256 != nil {
+		logger.Infof("Verifying SHA-256 of source image [%s]", source)
+		if err := vhd.VerifySHA256(source, *input.SourceSha256); err != nil {
+			return err
+		}
+	}
+	sourceFormat := ""
+	if input.SourceFormat != nil {
+		sourceFormat = *input.SourceFormat
+	}
+	format, err := vhd.NormalizeSourceFormat(sourceFormat, source)
+	if err != nil {
+		return err
+	}
+	src, err := vhd.OpenImage(source, format)
 	if err != nil {
-		return id, inputs, currentState, err
+		return fmt.Errorf("failed to open source image [%s]: %w", source, err)
 	}
+	defer src.Close()
 
-	// Create outputs from inputs
-	outputs := VhdFileOutputs{
-		VhdFileInputs: inputs,
+	opts := createOptions(input)
+	if err := os.MkdirAll(filepath.Dir(*input.Path), 0755); err != nil {
+		return fmt.Errorf("failed to create parent directory: %v", err)
+	}
+	logger.Infof("Creating vhd [%s] from %s image [%s]", *input.Path, format, source)
+	info, err := vhd.CreateFromImage(*input.Path, src, opts)
+	if err != nil {
+		return fmt.Errorf("failed to create vhd [%s] from [%s]: %w", *input.Path, source, err)
 	}
+	logger.Infof("Created %s %s vhd [%s] of %d bytes from [%s]", info.DiskType, info.Format, *input.Path, info.VirtualSize, source)
+	return nil
+}
 
-	// Check if the file actually exists first using PowerShell
-	powershellExe, psErr := util.FindPowerShellExe()
-	if psErr == nil {
-		checkCmd := exec.Command(powershellExe, "-Command", fmt.Sprintf("Test-Path -Path \"%s\"", vhdFileName))
-		checkOutput, checkErr := checkCmd.CombinedOutput()
-		if checkErr == nil {
-			fileExists := strings.TrimSpace(string(checkOutput)) == "True"
-			if !fileExists {
-				return id, inputs, currentState, fmt.Errorf("VHD file does not exist: %s", vhdFileName)
-			}
-			// File exists - if VMMS client is not available, we can just return success
-			if vmmsClient == nil {
-				logger.Infof("VHD file exists (verified via PowerShell): %s", vhdFileName)
-				return id, inputs, outputs, nil
-			}
+// createDisk creates the VHD file on the host with the Hyper-V backend.
+func createDisk(ctx context.Context, b backend.HypervBackend, input VhdFileInputs) (*backend.Disk, error) {
+	logger := logging.GetLogger(ctx)
+	path := *input.Path
+	opts := createOptions(input)
+	diskType, err := vhd.NormalizeDiskType(opts.DiskType)
+	if err != nil {
+		return nil, err
+	}
+	if diskType != vhd.TypeDifferencing {
+		if opts.VirtualSize == 0 {
+			return nil, fmt.Errorf("SizeBytes is required for %s disks", diskType)
+		}
+		// 1 MiB blocks are supported by every Hyper-V version, the defaults are not.
+		if opts.BlockSize == 0 {
+			opts.BlockSize = 1 << 20
+			logger.Debugf("No block size specified for vhd [%s], using 1 MiB", path)
 		}
 	}
-
-	// If we have a VMMS client, try more detailed validation
-	ims := vmmsClient.GetImageManagementService()
-	if ims == nil {
-		logger.Infof("ImageManagementService not available, using basic file existence check via VSMS")
-		// Just verify the file exists
-		vsms := vmmsClient.GetVirtualSystemManagementService()
-		if vsms == nil {
-			logger.Infof("VirtualSystemManagementService not available, file existence was already verified via PowerShell")
-			return id, inputs, outputs, nil
+	if config.Local(ctx) {
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return nil, fmt.Errorf("failed to create parent directory: %v", err)
 		}
+	}
 
-		params := map[string]interface{}{
-			"Path": vhdFileName,
+	logger.Infof("Creating vhd [%s] with the %s backend", path, b.Name())
+	disk, err := b.CreateDisk(ctx, path, opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create vhd [%s]: %w", path, err)
+	}
+	logger.Infof("Created %s %s vhd [%s]", disk.DiskType, disk.Format, path)
+	return disk, nil
+}
+
+// readDisk returns the properties of the disk at path as the host reports them. Disks of
+// the native writer, and local disks the host cannot read, are inspected directly instead.
+// A disk that does not exist is reported with errs.ErrNotFound.
+func readDisk(ctx context.Context, path string, native bool) (*vhd.Info, []string, error) {
+	logger := logging.GetLogger(ctx)
+	local := config.Local(ctx)
+	if !(native && local) {
+		b, err := backend.Connect(ctx)
+		if err == nil {
+			var found *backend.Disk
+			if found, err = b.GetDisk(ctx, path); err == nil {
+				return &found.Info, diskChain(ctx, b, &found.Info), nil
+			}
 		}
-		_, err = vsms.InvokeMethod("TestVirtualHardDiskExists", params)
-		if err != nil {
-			logger.Warnf("VSMS failed to verify VHD exists: %v, but file existence was already verified via PowerShell", err)
+		if errors.Is(err, errs.ErrNotFound) || !local {
+			return nil, nil, err
 		}
-		return id, inputs, outputs, nil
+		logger.Debugf("Failed to read vhd [%s] through the host: %v, inspecting it directly", path, err)
 	}
 
-	// If we have the ImageManagementService, we can get more details about the VHD
-	// For now, we're just checking existence
-	params := map[string]interface{}{
-		"Path": vhdFileName,
+	info, err := vhd.Inspect(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil, errs.New(errs.ErrNotFound, "Inspect", fmt.Sprintf("vhd [%s] does not exist", path))
 	}
-	_, err = ims.InvokeMethod("ValidateVirtualHardDisk", params)
 	if err != nil {
-		logger.Warnf("Failed to validate VHD with IMS: %v, but file existence was already verified", err)
+		return nil, nil, err
 	}
+	// A partial chain still shows how far the ancestors could be followed.
+	chain, _ := vhd.Chain(path)
+	return info, chain, nil
+}
 
-	return id, inputs, outputs, nil
+// diskChain returns the ancestors of a disk as the host reports them, starting with its
+// parent. The chain stops at the first ancestor that cannot be read.
+func diskChain(ctx context.Context, b backend.HypervBackend, info *vhd.Info) []string {
+	var chain []string
+	for current := info; current.DiskType == vhd.TypeDifferencing && current.ParentPath != ""; {
+		if len(chain) >= vhd.MaxChainDepth {
+			break
+		}
+		chain = append(chain, current.ParentPath)
+		parent, err := b.GetDisk(ctx, current.ParentPath)
+		if err != nil {
+			break
+		}
+		current = &parent.Info
+	}
+	return chain
 }
 
-// CreateVirtualHardDiskFallback uses PowerShell to create a VHD if WMI services are unavailable.
-// This is a fallback method when both ImageManagementService and VirtualSystemManagementService are unavailable.
-func CreateVirtualHardDiskFallback(path string, sizeBytes int64, blockSize int64, diskType string, parentPath *string) error {
-	// Find PowerShell executable first
-	powershellExe, psErr := util.FindPowerShellExe()
-	if psErr != nil {
-		return fmt.Errorf("failed to find PowerShell executable: %v", psErr)
+// Read retrieves information about an existing VHD file. The ID of an imported disk is its
+// path, and its inputs are read from the file.
+func (c *VhdFile) Read(ctx context.Context, id string, inputs VhdFileInputs, currentState VhdFileOutputs) (string, VhdFileInputs, VhdFileOutputs, error) {
+	logger := logging.GetLogger(ctx)
+
+	importing := inputs.Path == nil
+	if importing {
+		inputs.Path = &id
 	}
-	// Input validation
-	if path == "" {
-		return fmt.Errorf("VHD path cannot be empty")
+	vhdFileName := *inputs.Path
+	logger.Infof("Reading vhd [%s]", vhdFileName)
+	lower := strings.ToLower(vhdFileName)
+	if !strings.HasSuffix(lower, ".vhd") && !strings.HasSuffix(lower, ".vhdx") {
+		return id, inputs, currentState, fmt.Errorf("Path [%v] doesn't end with .vhd or .vhdx", vhdFileName)
 	}
 
-	if !strings.HasSuffix(path, ".vhd") && !strings.HasSuffix(path, ".vhdx") {
-		return fmt.Errorf("path '%s' must end with .vhd or .vhdx", path)
+	writer, _ := resolveDiskWriter(inputs.DiskWriter)
+	info, chain, err := readDisk(ctx, vhdFileName, writer == WriterNative)
+	if errors.Is(err, errs.ErrNotFound) {
+		logger.Infof("VHD file [%s] no longer exists", vhdFileName)
+		return "", inputs, currentState, nil
 	}
-
-	// Validate disk type and required parameters
-	diskTypeNormalized := strings.ToLower(diskType)
-	if diskTypeNormalized != "fixed" && diskTypeNormalized != "dynamic" && diskTypeNormalized != "differencing" {
-		return fmt.Errorf("invalid disk type: %s (must be 'Fixed', 'Dynamic', or 'Differencing')", diskType)
+	if err != nil {
+		return id, inputs, currentState, fmt.Errorf("failed to read vhd [%s]: %w", vhdFileName, err)
 	}
+	logger.Debugf("Read vhd [%s]: format=%s type=%s virtualSize=%d", vhdFileName, info.Format, info.DiskType, info.VirtualSize)
+	actual, outputs := fromDiskInfo(inputs, info, chain, importing)
+	return id, actual, outputs, nil
+}
 
-	// For differencing disks, parentPath is required
-	if diskTypeNormalized == "differencing" {
-		if parentPath == nil || *parentPath == "" {
-			return fmt.Errorf("parentPath is required for Differencing disk type")
+// Diff compares the saved state with the new inputs. Size changes and conversions between
+// fixed and dynamic disks are made in place. Shrinking a disk below the space its
+// partitions use is rejected here, before anything is changed. A disk replaced at the same
+// path is deleted first, so that deleting the old disk does not remove the new one.
+func (c *VhdFile) Diff(ctx context.Context, id string, olds VhdFileOutputs, news VhdFileInputs) (p.DiffResponse, error) {
+	detailed := resource.DiffInputs(olds.VhdFileInputs, news, replaceProperties)
+	if _, ok := detailed["diskType"]; ok {
+		changed, convertible := diskTypeChange(olds.DiskType, news.DiskType)
+		switch {
+		case !changed:
+			delete(detailed, "diskType")
+		case !convertible:
+			detailed["diskType"] = p.PropertyDiff{Kind: p.UpdateReplace, InputDiff: true}
 		}
+	}
 
-		// Validate parent path extension
-		if !strings.HasSuffix(*parentPath, ".vhd") && !strings.HasSuffix(*parentPath, ".vhdx") {
-			return fmt.Errorf("parentPath '%s' must end with .vhd or .vhdx", *parentPath)
-		}
-	} else {
-		// For non-differencing disks, sizeBytes must be positive
-		if sizeBytes <= 0 {
-			return fmt.Errorf("sizeBytes must be greater than 0 for Fixed or Dynamic disk types")
+	// Disks without sizeBytes, such as differencing disks and imports, have the size Read found.
+	current := olds.SizeBytes
+	if current == nil {
+		current = olds.VirtualSizeBytes
+	}
+	_, replaced := detailed["path"]
+	if _, resized := detailed["sizeBytes"]; resized && !replaced && olds.Path != nil &&
+		current != nil && news.SizeBytes != nil && *news.SizeBytes < *current {
+		if err := checkShrink(ctx, *olds.Path, olds.Format, uint64(*news.SizeBytes)); err != nil {
+			return p.DiffResponse{}, err
 		}
 	}
 
-	// Validate blockSize if specified
-	if blockSize < 0 {
-		return fmt.Errorf("blockSize cannot be negative")
-	}
+	return p.DiffResponse{
+		HasChanges:          len(detailed) > 0,
+		DetailedDiff:        detailed,
+		DeleteBeforeReplace: resource.ReplacedInPlace(detailed, "path"),
+	}, nil
+}
 
-	// Create parent directory if it doesn't exist
-	// Extract directory path from the full VHD path
-	lastSlashIndex := strings.LastIndex(path, "\\")
-	if lastSlashIndex == -1 {
-		lastSlashIndex = strings.LastIndex(path, "/")
+// checkShrink verifies that the disk at path can be shrunk to size bytes, using the minimum
+// size the host reports for it. If that is unknown the check is left to Hyper-V.
+func checkShrink(ctx context.Context, path string, format *string, size uint64) error {
+	logger := logging.GetLogger(ctx)
+	diskFormat, _ := vhd.FormatForPath(path)
+	if format != nil {
+		diskFormat = *format
+	}
+	if diskFormat == vhd.FormatVHD {
+		return fmt.Errorf("cannot shrink vhd [%s]: VHD files can only be expanded, convert the disk to VHDX to shrink it", path)
 	}
 
-	if lastSlashIndex != -1 {
-		dirPath := path[:lastSlashIndex]
-		// Create directory if it doesn't exist (including all parent directories)
-		createDirCmd := exec.Command(powershellExe, "-Command", fmt.Sprintf("New-Item -Path \"%s\" -ItemType Directory -Force | Out-Null", dirPath))
-		createDirOutput, createDirErr := createDirCmd.CombinedOutput()
-		if createDirErr != nil {
-			return fmt.Errorf("failed to create parent directory: %v, output: %s", createDirErr, string(createDirOutput))
+	var used uint64
+	b, err := backend.Connect(ctx)
+	if err == nil {
+		var found *backend.Disk
+		if found, err = b.GetDisk(ctx, path); err == nil {
+			used = found.MinimumSize
 		}
 	}
+	if used == 0 {
+		logger.Warnf("Could not determine the used size of vhd [%s], Hyper-V will validate the new size: %v", path, err)
+		return nil
+	}
 
-	// Construct the PowerShell command with proper escaping
-	var cmdArgs []string
-
-	// Base command - use proper array for command arguments
-	cmdArgs = append(cmdArgs, "-Command")
+	if size < used {
+		return fmt.Errorf("cannot shrink vhd [%s] to %d bytes: its partitions use %d bytes. Shrink the partitions inside the guest first, or choose a size of at least %d bytes", path, size, used, used)
+	}
+	return nil
+}
 
-	// Build the New-VHD command string
-	newVhdCmd := fmt.Sprintf("New-VHD -Path '%s'", path)
+// Update resizes, converts or compacts the disk in place. The steps run in that order, so a
+// disk that is both grown and converted to fixed is only allocated once.
+func (c *VhdFile) Update(ctx context.Context, id string, olds VhdFileOutputs, news VhdFileInputs, preview bool) (VhdFileOutputs, error) {
+	logger := logging.GetLogger(ctx)
+	state := olds
+	state.VhdFileInputs = news
 
-	// Add size parameter for non-differencing disks
-	if diskTypeNormalized != "differencing" {
-		newVhdCmd += fmt.Sprintf(" -SizeBytes %d", sizeBytes)
+	if olds.Path == nil {
+		return state, fmt.Errorf("Path is nil")
 	}
+	path := *olds.Path
 
-	// Add block size if specified and valid
-	if blockSize > 0 {
-		newVhdCmd += fmt.Sprintf(" -BlockSizeBytes %d", blockSize)
+	resize := news.SizeBytes != nil && (olds.SizeBytes == nil || *news.SizeBytes != *olds.SizeBytes)
+	changed, convertible := diskTypeChange(olds.DiskType, news.DiskType)
+	convert := changed && convertible
+	compact := news.CompactTrigger != nil && (olds.CompactTrigger == nil || *news.CompactTrigger != *olds.CompactTrigger)
+	if preview || (!resize && !convert && !compact) {
+		return state, nil
 	}
+	logger.Infof("Updating vhd [%s]", path)
 
-	// Set the disk type
-	switch diskTypeNormalized {
-	case "fixed":
-		newVhdCmd += " -Fixed"
-	case "dynamic":
-		newVhdCmd += " -Dynamic"
-	case "differencing":
-		newVhdCmd += fmt.Sprintf(" -Differencing -ParentPath '%s'", *parentPath)
+	b, err := backend.Connect(ctx)
+	if err != nil {
+		return state, err
+	}
+	users, err := diskUsers(ctx, b, path)
+	if err != nil {
+		return state, err
 	}
+	running := runningUser(users)
 
-	cmdArgs = append(cmdArgs, newVhdCmd)
+	if resize {
+		size := uint64(*news.SizeBytes)
+		if running != nil {
+			if err := checkOnlineResize(path, running); err != nil {
+				return state, err
+			}
+			logger.Infof("Resizing vhd [%s] online while attached to running VM [%s]", path, running.VMName)
+		}
+		if err := b.ResizeDisk(ctx, path, size); err != nil {
+			return state, fmt.Errorf("failed to resize vhd [%s] to %d bytes: %w", path, size, err)
+		}
+		logger.Infof("Resized vhd [%s] to %d bytes", path, size)
+	}
 
-	// Execute the PowerShell command with panic recovery
-	var cmd *exec.Cmd
-	var output []byte
-	var cmdErr error
+	if convert {
+		if running != nil {
+			return state, fmt.Errorf("cannot convert vhd [%s] while it is attached to running VM [%s], stop the VM first", path, running.VMName)
+		}
+		diskType, _ := vhd.NormalizeDiskType(*news.DiskType)
+		if err := b.ConvertDisk(ctx, path, diskType); err != nil {
+			return state, fmt.Errorf("failed to convert vhd [%s] to %s: %w", path, diskType, err)
+		}
+		logger.Infof("Converted vhd [%s] to %s", path, diskType)
+	}
 
-	func() {
-		defer func() {
-			if r := recover(); r != nil {
-				cmdErr = fmt.Errorf("recovered from panic in PowerShell execution: %v", r)
+	if compact {
+		diskType := ""
+		if news.DiskType != nil {
+			diskType = *news.DiskType
+		}
+		switch normalized, _ := vhd.NormalizeDiskType(diskType); {
+		case normalized == vhd.TypeFixed:
+			logger.Warnf("Skipping compaction of vhd [%s]: fixed disks cannot be compacted", path)
+		case running != nil:
+			return state, fmt.Errorf("cannot compact vhd [%s] while it is attached to running VM [%s], stop the VM first", path, running.VMName)
+		default:
+			if err := b.CompactDisk(ctx, path); err != nil {
+				return state, fmt.Errorf("failed to compact vhd [%s]: %w", path, err)
 			}
-		}()
-
-		cmd = exec.Command(powershellExe, cmdArgs...)
-		output, cmdErr = cmd.CombinedOutput()
-	}()
+			logger.Infof("Compacted vhd [%s]", path)
+		}
+	}
 
-	if cmdErr != nil {
-		return fmt.Errorf("failed to create VHD using PowerShell: %v, output: %s", cmdErr, string(output))
+	// Report the properties of the disk after the change.
+	writer, _ := resolveDiskWriter(news.DiskWriter)
+	if info, chain, err := readDisk(ctx, path, writer == WriterNative); err == nil {
+		_, state = fromDiskInfo(news, info, chain, false)
+	} else {
+		logger.Warnf("Updated vhd [%s] but could not read it back: %v", path, err)
 	}
+	return state, nil
+}
 
+// checkOnlineResize verifies that a disk attached to a running VM can be resized. Hyper-V
+// only resizes VHDX files on the SCSI controller of a generation 2 VM while it runs.
+func checkOnlineResize(path string, user *backend.DiskUser) error {
+	format, _ := vhd.FormatForPath(path)
+	if format != vhd.FormatVHDX || !strings.EqualFold(user.ControllerType, backend.ControllerSCSI) || user.Generation != 2 {
+		return fmt.Errorf("cannot resize vhd [%s] while VM [%s] is running: online resize requires a VHDX disk on the SCSI controller of a generation 2 VM, but the disk is a %s on the %s controller of a generation %d VM. Stop the VM first",
+			path, user.VMName, format, user.ControllerType, user.Generation)
+	}
 	return nil
 }
 
@@ -14,8 +14,147 @@
 
 package vhdfile
 
-// func run(ctx context.Context, in MachineInputs, out *MachineOutputs) error {
-// 	contract.Assertf(out != nil, "run:out cannot be nil")
+import (
+	"fmt"
+	"strings"
 
-// 	return nil
-// }
+	"github.com/pulumi/pulumi-hyperv/provider/pkg/provider/vhd"
+)
+
+// Writers that can create VHD files.
+const (
+	WriterAuto   = "auto"
+	WriterNative = "native"
+)
+
+// resolveDiskWriter validates the diskWriter input and applies its default.
+func resolveDiskWriter(writer *string) (string, error) {
+	if writer == nil || *writer == "" {
+		return WriterAuto, nil
+	}
+	switch w := strings.ToLower(*writer); w {
+	case WriterAuto, WriterNative:
+		return w, nil
+	default:
+		return "", fmt.Errorf("unsupported diskWriter [%s], must be %s or %s", *writer, WriterAuto, WriterNative)
+	}
+}
+
+// Behaviors of Delete for differencing disks.
+const (
+	DeleteRemove          = "remove"
+	DeleteMergeIntoParent = "mergeIntoParent"
+)
+
+// resolveDeleteBehavior validates the deleteBehavior input and applies its default.
+func resolveDeleteBehavior(behavior *string) (string, error) {
+	if behavior == nil || *behavior == "" {
+		return DeleteRemove, nil
+	}
+	switch {
+	case strings.EqualFold(*behavior, DeleteRemove):
+		return DeleteRemove, nil
+	case strings.EqualFold(*behavior, DeleteMergeIntoParent):
+		return DeleteMergeIntoParent, nil
+	default:
+		return "", fmt.Errorf("unsupported delete behavior [%s], must be %s or %s", *behavior, DeleteRemove, DeleteMergeIntoParent)
+	}
+}
+
+// createOptions maps the inputs to options for the built-in writer.
+func createOptions(input VhdFileInputs) vhd.CreateOptions {
+	opts := vhd.CreateOptions{}
+	if input.DiskType != nil {
+		opts.DiskType = *input.DiskType
+	}
+	if input.SizeBytes != nil {
+		opts.VirtualSize = uint64(*input.SizeBytes)
+	}
+	if input.BlockSize != nil {
+		opts.BlockSize = uint32(*input.BlockSize)
+	}
+	if input.ParentPath != nil {
+		opts.ParentPath = *input.ParentPath
+	}
+	return opts
+}
+
+// replaceProperties are the inputs whose change requires a new disk rather than modifying it.
+var replaceProperties = map[string]bool{
+	"path":         true,
+	"parentPath":   true,
+	"blockSize":    true,
+	"sourcePath":   true,
+	"sourceFormat": true,
+	"sourceSha256": true,
+	"triggers":     true,
+}
+
+// diskTypeChange reports whether the disk type differs between olds and news, and whether
+// the change can be made in place by converting between the fixed and dynamic types.
+func diskTypeChange(olds, news *string) (changed, convertible bool) {
+	var oldType, newType string
+	if olds != nil {
+		oldType = *olds
+	}
+	if news != nil {
+		newType = *news
+	}
+	from, fromErr := vhd.NormalizeDiskType(oldType)
+	to, toErr := vhd.NormalizeDiskType(newType)
+	if fromErr != nil || toErr != nil {
+		return oldType != newType, false
+	}
+	if from == to {
+		return false, false
+	}
+	return true, from != vhd.TypeDifferencing && to != vhd.TypeDifferencing
+}
+
+// normalizePath makes Windows paths comparable by dropping the long path prefix,
+// unifying separators and ignoring case.
+func normalizePath(path string) string {
+	path = strings.TrimPrefix(path, `\\?\`)
+	return strings.ToLower(strings.ReplaceAll(path, "/", `\`))
+}
+
+// fromDiskInfo reconciles the inputs with the properties found on disk and builds the
+// matching outputs, with chain as the ancestors of the disk. Only inputs that were specified
+// are overwritten, so that values left to their defaults don't show up as drift. An import
+// records all of them.
+func fromDiskInfo(inputs VhdFileInputs, info *vhd.Info, chain []string, importing bool) (VhdFileInputs, VhdFileOutputs) {
+	if (inputs.SizeBytes != nil || importing) && info.DiskType != vhd.TypeDifferencing {
+		size := int64(info.VirtualSize)
+		inputs.SizeBytes = &size
+	}
+	if (inputs.BlockSize != nil || importing) && info.BlockSize != 0 {
+		blockSize := int64(info.BlockSize)
+		inputs.BlockSize = &blockSize
+	}
+	if (inputs.DiskType != nil && !strings.EqualFold(*inputs.DiskType, info.DiskType)) || importing {
+		diskType := info.DiskType
+		inputs.DiskType = &diskType
+	}
+	if (inputs.ParentPath != nil || importing) && info.ParentPath != "" &&
+		(inputs.ParentPath == nil || normalizePath(*inputs.ParentPath) != normalizePath(info.ParentPath)) {
+		parentPath := info.ParentPath
+		inputs.ParentPath = &parentPath
+	}
+
+	format := info.Format
+	virtualSize := int64(info.VirtualSize)
+	fileSize := info.PhysicalSize
+	logical := int(info.LogicalSectorSize)
+	physical := int(info.PhysicalSectorSize)
+	diskID := info.DiskID
+	return inputs, VhdFileOutputs{
+		VhdFileInputs:      inputs,
+		Format:             &format,
+		VirtualSizeBytes:   &virtualSize,
+		FileSizeBytes:      &fileSize,
+		LogicalSectorSize:  &logical,
+		PhysicalSectorSize: &physical,
+		DiskIdentifier:     &diskID,
+		Chain:              chain,
+	}
+}
@@ -0,0 +1,378 @@
+// Copyright 2016-2022, Pulumi Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vhdfile
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	p "github.com/pulumi/pulumi-go-provider"
+
+	"github.com/pulumi/pulumi-hyperv/provider/pkg/provider/backend"
+	"github.com/pulumi/pulumi-hyperv/provider/pkg/provider/errs"
+	"github.com/pulumi/pulumi-hyperv/provider/pkg/provider/vhd"
+)
+
+func ptr[T any](v T) *T {
+	return &v
+}
+
+// simulate returns a context whose resource operations run against an empty Simulator.
+// The working directory is a temporary one, because the logger writes a file to it.
+func simulate(t *testing.T) (context.Context, *backend.Simulator) {
+	t.Chdir(t.TempDir())
+	sim := backend.NewSimulator()
+	return backend.WithBackend(context.Background(), sim), sim
+}
+
+func TestLifecycle(t *testing.T) {
+	ctx, sim := simulate(t)
+	c := &VhdFile{}
+
+	inputs := VhdFileInputs{Path: ptr(`C:\vms\data.vhdx`), SizeBytes: ptr(int64(1 << 30))}
+	id, state, err := c.Create(ctx, "data", inputs, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *state.Format != vhd.FormatVHDX || *state.VirtualSizeBytes != 1<<30 || *state.DiskIdentifier == "" {
+		t.Errorf("Create = %+v", state)
+	}
+	disk, err := sim.GetDisk(ctx, `C:\vms\data.vhdx`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if disk.DiskType != vhd.TypeDynamic || disk.BlockSize != 1<<20 {
+		t.Errorf("created %s disk with %d byte blocks, want a dynamic disk with 1 MiB blocks", disk.DiskType, disk.BlockSize)
+	}
+
+	_, actual, outputs, err := c.Read(ctx, id, inputs, state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *actual.SizeBytes != 1<<30 || actual.DiskType != nil || actual.BlockSize != nil {
+		t.Errorf("Read reported drift in %+v", actual)
+	}
+
+	grown := inputs
+	grown.SizeBytes = ptr(int64(2 << 30))
+	if state, err = c.Update(ctx, id, outputs, grown, false); err != nil {
+		t.Fatal(err)
+	}
+	if *state.VirtualSizeBytes != 2<<30 {
+		t.Errorf("Update reported %d bytes, want %d", *state.VirtualSizeBytes, 2<<30)
+	}
+
+	if err := c.Delete(ctx, id, state); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := sim.GetDisk(ctx, `C:\vms\data.vhdx`); !errors.Is(err, errs.ErrNotFound) {
+		t.Errorf("disk still exists after Delete: %v", err)
+	}
+	if id, _, _, err := c.Read(ctx, id, inputs, state); err != nil || id != "" {
+		t.Errorf("Read of a deleted disk = %q, %v, want it gone", id, err)
+	}
+	// A disk that is already gone is deleted successfully.
+	if err := c.Delete(ctx, "data", state); err != nil {
+		t.Errorf("Delete of a deleted disk: %v", err)
+	}
+}
+
+func TestImport(t *testing.T) {
+	ctx, sim := simulate(t)
+	if _, err := sim.CreateDisk(ctx, `C:\vms\base.vhdx`, vhd.CreateOptions{DiskType: vhd.TypeFixed, VirtualSize: 1 << 30}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := sim.CreateDisk(ctx, `C:\vms\web.vhdx`, vhd.CreateOptions{DiskType: vhd.TypeDifferencing, ParentPath: `C:\vms\base.vhdx`}); err != nil {
+		t.Fatal(err)
+	}
+
+	id, inputs, outputs, err := (&VhdFile{}).Read(ctx, `C:\vms\web.vhdx`, VhdFileInputs{}, VhdFileOutputs{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id != `C:\vms\web.vhdx` || *inputs.Path != id || *inputs.DiskType != vhd.TypeDifferencing || *inputs.ParentPath != `C:\vms\base.vhdx` {
+		t.Errorf("Read = %q, %+v", id, inputs)
+	}
+	if inputs.SizeBytes != nil {
+		t.Errorf("imported the size %d of a differencing disk, which is set by its parent", *inputs.SizeBytes)
+	}
+	if len(outputs.Chain) != 1 || outputs.Chain[0] != `C:\vms\base.vhdx` {
+		t.Errorf("Chain = %v", outputs.Chain)
+	}
+
+	if id, _, _, err := (&VhdFile{}).Read(ctx, `C:\vms\missing.vhdx`, VhdFileInputs{}, VhdFileOutputs{}); err != nil || id != "" {
+		t.Errorf("Read of a missing disk = %q, %v, want it gone", id, err)
+	}
+}
+
+func TestCreateDifferencing(t *testing.T) {
+	ctx, sim := simulate(t)
+	c := &VhdFile{}
+	if _, err := sim.CreateDisk(ctx, `C:\vms\base.vhdx`, vhd.CreateOptions{VirtualSize: 1 << 30}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := sim.CreateDisk(ctx, `C:\vms\legacy.vhd`, vhd.CreateOptions{VirtualSize: 1 << 30}); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, tc := range map[string]struct {
+		inputs VhdFileInputs
+		want   string
+	}{
+		"missing parent": {
+			VhdFileInputs{Path: ptr(`C:\vms\a.vhdx`), DiskType: ptr("Differencing"), ParentPath: ptr(`C:\vms\none.vhdx`)},
+			"does not exist",
+		},
+		"no parent": {
+			VhdFileInputs{Path: ptr(`C:\vms\a.vhdx`), DiskType: ptr("Differencing")},
+			"ParentPath is required",
+		},
+		"format mismatch": {
+			VhdFileInputs{Path: ptr(`C:\vms\a.vhdx`), DiskType: ptr("Differencing"), ParentPath: ptr(`C:\vms\legacy.vhd`)},
+			"cannot have the VHD parent",
+		},
+		"own parent": {
+			VhdFileInputs{Path: ptr(`C:\vms\base.vhdx`), DiskType: ptr("Differencing"), ParentPath: ptr(`C:\vms\base.vhdx`)},
+			"cannot be its own parent",
+		},
+		"size mismatch": {
+			VhdFileInputs{Path: ptr(`C:\vms\a.vhdx`), DiskType: ptr("Differencing"), ParentPath: ptr(`C:\vms\base.vhdx`), SizeBytes: ptr(int64(2 << 30))},
+			"has the size of its parent",
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, _, err := c.Create(ctx, "child", tc.inputs, false)
+			if err == nil || !strings.Contains(err.Error(), tc.want) {
+				t.Errorf("Create = %v, want an error containing %q", err, tc.want)
+			}
+		})
+	}
+
+	_, state, err := c.Create(ctx, "child", VhdFileInputs{Path: ptr(`C:\vms\child.vhdx`), DiskType: ptr("Differencing"), ParentPath: ptr(`C:\vms\base.vhdx`)}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *state.VirtualSizeBytes != 1<<30 || len(state.Chain) != 1 || state.Chain[0] != `C:\vms\base.vhdx` {
+		t.Errorf("Create = %+v", state)
+	}
+}
+
+func TestDelete(t *testing.T) {
+	ctx, sim := simulate(t)
+	c := &VhdFile{}
+	create := func(path string, opts vhd.CreateOptions) VhdFileOutputs {
+		t.Helper()
+		if _, err := sim.CreateDisk(ctx, path, opts); err != nil {
+			t.Fatal(err)
+		}
+		return VhdFileOutputs{VhdFileInputs: VhdFileInputs{Path: ptr(path)}}
+	}
+
+	attached := create(`C:\vms\os.vhdx`, vhd.CreateOptions{VirtualSize: 1 << 30})
+	if _, err := sim.CreateVM(ctx, backend.VMSpec{Name: "web", Generation: 2}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := sim.AttachDisk(ctx, "web", backend.DiskDrive{Path: `C:\vms\os.vhdx`}); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Delete(ctx, "os", attached); err == nil || !strings.Contains(err.Error(), "attached to [web]") {
+		t.Errorf("Delete of an attached disk = %v", err)
+	}
+	attached.RetainOnDelete = ptr(true)
+	if err := c.Delete(ctx, "os", attached); err != nil {
+		t.Errorf("Delete with retainOnDelete: %v", err)
+	}
+	if _, err := sim.GetDisk(ctx, `C:\vms\os.vhdx`); err != nil {
+		t.Errorf("retained disk is gone: %v", err)
+	}
+
+	backedUp := create(`C:\vms\old.vhdx`, vhd.CreateOptions{VirtualSize: 1 << 30})
+	backedUp.BackupPathOnDelete = ptr(`D:\backup\`)
+	if err := c.Delete(ctx, "old", backedUp); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := sim.GetDisk(ctx, `D:\backup\old.vhdx`); err != nil {
+		t.Errorf("backup is missing: %v", err)
+	}
+
+	create(`C:\vms\base.vhdx`, vhd.CreateOptions{VirtualSize: 1 << 30})
+	child := create(`C:\vms\child.vhdx`, vhd.CreateOptions{DiskType: vhd.TypeDifferencing, ParentPath: `C:\vms\base.vhdx`})
+	child.DeleteBehavior = ptr(DeleteMergeIntoParent)
+	if err := c.Delete(ctx, "child", child); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{`Move-Item C:\vms\old.vhdx`, `Merge-VHD C:\vms\child.vhdx`}
+	if strings.Join(sim.DiskOperations, "\n") != strings.Join(want, "\n") {
+		t.Errorf("DiskOperations = %q, want %q", sim.DiskOperations, want)
+	}
+}
+
+// failingProbe is a backend whose capability probe fails.
+type failingProbe struct {
+	*backend.Simulator
+}
+
+func (failingProbe) HostCapabilities(ctx context.Context) (*backend.HostCapabilities, error) {
+	return nil, errors.New("access denied")
+}
+
+func TestCreateWithoutHyperVTooling(t *testing.T) {
+	ctx, sim := simulate(t)
+	c := &VhdFile{}
+	inputs := VhdFileInputs{Path: ptr(filepath.Join(t.TempDir(), "data.vhdx")), SizeBytes: ptr(int64(1 << 20))}
+
+	// A failed probe is reported instead of silently using the native writer.
+	probeCtx := backend.WithBackend(context.Background(), failingProbe{sim})
+	if _, _, err := c.Create(probeCtx, "data", inputs, false); err == nil || !strings.Contains(err.Error(), "access denied") {
+		t.Errorf("Create with a failed probe = %v, want the probe error", err)
+	}
+	if _, err := os.Stat(*inputs.Path); !os.IsNotExist(err) {
+		t.Errorf("Create with a failed probe wrote the disk: %v", err)
+	}
+
+	// A local host that has neither the PowerShell module nor the WMI services gets a native disk.
+	sim.Capabilities.PowerShellModule, sim.Capabilities.VSMS = false, false
+	if _, _, err := c.Create(ctx, "data", inputs, false); err != nil {
+		t.Fatal(err)
+	}
+	if info, err := vhd.Inspect(*inputs.Path); err != nil || info.VirtualSize != 1<<20 {
+		t.Errorf("native disk = %+v, %v", info, err)
+	}
+	if _, err := sim.GetDisk(ctx, *inputs.Path); !errors.Is(err, errs.ErrNotFound) {
+		t.Errorf("the simulator created the disk: %v", err)
+	}
+}
+
+func TestDiff(t *testing.T) {
+	ctx, _ := simulate(t)
+	olds := VhdFileOutputs{VhdFileInputs: VhdFileInputs{
+		Path: ptr(`C:\vms\data.vhdx`), SizeBytes: ptr(int64(1 << 30)), DiskType: ptr("Dynamic"), BlockSize: ptr(int64(1 << 20)),
+	}}
+	tests := []struct {
+		name   string
+		change func(*VhdFileInputs)
+		want   map[string]p.DiffKind
+	}{
+		{"unchanged", func(*VhdFileInputs) {}, map[string]p.DiffKind{}},
+		{"disk type case", func(in *VhdFileInputs) { in.DiskType = ptr("dynamic") }, map[string]p.DiffKind{}},
+		{"grow", func(in *VhdFileInputs) { in.SizeBytes = ptr(int64(2 << 30)) }, map[string]p.DiffKind{"sizeBytes": p.Update}},
+		{"convert", func(in *VhdFileInputs) { in.DiskType = ptr("Fixed") }, map[string]p.DiffKind{"diskType": p.Update}},
+		{"to differencing", func(in *VhdFileInputs) { in.DiskType = ptr("Differencing") }, map[string]p.DiffKind{"diskType": p.UpdateReplace}},
+		{"compact", func(in *VhdFileInputs) { in.CompactTrigger = ptr("1") }, map[string]p.DiffKind{"compactTrigger": p.Update}},
+		{"block size", func(in *VhdFileInputs) { in.BlockSize = ptr(int64(32 << 20)) }, map[string]p.DiffKind{"blockSize": p.UpdateReplace}},
+		{"path", func(in *VhdFileInputs) { in.Path = ptr(`C:\vms\other.vhdx`) }, map[string]p.DiffKind{"path": p.UpdateReplace}},
+		{"path and block size", func(in *VhdFileInputs) {
+			in.Path = ptr(`C:\vms\other.vhdx`)
+			in.BlockSize = ptr(int64(32 << 20))
+		}, map[string]p.DiffKind{"path": p.UpdateReplace, "blockSize": p.UpdateReplace}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			news := olds.VhdFileInputs
+			tt.change(&news)
+			diff, err := (&VhdFile{}).Diff(ctx, "data", olds, news)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := map[string]p.DiffKind{}
+			for name, d := range diff.DetailedDiff {
+				got[name] = d.Kind
+			}
+			if diff.HasChanges != (len(tt.want) > 0) || !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Diff = %v (has changes %t), want %v", got, diff.HasChanges, tt.want)
+			}
+			// A replacement at the same path must delete the old disk before creating the new one.
+			replaced := false
+			for _, kind := range tt.want {
+				replaced = replaced || kind == p.UpdateReplace
+			}
+			_, moved := tt.want["path"]
+			if want := replaced && !moved; diff.DeleteBeforeReplace != want {
+				t.Errorf("DeleteBeforeReplace = %t, want %t", diff.DeleteBeforeReplace, want)
+			}
+		})
+	}
+}
+
+func TestDiffShrink(t *testing.T) {
+	ctx, sim := simulate(t)
+	if _, err := sim.CreateDisk(ctx, `C:\vms\data.vhdx`, vhd.CreateOptions{VirtualSize: 2 << 30}); err != nil {
+		t.Fatal(err)
+	}
+	if err := sim.SetDiskMinimumSize(`C:\vms\data.vhdx`, 3<<29); err != nil {
+		t.Fatal(err)
+	}
+
+	// A disk without sizeBytes is compared with the size Read found.
+	olds := VhdFileOutputs{VhdFileInputs: VhdFileInputs{Path: ptr(`C:\vms\data.vhdx`)}, VirtualSizeBytes: ptr(int64(2 << 30))}
+	news := VhdFileInputs{Path: ptr(`C:\vms\data.vhdx`), SizeBytes: ptr(int64(1 << 30))}
+	if _, err := (&VhdFile{}).Diff(ctx, "data", olds, news); err == nil || !strings.Contains(err.Error(), "partitions use") {
+		t.Errorf("Diff below the used size = %v, want an error", err)
+	}
+	news.SizeBytes = ptr(int64(7 << 28))
+	if diff, err := (&VhdFile{}).Diff(ctx, "data", olds, news); err != nil || diff.DetailedDiff["sizeBytes"].Kind != p.Update {
+		t.Errorf("Diff above the used size = %+v, %v", diff, err)
+	}
+
+	vhdOlds := VhdFileOutputs{VhdFileInputs: VhdFileInputs{Path: ptr(`C:\vms\data.vhd`), SizeBytes: ptr(int64(2 << 30))}}
+	vhdNews := VhdFileInputs{Path: ptr(`C:\vms\data.vhd`), SizeBytes: ptr(int64(1 << 30))}
+	if _, err := (&VhdFile{}).Diff(ctx, "data", vhdOlds, vhdNews); err == nil {
+		t.Error("Diff shrinking a VHD file succeeded")
+	}
+}
+
+func TestUpdateOrder(t *testing.T) {
+	tests := []struct {
+		name     string
+		diskType string
+		want     []string
+	}{
+		// Growing before converting to fixed allocates the disk once, and fixed disks are not compacted.
+		{"to fixed", "Fixed", []string{"Resize-VHD", "Convert-VHD"}},
+		{"to dynamic", "Dynamic", []string{"Resize-VHD", "Convert-VHD", "Optimize-VHD"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, sim := simulate(t)
+			from := vhd.TypeDynamic
+			if tt.diskType == "Dynamic" {
+				from = vhd.TypeFixed
+			}
+			if _, err := sim.CreateDisk(ctx, `C:\vms\data.vhdx`, vhd.CreateOptions{DiskType: from, VirtualSize: 1 << 30}); err != nil {
+				t.Fatal(err)
+			}
+			olds := VhdFileOutputs{VhdFileInputs: VhdFileInputs{
+				Path: ptr(`C:\vms\data.vhdx`), SizeBytes: ptr(int64(1 << 30)), DiskType: ptr(from), CompactTrigger: ptr("1"),
+			}}
+			news := olds.VhdFileInputs
+			news.SizeBytes, news.DiskType, news.CompactTrigger = ptr(int64(2<<30)), ptr(tt.diskType), ptr("2")
+			if _, err := (&VhdFile{}).Update(ctx, "data", olds, news, false); err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, op := range sim.DiskOperations {
+				got = append(got, strings.Fields(op)[0])
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("operations = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -18,7 +18,7 @@ import (
 	_ "embed"
 
 	"github.com/pulumi/pulumi-go-provider/infer"
-	"github.com/pulumi/pulumi-hyperv/provider/pkg/provider/common"
+	"github.com/pulumi/pulumi-hyperv/provider/pkg/provider/resource"
 )
 
 //go:embed virtualswitch.md
@@ -42,7 +42,7 @@ func (c *VirtualSwitch) Annotate(a infer.Annotator) {
 
 // These are the inputs (or arguments) to a VirtualSwitch resource.
 type VirtualSwitchInputs struct {
-	common.ResourceInputs
+	resource.Inputs
 	Name              *string `pulumi:"name"`
 	SwitchType        *string `pulumi:"switchType"`
 	AllowManagementOs *bool   `pulumi:"allowManagementOs,optional"`
@@ -61,6 +61,28 @@ func (c *VirtualSwitchInputs) Annotate(a infer.Annotator) {
 // These are the outputs (or properties) of a VirtualSwitch resource.
 type VirtualSwitchOutputs struct {
 	VirtualSwitchInputs
+	SwitchId                 *string             `pulumi:"switchId,optional"`
+	BandwidthReservationMode *string             `pulumi:"bandwidthReservationMode,optional"`
+	IovEnabled               *bool               `pulumi:"iovEnabled,optional"`
+	ConnectedAdapters        []*ConnectedAdapter `pulumi:"connectedAdapters,optional"`
 }
 
-func (c *VirtualSwitchOutputs) Annotate(a infer.Annotator) {}
+func (c *VirtualSwitchOutputs) Annotate(a infer.Annotator) {
+	a.Describe(&c.SwitchId, "GUID of the virtual switch.")
+	a.Describe(&c.BandwidthReservationMode, "How minimum bandwidth is reserved on the switch: Absolute, Weight, Default or None.")
+	a.Describe(&c.IovEnabled, "Whether single-root I/O virtualization (SR-IOV) is enabled on the switch.")
+	a.Describe(&c.ConnectedAdapters, "Network adapters of virtual machines that are connected to the switch.")
+}
+
+// ConnectedAdapter is a network adapter of a virtual machine connected to the switch.
+type ConnectedAdapter struct {
+	VMName     *string `pulumi:"vmName"`
+	Name       *string `pulumi:"name"`
+	MacAddress *string `pulumi:"macAddress,optional"`
+}
+
+func (c *ConnectedAdapter) Annotate(a infer.Annotator) {
+	a.Describe(&c.VMName, "Name of the virtual machine.")
+	a.Describe(&c.Name, "Name of the network adapter.")
+	a.Describe(&c.MacAddress, "MAC address of the network adapter.")
+}
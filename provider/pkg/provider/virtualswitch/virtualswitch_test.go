@@ -0,0 +1,194 @@
+// Copyright 2016-2022, Pulumi Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package virtualswitch
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/pulumi/pulumi-hyperv/provider/pkg/provider/backend"
+	"github.com/pulumi/pulumi-hyperv/provider/pkg/provider/errs"
+)
+
+func ptr[T any](v T) *T {
+	return &v
+}
+
+// simulate returns a context whose resource operations run against a new Simulator. The
+// working directory is a temporary one, because the logger writes a file to it.
+func simulate(t *testing.T) (context.Context, *backend.Simulator) {
+	t.Chdir(t.TempDir())
+	sim := backend.NewSimulator()
+	return backend.WithBackend(context.Background(), sim), sim
+}
+
+func TestLifecycle(t *testing.T) {
+	ctx, sim := simulate(t)
+	c := &VirtualSwitch{}
+	inputs := VirtualSwitchInputs{
+		Name:              ptr("lan"),
+		SwitchType:        ptr("External"),
+		NetAdapterName:    ptr("Ethernet"),
+		AllowManagementOs: ptr(true),
+	}
+
+	id, state, err := c.Create(ctx, "lan-resource", inputs, true)
+	if err != nil || id != "lan" {
+		t.Fatalf("preview Create = %q, %v", id, err)
+	}
+	if switches, _ := sim.ListSwitches(ctx); len(switches) != 0 {
+		t.Fatalf("preview Create created %+v", switches)
+	}
+
+	if id, state, err = c.Create(ctx, "lan-resource", inputs, false); err != nil {
+		t.Fatal(err)
+	}
+	sw, err := sim.GetSwitch(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sw.SwitchType != backend.SwitchTypeExternal || sw.NetAdapterName != "Ethernet" || !sw.AllowManagementOS {
+		t.Errorf("created switch = %+v", sw)
+	}
+
+	if readID, _, _, err := c.Read(ctx, id, inputs, state); err != nil || readID != id {
+		t.Errorf("Read = %q, %v, want the switch to exist", readID, err)
+	}
+
+	news := VirtualSwitchInputs{Name: ptr("lan"), SwitchType: ptr("internal"), Notes: ptr("host only")}
+	if state, err = c.Update(ctx, id, state, news, false); err != nil {
+		t.Fatal(err)
+	}
+	if sw, _ = sim.GetSwitch(ctx, id); sw.SwitchType != backend.SwitchTypeInternal || sw.NetAdapterName != "" || sw.Notes != "host only" {
+		t.Errorf("updated switch = %+v", sw)
+	}
+
+	if err := c.Delete(ctx, id, state); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := sim.GetSwitch(ctx, id); !errors.Is(err, errs.ErrNotFound) {
+		t.Errorf("GetSwitch after Delete = %v, want ErrNotFound", err)
+	}
+	if readID, _, _, err := c.Read(ctx, id, news, state); err != nil || readID != "" {
+		t.Errorf("Read of a deleted switch = %q, %v, want an empty ID", readID, err)
+	}
+	if err := c.Delete(ctx, id, state); err != nil {
+		t.Errorf("Delete of a deleted switch = %v", err)
+	}
+}
+
+func TestCreateValidates(t *testing.T) {
+	ctx, sim := simulate(t)
+	c := &VirtualSwitch{}
+
+	tests := []struct {
+		name   string
+		inputs VirtualSwitchInputs
+	}{
+		{"no type", VirtualSwitchInputs{Name: ptr("lan")}},
+		{"unknown type", VirtualSwitchInputs{Name: ptr("lan"), SwitchType: ptr("Bridged")}},
+		{"external without adapter", VirtualSwitchInputs{Name: ptr("lan"), SwitchType: ptr("External")}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, _, err := c.Create(ctx, "lan", tt.inputs, true); err == nil {
+				t.Error("Create succeeded")
+			}
+		})
+	}
+
+	inputs := VirtualSwitchInputs{Name: ptr("lan"), SwitchType: ptr("External"), NetAdapterName: ptr("Wi-Fi")}
+	if _, _, err := c.Create(ctx, "lan", inputs, false); !errors.Is(err, errs.ErrNotFound) {
+		t.Errorf("Create on a missing adapter = %v, want ErrNotFound", err)
+	}
+	if switches, _ := sim.ListSwitches(ctx); len(switches) != 0 {
+		t.Errorf("failed Create left %+v", switches)
+	}
+}
+
+func TestImport(t *testing.T) {
+	ctx, sim := simulate(t)
+	c := &VirtualSwitch{}
+	created, err := sim.CreateSwitch(ctx, backend.Switch{Name: "lan", SwitchType: backend.SwitchTypeExternal, NetAdapterName: "Ethernet", Notes: "uplink"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, importID := range []string{"LAN", "{" + created.ID + "}"} {
+		id, inputs, outputs, err := c.Read(ctx, importID, VirtualSwitchInputs{}, VirtualSwitchOutputs{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if id != "lan" || *inputs.Name != "lan" || *inputs.SwitchType != backend.SwitchTypeExternal ||
+			*inputs.NetAdapterName != "Ethernet" || *inputs.AllowManagementOs || *inputs.Notes != "uplink" {
+			t.Errorf("import Read(%s) = %q, %+v", importID, id, inputs)
+		}
+		if outputs.SwitchType == nil || *outputs.SwitchType != backend.SwitchTypeExternal {
+			t.Errorf("import Read(%s) outputs = %+v", importID, outputs)
+		}
+	}
+
+	if id, _, _, err := c.Read(ctx, "wan", VirtualSwitchInputs{}, VirtualSwitchOutputs{}); err != nil || id != "" {
+		t.Errorf("import Read of a missing switch = %q, %v, want an empty ID", id, err)
+	}
+}
+
+func TestRefresh(t *testing.T) {
+	ctx, sim := simulate(t)
+	c := &VirtualSwitch{}
+	inputs := VirtualSwitchInputs{Name: ptr("lan"), SwitchType: ptr("external"), NetAdapterName: ptr("ethernet")}
+	id, state, err := c.Create(ctx, "lan", inputs, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state.SwitchId == nil || *state.SwitchId == "" || *state.BandwidthReservationMode != "Absolute" || *state.IovEnabled {
+		t.Errorf("Create outputs = %+v", state)
+	}
+	if _, err := sim.CreateVM(ctx, backend.VMSpec{Name: "web"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := sim.AddNetworkAdapter(ctx, backend.NetworkAdapter{Name: "nic", VMName: "web", SwitchName: "lan", MacAddress: "00155D000001"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Without changes, the inputs are kept as written.
+	_, read, state, err := c.Read(ctx, id, inputs, state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *read.SwitchType != "external" || *read.NetAdapterName != "ethernet" || read.AllowManagementOs != nil || read.Notes != nil {
+		t.Errorf("Read without drift = %+v", read)
+	}
+	if len(state.ConnectedAdapters) != 1 || *state.ConnectedAdapters[0].VMName != "web" ||
+		*state.ConnectedAdapters[0].Name != "nic" || *state.ConnectedAdapters[0].MacAddress != "00155D000001" {
+		t.Errorf("connected adapters = %+v", state.ConnectedAdapters)
+	}
+
+	// Changes made outside of Pulumi replace the inputs.
+	if _, err := sim.UpdateSwitch(ctx, "lan", backend.Switch{SwitchType: backend.SwitchTypeInternal, Notes: "changed"}); err != nil {
+		t.Fatal(err)
+	}
+	_, read, state, err = c.Read(ctx, id, inputs, state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *read.SwitchType != backend.SwitchTypeInternal || read.NetAdapterName != nil || *read.Notes != "changed" {
+		t.Errorf("Read with drift = %+v", read)
+	}
+	if *state.SwitchType != backend.SwitchTypeInternal || len(state.ConnectedAdapters) != 1 {
+		t.Errorf("Read outputs with drift = %+v", state)
+	}
+}
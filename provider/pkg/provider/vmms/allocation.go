@@ -0,0 +1,350 @@
+package vmms
+
+import (
+	"fmt"
+	"strings"
+
+	wmi "github.com/microsoft/wmi/pkg/wmiinstance"
+
+	"github.com/pulumi/pulumi-hyperv/provider/pkg/provider/allocation"
+	"github.com/pulumi/pulumi-hyperv/provider/pkg/provider/job"
+)
+
+// allocationSession implements allocation.Session and allocation.FeatureSession with the
+// Virtual System Management Service.
+type allocationSession struct {
+	v *VMMS
+}
+
+var _ allocation.FeatureSession = (*allocationSession)(nil)
+
+// AllocationSession returns the WMI implementation of allocation.Session.
+func (v *VMMS) AllocationSession() allocation.Session {
+	return &allocationSession{v: v}
+}
+
+// FeatureSession returns the WMI implementation of allocation.FeatureSession.
+func (v *VMMS) FeatureSession() allocation.FeatureSession {
+	return &allocationSession{v: v}
+}
+
+func (s *allocationSession) conn() (*wmi.WmiSession, error) {
+	conn := s.v.GetVirtualizationConn()
+	if conn == nil {
+		return nil, fmt.Errorf("virtualization connection is unavailable")
+	}
+	return conn, nil
+}
+
+// PrimordialPool returns the instance path of the primordial pool of a resource type.
+// CIM_ResourcePool also covers Msvm_ProcessorPool, which is not a Msvm_ResourcePool.
+func (s *allocationSession) PrimordialPool(resourceType uint16, subType string) (path string, err error) {
+	conn, err := s.conn()
+	if err != nil {
+		return "", err
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("recovered from panic in PrimordialPool: %v", r)
+		}
+	}()
+
+	pools, err := conn.QueryInstances(fmt.Sprintf("SELECT * FROM CIM_ResourcePool WHERE ResourceType = %d AND ResourceSubType = '%s' AND Primordial = TRUE",
+		resourceType, strings.ReplaceAll(subType, "'", "\\'")))
+	if err != nil {
+		return "", fmt.Errorf("failed to query resource pools: %w", err)
+	}
+	defer closeAll(pools)
+	if len(pools) == 0 {
+		return "", fmt.Errorf("no primordial pool for resource type %d", resourceType)
+	}
+	return pools[0].InstancePath(), nil
+}
+
+// AllocationCapabilities returns the Msvm_AllocationCapabilities of a pool.
+func (s *allocationSession) AllocationCapabilities(pool string) (path string, err error) {
+	conn, err := s.conn()
+	if err != nil {
+		return "", err
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("recovered from panic in AllocationCapabilities: %v", r)
+		}
+	}()
+
+	instance, err := conn.GetInstance(pool)
+	if err != nil {
+		return "", err
+	}
+	defer instance.Close()
+	capabilities, err := instance.GetRelated("Msvm_AllocationCapabilities")
+	if err != nil {
+		return "", err
+	}
+	defer capabilities.Close()
+	return capabilities.InstancePath(), nil
+}
+
+// CapabilitySettings returns the settings an Msvm_AllocationCapabilities instance defines
+// through Msvm_SettingsDefineCapabilities, or an Msvm_EthernetSwitchFeatureCapabilities
+// instance through Msvm_FeatureSettingsDefineCapabilities.
+func (s *allocationSession) CapabilitySettings(capabilities string) (settings []allocation.CapabilitySetting, err error) {
+	conn, err := s.conn()
+	if err != nil {
+		return nil, err
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("recovered from panic in CapabilitySettings: %v", r)
+		}
+	}()
+
+	instance, err := conn.GetInstance(capabilities)
+	if err != nil {
+		return nil, err
+	}
+	defer instance.Close()
+	association := "Msvm_SettingsDefineCapabilities"
+	if strings.EqualFold(instance.GetClassName(), "Msvm_EthernetSwitchFeatureCapabilities") {
+		association = "Msvm_FeatureSettingsDefineCapabilities"
+	}
+	references, err := instance.GetReferences(association)
+	if err != nil {
+		return nil, err
+	}
+	defer references.Close()
+	for _, reference := range references {
+		setting := allocation.CapabilitySetting{}
+		if value, err := reference.GetProperty("PartComponent"); err == nil {
+			setting.Path, _ = value.(string)
+		}
+		setting.ValueRole = uint16Property(reference, "ValueRole")
+		setting.ValueRange = uint16Property(reference, "ValueRange")
+		settings = append(settings, setting)
+	}
+	return settings, nil
+}
+
+// AddResourceSettings adds resources with the given settings to a virtual system.
+func (s *allocationSession) AddResourceSettings(systemSettings string, settings []*allocation.Settings) (allocation.Result, error) {
+	embedded, err := s.embed(settings)
+	if err != nil {
+		return allocation.Result{}, err
+	}
+	return s.invoke("AddResourceSettings", "ResultingResourceSettings", wmi.WmiMethodParamCollection{
+		wmi.NewWmiMethodParam("AffectedConfiguration", systemSettings),
+		wmi.NewWmiMethodParam("ResourceSettings", embedded),
+	})
+}
+
+// ModifyResourceSettings changes the settings of existing resources.
+func (s *allocationSession) ModifyResourceSettings(settings []*allocation.Settings) (allocation.Result, error) {
+	embedded, err := s.embed(settings)
+	if err != nil {
+		return allocation.Result{}, err
+	}
+	return s.invoke("ModifyResourceSettings", "ResultingResourceSettings", wmi.WmiMethodParamCollection{
+		wmi.NewWmiMethodParam("ResourceSettings", embedded),
+	})
+}
+
+// RemoveResourceSettings removes the resources with the given settings.
+func (s *allocationSession) RemoveResourceSettings(paths []string) (allocation.Result, error) {
+	return s.invoke("RemoveResourceSettings", "", wmi.WmiMethodParamCollection{
+		wmi.NewWmiMethodParam("ResourceSettings", paths),
+	})
+}
+
+// FeatureCapabilities returns the Msvm_EthernetSwitchFeatureCapabilities of a feature.
+func (s *allocationSession) FeatureCapabilities(featureID string) (path string, err error) {
+	conn, err := s.conn()
+	if err != nil {
+		return "", err
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("recovered from panic in FeatureCapabilities: %v", r)
+		}
+	}()
+
+	capabilities, err := conn.QueryInstances(fmt.Sprintf("SELECT * FROM Msvm_EthernetSwitchFeatureCapabilities WHERE FeatureId = '%s'", featureID))
+	if err != nil {
+		return "", fmt.Errorf("failed to query feature capabilities: %w", err)
+	}
+	defer closeAll(capabilities)
+	if len(capabilities) == 0 {
+		return "", fmt.Errorf("no feature capabilities with FeatureId %s", featureID)
+	}
+	return capabilities[0].InstancePath(), nil
+}
+
+// PortFeatures returns the feature settings of a switch port.
+func (s *allocationSession) PortFeatures(portSettings string) (features []allocation.PortFeature, err error) {
+	conn, err := s.conn()
+	if err != nil {
+		return nil, err
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("recovered from panic in PortFeatures: %v", r)
+		}
+	}()
+
+	port, err := conn.GetInstance(portSettings)
+	if err != nil {
+		return nil, err
+	}
+	defer port.Close()
+	related, err := port.GetRelatedEx("Msvm_EthernetPortSettingDataComponent", "", "PartComponent", "GroupComponent")
+	if err != nil {
+		return nil, err
+	}
+	defer related.Close()
+	for _, feature := range related {
+		features = append(features, allocation.PortFeature{Path: feature.InstancePath(), Class: feature.GetClassName()})
+	}
+	return features, nil
+}
+
+// AddFeatureSettings adds feature settings to a switch port.
+func (s *allocationSession) AddFeatureSettings(portSettings string, settings []*allocation.Settings) (allocation.Result, error) {
+	embedded, err := s.embed(settings)
+	if err != nil {
+		return allocation.Result{}, err
+	}
+	return s.invoke("AddFeatureSettings", "ResultingFeatureSettings", wmi.WmiMethodParamCollection{
+		wmi.NewWmiMethodParam("AffectedConfiguration", portSettings),
+		wmi.NewWmiMethodParam("FeatureSettings", embedded),
+	})
+}
+
+// ModifyFeatureSettings changes existing feature settings.
+func (s *allocationSession) ModifyFeatureSettings(settings []*allocation.Settings) (allocation.Result, error) {
+	embedded, err := s.embed(settings)
+	if err != nil {
+		return allocation.Result{}, err
+	}
+	return s.invoke("ModifyFeatureSettings", "ResultingFeatureSettings", wmi.WmiMethodParamCollection{
+		wmi.NewWmiMethodParam("FeatureSettings", embedded),
+	})
+}
+
+// RemoveFeatureSettings removes feature settings.
+func (s *allocationSession) RemoveFeatureSettings(paths []string) (allocation.Result, error) {
+	return s.invoke("RemoveFeatureSettings", "", wmi.WmiMethodParamCollection{
+		wmi.NewWmiMethodParam("FeatureSettings", paths),
+	})
+}
+
+// Job returns the state of a Msvm_ConcreteJob.
+func (s *allocationSession) Job(path string) (job.State, error) {
+	return (&jobSource{v: s.v}).Job(path)
+}
+
+// embed loads the instance of every settings, applies its properties and returns the
+// instances as embedded instances, the form the service methods take.
+func (s *allocationSession) embed(settings []*allocation.Settings) (embedded []string, err error) {
+	conn, err := s.conn()
+	if err != nil {
+		return nil, err
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("recovered from panic while preparing resource settings: %v", r)
+		}
+	}()
+
+	for _, setting := range settings {
+		instance, err := conn.GetInstance(setting.Path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get settings [%s]: %w", setting.Path, err)
+		}
+		for name, value := range setting.Properties {
+			if err := instance.SetProperty(name, value); err != nil {
+				instance.Close()
+				return nil, fmt.Errorf("failed to set %s: %w", name, err)
+			}
+		}
+		text, err := instance.EmbeddedXMLInstance()
+		instance.Close()
+		if err != nil {
+			return nil, err
+		}
+		embedded = append(embedded, text)
+	}
+	return embedded, nil
+}
+
+// invoke runs a method of Msvm_VirtualSystemManagementService without waiting for the job
+// it starts. resulting names the output parameter with the resulting settings, if any.
+func (s *allocationSession) invoke(name string, resulting string, inparams wmi.WmiMethodParamCollection) (result allocation.Result, err error) {
+	vsms := s.v.GetVirtualSystemManagementService()
+	if vsms == nil {
+		return result, fmt.Errorf("VirtualSystemManagementService is unavailable")
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("recovered from panic in %s: %v", name, r)
+		}
+	}()
+
+	method, err := vsms.GetWmiMethod(name)
+	if err != nil {
+		return result, fmt.Errorf("failed to get %s method: %w", name, err)
+	}
+	defer method.Close()
+
+	outparams := wmi.WmiMethodParamCollection{wmi.NewWmiMethodParam("Job", nil)}
+	if resulting != "" {
+		outparams = append(outparams, wmi.NewWmiMethodParam(resulting, nil))
+	}
+	out, err := method.Execute(inparams, outparams)
+	if err != nil {
+		return result, err
+	}
+	result.ReturnValue = uint32(out.ReturnValue)
+	if result.ReturnValue != job.ReturnCompleted && result.ReturnValue != job.ReturnJobStarted {
+		return result, ErrorCodeError(name, result.ReturnValue)
+	}
+	if path, ok := out.OutMethodParams["Job"]; ok && path.Value != nil {
+		result.Job, _ = path.Value.(string)
+	}
+	if paths, ok := out.OutMethodParams[resulting]; ok && paths.Value != nil {
+		values, _ := paths.Value.([]interface{})
+		for _, value := range values {
+			if path, ok := value.(string); ok {
+				result.Resulting = append(result.Resulting, path)
+			}
+		}
+	}
+	return result, nil
+}
+
+// uint16Property returns a numeric property as a uint16, or 0 if it is not set.
+func uint16Property(instance *wmi.WmiInstance, name string) uint16 {
+	value, err := instance.GetProperty(name)
+	if err != nil {
+		return 0
+	}
+	switch v := value.(type) {
+	case uint16:
+		return v
+	case int32:
+		return uint16(v)
+	case int64:
+		return uint16(v)
+	case uint32:
+		return uint16(v)
+	case int:
+		return uint16(v)
+	}
+	return 0
+}
@@ -0,0 +1,1032 @@
+package vmms
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	wmierrors "github.com/microsoft/wmi/pkg/errors"
+	"github.com/microsoft/wmi/pkg/virtualization/core/memory"
+	"github.com/microsoft/wmi/pkg/virtualization/core/processor"
+	"github.com/microsoft/wmi/pkg/virtualization/core/storage/disk"
+	"github.com/microsoft/wmi/pkg/virtualization/core/virtualsystem"
+	netsvc "github.com/microsoft/wmi/pkg/virtualization/network/service"
+	wmi "github.com/microsoft/wmi/pkg/wmiinstance"
+	v2 "github.com/microsoft/wmi/server2019/root/virtualization/v2"
+	"github.com/pulumi/pulumi-hyperv/provider/pkg/provider/allocation"
+	"github.com/pulumi/pulumi-hyperv/provider/pkg/provider/backend"
+	"github.com/pulumi/pulumi-hyperv/provider/pkg/provider/errs"
+	"github.com/pulumi/pulumi-hyperv/provider/pkg/provider/passthrough"
+	"github.com/pulumi/pulumi-hyperv/provider/pkg/provider/util"
+	"github.com/pulumi/pulumi-hyperv/provider/pkg/provider/vhd"
+)
+
+// wmiBackend implements backend.HypervBackend with the Hyper-V WMI provider. The WMI library
+// does not expose the type, physical adapter and notes of virtual switches, so switches are
+// created, read and updated through the embedded PowerShell backend, which also lists the
+// adapters connected to them, reads the switch ports of network adapters and reports the host
+// information and physical network adapters.
+type wmiBackend struct {
+	*backend.PowerShell
+	v *VMMS
+}
+
+// Backend returns a backend that performs operations through WMI. powershell is used for the
+// operations that WMI does not support.
+func (v *VMMS) Backend(powershell *backend.PowerShell) backend.HypervBackend {
+	return &wmiBackend{PowerShell: powershell, v: v}
+}
+
+func (b *wmiBackend) Name() string {
+	return "wmi"
+}
+
+// HostCapabilities reports what the connection shows about the Hyper-V services. The Hyper-V
+// module and the configuration versions are probed with PowerShell when it is available, and
+// the operating system is read through WMI otherwise.
+func (b *wmiBackend) HostCapabilities(ctx context.Context) (result *backend.HostCapabilities, err error) {
+	defer recoverWMI("HostCapabilities", &err)
+	caps := &backend.HostCapabilities{}
+	if b.PowerShell != nil {
+		if probed, err := b.PowerShell.HostCapabilities(ctx); err == nil {
+			caps = probed
+		} else {
+			b.v.logger.Warnf("Failed to probe the host with PowerShell: %v", err)
+		}
+	}
+	if caps.OSVersion == "" {
+		caps.OSCaption, caps.OSVersion, err = util.OperatingSystem()
+		if err != nil {
+			return nil, err
+		}
+	}
+	// The connection itself shows that the role is enabled and the services answer.
+	caps.HyperVRole = true
+	caps.VMMS = true
+	caps.VSMS = b.v.GetVirtualSystemManagementService() != nil
+	caps.HGS = b.v.GetHgsConn() != nil
+	caps.Complete()
+	return caps, nil
+}
+
+// recoverWMI turns a panic in the WMI library into an error of op.
+func recoverWMI(op string, err *error) {
+	if r := recover(); r != nil {
+		*err = fmt.Errorf("recovered from panic in %s: %v", op, r)
+	}
+}
+
+// findVM returns the virtual machine with the given name or ID. Names are compared
+// case-insensitively, like Get-VM does.
+func (b *wmiBackend) findVM(nameOrID string) (*virtualsystem.VirtualMachine, error) {
+	vsms := b.v.GetVirtualSystemManagementService()
+	if vsms == nil {
+		return nil, fmt.Errorf("VirtualSystemManagementService is unavailable")
+	}
+	vms, err := vsms.GetVirtualMachines()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list virtual machines: %w", err)
+	}
+	defer vms.Close()
+	id := strings.Trim(nameOrID, "{}")
+	for _, vm := range vms {
+		if !strings.EqualFold(vm.Name(), nameOrID) && !strings.EqualFold(vm.ID(), id) {
+			continue
+		}
+		instance, err := vm.Clone()
+		if err != nil {
+			return nil, err
+		}
+		return virtualsystem.NewVirtualMachine(instance)
+	}
+	return nil, errs.New(errs.ErrNotFound, "Get-VM", fmt.Sprintf("virtual machine %s not found", nameOrID))
+}
+
+// describeVM reads the properties of vm that backend.VM reports.
+func describeVM(vm *virtualsystem.VirtualMachine) (backend.VM, error) {
+	result := backend.VM{ID: vm.ID(), Name: vm.Name()}
+	state, err := vm.State()
+	if err != nil {
+		return result, fmt.Errorf("failed to get the state of VM %s: %w", result.Name, err)
+	}
+	result.State = powerState(state)
+	if generation, err := vm.GetVirtualMachineGeneration(); err == nil {
+		result.Generation = 2
+		if generation == virtualsystem.HyperVGeneration_V1 {
+			result.Generation = 1
+		}
+	}
+	if settings, err := vm.GetMemory(); err == nil {
+		result.MemoryMB, _ = settings.GetSizeMB()
+		result.DynamicMemory, _ = settings.GetPropertyDynamicMemoryEnabled()
+		result.MinimumMemoryMB, _ = settings.GetMinimumMemoryMB()
+		result.MaximumMemoryMB, _ = settings.GetMaximumMemoryMB()
+		settings.Close()
+	}
+	if settings, err := vm.GetProcessor(); err == nil {
+		count, _ := settings.GetCPUCount()
+		result.ProcessorCount = int(count)
+		settings.Close()
+	}
+	if settings, err := vm.GetVirtualSystemSettingData(); err == nil {
+		if action, err := settings.GetPropertyAutomaticStartupAction(); err == nil {
+			result.AutomaticStartAction = startAction(action)
+		}
+		if action, err := settings.GetPropertyAutomaticShutdownAction(); err == nil {
+			result.AutomaticStopAction = stopAction(action)
+		}
+		settings.Close()
+	}
+	return result, nil
+}
+
+// startAction maps the AutomaticStartupAction of a virtual machine to the name Get-VM reports.
+func startAction(action v2.VirtualSystemSettingData_AutomaticStartupAction) string {
+	switch action {
+	case v2.VirtualSystemSettingData_AutomaticStartupAction_Restart_if_previously_active:
+		return "StartIfRunning"
+	case v2.VirtualSystemSettingData_AutomaticStartupAction_Always_startup:
+		return "Start"
+	default:
+		return "Nothing"
+	}
+}
+
+// stopAction maps the AutomaticShutdownAction of a virtual machine to the name Get-VM reports.
+func stopAction(action v2.VirtualSystemSettingData_AutomaticShutdownAction) string {
+	switch action {
+	case v2.VirtualSystemSettingData_AutomaticShutdownAction_Save_state:
+		return "Save"
+	case v2.VirtualSystemSettingData_AutomaticShutdownAction_Shutdown:
+		return "ShutDown"
+	default:
+		return "TurnOff"
+	}
+}
+
+// powerState maps the EnabledState of a virtual machine to the state Get-VM reports.
+func powerState(state virtualsystem.VirtualMachineState) backend.PowerState {
+	switch state {
+	case virtualsystem.Running, virtualsystem.RunningCritical:
+		return backend.PowerStateRunning
+	case virtualsystem.Paused, virtualsystem.PausedCritical:
+		return backend.PowerStatePaused
+	case virtualsystem.Saved, virtualsystem.FastSaved, virtualsystem.SavedCritical:
+		return backend.PowerStateSaved
+	case virtualsystem.Off, virtualsystem.OffCritical:
+		return backend.PowerStateOff
+	default:
+		return backend.PowerState(fmt.Sprint(state))
+	}
+}
+
+func (b *wmiBackend) ListVMs(ctx context.Context) (vms []backend.VM, err error) {
+	defer recoverWMI("ListVMs", &err)
+	vsms := b.v.GetVirtualSystemManagementService()
+	if vsms == nil {
+		return nil, fmt.Errorf("VirtualSystemManagementService is unavailable")
+	}
+	found, err := vsms.GetVirtualMachines()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list virtual machines: %w", err)
+	}
+	defer found.Close()
+	for _, vm := range found {
+		described, err := describeVM(vm)
+		if err != nil {
+			return nil, err
+		}
+		vms = append(vms, described)
+	}
+	return vms, nil
+}
+
+func (b *wmiBackend) GetVM(ctx context.Context, nameOrID string) (result *backend.VM, err error) {
+	defer recoverWMI("GetVM", &err)
+	vm, err := b.findVM(nameOrID)
+	if err != nil {
+		return nil, err
+	}
+	defer vm.Close()
+	described, err := describeVM(vm)
+	if err != nil {
+		return nil, err
+	}
+	return &described, nil
+}
+
+func (b *wmiBackend) CreateVM(ctx context.Context, spec backend.VMSpec) (result *backend.VM, err error) {
+	defer recoverWMI("CreateVM", &err)
+	vsms := b.v.GetVirtualSystemManagementService()
+	if vsms == nil {
+		return nil, fmt.Errorf("VirtualSystemManagementService is unavailable")
+	}
+	if spec.Name == "" {
+		return nil, fmt.Errorf("a virtual machine name is required")
+	}
+	whost := b.v.GetVirtualizationConn().WMIHost
+
+	settings, err := virtualsystem.GetVirtualSystemSettingData(whost, spec.Name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get the default system settings: %w", err)
+	}
+	defer settings.Close()
+	generation := virtualsystem.HyperVGeneration(virtualsystem.HyperVGeneration_V2)
+	if spec.Generation == 1 {
+		generation = virtualsystem.HyperVGeneration(virtualsystem.HyperVGeneration_V1)
+		if err := settings.SetPropertySecureBootEnabled(false); err != nil {
+			return nil, err
+		}
+	}
+	if err := settings.SetHyperVGeneration(generation); err != nil {
+		return nil, err
+	}
+
+	memorySettings, err := memory.GetDefaultMemorySettingData(whost)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get the default memory settings: %w", err)
+	}
+	defer memorySettings.Close()
+	memoryMB := spec.MemoryMB
+	if memoryMB == 0 {
+		memoryMB = 1024
+	}
+	if err := memorySettings.SetSizeMB(memoryMB); err != nil {
+		return nil, err
+	}
+
+	processorSettings, err := processor.GetDefaultProcessorSettingData(whost)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get the default processor settings: %w", err)
+	}
+	defer processorSettings.Close()
+	processorCount := spec.ProcessorCount
+	if processorCount == 0 {
+		processorCount = 1
+	}
+	if err := processorSettings.SetCPUCount(uint64(processorCount)); err != nil {
+		return nil, err
+	}
+
+	vm, err := vsms.CreateVirtualMachine(settings, memorySettings, processorSettings)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create VM %s: %w", spec.Name, err)
+	}
+	defer vm.Close()
+	described, err := describeVM(vm)
+	if err != nil {
+		return nil, err
+	}
+	return &described, nil
+}
+
+func (b *wmiBackend) SetVMState(ctx context.Context, name string, state backend.PowerState) (err error) {
+	defer recoverWMI("SetVMState", &err)
+	vm, err := b.findVM(name)
+	if err != nil {
+		return err
+	}
+	defer vm.Close()
+	current, err := vm.State()
+	if err != nil {
+		return fmt.Errorf("failed to get the state of VM %s: %w", name, err)
+	}
+	switch state {
+	case backend.PowerStateRunning:
+		if current == virtualsystem.Paused {
+			err = vm.Resume()
+		} else {
+			err = vm.Start()
+		}
+	case backend.PowerStateOff:
+		err = vm.Stop(true)
+	case backend.PowerStatePaused:
+		err = vm.Pause()
+	case backend.PowerStateSaved:
+		err = vm.Save()
+	default:
+		return fmt.Errorf("unsupported power state %s", state)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to change the state of VM %s from %s to %s: %w", name, powerState(current), state, err)
+	}
+	return nil
+}
+
+// SetVM modifies the processor, memory and system settings of the VM, each only when one of
+// its settings changes.
+func (b *wmiBackend) SetVM(ctx context.Context, name string, settings backend.VMSettings) error {
+	if err := settings.Validate(); err != nil {
+		return err
+	}
+	err := b.setVM(name, settings)
+	if err == nil || errors.Is(err, errs.ErrNotFound) {
+		return err
+	}
+	if fallbackErr := b.v.fallback(fmt.Sprintf("configure VM %s", name), err); fallbackErr != nil {
+		return fallbackErr
+	}
+	return b.PowerShell.SetVM(ctx, name, settings)
+}
+
+func (b *wmiBackend) setVM(name string, settings backend.VMSettings) (err error) {
+	defer recoverWMI("SetVM", &err)
+	vsms := b.v.GetVirtualSystemManagementService()
+	if vsms == nil {
+		return errVSMSUnavailable
+	}
+	vm, err := b.findVM(name)
+	if err != nil {
+		return err
+	}
+	defer vm.Close()
+
+	if settings.ProcessorCount != nil {
+		if err := vsms.SetProcessorCount(vm, uint64(*settings.ProcessorCount)); err != nil {
+			return fmt.Errorf("failed to set the processor count of VM %s: %w", name, err)
+		}
+	}
+
+	if settings.MemoryMB != nil || settings.DynamicMemory != nil || settings.MinimumMemoryMB != nil || settings.MaximumMemoryMB != nil {
+		memorySettings, err := vm.GetMemory()
+		if err != nil {
+			return fmt.Errorf("failed to get the memory settings of VM %s: %w", name, err)
+		}
+		defer memorySettings.Close()
+		if settings.DynamicMemory != nil {
+			if err := memorySettings.SetPropertyDynamicMemoryEnabled(*settings.DynamicMemory); err != nil {
+				return err
+			}
+		}
+		if settings.MemoryMB != nil {
+			if err := memorySettings.SetSizeMB(*settings.MemoryMB); err != nil {
+				return err
+			}
+		}
+		if settings.MinimumMemoryMB != nil {
+			if err := memorySettings.SetPropertyReservation(*settings.MinimumMemoryMB); err != nil {
+				return err
+			}
+		}
+		if settings.MaximumMemoryMB != nil {
+			if err := memorySettings.SetPropertyLimit(*settings.MaximumMemoryMB); err != nil {
+				return err
+			}
+		}
+		if err := vsms.ModifyVirtualSystemResourceEx(memorySettings.WmiInstance, -1); err != nil {
+			return fmt.Errorf("failed to set the memory of VM %s: %w", name, err)
+		}
+	}
+
+	if settings.AutomaticStartAction != nil || settings.AutomaticStopAction != nil {
+		systemSettings, err := vm.GetVirtualSystemSettingData()
+		if err != nil {
+			return fmt.Errorf("failed to get the settings of VM %s: %w", name, err)
+		}
+		defer systemSettings.Close()
+		// The WMI values follow the order of the actions, starting at 2.
+		if settings.AutomaticStartAction != nil {
+			action, _ := backend.AutomaticStartAction(*settings.AutomaticStartAction)
+			if err := systemSettings.SetPropertyAutomaticStartupAction(v2.VirtualSystemSettingData_AutomaticStartupAction(action + 2)); err != nil {
+				return err
+			}
+		}
+		if settings.AutomaticStopAction != nil {
+			action, _ := backend.AutomaticStopAction(*settings.AutomaticStopAction)
+			if err := systemSettings.SetPropertyAutomaticShutdownAction(v2.VirtualSystemSettingData_AutomaticShutdownAction(action + 2)); err != nil {
+				return err
+			}
+		}
+		if err := vsms.ModifyVirtualSystemSettings(systemSettings, -1); err != nil {
+			return fmt.Errorf("failed to set the automatic actions of VM %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+func (b *wmiBackend) DeleteVM(ctx context.Context, name string) (err error) {
+	defer recoverWMI("DeleteVM", &err)
+	vm, err := b.findVM(name)
+	if err != nil {
+		return err
+	}
+	defer vm.Close()
+	if state, err := vm.State(); err == nil && powerState(state) != backend.PowerStateOff {
+		return errs.New(errs.ErrInvalidState, "DestroySystem",
+			fmt.Sprintf("virtual machine %s must be off to be deleted, it is %s", name, powerState(state)))
+	}
+	if err := b.v.GetVirtualSystemManagementService().DeleteVirtualMachine(vm); err != nil {
+		return fmt.Errorf("failed to delete VM %s: %w", name, err)
+	}
+	return nil
+}
+
+// localFiles returns an error when the host is remote, so that the operations that reach the
+// disk files through the file system of the provider do not act on files of the wrong machine.
+func (b *wmiBackend) localFiles(op string, path string) error {
+	if b.v.Local() {
+		return nil
+	}
+	return errs.New(errs.ErrNotSupported, op, fmt.Sprintf("vhd [%s] is on the remote host %s, whose files the wmi backend cannot read or change", path, b.v.host.HostName))
+}
+
+// GetDisk reads the disk file directly with the built-in reader, which needs no Hyper-V service.
+func (b *wmiBackend) GetDisk(ctx context.Context, path string) (*backend.Disk, error) {
+	if err := b.localFiles("Get-VHD", path); err != nil {
+		return nil, err
+	}
+	info, err := vhd.Inspect(path)
+	if os.IsNotExist(err) {
+		return nil, errs.Wrap(errs.ErrNotFound, "Get-VHD", err)
+	}
+	if err != nil {
+		return nil, err
+	}
+	found := &backend.Disk{Path: path, Info: *info}
+	if info.Format == vhd.FormatVHDX {
+		// The used size is unknown when the partition table cannot be read.
+		found.MinimumSize, _ = vhd.UsedSize(path)
+	}
+	return found, nil
+}
+
+// CreateDisk creates the disk with the Image Management Service.
+func (b *wmiBackend) CreateDisk(ctx context.Context, path string, opts vhd.CreateOptions) (*backend.Disk, error) {
+	if err := b.localFiles("New-VHD", path); err != nil {
+		return nil, err
+	}
+	if _, err := os.Stat(path); err == nil {
+		return nil, errs.New(errs.ErrAlreadyExists, "New-VHD", fmt.Sprintf("vhd [%s] already exists", path))
+	}
+	if err := b.v.CreateVirtualHardDisk(ctx, path, opts); err != nil {
+		if fallbackErr := b.v.fallback(fmt.Sprintf("create vhd [%s]", path), err); fallbackErr != nil {
+			return nil, fallbackErr
+		}
+		return b.PowerShell.CreateDisk(ctx, path, opts)
+	}
+	return b.GetDisk(ctx, path)
+}
+
+func (b *wmiBackend) DeleteDisk(ctx context.Context, path string) error {
+	if err := b.localFiles("Remove-Item", path); err != nil {
+		return err
+	}
+	users, err := b.v.FindVirtualHardDiskUsers(path)
+	if err != nil {
+		if fallbackErr := b.v.fallback(fmt.Sprintf("find the users of vhd [%s]", path), err); fallbackErr != nil {
+			return fallbackErr
+		}
+		return b.PowerShell.DeleteDisk(ctx, path)
+	}
+	if len(users) > 0 {
+		return errs.New(errs.ErrInvalidState, "Remove-Item", fmt.Sprintf("vhd [%s] is attached to virtual machine %s", path, users[0].Name))
+	}
+	if err := os.Remove(path); err != nil {
+		if os.IsNotExist(err) {
+			return errs.Wrap(errs.ErrNotFound, "Remove-Item", err)
+		}
+		return err
+	}
+	return nil
+}
+
+// ListDiskUsers finds the storage settings that use the disk, which include those of
+// checkpoints. The state and controller of a checkpoint are not reported.
+func (b *wmiBackend) ListDiskUsers(ctx context.Context, path string) (users []backend.DiskUser, err error) {
+	defer recoverWMI("ListDiskUsers", &err)
+	found, err := b.v.FindVirtualHardDiskUsers(path)
+	if err != nil {
+		if fallbackErr := b.v.fallback(fmt.Sprintf("find the users of vhd [%s]", path), err); fallbackErr != nil {
+			return nil, fallbackErr
+		}
+		return b.PowerShell.ListDiskUsers(ctx, path)
+	}
+	for _, user := range found {
+		result := backend.DiskUser{VMName: user.Name}
+		if vm, err := b.findVM(user.Name); err == nil {
+			if described, err := describeVM(vm); err == nil {
+				result.State, result.Generation = described.State, described.Generation
+			}
+			if slot, err := b.v.virtualHardDiskSlotWMI(vm.Name(), path); err == nil && slot != nil {
+				result.ControllerType = slot.ControllerType
+			}
+			vm.Close()
+		}
+		users = append(users, result)
+	}
+	return users, nil
+}
+
+func (b *wmiBackend) ResizeDisk(ctx context.Context, path string, size uint64) (err error) {
+	defer recoverWMI("ResizeDisk", &err)
+	ims := b.v.GetImageManagementService()
+	if ims == nil {
+		err = fmt.Errorf("ImageManagementService is unavailable")
+	} else {
+		err = ims.ResizeDisk(path, size)
+	}
+	if err != nil {
+		if fallbackErr := b.v.fallback(fmt.Sprintf("resize vhd [%s]", path), err); fallbackErr != nil {
+			return fallbackErr
+		}
+		return b.PowerShell.ResizeDisk(ctx, path, size)
+	}
+	return nil
+}
+
+// ConvertDisk writes the converted copy next to the disk, keeping its format, size and sector
+// sizes, and swaps it in once the conversion has succeeded.
+func (b *wmiBackend) ConvertDisk(ctx context.Context, path string, diskType string) error {
+	if err := b.localFiles("Convert-VHD", path); err != nil {
+		return err
+	}
+	diskType, err := vhd.NormalizeDiskType(diskType)
+	if err != nil {
+		return err
+	}
+	ext := filepath.Ext(path)
+	converted := strings.TrimSuffix(path, ext) + ".converting" + ext
+	if err := os.Remove(converted); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove leftover conversion target [%s]: %w", converted, err)
+	}
+	if err := b.convertDisk(ctx, path, converted, diskType); err != nil {
+		os.Remove(converted)
+		if fallbackErr := b.v.fallback(fmt.Sprintf("convert vhd [%s]", path), err); fallbackErr != nil {
+			return fallbackErr
+		}
+		return b.PowerShell.ConvertDisk(ctx, path, diskType)
+	}
+
+	backup := path + ".bak"
+	if err := os.Rename(path, backup); err != nil {
+		os.Remove(converted)
+		return fmt.Errorf("failed to move vhd [%s] aside: %w", path, err)
+	}
+	if err := os.Rename(converted, path); err != nil {
+		if restoreErr := os.Rename(backup, path); restoreErr != nil {
+			return fmt.Errorf("failed to move converted vhd into place: %v, and the original disk is at [%s]: %w", err, backup, restoreErr)
+		}
+		return fmt.Errorf("failed to move converted vhd into place at [%s]: %w", path, err)
+	}
+	if err := os.Remove(backup); err != nil {
+		b.v.logger.Warnf("Converted vhd [%s] but failed to remove the original at [%s]: %v", path, backup, err)
+	}
+	return nil
+}
+
+// convertDisk converts source into destination with ConvertVirtualHardDisk.
+func (b *wmiBackend) convertDisk(ctx context.Context, source, destination, diskType string) (err error) {
+	defer recoverWMI("ConvertDisk", &err)
+	found, err := b.GetDisk(ctx, source)
+	if err != nil {
+		return err
+	}
+	format := disk.VirtualHardDiskFormat(disk.VirtualHardDiskFormat_2)
+	if found.Format == vhd.FormatVHD {
+		format = disk.VirtualHardDiskFormat_1
+	}
+	setting, err := disk.GetVirtualHardDiskSettingData(
+		b.v.GetVirtualizationConn().WMIHost,
+		destination,
+		found.LogicalSectorSize,
+		found.PhysicalSectorSize,
+		found.BlockSize,
+		found.VirtualSize,
+		diskType == vhd.TypeDynamic,
+		format,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to get disk settings: %w", err)
+	}
+	defer setting.Close()
+	return b.v.ConvertVirtualHardDisk(ctx, source, setting)
+}
+
+func (b *wmiBackend) CompactDisk(ctx context.Context, path string) error {
+	if err := b.v.CompactVirtualHardDisk(ctx, path, CompactModeFull); err != nil {
+		if fallbackErr := b.v.fallback(fmt.Sprintf("compact vhd [%s]", path), err); fallbackErr != nil {
+			return fallbackErr
+		}
+		return b.PowerShell.CompactDisk(ctx, path)
+	}
+	return nil
+}
+
+func (b *wmiBackend) MergeDisk(ctx context.Context, path string, destination string) error {
+	if err := b.localFiles("Merge-VHD", path); err != nil {
+		return err
+	}
+	if err := b.v.MergeVirtualHardDisk(ctx, path, destination); err != nil {
+		if fallbackErr := b.v.fallback(fmt.Sprintf("merge vhd [%s]", path), err); fallbackErr != nil {
+			return fallbackErr
+		}
+		return b.PowerShell.MergeDisk(ctx, path, destination)
+	}
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		b.v.logger.Warnf("Merged vhd [%s] but failed to remove it: %v", path, err)
+	}
+	return nil
+}
+
+// MoveDisk renames the disk file. A rename cannot cross volumes, so Move-Item, which copies
+// the file, moves it then.
+func (b *wmiBackend) MoveDisk(ctx context.Context, path string, destination string) (string, error) {
+	if err := b.localFiles("Move-Item", path); err != nil {
+		return "", err
+	}
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return "", errs.Wrap(errs.ErrNotFound, "Move-Item", err)
+	}
+	dest := destination
+	if st, err := os.Stat(dest); (err == nil && st.IsDir()) || strings.HasSuffix(dest, `\`) || strings.HasSuffix(dest, "/") {
+		dest = filepath.Join(dest, filepath.Base(path))
+	}
+	if _, err := os.Stat(dest); err == nil {
+		return "", errs.New(errs.ErrAlreadyExists, "Move-Item", fmt.Sprintf("[%s] already exists", dest))
+	}
+	if err := os.MkdirAll(filepath.Dir(dest), 0o755); err != nil {
+		return "", fmt.Errorf("failed to create the directory of [%s]: %w", dest, err)
+	}
+	if err := os.Rename(path, dest); err != nil {
+		if fallbackErr := b.v.fallback(fmt.Sprintf("move vhd [%s]", path), err); fallbackErr != nil {
+			return "", fallbackErr
+		}
+		return b.PowerShell.MoveDisk(ctx, path, dest)
+	}
+	return dest, nil
+}
+
+func (b *wmiBackend) ListDiskDrives(ctx context.Context, vmName string) (drives []backend.DiskDrive, err error) {
+	defer recoverWMI("ListDiskDrives", &err)
+	vm, err := b.findVM(vmName)
+	if err != nil {
+		return nil, err
+	}
+	defer vm.Close()
+	paths, err := vm.GetAttachedVirtualHardDisks()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list the disks of VM %s: %w", vmName, err)
+	}
+	for _, path := range paths {
+		slot, err := b.v.virtualHardDiskSlotWMI(vm.Name(), path)
+		if err != nil {
+			return nil, err
+		}
+		if slot != nil {
+			drives = append(drives, diskDrive(path, slot))
+		}
+	}
+	return drives, nil
+}
+
+func diskDrive(path string, slot *DiskSlot) backend.DiskDrive {
+	return backend.DiskDrive{
+		Path:               path,
+		ControllerType:     slot.ControllerType,
+		ControllerNumber:   slot.ControllerNumber,
+		ControllerLocation: slot.ControllerLocation,
+	}
+}
+
+func (b *wmiBackend) AttachDisk(ctx context.Context, vmName string, drive backend.DiskDrive) (result *backend.DiskDrive, err error) {
+	defer recoverWMI("AttachDisk", &err)
+	vm, err := b.findVM(vmName)
+	if err != nil {
+		return nil, err
+	}
+	defer vm.Close()
+	controllerType := strings.ToUpper(drive.ControllerType)
+	if controllerType == "" {
+		controllerType = backend.ControllerSCSI
+	}
+	if err := b.v.AttachVirtualHardDisk(ctx, vm, drive.Path, controllerType, drive.ControllerNumber, drive.ControllerLocation, drive.Settings, b.v.logger); err != nil {
+		return nil, err
+	}
+	slot, err := b.v.VirtualHardDiskSlot(ctx, vm.Name(), drive.Path)
+	if err != nil {
+		return nil, fmt.Errorf("attached vhd [%s] to VM %s but could not read its slot: %w", drive.Path, vmName, err)
+	}
+	if slot == nil {
+		return nil, fmt.Errorf("vhd [%s] is not attached to VM %s after attaching it", drive.Path, vmName)
+	}
+	attached := diskDrive(drive.Path, slot)
+	return &attached, nil
+}
+
+func (b *wmiBackend) DetachDisk(ctx context.Context, vmName string, path string) (err error) {
+	defer recoverWMI("DetachDisk", &err)
+	vm, err := b.findVM(vmName)
+	if err != nil {
+		return err
+	}
+	defer vm.Close()
+	return b.v.DetachVirtualHardDisk(ctx, vm, path, b.v.logger)
+}
+
+func (b *wmiBackend) SetDiskDrive(ctx context.Context, vmName string, path string, settings backend.DiskDriveSettings) error {
+	err := b.setDiskDrive(vmName, path, &settings)
+	if err == nil || errors.Is(err, errs.ErrNotFound) {
+		return err
+	}
+	if fallbackErr := b.v.fallback(fmt.Sprintf("configure the drive of vhd [%s]", path), err); fallbackErr != nil {
+		return fallbackErr
+	}
+	return b.PowerShell.SetDiskDrive(ctx, vmName, path, settings)
+}
+
+func (b *wmiBackend) setDiskDrive(vmName string, path string, settings *backend.DiskDriveSettings) (err error) {
+	defer recoverWMI("SetDiskDrive", &err)
+	vm, err := b.findVM(vmName)
+	if err != nil {
+		return err
+	}
+	defer vm.Close()
+	return b.v.SetVirtualHardDiskSettings(vm, path, settings)
+}
+
+func (b *wmiBackend) SetSCSIControllerCount(ctx context.Context, vmName string, count int) error {
+	if count < 0 || count > backend.MaxSCSIControllers {
+		return errs.New(errs.ErrInvalidParameter, "Add-VMScsiController", fmt.Sprintf("the SCSI controller count must be between 0 and %d", backend.MaxSCSIControllers))
+	}
+	err := b.setSCSIControllerCount(ctx, vmName, count)
+	if err == nil || errors.Is(err, errs.ErrNotFound) {
+		return err
+	}
+	if fallbackErr := b.v.fallback(fmt.Sprintf("set the SCSI controllers of VM %s", vmName), err); fallbackErr != nil {
+		return fallbackErr
+	}
+	return b.PowerShell.SetSCSIControllerCount(ctx, vmName, count)
+}
+
+func (b *wmiBackend) setSCSIControllerCount(ctx context.Context, vmName string, count int) (err error) {
+	defer recoverWMI("SetSCSIControllerCount", &err)
+	vm, err := b.findVM(vmName)
+	if err != nil {
+		return err
+	}
+	defer vm.Close()
+	return b.v.SetSCSIControllerCount(ctx, vm, count)
+}
+
+// AttachPassThroughDisk adds the drive through the Virtual System Management Service. Host
+// disks are listed with the PowerShell backend, because MSFT_Disk lives in the storage
+// namespace.
+func (b *wmiBackend) AttachPassThroughDisk(ctx context.Context, vmName string, spec passthrough.Spec) (*passthrough.Disk, error) {
+	disk, err := passthrough.Attach(b.v.newPassThroughSession(ctx, b.PowerShell), vmName, spec)
+	if err == nil {
+		return &disk, nil
+	}
+	if fallbackErr := b.v.fallback(fmt.Sprintf("attach %s to VM %s", spec, vmName), err); fallbackErr != nil {
+		return nil, fallbackErr
+	}
+	return b.PowerShell.AttachPassThroughDisk(ctx, vmName, spec)
+}
+
+func (b *wmiBackend) DetachPassThroughDisk(ctx context.Context, vmName string, spec passthrough.Spec) error {
+	err := passthrough.Detach(b.v.newPassThroughSession(ctx, b.PowerShell), vmName, spec)
+	if err == nil {
+		return nil
+	}
+	if fallbackErr := b.v.fallback(fmt.Sprintf("detach %s from VM %s", spec, vmName), err); fallbackErr != nil {
+		return fallbackErr
+	}
+	return b.PowerShell.DetachPassThroughDisk(ctx, vmName, spec)
+}
+
+func (b *wmiBackend) DeleteSwitch(ctx context.Context, name string) (err error) {
+	defer recoverWMI("DeleteSwitch", &err)
+	service, err := netsvc.GetVirtualEthernetSwitchManagementService(b.v.GetVirtualizationConn().WMIHost)
+	if err != nil {
+		return fmt.Errorf("failed to get the VirtualEthernetSwitchManagementService: %w", err)
+	}
+	defer service.Close()
+	vswitch, err := service.FindVirtualSwitchByName(name)
+	if wmierrors.IsNotFound(err) {
+		return errs.Wrap(errs.ErrNotFound, "Get-VMSwitch", err)
+	}
+	if err != nil {
+		return err
+	}
+	defer vswitch.Close()
+	if err := service.DeleteVirtualSwitch(vswitch); err != nil {
+		return fmt.Errorf("failed to delete virtual switch %s: %w", name, err)
+	}
+	return nil
+}
+
+// switchNames returns the names of the virtual switches by their Name property, the GUID
+// that appears in the HostResource of connected ports.
+func (b *wmiBackend) switchNames() (map[string]string, error) {
+	service, err := netsvc.GetVirtualEthernetSwitchManagementService(b.v.GetVirtualizationConn().WMIHost)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get the VirtualEthernetSwitchManagementService: %w", err)
+	}
+	defer service.Close()
+	switches, err := service.GetVirtualSwitches()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list virtual switches: %w", err)
+	}
+	defer switches.Close()
+	names := map[string]string{}
+	for _, vswitch := range *switches {
+		names[strings.ToLower(vswitch.ID())] = stringProperty(vswitch.WmiInstance, "ElementName")
+	}
+	return names, nil
+}
+
+// stringProperty returns a string property of instance, or "" if it cannot be read.
+func stringProperty(instance *wmi.WmiInstance, name string) string {
+	value, err := instance.GetProperty(name)
+	if err != nil {
+		return ""
+	}
+	s, _ := value.(string)
+	return s
+}
+
+func (b *wmiBackend) ListNetworkAdapters(ctx context.Context, vmName string) (adapters []backend.NetworkAdapter, err error) {
+	defer recoverWMI("ListNetworkAdapters", &err)
+	vm, err := b.findVM(vmName)
+	if err != nil {
+		return nil, err
+	}
+	defer vm.Close()
+	found, err := vm.GetVirtualNetworkAdapters()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list the network adapters of VM %s: %w", vmName, err)
+	}
+	defer found.Close()
+	switches, err := b.switchNames()
+	if err != nil {
+		return nil, err
+	}
+	for _, vna := range found {
+		adapter := backend.NetworkAdapter{
+			Name:       stringProperty(vna.WmiInstance, "ElementName"),
+			VMName:     vm.Name(),
+			MacAddress: stringProperty(vna.WmiInstance, "Address"),
+		}
+		if static, err := vna.GetProperty("StaticMacAddress"); err == nil {
+			isStatic, _ := static.(bool)
+			adapter.DynamicMacAddress = !isStatic
+		}
+		if port, err := vna.GetEthernetPortAllocationSettingData(); err == nil {
+			if enabled, err := port.GetProperty("EnabledState"); err == nil && fmt.Sprint(enabled) == "2" {
+				if resources, err := port.GetProperty("HostResource"); err == nil {
+					if list, ok := resources.([]interface{}); ok && len(list) > 0 {
+						adapter.SwitchName = switchNameOf(fmt.Sprint(list[0]), switches)
+					}
+				}
+			}
+			port.Close()
+		}
+		adapters = append(adapters, adapter)
+	}
+	return adapters, nil
+}
+
+// switchNameOf returns the name of the switch whose instance path is path.
+func switchNameOf(path string, switches map[string]string) string {
+	lower := strings.ToLower(path)
+	for id, name := range switches {
+		if strings.Contains(lower, `name="`+id+`"`) {
+			return name
+		}
+	}
+	return ""
+}
+
+func (b *wmiBackend) AddNetworkAdapter(ctx context.Context, adapter backend.NetworkAdapter) (result *backend.NetworkAdapter, err error) {
+	defer recoverWMI("AddNetworkAdapter", &err)
+	vsms := b.v.GetVirtualSystemManagementService()
+	vm, err := b.findVM(adapter.VMName)
+	if err != nil {
+		return nil, err
+	}
+	defer vm.Close()
+	if adapter.Name == "" {
+		adapter.Name = "Network Adapter"
+	}
+	if adapter.MacAddress != "" {
+		vna, err := vsms.AddVirtualNetworkAdapterWithMac(vm, adapter.Name, adapter.MacAddress)
+		if err != nil {
+			return nil, fmt.Errorf("failed to add network adapter %s to VM %s: %w", adapter.Name, adapter.VMName, err)
+		}
+		vna.Close()
+	} else {
+		vna, err := vsms.AddVirtualNetworkAdapter(vm, adapter.Name)
+		if err != nil {
+			return nil, fmt.Errorf("failed to add network adapter %s to VM %s: %w", adapter.Name, adapter.VMName, err)
+		}
+		vna.Close()
+	}
+	if adapter.SwitchName != "" {
+		if err := b.ConnectNetworkAdapter(ctx, vm.Name(), adapter.Name, adapter.SwitchName); err != nil {
+			return nil, err
+		}
+	}
+	adapters, err := b.ListNetworkAdapters(ctx, vm.Name())
+	if err != nil {
+		return nil, err
+	}
+	for _, added := range adapters {
+		if strings.EqualFold(added.Name, adapter.Name) {
+			return &added, nil
+		}
+	}
+	return nil, fmt.Errorf("network adapter %s of VM %s not found after adding it", adapter.Name, adapter.VMName)
+}
+
+func (b *wmiBackend) ConnectNetworkAdapter(ctx context.Context, vmName string, adapterName string, switchName string) (err error) {
+	defer recoverWMI("ConnectNetworkAdapter", &err)
+	vsms := b.v.GetVirtualSystemManagementService()
+	vm, err := b.findVM(vmName)
+	if err != nil {
+		return err
+	}
+	defer vm.Close()
+	if switchName == "" {
+		return vsms.DisconnectAdapterFromVirtualSwitch(vm, adapterName)
+	}
+	service, err := netsvc.GetVirtualEthernetSwitchManagementService(b.v.GetVirtualizationConn().WMIHost)
+	if err != nil {
+		return fmt.Errorf("failed to get the VirtualEthernetSwitchManagementService: %w", err)
+	}
+	defer service.Close()
+	vswitch, err := service.FindVirtualSwitchByName(switchName)
+	if wmierrors.IsNotFound(err) {
+		return errs.Wrap(errs.ErrNotFound, "Get-VMSwitch", err)
+	}
+	if err != nil {
+		return err
+	}
+	defer vswitch.Close()
+	return vsms.ConnectAdapterToVirtualSwitch(vm, adapterName, vswitch)
+}
+
+// SetNetworkAdapter sets the MAC address on the adapter and the switch port features on the
+// Msvm_EthernetPortAllocationSettingData that connects it to a switch. An adapter that is not
+// connected has no port settings, so its features are set through PowerShell.
+func (b *wmiBackend) SetNetworkAdapter(ctx context.Context, vmName string, adapterName string, settings backend.NetworkAdapterSettings) error {
+	err := b.setNetworkAdapter(ctx, vmName, adapterName, settings)
+	if err == nil || errors.Is(err, errs.ErrNotFound) {
+		return err
+	}
+	if fallbackErr := b.v.fallback(fmt.Sprintf("configure network adapter %s of VM %s", adapterName, vmName), err); fallbackErr != nil {
+		return fallbackErr
+	}
+	return b.PowerShell.SetNetworkAdapter(ctx, vmName, adapterName, settings)
+}
+
+func (b *wmiBackend) setNetworkAdapter(ctx context.Context, vmName string, adapterName string, settings backend.NetworkAdapterSettings) (err error) {
+	defer recoverWMI("SetNetworkAdapter", &err)
+	vm, err := b.findVM(vmName)
+	if err != nil {
+		return err
+	}
+	defer vm.Close()
+	vna, err := vm.GetVirtualNetworkAdapterByName(adapterName)
+	if wmierrors.IsNotFound(err) {
+		return errs.Wrap(errs.ErrNotFound, "Get-VMNetworkAdapter", err)
+	}
+	if err != nil {
+		return err
+	}
+	defer vna.Close()
+
+	if settings.MacAddress != nil {
+		if err := b.v.GetVirtualSystemManagementService().SetVirtualNetworkAdapterMACAddress(vm, adapterName, *settings.MacAddress); err != nil {
+			return fmt.Errorf("failed to set the MAC address: %w", err)
+		}
+	}
+	if !settings.PortFeatures() {
+		return nil
+	}
+	port, err := vna.GetEthernetPortAllocationSettingData()
+	if err != nil {
+		return fmt.Errorf("the adapter is not connected to a switch: %w", err)
+	}
+	defer port.Close()
+	return allocation.ApplyPortSettings(ctx, b.v.FeatureSession(), port.InstancePath(), settings, allocation.Options{})
+}
+
+func (b *wmiBackend) RemoveNetworkAdapter(ctx context.Context, vmName string, adapterName string) (err error) {
+	defer recoverWMI("RemoveNetworkAdapter", &err)
+	vm, err := b.findVM(vmName)
+	if err != nil {
+		return err
+	}
+	defer vm.Close()
+	vna, err := vm.GetVirtualNetworkAdapterByName(adapterName)
+	if wmierrors.IsNotFound(err) {
+		return errs.Wrap(errs.ErrNotFound, "Get-VMNetworkAdapter", err)
+	}
+	if err != nil {
+		return err
+	}
+	defer vna.Close()
+	return b.v.GetVirtualSystemManagementService().RemoveVirtualNetworkAdapter(vna)
+}
@@ -0,0 +1,151 @@
+package vmms
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/microsoft/wmi/pkg/virtualization/core/storage/disk"
+	wmi "github.com/microsoft/wmi/pkg/wmiinstance"
+
+	"github.com/pulumi/pulumi-hyperv/provider/pkg/provider/vhd"
+)
+
+// CompactModeFull reclaims unused blocks and, for disks with an NTFS file system, zeroed
+// blocks. It requires the disk to be detached or attached read-only.
+const CompactModeFull = uint16(0)
+
+// virtualHardDiskTypeDifferencing is the Type of the Msvm_VirtualHardDiskSettingData of a
+// differencing disk.
+const virtualHardDiskTypeDifferencing = uint16(4)
+
+// CreateVirtualHardDisk creates the disk that opts describe at path. Sector sizes that are
+// not set default to 512 bytes.
+func (v *VMMS) CreateVirtualHardDisk(ctx context.Context, path string, opts vhd.CreateOptions) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("recovered from panic in CreateVirtualHardDisk: %v", r)
+		}
+	}()
+	conn := v.GetVirtualizationConn()
+	if conn == nil {
+		return fmt.Errorf("virtualization connection is unavailable")
+	}
+	diskType, err := vhd.NormalizeDiskType(opts.DiskType)
+	if err != nil {
+		return err
+	}
+	format := disk.VirtualHardDiskFormat(disk.VirtualHardDiskFormat_2)
+	if f, _ := vhd.FormatForPath(path); f == vhd.FormatVHD {
+		format = disk.VirtualHardDiskFormat_1
+	}
+
+	var setting *disk.VirtualHardDiskSettingData
+	if diskType == vhd.TypeDifferencing {
+		if opts.ParentPath == "" {
+			return fmt.Errorf("a parent path is required for a differencing disk")
+		}
+		if setting, err = disk.GetDefaultVirtualHardDiskSettingData(conn.WMIHost); err != nil {
+			return fmt.Errorf("failed to get disk settings: %w", err)
+		}
+		defer setting.Close()
+		// The size and sector sizes of a differencing disk are those of its parent.
+		if err := setting.SetPropertyPath(path); err != nil {
+			return err
+		}
+		if err := setting.SetPropertyFormat(uint16(format)); err != nil {
+			return err
+		}
+		if err := setting.SetPropertyType(virtualHardDiskTypeDifferencing); err != nil {
+			return err
+		}
+		if err := setting.SetPropertyParentPath(opts.ParentPath); err != nil {
+			return err
+		}
+	} else {
+		logical, physical := opts.LogicalSectorSize, opts.PhysicalSectorSize
+		if logical == 0 {
+			logical = 512
+		}
+		if physical == 0 {
+			physical = 512
+		}
+		setting, err = disk.GetVirtualHardDiskSettingData(conn.WMIHost, path, logical, physical,
+			opts.BlockSize, opts.VirtualSize, diskType == vhd.TypeDynamic, format)
+		if err != nil {
+			return fmt.Errorf("failed to get disk settings: %w", err)
+		}
+		defer setting.Close()
+	}
+	embedded, err := setting.EmbeddedXMLInstance()
+	if err != nil {
+		return fmt.Errorf("failed to encode disk settings: %w", err)
+	}
+	return v.invokeImageManagementMethod(ctx, "CreateVirtualHardDisk", wmi.WmiMethodParamCollection{
+		wmi.NewWmiMethodParam("VirtualDiskSettingData", embedded),
+	})
+}
+
+// ConvertVirtualHardDisk converts the disk at sourcePath into a new disk described by setting,
+// for example to switch between the fixed and dynamic types.
+func (v *VMMS) ConvertVirtualHardDisk(ctx context.Context, sourcePath string, setting *disk.VirtualHardDiskSettingData) error {
+	embedded, err := setting.EmbeddedXMLInstance()
+	if err != nil {
+		return fmt.Errorf("failed to encode disk settings: %w", err)
+	}
+	return v.invokeImageManagementMethod(ctx, "ConvertVirtualHardDisk", wmi.WmiMethodParamCollection{
+		wmi.NewWmiMethodParam("SourcePath", sourcePath),
+		wmi.NewWmiMethodParam("VirtualDiskSettingData", embedded),
+	})
+}
+
+// CompactVirtualHardDisk reduces the size of a dynamic or differencing disk file.
+func (v *VMMS) CompactVirtualHardDisk(ctx context.Context, path string, mode uint16) error {
+	return v.invokeImageManagementMethod(ctx, "CompactVirtualHardDisk", wmi.WmiMethodParamCollection{
+		wmi.NewWmiMethodParam("Path", path),
+		wmi.NewWmiMethodParam("Mode", mode),
+	})
+}
+
+// MergeVirtualHardDisk merges the differencing disk at sourcePath into destinationPath, which
+// must be its parent or another ancestor. The source disk is removed by the merge.
+func (v *VMMS) MergeVirtualHardDisk(ctx context.Context, sourcePath, destinationPath string) error {
+	return v.invokeImageManagementMethod(ctx, "MergeVirtualHardDisk", wmi.WmiMethodParamCollection{
+		wmi.NewWmiMethodParam("SourcePath", sourcePath),
+		wmi.NewWmiMethodParam("DestinationPath", destinationPath),
+	})
+}
+
+// invokeImageManagementMethod runs a method of Msvm_ImageManagementService and waits for the
+// job it starts to complete, without a timeout.
+func (v *VMMS) invokeImageManagementMethod(ctx context.Context, name string, inparams wmi.WmiMethodParamCollection) (err error) {
+	if v == nil {
+		return fmt.Errorf("VMMS object is nil")
+	}
+	ims := v.GetImageManagementService()
+	if ims == nil {
+		return fmt.Errorf("ImageManagementService is unavailable")
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("recovered from panic in %s: %v", name, r)
+		}
+	}()
+
+	method, err := ims.GetWmiMethod(name)
+	if err != nil {
+		return fmt.Errorf("failed to get %s method: %w", name, err)
+	}
+	defer method.Close()
+
+	outparams := wmi.WmiMethodParamCollection{wmi.NewWmiMethodParam("Job", nil)}
+	result, err := method.Execute(inparams, outparams)
+	if err != nil {
+		return fmt.Errorf("%s failed: %w", name, err)
+	}
+	path := ""
+	if val, ok := result.OutMethodParams["Job"]; ok && val.Value != nil {
+		path, _ = val.Value.(string)
+	}
+	return v.WaitForStorageJob(ctx, name, uint32(result.ReturnValue), path)
+}
@@ -0,0 +1,78 @@
+package vmms
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/pulumi/pulumi-hyperv/provider/pkg/provider/job"
+)
+
+// jobSource implements job.Source with the virtualization namespace.
+type jobSource struct {
+	v *VMMS
+}
+
+var _ job.Source = (*jobSource)(nil)
+
+// JobSource returns the WMI implementation of job.Source.
+func (v *VMMS) JobSource() job.Source {
+	return &jobSource{v: v}
+}
+
+// Job returns the state of a Msvm_ConcreteJob.
+func (s *jobSource) Job(path string) (state job.State, err error) {
+	conn := s.v.GetVirtualizationConn()
+	if conn == nil {
+		return state, fmt.Errorf("virtualization connection is unavailable")
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("recovered from panic in Job: %v", r)
+		}
+	}()
+
+	instance, err := conn.GetInstance(path)
+	if err != nil {
+		return state, err
+	}
+	defer instance.Close()
+	state.JobState = uint16Property(instance, "JobState")
+	state.PercentComplete = uint16Property(instance, "PercentComplete")
+	state.ErrorCode = uint16Property(instance, "ErrorCode")
+	if value, err := instance.GetProperty("ErrorDescription"); err == nil {
+		state.ErrorDescription, _ = value.(string)
+	}
+	return state, nil
+}
+
+// WaitForJob waits for the job at path that method started, if returnValue says it started
+// one, for at most job.DefaultTimeout. Failures match the error classes of the errs package
+// with errors.Is.
+func (v *VMMS) WaitForJob(ctx context.Context, method string, returnValue uint32, path string) error {
+	return job.Wait(ctx, v.JobSource(), method, returnValue, path, job.Options{})
+}
+
+// WaitForStorageJob waits like WaitForJob, but until the job is done or ctx is, since jobs
+// that create, convert, compact or merge disks take as long as the disks are large.
+func (v *VMMS) WaitForStorageJob(ctx context.Context, method string, returnValue uint32, path string) error {
+	return job.Wait(ctx, v.JobSource(), method, returnValue, path, job.Options{Timeout: job.NoTimeout})
+}
+
+// WaitForMethodResult waits for a method invoked through InvokeMethod, given the map of its
+// ReturnValue and output parameters.
+func (v *VMMS) WaitForMethodResult(ctx context.Context, method string, result map[string]interface{}) error {
+	var returnValue uint32
+	switch value := result["ReturnValue"].(type) {
+	case uint32:
+		returnValue = value
+	case int32:
+		returnValue = uint32(value)
+	case float64:
+		returnValue = uint32(value)
+	default:
+		return fmt.Errorf("unexpected ReturnValue type %T from %s", value, method)
+	}
+	path, _ := result["Job"].(string)
+	return v.WaitForJob(ctx, method, returnValue, path)
+}
@@ -0,0 +1,201 @@
+package vmms
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/microsoft/wmi/pkg/virtualization/core/resource"
+	"github.com/microsoft/wmi/pkg/virtualization/core/resource/resourcepool"
+	v2 "github.com/microsoft/wmi/server2019/root/virtualization/v2"
+
+	"github.com/pulumi/pulumi-hyperv/provider/pkg/provider/backend"
+	"github.com/pulumi/pulumi-hyperv/provider/pkg/provider/passthrough"
+)
+
+// passThroughSession implements passthrough.Session with the Virtual System Management
+// Service. Host disks are listed with the PowerShell backend, because MSFT_Disk lives in the
+// storage namespace.
+type passThroughSession struct {
+	ctx        context.Context
+	v          *VMMS
+	powershell *backend.PowerShell
+}
+
+var _ passthrough.Session = (*passThroughSession)(nil)
+
+func (v *VMMS) newPassThroughSession(ctx context.Context, powershell *backend.PowerShell) *passThroughSession {
+	return &passThroughSession{ctx: ctx, v: v, powershell: powershell}
+}
+
+// HostDisks returns the physical disks of the host.
+func (s *passThroughSession) HostDisks() ([]passthrough.Disk, error) {
+	return s.powershell.HostDisks(s.ctx)
+}
+
+// HostDiskDrive returns the instance path of the Msvm_DiskDrive of a physical disk.
+func (s *passThroughSession) HostDiskDrive(number uint32) (path string, err error) {
+	conn := s.v.GetVirtualizationConn()
+	if conn == nil {
+		return "", fmt.Errorf("virtualization connection is unavailable")
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("recovered from panic in HostDiskDrive: %v", r)
+		}
+	}()
+
+	drives, err := conn.QueryInstances(fmt.Sprintf("SELECT * FROM Msvm_DiskDrive WHERE DriveNumber = %d", number))
+	if err != nil {
+		return "", fmt.Errorf("failed to query Msvm_DiskDrive: %w", err)
+	}
+	defer closeAll(drives)
+	if len(drives) == 0 {
+		return "", fmt.Errorf("no Msvm_DiskDrive with DriveNumber %d, the disk may be online or in use by the host", number)
+	}
+	return drives[0].InstancePath(), nil
+}
+
+// Controllers returns the SCSI or IDE controllers of a virtual machine.
+func (s *passThroughSession) Controllers(vmName, controllerType string) (controllers []passthrough.Controller, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("recovered from panic in Controllers: %v", r)
+		}
+	}()
+
+	subType := scsiControllerSubType
+	if controllerType == passthrough.ControllerIDE {
+		subType = ideControllerSubType
+	}
+	settings, err := s.v.resourceSettings(vmName)
+	if err != nil {
+		return nil, err
+	}
+	defer settings.Close()
+
+	// The controllers are returned in the order of their settings, which is the order in
+	// which Hyper-V numbers them.
+	for _, rasd := range settings {
+		if rasdSubType(rasd) == subType {
+			controllers = append(controllers, passthrough.Controller{Path: rasd.InstancePath()})
+		}
+	}
+	return controllers, nil
+}
+
+// Drives returns the disk and DVD drives of a virtual machine.
+func (s *passThroughSession) Drives(vmName string) (drives []passthrough.Drive, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("recovered from panic in Drives: %v", r)
+		}
+	}()
+
+	settings, err := s.v.resourceSettings(vmName)
+	if err != nil {
+		return nil, err
+	}
+	defer settings.Close()
+
+	for _, rasd := range settings {
+		rtype := rasdType(rasd)
+		if rtype != passthrough.ResourceTypeDiskDrive && rtype != passthrough.ResourceTypeDVDDrive {
+			continue
+		}
+		drive := passthrough.Drive{Path: rasd.InstancePath(), ResourceType: rtype, ResourceSubType: rasdSubType(rasd)}
+		drive.Parent, _ = rasd.GetPropertyParent()
+		drive.AddressOnParent, _ = rasd.GetPropertyAddressOnParent()
+		drive.HostResource, _ = rasd.GetPropertyHostResource()
+		drives = append(drives, drive)
+	}
+	return drives, nil
+}
+
+// AddDrive adds a physical disk drive, created from the default settings of the primordial
+// pool, to a virtual machine.
+func (s *passThroughSession) AddDrive(vmName string, settings passthrough.DriveSettings) (err error) {
+	vsms := s.v.GetVirtualSystemManagementService()
+	if vsms == nil {
+		return fmt.Errorf("VirtualSystemManagementService is unavailable")
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("recovered from panic in AddDrive: %v", r)
+		}
+	}()
+
+	vm, err := s.v.virtualMachine(vmName)
+	if err != nil {
+		return err
+	}
+	defer vm.Close()
+	vmSettings, err := vm.GetVirtualSystemSettingData()
+	if err != nil {
+		return fmt.Errorf("failed to get settings of VM %s: %w", vmName, err)
+	}
+	defer vmSettings.Close()
+
+	pools, err := resourcepool.GetResourcePools(s.v.host, true, &resource.ResourceTypeValue{
+		ResourceType:    v2.ResourcePool_ResourceType(settings.ResourceType),
+		ResourceSubType: settings.ResourceSubType,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to find the resource pool for %s: %w", settings.ResourceSubType, err)
+	}
+	defer pools.Close()
+	pool, err := resourcepool.NewResourcePool(pools[0])
+	if err != nil {
+		return err
+	}
+	rasd, err := pool.GetDefaultResourceAllocationSettingData()
+	if err != nil {
+		return fmt.Errorf("failed to get the default settings for %s: %w", settings.ResourceSubType, err)
+	}
+	defer rasd.Close()
+
+	if err := rasd.SetPropertyParent(settings.Parent); err != nil {
+		return fmt.Errorf("failed to set Parent: %w", err)
+	}
+	if err := rasd.SetPropertyAddressOnParent(settings.AddressOnParent); err != nil {
+		return fmt.Errorf("failed to set AddressOnParent: %w", err)
+	}
+	if err := rasd.SetPropertyHostResource(settings.HostResource); err != nil {
+		return fmt.Errorf("failed to set HostResource: %w", err)
+	}
+
+	result, err := vsms.AddVirtualSystemResource(vmSettings, rasd.CIM_ResourceAllocationSettingData, -1)
+	if err != nil {
+		return err
+	}
+	result.Close()
+	return nil
+}
+
+// RemoveDrive removes the drive with the given instance path from a virtual machine.
+func (s *passThroughSession) RemoveDrive(vmName string, path string) (err error) {
+	vsms := s.v.GetVirtualSystemManagementService()
+	if vsms == nil {
+		return fmt.Errorf("VirtualSystemManagementService is unavailable")
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("recovered from panic in RemoveDrive: %v", r)
+		}
+	}()
+
+	settings, err := s.v.resourceSettings(vmName)
+	if err != nil {
+		return err
+	}
+	defer settings.Close()
+	for _, rasd := range settings {
+		if strings.EqualFold(rasd.InstancePath(), path) {
+			return vsms.RemoveVirtualSystemResource(rasd.CIM_ResourceAllocationSettingData, -1)
+		}
+	}
+	return fmt.Errorf("drive [%s] not found on VM %s", path, vmName)
+}
@@ -0,0 +1,640 @@
+package vmms
+
+import (
+	"context"
+
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/microsoft/wmi/pkg/virtualization/core/resource/resourceallocation"
+	vmmsvc "github.com/microsoft/wmi/pkg/virtualization/core/service"
+	"github.com/microsoft/wmi/pkg/virtualization/core/storage/disk"
+	"github.com/microsoft/wmi/pkg/virtualization/core/virtualsystem"
+	wmi "github.com/microsoft/wmi/pkg/wmiinstance"
+	v2 "github.com/microsoft/wmi/server2019/root/virtualization/v2"
+
+	"github.com/pulumi/pulumi-hyperv/provider/pkg/provider/allocation"
+	"github.com/pulumi/pulumi-hyperv/provider/pkg/provider/backend"
+	"github.com/pulumi/pulumi-hyperv/provider/pkg/provider/errs"
+	"github.com/pulumi/pulumi-hyperv/provider/pkg/provider/logging"
+	"github.com/pulumi/pulumi-hyperv/provider/pkg/provider/util"
+	"github.com/pulumi/pulumi-hyperv/provider/pkg/provider/vhd"
+)
+
+// ResourceTypeLogicalDisk is the ResourceType of Msvm_StorageAllocationSettingData instances
+// that attach a virtual hard disk file to a drive.
+const ResourceTypeLogicalDisk = 31
+
+// DiskUser describes a virtual machine, or a checkpoint of one, whose configuration uses a
+// virtual hard disk file.
+type DiskUser struct {
+	// Name is the name of the virtual machine or checkpoint.
+	Name string
+	// SettingsID is the InstanceID of its Msvm_VirtualSystemSettingData.
+	SettingsID string
+}
+
+// FindVirtualHardDiskUsers returns the virtual machines and checkpoints whose
+// Msvm_StorageAllocationSettingData points at the disk file at path.
+func (v *VMMS) FindVirtualHardDiskUsers(path string) (users []DiskUser, err error) {
+	conn := v.GetVirtualizationConn()
+	if conn == nil {
+		return nil, fmt.Errorf("virtualization connection is unavailable")
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("recovered from panic in FindVirtualHardDiskUsers: %v", r)
+		}
+	}()
+
+	// HostResource is an array, which WQL cannot filter on, so the paths are compared here.
+	settings, err := conn.QueryInstances(fmt.Sprintf("SELECT * FROM Msvm_StorageAllocationSettingData WHERE ResourceType = %d", ResourceTypeLogicalDisk))
+	if err != nil {
+		return nil, fmt.Errorf("failed to query storage allocation settings: %w", err)
+	}
+	defer closeAll(settings)
+
+	seen := map[string]bool{}
+	for _, setting := range settings {
+		hostResource, err := setting.GetProperty("HostResource")
+		if err != nil || !hostResourceContains(hostResource, path) {
+			continue
+		}
+		instanceID, _ := setting.GetProperty("InstanceID")
+		id, _ := instanceID.(string)
+		settingsID := systemSettingsID(id)
+		if seen[settingsID] {
+			continue
+		}
+		seen[settingsID] = true
+		users = append(users, DiskUser{Name: v.systemSettingsName(conn, settingsID), SettingsID: settingsID})
+	}
+	return users, nil
+}
+
+// hostResourceContains reports whether the HostResource property value names path.
+func hostResourceContains(value interface{}, path string) bool {
+	var resources []string
+	switch r := value.(type) {
+	case []string:
+		resources = r
+	case []interface{}:
+		for _, item := range r {
+			if s, ok := item.(string); ok {
+				resources = append(resources, s)
+			}
+		}
+	case string:
+		resources = []string{r}
+	}
+	for _, resource := range resources {
+		if vhd.SamePath(resource, path) {
+			return true
+		}
+	}
+	return false
+}
+
+// systemSettingsID returns the InstanceID of the Msvm_VirtualSystemSettingData that a
+// resource setting belongs to. Resource InstanceIDs have the form
+// "Microsoft:<settings GUID>\<resource path>".
+func systemSettingsID(resourceID string) string {
+	if i := strings.Index(resourceID, `\`); i >= 0 {
+		return resourceID[:i]
+	}
+	return resourceID
+}
+
+// systemSettingsName returns the ElementName of the virtual system settings with the given
+// InstanceID, which is the name of the VM or checkpoint. The ID is returned if the settings
+// cannot be found.
+func (v *VMMS) systemSettingsName(conn *wmi.WmiSession, settingsID string) string {
+	query := fmt.Sprintf("SELECT * FROM Msvm_VirtualSystemSettingData WHERE InstanceID = '%s'", strings.ReplaceAll(settingsID, `\`, `\\`))
+	systems, err := conn.QueryInstances(query)
+	if err != nil || len(systems) == 0 {
+		return settingsID
+	}
+	defer closeAll(systems)
+	name, err := systems[0].GetProperty("ElementName")
+	if s, ok := name.(string); err == nil && ok && s != "" {
+		return s
+	}
+	return settingsID
+}
+
+func closeAll(instances []*wmi.WmiInstance) {
+	for _, instance := range instances {
+		instance.Close()
+	}
+}
+
+// applyDiskDriveSettings sets the specified settings on a Msvm_StorageAllocationSettingData
+// instance.
+func applyDiskDriveSettings(s *backend.DiskDriveSettings, setting *disk.VirtualHardDisk) error {
+	if s.MinimumIops != nil {
+		if err := setting.SetPropertyIOPSReservation(*s.MinimumIops); err != nil {
+			return fmt.Errorf("failed to set IOPSReservation: %w", err)
+		}
+	}
+	if s.MaximumIops != nil {
+		if err := setting.SetPropertyIOPSLimit(*s.MaximumIops); err != nil {
+			return fmt.Errorf("failed to set IOPSLimit: %w", err)
+		}
+	}
+	if s.QosPolicyID != nil {
+		if err := setting.SetPropertyStorageQoSPolicyID(*s.QosPolicyID); err != nil {
+			return fmt.Errorf("failed to set StorageQoSPolicyID: %w", err)
+		}
+	}
+	if s.SupportPersistentReservations != nil {
+		if err := setting.SetPropertyPersistentReservationsSupported(*s.SupportPersistentReservations); err != nil {
+			return fmt.Errorf("failed to set PersistentReservationsSupported: %w", err)
+		}
+	}
+	if s.ReadOnly != nil {
+		access := v2.StorageAllocationSettingData_Access_Read_Write_Supported
+		if *s.ReadOnly {
+			access = v2.StorageAllocationSettingData_Access_Readable
+		}
+		if err := setting.SetPropertyAccess(access); err != nil {
+			return fmt.Errorf("failed to set Access: %w", err)
+		}
+	}
+	if s.CacheMode != nil {
+		method, err := backend.WriteHardeningMethod(*s.CacheMode)
+		if err != nil {
+			return err
+		}
+		if err := setting.SetPropertyWriteHardeningMethod(method); err != nil {
+			return fmt.Errorf("failed to set WriteHardeningMethod: %w", err)
+		}
+	}
+	return nil
+}
+
+// SetVirtualHardDiskSettings changes the settings of the disk at path attached to vm in
+// place. The VM can be running.
+func (v *VMMS) SetVirtualHardDiskSettings(vm *virtualsystem.VirtualMachine, path string, settings *backend.DiskDriveSettings) (err error) {
+	if settings.IsEmpty() {
+		return nil
+	}
+	if err := settings.Validate(); err != nil {
+		return err
+	}
+	vsms := v.GetVirtualSystemManagementService()
+	if vsms == nil {
+		return fmt.Errorf("VirtualSystemManagementService is unavailable")
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("recovered from panic in SetVirtualHardDiskSettings: %v", r)
+		}
+	}()
+
+	setting, err := vm.GetVirtualHardDiskByPath(path)
+	if err != nil {
+		return fmt.Errorf("failed to find disk [%s]: %w", path, err)
+	}
+	if setting == nil {
+		return fmt.Errorf("disk [%s] is not attached to the VM", path)
+	}
+	defer setting.Close()
+	if err := applyDiskDriveSettings(settings, setting); err != nil {
+		return err
+	}
+	if err := vsms.ModifyVirtualSystemResourceEx(setting.WmiInstance, -1); err != nil {
+		return fmt.Errorf("failed to modify settings of disk [%s]: %w", path, err)
+	}
+	return nil
+}
+
+// Resource subtypes of the controllers drives are attached to.
+const (
+	scsiControllerSubType = "Microsoft:Hyper-V:Synthetic SCSI Controller"
+	ideControllerSubType  = "Microsoft:Hyper-V:Emulated IDE Controller"
+)
+
+// resourceSettings returns all Msvm_ResourceAllocationSettingData of a virtual machine.
+// VirtualMachine.GetResourceAllocationSettingData only matches the default subtype of a
+// resource type, which leaves out physical disk drives.
+func (v *VMMS) resourceSettings(vmName string) (resourceallocation.ResourceAllocationSettingDataCollection, error) {
+	return v.relatedSettings(vmName, "Msvm_ResourceAllocationSettingData")
+}
+
+// relatedSettings returns the settings of the given class, such as
+// Msvm_StorageAllocationSettingData, of a virtual machine.
+func (v *VMMS) relatedSettings(vmName string, class string) (resourceallocation.ResourceAllocationSettingDataCollection, error) {
+	vm, err := v.virtualMachine(vmName)
+	if err != nil {
+		return nil, err
+	}
+	defer vm.Close()
+	vmSettings, err := vm.GetVirtualSystemSettingData()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get settings of VM %s: %w", vmName, err)
+	}
+	defer vmSettings.Close()
+	related, err := vmSettings.GetAllRelated(class)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get resource settings of VM %s: %w", vmName, err)
+	}
+	var col resourceallocation.ResourceAllocationSettingDataCollection
+	for _, instance := range related {
+		rasd, err := resourceallocation.NewResourceAllocationSettingData(instance)
+		if err != nil {
+			instance.Close()
+			continue
+		}
+		col = append(col, rasd)
+	}
+	return col, nil
+}
+
+// rasdType returns the ResourceType of a resource setting, or 0 if it cannot be read.
+func rasdType(rasd *resourceallocation.ResourceAllocationSettingData) uint16 {
+	value, err := rasd.GetProperty("ResourceType")
+	if err != nil {
+		return 0
+	}
+	switch t := value.(type) {
+	case uint16:
+		return t
+	case int32:
+		return uint16(t)
+	case int64:
+		return uint16(t)
+	case uint32:
+		return uint16(t)
+	}
+	return 0
+}
+
+func rasdSubType(rasd *resourceallocation.ResourceAllocationSettingData) string {
+	subType, _ := rasd.GetPropertyResourceSubType()
+	return subType
+}
+
+// virtualMachine returns the virtual machine with the given name.
+func (v *VMMS) virtualMachine(vmName string) (*virtualsystem.VirtualMachine, error) {
+	vsms := v.GetVirtualSystemManagementService()
+	if vsms == nil {
+		return nil, fmt.Errorf("VirtualSystemManagementService is unavailable")
+	}
+	vm, err := vsms.GetVirtualMachineByName(vmName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get VM %s: %w", vmName, err)
+	}
+	return vm, nil
+}
+
+// DiskSlot is the controller slot a drive is attached to.
+type DiskSlot struct {
+	// ControllerType is SCSI or IDE.
+	ControllerType     string
+	ControllerNumber   int
+	ControllerLocation int
+}
+
+// VirtualHardDiskSlot returns the slot of the drive that the disk at path is attached to on
+// vmName, or nil if the disk is not attached. Get-VMHardDiskDrive is used when WMI is
+// unavailable.
+func (v *VMMS) VirtualHardDiskSlot(ctx context.Context, vmName string, path string) (*DiskSlot, error) {
+	slot, err := v.virtualHardDiskSlotWMI(vmName, path)
+	if err == nil {
+		return slot, nil
+	}
+	if err := v.fallback(fmt.Sprintf("find the slot of disk [%s]", path), err); err != nil {
+		return nil, err
+	}
+	return VirtualHardDiskSlotPowerShell(ctx, vmName, path)
+}
+
+func (v *VMMS) virtualHardDiskSlotWMI(vmName string, path string) (slot *DiskSlot, err error) {
+	if v == nil || v.GetVirtualSystemManagementService() == nil {
+		return nil, fmt.Errorf("VirtualSystemManagementService is unavailable")
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("recovered from panic in VirtualHardDiskSlot: %v", r)
+		}
+	}()
+
+	disks, err := v.relatedSettings(vmName, "Msvm_StorageAllocationSettingData")
+	if err != nil {
+		return nil, err
+	}
+	defer disks.Close()
+	drivePath := ""
+	for _, d := range disks {
+		if rasdType(d) != ResourceTypeLogicalDisk {
+			continue
+		}
+		hostResource, _ := d.GetPropertyHostResource()
+		if hostResourceContains(hostResource, path) {
+			drivePath, _ = d.GetPropertyParent()
+			break
+		}
+	}
+	if drivePath == "" {
+		return nil, nil
+	}
+
+	settings, err := v.resourceSettings(vmName)
+	if err != nil {
+		return nil, err
+	}
+	defer settings.Close()
+	controllerPath, location := "", ""
+	for _, rasd := range settings {
+		if strings.EqualFold(rasd.InstancePath(), drivePath) {
+			controllerPath, _ = rasd.GetPropertyParent()
+			location, _ = rasd.GetPropertyAddressOnParent()
+			break
+		}
+	}
+	if controllerPath == "" {
+		return nil, fmt.Errorf("drive of disk [%s] not found", path)
+	}
+	controllerLocation, err := strconv.Atoi(location)
+	if err != nil {
+		return nil, fmt.Errorf("drive of disk [%s] has an invalid AddressOnParent [%s]", path, location)
+	}
+
+	// Controllers are numbered per type in the order of their settings.
+	counts := map[string]int{}
+	for _, rasd := range settings {
+		controllerType := ""
+		switch rasdSubType(rasd) {
+		case scsiControllerSubType:
+			controllerType = "SCSI"
+		case ideControllerSubType:
+			controllerType = "IDE"
+		default:
+			continue
+		}
+		if strings.EqualFold(rasd.InstancePath(), controllerPath) {
+			return &DiskSlot{ControllerType: controllerType, ControllerNumber: counts[controllerType], ControllerLocation: controllerLocation}, nil
+		}
+		counts[controllerType]++
+	}
+	return nil, fmt.Errorf("controller of disk [%s] not found", path)
+}
+
+// VirtualHardDiskSlotPowerShell returns the slot of the disk at path on vmName using
+// Get-VMHardDiskDrive, or nil if the disk is not attached.
+func VirtualHardDiskSlotPowerShell(ctx context.Context, vmName string, path string) (*DiskSlot, error) {
+	cmd := fmt.Sprintf(`$path = %s
+ConvertTo-Json -Compress -InputObject @(Get-VMHardDiskDrive -VMName %s | Where-Object { $_.Path -eq $path } | ForEach-Object {
+	[pscustomobject]@{ ControllerType = [string]$_.ControllerType; ControllerNumber = $_.ControllerNumber; ControllerLocation = $_.ControllerLocation }
+})`, backend.Quote(path), backend.Quote(vmName))
+	output, err := util.RunIdempotentPowerShellCommand(ctx, cmd)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get the hard disk drives of VM %s: %w", vmName, err)
+	}
+	var slots []DiskSlot
+	if trimmed := strings.TrimSpace(output); trimmed != "" {
+		if err := json.Unmarshal([]byte(trimmed), &slots); err != nil {
+			return nil, fmt.Errorf("failed to parse the hard disk drives of VM %s: %v", vmName, err)
+		}
+	}
+	if len(slots) == 0 {
+		return nil, nil
+	}
+	return &slots[0], nil
+}
+
+// DetachVirtualHardDisk removes the disk at path and its drive from vm. Disks that are not
+// attached are ignored. Remove-VMHardDiskDrive is used when WMI fails.
+func (v *VMMS) DetachVirtualHardDisk(ctx context.Context, vm *virtualsystem.VirtualMachine, path string, logger logging.Logger) error {
+	if v == nil {
+		return fmt.Errorf("VMMS object is nil")
+	}
+	if vm == nil {
+		return fmt.Errorf("virtual machine is nil")
+	}
+	vmName, err := vm.GetPropertyElementName()
+	if err != nil {
+		return fmt.Errorf("failed to get VM name: %w", err)
+	}
+
+	vsms := v.GetVirtualSystemManagementService()
+	if vsms != nil {
+		err = func() (err error) {
+			defer func() {
+				if r := recover(); r != nil {
+					err = fmt.Errorf("recovered from panic in DetachVirtualHardDisk: %v", r)
+				}
+			}()
+			attached, err := vm.GetVirtualHardDiskByPath(path)
+			if err != nil {
+				return err
+			}
+			if attached == nil {
+				return nil
+			}
+			defer attached.Close()
+			return vsms.DetachVirtualHardDisk(attached)
+		}()
+		if err == nil {
+			logger.Infof("[INFO] Successfully detached VHD [%s] from VM [%s] using WMI", path, vmName)
+			return nil
+		}
+		if err := v.fallback(fmt.Sprintf("detach VHD [%s] from VM [%s]", path, vmName), err); err != nil {
+			return err
+		}
+	}
+
+	return DetachVirtualHardDiskPowerShell(ctx, vmName, path)
+}
+
+// DetachVirtualHardDiskPowerShell removes the drive of the disk at path from vmName with
+// Remove-VMHardDiskDrive.
+func DetachVirtualHardDiskPowerShell(ctx context.Context, vmName string, path string) error {
+	cmd := fmt.Sprintf("$path = %s\nGet-VMHardDiskDrive -VMName %s | Where-Object { $_.Path -eq $path } | Remove-VMHardDiskDrive",
+		backend.Quote(path), backend.Quote(vmName))
+	if _, err := util.RunPowerShellCommand(ctx, cmd); err != nil {
+		return fmt.Errorf("failed to detach VHD [%s] from VM [%s]: %w", path, vmName, err)
+	}
+	return nil
+}
+
+// VirtualMachineNameByID returns the name of the virtual machine with the given ID, the
+// GUID that Get-VM reports as VMId.
+func (v *VMMS) VirtualMachineNameByID(id string) (name string, err error) {
+	conn := v.GetVirtualizationConn()
+	if conn == nil {
+		return "", fmt.Errorf("virtualization connection is unavailable")
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("recovered from panic in VirtualMachineNameByID: %v", r)
+		}
+	}()
+
+	systems, err := conn.QueryInstances(fmt.Sprintf("SELECT * FROM Msvm_ComputerSystem WHERE Name = '%s'", strings.ReplaceAll(id, "'", "")))
+	if err != nil {
+		return "", fmt.Errorf("failed to query Msvm_ComputerSystem: %w", err)
+	}
+	defer closeAll(systems)
+	if len(systems) == 0 {
+		return "", fmt.Errorf("no virtual machine with ID %s", id)
+	}
+	value, err := systems[0].GetProperty("ElementName")
+	if err != nil {
+		return "", err
+	}
+	name, _ = value.(string)
+	return name, nil
+}
+
+// SCSIControllerCount returns the number of SCSI controllers of vmName.
+func (v *VMMS) SCSIControllerCount(vmName string) (count int, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("recovered from panic in SCSIControllerCount: %v", r)
+		}
+	}()
+
+	settings, err := v.resourceSettings(vmName)
+	if err != nil {
+		return 0, err
+	}
+	defer settings.Close()
+	for _, rasd := range settings {
+		if rasdSubType(rasd) == scsiControllerSubType {
+			count++
+		}
+	}
+	return count, nil
+}
+
+// SetSCSIControllerCount adds or removes SCSI controllers of vm until it has count of them.
+// Controllers are added from the default settings of the primordial pool and removed from
+// the end, and a controller that still has drives attached is not removed. The VM must be
+// off.
+func (v *VMMS) SetSCSIControllerCount(ctx context.Context, vm *virtualsystem.VirtualMachine, count int) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("recovered from panic in SetSCSIControllerCount: %v", r)
+		}
+	}()
+
+	vmName, err := vm.GetPropertyElementName()
+	if err != nil {
+		return fmt.Errorf("failed to get VM name: %w", err)
+	}
+	settings, err := v.resourceSettings(vmName)
+	if err != nil {
+		return err
+	}
+	defer settings.Close()
+	var controllers resourceallocation.ResourceAllocationSettingDataCollection
+	for _, rasd := range settings {
+		if rasdSubType(rasd) == scsiControllerSubType {
+			controllers = append(controllers, rasd)
+		}
+	}
+
+	session := v.AllocationSession()
+	if len(controllers) < count {
+		vmSettings, err := vm.GetVirtualSystemSettingData()
+		if err != nil {
+			return fmt.Errorf("failed to get VM settings: %w", err)
+		}
+		defer vmSettings.Close()
+		var added []*allocation.Settings
+		for i := len(controllers); i < count; i++ {
+			controller, err := allocation.DefaultSettings(session, uint16(v2.ResourcePool_ResourceType_Parallel_SCSI_HBA), scsiControllerSubType)
+			if err != nil {
+				return err
+			}
+			added = append(added, controller)
+		}
+		return errs.Retry(ctx, func() error {
+			_, err := allocation.Add(ctx, session, vmSettings.InstancePath(), added, allocation.Options{})
+			return err
+		})
+	}
+
+	var removed []string
+	for i := len(controllers) - 1; i >= count; i-- {
+		path := controllers[i].InstancePath()
+		for _, rasd := range settings {
+			if parent, _ := rasd.GetPropertyParent(); strings.EqualFold(parent, path) {
+				return fmt.Errorf("cannot remove SCSI controller %d while drives are attached to it", i)
+			}
+		}
+		removed = append(removed, path)
+	}
+	if len(removed) == 0 {
+		return nil
+	}
+	return errs.Retry(ctx, func() error {
+		return allocation.Remove(ctx, session, removed, allocation.Options{})
+	})
+}
+
+// attachVirtualHardDiskToSlot attaches the disk at path to a new drive on the given SCSI
+// controller. It follows VirtualSystemManagementService.AttachVirtualHardDisk, which always
+// uses the first free location of the first controller. A negative location selects the
+// first free location of the controller.
+func attachVirtualHardDiskToSlot(vsms *vmmsvc.VirtualSystemManagementService, vm *virtualsystem.VirtualMachine, path string, controllerNumber int, controllerLocation int) (attached *disk.VirtualHardDisk, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("recovered from panic in attachVirtualHardDiskToSlot: %v", r)
+		}
+	}()
+
+	if controllerLocation < 0 {
+		controllerLocation = -1
+	}
+	drive, err := vsms.AddSyntheticDiskDrive(vm, int32(controllerNumber), int32(controllerLocation), virtualsystem.VirtualHardDiskType_DATADISK_VIRTUALHARDDISK)
+	if err != nil {
+		return nil, fmt.Errorf("failed to add a drive on SCSI controller %d: %w", controllerNumber, err)
+	}
+	defer func() {
+		if err != nil {
+			_ = vsms.RemoveSyntheticDiskDrive(drive)
+		}
+		drive.Close()
+	}()
+
+	setting, err := vm.NewVirtualHardDisk(path)
+	if err != nil {
+		return nil, err
+	}
+	defer setting.Close()
+	if err = setting.SetPropertyParent(drive.InstancePath()); err != nil {
+		return nil, err
+	}
+
+	vmSettings, err := vm.GetVirtualSystemSettingData()
+	if err != nil {
+		return nil, err
+	}
+	defer vmSettings.Close()
+	result, err := vsms.AddVirtualSystemResource(vmSettings, setting.CIM_ResourceAllocationSettingData, -1)
+	if err != nil {
+		return nil, err
+	}
+	defer result.Close()
+	if len(result) == 0 {
+		return vm.GetVirtualHardDiskByPath(path)
+	}
+	instance, err := result[0].Clone()
+	if err != nil {
+		return nil, err
+	}
+	attached, err = disk.NewVirtualHardDisk(instance)
+	if err != nil {
+		instance.Close()
+		return nil, err
+	}
+	return attached, nil
+}
@@ -3,6 +3,7 @@ package vmms
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
 	"strings"
@@ -14,6 +15,8 @@ import (
 	"github.com/microsoft/wmi/pkg/virtualization/core/virtualsystem"
 	"github.com/microsoft/wmi/pkg/virtualization/network/virtualswitch"
 	wmi "github.com/microsoft/wmi/pkg/wmiinstance" // Updated import path
+	"github.com/pulumi/pulumi-hyperv/provider/pkg/provider/backend"
+	"github.com/pulumi/pulumi-hyperv/provider/pkg/provider/job"
 	"github.com/pulumi/pulumi-hyperv/provider/pkg/provider/logging"
 	"github.com/pulumi/pulumi-hyperv/provider/pkg/provider/util"
 )
@@ -27,6 +30,18 @@ type VMMS struct {
 	imageManagementSvc  *imsvc.ImageManagementService
 	vmManagementService *vmmsvc.VirtualSystemManagementService
 	logger              logging.Logger
+	// selection decides whether operations that fail using WMI fall back to PowerShell.
+	selection backend.Selection
+}
+
+// Local reports whether the service runs on the machine of the provider, whose file system is
+// then that of the host.
+func (v *VMMS) Local() bool {
+	switch strings.ToLower(v.host.HostName) {
+	case "", ".", "localhost":
+		return true
+	}
+	return false
 }
 
 // NewVMMS creates a new VMMS instance.
@@ -308,6 +323,26 @@ func (v *VMMS) GetImageManagementService() *imsvc.ImageManagementService {
 }
 
 // GetVirtualSystemManagementService returns the virtual machine management service.
+// errVSMSUnavailable is the WMI failure of operations on a host whose Virtual System
+// Management Service could not be reached.
+var errVSMSUnavailable = errors.New("the Virtual System Management Service is unavailable")
+
+// SetSelection sets how the operations of v choose between WMI and PowerShell. Without it,
+// operations that fail using WMI fall back to PowerShell.
+func (v *VMMS) SetSelection(selection backend.Selection) {
+	v.selection = selection
+}
+
+// fallback returns nil when op, which failed with err using WMI, may be repeated with
+// PowerShell, and logs that it is.
+func (v *VMMS) fallback(op string, err error) error {
+	if fallbackErr := v.selection.Fallback(op, err); fallbackErr != nil {
+		return fallbackErr
+	}
+	v.logger.Warnf("Failed to %s using WMI: %v, falling back to PowerShell", op, err)
+	return nil
+}
+
 func (v *VMMS) GetVirtualSystemManagementService() *vmmsvc.VirtualSystemManagementService {
 	// Add nil check to prevent panics when the service couldn't be initialized
 	if v == nil {
@@ -317,50 +352,13 @@ func (v *VMMS) GetVirtualSystemManagementService() *vmmsvc.VirtualSystemManageme
 	return v.vmManagementService
 }
 
-// ErrorCodeMeaning returns a string description for a WMI error code.
-func ErrorCodeMeaning(returnValue uint32) string {
-	switch returnValue {
-	case 0:
-		return "Completed with No Error."
-	case 1:
-		return "Not Supported."
-	case 2:
-		return "Failed."
-	case 3:
-		return "Timeout."
-	case 4:
-		return "Invalid Parameter."
-	case 5:
-		return "Invalid State."
-	case 6:
-		return "Invalid Type."
-	case 4096:
-		return "Method Parameters Checked - Job Started."
-	case 32768:
-		return "Failed."
-	case 32769:
-		return "Access Denied."
-	case 32770:
-		return "Not Supported."
-	case 32771:
-		return "Status is Unknown."
-	case 32772:
-		return "Timeout."
-	case 32773:
-		return "Invalid Parameter."
-	case 32774:
-		return "System is In Use."
-	case 32775:
-		return "Invalid State for this Operation."
-	case 32776:
-		return "Incorrect Data Type."
-	case 32777:
-		return "System is Not Available."
-	case 32778:
-		return "Out of Memory."
-	default:
-		return "The Method Failed. The Reason is Unknown."
+// ErrorCodeError returns the error for a WMI error code that method returned, or nil if it
+// completed. The error matches the classes of the errs package with errors.Is.
+func ErrorCodeError(method string, returnValue uint32) error {
+	if returnValue == job.ReturnCompleted {
+		return nil
 	}
+	return &job.Error{Method: method, Code: returnValue}
 }
 
 // RequestedState represents the state to request for a virtual machine.
@@ -423,7 +421,10 @@ const (
 	RequestedStateFastSavingCritical RequestedState = 32792
 )
 
-func (v *VMMS) AttachVirtualHardDisk(vm *virtualsystem.VirtualMachine, hdPath string, controllerType string, controllerNumber int, controllerLocation int, logger logging.Logger) error {
+// AttachVirtualHardDisk attaches the disk at hdPath to vm and applies the optional settings,
+// which may be nil, to its Msvm_StorageAllocationSettingData. A negative controllerLocation
+// selects the first free location on the controller.
+func (v *VMMS) AttachVirtualHardDisk(ctx context.Context, vm *virtualsystem.VirtualMachine, hdPath string, controllerType string, controllerNumber int, controllerLocation int, settings *backend.DiskDriveSettings, logger logging.Logger) error {
 	if v == nil {
 		return fmt.Errorf("VMMS object is nil")
 	}
@@ -432,62 +433,101 @@ func (v *VMMS) AttachVirtualHardDisk(vm *virtualsystem.VirtualMachine, hdPath st
 		return fmt.Errorf("virtual machine is nil")
 	}
 
+	if err := settings.Validate(); err != nil {
+		return err
+	}
+
 	vsms := v.GetVirtualSystemManagementService()
 	if vsms == nil {
-		logger.Warnf("VirtualSystemManagementService is unavailable, falling back to PowerShell")
-		return attachVirtualHardDiskPowerShell(vm, hdPath, controllerType, controllerNumber, controllerLocation, logger)
+		if err := v.fallback(fmt.Sprintf("attach VHD [%s]", hdPath), errVSMSUnavailable); err != nil {
+			return err
+		}
+		return attachVirtualHardDiskPowerShell(ctx, vm, hdPath, controllerType, controllerNumber, controllerLocation, settings, logger)
 	}
 
-	// Determine disk type based on controller type
-	var diskType virtualsystem.VirtualHardDiskType
-	switch strings.ToUpper(controllerType) {
-	case "IDE":
-		diskType = virtualsystem.VirtualHardDiskType_DATADISK_VIRTUALHARDDISK
-	case "SCSI":
-		diskType = virtualsystem.VirtualHardDiskType_DATADISK_VIRTUALHARDDISK
-	default:
-		logger.Warnf("Unknown controller type '%s', defaulting to SCSI", controllerType)
-		diskType = virtualsystem.VirtualHardDiskType_DATADISK_VIRTUALHARDDISK
+	// The WMI path only handles SCSI controllers.
+	if !strings.EqualFold(controllerType, "SCSI") {
+		return attachVirtualHardDiskPowerShell(ctx, vm, hdPath, controllerType, controllerNumber, controllerLocation, settings, logger)
 	}
 
-	// Attempt to attach using WMI API
-	err := vsms.AddSCSIController(vm)
+	// Make sure the requested controller exists. A VM without SCSI controllers gets one, as
+	// New-VM does for generation 2 VMs.
+	vmName, err := vm.GetPropertyElementName()
 	if err != nil {
-		logger.Warnf("Failed to add SCSI controller: %v", err)
-		return attachVirtualHardDiskPowerShell(vm, hdPath, controllerType, controllerNumber, controllerLocation, logger)
+		return fmt.Errorf("failed to get VM name: %w", err)
 	}
-	_, _, err = vsms.AttachVirtualHardDisk(vm, hdPath, diskType)
+	count, err := v.SCSIControllerCount(vmName)
+	if err != nil {
+		if err := v.fallback("count SCSI controllers", err); err != nil {
+			return err
+		}
+		return attachVirtualHardDiskPowerShell(ctx, vm, hdPath, controllerType, controllerNumber, controllerLocation, settings, logger)
+	}
+	if count == 0 {
+		if err := vsms.AddSCSIController(vm); err != nil {
+			if err := v.fallback("add SCSI controller", err); err != nil {
+				return err
+			}
+			return attachVirtualHardDiskPowerShell(ctx, vm, hdPath, controllerType, controllerNumber, controllerLocation, settings, logger)
+		}
+		count = 1
+	}
+	if controllerNumber >= count {
+		return fmt.Errorf("cannot attach VHD [%s]: VM %s has %d SCSI controllers, controller %d does not exist. Raise scsiControllerCount to add controllers", hdPath, vmName, count, controllerNumber)
+	}
+
+	// Attempt to attach using WMI API
+	attached, err := attachVirtualHardDiskToSlot(vsms, vm, hdPath, controllerNumber, controllerLocation)
 	if err == nil {
+		defer attached.Close()
 		logger.Infof("[INFO] Successfully attached VHD [%s] using WMI", hdPath)
+		if settings.IsEmpty() {
+			return nil
+		}
+		if err := applyDiskDriveSettings(settings, attached); err != nil {
+			return err
+		}
+		if err := vsms.ModifyVirtualSystemResourceEx(attached.WmiInstance, -1); err != nil {
+			return fmt.Errorf("attached VHD [%s] but failed to apply its settings: %w", hdPath, err)
+		}
 		return nil
 	}
 
 	logger.Warnf("Failed to attach VHD [%s] using WMI: %v, trying direct API", hdPath, err)
 
 	// Attempt direct API call
-	err = v.AttachVirtualHardDiskDirectApi(vm, hdPath, controllerNumber, controllerLocation, logger)
+	err = v.AttachVirtualHardDiskDirectApi(ctx, vm, hdPath, controllerNumber, controllerLocation, settings, logger)
 	if err == nil {
 		logger.Infof("[INFO] Successfully attached VHD [%s] using direct API", hdPath)
 		return nil
 	}
 
-	logger.Warnf("Failed to attach VHD [%s] using direct API: %v, falling back to PowerShell", hdPath, err)
-
-	// Fallback to PowerShell
-	return attachVirtualHardDiskPowerShell(vm, hdPath, controllerType, controllerNumber, controllerLocation, logger)
+	if err := v.fallback(fmt.Sprintf("attach VHD [%s] using direct API", hdPath), err); err != nil {
+		return err
+	}
+	return attachVirtualHardDiskPowerShell(ctx, vm, hdPath, controllerType, controllerNumber, controllerLocation, settings, logger)
 }
 
 // attachVirtualHardDiskPowerShell attaches a VHD using PowerShell as a fallback.
-func attachVirtualHardDiskPowerShell(vm *virtualsystem.VirtualMachine, hdPath string, controllerType string, controllerNumber int, controllerLocation int, logger logging.Logger) error {
+func attachVirtualHardDiskPowerShell(ctx context.Context, vm *virtualsystem.VirtualMachine, hdPath string, controllerType string, controllerNumber int, controllerLocation int, settings *backend.DiskDriveSettings, logger logging.Logger) error {
 	vmName, err := vm.GetPropertyElementName()
 	if err != nil {
 		return fmt.Errorf("failed to get VM name: %w", err)
 	}
 
-	cmd := fmt.Sprintf("Add-VMHardDiskDrive -VMName \"%s\" -Path \"%s\" -ControllerType %s -ControllerNumber %d -ControllerLocation %d",
-		vmName, hdPath, controllerType, controllerNumber, controllerLocation)
+	cmd := fmt.Sprintf("Add-VMHardDiskDrive -VMName %s -Path %s -ControllerType %s -ControllerNumber %d",
+		backend.Quote(vmName), backend.Quote(hdPath), controllerType, controllerNumber)
+	if controllerLocation >= 0 {
+		cmd += fmt.Sprintf(" -ControllerLocation %d", controllerLocation)
+	}
+	if args := settings.PowerShellArgs(); args != "" {
+		cmd += " " + args
+	}
+	if settings != nil && settings.ReadOnly != nil && *settings.ReadOnly {
+		return backend.ReadOnlyUnsupported("Add-VMHardDiskDrive", hdPath)
+	}
 
-	output, err := util.RunPowerShellCommand(cmd)
+	output, err := util.RunPowerShellCommand(ctx, cmd)
 	if err != nil {
 		outputStr := string(output)
 
@@ -525,7 +565,7 @@ func attachVirtualHardDiskPowerShell(vm *virtualsystem.VirtualMachine, hdPath st
 	return nil
 }
 
-func (v *VMMS) AttachVirtualHardDiskDirectApi(vm *virtualsystem.VirtualMachine, path string, controllerNumber int, controllerLocation int, logger logging.Logger) error {
+func (v *VMMS) AttachVirtualHardDiskDirectApi(ctx context.Context, vm *virtualsystem.VirtualMachine, path string, controllerNumber int, controllerLocation int, settings *backend.DiskDriveSettings, logger logging.Logger) error {
 	if v == nil {
 		return fmt.Errorf("VMMS object is nil")
 	}
@@ -545,16 +585,41 @@ func (v *VMMS) AttachVirtualHardDiskDirectApi(vm *virtualsystem.VirtualMachine,
 	}
 
 	// Create resource settings for the hard drive
-	resourceSettings := []interface{}{
-		map[string]interface{}{
-			"ResourceType":       uint16(31), // 31 = Disk drive
-			"ResourceSubType":    "Microsoft:Hyper-V:Virtual Hard Disk",
-			"Path":               path,
-			"ControllerType":     "Microsoft:Hyper-V:Synthetic SCSI Controller",
-			"ControllerNumber":   uint32(controllerNumber),
-			"ControllerLocation": uint32(controllerLocation),
-		},
+	diskSettings := map[string]interface{}{
+		"ResourceType":     uint16(31), // 31 = Disk drive
+		"ResourceSubType":  "Microsoft:Hyper-V:Virtual Hard Disk",
+		"Path":             path,
+		"ControllerType":   "Microsoft:Hyper-V:Synthetic SCSI Controller",
+		"ControllerNumber": uint32(controllerNumber),
+	}
+	if controllerLocation >= 0 {
+		diskSettings["ControllerLocation"] = uint32(controllerLocation)
+	}
+	if settings != nil {
+		if settings.MinimumIops != nil {
+			diskSettings["IOPSReservation"] = *settings.MinimumIops
+		}
+		if settings.MaximumIops != nil {
+			diskSettings["IOPSLimit"] = *settings.MaximumIops
+		}
+		if settings.QosPolicyID != nil {
+			diskSettings["StorageQoSPolicyID"] = *settings.QosPolicyID
+		}
+		if settings.SupportPersistentReservations != nil {
+			diskSettings["PersistentReservationsSupported"] = *settings.SupportPersistentReservations
+		}
+		if settings.ReadOnly != nil && *settings.ReadOnly {
+			diskSettings["Access"] = uint16(1) // 1 = Readable
+		}
+		if settings.CacheMode != nil {
+			method, err := backend.WriteHardeningMethod(*settings.CacheMode)
+			if err != nil {
+				return err
+			}
+			diskSettings["WriteHardeningMethod"] = method
+		}
 	}
+	resourceSettings := []interface{}{diskSettings}
 
 	// Get the VM path or system name to use in AddResourceSettings
 	systemName := fmt.Sprintf("\\\\%s\\root\\virtualization\\v2:Msvm_ComputerSystem.CreationClassName=\"Msvm_ComputerSystem\",Name=\"%s\"",
@@ -577,21 +642,16 @@ func (v *VMMS) AttachVirtualHardDiskDirectApi(vm *virtualsystem.VirtualMachine,
 		return fmt.Errorf("unexpected result type from AddResourceSettings")
 	}
 
-	// Check return value
-	returnValue, ok := resultMap["ReturnValue"].(uint32)
-	if !ok {
-		return fmt.Errorf("unexpected ReturnValue type from AddResourceSettings")
-	}
-
-	if returnValue != 0 {
-		return fmt.Errorf("AddResourceSettings failed with error: %s", ErrorCodeMeaning(returnValue))
+	// Wait for the job the method may have started
+	if err := v.WaitForMethodResult(ctx, "AddResourceSettings", resultMap); err != nil {
+		return err
 	}
 
 	logger.Infof("[INFO] Successfully attached virtual hard disk %s to VM %s", path, vmName)
 	return nil
 }
 
-func (v *VMMS) AddVirtualNetworkAdapterAndConnect(vm *virtualsystem.VirtualMachine, adapterName string, switchName string, logger logging.Logger) error {
+func (v *VMMS) AddVirtualNetworkAdapterAndConnect(ctx context.Context, vm *virtualsystem.VirtualMachine, adapterName string, switchName string, logger logging.Logger) error {
 	if v == nil {
 		return fmt.Errorf("VMMS object is nil")
 	}
@@ -602,8 +662,10 @@ func (v *VMMS) AddVirtualNetworkAdapterAndConnect(vm *virtualsystem.VirtualMachi
 
 	vsms := v.GetVirtualSystemManagementService()
 	if vsms == nil {
-		logger.Warnf("VirtualSystemManagementService is unavailable, falling back to PowerShell")
-		return addVirtualNetworkAdapterPowerShell(vm, adapterName, switchName, logger)
+		if err := v.fallback(fmt.Sprintf("add network adapter [%s]", adapterName), errVSMSUnavailable); err != nil {
+			return err
+		}
+		return addVirtualNetworkAdapterPowerShell(ctx, vm, adapterName, switchName, logger)
 	}
 
 	// Attempt to add adapter using WMI API
@@ -642,21 +704,21 @@ func (v *VMMS) AddVirtualNetworkAdapterAndConnect(vm *virtualsystem.VirtualMachi
 		return nil
 	}
 
-	logger.Warnf("Failed to add/connect network adapter [%s] using WMI: %v, falling back to PowerShell", adapterName, addErr)
-
-	// Fallback to PowerShell
-	return addVirtualNetworkAdapterPowerShell(vm, adapterName, switchName, logger)
+	if err := v.fallback(fmt.Sprintf("add/connect network adapter [%s]", adapterName), addErr); err != nil {
+		return err
+	}
+	return addVirtualNetworkAdapterPowerShell(ctx, vm, adapterName, switchName, logger)
 }
 
 // addVirtualNetworkAdapterPowerShell adds and connects a network adapter using PowerShell as a fallback.
-func addVirtualNetworkAdapterPowerShell(vm *virtualsystem.VirtualMachine, adapterName string, switchName string, logger logging.Logger) error {
+func addVirtualNetworkAdapterPowerShell(ctx context.Context, vm *virtualsystem.VirtualMachine, adapterName string, switchName string, logger logging.Logger) error {
 	vmName, err := vm.GetPropertyElementName()
 	if err != nil {
 		return fmt.Errorf("failed to get VM name: %w", err)
 	}
-	cmd := fmt.Sprintf("Add-VMNetworkAdapter -VMName \"%s\" -Name \"%s\" -SwitchName \"%s\"",
-		vmName, adapterName, switchName)
-	output, err := util.RunPowerShellCommand(cmd)
+	cmd := fmt.Sprintf("Add-VMNetworkAdapter -VMName %s -Name %s -SwitchName %s",
+		backend.Quote(vmName), backend.Quote(adapterName), backend.Quote(switchName))
+	output, err := util.RunPowerShellCommand(ctx, cmd)
 	if err != nil {
 		outputStr := string(output)
 
@@ -687,7 +749,7 @@ func addVirtualNetworkAdapterPowerShell(vm *virtualsystem.VirtualMachine, adapte
 
 // AddVirtualNetworkAdapterAndConnect adds a virtual network adapter to a virtual machine
 // and connects it to a virtual switch.
-func (v *VMMS) AddVirtualNetworkAdapterAndConnectApi(vm *virtualsystem.VirtualMachine, adapterName string, switchName string, logger logging.Logger) error {
+func (v *VMMS) AddVirtualNetworkAdapterAndConnectApi(ctx context.Context, vm *virtualsystem.VirtualMachine, adapterName string, switchName string, logger logging.Logger) error {
 	if v == nil {
 		return fmt.Errorf("VMMS object is nil")
 	}
@@ -737,14 +799,9 @@ func (v *VMMS) AddVirtualNetworkAdapterAndConnectApi(vm *virtualsystem.VirtualMa
 		return fmt.Errorf("unexpected result type from AddResourceSettings")
 	}
 
-	// Check return value
-	returnValue, ok := resultMap["ReturnValue"].(uint32)
-	if !ok {
-		return fmt.Errorf("unexpected ReturnValue type from AddResourceSettings")
-	}
-
-	if returnValue != 0 {
-		return fmt.Errorf("AddResourceSettings failed with error: %s", ErrorCodeMeaning(returnValue))
+	// Wait for the job the method may have started
+	if err := v.WaitForMethodResult(ctx, "AddResourceSettings", resultMap); err != nil {
+		return err
 	}
 
 	logger.Infof("[INFO] Successfully added network adapter %s to VM %s connected to switch %s", adapterName, vmName, switchName)